@@ -7,17 +7,7 @@ import (
 
 	"github.com/cicconee/clox/internal/api"
 	"github.com/cicconee/clox/internal/api/app"
-	"github.com/cicconee/clox/internal/cache"
-	"github.com/cicconee/clox/internal/cloudstore"
-	"github.com/cicconee/clox/internal/db"
-	"github.com/cicconee/clox/internal/jwt"
-	"github.com/cicconee/clox/internal/router"
-	"github.com/cicconee/clox/internal/server"
-	"github.com/cicconee/clox/internal/token"
-	"github.com/cicconee/clox/internal/user"
-	"github.com/cicconee/clox/pkg/env"
-
-	_ "github.com/lib/pq"
+	"github.com/cicconee/clox/internal/bootstrap"
 )
 
 var envFile = ".env"
@@ -32,72 +22,21 @@ func main() {
 }
 
 func Run(logger *log.Logger) error {
-	loader, err := env.NewFileLoader(envFile)
+	loader, err := bootstrap.Loader(envFile)
 	if err != nil {
-		return fmt.Errorf("creating file loader for file %s: %w", envFile, err)
+		return err
 	}
 
 	config, err := api.LoadConfig(loader)
 	if err != nil {
-		return fmt.Errorf("loading web configuration: %w", err)
-	}
-
-	database := &db.Postgres{}
-	if err := config.OpenDB(database); err != nil {
-		return fmt.Errorf("opening database: %w", err)
-	}
-	defer CloseDB(logger, database)
-
-	cache := &cache.Redis{}
-	if err := config.OpenCache(cache); err != nil {
-		return fmt.Errorf("opening cache: %w", err)
-	}
-	defer CloseCache(logger, cache)
-
-	jwts := jwt.NewManager("clox-server-side-app", "clox-api")
-	config.SetJWTSecret(jwts)
-
-	// Configure cloudstore dependencies.
-	cloudStorage := cloudstore.NewStore(database)
-	cloudPaths := cloudstore.NewPathMapper(config.FileStorePath)
-	cloudIO := cloudstore.NewIO(&cloudstore.OSFileSystem{}, cloudPaths)
-
-	// Configure cloudstore services.
-	dirs := cloudstore.NewDirService(cloudstore.DirServiceConfig{
-		Store:   cloudStorage,
-		IO:      cloudIO,
-		Log:     logger,
-		PathMap: cloudPaths,
-	})
-
-	files := cloudstore.NewFileService(cloudstore.FileServiceConfig{
-		Store:        cloudStorage,
-		IO:           cloudIO,
-		Log:          logger,
-		ValidateUser: dirs.ValidateUser,
-		PathMap:      cloudPaths,
-	})
-
-	webApp := &app.App{
-		Server:     server.New(config.Host, config.APIPort, router.NewChi()),
-		Logger:     logger,
-		Users:      user.NewService(user.NewRepo(database)),
-		Tokens:     token.NewService(jwts, cache, token.NewRepo(database)),
-		CloudDirs:  dirs,
-		CloudFiles: files,
+		return fmt.Errorf("loading api configuration: %w", err)
 	}
 
-	return webApp.Start()
-}
-
-func CloseDB(logger *log.Logger, database *db.Postgres) {
-	if err := database.Close(); err != nil {
-		logger.Printf("[ERROR] Closing the database connection: %v\n", err)
+	srv, cleanup, err := app.Open(config, logger)
+	if err != nil {
+		return err
 	}
-}
+	defer cleanup()
 
-func CloseCache(logger *log.Logger, cache *cache.Redis) {
-	if err := cache.Close(); err != nil {
-		logger.Printf("[ERROR] Closing the cache connection: %v\n", err)
-	}
+	return srv.Start()
 }
@@ -1,15 +1,32 @@
 package app
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"time"
 
+	"github.com/cicconee/clox/internal/api"
 	"github.com/cicconee/clox/internal/api/auth"
 	"github.com/cicconee/clox/internal/api/handler"
 	"github.com/cicconee/clox/internal/api/middleware"
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/audit"
+	"github.com/cicconee/clox/internal/bootstrap"
+	"github.com/cicconee/clox/internal/clientcert"
 	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/event"
+	"github.com/cicconee/clox/internal/hook"
+	"github.com/cicconee/clox/internal/operation"
+	"github.com/cicconee/clox/internal/pagination"
+	"github.com/cicconee/clox/internal/router"
+	"github.com/cicconee/clox/internal/security"
 	"github.com/cicconee/clox/internal/server"
 	"github.com/cicconee/clox/internal/token"
+	"github.com/cicconee/clox/internal/transfer"
 	"github.com/cicconee/clox/internal/user"
 )
 
@@ -21,49 +38,489 @@ type App struct {
 	Tokens     *token.Service
 	CloudDirs  *cloudstore.DirService
 	CloudFiles *cloudstore.FileService
+	Cursors    *pagination.Codec
+
+	// Backfill computes the content of files uploaded before it was recorded on upload.
+	Backfill *cloudstore.Backfill
+
+	// Faults forces the cloudstore services to fail at the cloudstore.FaultPoints. If nil, fault
+	// injection is disabled and the admin fault endpoints respond with 404.
+	Faults *cloudstore.Faults
+
+	// Hooks runs the callbacks registered on CloudHooks and TokenHooks. If nil, no callbacks
+	// are run.
+	Hooks *hook.Queue
+
+	// CloudHooks and TokenHooks are the Hooks passed to the cloudstore services and Tokens.
+	// Callbacks may be registered on them until NewServer is called.
+	CloudHooks *cloudstore.Hooks
+	TokenHooks *token.Hooks
+
+	// LogHooks registers the callbacks that log every hook point.
+	LogHooks bool
+
+	// StatementBudget is the number of database statements a request may execute before a warning is
+	// logged. If zero, statements are not counted.
+	StatementBudget int
+
+	// Security records the failed authentications of users. If nil, nothing is recorded.
+	Security *security.Recorder
+
+	// Operations records the multi-step storage operations of users. If nil, nothing is recorded.
+	Operations *operation.Service
+
+	// Transfers counts the bytes uploaded and downloaded by each token. If nil, nothing is counted.
+	Transfers *transfer.Stats
+
+	// AdminUsers are the usernames allowed to use the admin endpoints.
+	AdminUsers []string
+
+	// Events is the bus the outbox events are published to.
+	Events *event.Bus
+
+	// Dispatcher publishes the outbox to Events. If nil, the outbox is not published.
+	Dispatcher *event.Dispatcher
+
+	// AuditExporter exports the outbox as the audit log.
+	AuditExporter *audit.Exporter
+
+	// AuditPurger deletes the audit events older than the retention period. If nil, events are
+	// kept forever.
+	AuditPurger *audit.Purger
+
+	// ClientCerts are the client certificates registered for users.
+	ClientCerts *clientcert.Service
+
+	// TLSCertFile and TLSKeyFile are the certificate and key the API listens with. If empty, the API
+	// does not use TLS.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// ClientCAFile is the PEM bundle of the CAs client certificates are verified against. If set, requests
+	// with a verified client certificate registered in ClientCerts are authenticated as its user, and
+	// requests without one are authenticated by token. It requires TLSCertFile.
+	ClientCAFile string
+
+	// TrustedProxies are the proxies trusted to set the X-Forwarded-For header.
+	TrustedProxies []*net.IPNet
+
+	// AllowedOrigins are the origins allowed to send cross-origin requests. The allowed origins of the tokens
+	// are also allowed.
+	AllowedOrigins []string
+
+	// TokenOriginPolicy decides if a token with allowed origins may be used by a request without an Origin
+	// header. If empty, it defaults to token.OriginLenient.
+	TokenOriginPolicy token.OriginPolicy
+
+	// StreamIdleTimeout is the time an upload or download may go without moving any bytes before it is
+	// aborted. If zero, it defaults to server.DefaultStreamIdleTimeout.
+	StreamIdleTimeout time.Duration
+
+	// MinFreeBytes is the free space the root storage directory must have for the readiness endpoint to
+	// report ready, so load balancers stop sending uploads to a full server. If zero, free space is not
+	// checked.
+	MinFreeBytes int64
+
+	// WarmUpMode is the startup warm-up mode. It is one of app.WarmUpOff, app.WarmUpWarn, or
+	// app.WarmUpStrict. If empty, it defaults to app.WarmUpWarn.
+	WarmUpMode string
+
+	readiness app.Readiness
 
 	users       *handler.User
 	directories *handler.Directory
 	files       *handler.File
+	syncs       *handler.Sync
+	moves       *handler.Move
+	operations  *handler.Operation
+	transfers   *handler.Transfer
+	admin       *handler.Admin
+	certs       *handler.ClientCert
 
-	tokenMiddleware *middleware.Token
+	tokenMiddleware    *middleware.Token
+	corsMiddleware     *middleware.CORS
+	adminMiddleware    *middleware.Admin
+	transferMiddleware *middleware.Transfer
+	certMiddleware     *middleware.ClientCert
 }
 
-// init initializes and validates App. If any required fields in App are not defined an error is returned.
+// init initializes and validates App, and sets all of its routes. If any required fields in App are not defined, or
+// a route cannot be set, an error is returned.
 func (a *App) init() error {
-	if err := a.CloudDirs.SetupRoot(); err != nil {
-		return fmt.Errorf("setting up root storage directory: %w", err)
+	if a.LogHooks {
+		a.registerLogHooks()
 	}
 
-	authenticator := auth.NewAuthenticator(a.Tokens, a.Users)
+	if a.MinFreeBytes > 0 {
+		a.readiness.AddCheck("fs_free_space", func() error {
+			return a.CloudDirs.CheckFreeSpace(a.MinFreeBytes)
+		})
+	}
 
-	a.users = handler.NewUser(a.Users, a.Logger)
-	a.directories = handler.NewDirectory(a.CloudDirs, a.Logger)
-	a.files = handler.NewFile(a.CloudFiles, a.Logger)
+	// Client certificates are only accepted when the listener verifies them.
+	var certs *clientcert.Service
+	if a.TLSCertFile != "" {
+		var tlsConfig *tls.Config
+		if a.ClientCAFile != "" {
+			var err error
+			if tlsConfig, err = server.ClientCertTLS(a.ClientCAFile); err != nil {
+				return fmt.Errorf("configuring mutual TLS: %w", err)
+			}
+			certs = a.ClientCerts
+		}
+
+		a.Server.SetTLS(tlsConfig, a.TLSCertFile, a.TLSKeyFile)
+	}
+
+	authenticator := auth.NewAuthenticator(a.Tokens, a.Users, certs, a.TrustedProxies, a.Security)
+	authenticator.SetOriginPolicy(a.TokenOriginPolicy)
+
+	a.users = handler.NewUser(a.Users, a.CloudDirs, a.Security, a.Logger)
+	a.directories = handler.NewDirectory(a.CloudDirs, a.Cursors, a.Logger)
+	a.files = handler.NewFile(a.CloudFiles, a.CloudDirs, a.Operations, a.Logger)
+	a.syncs = handler.NewSync(cloudstore.NewSyncService(a.CloudDirs, a.CloudFiles, 0), a.Operations, a.Logger)
+	a.moves = handler.NewMove(cloudstore.NewMoveService(a.CloudDirs, a.CloudFiles), a.Logger)
+	a.operations = handler.NewOperation(a.Operations, a.Logger)
+	a.transfers = handler.NewTransfer(a.Transfers, a.Logger)
+	a.admin = handler.NewAdmin(a.Backfill, a.CloudDirs, a.Faults, a.Operations, a.AuditExporter, a.Logger)
+	a.certs = handler.NewClientCert(a.ClientCerts, a.Logger)
 
 	a.tokenMiddleware = middleware.NewToken(authenticator, a.Logger)
+	if certs != nil {
+		a.certMiddleware = middleware.NewClientCert(authenticator, a.tokenMiddleware, a.Logger)
+	}
+	a.corsMiddleware = middleware.NewCORS(a.AllowedOrigins, a.Tokens, a.Logger)
+	a.adminMiddleware = middleware.NewAdmin(a.Users, a.AdminUsers, a.Logger)
+	a.transferMiddleware = middleware.NewTransfer(a.Transfers)
+
+	a.setRoutes()
+	if err := a.Server.Err(); err != nil {
+		return fmt.Errorf("setting routes: %w", err)
+	}
 
 	return nil
 }
 
+// registerLogHooks registers the callbacks that log every hook point on CloudHooks and
+// TokenHooks.
+func (a *App) registerLogHooks() {
+	if a.CloudHooks != nil {
+		a.CloudHooks.Log(a.Logger)
+	}
+
+	if a.TokenHooks != nil {
+		a.TokenHooks.Log(a.Logger)
+	}
+}
+
 // setRoutes sets all the route handlers for App.
 func (a *App) setRoutes() {
-	a.Server.SetRoute("GET", "/me", a.users.Me(), a.tokenMiddleware.Validate)
-	a.Server.SetRoute("POST", "/api/dir/{id}", a.directories.New(), a.tokenMiddleware.Validate)
-	a.Server.SetRoute("POST", "/api/dir", a.directories.NewPath(), a.tokenMiddleware.Validate)
-	a.Server.SetRoute("POST", "/api/upload/{id}", a.files.Upload(), a.tokenMiddleware.Validate)
-	a.Server.SetRoute("POST", "/api/upload", a.files.UploadPath(), a.tokenMiddleware.Validate)
-	a.Server.SetRoute("GET", "/api/download/file/{id}", a.files.Download(), a.tokenMiddleware.Validate)
-	a.Server.SetRoute("GET", "/api/download/file", a.files.DownloadPath(), a.tokenMiddleware.Validate)
+	a.Server.Use(
+		server.Recover(a.Logger),
+		server.RequestInfo(a.TrustedProxies),
+		server.Format(app.FormatJSON),
+		server.Named("cors.Allow", a.corsMiddleware.Allow),
+		server.MaxQuery())
+
+	if a.StatementBudget > 0 {
+		a.Server.Use(server.StatementBudget(a.StatementBudget, a.Logger))
+	}
+
+	validate := server.Named("token.Validate", a.tokenMiddleware.Validate)
+	if a.certMiddleware != nil {
+		validate = server.Named("clientcert.Validate", a.certMiddleware.Validate)
+	}
+	admin := server.Named("admin.Require", a.adminMiddleware.Require)
+	stream := server.Stream(a.StreamIdleTimeout)
+	upload := server.Named("transfer.Upload", a.transferMiddleware.Upload)
+	download := server.Named("transfer.Download", a.transferMiddleware.Download)
+
+	a.setRoute(api.EndpointReady, a.readiness.Handler())
+	a.setRoute(api.EndpointMe, a.users.Me(), validate)
+	a.setRoute(api.EndpointMeHead, a.users.Me(), validate)
+	a.setRoute(api.EndpointUploadStats, a.users.UploadStats(), validate)
+	a.setRoute(api.EndpointTransferStats, a.transfers.Me(), validate)
+	a.setRoute(api.EndpointSecurityEvents, a.users.SecurityEvents(), validate)
+	a.setRoute(api.EndpointDirInfo, a.directories.Info(), validate)
+	a.setRoute(api.EndpointDirContents, a.directories.Contents(), validate)
+	a.setRoute(api.EndpointDirContentsPath, a.directories.ContentsPath(), validate)
+	a.setRoute(api.EndpointDirEntries, a.directories.Entries(), validate)
+	a.setRoute(api.EndpointNewDir, a.directories.New(), validate)
+	a.setRoute(api.EndpointNewDirPath, a.directories.NewPath(), validate)
+	a.setRoute(api.EndpointDeleteDir, a.directories.Delete(), validate)
+	a.setRoute(api.EndpointRenameDir, a.directories.Rename(), validate)
+	a.setRoute(api.EndpointMoveDir, a.directories.Move(), validate)
+	a.setRoute(api.EndpointInbox, a.directories.Inbox(), validate)
+	a.setRoute(api.EndpointSetInbox, a.directories.SetInbox(), validate)
+	a.setRoute(api.EndpointUploadInbox, a.files.UploadInbox(), stream, validate, upload)
+	a.setRoute(api.EndpointUpload, a.files.Upload(), stream, validate, upload)
+	a.setRoute(api.EndpointUploadPath, a.files.UploadPath(), stream, validate, upload)
+	a.setRoute(api.EndpointSyncApply, a.syncs.Apply(), stream, validate, upload)
+	a.setRoute(api.EndpointDownload, a.files.Download(), stream, validate, download)
+	a.setRoute(api.EndpointDownloadPath, a.files.DownloadPath(), stream, validate, download)
+	a.setRoute(api.EndpointPreview, a.files.Preview(), stream, validate, download)
+	a.setRoute(api.EndpointDeleteFile, a.files.Delete(), validate)
+	a.setRoute(api.EndpointRenameFile, a.files.Rename(), validate)
+	a.setRoute(api.EndpointMoveFile, a.files.Move(), validate)
+	a.setRoute(api.EndpointMove, a.moves.Apply(), validate)
+	a.setRoute(api.EndpointSearch, a.files.Search(), validate)
+	a.setRoute(api.EndpointOperation, a.operations.Get(), validate)
+	a.setRoute(api.EndpointOperations, a.operations.List(), validate)
+	a.setRoute(api.EndpointAdminBackfill, a.admin.Backfill(), validate, admin)
+	a.setRoute(api.EndpointAdminBackfillStart, a.admin.BackfillStart(), validate, admin)
+	a.setRoute(api.EndpointAdminDirLayout, a.admin.ConvertLayout(), validate, admin)
+	a.setRoute(api.EndpointAdminAuditExport, a.admin.AuditExport(), stream, validate, admin)
+	a.setRoute(api.EndpointAdminUserStorage, a.admin.UserStorage(), validate, admin)
+	a.setRoute(api.EndpointAdminUserStorageRepair, a.admin.UserStorageRepair(), validate, admin)
+	a.setRoute(api.EndpointAdminTransferStats, a.transfers.Users(), validate, admin)
+	a.setRoute(api.EndpointAdminTreeStats, a.admin.TreeStats(), validate, admin)
+	a.setRoute(api.EndpointAdminClientCerts, a.certs.List(), validate, admin)
+	a.setRoute(api.EndpointAdminClientCertAdd, a.certs.Register(), validate, admin)
+	a.setRoute(api.EndpointAdminClientCertRevoke, a.certs.Revoke(), validate, admin)
+	a.setRoute(api.EndpointAdminFaults, a.admin.Faults(), validate, admin)
+	a.setRoute(api.EndpointAdminArmFault, a.admin.ArmFault(), validate, admin)
+}
+
+// setRoute sets the handler for the endpoint.
+func (a *App) setRoute(e api.Endpoint, handler http.HandlerFunc, middlewares ...server.Middleware) {
+	a.Server.SetRoute(e.Method, e.Pattern, handler, middlewares...)
+}
+
+// warmUp runs the startup warm-up based on WarmUpMode. The readiness endpoint reports
+// not ready until the warm-up completes.
+//
+// In strict mode the warm-up runs before returning, and any failed probe is returned as
+// an error. Otherwise the warm-up runs in the background and failed probes are logged.
+func (a *App) warmUp() error {
+	switch a.WarmUpMode {
+	case app.WarmUpOff:
+		a.readiness.Complete(nil)
+		return nil
+	case app.WarmUpStrict:
+		probes := a.runWarmUp()
+		for _, p := range probes {
+			if p.Err != nil {
+				return fmt.Errorf("probe %s: %w", p.Name, p.Err)
+			}
+		}
+
+		a.readiness.Complete(probes)
+		return nil
+	default:
+		go func() {
+			a.readiness.Complete(a.runWarmUp())
+		}()
+
+		return nil
+	}
+}
+
+// runWarmUp probes the file store and database and logs the result of every probe.
+func (a *App) runWarmUp() []app.Probe {
+	probes := a.CloudDirs.WarmUp(context.Background())
+	for _, p := range probes {
+		if p.Err != nil {
+			a.Logger.Printf("[WARN] Warm-up probe %s failed after %s: %v\n", p.Name, p.Duration, p.Err)
+		} else {
+			a.Logger.Printf("[INFO] Warm-up probe %s took %s\n", p.Name, p.Duration)
+		}
+	}
+
+	return probes
+}
+
+// cleanup retries removing the paths that previously failed to be removed from the file
+// system and logs the result.
+func (a *App) cleanup() {
+	removed, remaining, err := a.CloudDirs.Cleanup(context.Background())
+	if err != nil {
+		a.Logger.Printf("[ERROR] Cleaning up file system: %v\n", err)
+		return
+	}
+
+	if removed > 0 || remaining > 0 {
+		a.Logger.Printf("[INFO] Cleaned up file system [removed: %d, remaining: %d]\n", removed, remaining)
+	}
+}
+
+// resolvePending resolves the files left pending by strict mode uploads every
+// cloudstore.PendingGrace until ctx is done, and logs the result.
+func (a *App) resolvePending(ctx context.Context) {
+	ticker := time.NewTicker(cloudstore.PendingGrace)
+	defer ticker.Stop()
+
+	for {
+		promoted, deleted, err := a.CloudFiles.ResolvePending(ctx)
+		if err != nil {
+			a.Logger.Printf("[ERROR] Resolving pending files: %v\n", err)
+		} else if promoted > 0 || deleted > 0 {
+			a.Logger.Printf("[INFO] Resolved pending files [promoted: %d, deleted: %d]\n", promoted, deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
-// Start will initialize, set all the routes, and start App.
-func (a *App) Start() error {
+// reportTrees computes the cloudstore tree report every cloudstore.TreeReportInterval
+// until ctx is done, which publishes the "cloudstore_tree" metrics, and logs the
+// users whose directory trees are above the thresholds.
+func (a *App) reportTrees(ctx context.Context) {
+	ticker := time.NewTicker(cloudstore.TreeReportInterval)
+	defer ticker.Stop()
+
+	for {
+		report, err := a.CloudDirs.TreeReport(ctx, 0)
+		if err != nil {
+			a.Logger.Printf("[ERROR] Reporting directory trees: %v\n", err)
+		} else {
+			for _, u := range report.Users {
+				if u.Flagged {
+					a.Logger.Printf("[WARN] Directory tree above thresholds [user: %s, max_depth: %d, closure_rows: %d]\n", u.UserID, u.MaxDepth, u.ClosureRows)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Open opens the shared services of config with bootstrap.Open and builds the server of the
+// API on them with NewServer. The returned func stops the background work and closes
+// the services.
+func Open(config *api.Config, logger *log.Logger) (*server.HTTP, func(), error) {
+	s, err := bootstrap.Open(config.Config, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srv, stop, err := NewServer(config, s)
+	if err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+
+	return srv, func() {
+		stop()
+		s.Close()
+	}, nil
+}
+
+// NewServer builds the App of the API from config on the shared services s, initializes it,
+// and starts its background work. The returned server.HTTP serves the API, with Start or as a
+// http.Handler, and the returned func stops the background work. It does not close s.
+func NewServer(config *api.Config, s *bootstrap.Services) (*server.HTTP, func(), error) {
+	a := New(config, s)
+
+	ctx, stop := context.WithCancel(context.Background())
+	if err := a.run(ctx); err != nil {
+		stop()
+		return nil, nil, err
+	}
+
+	return a.Server, stop, nil
+}
+
+// New builds the App of the API from config on the shared services s. The App is not
+// initialized.
+func New(config *api.Config, s *bootstrap.Services) *App {
+	// Publish the outbox to the in-process event bus.
+	events := event.NewBus()
+
+	// The outbox is also the audit log. Export manifests are signed with a key derived from the JWT secret.
+	auditExporter := audit.NewExporter(event.NewRepo(s.DB))
+	config.SetJWTSecret(auditExporter)
+
+	return &App{
+		Server:     server.New(config.Host, config.APIPort, router.NewChi()),
+		Logger:     s.Logger,
+		Users:      s.Users,
+		Tokens:     s.Tokens,
+		CloudDirs:  s.CloudDirs,
+		CloudFiles: s.CloudFiles,
+		Faults:     s.Faults,
+		Cursors:    s.Cursors,
+		Events:     events,
+		Security:   s.Security,
+		Operations: operation.NewService(operation.NewRepo(s.DB), s.Logger),
+		Transfers:  transfer.NewStats(s.Cache, transfer.NewRepo(s.DB), s.Logger),
+		Hooks:      s.Hooks,
+		CloudHooks: s.CloudHooks,
+		TokenHooks: s.TokenHooks,
+		Backfill: cloudstore.NewBackfill(cloudstore.BackfillConfig{
+			Store:          s.CloudStorage,
+			IO:             s.CloudIO,
+			PathMap:        s.CloudPaths,
+			Log:            s.Logger,
+			Concurrency:    config.BackfillConcurrency,
+			BytesPerSecond: config.BackfillBytesPerSecond,
+		}),
+		Dispatcher: event.NewDispatcher(event.DispatcherConfig{
+			Repo:      event.NewRepo(s.DB),
+			Publisher: events,
+			Log:       s.Logger,
+		}),
+		AuditExporter: auditExporter,
+		AuditPurger:   audit.NewPurger(event.NewRepo(s.DB), config.AuditRetentionMonths, s.Logger),
+
+		ClientCerts:  clientcert.NewService(clientcert.NewRepo(s.DB)),
+		TLSCertFile:  config.TLSCertFile,
+		TLSKeyFile:   config.TLSKeyFile,
+		ClientCAFile: config.ClientCAFile,
+
+		TrustedProxies:    config.TrustedProxies,
+		AllowedOrigins:    config.CORSAllowedOrigins,
+		TokenOriginPolicy: config.TokenOriginPolicy,
+		AdminUsers:        config.AdminUsers,
+		WarmUpMode:        config.WarmUpMode,
+		MinFreeBytes:      config.MinFreeBytes,
+		LogHooks:          config.LogHooks,
+		StatementBudget:   config.StatementBudget,
+
+		StreamIdleTimeout: config.StreamIdleTimeout,
+	}
+}
+
+// run initializes App, warms it up, and starts its background work until ctx is done.
+func (a *App) run(ctx context.Context) error {
 	if err := a.init(); err != nil {
 		return fmt.Errorf("initializing App: %w", err)
 	}
 
-	a.setRoutes()
+	if err := a.warmUp(); err != nil {
+		return fmt.Errorf("warming up: %w", err)
+	}
 
-	return a.Server.Start()
+	go a.cleanup()
+	go a.resolvePending(ctx)
+	go a.reportTrees(ctx)
+
+	if a.Dispatcher != nil {
+		go a.Dispatcher.Run(ctx)
+	}
+
+	if a.Security != nil {
+		go a.Security.Run(ctx)
+	}
+
+	if a.Hooks != nil {
+		go a.Hooks.Run(ctx)
+	}
+
+	if a.AuditPurger != nil {
+		go a.AuditPurger.Run(ctx)
+	}
+
+	if a.Transfers != nil {
+		go a.Transfers.Run(ctx)
+	}
+
+	return nil
 }
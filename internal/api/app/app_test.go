@@ -0,0 +1,242 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/api"
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/bootstrap"
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/db"
+	"github.com/cicconee/clox/internal/router"
+	"github.com/cicconee/clox/internal/server"
+)
+
+// newTestApp initializes a with every route set. The cloudstore services use a database
+// that is never opened, the routes are only introspected, never served.
+func newTestApp(t *testing.T, a *App) *App {
+	t.Helper()
+
+	store := cloudstore.NewStore(&db.Postgres{})
+	paths := cloudstore.NewPathMapper(t.TempDir())
+
+	a.Server = server.New("localhost", "0", router.NewChi())
+	a.Logger = log.New(io.Discard, "", 0)
+	a.CloudDirs = cloudstore.NewDirService(cloudstore.DirServiceConfig{Store: store, PathMap: paths, Log: a.Logger})
+	a.CloudFiles = cloudstore.NewFileService(cloudstore.FileServiceConfig{
+		Store:   store,
+		PathMap: paths,
+		Log:     a.Logger,
+		ValidateUser: func(ctx context.Context, userID string) (cloudstore.Dir, error) {
+			return cloudstore.Dir{}, nil
+		},
+	})
+
+	if err := a.init(); err != nil {
+		t.Fatalf("init() error = %v", err)
+	}
+
+	return a
+}
+
+// chains returns the middleware chain of every route of a, keyed by method and pattern.
+func chains(a *App) map[string][]string {
+	m := map[string][]string{}
+	for _, r := range a.Server.Routes() {
+		m[r.Method+" "+r.Pattern] = r.Middlewares
+	}
+
+	return m
+}
+
+var globalChain = []string{
+	"server.Recover",
+	"server.RequestInfo",
+	"server.Format(json)",
+	"cors.Allow",
+	"server.MaxQuery",
+}
+
+func TestRouteMiddlewareOrder(t *testing.T) {
+	routes := chains(newTestApp(t, &App{}))
+
+	validate := []string{"token.Validate"}
+	admin := []string{"token.Validate", "admin.Require"}
+	upload := []string{"server.Stream", "token.Validate", "transfer.Upload"}
+	download := []string{"server.Stream", "token.Validate", "transfer.Download"}
+
+	tests := []struct {
+		endpoint api.Endpoint
+		want     []string
+	}{
+		{api.EndpointReady, nil},
+		{api.EndpointMe, validate},
+		{api.EndpointMeHead, validate},
+		{api.EndpointDirContents, validate},
+		{api.EndpointDeleteDir, validate},
+		{api.EndpointMoveDir, validate},
+		{api.EndpointDeleteFile, validate},
+		{api.EndpointMoveFile, validate},
+		{api.EndpointMove, validate},
+		{api.EndpointUpload, upload},
+		{api.EndpointUploadPath, upload},
+		{api.EndpointUploadInbox, upload},
+		{api.EndpointSyncApply, upload},
+		{api.EndpointDownload, download},
+		{api.EndpointDownloadPath, download},
+		{api.EndpointPreview, download},
+		{api.EndpointAdminBackfillStart, admin},
+		{api.EndpointAdminUserStorageRepair, admin},
+		{api.EndpointAdminClientCertAdd, admin},
+		{api.EndpointAdminClientCertRevoke, admin},
+		{api.EndpointAdminArmFault, admin},
+		{api.EndpointAdminAuditExport, []string{"server.Stream", "token.Validate", "admin.Require"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.endpoint.Method+" "+tc.endpoint.Pattern, func(t *testing.T) {
+			got, ok := routes[tc.endpoint.Method+" "+tc.endpoint.Pattern]
+			if !ok {
+				t.Fatalf("route is not set")
+			}
+
+			want := append(append([]string{}, globalChain...), tc.want...)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("middlewares = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoutesAuthenticated(t *testing.T) {
+	for key, got := range chains(newTestApp(t, &App{})) {
+		if key == api.EndpointReady.Method+" "+api.EndpointReady.Pattern {
+			continue
+		}
+
+		route := got[len(globalChain):]
+		validate := indexOf(route, "token.Validate")
+		if validate < 0 {
+			t.Errorf("%s: middlewares = %v, want token.Validate", key, got)
+			continue
+		}
+
+		// Every middleware that reads the principal must run inside token.Validate.
+		for _, name := range []string{"admin.Require", "transfer.Upload", "transfer.Download"} {
+			if i := indexOf(route, name); i >= 0 && i < validate {
+				t.Errorf("%s: %s wraps token.Validate in %v", key, name, got)
+			}
+		}
+
+		if strings.Contains(key, " /api/admin/") {
+			if indexOf(route, "admin.Require") != validate+1 {
+				t.Errorf("%s: middlewares = %v, want admin.Require right inside token.Validate", key, got)
+			}
+		}
+	}
+}
+
+func TestGlobalStatementBudget(t *testing.T) {
+	routes := chains(newTestApp(t, &App{StatementBudget: 10}))
+
+	got := routes[api.EndpointMe.Method+" "+api.EndpointMe.Pattern]
+	want := append(append([]string{}, globalChain...), "server.StatementBudget(10)", "token.Validate")
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("middlewares = %v, want %v", got, want)
+	}
+}
+
+func TestSetRouteDuplicate(t *testing.T) {
+	a := newTestApp(t, &App{})
+	a.setRoute(api.EndpointMe, func(w http.ResponseWriter, r *http.Request) {})
+
+	err := a.Server.Err()
+	if !errors.Is(err, server.ErrDuplicateRoute) {
+		t.Fatalf("Err() = %v, want server.ErrDuplicateRoute", err)
+	}
+
+	// setRoute is skipped, the error names the lines that called it.
+	for _, want := range []string{"app_test.go:", "/internal/api/app/app.go:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Err() = %q, want it to name %s", err, want)
+		}
+	}
+}
+
+// indexOf returns the index of name in names, or -1 if it is not in names.
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// newTestServices builds the shared services on a database and cache that are never
+// connected to, and a file store in a temporary directory.
+func newTestServices(t *testing.T, config *app.Config) *bootstrap.Services {
+	t.Helper()
+
+	pg := &db.Postgres{}
+	if err := pg.Open("127.0.0.1", "1", "clox", "clox", "clox"); err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+
+	redis := &cache.Redis{}
+	redis.Open("127.0.0.1", "1", "", "")
+
+	s, err := bootstrap.New(config, pg, redis, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("bootstrap.New() error = %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestNewServer(t *testing.T) {
+	config := &api.Config{
+		Config: &app.Config{
+			Host:            "localhost",
+			FileStorePath:   t.TempDir(),
+			WarmUpMode:      app.WarmUpOff,
+			DisplayLocation: time.UTC,
+		},
+		APIPort: "0",
+	}
+
+	srv, stop, err := NewServer(config, newTestServices(t, config.Config))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer stop()
+
+	tests := []struct {
+		endpoint api.Endpoint
+		want     int
+	}{
+		{api.EndpointReady, http.StatusOK},
+		{api.EndpointMe, http.StatusUnauthorized},
+		{api.EndpointMeHead, http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(tc.endpoint.Method, tc.endpoint.Pattern, nil))
+
+		if w.Code != tc.want {
+			t.Errorf("%s %s status = %d, want %d", tc.endpoint.Method, tc.endpoint.Pattern, w.Code, tc.want)
+		}
+	}
+}
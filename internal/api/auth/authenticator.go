@@ -1,40 +1,63 @@
 package auth
 
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 
 	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/clientcert"
+	"github.com/cicconee/clox/internal/security"
 	"github.com/cicconee/clox/internal/token"
 	"github.com/cicconee/clox/internal/user"
 	"golang.org/x/net/context"
 )
 
-// Authenticator authenticates API tokens for the Clox API.
+// Authenticator authenticates API tokens and client certificates for the Clox API.
 type Authenticator struct {
-	tokens *token.Service
-	users  *user.Service
+	tokens         *token.Service
+	users          *user.Service
+	certs          *clientcert.Service
+	trustedProxies []*net.IPNet
+	security       *security.Recorder
+	originPolicy   token.OriginPolicy
 }
 
-// NewAuthenticator creates a new Authenticator.
-func NewAuthenticator(tokens *token.Service, users *user.Service) *Authenticator {
-	return &Authenticator{tokens: tokens, users: users}
+// NewAuthenticator creates a new Authenticator. The trustedProxies are the proxies that are
+// trusted to set the X-Forwarded-For header when determining the client IP of a request.
+//
+// The failed authentications of a known user are recorded with recorder. If recorder is nil,
+// nothing is recorded.
+//
+// The client certificates of requests are authenticated with certs. If certs is nil, client
+// certificates are not accepted.
+func NewAuthenticator(tokens *token.Service, users *user.Service, certs *clientcert.Service, trustedProxies []*net.IPNet, recorder *security.Recorder) *Authenticator {
+	return &Authenticator{tokens: tokens, users: users, certs: certs, trustedProxies: trustedProxies, security: recorder}
 }
 
-// Authenticate validates token.
-func (a *Authenticator) Authenticate(ctx context.Context, token string) (string, error) {
-	return a.authenticate(ctx, token)
+// SetOriginPolicy sets the token.OriginPolicy of the tokens with allowed origins presented
+// without an Origin header. If it is not set, token.OriginLenient is used. It should be called
+// before the Authenticator is used.
+func (a *Authenticator) SetOriginPolicy(policy token.OriginPolicy) {
+	a.originPolicy = policy
+}
+
+// Authenticate validates apiToken when presented by the client at clientIP. The request is
+// treated as a request without an Origin header.
+func (a *Authenticator) Authenticate(ctx context.Context, apiToken string, clientIP net.IP) (token.Principal, error) {
+	return a.authenticate(ctx, apiToken, clientIP, "")
 }
 
 // AuthenticateRequest extracts a Bearer token from the http.Request Authorization header
 // and then validates the token.
-func (a *Authenticator) AuthenticateRequest(r *http.Request) (string, error) {
+func (a *Authenticator) AuthenticateRequest(r *http.Request) (token.Principal, error) {
 	authHeader := r.Header.Get("Authorization")
 
 	if authHeader == "" {
-		return "", app.Wrap(app.WrapParams{
+		return token.Principal{}, app.Wrap(app.WrapParams{
 			Err:         errors.New("empty api token"),
 			SafeMessage: "No Authorization header provided",
 			StatusCode:  http.StatusUnauthorized,
@@ -42,43 +65,141 @@ func (a *Authenticator) AuthenticateRequest(r *http.Request) (string, error) {
 	}
 
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return "", app.Wrap(app.WrapParams{
+		return token.Principal{}, app.Wrap(app.WrapParams{
 			Err:         errors.New("invalid api token format"),
 			SafeMessage: "Invalid Authorization header format",
 			StatusCode:  http.StatusUnauthorized,
 		})
 	}
 
-	return a.authenticate(r.Context(), strings.TrimPrefix(authHeader, "Bearer "))
+	clientIP := app.ClientIP(r, a.trustedProxies)
+
+	principal, err := a.authenticate(r.Context(), strings.TrimPrefix(authHeader, "Bearer "), clientIP, r.Header.Get("Origin"))
+	if err != nil {
+		a.record(r, clientIP, err)
+		return token.Principal{}, err
+	}
+
+	return principal, nil
+}
+
+// ClientCert returns the verified client certificate of r. If the request was not made over
+// mutual TLS, or client certificates are not accepted, ok is false.
+func (a *Authenticator) ClientCert(r *http.Request) (cert *x509.Certificate, ok bool) {
+	if a.certs == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	return r.TLS.VerifiedChains[0][0], true
 }
 
-// authenticate validates token and ensures user is not blocked.
-func (a *Authenticator) authenticate(ctx context.Context, token string) (string, error) {
-	uid, err := a.tokens.Validate(ctx, token)
+// AuthenticateCert authenticates the verified client certificate cert of r. The certificate
+// must be registered for a user, see clientcert.Service.Register.
+//
+// The token.Principal of a certificate has the ID and name of the registered certificate as
+// its token ID and name, and the certificate expiration as its token expiration, so handlers
+// and the transfer stats treat it like a token.
+func (a *Authenticator) AuthenticateCert(r *http.Request, cert *x509.Certificate) (token.Principal, error) {
+	clientIP := app.ClientIP(r, a.trustedProxies)
+
+	c, err := a.certs.Authenticate(r.Context(), cert)
 	if err != nil {
-		return "", fmt.Errorf("validating token: %w", err)
+		return token.Principal{}, fmt.Errorf("authenticating client certificate: %w", err)
+	}
+
+	principal := token.Principal{
+		UserID: c.UserID,
+		Token: token.Listing{
+			TokenID:   c.ID,
+			TokenName: c.Name,
+			ExpiresAt: c.NotAfter,
+			IssuedAt:  c.CreatedAt,
+			LastUsed:  c.LastUsed,
+		},
+	}
+
+	if err := a.checkUser(r.Context(), principal.UserID); err != nil {
+		a.record(r, clientIP, err)
+		return token.Principal{}, err
 	}
 
-	u, err := a.users.Get(ctx, uid)
+	return principal, nil
+}
+
+// blockedError is the error of a valid token of a blocked user.
+type blockedError struct {
+	userID string
+}
+
+func (e *blockedError) Error() string {
+	return fmt.Sprintf("blocked user [id: %s]", e.userID)
+}
+
+// record records err as a security event if it is the failure of a known user.
+func (a *Authenticator) record(r *http.Request, clientIP net.IP, err error) {
+	var rejected *token.RejectedError
+	if errors.As(err, &rejected) {
+		a.security.Record(security.NewEvent(r, clientIP, rejected.UserID, security.TypeTokenRejected, rejected.Reason))
+		return
+	}
+
+	var blocked *blockedError
+	if errors.As(err, &blocked) {
+		a.security.Record(security.NewEvent(r, clientIP, blocked.userID, security.TypeTokenBlocked, "valid token used while the account is blocked"))
+	}
+}
+
+// authenticate validates apiToken and ensures user exists and is not blocked. The origin is the
+// Origin header of the request, or empty if it has none.
+func (a *Authenticator) authenticate(ctx context.Context, apiToken string, clientIP net.IP, origin string) (token.Principal, error) {
+	principal, err := a.tokens.Validate(ctx, token.ValidateParams{
+		Token:        apiToken,
+		ClientIP:     clientIP,
+		Origin:       origin,
+		OriginPolicy: a.originPolicy,
+	})
+	if err != nil {
+		return token.Principal{}, fmt.Errorf("validating token: %w", err)
+	}
+
+	if err := a.checkUser(ctx, principal.UserID); err != nil {
+		return token.Principal{}, err
+	}
+
+	return principal, nil
+}
+
+// checkUser ensures the user of a valid credential exists and is not blocked.
+func (a *Authenticator) checkUser(ctx context.Context, userID string) error {
+	u, err := a.users.Get(ctx, userID)
 	if err != nil {
-		return "", fmt.Errorf("getting user: %w", err)
+		// A credential that outlived its user is not a valid credential, it is not a missing resource.
+		if errors.Is(err, user.ErrUserNotFound) {
+			return app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("getting user: %w", err),
+				SafeMessage: "Your account no longer exists",
+				StatusCode:  http.StatusUnauthorized,
+			})
+		}
+
+		return fmt.Errorf("getting user: %w", err)
 	}
 
 	if !u.ValidRegistration() {
 		if u.RegistrationStatus == user.Blocked {
-			return "", app.Wrap(app.WrapParams{
-				Err:         errors.New("blocked user"),
+			return app.Wrap(app.WrapParams{
+				Err:         &blockedError{userID: u.ID},
 				SafeMessage: "Your account is blocked. Please contact us.",
 				StatusCode:  http.StatusUnauthorized,
 			})
 		}
 
-		return "", app.Wrap(app.WrapParams{
+		return app.Wrap(app.WrapParams{
 			Err:         fmt.Errorf("unsupported registraton status: %v", u.RegistrationStatus),
 			SafeMessage: "Something is wrong with you account. Please contact us.",
 			StatusCode:  http.StatusUnauthorized,
 		})
 	}
 
-	return uid, nil
+	return nil
 }
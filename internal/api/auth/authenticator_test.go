@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/security"
+	"github.com/cicconee/clox/internal/token"
+	"github.com/cicconee/clox/internal/user"
+	"github.com/google/uuid"
+)
+
+func TestAuthenticatorRecord(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	userID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	recorder := security.NewRecorder(security.NewRepo(p), 0, log.New(io.Discard, "", 0))
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		recorder.Run(runCtx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	a := NewAuthenticator(nil, nil, nil, nil, recorder)
+	r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	r.Header.Set("User-Agent", "clox-cli/1.0")
+	ip := net.ParseIP("203.0.113.9")
+
+	// Failures that cannot be attributed to a user are not recorded.
+	a.record(r, ip, errors.New("token expired"))
+	a.record(r, ip, fmt.Errorf("validating token: %w", &token.RejectedError{UserID: userID, Reason: "token revoked"}))
+	a.record(r, ip, &blockedError{userID: userID})
+
+	var events []security.Event
+	for deadline := time.Now().Add(5 * time.Second); len(events) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("recorded events = %d, want 2", len(events))
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		events, err = recorder.Recent(ctx, userID, 0)
+		if err != nil {
+			t.Fatalf("Recent() error = %v", err)
+		}
+	}
+
+	types := map[string]security.Event{}
+	for _, e := range events {
+		types[e.Type] = e
+	}
+
+	rejected, ok := types[security.TypeTokenRejected]
+	if !ok || rejected.Detail != "token revoked" || rejected.IP != "203.0.113.9" || rejected.UserAgent != "clox-cli/1.0" {
+		t.Errorf("rejected token event = %+v, want the reason, client IP, and user agent", rejected)
+	}
+
+	if _, ok := types[security.TypeTokenBlocked]; !ok {
+		t.Errorf("events = %+v, want a blocked user event", events)
+	}
+}
+
+// TestAuthenticatorDeletedUser checks a valid credential of a user that no longer exists is
+// rejected as unauthorized. The tokens of a user are deleted with the user, so this happens
+// when the user is deleted after the token is validated and before the user is read.
+func TestAuthenticatorDeletedUser(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	a := NewAuthenticator(nil, user.NewService(user.NewRepo(p)), nil, nil, nil)
+	err := a.checkUser(ctx, uuid.NewString())
+	if !errors.Is(err, user.ErrUserNotFound) {
+		t.Fatalf("checkUser() error = %v, want a ErrUserNotFound", err)
+	}
+
+	var safeErr *app.WrappedSafeError
+	if !errors.As(err, &safeErr) {
+		t.Fatalf("checkUser() error = %v, want a app.WrappedSafeError", err)
+	}
+
+	// The user.Service error is a 404, the outermost safe error must be the 401.
+	msg, status := safeErr.Safe()
+	if status != http.StatusUnauthorized || msg != "Your account no longer exists" {
+		t.Errorf("checkUser() safe error = %d %q, want 401 %q", status, msg, "Your account no longer exists")
+	}
+
+	rec := httptest.NewRecorder()
+	app.WriteJSONError(rec, fmt.Errorf("authenticating: %w", err))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("response status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -1,25 +1,74 @@
 package auth
 
-import "context"
+import (
+	"context"
+	"errors"
+	"net/http"
 
-type contextKey string
-
-var (
-	userIDContextKey contextKey = "user_id"
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/reqinfo"
+	"github.com/cicconee/clox/internal/token"
 )
 
+// PrincipalKey is the reqinfo.Key of the token.Principal that authenticated the request.
+var PrincipalKey = reqinfo.NewKey[token.Principal]("auth.principal")
+
+// WithPrincipal sets a token.Principal and its user and token IDs in the reqinfo.Info of the
+// context.
+func WithPrincipal(ctx context.Context, p token.Principal) context.Context {
+	ctx = reqinfo.SetToken(ctx, p.UserID, p.Token.TokenID)
+	return reqinfo.Set(ctx, PrincipalKey, p)
+}
+
 // SetUserIDContext sets a user ID in the context. User ID can only be retrieved
-// using the GetUserIDContext.
+// using the LookupUserIDContext or MustUserID.
+//
+// Deprecated: Use reqinfo.SetUser.
 func SetUserIDContext(ctx context.Context, userID string) context.Context {
-	return context.WithValue(ctx, userIDContextKey, userID)
+	return reqinfo.SetUser(ctx, userID)
+}
+
+// SetPrincipalContext sets a token.Principal in the context. The principals user ID is
+// also set, and can be retrieved using LookupUserIDContext or MustUserID.
+//
+// Deprecated: Use WithPrincipal.
+func SetPrincipalContext(ctx context.Context, p token.Principal) context.Context {
+	return WithPrincipal(ctx, p)
+}
+
+// LookupPrincipalContext gets the token.Principal from the context. If a principal is not
+// set, ok is false.
+//
+// Deprecated: Use reqinfo.Get with PrincipalKey.
+func LookupPrincipalContext(ctx context.Context) (p token.Principal, ok bool) {
+	return reqinfo.Get(ctx, PrincipalKey)
+}
+
+// LookupUserIDContext gets the user ID from the context. If a user ID is not set
+// or is empty, ok is false.
+//
+// Deprecated: Use reqinfo.From.
+func LookupUserIDContext(ctx context.Context) (userID string, ok bool) {
+	userID = reqinfo.From(ctx).UserID
+	return userID, userID != ""
 }
 
-// GetUserIDContext gets the user ID from the context.
-func GetUserIDContext(ctx context.Context) string {
-	userID, ok := ctx.Value(userIDContextKey).(string)
+// MustUserID gets the user ID from the request context. If a user ID is not set,
+// a 401 JSON error is written to w and ok is false. Handlers should return
+// immediately when ok is false.
+//
+// A user ID will not be set if the handler was not wrapped with a middleware that
+// authenticates the request, such as middleware.Token.Validate.
+func MustUserID(w http.ResponseWriter, r *http.Request) (userID string, ok bool) {
+	userID = reqinfo.From(r.Context()).UserID
+	ok = userID != ""
 	if !ok {
-		return ""
+		app.WriteJSONError(w, app.Wrap(app.WrapParams{
+			Err:         errors.New("user id not set in request context"),
+			SafeMessage: "Unauthorized",
+			StatusCode:  http.StatusUnauthorized,
+		}))
 	}
 
-	return userID
+	return userID, ok
 }
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/cicconee/clox/internal/reqinfo"
+	"github.com/cicconee/clox/internal/token"
+)
+
+func TestMustUserID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+
+	w := httptest.NewRecorder()
+	if userID, ok := MustUserID(w, r); ok || userID != "" {
+		t.Errorf("MustUserID() without a principal = %q, %v, want \"\", false", userID, ok)
+	}
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	r = r.WithContext(WithPrincipal(r.Context(), token.Principal{UserID: "user", Token: token.Listing{TokenID: "token"}}))
+
+	w = httptest.NewRecorder()
+	if userID, ok := MustUserID(w, r); !ok || userID != "user" {
+		t.Errorf("MustUserID() = %q, %v, want \"user\", true", userID, ok)
+	}
+
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %s, want nothing written", w.Body)
+	}
+}
+
+func TestDeprecatedContext(t *testing.T) {
+	ctx := context.Background()
+
+	if userID, ok := LookupUserIDContext(ctx); ok || userID != "" {
+		t.Errorf("LookupUserIDContext() = %q, %v, want \"\", false", userID, ok)
+	}
+
+	if p, ok := LookupPrincipalContext(ctx); ok || !reflect.DeepEqual(p, token.Principal{}) {
+		t.Errorf("LookupPrincipalContext() = %+v, %v, want the zero token.Principal, false", p, ok)
+	}
+
+	// A user ID alone does not set a principal.
+	userCtx := SetUserIDContext(ctx, "user")
+	if userID, ok := LookupUserIDContext(userCtx); !ok || userID != "user" {
+		t.Errorf("LookupUserIDContext() = %q, %v, want \"user\", true", userID, ok)
+	}
+
+	if _, ok := LookupPrincipalContext(userCtx); ok {
+		t.Error("LookupPrincipalContext() after SetUserIDContext ok = true, want false")
+	}
+
+	// The principal replaces the user ID set before it.
+	principal := token.Principal{UserID: "principal", Token: token.Listing{TokenID: "token"}}
+	principalCtx := SetPrincipalContext(userCtx, principal)
+	if p, ok := LookupPrincipalContext(principalCtx); !ok || !reflect.DeepEqual(p, principal) {
+		t.Errorf("LookupPrincipalContext() = %+v, %v, want %+v, true", p, ok, principal)
+	}
+
+	if userID, _ := LookupUserIDContext(principalCtx); userID != "principal" {
+		t.Errorf("LookupUserIDContext() after SetPrincipalContext = %q, want \"principal\"", userID)
+	}
+
+	if info := reqinfo.From(principalCtx); info.TokenID != "token" {
+		t.Errorf("token ID = %q, want \"token\"", info.TokenID)
+	}
+
+	// An empty user ID is not set.
+	if _, ok := LookupUserIDContext(SetUserIDContext(ctx, "")); ok {
+		t.Error("LookupUserIDContext() of a empty user ID ok = true, want false")
+	}
+}
@@ -1,10 +1,15 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/audit"
+	"github.com/cicconee/clox/internal/token"
 	"github.com/cicconee/clox/pkg/env"
 )
 
@@ -12,6 +17,45 @@ import (
 type Config struct {
 	*app.Config
 	APIPort string
+
+	// CORSAllowedOrigins are the origins allowed to send cross-origin requests, such as the server side app
+	// running the request console. Set with the CORS_ALLOWED_ORIGINS environment variable as a comma separated list.
+	CORSAllowedOrigins []string
+
+	// TokenOriginPolicy decides if a token with allowed origins may be used by a request without an Origin header.
+	// Set with the TOKEN_ORIGIN_POLICY environment variable, "lenient" or "strict". Defaults to "lenient".
+	TokenOriginPolicy token.OriginPolicy
+
+	// BackfillConcurrency is the number of files the backfill processes at the same time. Set with the
+	// BACKFILL_CONCURRENCY environment variable. If zero, cloudstore.DefaultBackfillConcurrency is used.
+	BackfillConcurrency int
+
+	// BackfillBytesPerSecond is the maximum number of bytes the backfill reads from the file store per second. Set
+	// with the BACKFILL_MAX_BYTES_PER_SECOND environment variable as a size, such as "10MB". If zero, reads are not
+	// throttled.
+	BackfillBytesPerSecond int64
+
+	// StreamIdleTimeout is the time an upload or download may go without moving any bytes before it is aborted.
+	// Set with the STREAM_IDLE_TIMEOUT environment variable as a duration, such as "1m". If zero,
+	// server.DefaultStreamIdleTimeout is used.
+	StreamIdleTimeout time.Duration
+
+	// AuditRetentionMonths is the number of calendar months audit events are kept for. Set with the
+	// AUDIT_RETENTION_MONTHS environment variable. If zero, events are kept forever.
+	AuditRetentionMonths int
+
+	// MinFreeBytes is the free space the root storage directory must have for the API to report ready. Set with
+	// the STORAGE_MIN_FREE_BYTES environment variable as a size, such as "1GB". If zero, free space is not checked.
+	MinFreeBytes int64
+
+	// TLSCertFile and TLSKeyFile are the certificate and key files the API listens with. Set with the TLS_CERT_FILE
+	// and TLS_KEY_FILE environment variables. If empty, the API does not use TLS.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// ClientCAFile is the PEM bundle of the CAs client certificates are verified against, which enables mutual TLS.
+	// Set with the MTLS_CLIENT_CA_FILE environment variable. It requires TLSCertFile and TLSKeyFile.
+	ClientCAFile string
 }
 
 // LoadConfig will load the application configuration and set the remaining values based on the environment variables.
@@ -21,8 +65,58 @@ func LoadConfig(loader *env.Loader) (*Config, error) {
 		return nil, fmt.Errorf("loading app configuration: %w", err)
 	}
 
-	return &Config{
-		Config:  appConfig,
-		APIPort: os.Getenv("API_PORT"),
-	}, nil
+	var origins []string
+	if o := os.Getenv("CORS_ALLOWED_ORIGINS"); o != "" {
+		origins = strings.Split(o, ",")
+	}
+
+	config := &Config{
+		Config:             appConfig,
+		APIPort:            os.Getenv("API_PORT"),
+		CORSAllowedOrigins: origins,
+	}
+
+	config.TokenOriginPolicy, err = token.ParseOriginPolicy(os.Getenv("TOKEN_ORIGIN_POLICY"), "TOKEN_ORIGIN_POLICY")
+	if err != nil {
+		return nil, err
+	}
+
+	config.BackfillConcurrency, err = env.Int("BACKFILL_CONCURRENCY", 0, env.Min(0))
+	if err != nil {
+		return nil, err
+	}
+
+	config.BackfillBytesPerSecond, err = env.Bytes("BACKFILL_MAX_BYTES_PER_SECOND", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	config.StreamIdleTimeout, err = env.Duration("STREAM_IDLE_TIMEOUT", 0, env.Min[time.Duration](0))
+	if err != nil {
+		return nil, err
+	}
+
+	config.AuditRetentionMonths, err = env.Int("AUDIT_RETENTION_MONTHS", audit.DefaultRetentionMonths, env.Min(0))
+	if err != nil {
+		return nil, err
+	}
+
+	config.MinFreeBytes, err = env.Bytes("STORAGE_MIN_FREE_BYTES", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	config.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	config.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	config.ClientCAFile = os.Getenv("MTLS_CLIENT_CA_FILE")
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if config.ClientCAFile != "" && config.TLSCertFile == "" {
+		return nil, errors.New("MTLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	return config, nil
 }
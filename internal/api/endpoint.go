@@ -0,0 +1,125 @@
+package api
+
+// Endpoint is a route served by the Clox API.
+type Endpoint struct {
+	// The HTTP method.
+	Method string
+
+	// The route pattern. URL parameters are wrapped in braces, for example "/api/dir/{id}".
+	Pattern string
+
+	// A short description of what the endpoint does.
+	Description string
+}
+
+// The endpoints of the Clox API.
+//
+// All route declarations should use these values. If any new endpoints are implemented, declare them here and add
+// them to Endpoints. This keeps the route table and the request console in sync.
+var (
+	EndpointReady = Endpoint{"GET", "/readyz", "Get the startup warm-up and free space status (no token required)"}
+
+	EndpointMe     = Endpoint{"GET", "/me", "Get the authenticated user. The \"include\" query parameter may list root, storage, and token"}
+	EndpointMeHead = Endpoint{"HEAD", "/me", "Check the token is valid without a response body"}
+
+	EndpointUploadStats    = Endpoint{"GET", "/api/me/stats/uploads", "Count the files uploaded on each of the last \"days\" days (default 30)"}
+	EndpointTransferStats  = Endpoint{"GET", "/api/me/transfer-stats", "Get the bytes uploaded and downloaded by each token on each of the last \"days\" UTC days (default 30)"}
+	EndpointSecurityEvents = Endpoint{"GET", "/api/me/security-events", "List the most recent failed authentications of the user, at most \"limit\" (default 20)"}
+
+	EndpointDirInfo         = Endpoint{"GET", "/api/dir/{id}/info", "Get the directory {id} and its tree ETag"}
+	EndpointDirContents     = Endpoint{"GET", "/api/dir/{id}", "Get the directory {id} with a page of its sub directories and files"}
+	EndpointDirContentsPath = Endpoint{"GET", "/api/dir", "Get the directory at the \"path\" query parameter with a page of its sub directories and files"}
+	EndpointDirEntries      = Endpoint{"GET", "/api/dir/{id}/entries", "List the sub directories and files of the directory {id}"}
+	EndpointNewDir          = Endpoint{"POST", "/api/dir/{id}", "Create a directory under the parent directory {id}"}
+	EndpointNewDirPath      = Endpoint{"POST", "/api/dir", "Create a directory under the directory at the \"path\" query parameter, relative to \"base_id\" if set"}
+	EndpointDeleteDir       = Endpoint{"DELETE", "/api/dir/{id}", "Delete the directory {id} with every directory and file under it, succeeding if it does not exist when \"idempotent\" is true, or list what would be deleted if \"dry_run\" is true"}
+	EndpointRenameDir       = Endpoint{"PATCH", "/api/dir/{id}", "Rename the directory {id} to the \"name\" of the JSON body"}
+	EndpointMoveDir         = Endpoint{"POST", "/api/dir/{id}/move", "Move the directory {id} with every directory and file under it to the directory \"parent_id\" of the JSON body"}
+
+	EndpointInbox    = Endpoint{"GET", "/api/settings/inbox", "Get the default upload directory"}
+	EndpointSetInbox = Endpoint{"PUT", "/api/settings/inbox", "Set the default upload directory"}
+
+	EndpointUploadInbox = Endpoint{"POST", "/api/upload/inbox", "Upload files to the default upload directory"}
+	EndpointUpload      = Endpoint{"POST", "/api/upload/{id}", "Upload files to the directory {id}"}
+	EndpointUploadPath  = Endpoint{"POST", "/api/upload", "Upload files to the directory at the \"path\" query parameter, relative to \"base_id\" if set"}
+
+	EndpointSyncApply = Endpoint{"POST", "/api/sync/apply", "Create directories and upload files described by a \"manifest\" JSON part in dependency order, with a result per operation"}
+
+	EndpointDownload     = Endpoint{"GET", "/api/download/file/{id}", "Download the file {id}"}
+	EndpointDownloadPath = Endpoint{"GET", "/api/download/file", "Download the file at the \"path\" query parameter"}
+	EndpointPreview      = Endpoint{"GET", "/api/preview/file/{id}", "Stream the file {id} inline with byte ranges if it is a video, audio, or image file, otherwise download it"}
+	EndpointDeleteFile   = Endpoint{"DELETE", "/api/file/{id}", "Delete the file {id}, succeeding if it does not exist when \"idempotent\" is true"}
+	EndpointRenameFile   = Endpoint{"PATCH", "/api/file/{id}", "Rename the file {id} to the \"name\" of the JSON body"}
+	EndpointMoveFile     = Endpoint{"POST", "/api/file/{id}/move", "Move the file {id} to the directory \"directory_id\", or at \"path\", of the JSON body"}
+
+	EndpointMove = Endpoint{"POST", "/api/move", "Move the \"sources\" of the JSON body, directories and files by \"id\" or \"path\", to the directory \"directory_id\", or at \"path\", with a result per source, or none of them if \"atomic\", or only the results if \"dry_run\" is true"}
+
+	EndpointSearch = Endpoint{"GET", "/api/search", "Search the names of the files for \"q\", or the text of small text files if \"content\" is true, at most \"limit\" (default 20)"}
+
+	EndpointOperation  = Endpoint{"GET", "/api/operations/{id}", "Get the upload or other multi-step operation {id}, its ID is returned in the X-Clox-Operation header"}
+	EndpointOperations = Endpoint{"GET", "/api/operations", "List the most recent operations, filtered by \"status\" (running, succeeded, failed), at most \"limit\" (default 20)"}
+
+	EndpointAdminBackfill          = Endpoint{"GET", "/api/admin/backfill", "Get the progress of the file content backfill (admin only)"}
+	EndpointAdminBackfillStart     = Endpoint{"POST", "/api/admin/backfill", "Start the file content backfill, resuming from its checkpoint (admin only)"}
+	EndpointAdminDirLayout         = Endpoint{"POST", "/api/admin/dir/{id}/layout", "Convert the files of the directory {id} to the fan-out layout (admin only)"}
+	EndpointAdminAuditExport       = Endpoint{"GET", "/api/admin/audit/export", "Export the audit events created between the \"from\" and \"to\" RFC3339 times as gzip NDJSON with a signed manifest (admin only)"}
+	EndpointAdminUserStorage       = Endpoint{"GET", "/api/admin/users/{id}/storage", "Check the storage of the user {id} and list its inconsistencies, of the directories created by \"created_via\" if set (admin only)"}
+	EndpointAdminUserStorageRepair = Endpoint{"POST", "/api/admin/users/{id}/storage/repair", "Apply the safe fixes to the storage of the user {id}, or list them if \"dry_run\" is true (admin only)"}
+	EndpointAdminClientCerts       = Endpoint{"GET", "/api/admin/users/{id}/certs", "List the client certificates registered for the user {id} (admin only)"}
+	EndpointAdminClientCertAdd     = Endpoint{"POST", "/api/admin/users/{id}/certs", "Register the PEM \"certificate\" named \"name\" for the user {id} to authenticate over mutual TLS (admin only)"}
+	EndpointAdminClientCertRevoke  = Endpoint{"DELETE", "/api/admin/users/{id}/certs/{certID}", "Revoke the client certificate {certID} of the user {id}, succeeding if it is already revoked when \"idempotent\" is true (admin only)"}
+	EndpointAdminFaults            = Endpoint{"GET", "/api/admin/faults", "List the number of calls left to fail at each fault injection point (admin only, FAULT_INJECTION in dev)"}
+	EndpointAdminArmFault          = Endpoint{"PUT", "/api/admin/faults", "Force the next \"count\" calls at the fault injection \"point\" to fail, 0 disarms it (admin only, FAULT_INJECTION in dev)"}
+	EndpointAdminTransferStats     = Endpoint{"GET", "/api/admin/transfer-stats", "Get the bytes uploaded and downloaded by every user over the last \"days\" UTC days (default 30) (admin only)"}
+	EndpointAdminTreeStats         = Endpoint{"GET", "/api/admin/tree-stats", "Get the directory count, depth, and closure rows of the \"limit\" users with the most closure rows (default 20), and flag the users above the thresholds (admin only)"}
+)
+
+// Endpoints returns every endpoint of the Clox API.
+func Endpoints() []Endpoint {
+	return []Endpoint{
+		EndpointReady,
+		EndpointMe,
+		EndpointMeHead,
+		EndpointUploadStats,
+		EndpointTransferStats,
+		EndpointSecurityEvents,
+		EndpointDirInfo,
+		EndpointDirContents,
+		EndpointDirContentsPath,
+		EndpointDirEntries,
+		EndpointNewDir,
+		EndpointNewDirPath,
+		EndpointDeleteDir,
+		EndpointRenameDir,
+		EndpointMoveDir,
+		EndpointInbox,
+		EndpointSetInbox,
+		EndpointUploadInbox,
+		EndpointUpload,
+		EndpointUploadPath,
+		EndpointSyncApply,
+		EndpointDownload,
+		EndpointDownloadPath,
+		EndpointPreview,
+		EndpointDeleteFile,
+		EndpointRenameFile,
+		EndpointMoveFile,
+		EndpointMove,
+		EndpointSearch,
+		EndpointOperation,
+		EndpointOperations,
+		EndpointAdminBackfill,
+		EndpointAdminBackfillStart,
+		EndpointAdminDirLayout,
+		EndpointAdminAuditExport,
+		EndpointAdminUserStorage,
+		EndpointAdminUserStorageRepair,
+		EndpointAdminTransferStats,
+		EndpointAdminClientCerts,
+		EndpointAdminClientCertAdd,
+		EndpointAdminClientCertRevoke,
+		EndpointAdminTreeStats,
+		EndpointAdminFaults,
+		EndpointAdminArmFault,
+	}
+}
@@ -0,0 +1,553 @@
+package handler
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/audit"
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/operation"
+	"github.com/go-chi/chi/v5"
+)
+
+type Admin struct {
+	backfill *cloudstore.Backfill
+	dirs     *cloudstore.DirService
+	faults   *cloudstore.Faults
+	ops      *operation.Service
+	audit    *audit.Exporter
+	log      *log.Logger
+}
+
+func NewAdmin(backfill *cloudstore.Backfill, dirs *cloudstore.DirService, faults *cloudstore.Faults, ops *operation.Service, audit *audit.Exporter, log *log.Logger) *Admin {
+	return &Admin{
+		backfill: backfill,
+		dirs:     dirs,
+		faults:   faults,
+		ops:      ops,
+		audit:    audit,
+		log:      log,
+	}
+}
+
+type backfillResponse struct {
+	Running    bool     `json:"running"`
+	LastID     string   `json:"last_id"`
+	Processed  int64    `json:"processed"`
+	Updated    int64    `json:"updated"`
+	Missing    int64    `json:"missing"`
+	Failed     int64    `json:"failed"`
+	StartedAt  app.Time `json:"started_at"`
+	UpdatedAt  app.Time `json:"updated_at"`
+	FinishedAt app.Time `json:"finished_at"`
+
+	// Versions is keyed by record version.
+	Versions map[cloudstore.RecordVersion]int64 `json:"versions"`
+
+	// OperationID is the operation of the run started by the request, if any.
+	OperationID string `json:"operation_id,omitempty"`
+}
+
+// Backfill returns a http.HandlerFunc that writes the progress of the file content backfill as a
+// JSON response.
+func (a *Admin) Backfill() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.writeProgress(w, r, http.StatusOK, "")
+	}
+}
+
+// BackfillStart returns a http.HandlerFunc that starts the file content backfill in the background
+// and writes its progress as a JSON response with a 202 status code. If the backfill is already
+// running, a 409 JSON error is written.
+//
+// The run is a operation of the admin, it is updated as the backfill checkpoints.
+func (a *Admin) BackfillStart() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		run, ok := startOperation(w, r, a.ops, a.log, userID, operation.TypeBackfill, struct{}{})
+		if !ok {
+			return
+		}
+
+		if !a.backfill.Start(run) {
+			err := app.Wrap(app.WrapParams{
+				Err:         cloudstore.ErrBackfillRunning,
+				SafeMessage: "Backfill is already running",
+				StatusCode:  http.StatusConflict,
+			})
+			run.Finish(r.Context(), err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		a.writeProgress(w, r, http.StatusAccepted, run.ID())
+	}
+}
+
+// ConvertLayout returns a http.HandlerFunc that converts the files of the directory in the URL
+// to the fan-out layout and writes the result as a JSON response. The conversion runs before the
+// response is written. Converting a directory that already uses the fan-out layout does nothing.
+func (a *Admin) ConvertLayout() http.HandlerFunc {
+	type response struct {
+		DirectoryID string `json:"directory_id"`
+		Layout      string `json:"layout"`
+		Converted   bool   `json:"converted"`
+		Moved       int    `json:"moved"`
+		OperationID string `json:"operation_id,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		dirID := chi.URLParam(r, "id")
+		run, ok := startOperation(w, r, a.ops, a.log, userID, operation.TypeConvertLayout, map[string]string{"directory_id": dirID})
+		if !ok {
+			return
+		}
+
+		c, err := a.dirs.ConvertLayout(r.Context(), dirID)
+		run.Progress(r.Context(), int64(c.Moved), 0)
+		run.Finish(r.Context(), err)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			a.log.Printf("[ERROR] [%s %s] Converting directory layout: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		body, err := json.Marshal(&response{
+			DirectoryID: c.DirectoryID,
+			Layout:      cloudstore.LayoutFanOut.String(),
+			Converted:   c.Converted,
+			Moved:       c.Moved,
+			OperationID: run.ID(),
+		})
+		if err != nil {
+			app.WriteJSONError(w, err)
+			a.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+// storageIssueResponse is a cloudstore.StorageIssue in JSON format.
+type storageIssueResponse struct {
+	Kind       cloudstore.IssueKind  `json:"kind"`
+	ID         string                `json:"id"`
+	Path       string                `json:"path,omitempty"`
+	Detail     string                `json:"detail"`
+	CreatedVia cloudstore.CreatedVia `json:"created_via,omitempty"`
+	Fixable    bool                  `json:"fixable"`
+	Error      string                `json:"error,omitempty"`
+}
+
+// storageReportResponse is a cloudstore.StorageReport in JSON format.
+type storageReportResponse struct {
+	UserID        string                        `json:"user_id"`
+	State         cloudstore.StorageState       `json:"storage_state"`
+	RootID        string                        `json:"root_id"`
+	RootCreatedAt app.Time                      `json:"root_created_at"`
+	Directories   int                           `json:"directories"`
+	Files         int                           `json:"files"`
+	Bytes         int64                         `json:"bytes"`
+	CreatedVia    map[cloudstore.CreatedVia]int `json:"created_via"`
+	Issues        []storageIssueResponse        `json:"issues"`
+}
+
+func newStorageIssues(issues []cloudstore.StorageIssue) []storageIssueResponse {
+	resp := []storageIssueResponse{}
+	for _, i := range issues {
+		issue := storageIssueResponse{
+			Kind:       i.Kind,
+			ID:         i.ID,
+			Path:       i.Path,
+			Detail:     i.Detail,
+			CreatedVia: i.CreatedVia,
+			Fixable:    i.Fixable,
+		}
+		if i.Err != nil {
+			issue.Error = i.Err.Error()
+		}
+
+		resp = append(resp, issue)
+	}
+
+	return resp
+}
+
+func newStorageReport(r cloudstore.StorageReport) storageReportResponse {
+	return storageReportResponse{
+		UserID:        r.UserID,
+		State:         r.State,
+		RootID:        r.RootID,
+		RootCreatedAt: app.NewTime(r.RootCreatedAt),
+		Directories:   r.Directories,
+		Files:         r.Files,
+		Bytes:         r.Bytes,
+		CreatedVia:    r.CreatedVia,
+		Issues:        newStorageIssues(r.Issues),
+	}
+}
+
+// UserStorage returns a http.HandlerFunc that checks the storage of the user in the URL and
+// writes the report, with every inconsistency found, as a JSON response. If the "created_via"
+// query parameter is set, only the issues of directories created by that path are written.
+func (a *Admin) UserStorage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var via cloudstore.CreatedVia
+		if v := r.URL.Query().Get("created_via"); v != "" {
+			var err error
+			if via, err = cloudstore.ParseCreatedVia(v); err != nil {
+				app.WriteJSONError(w, err)
+				return
+			}
+		}
+
+		report, err := a.dirs.CheckStorage(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			a.log.Printf("[ERROR] [%s %s] Checking user storage: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		if via != "" {
+			report = report.FilterCreatedVia(via)
+		}
+
+		a.writeJSON(w, r, newStorageReport(report))
+	}
+}
+
+// UserStorageRepair returns a http.HandlerFunc that applies the safe fixes to the storage of the
+// user in the URL and writes the result as a JSON response. If the "dry_run" query parameter is
+// true, nothing is changed and the fixes that would be applied are written.
+//
+// The repair is a operation of the admin.
+func (a *Admin) UserStorageRepair() http.HandlerFunc {
+	type response struct {
+		DryRun      bool                   `json:"dry_run"`
+		Report      storageReportResponse  `json:"report"`
+		Fixed       []storageIssueResponse `json:"fixed"`
+		Failed      []storageIssueResponse `json:"failed"`
+		OperationID string                 `json:"operation_id,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		dryRun, err := parseDryRun(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		userID := chi.URLParam(r, "id")
+		run, ok := startOperation(w, r, a.ops, a.log, adminID, operation.TypeStorageRepair, map[string]any{"user_id": userID, "dry_run": dryRun})
+		if !ok {
+			return
+		}
+
+		repair, err := a.dirs.RepairStorage(r.Context(), userID, dryRun)
+		run.Progress(r.Context(), int64(len(repair.Fixed)+len(repair.Failed)), int64(len(repair.Failed)))
+		run.Finish(r.Context(), err)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			a.log.Printf("[ERROR] [%s %s] Repairing user storage: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		a.writeJSON(w, r, &response{
+			DryRun:      repair.DryRun,
+			Report:      newStorageReport(repair.Report),
+			Fixed:       newStorageIssues(repair.Fixed),
+			Failed:      newStorageIssues(repair.Failed),
+			OperationID: run.ID(),
+		})
+	}
+}
+
+// TreeStats returns a http.HandlerFunc that writes the shape of the directory trees of the users
+// with the most closure rows as a JSON response. The number of users is set with the "limit" query
+// parameter. The users above the thresholds are flagged, and the totals cover every user.
+func (a *Admin) TreeStats() http.HandlerFunc {
+	type user struct {
+		UserID      string  `json:"user_id"`
+		Directories int64   `json:"directories"`
+		MaxDepth    int     `json:"max_depth"`
+		AvgDepth    float64 `json:"avg_depth"`
+		ClosureRows int64   `json:"closure_rows"`
+		Flagged     bool    `json:"flagged"`
+	}
+
+	type thresholds struct {
+		Depth       int   `json:"depth"`
+		ClosureRows int64 `json:"closure_rows"`
+	}
+
+	type response struct {
+		Thresholds   thresholds `json:"thresholds"`
+		TotalUsers   int        `json:"total_users"`
+		FlaggedUsers int        `json:"flagged_users"`
+		Directories  int64      `json:"directories"`
+		MaxDepth     int        `json:"max_depth"`
+		ClosureRows  int64      `json:"closure_rows"`
+		Users        []user     `json:"users"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := cloudstore.ParseTreeReportLimit(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		report, err := a.dirs.TreeReport(r.Context(), limit)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			a.log.Printf("[ERROR] [%s %s] Getting tree stats: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp := response{
+			Thresholds: thresholds{
+				Depth:       report.Thresholds.Depth,
+				ClosureRows: report.Thresholds.ClosureRows,
+			},
+			TotalUsers:   report.TotalUsers,
+			FlaggedUsers: report.FlaggedUsers,
+			Directories:  report.Directories,
+			MaxDepth:     report.MaxDepth,
+			ClosureRows:  report.ClosureRows,
+			Users:        []user{},
+		}
+
+		for _, u := range report.Users {
+			resp.Users = append(resp.Users, user{
+				UserID:      u.UserID,
+				Directories: u.Directories,
+				MaxDepth:    u.MaxDepth,
+				AvgDepth:    u.AvgDepth,
+				ClosureRows: u.ClosureRows,
+				Flagged:     u.Flagged,
+			})
+		}
+
+		a.writeJSON(w, r, resp)
+	}
+}
+
+// writeJSON writes v as a JSON response.
+func (a *Admin) writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		app.WriteJSONError(w, err)
+		a.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// faultsDisabled is the error of the fault endpoints when fault injection is disabled. It is a 404,
+// so the endpoints look like they do not exist outside of development.
+func faultsDisabled() error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("fault injection is disabled"),
+		SafeMessage: "Not found",
+		StatusCode:  http.StatusNotFound,
+	})
+}
+
+// Faults returns a http.HandlerFunc that writes the number of calls left to fail at each
+// cloudstore.FaultPoint as a JSON response. If fault injection is disabled, a 404 JSON error is
+// written.
+func (a *Admin) Faults() http.HandlerFunc {
+	type response struct {
+		Armed map[cloudstore.FaultPoint]int `json:"armed"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.faults == nil {
+			app.WriteJSONError(w, faultsDisabled())
+			return
+		}
+
+		a.writeJSON(w, r, response{Armed: a.faults.Armed()})
+	}
+}
+
+// ArmFault returns a http.HandlerFunc that forces the next "count" calls at the fault injection
+// "point" of the JSON request body to fail, and writes the armed points as a JSON response. A count
+// of 0 disarms the point. If fault injection is disabled, a 404 JSON error is written.
+func (a *Admin) ArmFault() http.HandlerFunc {
+	type response struct {
+		Armed map[cloudstore.FaultPoint]int `json:"armed"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.faults == nil {
+			app.WriteJSONError(w, faultsDisabled())
+			return
+		}
+
+		var body struct {
+			Point *string `json:"point"`
+			Count *int    `json:"count"`
+		}
+		if err := decodeJSON(r, &body); err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if body.Point == nil {
+			app.WriteJSONError(w, requiredField("point"))
+			return
+		}
+
+		if body.Count == nil {
+			app.WriteJSONError(w, requiredField("count"))
+			return
+		}
+
+		point, err := cloudstore.ParseFaultPoint(*body.Point)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		if *body.Count < 0 {
+			app.WriteJSONError(w, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("invalid count: %d", *body.Count),
+				SafeMessage: "count cannot be negative",
+				StatusCode:  http.StatusBadRequest,
+				Field:       "count",
+			}))
+			return
+		}
+
+		a.faults.Arm(point, *body.Count)
+		a.log.Printf("[WARN] [%s %s] Fault injection point armed [point: %s, count: %d]\n", r.Method, r.URL.Path, point, *body.Count)
+		a.writeJSON(w, r, response{Armed: a.faults.Armed()})
+	}
+}
+
+// AuditExport returns a http.HandlerFunc that writes the audit log events created in the window
+// of the "from" and "to" query parameters as a gzip compressed NDJSON file. The last line of the
+// file is the signed audit.Manifest of the export.
+//
+// The events are streamed as they are read. If the export fails part way, the response ends
+// without the manifest.
+func (a *Admin) AuditExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseWindow(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		name := fmt.Sprintf("audit-%s-%s.ndjson.gz", from.UTC().Format("20060102T150405Z"), to.UTC().Format("20060102T150405Z"))
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.WriteHeader(http.StatusOK)
+
+		gz := gzip.NewWriter(w)
+		m, err := a.audit.Export(r.Context(), gz, from, to)
+		if err != nil {
+			a.log.Printf("[ERROR] [%s %s] Exporting audit log: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		if err := gz.Close(); err != nil {
+			a.log.Printf("[ERROR] [%s %s] Closing audit export: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		a.log.Printf("[INFO] [%s %s] Exported audit log [events: %d, sha256: %s]\n", r.Method, r.URL.Path, m.Count, m.SHA256)
+	}
+}
+
+// parseWindow parses the "from" and "to" query parameters as RFC3339 times. Both are required
+// and from must be before to. All errors returned are a app.WrappedSafeError.
+func parseWindow(q url.Values) (time.Time, time.Time, error) {
+	var times [2]time.Time
+	for i, field := range []string{"from", "to"} {
+		v := q.Get(field)
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("invalid %s %q: %w", field, v, err),
+				SafeMessage: fmt.Sprintf("%s must be a RFC3339 time, such as 2024-01-02T15:04:05Z", field),
+				StatusCode:  http.StatusBadRequest,
+				Field:       field,
+			})
+		}
+		times[i] = t
+	}
+
+	if !times[0].Before(times[1]) {
+		return time.Time{}, time.Time{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("from %s not before to %s", times[0], times[1]),
+			SafeMessage: "from must be before to",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "from",
+		})
+	}
+
+	return times[0], times[1], nil
+}
+
+func (a *Admin) writeProgress(w http.ResponseWriter, r *http.Request, statusCode int, operationID string) {
+	p, err := a.backfill.Progress(r.Context())
+	if err != nil {
+		app.WriteJSONError(w, err)
+		a.log.Printf("[ERROR] [%s %s] Getting backfill progress: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	body, err := json.Marshal(&backfillResponse{
+		Running:    p.Running,
+		LastID:     p.LastID,
+		Processed:  p.Processed,
+		Updated:    p.Updated,
+		Missing:    p.Missing,
+		Failed:     p.Failed,
+		StartedAt:  app.NewTime(p.StartedAt),
+		UpdatedAt:  app.NewTime(p.UpdatedAt),
+		FinishedAt: app.NewTime(p.FinishedAt),
+		Versions:   p.Versions,
+
+		OperationID: operationID,
+	})
+	if err != nil {
+		app.WriteJSONError(w, err)
+		a.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
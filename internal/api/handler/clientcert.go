@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/clientcert"
+	"github.com/go-chi/chi/v5"
+)
+
+// ClientCert encapsulates the admin handlers for the client certificates of users.
+type ClientCert struct {
+	certs *clientcert.Service
+	log   *log.Logger
+}
+
+// NewClientCert creates a client certificate handler.
+func NewClientCert(certs *clientcert.Service, log *log.Logger) *ClientCert {
+	return &ClientCert{certs: certs, log: log}
+}
+
+type clientCertResponse struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Fingerprint string   `json:"fingerprint"`
+	Subject     string   `json:"subject"`
+	NotAfter    app.Time `json:"not_after"`
+	CreatedAt   app.Time `json:"created_at"`
+	LastUsed    app.Time `json:"last_used"`
+}
+
+func newClientCertResponse(c clientcert.Cert) clientCertResponse {
+	return clientCertResponse{
+		ID:          c.ID,
+		Name:        c.Name,
+		Fingerprint: c.Fingerprint,
+		Subject:     c.Subject,
+		NotAfter:    app.NewTime(c.NotAfter),
+		CreatedAt:   app.NewTime(c.CreatedAt),
+		LastUsed:    app.NewTime(c.LastUsed),
+	}
+}
+
+// List returns a http.HandlerFunc that writes the client certificates of the user in the URL
+// as a JSON response, newest first.
+func (c *ClientCert) List() http.HandlerFunc {
+	type response struct {
+		Certs []clientCertResponse `json:"certs"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		certs, err := c.certs.List(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			c.log.Printf("[ERROR] [%s %s] Listing client certificates: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp := response{Certs: []clientCertResponse{}}
+		for _, cert := range certs {
+			resp.Certs = append(resp.Certs, newClientCertResponse(cert))
+		}
+
+		c.write(w, r, http.StatusOK, resp)
+	}
+}
+
+// Register returns a http.HandlerFunc that registers the PEM encoded "certificate" of the JSON
+// request body for the user in the URL, and writes it as a JSON response with a 201 status code.
+// Requests presenting the certificate over mutual TLS are then authenticated as the user.
+func (c *ClientCert) Register() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Name        string `json:"name"`
+			Certificate string `json:"certificate"`
+		}
+		if err := decodeJSON(r, &body); err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if body.Certificate == "" {
+			app.WriteJSONError(w, requiredField("certificate"))
+			return
+		}
+
+		cert, err := c.certs.Register(r.Context(), chi.URLParam(r, "id"), body.Name, body.Certificate)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			c.log.Printf("[ERROR] [%s %s] Registering client certificate: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		c.log.Printf("[INFO] [%s %s] Client certificate registered [user: %s, id: %s, fingerprint: %s]\n", r.Method, r.URL.Path, cert.UserID, cert.ID, cert.Fingerprint)
+		c.write(w, r, http.StatusCreated, newClientCertResponse(cert))
+	}
+}
+
+// Revoke returns a http.HandlerFunc that deletes the client certificate {certID} of the user
+// in the URL. The certificate no longer authenticates requests. A 204 status code is written.
+//
+// If the "idempotent" query parameter is true, revoking a certificate that does not exist also
+// writes a 204 status code, with the IdempotentHeader set, so a retried revoke succeeds.
+// Otherwise a 404 status code is written.
+func (c *ClientCert) Revoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, certID := chi.URLParam(r, "id"), chi.URLParam(r, "certID")
+
+		idempotent, err := parseIdempotent(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		if err := c.certs.Revoke(r.Context(), userID, certID); err != nil {
+			if deletedIdempotent(w, idempotent, err, clientcert.ErrNotFound, certID) {
+				c.log.Printf("[INFO] [%s %s] Client certificate already revoked [user: %s, id: %s]\n", r.Method, r.URL.Path, userID, certID)
+				return
+			}
+
+			app.WriteJSONError(w, err)
+			c.log.Printf("[ERROR] [%s %s] Revoking client certificate: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		c.log.Printf("[INFO] [%s %s] Client certificate revoked [user: %s, id: %s]\n", r.Method, r.URL.Path, userID, certID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// write writes v as a JSON response with the status code.
+func (c *ClientCert) write(w http.ResponseWriter, r *http.Request, statusCode int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		app.WriteJSONError(w, err)
+		c.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
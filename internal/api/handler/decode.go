@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// errBodyRequired signals a request had no JSON body, or a body of null.
+var errBodyRequired = errors.New("request body required")
+
+// The limits of a JSON request body. No request body of the API comes close to them, they
+// bound the memory and CPU a single request can spend on decoding.
+const (
+	maxJSONBodyBytes = 1 << 20
+	maxJSONDepth     = 32
+)
+
+// decodeJSON decodes the JSON request body of r into v. Fields of v that must be present should
+// be pointers, so a absent field (nil) can be told apart from a zero value.
+//
+// If the body is empty or null, a app.WrappedSafeError with a 400 status code and the message
+// "Request body is required" is returned. If the body is not valid JSON for v, the message is
+// "Invalid request body". A body larger than maxJSONBodyBytes is rejected with a 413 status
+// code, and a body with objects or arrays nested deeper than maxJSONDepth with a 400 status
+// code, before it is decoded.
+//
+// decodeJSON does not close r.Body.
+func decodeJSON(r *http.Request, v any) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxJSONBodyBytes+1))
+	if err != nil {
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("reading request body: %w", err),
+			SafeMessage: "Invalid request body",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	if len(body) > maxJSONBodyBytes {
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("request body larger than %d bytes", maxJSONBodyBytes),
+			SafeMessage: "Request body is too large",
+			StatusCode:  http.StatusRequestEntityTooLarge,
+		})
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || bytes.Equal(body, []byte("null")) {
+		return app.Wrap(app.WrapParams{
+			Err:         errBodyRequired,
+			SafeMessage: "Request body is required",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	if jsonTooDeep(body, maxJSONDepth) {
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("request body nested deeper than %d", maxJSONDepth),
+			SafeMessage: "Request body is nested too deeply",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return app.Wrap(app.WrapParams{
+			Err:         err,
+			SafeMessage: "Invalid request body",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	return nil
+}
+
+// jsonTooDeep reports if the objects and arrays of the JSON body are nested deeper than max.
+// The body is only scanned, not validated, invalid JSON is left to json.Unmarshal.
+func jsonTooDeep(body []byte, max int) bool {
+	depth := 0
+	inString, escaped := false, false
+
+	for _, c := range body {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			if depth > max {
+				return true
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+
+	return false
+}
+
+// requiredField returns the 400 app.WrappedSafeError of a field that is absent from a request
+// body.
+func requiredField(field string) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("missing field: %s", field),
+		SafeMessage: fmt.Sprintf("%s is required", field),
+		StatusCode:  http.StatusBadRequest,
+		Field:       field,
+	})
+}
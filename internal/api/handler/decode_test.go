@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat(`{"a":`, depth) + "1" + strings.Repeat("}", depth)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantMsg    string
+	}{
+		{name: "valid", body: `{"name":"photos"}`},
+		{name: "empty", body: "  ", wantStatus: http.StatusBadRequest, wantMsg: "Request body is required"},
+		{name: "null", body: "null", wantStatus: http.StatusBadRequest, wantMsg: "Request body is required"},
+		{name: "invalid", body: `{"name":`, wantStatus: http.StatusBadRequest, wantMsg: "Invalid request body"},
+		{name: "at depth", body: nested(maxJSONDepth)},
+		{name: "too deep", body: nested(maxJSONDepth + 1), wantStatus: http.StatusBadRequest, wantMsg: "Request body is nested too deeply"},
+		{name: "too deep arrays", body: strings.Repeat("[", maxJSONDepth+1), wantStatus: http.StatusBadRequest, wantMsg: "Request body is nested too deeply"},
+		{name: "brackets in strings", body: `{"name":"` + strings.Repeat(`[{\"`, maxJSONDepth+1) + `"}`},
+		{name: "at size", body: `{"name":"` + strings.Repeat("a", maxJSONBodyBytes-len(`{"name":""}`)) + `"}`},
+		{
+			name:       "too large",
+			body:       `{"name":"` + strings.Repeat("a", maxJSONBodyBytes) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantMsg:    "Request body is too large",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/dir", strings.NewReader(tc.body))
+
+			var v map[string]any
+			err := decodeJSON(r, &v)
+			if tc.wantStatus == 0 {
+				if err != nil {
+					t.Errorf("decodeJSON() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var safeErr *app.WrappedSafeError
+			if !errors.As(err, &safeErr) {
+				t.Fatalf("decodeJSON() error = %v, want a app.WrappedSafeError", err)
+			}
+
+			if msg, status := safeErr.Safe(); status != tc.wantStatus || msg != tc.wantMsg {
+				t.Errorf("decodeJSON() error = %d %q, want %d %q", status, msg, tc.wantStatus, tc.wantMsg)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONAdversarial(t *testing.T) {
+	bodies := []string{
+		// Nesting is rejected before json.Unmarshal recurses into it.
+		strings.Repeat("[", maxJSONBodyBytes),
+		strings.Repeat(`{"a":`, maxJSONBodyBytes/5),
+		// The body is never read past the limit.
+		strings.Repeat(" ", 64*maxJSONBodyBytes),
+	}
+
+	start := time.Now()
+	for _, body := range bodies {
+		r := httptest.NewRequest("POST", "/api/dir", strings.NewReader(body))
+
+		var v any
+		if err := decodeJSON(r, &v); err == nil {
+			t.Errorf("decodeJSON() of a %d byte body error = nil, want an error", len(body))
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("decodeJSON() took %s, want the bodies rejected without decoding them", elapsed)
+	}
+}
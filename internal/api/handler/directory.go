@@ -2,23 +2,25 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"time"
 
 	"github.com/cicconee/clox/internal/api/auth"
 	"github.com/cicconee/clox/internal/app"
 	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/pagination"
 	"github.com/go-chi/chi/v5"
 )
 
 type Directory struct {
-	dirs *cloudstore.DirService
-	log  *log.Logger
+	dirs    *cloudstore.DirService
+	cursors *pagination.Codec
+	log     *log.Logger
 }
 
-func NewDirectory(dirs *cloudstore.DirService, log *log.Logger) *Directory {
-	return &Directory{dirs: dirs, log: log}
+func NewDirectory(dirs *cloudstore.DirService, cursors *pagination.Codec, log *log.Logger) *Directory {
+	return &Directory{dirs: dirs, cursors: cursors, log: log}
 }
 
 // The request body when creating a new directory.
@@ -26,47 +28,68 @@ type newDirRequest struct {
 	Name string `json:"name"`
 }
 
-// parseNewDirRequest parses the request body into a newDirRequest.
+// parseNewDirRequest parses the request body into a newDirRequest. The name must be present
+// and not empty, a absent name and a empty name are distinct field errors.
 //
 // parseNewDirRequest does not close r.Body.
 func parseNewDirRequest(r *http.Request) (newDirRequest, error) {
-	var request newDirRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	var body struct {
+		Name *string `json:"name"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return newDirRequest{}, err
+	}
+
+	if body.Name == nil {
+		return newDirRequest{}, requiredField("name")
+	}
+
+	if *body.Name == "" {
 		return newDirRequest{}, app.Wrap(app.WrapParams{
-			Err:         err,
-			SafeMessage: "Invalid request body",
+			Err:         errors.New("empty directory name"),
+			SafeMessage: "name cannot be empty",
 			StatusCode:  http.StatusBadRequest,
+			Field:       "name",
 		})
 	}
 
-	return request, nil
+	return newDirRequest{Name: *body.Name}, nil
 }
 
 // The response body when creating a new directory.
 type newDirResponse struct {
-	ID        string    `json:"id"`
-	OwnerID   string    `json:"owner_id"`
-	ParentID  string    `json:"parent_id"`
-	DirName   string    `json:"directory_name"`
-	DirPath   string    `json:"directory_path"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	LastWrite time.Time `json:"last_write"`
+	ID        string   `json:"id"`
+	OwnerID   string   `json:"owner_id"`
+	ParentID  string   `json:"parent_id"`
+	DirName   string   `json:"directory_name"`
+	DirPath   string   `json:"directory_path"`
+	CreatedAt app.Time `json:"created_at"`
+	UpdatedAt app.Time `json:"updated_at"`
+	LastWrite app.Time `json:"last_write"`
+
+	CreatedVia cloudstore.CreatedVia `json:"created_via"`
 }
 
 // marshalNewDirResponse converts a cloudstore.Dir to a newDirResponse
 // and marshals it to json byte slice.
 func marshalNewDirResponse(dir cloudstore.Dir) ([]byte, error) {
-	return json.Marshal(&newDirResponse{
-		ID:        dir.ID,
-		OwnerID:   dir.Owner,
-		ParentID:  dir.ParentID,
-		DirName:   dir.Name,
-		DirPath:   dir.Path,
-		CreatedAt: dir.CreatedAt,
-		UpdatedAt: dir.UpdatedAt,
-		LastWrite: dir.LastWrite,
-	})
+	resp := newDirResponseFrom(dir)
+	return json.Marshal(&resp)
+}
+
+// newDirResponseFrom converts a cloudstore.Dir to a newDirResponse.
+func newDirResponseFrom(dir cloudstore.Dir) newDirResponse {
+	return newDirResponse{
+		ID:         dir.ID,
+		OwnerID:    dir.Owner,
+		ParentID:   dir.ParentID,
+		DirName:    dir.Name,
+		DirPath:    dir.Path,
+		CreatedAt:  app.NewTime(dir.CreatedAt),
+		UpdatedAt:  app.NewTime(dir.UpdatedAt),
+		LastWrite:  app.NewTime(dir.LastWrite),
+		CreatedVia: dir.CreatedVia,
+	}
 }
 
 // New returns a http.HandlerFunc that handles creating a new directory when
@@ -74,7 +97,7 @@ func marshalNewDirResponse(dir cloudstore.Dir) ([]byte, error) {
 // should be specified in a json request body.
 //
 // New expects the user ID to be in the request context. To set the user ID in the
-// request context, use auth.SetUserIDContext.
+// request context, use reqinfo.SetUser.
 func (d *Directory) New() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		d.new(w, r, func(userID string, request newDirRequest) (cloudstore.Dir, error) {
@@ -86,14 +109,16 @@ func (d *Directory) New() http.HandlerFunc {
 // NewPath returns a http.HandlerFunc that handles creating a new directory
 // when the directories path is specified as a URL query parameter with the
 // key "path". The name of the directory should be specified in a json request
-// body.
+// body. If the "base_id" query parameter is set, the path is relative to that
+// directory instead of the users root directory.
 //
 // NewPath expects the user ID to be in the request context. To set the user
-// ID in the request context, use auth.SetUserIDContext.
+// ID in the request context, use reqinfo.SetUser.
 func (d *Directory) NewPath() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		d.new(w, r, func(userID string, request newDirRequest) (cloudstore.Dir, error) {
-			return d.dirs.NewPath(r.Context(), userID, request.Name, r.URL.Query().Get("path"))
+			query := r.URL.Query()
+			return d.dirs.NewPathRelative(r.Context(), userID, request.Name, query.Get("base_id"), query.Get("path"))
 		})
 	}
 }
@@ -103,7 +128,10 @@ func (d *Directory) NewPath() http.HandlerFunc {
 // the request body parsed as a newDirRequest. newDirFunc should create a new
 // directory and return it.
 func (d *Directory) new(w http.ResponseWriter, r *http.Request, newDirFunc func(string, newDirRequest) (cloudstore.Dir, error)) {
-	userID := auth.GetUserIDContext(r.Context())
+	userID, ok := auth.MustUserID(w, r)
+	if !ok {
+		return
+	}
 
 	request, err := parseNewDirRequest(r)
 	if err != nil {
@@ -120,6 +148,11 @@ func (d *Directory) new(w http.ResponseWriter, r *http.Request, newDirFunc func(
 		return
 	}
 
+	d.writeDir(w, r, dir)
+}
+
+// writeDir writes dir as a JSON response.
+func (d *Directory) writeDir(w http.ResponseWriter, r *http.Request, dir cloudstore.Dir) {
 	resp, err := marshalNewDirResponse(dir)
 	if err != nil {
 		app.WriteJSONError(w, err)
@@ -131,3 +164,482 @@ func (d *Directory) new(w http.ResponseWriter, r *http.Request, newDirFunc func(
 	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
+
+// The request body when setting the default upload directory.
+type setInboxRequest struct {
+	DirectoryID *string `json:"directory_id"`
+}
+
+// Inbox returns a http.HandlerFunc that writes the users default upload
+// directory as a JSON response. If the user has not set a default upload
+// directory, a "Inbox" directory is created and returned.
+//
+// Inbox expects the user ID to be in the request context. To set the user ID
+// in the request context, use reqinfo.SetUser.
+func (d *Directory) Inbox() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		dir, err := d.dirs.Inbox(r.Context(), userID)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed getting inbox directory: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		d.writeDir(w, r, dir)
+	}
+}
+
+// SetInbox returns a http.HandlerFunc that handles setting the users default
+// upload directory. The directory ID should be specified in a json request body.
+// The directory ID is required, an empty directory ID resets the default upload
+// directory.
+//
+// SetInbox expects the user ID to be in the request context. To set the user ID
+// in the request context, use reqinfo.SetUser.
+func (d *Directory) SetInbox() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		var request setInboxRequest
+		if err := decodeJSON(r, &request); err != nil {
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed to decode request body: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if request.DirectoryID == nil {
+			err := requiredField("directory_id")
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed to decode request body: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		dir, err := d.dirs.SetInbox(r.Context(), userID, *request.DirectoryID)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed setting inbox directory: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		if dir.ID == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		d.writeDir(w, r, dir)
+	}
+}
+
+// The response body of a directory entry.
+type entryResponse struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Path       string   `json:"path"`
+	Size       *int64   `json:"size"`
+	CreatedAt  app.Time `json:"created_at"`
+	ModifiedAt app.Time `json:"modified_at"`
+
+	// ClientModifiedAt is null for directories.
+	ClientModifiedAt app.Time `json:"client_modified_at"`
+
+	// ContentMissing is true if the content of a file is unavailable.
+	ContentMissing bool `json:"content_missing"`
+}
+
+// newEntryPageFrom converts a page of cloudstore.Entry to a page of entryResponse.
+func newEntryPageFrom(page pagination.Page[cloudstore.Entry]) pagination.Page[entryResponse] {
+	items := []entryResponse{}
+	for _, e := range page.Items {
+		items = append(items, entryResponse{
+			Type:       e.Type.Name(),
+			ID:         e.ID,
+			Name:       e.Name,
+			Path:       e.Path,
+			Size:       e.Size,
+			CreatedAt:  app.NewTime(e.CreatedAt),
+			ModifiedAt: app.NewTime(e.ModifiedAt),
+
+			ClientModifiedAt: app.NewTime(e.ClientModifiedAt),
+			ContentMissing:   e.ContentMissing,
+		})
+	}
+
+	return pagination.Page[entryResponse]{
+		Items:      items,
+		NextCursor: page.NextCursor,
+		HasMore:    page.HasMore,
+	}
+}
+
+// The response body of a directory with a page of its entries.
+type dirContentsResponse struct {
+	Directory newDirResponse `json:"directory"`
+	pagination.Page[entryResponse]
+}
+
+// newDirContentsResponseFrom converts a cloudstore.DirContents to a dirContentsResponse.
+func newDirContentsResponseFrom(contents cloudstore.DirContents) dirContentsResponse {
+	return dirContentsResponse{
+		Directory: newDirResponseFrom(contents.Dir),
+		Page:      newEntryPageFrom(contents.Entries),
+	}
+}
+
+// Contents returns a http.HandlerFunc that writes a directory with a page of its sub
+// directories and files as a JSON response when the directory ID is apart of the URL
+// path. The page is controlled by the same query parameters as Entries.
+//
+// Contents expects the user ID to be in the request context. To set the user ID in the
+// request context, use reqinfo.SetUser.
+func (d *Directory) Contents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.contents(w, r, func(userID string) (cloudstore.DirContents, error) {
+			opts, req, err := parseEntriesRequest(r)
+			if err != nil {
+				return cloudstore.DirContents{}, err
+			}
+
+			return d.dirs.List(r.Context(), d.cursors, userID, chi.URLParam(r, "id"), opts, req)
+		})
+	}
+}
+
+// ContentsPath returns a http.HandlerFunc that writes a directory with a page of its
+// sub directories and files as a JSON response when the directories path is specified
+// as a URL query parameter with the key "path". The page is controlled by the same query
+// parameters as Entries.
+//
+// ContentsPath expects the user ID to be in the request context. To set the user ID in
+// the request context, use reqinfo.SetUser.
+func (d *Directory) ContentsPath() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.contents(w, r, func(userID string) (cloudstore.DirContents, error) {
+			opts, req, err := parseEntriesRequest(r)
+			if err != nil {
+				return cloudstore.DirContents{}, err
+			}
+
+			return d.dirs.ListPath(r.Context(), d.cursors, userID, r.URL.Query().Get("path"), opts, req)
+		})
+	}
+}
+
+// contents is a modified http handler for writing the contents of a directory. The
+// function, contentsFunc, will be passed the user ID of the user making the request
+// and should return the contents of the directory.
+func (d *Directory) contents(w http.ResponseWriter, r *http.Request, contentsFunc func(string) (cloudstore.DirContents, error)) {
+	userID, ok := auth.MustUserID(w, r)
+	if !ok {
+		return
+	}
+
+	contents, err := contentsFunc(userID)
+	if err != nil {
+		app.WriteJSONError(w, err)
+		d.log.Printf("[ERROR] [%s %s] Failed getting directory contents: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	resp, err := json.Marshal(newDirContentsResponseFrom(contents))
+	if err != nil {
+		app.WriteJSONError(w, err)
+		d.log.Printf("[ERROR] [%s %s] Failed marshalling response: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// Rename returns a http.HandlerFunc that handles renaming a directory when the directory
+// ID is apart of the URL path. The new name is read from the "name" field of the JSON
+// request body, see parseRenameRequest. The renamed directory is written as JSON.
+//
+// Rename expects the user ID to be in the request context. To set the user ID in the
+// request context, use reqinfo.SetUser.
+func (d *Directory) Rename() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		name, err := parseRenameRequest(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		dir, err := d.dirs.Rename(r.Context(), userID, chi.URLParam(r, "id"), name)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed renaming directory: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		d.writeDir(w, r, dir)
+	}
+}
+
+// Move returns a http.HandlerFunc that handles moving a directory, with every directory
+// and file under it, when the directory ID is apart of the URL path. The ID of the new
+// parent is read from the "parent_id" field of the JSON request body. The moved
+// directory is written as JSON.
+//
+// Move expects the user ID to be in the request context. To set the user ID in the
+// request context, use reqinfo.SetUser.
+func (d *Directory) Move() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			ParentID *string `json:"parent_id"`
+		}
+		if err := decodeJSON(r, &body); err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		if body.ParentID == nil {
+			app.WriteJSONError(w, requiredField("parent_id"))
+			return
+		}
+
+		dir, err := d.dirs.Move(r.Context(), userID, chi.URLParam(r, "id"), *body.ParentID)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed moving directory: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		d.writeDir(w, r, dir)
+	}
+}
+
+// deletePlanResponse is the response body of a directory delete dry run in JSON format.
+type deletePlanResponse struct {
+	DryRun      bool   `json:"dry_run"`
+	ID          string `json:"id"`
+	Path        string `json:"path"`
+	Directories int    `json:"directories"`
+	Files       int    `json:"files"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// newDeletePlanResponseFrom converts a cloudstore.DeletePlan to a deletePlanResponse.
+func newDeletePlanResponseFrom(plan cloudstore.DeletePlan) deletePlanResponse {
+	return deletePlanResponse{
+		DryRun:      true,
+		ID:          plan.Dir.ID,
+		Path:        plan.Dir.Path,
+		Directories: plan.Dirs,
+		Files:       plan.Files,
+		Bytes:       plan.Bytes,
+	}
+}
+
+// Delete returns a http.HandlerFunc that handles deleting a directory, with every
+// directory and file under it, when the directory ID is apart of the URL path. A 204
+// No Content is written on success.
+//
+// If the "idempotent" query parameter is true, deleting a directory that does not
+// exist also succeeds, see parseIdempotent. If the "dry_run" query parameter is true,
+// nothing is deleted and what would be deleted is written as JSON, see
+// cloudstore.DirService.PlanDelete.
+//
+// Delete expects the user ID to be in the request context. To set the user ID in the
+// request context, use reqinfo.SetUser.
+func (d *Directory) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		idempotent, err := parseIdempotent(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		dryRun, err := parseDryRun(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		dirID := chi.URLParam(r, "id")
+		if dryRun {
+			d.planDelete(w, r, userID, dirID)
+			return
+		}
+
+		if err := d.dirs.Delete(r.Context(), userID, dirID); err != nil {
+			if deletedIdempotent(w, idempotent, err, cloudstore.ErrNotFound, dirID) {
+				return
+			}
+
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed deleting directory: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// planDelete writes what deleting the directory dirID of the user would delete as JSON.
+func (d *Directory) planDelete(w http.ResponseWriter, r *http.Request, userID string, dirID string) {
+	plan, err := d.dirs.PlanDelete(r.Context(), userID, dirID)
+	if err != nil {
+		app.WriteJSONError(w, err)
+		d.log.Printf("[ERROR] [%s %s] Failed planning directory delete: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	resp, err := json.Marshal(newDeletePlanResponseFrom(plan))
+	if err != nil {
+		app.WriteJSONError(w, err)
+		d.log.Printf("[ERROR] [%s %s] Failed marshalling response: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// The response body of the directory info.
+type dirInfoResponse struct {
+	newDirResponse
+	TreeETag string `json:"tree_etag"`
+}
+
+// newDirInfoResponseFrom converts a cloudstore.DirInfo to a dirInfoResponse.
+func newDirInfoResponseFrom(info cloudstore.DirInfo) dirInfoResponse {
+	return dirInfoResponse{
+		newDirResponse: newDirResponseFrom(info.Dir),
+		TreeETag:       info.TreeETag,
+	}
+}
+
+// Info returns a http.HandlerFunc that writes a directory and its tree ETag as a
+// JSON response when the directory ID is apart of the URL path.
+//
+// The Last-Modified header is set to the directories last write time and the ETag
+// header is set to the tree ETag. If the request If-None-Match header matches the
+// tree ETag, a 304 Not Modified is written.
+//
+// Info expects the user ID to be in the request context. To set the user ID in the
+// request context, use reqinfo.SetUser.
+func (d *Directory) Info() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		info, err := d.dirs.Info(r.Context(), userID, chi.URLParam(r, "id"))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed getting directory info: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		lastModified := info.LastWrite
+		if lastModified.IsZero() {
+			lastModified = info.CreatedAt
+		}
+
+		etag := `"` + info.TreeETag + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		resp, err := json.Marshal(newDirInfoResponseFrom(info))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
+
+// parseEntriesRequest parses the "sort", "order", and "include" query parameters of r with
+// cloudstore.ParseListOptions, and the "cursor" and "limit" query parameters with
+// pagination.ParseRequest.
+//
+// All errors returned are a app.WrappedSafeError.
+func parseEntriesRequest(r *http.Request) (cloudstore.ListOptions, pagination.Request, error) {
+	opts, err := cloudstore.ParseListOptions(r.URL.Query())
+	if err != nil {
+		return cloudstore.ListOptions{}, pagination.Request{}, err
+	}
+
+	req, err := pagination.ParseRequest(r, 50, 500)
+	if err != nil {
+		return cloudstore.ListOptions{}, pagination.Request{}, err
+	}
+
+	return opts, req, nil
+}
+
+// Entries returns a http.HandlerFunc that writes a page of the sub directories and files of
+// the directory {id} as a JSON pagination.Page. The listing is controlled by the "sort",
+// "order", "include", "cursor", and "limit" query parameters.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (d *Directory) Entries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		opts, req, err := parseEntriesRequest(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		page, err := d.dirs.ListEntriesPage(r.Context(), d.cursors, userID, chi.URLParam(r, "id"), opts, req)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed listing directory entries: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp, err := json.Marshal(newEntryPageFrom(page))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			d.log.Printf("[ERROR] [%s %s] Failed marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/pagination"
+)
+
+// testDir is a directory that was written, its LastWrite is set.
+var testDir = cloudstore.Dir{
+	ID:         "6f0c4a9e-3b1d-4f5e-9a7c-2d8e1b0f4c6a",
+	Owner:      "3a7e9c1b-5d2f-4e8a-b6c0-1f9d7e3a5b2c",
+	ParentID:   "9b2d6f8a-1c4e-4a3b-8d7f-5e0c2a9b6d1e",
+	Name:       "photos",
+	Path:       "/photos",
+	CreatedAt:  testCreatedAt,
+	UpdatedAt:  testUpdatedAt,
+	LastWrite:  testUpdatedAt,
+	CreatedVia: cloudstore.CreatedExplicit,
+}
+
+func TestNewDirResponseGolden(t *testing.T) {
+	// A new directory was never written, its last write is null.
+	dir := testDir
+	dir.LastWrite = time.Time{}
+
+	body, err := marshalNewDirResponse(dir)
+	if err != nil {
+		t.Fatalf("marshalNewDirResponse() error = %v", err)
+	}
+
+	assertGoldenBody(t, "dir_new", body)
+}
+
+func TestDirInfoResponseGolden(t *testing.T) {
+	assertGolden(t, "dir_info", newDirInfoResponseFrom(cloudstore.DirInfo{
+		Dir:      testDir,
+		TreeETag: "d41d8cd98f00b204e9800998ecf8427e",
+	}))
+}
+
+func TestDeletePlanResponseGolden(t *testing.T) {
+	assertGolden(t, "dir_delete_plan", newDeletePlanResponseFrom(cloudstore.DeletePlan{
+		Dir:   testDir,
+		Dirs:  3,
+		Files: 12,
+		Bytes: 48213,
+	}))
+}
+
+func TestDirContentsResponseGolden(t *testing.T) {
+	size := int64(2048)
+
+	tests := []struct {
+		name     string
+		contents cloudstore.DirContents
+	}{
+		{
+			name: "dir_contents",
+			contents: cloudstore.DirContents{
+				Dir: testDir,
+				Entries: pagination.Page[cloudstore.Entry]{
+					Items: []cloudstore.Entry{
+						{
+							Type:       cloudstore.EntryDir,
+							ID:         "2c8f4e6a-9d1b-4b3c-a5e7-0f2d8c6b4a9e",
+							Name:       "2024",
+							Path:       "/photos/2024",
+							CreatedAt:  testCreatedAt,
+							ModifiedAt: testUpdatedAt,
+						},
+						{
+							Type:             cloudstore.EntryFile,
+							ID:               "7e1a3c5b-8f2d-4e6a-9b0c-4d7f1e3a8c5b",
+							Name:             "beach.jpg",
+							Path:             "/photos/beach.jpg",
+							Size:             &size,
+							CreatedAt:        testCreatedAt,
+							ModifiedAt:       testCreatedAt,
+							ClientModifiedAt: testUpdatedAt,
+						},
+						{
+							Type:           cloudstore.EntryFile,
+							ID:             "5b9d2f7e-4a6c-4c1e-8f3b-6a0e9d2c7f4b",
+							Name:           "lost.jpg",
+							Path:           "/photos/lost.jpg",
+							Size:           &size,
+							CreatedAt:      testCreatedAt,
+							ModifiedAt:     testCreatedAt,
+							ContentMissing: true,
+						},
+					},
+					NextCursor: "eyJuIjoibG9zdC5qcGcifQ",
+					HasMore:    true,
+				},
+			},
+		},
+		{
+			name: "dir_contents_empty",
+			contents: cloudstore.DirContents{
+				Dir:     testDir,
+				Entries: pagination.Page[cloudstore.Entry]{Items: []cloudstore.Entry{}},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assertGolden(t, tc.name, newDirContentsResponseFrom(tc.contents))
+		})
+	}
+}
+
+func TestParseNewDirRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantName  string
+		wantMsg   string
+		wantField string
+	}{
+		{name: "valid", body: `{"name":"photos"}`, wantName: "photos"},
+		{name: "empty body", body: "", wantMsg: "Request body is required"},
+		{name: "null body", body: "null", wantMsg: "Request body is required"},
+		{name: "absent name", body: `{}`, wantMsg: "name is required", wantField: "name"},
+		{name: "null name", body: `{"name":null}`, wantMsg: "name is required", wantField: "name"},
+		{name: "empty name", body: `{"name":""}`, wantMsg: "name cannot be empty", wantField: "name"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/dir", strings.NewReader(tc.body))
+
+			got, err := parseNewDirRequest(r)
+			if tc.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("parseNewDirRequest() error = %v", err)
+				}
+
+				if got.Name != tc.wantName {
+					t.Errorf("name = %q, want %q", got.Name, tc.wantName)
+				}
+				return
+			}
+
+			var safeErr *app.WrappedSafeError
+			if !errors.As(err, &safeErr) {
+				t.Fatalf("parseNewDirRequest() error = %v, want a app.WrappedSafeError", err)
+			}
+
+			if msg, status := safeErr.Safe(); status != http.StatusBadRequest || msg != tc.wantMsg {
+				t.Errorf("parseNewDirRequest() error = %d %q, want %d %q", status, msg, http.StatusBadRequest, tc.wantMsg)
+			}
+
+			if field := safeErr.Field(); field != tc.wantField {
+				t.Errorf("field = %q, want %q", field, tc.wantField)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// parseDryRun parses the "dry_run" query parameter of a request. If it is true, the request
+// writes what it would change without changing anything. If it is not set, false is
+// returned.
+func parseDryRun(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		return false, nil
+	}
+
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid dry_run: %q", v),
+			SafeMessage: "dry_run must be true or false",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "dry_run",
+		})
+	}
+
+	return dryRun, nil
+}
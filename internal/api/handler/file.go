@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"time"
@@ -13,28 +14,47 @@ import (
 	"github.com/cicconee/clox/internal/api/auth"
 	"github.com/cicconee/clox/internal/app"
 	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/operation"
 	"github.com/go-chi/chi/v5"
 )
 
 type File struct {
 	files *cloudstore.FileService
+	dirs  *cloudstore.DirService
+	ops   *operation.Service
 	log   *log.Logger
 }
 
-func NewFile(files *cloudstore.FileService, log *log.Logger) *File {
-	return &File{files: files, log: log}
+func NewFile(files *cloudstore.FileService, dirs *cloudstore.DirService, ops *operation.Service, log *log.Logger) *File {
+	return &File{files: files, dirs: dirs, ops: ops, log: log}
 }
 
 // uploadFileResponse encapsulates the result of a file upload operation
 // in JSON format.
 type uploadFileResponse struct {
-	ID          string    `json:"id"`
-	OwnerID     string    `json:"owner_id"`
-	DirectoryID string    `json:"directory_id"`
-	Name        string    `json:"file_name"`
-	Path        string    `json:"file_path"`
-	Size        int64     `json:"file_size"`
-	UploadedAt  time.Time `json:"uploaded_at"`
+	ID          string   `json:"id"`
+	OwnerID     string   `json:"owner_id"`
+	DirectoryID string   `json:"directory_id"`
+	Name        string   `json:"file_name"`
+	Path        string   `json:"file_path"`
+	Size        int64    `json:"file_size"`
+	UploadedAt  app.Time `json:"uploaded_at"`
+	ModifiedAt  app.Time `json:"client_modified_at"`
+	Warning     string   `json:"warning,omitempty"`
+}
+
+// newFileResponseFrom converts a cloudstore.FileInfo into a uploadFileResponse.
+func newFileResponseFrom(f cloudstore.FileInfo) uploadFileResponse {
+	return uploadFileResponse{
+		ID:          f.ID,
+		OwnerID:     f.OwnerID,
+		DirectoryID: f.DirectoryID,
+		Name:        f.Name,
+		Path:        f.Path,
+		Size:        f.Size,
+		UploadedAt:  app.NewTime(f.UploadedAt),
+		ModifiedAt:  app.NewTime(f.ClientModifiedAt),
+	}
 }
 
 // uploadErrorResponse encapsulates a failed file upload operation in JSON
@@ -45,20 +65,29 @@ type uploadErrorResponse struct {
 	Error    string `json:"error"`
 }
 
+// uploadDirResponse encapsulates the directory the files of a batch file
+// upload were saved in, in JSON format.
+type uploadDirResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
 // uploadResponse represents the response body of a batch file upload
 // operation in JSON format.
 type uploadResponse struct {
-	Uploads []uploadFileResponse  `json:"uploads"`
-	Errors  []uploadErrorResponse `json:"errors"`
+	OperationID string                `json:"operation_id,omitempty"`
+	Directory   uploadDirResponse     `json:"directory"`
+	Uploads     []uploadFileResponse  `json:"uploads"`
+	Errors      []uploadErrorResponse `json:"errors"`
 }
 
-// marshalUploadResponse converts a []cloudstore.BatchSave into a
-// uploadResponse and marshals it to a []byte to serve as a JSON response
-// body.
-func marshalUploadResponse(r []cloudstore.BatchSave) ([]byte, error) {
+// marshalUploadResponse converts a cloudstore.Batch into a uploadResponse
+// and marshals it to a []byte to serve as a JSON response body.
+func marshalUploadResponse(operationID string, r cloudstore.Batch) ([]byte, error) {
 	uploads := []uploadFileResponse{}
 	errors := []uploadErrorResponse{}
-	for _, b := range r {
+	for _, b := range r.Saves {
 		if b.Err != nil {
 			errors = append(errors, uploadErrorResponse{
 				FileName: b.Name,
@@ -73,12 +102,20 @@ func marshalUploadResponse(r []cloudstore.BatchSave) ([]byte, error) {
 				Name:        b.Name,
 				Path:        b.Path,
 				Size:        b.Size,
-				UploadedAt:  b.UploadedAt.UTC(),
+				UploadedAt:  app.NewTime(b.UploadedAt),
+				ModifiedAt:  app.NewTime(b.ClientModifiedAt),
+				Warning:     b.Warning,
 			})
 		}
 	}
 
 	return json.Marshal(&uploadResponse{
+		OperationID: operationID,
+		Directory: uploadDirResponse{
+			ID:   r.Dir.ID,
+			Name: r.Dir.Name,
+			Path: r.Dir.Path,
+		},
 		Uploads: uploads,
 		Errors:  errors,
 	})
@@ -88,76 +125,185 @@ func marshalUploadResponse(r []cloudstore.BatchSave) ([]byte, error) {
 // a specified directory when the directory ID is apart of the URL path.
 //
 // Upload expects the user ID to be in the request context. To set the user ID in
-// the request context, use auth.SetUserIDContext.
+// the request context, use reqinfo.SetUser.
 func (f *File) Upload() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		f.upload(w, r, func(ctx context.Context, userID string, fileHeaders []*multipart.FileHeader) ([]cloudstore.BatchSave, error) {
-			return f.files.SaveBatch(ctx, userID, chi.URLParam(r, "id"), fileHeaders)
+		f.upload(w, r, func(ctx context.Context, userID string, fileHeaders []*multipart.FileHeader, mtimes cloudstore.ClientModTimes) (cloudstore.Batch, error) {
+			return f.files.SaveBatch(ctx, userID, chi.URLParam(r, "id"), fileHeaders, mtimes)
 		})
 	}
 }
 
 // UploadPath return a http.HandlerFunc that handles uploading 1 or many files to
 // a specified directory when the when the directories path is specified as a URL
-// query parameter with the key "path".
+// query parameter with the key "path". If the "base_id" query parameter is set, the
+// path is relative to that directory instead of the users root directory.
 //
 // Upload expects the user ID to be in the request context. To set the user ID in
-// the request context, use auth.SetUserIDContext.
+// the request context, use reqinfo.SetUser.
 func (f *File) UploadPath() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		f.upload(w, r, func(ctx context.Context, userID string, fileHeaders []*multipart.FileHeader) ([]cloudstore.BatchSave, error) {
-			return f.files.SaveBatchPath(ctx, userID, r.URL.Query().Get("path"), fileHeaders)
+		f.upload(w, r, func(ctx context.Context, userID string, fileHeaders []*multipart.FileHeader, mtimes cloudstore.ClientModTimes) (cloudstore.Batch, error) {
+			query := r.URL.Query()
+			return f.files.SaveBatchPathRelative(ctx, userID, query.Get("base_id"), query.Get("path"), fileHeaders, mtimes)
+		})
+	}
+}
+
+// UploadInbox return a http.HandlerFunc that handles uploading 1 or many files to
+// the users default upload directory. If the user has not set a default upload
+// directory, a "Inbox" directory is created under their root directory.
+//
+// UploadInbox expects the user ID to be in the request context. To set the user ID
+// in the request context, use reqinfo.SetUser.
+func (f *File) UploadInbox() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.upload(w, r, func(ctx context.Context, userID string, fileHeaders []*multipart.FileHeader, mtimes cloudstore.ClientModTimes) (cloudstore.Batch, error) {
+			inbox, err := f.dirs.Inbox(ctx, userID)
+			if err != nil {
+				return cloudstore.Batch{}, err
+			}
+
+			return f.files.SaveBatch(ctx, userID, inbox.ID, fileHeaders, mtimes)
 		})
 	}
 }
 
 // saveBatchFunc is passed the user ID of the user making a http request to upload
 // files. All the files in []*multipart.FileHeader should be saved to the users storage
-// location on the server. The result of all the file write operations should be
-// returned as a []cloudstore.BatchSave.
-type saveBatchFunc func(ctx context.Context, userID string, fileHeaders []*multipart.FileHeader) ([]cloudstore.BatchSave, error)
+// location on the server with the modification times in mtimes. The result of all the
+// file write operations and the directory they were saved in should be returned as a
+// cloudstore.Batch.
+type saveBatchFunc func(ctx context.Context, userID string, fileHeaders []*multipart.FileHeader, mtimes cloudstore.ClientModTimes) (cloudstore.Batch, error)
+
+// mtimeHeader is the request header a client declares the modification time of a
+// single uploaded file with.
+const mtimeHeader = "X-Clox-Mtime"
+
+// parseMtimes parses the modification times a client declared for the files it is
+// uploading. They are read from the "file_mtimes" form field, a JSON object of file
+// names to RFC3339 timestamps. When a single file is uploaded, the mtimeHeader may be
+// used instead. If the form field also declares the time of the file, the form field
+// is used.
+func parseMtimes(r *http.Request, fileHeaders []*multipart.FileHeader) (cloudstore.ClientModTimes, error) {
+	now := time.Now()
+
+	mtimes, err := cloudstore.ParseClientModTimes(r.FormValue("file_mtimes"), now, "file_mtimes")
+	if err != nil {
+		return nil, err
+	}
+
+	value := r.Header.Get(mtimeHeader)
+	if value == "" {
+		return mtimes, nil
+	}
+
+	if len(fileHeaders) != 1 {
+		return nil, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("%s header set when uploading %d files", mtimeHeader, len(fileHeaders)),
+			SafeMessage: fmt.Sprintf("%s header can only be used when uploading a single file, use file_mtimes instead", mtimeHeader),
+			StatusCode:  http.StatusBadRequest,
+			Field:       mtimeHeader,
+		})
+	}
+
+	t, err := cloudstore.ParseClientModTime(value, now, mtimeHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fileHeaders[0].Filename
+	if _, ok := mtimes[name]; !ok {
+		if mtimes == nil {
+			mtimes = cloudstore.ClientModTimes{}
+		}
+
+		mtimes[name] = t
+	}
+
+	return mtimes, nil
+}
+
+// parseUploadForm parses the multipart/form-data body of a upload request. All errors
+// returned are a 400 app.WrappedSafeError.
+func parseUploadForm(r *http.Request) error {
+	err := r.ParseMultipartForm(10 << 20)
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, http.ErrNotMultipart) {
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid Content-Type header: %w", err),
+			SafeMessage: "Request Content-Type must be multipart/form-data for file uploads",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	if errors.Is(err, http.ErrMissingBoundary) {
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("request Content-Type=multipar/formdata missing boundary: %w", err),
+			SafeMessage: "Request header Content-Type=multipart/form-data must include a boundary",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("malformed request: %w", err),
+		SafeMessage: "Malformed request",
+		StatusCode:  http.StatusBadRequest,
+	})
+}
 
 // upload is a modified http handler for uploading files. The saveBatchFunc is passed
 // the user ID of the user making the request and all the files they are uploading. The
 // function should save the files to the users storage location on the server and
-// return the result of all the write operations as a []cloudstore.BatchSave.
+// return the result of all the write operations as a cloudstore.Batch.
 func (f *File) upload(w http.ResponseWriter, r *http.Request, saveBatch saveBatchFunc) {
-	userID := auth.GetUserIDContext(r.Context())
-
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		if errors.Is(err, http.ErrNotMultipart) {
-			err = app.Wrap(app.WrapParams{
-				Err:         fmt.Errorf("invalid Content-Type header: %w", err),
-				SafeMessage: "Request Content-Type must be multipart/form-data for file uploads",
-				StatusCode:  http.StatusBadRequest,
-			})
-		} else if errors.Is(err, http.ErrMissingBoundary) {
-			err = app.Wrap(app.WrapParams{
-				Err:         fmt.Errorf("request Content-Type=multipar/formdata missing boundary: %w", err),
-				SafeMessage: "Request header Content-Type=multipart/form-data must include a boundary",
-				StatusCode:  http.StatusBadRequest,
-			})
-		} else {
-			err = app.Wrap(app.WrapParams{
-				Err:         fmt.Errorf("malformed request: %w", err),
-				SafeMessage: "Malformed request",
-				StatusCode:  http.StatusBadRequest,
-			})
-		}
+	userID, ok := auth.MustUserID(w, r)
+	if !ok {
+		return
+	}
 
+	if err := parseUploadForm(r); err != nil {
 		app.WriteJSONError(w, err)
 		f.log.Printf("[ERROR] %v\n", err)
 		return
 	}
 
-	result, err := saveBatch(r.Context(), userID, r.MultipartForm.File["file_uploads"])
+	fileHeaders := r.MultipartForm.File["file_uploads"]
+
+	mtimes, err := parseMtimes(r, fileHeaders)
+	if err != nil {
+		app.WriteJSONError(w, err)
+		f.log.Printf("[ERROR] [%s %s] Parsing modification times: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	names := []string{}
+	for _, header := range fileHeaders {
+		names = append(names, header.Filename)
+	}
+
+	run, ok := startOperation(w, r, f.ops, f.log, userID, operation.TypeUpload, map[string]any{
+		"path":  r.URL.Path,
+		"query": r.URL.RawQuery,
+		"files": names,
+	})
+	if !ok {
+		return
+	}
+
+	result, err := saveBatch(r.Context(), userID, fileHeaders, mtimes)
+	run.Progress(r.Context(), int64(len(result.Saves)), countFailed(result.Saves))
+	run.Finish(r.Context(), err)
 	if err != nil {
 		app.WriteJSONError(w, err)
 		f.log.Printf("[ERROR] [%s %s] Failed to save files: %v\n", r.Method, r.URL.Path, err)
 		return
 	}
 
-	resp, err := marshalUploadResponse(result)
+	resp, err := marshalUploadResponse(run.ID(), result)
 	if err != nil {
 		app.WriteJSONError(w, err)
 		f.log.Printf("[ERROR] [%s %s] Failed marshalling response: %v\n", r.Method, r.URL.Path, err)
@@ -169,14 +315,29 @@ func (f *File) upload(w http.ResponseWriter, r *http.Request, saveBatch saveBatc
 	w.Write(resp)
 }
 
+// countFailed counts the files of a batch that failed to save.
+func countFailed(result []cloudstore.BatchSave) int64 {
+	var n int64
+	for _, b := range result {
+		if b.Err != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
 // Download returns a http.HandlerFunc that handles downloading a file when the
 // file ID is apart of the URL path.
 //
 // Download expects the user ID to be in the request context. To set the user ID in
-// the request context, use auth.SetUserIDContext.
+// the request context, use reqinfo.SetUser.
 func (f *File) Download() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID := auth.GetUserIDContext(r.Context())
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
 		fileID := chi.URLParam(r, "id")
 
 		file, err := f.files.Info(r.Context(), userID, fileID)
@@ -186,9 +347,7 @@ func (f *File) Download() http.HandlerFunc {
 			return
 		}
 
-		w.Header().Set("Content-Disposition", "attachment; filename="+file.Name)
-		w.Header().Set("Content-Type", "application/octet-stream")
-		http.ServeFile(w, r, file.FSPath)
+		f.serve(w, r, file)
 	}
 }
 
@@ -196,10 +355,13 @@ func (f *File) Download() http.HandlerFunc {
 // the file path is specified as a URL query parameter with the key "path".
 //
 // DownloadPath expects the user ID to be in the request context. To set the user
-// ID in the request context, use auth.SetUserIDContext.
+// ID in the request context, use reqinfo.SetUser.
 func (f *File) DownloadPath() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID := auth.GetUserIDContext(r.Context())
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
 		path := r.URL.Query().Get("path")
 
 		file, err := f.files.InfoPath(r.Context(), userID, path)
@@ -209,8 +371,323 @@ func (f *File) DownloadPath() http.HandlerFunc {
 			return
 		}
 
-		w.Header().Set("Content-Disposition", "attachment; filename="+file.Name)
-		w.Header().Set("Content-Type", "application/octet-stream")
-		http.ServeFile(w, r, file.FSPath)
+		f.serve(w, r, file)
+	}
+}
+
+// Delete returns a http.HandlerFunc that handles deleting a file when the file ID is
+// apart of the URL path. A 204 No Content is written on success.
+//
+// If the "idempotent" query parameter is true, deleting a file that does not exist
+// also succeeds, see parseIdempotent.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (f *File) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		idempotent, err := parseIdempotent(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		fileID := chi.URLParam(r, "id")
+		if err := f.files.Delete(r.Context(), userID, fileID); err != nil {
+			if deletedIdempotent(w, idempotent, err, cloudstore.ErrNotFound, fileID) {
+				return
+			}
+
+			app.WriteJSONError(w, err)
+			f.log.Printf("[ERROR] [%s %s] Failed deleting file: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// parseRenameRequest parses the new name of a file or directory from the request body,
+// a JSON object with a "name" field. The name must be present, it is validated by the
+// service renaming the file or directory.
+//
+// parseRenameRequest does not close r.Body.
+func parseRenameRequest(r *http.Request) (string, error) {
+	var body struct {
+		Name *string `json:"name"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return "", err
+	}
+
+	if body.Name == nil {
+		return "", requiredField("name")
+	}
+
+	return *body.Name, nil
+}
+
+// Rename returns a http.HandlerFunc that handles renaming a file when the file ID is
+// apart of the URL path. The new name is read from the "name" field of the JSON request
+// body. The renamed file is written as JSON.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (f *File) Rename() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		name, err := parseRenameRequest(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		file, err := f.files.Rename(r.Context(), userID, chi.URLParam(r, "id"), name)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			f.log.Printf("[ERROR] [%s %s] Failed renaming file: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp, err := json.Marshal(newFileResponseFrom(file))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			f.log.Printf("[ERROR] [%s %s] Failed marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
+
+// The request body when moving a file. The target directory is Path if ByPath is true,
+// otherwise it is DirectoryID.
+type moveFileRequest struct {
+	DirectoryID string
+	Path        string
+	ByPath      bool
+}
+
+// parseMoveRequest parses the request body into a moveFileRequest. The target directory
+// is either the "directory_id" or the "path" field, exactly one of them must be present.
+//
+// parseMoveRequest does not close r.Body.
+func parseMoveRequest(r *http.Request) (moveFileRequest, error) {
+	var body struct {
+		DirectoryID *string `json:"directory_id"`
+		Path        *string `json:"path"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return moveFileRequest{}, err
+	}
+
+	return parseMoveTarget(body.DirectoryID, body.Path)
+}
+
+// parseMoveTarget returns the target directory of a move from the "directory_id" and
+// "path" fields of a request body, exactly one of them must be present.
+func parseMoveTarget(directoryID *string, path *string) (moveFileRequest, error) {
+	switch {
+	case directoryID != nil && path != nil:
+		return moveFileRequest{}, app.Wrap(app.WrapParams{
+			Err:         errors.New("both directory_id and path are set"),
+			SafeMessage: "directory_id and path cannot both be set",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "path",
+		})
+	case directoryID != nil:
+		return moveFileRequest{DirectoryID: *directoryID}, nil
+	case path != nil:
+		return moveFileRequest{Path: *path, ByPath: true}, nil
+	default:
+		return moveFileRequest{}, requiredField("directory_id")
+	}
+}
+
+// Move returns a http.HandlerFunc that handles moving a file to another directory when
+// the file ID is apart of the URL path. The target directory is read from the JSON
+// request body, see parseMoveRequest. The moved file is written as JSON.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (f *File) Move() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		request, err := parseMoveRequest(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		fileID := chi.URLParam(r, "id")
+
+		var file cloudstore.FileInfo
+		if request.ByPath {
+			file, err = f.files.MovePath(r.Context(), userID, fileID, request.Path)
+		} else {
+			file, err = f.files.Move(r.Context(), userID, fileID, request.DirectoryID)
+		}
+		if err != nil {
+			app.WriteJSONError(w, err)
+			f.log.Printf("[ERROR] [%s %s] Failed moving file: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp, err := json.Marshal(newFileResponseFrom(file))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			f.log.Printf("[ERROR] [%s %s] Failed marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
+
+// serve writes the content of file as an attachment. The Last-Modified header is set to
+// the modification time the client declared when uploading the file.
+func (f *File) serve(w http.ResponseWriter, r *http.Request, file cloudstore.FileInfo) {
+	content, err := f.files.Open(r.Context(), file)
+	if err != nil {
+		app.WriteJSONError(w, err)
+		f.log.Printf("[ERROR] [%s %s] Failed opening file: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Disposition", "attachment; filename="+file.Name)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", file.ClientModifiedAt, content)
+}
+
+// Preview returns a http.HandlerFunc that streams a file inline when the file ID is apart
+// of the URL path. Video, audio, and image files are served with their content type and
+// byte range support, so a browser can seek through them. Every other file is served as
+// an attachment, the same as Download.
+//
+// Each range request is served as its own request, so only the bytes of the range are
+// counted as downloaded.
+//
+// Preview expects the user ID to be in the request context. To set the user ID in the
+// request context, use reqinfo.SetUser.
+func (f *File) Preview() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		file, err := f.files.Info(r.Context(), userID, chi.URLParam(r, "id"))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			f.log.Printf("[ERROR] [%s %s] Failed getting file info: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		contentType, inline := file.PreviewType()
+		if !inline {
+			f.serve(w, r, file)
+			return
+		}
+
+		content, err := f.files.Open(r.Context(), file)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			f.log.Printf("[ERROR] [%s %s] Failed opening file: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+		defer content.Close()
+
+		// The ETag lets a browser resume ranges with If-Range once the content is recorded.
+		// The file may be overwritten, so every cached copy is revalidated.
+		if file.Checksum != "" {
+			w.Header().Set("ETag", `"`+file.Checksum+`"`)
+		}
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": file.Name}))
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, "", file.ClientModifiedAt, content)
+	}
+}
+
+// Search returns a http.HandlerFunc that writes the files of the user matching the "q" query
+// parameter as a JSON response. If the "content" query parameter is true, the text of the
+// indexed text files is searched and every hit has a snippet and rank, otherwise file names
+// are searched. The number of hits is set with the "limit" query parameter.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (f *File) Search() http.HandlerFunc {
+	type hit struct {
+		ID          string   `json:"id"`
+		DirectoryID string   `json:"directory_id"`
+		Name        string   `json:"file_name"`
+		Path        string   `json:"file_path"`
+		Size        int64    `json:"file_size"`
+		UploadedAt  app.Time `json:"uploaded_at"`
+		Snippet     string   `json:"snippet,omitempty"`
+		Rank        float64  `json:"rank,omitempty"`
+	}
+
+	type response struct {
+		Hits []hit `json:"hits"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		params, err := cloudstore.ParseSearch(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		hits, err := f.files.Search(r.Context(), userID, params)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			f.log.Printf("[ERROR] [%s %s] Searching files: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp := response{Hits: []hit{}}
+		for _, h := range hits {
+			resp.Hits = append(resp.Hits, hit{
+				ID:          h.ID,
+				DirectoryID: h.DirectoryID,
+				Name:        h.Name,
+				Path:        h.Path,
+				Size:        h.Size,
+				UploadedAt:  app.NewTime(h.UploadedAt),
+				Snippet:     h.Snippet,
+				Rank:        h.Rank,
+			})
+		}
+
+		body, err := json.Marshal(&resp)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			f.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
 	}
 }
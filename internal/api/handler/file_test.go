@@ -0,0 +1,330 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/reqinfo"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func TestUploadResponseGolden(t *testing.T) {
+	batch := cloudstore.Batch{
+		Dir: cloudstore.BatchDir{
+			ID:   testDir.ID,
+			Name: testDir.Name,
+			Path: testDir.Path,
+		},
+		Saves: []cloudstore.BatchSave{
+			{
+				FileInfo: cloudstore.FileInfo{
+					ID:               "7e1a3c5b-8f2d-4e6a-9b0c-4d7f1e3a8c5b",
+					OwnerID:          testDir.Owner,
+					DirectoryID:      testDir.ID,
+					Name:             "beach.jpg",
+					Path:             "/photos/beach.jpg",
+					Size:             2048,
+					UploadedAt:       testCreatedAt,
+					ClientModifiedAt: testUpdatedAt,
+				},
+			},
+			{
+				// The client did not send a modified time.
+				FileInfo: cloudstore.FileInfo{
+					ID:          "1d3f5b7a-9c2e-4f6b-8a0d-3e5c7a9f1b2d",
+					OwnerID:     testDir.Owner,
+					DirectoryID: testDir.ID,
+					Name:        "beach (1).jpg",
+					Path:        "/photos/beach (1).jpg",
+					Size:        2048,
+					UploadedAt:  testUpdatedAt,
+				},
+				Warning: "This file was uploaded many times in a short period.",
+			},
+			{
+				FileInfo: cloudstore.FileInfo{Name: "exists.jpg", Size: 512},
+				Err: app.Wrap(app.WrapParams{
+					Err:         errors.New("file exists"),
+					SafeMessage: "File already exists",
+					StatusCode:  http.StatusConflict,
+				}),
+			},
+			{
+				FileInfo: cloudstore.FileInfo{Name: "broken.jpg", Size: 1024},
+				Err:      errors.New("disk full"),
+			},
+		},
+	}
+
+	body, err := marshalUploadResponse("4f6a8c0e-2b4d-4a6f-9c1e-7b3d5f9a2c4e", batch)
+	if err != nil {
+		t.Fatalf("marshalUploadResponse() error = %v", err)
+	}
+
+	assertGoldenBody(t, "upload", body)
+}
+
+func TestParseMtimes(t *testing.T) {
+	mtime := time.Date(2024, time.March, 1, 8, 30, 0, 0, time.UTC)
+	form := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+	one := []*multipart.FileHeader{{Filename: "a.txt"}}
+	two := []*multipart.FileHeader{{Filename: "a.txt"}, {Filename: "b.txt"}}
+
+	tests := []struct {
+		name    string
+		form    string
+		header  string
+		files   []*multipart.FileHeader
+		want    cloudstore.ClientModTimes
+		wantErr string
+	}{
+		{name: "none", files: one, want: nil},
+		{name: "form", form: `{"b.txt":"2024-02-01T00:00:00Z"}`, files: two, want: cloudstore.ClientModTimes{"b.txt": form}},
+		{name: "header", header: "2024-03-01T08:30:00Z", files: one, want: cloudstore.ClientModTimes{"a.txt": mtime}},
+		{name: "form wins", form: `{"a.txt":"2024-02-01T00:00:00Z"}`, header: "2024-03-01T08:30:00Z", files: one, want: cloudstore.ClientModTimes{"a.txt": form}},
+		{name: "header with many files", header: "2024-03-01T08:30:00Z", files: two, wantErr: mtimeHeader},
+		{name: "invalid header", header: "yesterday", files: one, wantErr: mtimeHeader},
+		{name: "invalid form", form: `{"a.txt":"yesterday"}`, files: one, wantErr: "file_mtimes"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/upload", strings.NewReader(url.Values{"file_mtimes": {tc.form}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.header != "" {
+				r.Header.Set(mtimeHeader, tc.header)
+			}
+
+			got, err := parseMtimes(r, tc.files)
+			if tc.wantErr != "" {
+				var fieldErr app.FieldError
+				if !errors.As(err, &fieldErr) || fieldErr.Field() != tc.wantErr {
+					t.Fatalf("parseMtimes() error = %v, want a error of the %s field", err, tc.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseMtimes() error = %v", err)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("parseMtimes() = %v, want %v", got, tc.want)
+			}
+
+			for name, want := range tc.want {
+				if !got[name].Equal(want) {
+					t.Errorf("parseMtimes()[%s] = %s, want %s", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+// newFileHeader creates a multipart.FileHeader of a file named name with content.
+func newFileHeader(t *testing.T, name string, content string) *multipart.FileHeader {
+	t.Helper()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("files", name)
+	if err != nil {
+		t.Fatalf("creating form file: %v", err)
+	}
+	part.Write([]byte(content))
+	w.Close()
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("reading form: %v", err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+
+	return form.File["files"][0]
+}
+
+// testStorage is a DirService and FileService on the test database and a new temporary
+// file store, with the root directory of a new user.
+type testStorage struct {
+	userID string
+	root   cloudstore.Dir
+	dirs   *cloudstore.DirService
+	files  *cloudstore.FileService
+}
+
+func newTestStorage(t *testing.T) testStorage {
+	t.Helper()
+
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	userID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	discard := log.New(io.Discard, "", 0)
+	store := cloudstore.NewStore(p)
+	pathMap := cloudstore.NewPathMapper(t.TempDir())
+	dirs := cloudstore.NewDirService(cloudstore.DirServiceConfig{Store: store, PathMap: pathMap, Log: discard})
+	files := cloudstore.NewFileService(cloudstore.FileServiceConfig{
+		Store:        store,
+		PathMap:      pathMap,
+		Log:          discard,
+		ValidateUser: dirs.ValidateUser,
+	})
+
+	root, err := dirs.ValidateUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("ValidateUser() error = %v", err)
+	}
+
+	return testStorage{userID: userID, root: root, dirs: dirs, files: files}
+}
+
+// upload uploads a file named name with content to the root directory and returns its ID.
+func (ts testStorage) upload(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	batch, err := ts.files.SaveBatch(context.Background(), ts.userID, ts.root.ID, []*multipart.FileHeader{newFileHeader(t, name, content)}, nil)
+	if err != nil || batch.Saves[0].Err != nil {
+		t.Fatalf("SaveBatch() error = %v, %v", err, batch.Saves[0].Err)
+	}
+
+	return batch.Saves[0].ID
+}
+
+// TestUploadWithoutUser uploads files on routes without the auth middleware. Every upload
+// is rejected before a service is called, so no root directory is created for the empty
+// user ID.
+func TestUploadWithoutUser(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	discard := log.New(io.Discard, "", 0)
+	store := cloudstore.NewStore(p)
+	pathMap := cloudstore.NewPathMapper(t.TempDir())
+	dirs := cloudstore.NewDirService(cloudstore.DirServiceConfig{Store: store, PathMap: pathMap, Log: discard})
+	files := cloudstore.NewFileService(cloudstore.FileServiceConfig{
+		Store:        store,
+		PathMap:      pathMap,
+		Log:          discard,
+		ValidateUser: dirs.ValidateUser,
+	})
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM directories WHERE user_id = ''`) })
+
+	h := NewFile(files, dirs, nil, discard)
+	router := chi.NewRouter()
+	router.Post("/api/upload/inbox", h.UploadInbox())
+	router.Post("/api/upload/{id}", h.Upload())
+	router.Post("/api/upload", h.UploadPath())
+
+	for _, target := range []string{"/api/upload/" + uuid.NewString(), "/api/upload?path=/photos", "/api/upload/inbox"} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, err := mw.CreateFormFile("file_uploads", "beach.jpg")
+		if err != nil {
+			t.Fatalf("creating form file: %v", err)
+		}
+		part.Write([]byte("beach"))
+		mw.Close()
+
+		r := httptest.NewRequest(http.MethodPost, target, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s status = %d, want %d: %s", target, w.Code, http.StatusUnauthorized, w.Body.String())
+		}
+	}
+
+	var n int
+	if err := p.QueryRow(ctx, `SELECT COUNT(*) FROM directories WHERE user_id = ''`).Scan(&n); err != nil {
+		t.Fatalf("counting directories: %v", err)
+	}
+
+	if n != 0 {
+		t.Errorf("directories of the empty user ID = %d, want 0", n)
+	}
+}
+
+func TestFilePreviewRanges(t *testing.T) {
+	ts := newTestStorage(t)
+
+	const content = "0123456789abcdefghij"
+	clip := ts.upload(t, "clip.mp4", content)
+
+	router := chi.NewRouter()
+	router.Get("/api/preview/file/{id}", NewFile(ts.files, ts.dirs, nil, log.New(io.Discard, "", 0)).Preview())
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		want         int
+		body         string
+		contentRange string
+	}{
+		{name: "no range", want: http.StatusOK, body: content},
+		{name: "first bytes", rangeHeader: "bytes=0-3", want: http.StatusPartialContent, body: "0123", contentRange: "bytes 0-3/20"},
+		{name: "open ended", rangeHeader: "bytes=15-", want: http.StatusPartialContent, body: "fghij", contentRange: "bytes 15-19/20"},
+		{name: "suffix", rangeHeader: "bytes=-4", want: http.StatusPartialContent, body: "ghij", contentRange: "bytes 16-19/20"},
+		{name: "past the end", rangeHeader: "bytes=20-", want: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */20"},
+		{name: "reversed", rangeHeader: "bytes=5-2", want: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */20"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/preview/file/"+clip, nil)
+			r = r.WithContext(reqinfo.SetUser(r.Context(), ts.userID))
+			if tc.rangeHeader != "" {
+				r.Header.Set("Range", tc.rangeHeader)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+
+			if got := w.Header().Get("Content-Range"); got != tc.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tc.contentRange)
+			}
+
+			if tc.want == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+
+			if got := w.Body.String(); got != tc.body {
+				t.Errorf("body = %q, want %q", got, tc.body)
+			}
+
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", got)
+			}
+
+			if got := w.Header().Get("Content-Type"); got != "video/mp4" {
+				t.Errorf("Content-Type = %q, want video/mp4", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// update rewrites the golden files with the current responses. Run it after an intended
+// change to a response body:
+//
+//	go test ./internal/api/handler -update
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// The times of the test responses. They are not in UTC so the golden files show the
+// conversion.
+var (
+	testCreatedAt = time.Date(2024, 3, 1, 7, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+	testUpdatedAt = time.Date(2024, 3, 2, 9, 15, 30, 0, time.FixedZone("CET", 60*60))
+)
+
+// assertGolden marshals v as a response body and compares it with the golden file
+// testdata/name.golden.json. If the update flag is set, the golden file is written
+// instead.
+func assertGolden(t *testing.T, name string, v any) {
+	t.Helper()
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshalling response: %v", err)
+	}
+
+	assertGoldenBody(t, name, body)
+}
+
+// assertGoldenBody compares the JSON body with the golden file testdata/name.golden.json.
+// If the update flag is set, the golden file is written instead.
+func assertGoldenBody(t *testing.T, name string, body []byte) {
+	t.Helper()
+
+	var got bytes.Buffer
+	if err := json.Indent(&got, body, "", "  "); err != nil {
+		t.Fatalf("indenting response %s: %v", body, err)
+	}
+	got.WriteByte('\n')
+
+	path := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatalf("creating testdata: %v", err)
+		}
+
+		if err := os.WriteFile(path, got.Bytes(), 0644); err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file, run with -update to create it: %v", err)
+	}
+
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("response %s does not match %s, run with -update if the change is intended\n got: %s\nwant: %s",
+			name, path, got.Bytes(), want)
+	}
+}
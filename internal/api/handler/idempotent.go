@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/google/uuid"
+)
+
+// IdempotentHeader is set to "true" on the response of a idempotent DELETE request for a
+// resource that was already deleted, or never existed.
+const IdempotentHeader = "X-Clox-Idempotent"
+
+// parseIdempotent parses the "idempotent" query parameter of a DELETE request. If it is true,
+// deleting a resource that does not exist succeeds, so a retried delete is not a failure. If
+// it is not set, false is returned.
+func parseIdempotent(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("idempotent")
+	if v == "" {
+		return false, nil
+	}
+
+	idempotent, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid idempotent: %q", v),
+			SafeMessage: "idempotent must be true or false",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "idempotent",
+		})
+	}
+
+	return idempotent, nil
+}
+
+// deletedIdempotent writes a 204 status code with the IdempotentHeader and returns true if
+// err is notFound, idempotent is true, and id is a well-formed UUID. The ID of a resource
+// that could never exist is still not found.
+func deletedIdempotent(w http.ResponseWriter, idempotent bool, err error, notFound error, id string) bool {
+	if !idempotent || !errors.Is(err, notFound) {
+		return false
+	}
+
+	if _, parseErr := uuid.Parse(id); parseErr != nil {
+		return false
+	}
+
+	w.Header().Set(IdempotentHeader, "true")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/reqinfo"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func TestParseIdempotent(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    bool
+		wantErr bool
+	}{
+		{query: "", want: false},
+		{query: "?idempotent=true", want: true},
+		{query: "?idempotent=1", want: true},
+		{query: "?idempotent=false", want: false},
+		{query: "?idempotent=maybe", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "/api/file/a"+tc.query, nil)
+
+			got, err := parseIdempotent(r)
+			if tc.wantErr {
+				var safeErr *app.WrappedSafeError
+				if !errors.As(err, &safeErr) || safeErr.Field() != "idempotent" {
+					t.Errorf("parseIdempotent() error = %v, want a 400 of the idempotent field", err)
+				}
+				return
+			}
+
+			if err != nil || got != tc.want {
+				t.Errorf("parseIdempotent() = %t, %v, want %t", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeletedIdempotent(t *testing.T) {
+	notFound := fmt.Errorf("deleting file: %w", cloudstore.ErrNotFound)
+
+	tests := []struct {
+		name       string
+		idempotent bool
+		err        error
+		id         string
+		want       bool
+	}{
+		{name: "not found", idempotent: true, err: notFound, id: uuid.NewString(), want: true},
+		{name: "default", idempotent: false, err: notFound, id: uuid.NewString()},
+		{name: "other error", idempotent: true, err: errors.New("connection reset"), id: uuid.NewString()},
+		{name: "malformed id", idempotent: true, err: notFound, id: "not-a-uuid"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			if got := deletedIdempotent(w, tc.idempotent, tc.err, cloudstore.ErrNotFound, tc.id); got != tc.want {
+				t.Fatalf("deletedIdempotent() = %t, want %t", got, tc.want)
+			}
+
+			if !tc.want {
+				if len(w.Header()) != 0 || w.Body.Len() != 0 {
+					t.Errorf("response written = %v %q, want nothing", w.Header(), w.Body.String())
+				}
+				return
+			}
+
+			if w.Code != http.StatusNoContent || w.Header().Get(IdempotentHeader) != "true" {
+				t.Errorf("response = %d with %s %q, want 204 with %s true", w.Code, IdempotentHeader, w.Header().Get(IdempotentHeader), IdempotentHeader)
+			}
+		})
+	}
+}
+
+// TestDeleteIdempotent deletes a file and a directory, then retries the delete with and
+// without the idempotent query parameter.
+func TestDeleteIdempotent(t *testing.T) {
+	ts := newTestStorage(t)
+	discard := log.New(io.Discard, "", 0)
+
+	router := chi.NewRouter()
+	router.Delete("/api/file/{id}", NewFile(ts.files, ts.dirs, nil, discard).Delete())
+	router.Delete("/api/dir/{id}", NewDirectory(ts.dirs, nil, discard).Delete())
+
+	dir, err := ts.dirs.New(context.Background(), ts.userID, "photos", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	resources := map[string]string{
+		"file":      "/api/file/" + ts.upload(t, "a.txt", "hello"),
+		"directory": "/api/dir/" + dir.ID,
+	}
+
+	// The steps run in order against the same resource.
+	steps := []struct {
+		name  string
+		query string
+		want  int
+
+		// header is the IdempotentHeader of the response.
+		header string
+	}{
+		{name: "first call", query: "?idempotent=true", want: http.StatusNoContent},
+		{name: "retry", query: "?idempotent=true", want: http.StatusNoContent, header: "true"},
+		{name: "default", want: http.StatusNotFound},
+		{name: "retry not idempotent", query: "?idempotent=false", want: http.StatusNotFound},
+	}
+
+	for name, url := range resources {
+		t.Run(name, func(t *testing.T) {
+			for _, step := range steps {
+				r := httptest.NewRequest(http.MethodDelete, url+step.query, nil)
+				r = r.WithContext(reqinfo.SetUser(r.Context(), ts.userID))
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+
+				if w.Code != step.want {
+					t.Fatalf("%s: status = %d, want %d: %s", step.name, w.Code, step.want, w.Body.String())
+				}
+
+				if got := w.Header().Get(IdempotentHeader); got != step.header {
+					t.Errorf("%s: %s = %q, want %q", step.name, IdempotentHeader, got, step.header)
+				}
+			}
+		})
+	}
+
+	// A ID that could never exist is not found, even when idempotent.
+	r := httptest.NewRequest(http.MethodDelete, "/api/file/not-a-uuid?idempotent=true", nil)
+	r = r.WithContext(reqinfo.SetUser(r.Context(), ts.userID))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("malformed ID status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
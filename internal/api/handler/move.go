@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cloudstore"
+)
+
+type Move struct {
+	moves *cloudstore.MoveService
+	log   *log.Logger
+}
+
+func NewMove(moves *cloudstore.MoveService, log *log.Logger) *Move {
+	return &Move{moves: moves, log: log}
+}
+
+// moveSourceRequest is a source of a bulk move request body.
+type moveSourceRequest struct {
+	// Type is "directory" or "file".
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Path string `json:"path"`
+}
+
+// parseBulkMoveRequest parses the request body of a bulk move into a
+// cloudstore.BulkMove. The "sources" field is required, and the destination is either
+// the "directory_id" or the "path" field, see parseMoveTarget. The sources are validated
+// by cloudstore.MoveService.Move.
+//
+// parseBulkMoveRequest does not close r.Body.
+func parseBulkMoveRequest(r *http.Request) (cloudstore.BulkMove, error) {
+	var body struct {
+		Sources     *[]moveSourceRequest `json:"sources"`
+		DirectoryID *string              `json:"directory_id"`
+		Path        *string              `json:"path"`
+		Atomic      bool                 `json:"atomic"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return cloudstore.BulkMove{}, err
+	}
+
+	if body.Sources == nil {
+		return cloudstore.BulkMove{}, requiredField("sources")
+	}
+
+	target, err := parseMoveTarget(body.DirectoryID, body.Path)
+	if err != nil {
+		return cloudstore.BulkMove{}, err
+	}
+
+	m := cloudstore.BulkMove{DirectoryID: target.DirectoryID, Path: target.Path, Atomic: body.Atomic}
+	if target.ByPath && target.Path == "" {
+		// An empty path is the root directory.
+		m.Path = "/"
+	}
+
+	for _, src := range *body.Sources {
+		var t cloudstore.EntryType
+		switch src.Type {
+		case cloudstore.EntryDir.Name():
+			t = cloudstore.EntryDir
+		case cloudstore.EntryFile.Name():
+			t = cloudstore.EntryFile
+		}
+
+		m.Sources = append(m.Sources, cloudstore.MoveSource{Type: t, ID: src.ID, Path: src.Path})
+	}
+
+	return m, nil
+}
+
+// moveResultResponse is the result of a single source of a bulk move in JSON format.
+type moveResultResponse struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+
+	// ID is the directory or file of the source, and Path its path after the move.
+	ID   string `json:"id,omitempty"`
+	Path string `json:"path,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// moveResponse is the response body of a bulk move in JSON format.
+type moveResponse struct {
+	Atomic  bool                 `json:"atomic"`
+	DryRun  bool                 `json:"dry_run"`
+	Done    int                  `json:"done"`
+	Failed  int                  `json:"failed"`
+	Skipped int                  `json:"skipped"`
+	Results []moveResultResponse `json:"results"`
+}
+
+// newMoveResponseFrom creates a moveResponse from the results of the bulk move m.
+func newMoveResponseFrom(m cloudstore.BulkMove, results []cloudstore.MoveResult) moveResponse {
+	resp := moveResponse{Atomic: m.Atomic, DryRun: m.DryRun, Results: []moveResultResponse{}}
+	for _, result := range results {
+		switch result.Status {
+		case cloudstore.MoveDone:
+			resp.Done++
+		case cloudstore.MoveFailed:
+			resp.Failed++
+		case cloudstore.MoveSkipped:
+			resp.Skipped++
+		}
+
+		resp.Results = append(resp.Results, moveResultResponse{
+			Type:   result.Source.Type.Name(),
+			Status: string(result.Status),
+			ID:     result.ID,
+			Path:   result.Path,
+			Error:  result.Msg(),
+		})
+	}
+
+	return resp
+}
+
+// Apply returns a http.HandlerFunc that moves many directories and files to a single
+// directory, see parseBulkMoveRequest for the JSON request body. Once the sources are
+// moved, the response is 200 with the result of every source in request order, even if
+// some of them failed.
+//
+// If the "dry_run" query parameter is true, nothing is moved and the results are the ones
+// the move would have, see cloudstore.BulkMove.
+//
+// Apply expects the user ID to be in the request context. To set the user ID in the
+// request context, use reqinfo.SetUser.
+func (m *Move) Apply() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		dryRun, err := parseDryRun(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		request, err := parseBulkMoveRequest(r)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+		request.DryRun = dryRun
+
+		results, err := m.moves.Move(r.Context(), userID, request)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			m.log.Printf("[ERROR] [%s %s] Failed moving entries: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		for i, result := range results {
+			if result.Status == cloudstore.MoveFailed {
+				m.log.Printf("[ERROR] [%s %s] Moving source [index: %d, id: %s]: %v\n", r.Method, r.URL.Path, i, result.ID, result.Err)
+			}
+		}
+
+		body, err := json.Marshal(newMoveResponseFrom(request, results))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			m.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cloudstore"
+)
+
+func TestParseBulkMoveRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want cloudstore.BulkMove
+
+		// wantField is the field of the 400 error, or empty if the body is valid.
+		wantField string
+	}{
+		{
+			name: "by id",
+			body: `{"sources":[{"type":"file","id":"a"},{"type":"directory","path":"/photos"}],"directory_id":"b","atomic":true}`,
+			want: cloudstore.BulkMove{
+				Sources: []cloudstore.MoveSource{
+					{Type: cloudstore.EntryFile, ID: "a"},
+					{Type: cloudstore.EntryDir, Path: "/photos"},
+				},
+				DirectoryID: "b",
+				Atomic:      true,
+			},
+		},
+		{
+			name: "root path",
+			body: `{"sources":[{"type":"file","id":"a"}],"path":""}`,
+			want: cloudstore.BulkMove{Sources: []cloudstore.MoveSource{{Type: cloudstore.EntryFile, ID: "a"}}, Path: "/"},
+		},
+		{
+			// The type is validated by cloudstore.MoveService.
+			name: "unknown type",
+			body: `{"sources":[{"type":"d","id":"a"}],"path":"/docs"}`,
+			want: cloudstore.BulkMove{Sources: []cloudstore.MoveSource{{ID: "a"}}, Path: "/docs"},
+		},
+		{name: "no sources", body: `{"directory_id":"b"}`, wantField: "sources"},
+		{name: "no destination", body: `{"sources":[]}`, wantField: "directory_id"},
+		{name: "two destinations", body: `{"sources":[],"directory_id":"b","path":"/"}`, wantField: "path"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/move", strings.NewReader(tc.body))
+
+			got, err := parseBulkMoveRequest(r)
+			if tc.wantField == "" {
+				if err != nil {
+					t.Fatalf("parseBulkMoveRequest() error = %v", err)
+				}
+
+				if !reflect.DeepEqual(got, tc.want) {
+					t.Errorf("parseBulkMoveRequest() = %+v, want %+v", got, tc.want)
+				}
+				return
+			}
+
+			var safeErr *app.WrappedSafeError
+			if !errors.As(err, &safeErr) || safeErr.Field() != tc.wantField {
+				t.Errorf("parseBulkMoveRequest() error = %v, want a 400 of the %q field", err, tc.wantField)
+			}
+		})
+	}
+}
+
+func TestMoveResponseGolden(t *testing.T) {
+	results := []cloudstore.MoveResult{
+		{
+			Source: cloudstore.MoveSource{Type: cloudstore.EntryDir, ID: testDir.ID},
+			Status: cloudstore.MoveDone,
+			ID:     testDir.ID,
+			Path:   "/archive/photos",
+		},
+		{
+			Source: cloudstore.MoveSource{Type: cloudstore.EntryDir, Path: "/archive"},
+			Status: cloudstore.MoveFailed,
+			ID:     "9b2d6f8a-1c4e-4a3b-8d7f-5e0c2a9b6d1e",
+			Err: app.Wrap(app.WrapParams{
+				Err:         errors.New("cycle"),
+				SafeMessage: "Directory cannot be moved into itself",
+				StatusCode:  http.StatusBadRequest,
+			}),
+		},
+		{
+			Source: cloudstore.MoveSource{Type: cloudstore.EntryFile, Path: "/missing.txt"},
+			Status: cloudstore.MoveFailed,
+			Err:    errors.New("connection reset"),
+		},
+	}
+
+	assertGolden(t, "move", newMoveResponseFrom(cloudstore.BulkMove{}, results))
+	assertGolden(t, "move_dry_run", newMoveResponseFrom(cloudstore.BulkMove{Atomic: true, DryRun: true}, results[:1]))
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/operation"
+	"github.com/go-chi/chi/v5"
+)
+
+type Operation struct {
+	ops *operation.Service
+	log *log.Logger
+}
+
+func NewOperation(ops *operation.Service, log *log.Logger) *Operation {
+	return &Operation{ops: ops, log: log}
+}
+
+// operationResponse is a operation in JSON format.
+type operationResponse struct {
+	ID         string   `json:"id"`
+	Type       string   `json:"type"`
+	Status     string   `json:"status"`
+	ParamsHash string   `json:"params_hash"`
+	Processed  int64    `json:"processed"`
+	Failed     int64    `json:"failed"`
+	Error      string   `json:"error"`
+	StartedAt  app.Time `json:"started_at"`
+	UpdatedAt  app.Time `json:"updated_at"`
+	FinishedAt app.Time `json:"finished_at"`
+}
+
+// newOperationResponse converts a operation.Operation to a operationResponse.
+func newOperationResponse(o operation.Operation) operationResponse {
+	return operationResponse{
+		ID:         o.ID,
+		Type:       o.Type,
+		Status:     o.Status,
+		ParamsHash: o.ParamsHash,
+		Processed:  o.Processed,
+		Failed:     o.Failed,
+		Error:      o.Error,
+		StartedAt:  app.NewTime(o.StartedAt),
+		UpdatedAt:  app.NewTime(o.UpdatedAt),
+		FinishedAt: app.NewTime(o.FinishedAt),
+	}
+}
+
+// Get returns a http.HandlerFunc that writes the operation in the URL as a JSON response. Only
+// the user that started the operation may get it.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (o *Operation) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		op, err := o.ops.Get(r.Context(), userID, chi.URLParam(r, "id"))
+		if err != nil {
+			app.WriteJSONError(w, err)
+			o.log.Printf("[ERROR] [%s %s] Getting operation: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		o.write(w, r, newOperationResponse(op))
+	}
+}
+
+// List returns a http.HandlerFunc that writes the most recent operations of the user as a JSON
+// response, newest first. The operations are filtered with the "status" query parameter, and
+// the number of operations is set with the "limit" query parameter.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (o *Operation) List() http.HandlerFunc {
+	type response struct {
+		Operations []operationResponse `json:"operations"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		status, err := operation.ParseStatus(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		limit, err := operation.ParseLimit(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		ops, err := o.ops.Recent(r.Context(), userID, status, limit)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			o.log.Printf("[ERROR] [%s %s] Getting operations: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp := response{Operations: []operationResponse{}}
+		for _, op := range ops {
+			resp.Operations = append(resp.Operations, newOperationResponse(op))
+		}
+
+		o.write(w, r, resp)
+	}
+}
+
+// write writes v as a JSON response.
+func (o *Operation) write(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		app.WriteJSONError(w, err)
+		o.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// startOperation starts a operation of the user and sets its ID in the operation.Header response
+// header. If the operation cannot be started, a JSON error is written and false is returned.
+func startOperation(w http.ResponseWriter, r *http.Request, ops *operation.Service, logger *log.Logger, userID string, opType string, params any) (*operation.Run, bool) {
+	run, err := ops.Start(r.Context(), userID, opType, params)
+	if err != nil {
+		app.WriteJSONError(w, err)
+		logger.Printf("[ERROR] [%s %s] Starting operation: %v\n", r.Method, r.URL.Path, err)
+		return nil, false
+	}
+
+	if id := run.ID(); id != "" {
+		w.Header().Set(operation.Header, id)
+	}
+
+	return run, true
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/operation"
+)
+
+type Sync struct {
+	syncs *cloudstore.SyncService
+	ops   *operation.Service
+	log   *log.Logger
+}
+
+func NewSync(syncs *cloudstore.SyncService, ops *operation.Service, log *log.Logger) *Sync {
+	return &Sync{syncs: syncs, ops: ops, log: log}
+}
+
+// syncResultResponse is the result of a single operation of a sync apply in JSON format.
+type syncResultResponse struct {
+	ID     string `json:"id"`
+	Op     string `json:"op"`
+	Status string `json:"status"`
+
+	// EntryID and Path are the directory or file that was created or moved, a deleted
+	// entry has no path.
+	EntryID string `json:"entry_id,omitempty"`
+	Path    string `json:"path,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// syncResponse is the response body of a sync apply in JSON format.
+type syncResponse struct {
+	OperationID string               `json:"operation_id,omitempty"`
+	Done        int                  `json:"done"`
+	Failed      int                  `json:"failed"`
+	Skipped     int                  `json:"skipped"`
+	Results     []syncResultResponse `json:"results"`
+}
+
+// Apply returns a http.HandlerFunc that applies the changes of a sync client in a single
+// request. The multipart/form-data body has a "manifest" field, a JSON
+// cloudstore.SyncManifest, and the file parts its upload operations reference by name.
+//
+// The manifest is validated before anything is applied, see cloudstore.ParseSyncPlan.
+// Once applied, the response is 200 with the result of every operation in manifest
+// order, even if some of them failed.
+//
+// Apply expects the user ID to be in the request context. To set the user ID in the
+// request context, use reqinfo.SetUser.
+func (s *Sync) Apply() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		if err := parseUploadForm(r); err != nil {
+			app.WriteJSONError(w, err)
+			s.log.Printf("[ERROR] %v\n", err)
+			return
+		}
+
+		manifest := r.FormValue("manifest")
+		if manifest == "" {
+			app.WriteJSONError(w, requiredField("manifest"))
+			return
+		}
+
+		plan, err := cloudstore.ParseSyncPlan(manifest, r.MultipartForm.File)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			s.log.Printf("[ERROR] [%s %s] Parsing sync plan: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		run, ok := startOperation(w, r, s.ops, s.log, userID, operation.TypeSyncApply, map[string]any{
+			"manifest": manifest,
+		})
+		if !ok {
+			return
+		}
+
+		results := s.syncs.Apply(r.Context(), userID, plan, func(finished int64, failed int64) {
+			run.Progress(r.Context(), finished, failed)
+		})
+		run.Finish(r.Context(), nil)
+
+		resp := syncResponse{OperationID: run.ID(), Results: []syncResultResponse{}}
+		for _, result := range results {
+			switch result.Status {
+			case cloudstore.SyncDone:
+				resp.Done++
+			case cloudstore.SyncFailed:
+				resp.Failed++
+				s.log.Printf("[ERROR] [%s %s] Applying sync operation [id: %s, op: %s]: %v\n", r.Method, r.URL.Path, result.Op.ID, result.Op.Kind, result.Err)
+			case cloudstore.SyncSkipped:
+				resp.Skipped++
+			}
+
+			resp.Results = append(resp.Results, syncResultResponse{
+				ID:      result.Op.ID,
+				Op:      string(result.Op.Kind),
+				Status:  string(result.Status),
+				EntryID: result.ID,
+				Path:    result.Path,
+				Error:   result.Msg(),
+			})
+		}
+
+		body, err := json.Marshal(&resp)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			s.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/transfer"
+)
+
+type Transfer struct {
+	stats *transfer.Stats
+	log   *log.Logger
+}
+
+func NewTransfer(stats *transfer.Stats, log *log.Logger) *Transfer {
+	return &Transfer{stats: stats, log: log}
+}
+
+// Me returns a http.HandlerFunc that writes the bytes uploaded and downloaded by each token of
+// the user on each of the last days as a JSON response. The number of days is set with the
+// "days" query parameter. Days are in UTC, and only days with transfers are included.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (t *Transfer) Me() http.HandlerFunc {
+	type day struct {
+		Date      string `json:"date"`
+		TokenID   string `json:"token_id"`
+		Direction string `json:"direction"`
+		Bytes     int64  `json:"bytes"`
+		Requests  int64  `json:"requests"`
+	}
+
+	type response struct {
+		Days []day `json:"days"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		days, err := transfer.ParseDays(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		totals, err := t.stats.Daily(r.Context(), userID, days)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			t.log.Printf("[ERROR] [%s %s] Getting transfer stats: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp := response{Days: []day{}}
+		for _, total := range totals {
+			resp.Days = append(resp.Days, day{
+				Date:      total.Date,
+				TokenID:   total.TokenID,
+				Direction: total.Direction,
+				Bytes:     total.Bytes,
+				Requests:  total.Requests,
+			})
+		}
+
+		t.write(w, r, resp)
+	}
+}
+
+// Users returns a http.HandlerFunc that writes the bytes uploaded and downloaded by every user
+// over the last days as a JSON response, users with the most bytes first. The number of days is
+// set with the "days" query parameter.
+func (t *Transfer) Users() http.HandlerFunc {
+	type user struct {
+		UserID    string `json:"user_id"`
+		Direction string `json:"direction"`
+		Bytes     int64  `json:"bytes"`
+		Requests  int64  `json:"requests"`
+	}
+
+	type response struct {
+		Days  int    `json:"days"`
+		Users []user `json:"users"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		days, err := transfer.ParseDays(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		totals, err := t.stats.ByUser(r.Context(), days)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			t.log.Printf("[ERROR] [%s %s] Getting transfer stats: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp := response{Days: days, Users: []user{}}
+		for _, total := range totals {
+			resp.Users = append(resp.Users, user{
+				UserID:    total.UserID,
+				Direction: total.Direction,
+				Bytes:     total.Bytes,
+				Requests:  total.Requests,
+			})
+		}
+
+		t.write(w, r, resp)
+	}
+}
+
+// write writes v as a JSON response.
+func (t *Transfer) write(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		app.WriteJSONError(w, err)
+		t.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
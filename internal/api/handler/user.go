@@ -2,57 +2,196 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/cicconee/clox/internal/api/auth"
 	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/reqinfo"
+	"github.com/cicconee/clox/internal/security"
+	"github.com/cicconee/clox/internal/token"
 	"github.com/cicconee/clox/internal/user"
 )
 
 type User struct {
-	users *user.Service
-	log   *log.Logger
+	users    *user.Service
+	dirs     *cloudstore.DirService
+	security *security.Recorder
+	log      *log.Logger
 }
 
-func NewUser(users *user.Service, log *log.Logger) *User {
+func NewUser(users *user.Service, dirs *cloudstore.DirService, security *security.Recorder, log *log.Logger) *User {
 	return &User{
-		users: users,
-		log:   log,
+		users:    users,
+		dirs:     dirs,
+		security: security,
+		log:      log,
+	}
+}
+
+// The values accepted by the "include" query parameter of Me.
+const (
+	includeRoot    = "root"
+	includeStorage = "storage"
+	includeToken   = "token"
+)
+
+// The response body of Me.
+type meResponse struct {
+	ID              string             `json:"id"`
+	FirstName       string             `json:"first_name"`
+	LastName        string             `json:"last_name"`
+	PictureURL      string             `json:"picture_url"`
+	Email           string             `json:"email"`
+	Username        string             `json:"username"`
+	RootDirectoryID string             `json:"root_directory_id,omitempty"`
+	Storage         *meStorageResponse `json:"storage,omitempty"`
+	Token           *meTokenResponse   `json:"token,omitempty"`
+}
+
+// The storage used by the user in the response body of Me.
+type meStorageResponse struct {
+	UsedBytes int64 `json:"used_bytes"`
+}
+
+// The token used to make the request in the response body of Me.
+type meTokenResponse struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	ExpiresAt      app.Time `json:"expires_at"`
+	AllowedIPs     []string `json:"allowed_ips"`
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// newMeResponseFrom converts a user.User to a meResponse without any of the included
+// fields.
+func newMeResponseFrom(u *user.User) meResponse {
+	return meResponse{
+		ID:         u.ID,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		PictureURL: u.PictureURL,
+		Email:      u.Email,
+		Username:   u.Username,
+	}
+}
+
+// newMeTokenResponseFrom converts a token.Listing to a meTokenResponse.
+func newMeTokenResponseFrom(l token.Listing) *meTokenResponse {
+	return &meTokenResponse{
+		ID:             l.TokenID,
+		Name:           l.TokenName,
+		ExpiresAt:      app.NewTime(l.ExpiresAt),
+		AllowedIPs:     l.AllowedIPs,
+		AllowedOrigins: l.AllowedOrigins,
 	}
 }
 
 // Me returns a http.HandlerFunc that writes the user information as a JSON response.
 //
+// The "include" query parameter is a comma separated list of additional fields to write:
+//   - root: The ID of the users root directory. It is created if it does not exist.
+//   - storage: The storage used by the user.
+//   - token: The token used to make the request.
+//
 // The http.HandlerFunc expects a user ID in the request context.
 func (u *User) Me() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		include, err := parseInclude(r, includeRoot, includeStorage, includeToken)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		user, err := u.users.Get(r.Context(), userID)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			u.log.Printf("[ERROR] [%s %s] Getting user: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		resp := newMeResponseFrom(user)
+
+		if include[includeRoot] {
+			root, err := u.dirs.ValidateUser(r.Context(), userID)
+			if err != nil {
+				app.WriteJSONError(w, err)
+				u.log.Printf("[ERROR] [%s %s] Validating user root directory: %v\n", r.Method, r.URL.Path, err)
+				return
+			}
+
+			resp.RootDirectoryID = root.ID
+		}
+
+		if include[includeStorage] {
+			usage, err := u.dirs.Usage(r.Context(), userID)
+			if err != nil {
+				app.WriteJSONError(w, err)
+				u.log.Printf("[ERROR] [%s %s] Getting storage usage: %v\n", r.Method, r.URL.Path, err)
+				return
+			}
+
+			resp.Storage = &meStorageResponse{UsedBytes: usage.Used}
+		}
+
+		if include[includeToken] {
+			if p, ok := reqinfo.Get(r.Context(), auth.PrincipalKey); ok {
+				resp.Token = newMeTokenResponseFrom(p.Token)
+			}
+		}
+
+		body, err := json.Marshal(&resp)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			u.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+// UploadStats returns a http.HandlerFunc that writes the number of files the user uploaded on
+// each of the last days as a JSON response. The number of days is set with the "days" query
+// parameter. Days are in the display time zone, and days without uploads have a count of 0.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (u *User) UploadStats() http.HandlerFunc {
 	type response struct {
-		ID         string `json:"id"`
-		FirstName  string `json:"first_name"`
-		LastName   string `json:"last_name"`
-		PictureURL string `json:"picture_url"`
-		Email      string `json:"email"`
-		Username   string `json:"username"`
+		TimeZone string                `json:"time_zone"`
+		Days     []cloudstore.DayCount `json:"days"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID := auth.GetUserIDContext(r.Context())
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
 
-		user, err := u.users.Get(r.Context(), userID)
+		days, err := cloudstore.ParseStatsDays(r.URL.Query())
 		if err != nil {
 			app.WriteJSONError(w, err)
-			u.log.Printf("[ERROR] [%s %s] Getting user: %v\n", r.Method, r.URL.Path, err)
 			return
 		}
 
-		resp, err := json.Marshal(&response{
-			ID:         user.ID,
-			FirstName:  user.FirstName,
-			LastName:   user.LastName,
-			PictureURL: user.PictureURL,
-			Email:      user.Email,
-			Username:   user.Username,
-		})
+		series, err := u.dirs.UploadStats(r.Context(), userID, days)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			u.log.Printf("[ERROR] [%s %s] Getting upload stats: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		body, err := json.Marshal(&response{TimeZone: u.dirs.Location().String(), Days: series})
 		if err != nil {
 			app.WriteJSONError(w, err)
 			u.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
@@ -61,6 +200,104 @@ func (u *User) Me() http.HandlerFunc {
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write(resp)
+		w.Write(body)
+	}
+}
+
+// SecurityEvents returns a http.HandlerFunc that writes the most recent failed authentications
+// of the user as a JSON response, newest first. The number of events is set with the "limit"
+// query parameter.
+//
+// The http.HandlerFunc expects a user ID in the request context.
+func (u *User) SecurityEvents() http.HandlerFunc {
+	type event struct {
+		Type        string   `json:"type"`
+		Description string   `json:"description"`
+		Detail      string   `json:"detail"`
+		IP          string   `json:"ip"`
+		UserAgent   string   `json:"user_agent"`
+		CreatedAt   app.Time `json:"created_at"`
 	}
+
+	type response struct {
+		Events []event `json:"events"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		limit, err := security.ParseLimit(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		resp := response{Events: []event{}}
+		if u.security != nil {
+			events, err := u.security.Recent(r.Context(), userID, limit)
+			if err != nil {
+				app.WriteJSONError(w, err)
+				u.log.Printf("[ERROR] [%s %s] Getting security events: %v\n", r.Method, r.URL.Path, err)
+				return
+			}
+
+			for _, e := range events {
+				resp.Events = append(resp.Events, event{
+					Type:        e.Type,
+					Description: e.Description(),
+					Detail:      e.Detail,
+					IP:          e.IP,
+					UserAgent:   e.UserAgent,
+					CreatedAt:   app.NewTime(e.CreatedAt),
+				})
+			}
+		}
+
+		body, err := json.Marshal(&resp)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			u.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+// parseInclude parses the comma separated "include" query parameter of r into a set. If a
+// value is not one of allowed, a app.WrappedSafeError with a 400 status code is returned.
+func parseInclude(r *http.Request, allowed ...string) (map[string]bool, error) {
+	include := map[string]bool{}
+
+	for _, v := range strings.Split(r.URL.Query().Get("include"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		valid := false
+		for _, a := range allowed {
+			if v == a {
+				valid = true
+				break
+			}
+		}
+
+		if !valid {
+			return nil, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("invalid include value: %q", v),
+				SafeMessage: fmt.Sprintf("Include must be one of: %s", strings.Join(allowed, ", ")),
+				StatusCode:  http.StatusBadRequest,
+			})
+		}
+
+		include[v] = true
+	}
+
+	return include, nil
 }
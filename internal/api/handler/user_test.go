@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/token"
+	"github.com/cicconee/clox/internal/user"
+)
+
+func TestMeResponseGolden(t *testing.T) {
+	u := &user.User{
+		ID:         testDir.Owner,
+		FirstName:  "Ada",
+		LastName:   "Lovelace",
+		PictureURL: "https://example.com/ada.png",
+		Email:      "ada@example.com",
+		Username:   "ada",
+	}
+
+	t.Run("me", func(t *testing.T) {
+		assertGolden(t, "me", newMeResponseFrom(u))
+	})
+
+	t.Run("me_include", func(t *testing.T) {
+		resp := newMeResponseFrom(u)
+		resp.RootDirectoryID = testDir.ParentID
+		resp.Storage = &meStorageResponse{UsedBytes: 1 << 20}
+		resp.Token = newMeTokenResponseFrom(token.Listing{
+			TokenID:        "8a0c2e4f-6b8d-4f1a-9c3e-5d7f9b1a3c5e",
+			TokenName:      "laptop",
+			ExpiresAt:      testUpdatedAt,
+			IssuedAt:       testCreatedAt,
+			AllowedIPs:     []string{"203.0.113.0/24"},
+			AllowedOrigins: []string{"https://example.com"},
+		})
+
+		assertGolden(t, "me_include", resp)
+	})
+}
+
+func TestParseInclude(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		{query: "", want: []string{}},
+		{query: "include=root", want: []string{"root"}},
+		{query: "include=root,storage,token", want: []string{"root", "storage", "token"}},
+		{query: "include=+root+,,token", want: []string{"root", "token"}},
+		{query: "include=quota", wantErr: true},
+		{query: "include=root,Root", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/me?"+tc.query, nil)
+
+			got, err := parseInclude(r, includeRoot, includeStorage, includeToken)
+			if tc.wantErr {
+				var safeErr *app.WrappedSafeError
+				if !errors.As(err, &safeErr) {
+					t.Fatalf("parseInclude() error = %v, want a app.WrappedSafeError", err)
+				}
+
+				if _, status := safeErr.Safe(); status != http.StatusBadRequest {
+					t.Errorf("parseInclude() status = %d, want %d", status, http.StatusBadRequest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseInclude() error = %v", err)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Errorf("parseInclude() = %v, want %v", got, tc.want)
+			}
+
+			for _, v := range tc.want {
+				if !got[v] {
+					t.Errorf("parseInclude() = %v, want it to include %s", got, v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/user"
+)
+
+// Admin has middleware functions for handling requests that require an admin user.
+type Admin struct {
+	users  *user.Service
+	admins map[string]bool
+	logger *log.Logger
+}
+
+// NewAdmin creates a new Admin middleware. Only the users with a username in usernames are admins.
+func NewAdmin(users *user.Service, usernames []string, logger *log.Logger) *Admin {
+	admins := map[string]bool{}
+	for _, u := range usernames {
+		if u = strings.ToLower(strings.TrimSpace(u)); u != "" {
+			admins[u] = true
+		}
+	}
+
+	return &Admin{users: users, admins: admins, logger: logger}
+}
+
+// Require is a http middleware that ensures the request is being made by an admin user. If the user
+// is not an admin, a 403 JSON error is written.
+//
+// Require expects a user ID in the request context, so it must be wrapped by Token.Validate.
+func (a *Admin) Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.MustUserID(w, r)
+		if !ok {
+			return
+		}
+
+		u, err := a.users.Get(r.Context(), userID)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			a.logger.Printf("[ERROR] [%s %s] Getting user: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		if !a.admins[strings.ToLower(u.Username)] {
+			app.WriteJSONError(w, app.Wrap(app.WrapParams{
+				Err:         errors.New("user is not an admin"),
+				SafeMessage: "Forbidden",
+				StatusCode:  http.StatusForbidden,
+			}))
+			return
+		}
+
+		next(w, r)
+	}
+}
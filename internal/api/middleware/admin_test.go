@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/token"
+	"github.com/cicconee/clox/internal/user"
+	"github.com/google/uuid"
+)
+
+func TestAdminRequire(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	// The usernames are unique, the admin is listed in another case with surrounding
+	// white space.
+	suffix := uuid.NewString()[:8]
+	users := map[string]string{"Admin" + suffix: uuid.NewString(), "user" + suffix: uuid.NewString()}
+	for username, id := range users {
+		_, err := p.Exec(ctx, `INSERT INTO users (id, email, username, register_status) VALUES ($1, $2, $3, 'complete')`,
+			id, id+"@example.com", username)
+		if err != nil {
+			t.Fatalf("inserting user: %v", err)
+		}
+		t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, id) })
+	}
+
+	a := NewAdmin(user.NewService(user.NewRepo(p)), []string{" admin" + suffix + " ", ""}, log.New(io.Discard, "", 0))
+
+	tests := []struct {
+		name   string
+		userID string
+		want   int
+	}{
+		{name: "admin", userID: users["Admin"+suffix], want: http.StatusOK},
+		{name: "not admin", userID: users["user"+suffix], want: http.StatusForbidden},
+		{name: "no user", userID: "", want: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/admin/backfill", nil)
+			if tc.userID != "" {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), token.Principal{UserID: tc.userID}))
+			}
+
+			w := httptest.NewRecorder()
+			a.Require(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })(w, r)
+
+			if w.Code != tc.want {
+				t.Errorf("status = %d, want %d", w.Code, tc.want)
+			}
+		})
+	}
+}
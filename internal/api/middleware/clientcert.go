@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/app"
+)
+
+// ClientCert has middleware functions for handling requests authenticated with a client
+// certificate over mutual TLS.
+type ClientCert struct {
+	auth   *auth.Authenticator
+	token  *Token
+	logger *log.Logger
+}
+
+// NewClientCert creates a new ClientCert middleware. Requests without a client certificate
+// are authenticated by token.
+func NewClientCert(auth *auth.Authenticator, token *Token, logger *log.Logger) *ClientCert {
+	return &ClientCert{auth: auth, token: token, logger: logger}
+}
+
+// Validate is a http middleware that authenticates the request with its verified client
+// certificate. The token.Principal of the certificate is injected into the request context,
+// the same as Token.Validate, so handlers do not know how the request was authenticated.
+//
+// A request without a client certificate is passed to Token.Validate, so API tokens keep
+// working on the same listener.
+//
+// Validate should wrap all handlers that require authentication when mutual TLS is enabled.
+func (c *ClientCert) Validate(next http.HandlerFunc) http.HandlerFunc {
+	validateToken := c.token.Validate(next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		cert, ok := c.auth.ClientCert(r)
+		if !ok {
+			validateToken(w, r)
+			return
+		}
+
+		principal, err := c.auth.AuthenticateCert(r, cert)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			c.logger.Printf("[ERROR] [%s %s] Authenticating client certificate: %v\n", r.Method, r.URL.Path, err)
+			return
+		}
+
+		ctx := auth.WithPrincipal(r.Context(), principal)
+		next(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/clientcert"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/reqinfo"
+	"github.com/cicconee/clox/internal/user"
+	"github.com/google/uuid"
+)
+
+// testCA is a certificate authority that issues client certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "clox test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %v", err)
+	}
+
+	return testCA{cert: cert, key: key}
+}
+
+// issue issues a client certificate named name. The PEM encoded certificate is returned
+// with the tls.Certificate a client presents.
+func (ca testCA) issue(t *testing.T, name string) (string, tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating client key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("generating serial number: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("creating client certificate: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return string(certPEM), tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCertValidate(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	// The deleted user has a registered certificate, removed with the user before the
+	// request.
+	userIDs := []string{uuid.NewString(), uuid.NewString()}
+	for _, id := range userIDs {
+		id := id
+		_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+			id, id+"@example.com")
+		if err != nil {
+			t.Fatalf("inserting user: %v", err)
+		}
+		t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, id) })
+	}
+	userID, deletedID := userIDs[0], userIDs[1]
+
+	certs := clientcert.NewService(clientcert.NewRepo(p))
+	ca := newTestCA(t)
+
+	validPEM, valid := ca.issue(t, "laptop")
+	registered, err := certs.Register(ctx, userID, "laptop", validPEM)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	deletedPEM, deleted := ca.issue(t, "old laptop")
+	if _, err := certs.Register(ctx, deletedID, "old laptop", deletedPEM); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	if _, err := p.Exec(ctx, `DELETE FROM users WHERE id = $1`, deletedID); err != nil {
+		t.Fatalf("deleting user: %v", err)
+	}
+
+	_, unknown := ca.issue(t, "unregistered")
+
+	discard := log.New(io.Discard, "", 0)
+	a := auth.NewAuthenticator(nil, user.NewService(user.NewRepo(p)), certs, nil, nil)
+	c := NewClientCert(a, NewToken(a, discard), discard)
+
+	srv := httptest.NewUnstartedServer(c.Validate(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := reqinfo.Get(r.Context(), auth.PrincipalKey)
+		if !ok {
+			t.Error("handler called without a principal")
+		}
+		fmt.Fprintf(w, "%s %s", principal.UserID, principal.Token.TokenID)
+	}))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name string
+		cert *tls.Certificate
+		want int
+
+		// body is in the response body.
+		body string
+	}{
+		{name: "valid", cert: &valid, want: http.StatusOK, body: userID + " " + registered.ID},
+		{name: "unknown", cert: &unknown, want: http.StatusUnauthorized, body: "Client certificate is not registered"},
+		{name: "deleted user", cert: &deleted, want: http.StatusUnauthorized},
+		{name: "no certificate", want: http.StatusUnauthorized, body: "No Authorization header provided"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := srv.Client()
+			transport := client.Transport.(*http.Transport).Clone()
+			if tc.cert != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{*tc.cert}
+			}
+			client.Transport = transport
+			t.Cleanup(transport.CloseIdleConnections)
+
+			resp, err := client.Get(srv.URL + "/api/me")
+			if err != nil {
+				t.Fatalf("GET error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading body: %v", err)
+			}
+
+			if resp.StatusCode != tc.want {
+				t.Errorf("status = %d, want %d: %s", resp.StatusCode, tc.want, body)
+			}
+
+			if !strings.Contains(string(body), tc.body) {
+				t.Errorf("body = %s, want %q", body, tc.body)
+			}
+		})
+	}
+}
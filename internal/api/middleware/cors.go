@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cicconee/clox/internal/operation"
+	"github.com/cicconee/clox/internal/server"
+	"github.com/cicconee/clox/internal/token"
+)
+
+// CORS has middleware functions for handling cross-origin requests, such as the requests sent by the request
+// console of the server side app, or by a page that uses a token with allowed origins.
+type CORS struct {
+	origins map[string]bool
+	logger  *log.Logger
+
+	// lookup returns true if a token allows the origin. If nil, only origins are allowed.
+	lookup func(ctx context.Context, origin string) (bool, error)
+
+	// cache caches the results of lookup, so a page that sends many requests does not
+	// query the tokens on each of them.
+	cache *originCache
+}
+
+// Token origins are cached for at most originCacheTTL, a origin removed from the last token
+// that allowed it is allowed for at most that long. The bearer token of a request is still
+// checked against its own allowed origins, so this only delays the CORS headers.
+const (
+	originCacheSize = 1024
+	originCacheTTL  = 30 * time.Second
+)
+
+// NewCORS creates a new CORS middleware that allows requests from the origins, and from the allowed origins of
+// the tokens that are not revoked or expired. If origins is empty and tokens is nil, no cross-origin requests are
+// allowed.
+func NewCORS(origins []string, tokens *token.Service, logger *log.Logger) *CORS {
+	allowed := map[string]bool{}
+	for _, o := range origins {
+		o = strings.TrimSuffix(strings.TrimSpace(o), "/")
+		if o != "" {
+			allowed[o] = true
+		}
+	}
+
+	c := &CORS{origins: allowed, logger: logger}
+	if tokens != nil {
+		c.lookup = tokens.OriginAllowed
+		c.cache = newOriginCache(originCacheSize, originCacheTTL)
+	}
+
+	return c
+}
+
+// Allow is a http middleware that sets the CORS headers when the request Origin is allowed. Preflight
+// (OPTIONS) requests from an allowed origin are answered with 204 No Content and are not passed to next.
+//
+// Allow should be applied to every route with server.HTTP.Use, so that preflight requests are handled
+// before routing.
+func (c *CORS) Allow(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.allowed(r, origin) {
+			next(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", operation.Header+", "+server.RequestIDHeader)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// allowed returns true if origin is one of the origins of c, or an allowed origin of a token. The allowed
+// origins of the tokens are cached, both when a origin is allowed and when it is not. A origin that cannot be
+// looked up is not allowed, and is not cached.
+func (c *CORS) allowed(r *http.Request, origin string) bool {
+	if c.origins[origin] {
+		return true
+	}
+
+	if c.lookup == nil {
+		return false
+	}
+
+	if ok, cached := c.cache.get(origin); cached {
+		return ok
+	}
+
+	ok, err := c.lookup(r.Context(), origin)
+	if err != nil {
+		c.logger.Printf("[ERROR] [%s %s] Looking up token origin [origin: %s]: %v\n", r.Method, r.URL.Path, origin, err)
+		return false
+	}
+	c.cache.set(origin, ok)
+
+	return ok
+}
+
+// originCache is a least recently used cache of whether a origin is allowed by a token.
+// Results expire after the TTL. A nil originCache is valid and caches nothing.
+type originCache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// originEntry is a cached result of a origin.
+type originEntry struct {
+	origin    string
+	allowed   bool
+	expiresAt time.Time
+}
+
+// newOriginCache creates a new originCache of at most size origins, each cached for ttl.
+func newOriginCache(size int, ttl time.Duration) *originCache {
+	return &originCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// get gets the cached result of origin. If origin is not cached or expired, false is
+// returned as cached.
+func (c *originCache) get(origin string) (allowed bool, cached bool) {
+	if c == nil {
+		return false, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[origin]
+	if !ok {
+		return false, false
+	}
+
+	entry := e.Value.(*originEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.order.Remove(e)
+		delete(c.entries, origin)
+		return false, false
+	}
+
+	c.order.MoveToFront(e)
+
+	return entry.allowed, true
+}
+
+// set caches the result of origin. If the cache is full, the least recently used origin
+// is evicted.
+func (c *originCache) set(origin string, allowed bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &originEntry{origin: origin, allowed: allowed, expiresAt: c.now().Add(c.ttl)}
+
+	if e, ok := c.entries[origin]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[origin] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.entries, e.Value.(*originEntry).origin)
+	}
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestCORS creates a CORS that allows the origins, and the token origins in tokenOrigins.
+// The number of token lookups is counted in lookups.
+func newTestCORS(origins []string, tokenOrigins map[string]bool, lookups *int) *CORS {
+	c := NewCORS(origins, nil, log.New(io.Discard, "", 0))
+	c.lookup = func(ctx context.Context, origin string) (bool, error) {
+		*lookups++
+		return tokenOrigins[origin], nil
+	}
+	c.cache = newOriginCache(2, time.Minute)
+
+	return c
+}
+
+// serveCORS serves a request with the method and origin through the Allow middleware of
+// c. The response and whether the next handler was called are returned.
+func serveCORS(c *CORS, method string, origin string, preflight bool) (*httptest.ResponseRecorder, bool) {
+	r := httptest.NewRequest(method, "/api/dirs", nil)
+	r.Header.Set("Origin", origin)
+	if preflight {
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+
+	called := false
+	w := httptest.NewRecorder()
+	c.Allow(func(w http.ResponseWriter, r *http.Request) { called = true })(w, r)
+
+	return w, called
+}
+
+func TestCORSAllow(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		preflight  bool
+		wantOrigin string
+		wantStatus int
+		wantNext   bool
+	}{
+		{name: "configured origin", method: http.MethodGet, origin: "https://console.example.com", wantOrigin: "https://console.example.com", wantStatus: http.StatusOK, wantNext: true},
+		{name: "token origin", method: http.MethodGet, origin: "https://app.example.com", wantOrigin: "https://app.example.com", wantStatus: http.StatusOK, wantNext: true},
+		{name: "denied origin", method: http.MethodGet, origin: "https://evil.example.com", wantStatus: http.StatusOK, wantNext: true},
+		{name: "preflight", method: http.MethodOptions, origin: "https://app.example.com", preflight: true, wantOrigin: "https://app.example.com", wantStatus: http.StatusNoContent},
+		{name: "denied preflight", method: http.MethodOptions, origin: "https://evil.example.com", preflight: true, wantStatus: http.StatusOK, wantNext: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lookups int
+			c := newTestCORS([]string{"https://console.example.com/"}, map[string]bool{"https://app.example.com": true}, &lookups)
+
+			w, next := serveCORS(c, tt.method, tt.origin, tt.preflight)
+
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if next != tt.wantNext {
+				t.Errorf("next called = %v, want %v", next, tt.wantNext)
+			}
+
+			if tt.preflight && tt.wantOrigin != "" && w.Header().Get("Access-Control-Allow-Methods") == "" {
+				t.Error("Access-Control-Allow-Methods not set on preflight")
+			}
+		})
+	}
+}
+
+func TestCORSCachesTokenOrigins(t *testing.T) {
+	var lookups int
+	c := newTestCORS(nil, map[string]bool{"https://app.example.com": true}, &lookups)
+
+	for i := 0; i < 3; i++ {
+		serveCORS(c, http.MethodGet, "https://app.example.com", false)
+		serveCORS(c, http.MethodGet, "https://evil.example.com", false)
+	}
+
+	if lookups != 2 {
+		t.Errorf("lookups = %d, want 2, one for the allowed and one for the denied origin", lookups)
+	}
+
+	// A cached result expires after the TTL.
+	c.cache.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	serveCORS(c, http.MethodGet, "https://app.example.com", false)
+	if lookups != 3 {
+		t.Errorf("lookups after expiry = %d, want 3", lookups)
+	}
+
+	// The least recently used origin is evicted.
+	serveCORS(c, http.MethodGet, "https://other.example.com", false)
+	serveCORS(c, http.MethodGet, "https://third.example.com", false)
+	serveCORS(c, http.MethodGet, "https://app.example.com", false)
+	if lookups != 6 {
+		t.Errorf("lookups after eviction = %d, want 6", lookups)
+	}
+}
+
+func TestCORSLookupFailure(t *testing.T) {
+	var lookups int
+	c := NewCORS(nil, nil, log.New(io.Discard, "", 0))
+	c.lookup = func(ctx context.Context, origin string) (bool, error) {
+		lookups++
+		return false, errors.New("connection refused")
+	}
+	c.cache = newOriginCache(2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		w, _ := serveCORS(c, http.MethodGet, "https://app.example.com", false)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+		}
+	}
+
+	// A failed lookup is not cached.
+	if lookups != 2 {
+		t.Errorf("lookups = %d, want 2", lookups)
+	}
+}
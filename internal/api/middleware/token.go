@@ -20,20 +20,20 @@ func NewToken(auth *auth.Authenticator, logger *log.Logger) *Token {
 }
 
 // Validate is a http middleware that ensures the request is being made with a valid API token.
-// If a token exists and is valid, the user ID of the user making the request is injected into
-// the request context.
+// If a token exists and is valid, the token.Principal making the request is injected into the
+// request context.
 //
 // Validate should wrap all handlers that require an API token.
 func (a *Token) Validate(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID, err := a.auth.AuthenticateRequest(r)
+		principal, err := a.auth.AuthenticateRequest(r)
 		if err != nil {
 			app.WriteJSONError(w, err)
 			a.logger.Printf("[ERROR] [%s %s] Authenticating request: %v\n", r.Method, r.URL.Path, err)
 			return
 		}
 
-		ctx := auth.SetUserIDContext(r.Context(), userID)
+		ctx := auth.WithPrincipal(r.Context(), principal)
 		next(w, r.WithContext(ctx))
 	}
 }
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/api/auth"
+	"github.com/cicconee/clox/internal/reqinfo"
+	"github.com/cicconee/clox/internal/transfer"
+)
+
+// Transfer has middleware functions that count the bytes uploaded and downloaded by each token.
+type Transfer struct {
+	stats *transfer.Stats
+}
+
+// NewTransfer creates a new Transfer middleware. If stats is nil, nothing is counted.
+func NewTransfer(stats *transfer.Stats) *Transfer {
+	return &Transfer{stats: stats}
+}
+
+// Upload is a http middleware that counts the bytes read from the request body as a upload by
+// the token of the request.
+//
+// Upload expects a principal in the request context, so it must be wrapped by Token.Validate.
+func (t *Transfer) Upload(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+
+		next(w, r)
+
+		t.record(r, transfer.Upload, body.n)
+	}
+}
+
+// Download is a http middleware that counts the bytes written to the response body as a
+// download by the token of the request.
+//
+// Download expects a principal in the request context, so it must be wrapped by Token.Validate.
+func (t *Transfer) Download(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingWriter{ResponseWriter: w}
+
+		next(cw, r)
+
+		t.record(r, transfer.Download, cw.n)
+	}
+}
+
+// record records the bytes of a request. Requests without a principal are not recorded.
+func (t *Transfer) record(r *http.Request, direction string, n int64) {
+	p, ok := reqinfo.Get(r.Context(), auth.PrincipalKey)
+	if !ok {
+		return
+	}
+
+	// The bytes are counted even if the client went away before the request finished.
+	t.stats.Record(context.WithoutCancel(r.Context()), transfer.Sample{
+		UserID:    p.UserID,
+		TokenID:   p.Token.TokenID,
+		Direction: direction,
+		Bytes:     n,
+	})
+}
+
+// countingReader is a request body that counts the bytes read from it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingWriter is a http.ResponseWriter that counts the bytes written to it.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, it is used by http.ResponseController.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -2,11 +2,41 @@ package app
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cicconee/clox/pkg/env"
 )
 
+// The warm-up modes. The warm-up mode is set with the WARMUP_MODE environment variable.
+const (
+	// WarmUpOff skips the startup warm-up.
+	WarmUpOff string = "off"
+
+	// WarmUpWarn runs the startup warm-up in the background and logs failed probes. This is the default.
+	WarmUpWarn string = "warn"
+
+	// WarmUpStrict runs the startup warm-up before serving requests. Any failed probe is a startup error.
+	WarmUpStrict string = "strict"
+)
+
+// DefaultListingCacheTTL is the default time a directory listing is cached for.
+const DefaultListingCacheTTL = 30 * time.Second
+
+// DefaultUploadLoopWindow is the default window uploads are counted in to detect upload loops.
+const DefaultUploadLoopWindow = time.Hour
+
+// The default user cache configuration.
+const (
+	DefaultUserCacheSize = 1000
+	DefaultUserCacheTTL  = 10 * time.Second
+)
+
+// DefaultStatementBudget is the default number of database statements a request may execute when APP_ENV is "dev".
+const DefaultStatementBudget = 25
+
 // A Config is the application configuration for Clox. This configuration is considered the base configuration, and it
 // will be used by both the Server Side App and the API.
 type Config struct {
@@ -24,6 +54,92 @@ type Config struct {
 	redisPassword        string
 	jwtSecretKey         string
 	FileStorePath        string
+
+	// TrustedProxies are the proxies that are trusted to set the X-Forwarded-For header. Set with the
+	// TRUSTED_PROXIES environment variable as a comma separated list of IP addresses or CIDRs.
+	TrustedProxies []*net.IPNet
+
+	// AdminUsers are the usernames allowed to use the admin endpoints and pages. If empty, there are no admins.
+	// Set with the ADMIN_USERS environment variable as a comma separated list.
+	AdminUsers []string
+
+	// WarmUpMode is the startup warm-up mode. It is one of WarmUpOff, WarmUpWarn, or WarmUpStrict.
+	WarmUpMode string
+
+	// FSDirPerm and FSFilePerm are the octal permissions of the directories and files in the file store.
+	// Set with the FS_DIR_PERM and FS_FILE_PERM environment variables. They are empty if not set, and must
+	// be validated with cloudstore.ParsePerms.
+	FSDirPerm  string
+	FSFilePerm string
+
+	// StorageLayout is the file system layout of new directories. Set with the STORAGE_LAYOUT environment
+	// variable. It is empty if not set, and must be parsed with cloudstore.ParseLayout.
+	StorageLayout string
+
+	// StrictFileWrites writes uploads in two phases, so a file is never readable before its content is fully
+	// written. Set with the STRICT_FILE_WRITES environment variable. See cloudstore.FilePending.
+	StrictFileWrites bool
+
+	// AllowWorldWritable allows FSDirPerm and FSFilePerm to grant write to others. Set with the
+	// ALLOW_WORLD_WRITABLE environment variable.
+	AllowWorldWritable bool
+
+	// DisplayLocation is the time zone dates are displayed in. Set with the DISPLAY_TIMEZONE environment
+	// variable as a IANA time zone name, such as "America/Chicago". It defaults to UTC.
+	DisplayLocation *time.Location
+
+	// LogHooks registers the hook callbacks that log every hook point. Set with the LOG_HOOKS environment
+	// variable.
+	LogHooks bool
+
+	// ListingCacheTTL is the time a directory listing is cached for. Set with the LISTING_CACHE_TTL
+	// environment variable as a duration, such as "30s". If zero, listings are not cached.
+	ListingCacheTTL time.Duration
+
+	// UploadLoopThreshold is the number of copies of the same content a user may upload under the same name to
+	// a directory within UploadLoopWindow before the upload is warned about or blocked. Set with the
+	// UPLOAD_LOOP_THRESHOLD environment variable. If zero, uploads are not checked.
+	UploadLoopThreshold int
+
+	// UploadLoopWindow is how far back uploads are counted for UploadLoopThreshold. Set with the
+	// UPLOAD_LOOP_WINDOW environment variable as a duration, such as "1h".
+	UploadLoopWindow time.Duration
+
+	// UploadLoopMode is what is done with an upload past UploadLoopThreshold. Set with the UPLOAD_LOOP_MODE
+	// environment variable. It is empty if not set, and must be parsed with cloudstore.ParseLoopMode.
+	UploadLoopMode string
+
+	// UserCacheSize is the number of users cached in-process by the user service. Set with the
+	// USER_CACHE_SIZE environment variable. If zero, users are not cached.
+	UserCacheSize int
+
+	// UserCacheTTL is the time a user is cached for. Set with the USER_CACHE_TTL environment variable
+	// as a duration, such as "10s". If zero, users are not cached.
+	UserCacheTTL time.Duration
+
+	// StatementBudget is the number of database statements a request may execute before a warning is logged.
+	// Set with the DB_STATEMENT_BUDGET environment variable. It defaults to DefaultStatementBudget when APP_ENV
+	// is "dev", and zero otherwise. If zero, statements are not counted.
+	StatementBudget int
+
+	// TreeDepthThreshold is the directory depth above which the directory tree of a user is flagged in the
+	// tree report. Set with the TREE_DEPTH_THRESHOLD environment variable. If zero,
+	// cloudstore.DefaultTreeThresholds is used.
+	TreeDepthThreshold int
+
+	// TreeClosureThreshold is the number of paths rows above which the directory tree of a user is flagged in
+	// the tree report. Set with the TREE_CLOSURE_THRESHOLD environment variable. If zero,
+	// cloudstore.DefaultTreeThresholds is used.
+	TreeClosureThreshold int
+
+	// FaultInjection enables forcing the database and file system to fail at the cloudstore.FaultPoints, to
+	// exercise the compensation paths. Set with the FAULT_INJECTION environment variable. It is only enabled
+	// when APP_ENV is "dev".
+	FaultInjection bool
+
+	// Faults are the fault injection points armed at startup, such as "commit=1,fs.copy=2". Set with the
+	// FAULTS environment variable. It is only used if FaultInjection is enabled.
+	Faults string
 }
 
 // LoadConfig will load the environment variables and create the Config based on these values.
@@ -47,6 +163,92 @@ func LoadConfig(loader *env.Loader) (*Config, error) {
 		redisPassword:        os.Getenv("REDIS_PASSWORD"),
 		jwtSecretKey:         os.Getenv("JWT_SECRET_KEY"),
 		FileStorePath:        os.Getenv("FILE_STORE_PATH"),
+		FSDirPerm:            os.Getenv("FS_DIR_PERM"),
+		FSFilePerm:           os.Getenv("FS_FILE_PERM"),
+		StorageLayout:        os.Getenv("STORAGE_LAYOUT"),
+		AllowWorldWritable:   os.Getenv("ALLOW_WORLD_WRITABLE") == "true",
+		StrictFileWrites:     os.Getenv("STRICT_FILE_WRITES") == "true",
+		UploadLoopMode:       os.Getenv("UPLOAD_LOOP_MODE"),
+		LogHooks:             os.Getenv("LOG_HOOKS") == "true",
+	}
+
+	trustedProxies, err := ParseCIDRs(strings.Split(os.Getenv("TRUSTED_PROXIES"), ","))
+	if err != nil {
+		return nil, fmt.Errorf("parsing TRUSTED_PROXIES: %w", err)
+	}
+	config.TrustedProxies = trustedProxies
+
+	if u := os.Getenv("ADMIN_USERS"); u != "" {
+		config.AdminUsers = strings.Split(u, ",")
+	}
+
+	switch mode := os.Getenv("WARMUP_MODE"); mode {
+	case "":
+		config.WarmUpMode = WarmUpWarn
+	case WarmUpOff, WarmUpWarn, WarmUpStrict:
+		config.WarmUpMode = mode
+	default:
+		return nil, fmt.Errorf("invalid WARMUP_MODE %q: must be %q, %q, or %q", mode, WarmUpOff, WarmUpWarn, WarmUpStrict)
+	}
+
+	config.DisplayLocation = time.UTC
+	if tz := os.Getenv("DISPLAY_TIMEZONE"); tz != "" {
+		// The location name is passed to the database, "Local" is not a name it knows.
+		loc, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			return nil, fmt.Errorf("invalid DISPLAY_TIMEZONE %q: must be a IANA time zone name", tz)
+		}
+		config.DisplayLocation = loc
+	}
+
+	config.ListingCacheTTL, err = env.Duration("LISTING_CACHE_TTL", DefaultListingCacheTTL, env.Min[time.Duration](0))
+	if err != nil {
+		return nil, err
+	}
+
+	config.UploadLoopThreshold, err = env.Int("UPLOAD_LOOP_THRESHOLD", 0, env.Min(0))
+	if err != nil {
+		return nil, err
+	}
+
+	config.UploadLoopWindow, err = env.Duration("UPLOAD_LOOP_WINDOW", DefaultUploadLoopWindow, env.Min(time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	config.UserCacheSize, err = env.Int("USER_CACHE_SIZE", DefaultUserCacheSize, env.Min(0))
+	if err != nil {
+		return nil, err
+	}
+
+	config.UserCacheTTL, err = env.Duration("USER_CACHE_TTL", DefaultUserCacheTTL, env.Min[time.Duration](0))
+	if err != nil {
+		return nil, err
+	}
+
+	statementBudget := 0
+	if config.appEnv == "dev" {
+		statementBudget = DefaultStatementBudget
+	}
+
+	config.StatementBudget, err = env.Int("DB_STATEMENT_BUDGET", statementBudget, env.Min(0))
+	if err != nil {
+		return nil, err
+	}
+
+	config.TreeDepthThreshold, err = env.Int("TREE_DEPTH_THRESHOLD", 0, env.Min(0))
+	if err != nil {
+		return nil, err
+	}
+
+	config.TreeClosureThreshold, err = env.Int("TREE_CLOSURE_THRESHOLD", 0, env.Min(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if config.appEnv == "dev" && os.Getenv("FAULT_INJECTION") == "true" {
+		config.FaultInjection = true
+		config.Faults = os.Getenv("FAULTS")
 	}
 
 	return config, nil
@@ -1,8 +1,8 @@
 package app
 
 import (
+	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 )
 
@@ -47,6 +47,10 @@ type WrappedSafeError struct {
 	// The HTTP status code that should be used. If a HTTP status code is not defined it will default
 	// to DefaultStatusCode.
 	statusCode int
+
+	// The name of the input field that caused the error. Empty if the error is not caused by a
+	// specific field.
+	field string
 }
 
 // WrapParams is the paramters used when wrapping an error with the Wrap func.
@@ -61,6 +65,10 @@ type WrapParams struct {
 	// The HTTP status code that will be set in the WrappedSafeError. This value will be returned
 	// by the Safe method.
 	StatusCode int
+
+	// The name of the input field that caused the error. This is optional, and will be returned by
+	// the Field method.
+	Field string
 }
 
 // Wrap will wrap an error in a WrappedSafeError.
@@ -84,6 +92,7 @@ func Wrap(w WrapParams) *WrappedSafeError {
 		err:         w.Err,
 		safeMessage: w.SafeMessage,
 		statusCode:  w.StatusCode,
+		field:       w.Field,
 	}
 }
 
@@ -104,6 +113,23 @@ func (e *WrappedSafeError) Safe() (string, int) {
 	return e.safeMessage, e.statusCode
 }
 
+// Field is the function that satisfies the FieldError interface.
+//
+// Field returns the name of the input field that caused the error.
+func (e *WrappedSafeError) Field() string {
+	return e.field
+}
+
+// FieldError is the interface that wraps the Field func.
+//
+// When an error is caused by a specific input field, and can be converted to a FieldError using
+// errors.As, the field name is included in the error response so it can be displayed next to
+// the field.
+type FieldError interface {
+	// Field returns the name of the input field that caused the error.
+	Field() string
+}
+
 // WriteJSONError writes a error message body to w. If the error can be converted to a SafeError, the message
 // and status code returned by the Safe function will be displayed to the end user. If the error cannot be
 // converted, the cause of the error will remain hidden from the end user. In this case, a generic message
@@ -122,6 +148,14 @@ func WriteJSONError(w http.ResponseWriter, err error) {
 		statusCode = DefaultStatusCode
 	}
 
+	var fieldError FieldError
+	if errors.As(err, &fieldError) && fieldError.Field() != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write(JSONFieldError(message, fieldError.Field(), statusCode))
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	w.Write(JSONError(message, statusCode))
@@ -130,6 +164,20 @@ func WriteJSONError(w http.ResponseWriter, err error) {
 // JSONError creates a JSON error response with a "error" and "status_code" field. The "error" field should
 // be a safe message that can be displayed to an end user.
 func JSONError(err string, statusCode int) []byte {
-	str := fmt.Sprintf(`{"error": "%s", "status_code": %d}`, err, statusCode)
-	return []byte(str)
+	return JSONFieldError(err, "", statusCode)
+}
+
+// JSONFieldError creates a JSON error response with a "error", "field", and "status_code" field. The "field"
+// field is the name of the input field that caused the error.
+//
+// The message and field are JSON encoded, so they may contain quotes and control characters. If
+// field is empty, the "field" field is omitted.
+func JSONFieldError(err string, field string, statusCode int) []byte {
+	body, _ := json.Marshal(&struct {
+		Error      string `json:"error"`
+		Field      string `json:"field,omitempty"`
+		StatusCode int    `json:"status_code"`
+	}{Error: err, Field: field, StatusCode: statusCode})
+
+	return body
 }
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteJSONError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{
+			name:   "unsafe error hidden",
+			err:    errors.New("dial tcp: connection refused"),
+			status: http.StatusInternalServerError,
+			body:   `{"error":"Something went wrong. Please try again later.","status_code":500}`,
+		},
+		{
+			name:   "safe error",
+			err:    Wrap(WrapParams{Err: errors.New("not found"), SafeMessage: "File not found", StatusCode: http.StatusNotFound}),
+			status: http.StatusNotFound,
+			body:   `{"error":"File not found","status_code":404}`,
+		},
+		{
+			name:   "field error",
+			err:    Wrap(WrapParams{SafeMessage: `Expiration must be "30d"`, StatusCode: http.StatusBadRequest, Field: "expiration"}),
+			status: http.StatusBadRequest,
+			body:   `{"error":"Expiration must be \"30d\"","field":"expiration","status_code":400}`,
+		},
+		{
+			name:   "wrapped field error",
+			err:    fmt.Errorf("creating token: %w", Wrap(WrapParams{SafeMessage: "Name is required", StatusCode: http.StatusBadRequest, Field: "name"})),
+			status: http.StatusBadRequest,
+			body:   `{"error":"Name is required","field":"name","status_code":400}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			WriteJSONError(w, tc.err)
+
+			if w.Code != tc.status {
+				t.Errorf("status = %d, want %d", w.Code, tc.status)
+			}
+
+			if got := w.Body.String(); got != tc.body {
+				t.Errorf("body = %s, want %s", got, tc.body)
+			}
+
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+		})
+	}
+}
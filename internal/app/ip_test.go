@@ -0,0 +1,105 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    string
+		wantErr bool
+	}{
+		{name: "ipv4 address", values: []string{"203.0.113.7"}, want: "[203.0.113.7/32]"},
+		{name: "ipv6 address", values: []string{"2001:db8::1"}, want: "[2001:db8::1/128]"},
+		{name: "cidr normalized", values: []string{" 203.0.113.7/24 "}, want: "[203.0.113.0/24]"},
+		{name: "empty values ignored", values: []string{"", "  ", "10.0.0.0/8"}, want: "[10.0.0.0/8]"},
+		{name: "none", values: nil, want: "[]"},
+		{name: "invalid address", values: []string{"203.0.113"}, wantErr: true},
+		{name: "invalid cidr", values: []string{"203.0.113.0/33"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			nets, err := ParseCIDRs(tc.values)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ParseCIDRs(%q) = %v, want an error", tc.values, nets)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCIDRs(%q) error = %v", tc.values, err)
+			}
+
+			if got := fmt.Sprint(nets); got != tc.want {
+				t.Errorf("ParseCIDRs(%q) = %s, want %s", tc.values, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestContainsIP(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"203.0.113.0/24", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   net.IP
+		want bool
+	}{
+		{net.ParseIP("203.0.113.200"), true},
+		{net.ParseIP("::ffff:203.0.113.200"), true},
+		{net.ParseIP("2001:db8::42"), true},
+		{net.ParseIP("198.51.100.1"), false},
+		{nil, false},
+	}
+
+	for _, tc := range tests {
+		if got := ContainsIP(nets, tc.ip); got != tc.want {
+			t.Errorf("ContainsIP(%v) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{name: "direct", remote: "198.51.100.1:5000", want: "198.51.100.1"},
+		{name: "forwarded by untrusted client ignored", remote: "198.51.100.1:5000", forwarded: "203.0.113.7", want: "198.51.100.1"},
+		{name: "forwarded by trusted proxy", remote: "10.0.0.2:5000", forwarded: "203.0.113.7", want: "203.0.113.7"},
+		{name: "chain of trusted proxies", remote: "10.0.0.2:5000", forwarded: "198.51.100.9, 203.0.113.7, 10.0.0.3", want: "203.0.113.7"},
+		{name: "invalid forwarded value stops the walk", remote: "10.0.0.2:5000", forwarded: "203.0.113.7, bogus, 10.0.0.3", want: "10.0.0.3"},
+		{name: "proxy without header", remote: "10.0.0.2:5000", want: "10.0.0.2"},
+		{name: "remote without port", remote: "198.51.100.1", want: "198.51.100.1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remote
+			if tc.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+
+			if got := ClientIP(r, proxies); got.String() != tc.want {
+				t.Errorf("ClientIP() = %v, want %s", got, tc.want)
+			}
+		})
+	}
+}
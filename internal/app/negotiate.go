@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Format is the format of a response that is not the content a handler serves, such as a error
+// written by a middleware.
+type Format string
+
+// The response formats.
+const (
+	// FormatHTML is a HTML page, or a redirect to one, for browser navigation.
+	FormatHTML Format = "html"
+
+	// FormatJSON is a JSON body, for API clients and fetch requests.
+	FormatJSON Format = "json"
+)
+
+// formatContextKey is the context key of the Format hint of a route.
+type formatContextKey struct{}
+
+// formatHint holds the Format hint of a route. It is stored in the context as a pointer, so a
+// hint set by a route middleware is also seen by the middlewares that reserved it before the
+// route was matched, such as server.Recover.
+type formatHint struct {
+	format Format
+}
+
+// WithFormat returns ctx with the Format hint f. If ctx already has a hint, it is replaced in
+// place and ctx is returned. A empty f reserves a hint that a inner middleware may set.
+func WithFormat(ctx context.Context, f Format) context.Context {
+	if h, ok := ctx.Value(formatContextKey{}).(*formatHint); ok {
+		if f != "" {
+			h.format = f
+		}
+
+		return ctx
+	}
+
+	return context.WithValue(ctx, formatContextKey{}, &formatHint{format: f})
+}
+
+// FormatHint returns the Format hint of ctx. If no hint is set, it is empty.
+func FormatHint(ctx context.Context) Format {
+	if h, ok := ctx.Value(formatContextKey{}).(*formatHint); ok {
+		return h.format
+	}
+
+	return ""
+}
+
+// Negotiate returns the Format to respond to r with. The signals are checked in order:
+//
+//  1. A X-Requested-With header, set by fetch and XMLHttpRequest callers, is FormatJSON.
+//  2. A Accept header that prefers text/html or application/json over the other is that Format.
+//     Wildcards are ignored, so the "*/*" sent by fetch by default is not a preference.
+//  3. The Format hint of the route, see WithFormat.
+//  4. fallback.
+func Negotiate(r *http.Request, fallback Format) Format {
+	if r.Header.Get("X-Requested-With") != "" {
+		return FormatJSON
+	}
+
+	if f := acceptFormat(r.Header.Values("Accept")); f != "" {
+		return f
+	}
+
+	if f := FormatHint(r.Context()); f != "" {
+		return f
+	}
+
+	return fallback
+}
+
+// acceptFormat returns the Format preferred by the Accept header values. If neither HTML nor
+// JSON is accepted, or both are accepted with the same quality, it is empty.
+func acceptFormat(values []string) Format {
+	var htmlQ, jsonQ float64
+
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+			if s, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(s, 64); err != nil {
+					continue
+				}
+			}
+
+			switch {
+			case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+				htmlQ = max(htmlQ, q)
+			case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+				jsonQ = max(jsonQ, q)
+			}
+		}
+	}
+
+	switch {
+	case htmlQ > jsonQ:
+		return FormatHTML
+	case jsonQ > htmlQ:
+		return FormatJSON
+	default:
+		return ""
+	}
+}
+
+// WriteError writes err in format. FormatJSON is written with WriteJSONError. Any other format
+// is written as a plain text page with the safe message and status code of err.
+func WriteError(w http.ResponseWriter, err error, format Format) {
+	if format == FormatJSON {
+		WriteJSONError(w, err)
+		return
+	}
+
+	message, statusCode := DefaultSafeMessage, DefaultStatusCode
+
+	var safeError SafeError
+	if errors.As(err, &safeError) {
+		message, statusCode = safeError.Safe()
+	}
+
+	http.Error(w, message, statusCode)
+}
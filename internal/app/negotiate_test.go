@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name      string
+		accept    []string
+		requested string
+		hint      Format
+		fallback  Format
+		want      Format
+	}{
+		{name: "fallback", fallback: FormatHTML, want: FormatHTML},
+		{name: "hint", hint: FormatJSON, fallback: FormatHTML, want: FormatJSON},
+		{name: "requested with", requested: "XMLHttpRequest", hint: FormatHTML, want: FormatJSON},
+		{name: "requested with over accept", accept: []string{"text/html"}, requested: "fetch", want: FormatJSON},
+		{name: "browser navigation", accept: []string{"text/html,application/xhtml+xml,*/*;q=0.8"}, hint: FormatJSON, want: FormatHTML},
+		{name: "accept json", accept: []string{"application/json"}, hint: FormatHTML, want: FormatJSON},
+		{name: "accept json suffix", accept: []string{"application/problem+json"}, fallback: FormatHTML, want: FormatJSON},
+		{name: "accept quality", accept: []string{"text/html;q=0.5, application/json;q=0.9"}, want: FormatJSON},
+		{name: "accept many headers", accept: []string{"application/json;q=0.1", "text/html"}, want: FormatHTML},
+		{name: "wildcard is not a preference", accept: []string{"*/*"}, hint: FormatJSON, fallback: FormatHTML, want: FormatJSON},
+		{name: "equal quality", accept: []string{"text/html, application/json"}, fallback: FormatHTML, want: FormatHTML},
+		{name: "malformed quality", accept: []string{"application/json;q=high"}, fallback: FormatHTML, want: FormatHTML},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, v := range tc.accept {
+				r.Header.Add("Accept", v)
+			}
+
+			if tc.requested != "" {
+				r.Header.Set("X-Requested-With", tc.requested)
+			}
+
+			if tc.hint != "" {
+				r = r.WithContext(WithFormat(r.Context(), tc.hint))
+			}
+
+			if got := Negotiate(r, tc.fallback); got != tc.want {
+				t.Errorf("Negotiate() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithFormat(t *testing.T) {
+	if got := FormatHint(context.Background()); got != "" {
+		t.Errorf("FormatHint() without a hint = %q, want empty", got)
+	}
+
+	// A reserved hint is set in place, so the outer context sees the hint of the inner one.
+	outer := WithFormat(context.Background(), "")
+	inner := WithFormat(outer, FormatJSON)
+
+	if inner != outer {
+		t.Error("WithFormat() with a reserved hint returned a new context")
+	}
+
+	if got := FormatHint(outer); got != FormatJSON {
+		t.Errorf("FormatHint() of the outer context = %q, want %q", got, FormatJSON)
+	}
+
+	// A empty format does not clear a hint.
+	WithFormat(outer, "")
+	if got := FormatHint(outer); got != FormatJSON {
+		t.Errorf("FormatHint() after a empty format = %q, want %q", got, FormatJSON)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	err := Wrap(WrapParams{Err: errors.New("missing"), SafeMessage: "Not found", StatusCode: http.StatusNotFound})
+
+	tests := []struct {
+		format      Format
+		err         error
+		wantStatus  int
+		wantType    string
+		wantMessage string
+	}{
+		{format: FormatJSON, err: err, wantStatus: http.StatusNotFound, wantType: "application/json", wantMessage: "Not found"},
+		{format: FormatHTML, err: err, wantStatus: http.StatusNotFound, wantType: "text/plain; charset=utf-8", wantMessage: "Not found"},
+		{format: FormatHTML, err: errors.New("pq: connection reset"), wantStatus: DefaultStatusCode, wantType: "text/plain; charset=utf-8", wantMessage: DefaultSafeMessage},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		WriteError(w, tc.err, tc.format)
+
+		if w.Code != tc.wantStatus || w.Header().Get("Content-Type") != tc.wantType {
+			t.Errorf("WriteError(%s) = %d %s, want %d %s", tc.format, w.Code, w.Header().Get("Content-Type"), tc.wantStatus, tc.wantType)
+		}
+
+		if !strings.Contains(w.Body.String(), tc.wantMessage) {
+			t.Errorf("WriteError(%s) body = %q, want it to contain %q", tc.format, w.Body.String(), tc.wantMessage)
+		}
+	}
+}
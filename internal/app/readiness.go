@@ -0,0 +1,139 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Probe is the result of a single warm-up check.
+type Probe struct {
+	// Name identifies the check, for example "fs_write".
+	Name string
+
+	// Duration is how long the check took.
+	Duration time.Duration
+
+	// Err is the error the check failed with. It is nil if the check passed.
+	Err error
+}
+
+// RunProbe runs fn and returns its result as a Probe named name.
+func RunProbe(name string, fn func() error) Probe {
+	start := time.Now()
+	err := fn()
+	return Probe{Name: name, Duration: time.Since(start), Err: err}
+}
+
+// Readiness tracks if the application has finished warming up. The zero value is
+// not ready.
+//
+// Checks added with AddCheck are run every time the readiness is reported. Unlike the
+// warm-up probes, a failed check makes the application unready until it passes again.
+type Readiness struct {
+	mu     sync.RWMutex
+	done   bool
+	probes []Probe
+	checks []check
+}
+
+// check is a named readiness check.
+type check struct {
+	name string
+	fn   func() error
+}
+
+// AddCheck adds a check named name that must pass for the application to be ready.
+func (r *Readiness) AddCheck(name string, fn func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks = append(r.checks, check{name: name, fn: fn})
+}
+
+// Complete marks the application as ready and records the warm-up probes.
+func (r *Readiness) Complete(probes []Probe) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.done = true
+	r.probes = probes
+}
+
+// Ready returns if Complete has been called and every check passes.
+func (r *Readiness) Ready() bool {
+	ready, _ := r.runChecks()
+	return ready
+}
+
+// runChecks runs the checks and returns if the application is ready and the result of
+// every check.
+func (r *Readiness) runChecks() (bool, []Probe) {
+	r.mu.RLock()
+	done := r.done
+	checks := r.checks
+	r.mu.RUnlock()
+
+	ready := done
+	results := make([]Probe, 0, len(checks))
+	for _, c := range checks {
+		p := RunProbe(c.name, c.fn)
+		if p.Err != nil {
+			ready = false
+		}
+		results = append(results, p)
+	}
+
+	return ready, results
+}
+
+// Handler returns a http.HandlerFunc that writes the readiness as a JSON response.
+// The status code is 503 until Complete is called, and 200 after. Failed probes are
+// included in the response but do not make the application unready. Checks are
+// included as probes, a failed check makes the status code 503.
+func (r *Readiness) Handler() http.HandlerFunc {
+	type probe struct {
+		Name       string  `json:"name"`
+		DurationMS float64 `json:"duration_ms"`
+		Error      string  `json:"error,omitempty"`
+	}
+
+	type response struct {
+		Ready  bool    `json:"ready"`
+		Probes []probe `json:"probes"`
+	}
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		ready, checks := r.runChecks()
+
+		r.mu.RLock()
+		probes := append(append([]Probe{}, r.probes...), checks...)
+		r.mu.RUnlock()
+
+		resp := response{Ready: ready, Probes: []probe{}}
+		for _, p := range probes {
+			pr := probe{Name: p.Name, DurationMS: float64(p.Duration.Microseconds()) / 1000}
+			if p.Err != nil {
+				// Probe errors may contain file system paths, only expose that it failed.
+				pr.Error = "failed"
+			}
+			resp.Probes = append(resp.Probes, pr)
+		}
+
+		body, err := json.Marshal(&resp)
+		if err != nil {
+			WriteJSONError(w, err)
+			return
+		}
+
+		status := http.StatusOK
+		if !resp.Ready {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
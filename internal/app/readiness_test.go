@@ -0,0 +1,68 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	type response struct {
+		Ready  bool `json:"ready"`
+		Probes []struct {
+			Name  string `json:"name"`
+			Error string `json:"error"`
+		} `json:"probes"`
+	}
+
+	get := func(t *testing.T, r *Readiness) (int, response) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r.Handler()(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var resp response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshalling response %s: %v", w.Body, err)
+		}
+
+		return w.Code, resp
+	}
+
+	r := &Readiness{}
+	if status, resp := get(t, r); status != http.StatusServiceUnavailable || resp.Ready || len(resp.Probes) != 0 {
+		t.Errorf("before Complete = %d, %+v, want 503 and not ready", status, resp)
+	}
+
+	// A failed warm-up probe is reported, but does not make the application unready. Its
+	// error may contain a path, it is not written.
+	r.Complete([]Probe{
+		{Name: "fs_stat", Duration: time.Millisecond},
+		{Name: "fs_list", Err: errors.New("open /srv/clox: permission denied")},
+	})
+
+	status, resp := get(t, r)
+	if status != http.StatusOK || !resp.Ready {
+		t.Errorf("after Complete = %d, %+v, want 200 and ready", status, resp)
+	}
+
+	if len(resp.Probes) != 2 || resp.Probes[0].Error != "" || resp.Probes[1].Error != "failed" {
+		t.Errorf("probes = %+v, want fs_stat passed and fs_list failed", resp.Probes)
+	}
+
+	// A failed check makes the application unready until it passes again.
+	checkErr := errors.New("disk full")
+	r.AddCheck("fs_free_space", func() error { return checkErr })
+
+	if status, resp := get(t, r); status != http.StatusServiceUnavailable || resp.Ready || len(resp.Probes) != 3 {
+		t.Errorf("with a failed check = %d, %+v, want 503 and the check reported", status, resp)
+	}
+
+	checkErr = nil
+	if !r.Ready() {
+		t.Error("Ready() = false once the check passes, want true")
+	}
+}
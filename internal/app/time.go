@@ -0,0 +1,60 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// Time is a time.Time that is written to JSON as an RFC 3339 UTC timestamp. A zero Time is
+// written as null.
+//
+// Every timestamp in a JSON response should be a Time so clients see one format regardless
+// of the location the time was read in.
+type Time struct {
+	time.Time
+}
+
+// NewTime creates a Time from t.
+func NewTime(t time.Time) Time {
+	return Time{Time: t}
+}
+
+// String returns t formatted as RFC 3339 in UTC. If t is zero, an empty string is returned.
+func (t Time) String() string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+
+	return []byte(`"` + t.String() + `"`), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. It accepts null or an RFC 3339
+// timestamp.
+func (t *Time) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return fmt.Errorf("invalid time: %s", data)
+	}
+
+	parsed, err := time.Parse(time.RFC3339, string(data[1:len(data)-1]))
+	if err != nil {
+		return fmt.Errorf("parsing time: %w", err)
+	}
+
+	t.Time = parsed.UTC()
+	return nil
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimeMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		time time.Time
+		want string
+	}{
+		{name: "utc", time: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), want: `"2024-03-01T12:30:00Z"`},
+		{name: "converted to utc", time: time.Date(2024, 3, 1, 7, 30, 0, 0, time.FixedZone("EST", -5*60*60)), want: `"2024-03-01T12:30:00Z"`},
+		{name: "previous day in utc", time: time.Date(2024, 3, 1, 0, 30, 0, 0, time.FixedZone("CET", 60*60)), want: `"2024-02-29T23:30:00Z"`},
+		{name: "fractional seconds dropped", time: time.Date(2024, 3, 1, 12, 30, 0, 999, time.UTC), want: `"2024-03-01T12:30:00Z"`},
+		{name: "zero", time: time.Time{}, want: `null`},
+		{name: "zero in another location", time: time.Time{}.In(time.FixedZone("EST", -5*60*60)), want: `null`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := json.Marshal(NewTime(tc.time))
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+
+			if string(got) != tc.want {
+				t.Errorf("Marshal() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTimeMarshalJSONField(t *testing.T) {
+	type response struct {
+		CreatedAt Time  `json:"created_at"`
+		UpdatedAt Time  `json:"updated_at"`
+		DeletedAt *Time `json:"deleted_at,omitempty"`
+	}
+
+	got, err := json.Marshal(response{CreatedAt: NewTime(time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC))})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	want := `{"created_at":"2024-03-01T12:30:00Z","updated_at":null}`
+	if string(got) != want {
+		t.Errorf("Marshal() = %s, want %s", got, want)
+	}
+}
+
+func TestTimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "utc", data: `"2024-03-01T12:30:00Z"`, want: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{name: "offset", data: `"2024-03-01T07:30:00-05:00"`, want: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{name: "null", data: `null`},
+		{name: "not rfc3339", data: `"2024-03-01 12:30:00"`, wantErr: true},
+		{name: "not a string", data: `1709296200`, wantErr: true},
+		{name: "empty string", data: `""`, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := NewTime(time.Now())
+			err := json.Unmarshal([]byte(tc.data), &got)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if tc.wantErr {
+				return
+			}
+
+			if !got.Equal(tc.want) || got.Location() != time.UTC {
+				t.Errorf("Unmarshal() = %v, want %v in UTC", got.Time, tc.want)
+			}
+		})
+	}
+}
+
+func TestTimeRoundTrip(t *testing.T) {
+	for _, want := range []time.Time{{}, time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)} {
+		data, err := json.Marshal(NewTime(want))
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+
+		var got Time
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", data, err)
+		}
+
+		if !got.Equal(want) {
+			t.Errorf("round trip of %v = %v", want, got.Time)
+		}
+	}
+}
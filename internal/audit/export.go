@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/cicconee/clox/internal/event"
+)
+
+// exportBatchSize is the number of events read from the database at a time.
+const exportBatchSize = 500
+
+// Manifest describes a export so it can be verified later. It is the last line of a export.
+type Manifest struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Count is the number of events in the export.
+	Count int64 `json:"count"`
+
+	// SHA256 is the hex encoded SHA-256 digest of every line of the export before the
+	// manifest, including their newlines.
+	SHA256 string `json:"sha256"`
+
+	// Signature is the hex encoded HMAC-SHA256 of the other fields, see Exporter.Verify.
+	Signature string `json:"signature"`
+}
+
+// manifestLine is the JSON object of the manifest line, so it cannot be mistaken for a
+// event.
+type manifestLine struct {
+	Manifest Manifest `json:"manifest"`
+}
+
+// Exporter writes the events of the audit log as NDJSON. The key manifests are signed with
+// must be set with SetSecret.
+//
+// Exporter should be created using the NewExporter function.
+type Exporter struct {
+	repo *event.Repo
+	key  []byte
+}
+
+// NewExporter creates a new Exporter.
+func NewExporter(repo *event.Repo) *Exporter {
+	if repo == nil {
+		panic("audit.NewExporter: cannot create Exporter with nil Repo")
+	}
+
+	return &Exporter{repo: repo}
+}
+
+// SetSecret sets the secret used to sign manifests. The signing key is derived from secret,
+// so the same secret can safely be shared with other components (such as the JWT manager).
+func (e *Exporter) SetSecret(secret string) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("clox-audit-export"))
+	e.key = mac.Sum(nil)
+}
+
+// Export writes the events created at or after from and before to to w, one JSON object
+// per line in increasing Seq order, followed by the signed Manifest. The same window of
+// the same events is always written byte for byte the same.
+//
+// If Export fails part way, the manifest is not written. A export without a manifest
+// is incomplete.
+func (e *Exporter) Export(ctx context.Context, w io.Writer, from time.Time, to time.Time) (Manifest, error) {
+	digest := sha256.New()
+	out := io.MultiWriter(w, digest)
+
+	m := Manifest{From: from.UTC(), To: to.UTC()}
+
+	var line bytes.Buffer
+	var afterSeq int64
+	for {
+		events, err := e.repo.SelectRange(ctx, m.From, m.To, afterSeq, exportBatchSize)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("selecting events after %d: %w", afterSeq, err)
+		}
+
+		for _, ev := range events {
+			line.Reset()
+			if err := json.NewEncoder(&line).Encode(ev); err != nil {
+				return Manifest{}, fmt.Errorf("marshalling event [seq: %d]: %w", ev.Seq, err)
+			}
+
+			if _, err := out.Write(line.Bytes()); err != nil {
+				return Manifest{}, err
+			}
+
+			m.Count++
+			afterSeq = ev.Seq
+		}
+
+		if len(events) < exportBatchSize {
+			break
+		}
+	}
+
+	m.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	m.Signature = hex.EncodeToString(e.sign(m).Sum(nil))
+
+	if err := json.NewEncoder(w).Encode(manifestLine{Manifest: m}); err != nil {
+		return Manifest{}, err
+	}
+
+	return m, nil
+}
+
+// Verify reports whether the Signature of m was made by this Exporter, with the same
+// secret.
+func (e *Exporter) Verify(m Manifest) bool {
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(sig, e.sign(m).Sum(nil))
+}
+
+// sign returns the HMAC of the fields of m other than Signature.
+func (e *Exporter) sign(m Manifest) hash.Hash {
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write([]byte(m.From.Format(time.RFC3339Nano)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(m.To.Format(time.RFC3339Nano)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(strconv.FormatInt(m.Count, 10)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(m.SHA256))
+
+	return mac
+}
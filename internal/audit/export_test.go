@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/event"
+)
+
+func TestExporterVerify(t *testing.T) {
+	e := NewExporter(event.NewRepo(nil))
+	e.SetSecret("secret")
+
+	m := Manifest{
+		From:   time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+		Count:  2,
+		SHA256: strings.Repeat("a", 64),
+	}
+	m.Signature = hex.EncodeToString(e.sign(m).Sum(nil))
+
+	if !e.Verify(m) {
+		t.Fatal("Verify() = false, want true")
+	}
+
+	tampered := m
+	tampered.Count = 3
+	if e.Verify(tampered) {
+		t.Error("Verify() of a tampered manifest = true, want false")
+	}
+
+	other := NewExporter(event.NewRepo(nil))
+	other.SetSecret("other")
+	if other.Verify(m) {
+		t.Error("Verify() with another secret = true, want false")
+	}
+
+	m.Signature = "not hex"
+	if e.Verify(m) {
+		t.Error("Verify() of a malformed signature = true, want false")
+	}
+}
+
+func TestExporterExport(t *testing.T) {
+	ctx := context.Background()
+	tx := dbtest.Tx(t)
+	userID := insertUser(t, tx)
+
+	from := time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	// The events are inserted out of creation order, they are exported by Seq. Events
+	// outside the window are not exported.
+	insertEvent(t, tx, userID, from.Add(-time.Second), time.Time{})
+	want := []int64{
+		insertEvent(t, tx, userID, from.Add(2*time.Hour), time.Time{}),
+		insertEvent(t, tx, userID, from, to),
+		insertEvent(t, tx, userID, to.Add(-time.Second), time.Time{}),
+	}
+	insertEvent(t, tx, userID, to, time.Time{})
+
+	e := NewExporter(event.NewRepo(tx))
+	e.SetSecret("secret")
+
+	// The window is in another time zone, it is exported in UTC.
+	est := time.FixedZone("EST", -5*60*60)
+
+	var first bytes.Buffer
+	m, err := e.Export(ctx, &first, from.In(est), to.In(est))
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	var second bytes.Buffer
+	if _, err := e.Export(ctx, &second, from, to); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("Export() of the same window differs:\n%s\n%s", first.Bytes(), second.Bytes())
+	}
+
+	lines := strings.SplitAfter(strings.TrimSuffix(first.String(), "\n"), "\n")
+	if len(lines) != len(want)+1 {
+		t.Fatalf("Export() wrote %d lines, want %d events and the manifest", len(lines), len(want))
+	}
+
+	events := strings.Join(lines[:len(want)], "")
+	for i, line := range lines[:len(want)] {
+		var ev event.Event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("unmarshalling line %d: %v", i, err)
+		}
+
+		if ev.Seq != want[i] {
+			t.Errorf("line %d seq = %d, want %d", i, ev.Seq, want[i])
+		}
+	}
+
+	var last manifestLine
+	if err := json.Unmarshal([]byte(lines[len(want)]), &last); err != nil {
+		t.Fatalf("unmarshalling manifest: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(events))
+	if last.Manifest.Signature != m.Signature || m.Count != int64(len(want)) || m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("manifest = %+v, want %d events with the digest of the lines", last.Manifest, len(want))
+	}
+
+	if !m.From.Equal(from) || m.From.Location() != time.UTC {
+		t.Errorf("manifest from = %s, want %s", m.From, from)
+	}
+
+	if !e.Verify(last.Manifest) {
+		t.Error("Verify() of the written manifest = false, want true")
+	}
+}
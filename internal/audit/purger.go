@@ -0,0 +1,119 @@
+// Package audit exports and purges the audit log.
+//
+// The audit log is the outbox of the event package. Every committed change to a users storage
+// is appended to it with an increasing sequence number, and it is never updated other than to
+// record delivery. A Purger deletes the events older than the retention period, and a Exporter
+// writes the events of a time window as a signed NDJSON stream.
+//
+// Both read and delete the outbox in small batches, neither holds a lock that would block new
+// events from being inserted.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cicconee/clox/internal/event"
+)
+
+// The default Purger configuration.
+const (
+	DefaultRetentionMonths = 13
+	DefaultPurgeInterval   = time.Hour
+	DefaultPurgeBatchSize  = 1000
+)
+
+// Purger deletes the events of the audit log that are older than the retention period.
+// Events that have not been published are kept until they are, the Dispatcher still needs
+// them.
+//
+// A nil Purger is valid and purges nothing. Purger should be created using the NewPurger
+// function.
+type Purger struct {
+	repo      *event.Repo
+	months    int
+	interval  time.Duration
+	batchSize int
+	log       *log.Logger
+}
+
+// NewPurger creates a new Purger that keeps events for months calendar months. If months is
+// not positive, purging is disabled and nil is returned.
+//
+// If logger is nil, it will default to log.Default().
+func NewPurger(repo *event.Repo, months int, logger *log.Logger) *Purger {
+	if months <= 0 {
+		return nil
+	}
+
+	if repo == nil {
+		panic("audit.NewPurger: cannot create Purger with nil Repo")
+	}
+
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &Purger{
+		repo:      repo,
+		months:    months,
+		interval:  DefaultPurgeInterval,
+		batchSize: DefaultPurgeBatchSize,
+		log:       logger,
+	}
+}
+
+// Cutoff returns the time events created before are purged at now.
+func (p *Purger) Cutoff(now time.Time) time.Time {
+	return now.UTC().AddDate(0, -p.months, 0)
+}
+
+// Run purges the audit log every interval until ctx is done. Errors are logged.
+func (p *Purger) Run(ctx context.Context) {
+	if p == nil {
+		return
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.Purge(ctx, time.Now())
+		if err != nil {
+			p.log.Printf("[ERROR] Purging audit log: %v\n", err)
+		} else if n > 0 {
+			p.log.Printf("[INFO] Purged audit log [events: %d]\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes the published events created before Cutoff(now), a batch at a time. The
+// number of events deleted is returned.
+func (p *Purger) Purge(ctx context.Context, now time.Time) (int64, error) {
+	if p == nil {
+		return 0, nil
+	}
+
+	cutoff := p.Cutoff(now)
+
+	var total int64
+	for {
+		n, err := p.repo.DeletePublishedBefore(ctx, cutoff, p.batchSize)
+		if err != nil {
+			return total, fmt.Errorf("deleting events before %s: %w", cutoff.Format(time.RFC3339), err)
+		}
+
+		total += n
+		if n < int64(p.batchSize) {
+			return total, nil
+		}
+	}
+}
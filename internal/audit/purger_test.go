@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/event"
+	"github.com/google/uuid"
+)
+
+// insertEvent inserts a event of the user userID created at createdAt into the outbox of
+// tx. If publishedAt is zero, the event is not published. The Seq of the event is returned.
+func insertEvent(t *testing.T, tx event.DBTX, userID string, createdAt time.Time, publishedAt time.Time) int64 {
+	t.Helper()
+
+	var seq int64
+	err := tx.QueryRow(context.Background(),
+		`INSERT INTO outbox (id, user_id, type, payload, created_at, published_at, next_attempt_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $5)
+		 RETURNING seq`,
+		uuid.NewString(), userID, event.TypeDirCreated, `{"name":"photos"}`, createdAt,
+		sql.NullTime{Time: publishedAt, Valid: !publishedAt.IsZero()}).Scan(&seq)
+	if err != nil {
+		t.Fatalf("inserting event: %v", err)
+	}
+
+	return seq
+}
+
+// insertUser inserts a new user into tx and returns its ID.
+func insertUser(t *testing.T, tx event.DBTX) string {
+	t.Helper()
+
+	userID := uuid.NewString()
+	_, err := tx.Exec(context.Background(), `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+
+	return userID
+}
+
+func TestNewPurgerDisabled(t *testing.T) {
+	p := NewPurger(nil, 0, nil)
+	if p != nil {
+		t.Fatal("NewPurger() with no retention is not nil")
+	}
+
+	// A nil Purger purges nothing.
+	if n, err := p.Purge(context.Background(), time.Now()); n != 0 || err != nil {
+		t.Errorf("Purge() on a nil Purger = %d, %v, want 0, nil", n, err)
+	}
+}
+
+func TestPurgerCutoff(t *testing.T) {
+	p := NewPurger(event.NewRepo(nil), DefaultRetentionMonths, nil)
+
+	// The retention is in calendar months, in UTC.
+	est := time.FixedZone("EST", -5*60*60)
+	now := time.Date(2024, time.March, 31, 22, 0, 0, 0, est)
+	want := time.Date(2023, time.March, 1, 3, 0, 0, 0, time.UTC)
+
+	if got := p.Cutoff(now); !got.Equal(want) {
+		t.Errorf("Cutoff() = %s, want %s", got, want)
+	}
+}
+
+func TestPurgerPurge(t *testing.T) {
+	ctx := context.Background()
+	tx := dbtest.Tx(t)
+	userID := insertUser(t, tx)
+
+	// The events are long before any other test data, so only they are old enough to
+	// be purged.
+	cutoff := time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+	now := cutoff.AddDate(0, 1, 0)
+	published := cutoff.AddDate(0, 0, 1)
+
+	expired := []int64{
+		insertEvent(t, tx, userID, cutoff.Add(-time.Second), published),
+		insertEvent(t, tx, userID, cutoff.AddDate(0, -1, 0), published),
+		insertEvent(t, tx, userID, cutoff.AddDate(0, -2, 0), published),
+	}
+	kept := []int64{
+		insertEvent(t, tx, userID, cutoff, published),
+		insertEvent(t, tx, userID, cutoff.AddDate(0, -1, 0), time.Time{}),
+	}
+
+	p := NewPurger(event.NewRepo(tx), 1, nil)
+	p.batchSize = 2
+
+	n, err := p.Purge(ctx, now)
+	if err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+
+	if n != int64(len(expired)) {
+		t.Errorf("Purge() = %d, want %d", n, len(expired))
+	}
+
+	// Events at the cutoff, and events that have not been published, are kept.
+	for _, seqs := range []struct {
+		seqs []int64
+		want bool
+	}{{expired, false}, {kept, true}} {
+		for _, seq := range seqs.seqs {
+			var exists bool
+			if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM outbox WHERE seq = $1)`, seq).Scan(&exists); err != nil {
+				t.Fatalf("selecting event: %v", err)
+			}
+
+			if exists != seqs.want {
+				t.Errorf("event %d exists = %t, want %t", seq, exists, seqs.want)
+			}
+		}
+	}
+}
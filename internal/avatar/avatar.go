@@ -0,0 +1,191 @@
+// Package avatar stores copies of user profile pictures so they can be served locally instead
+// of hotlinking the provider.
+package avatar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound signals a user does not have a stored avatar.
+	ErrNotFound = errors.New("avatar not found")
+
+	// ErrInvalidSource signals a picture URL or its response cannot be used as an avatar.
+	ErrInvalidSource = errors.New("invalid avatar source")
+)
+
+// MaxBytes is the maximum size of an avatar image.
+const MaxBytes = 1 << 20
+
+// contentTypes are the image content types accepted as avatars.
+var contentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Service fetches, stores, and opens user avatars. Avatars are stored as files in a
+// directory, keyed by the users ID.
+type Service struct {
+	dir    string
+	client *http.Client
+	log    *log.Logger
+}
+
+// NewService creates a new Service that stores avatars in dir. The directory is created
+// when the first avatar is stored.
+func NewService(dir string, log *log.Logger) *Service {
+	return &Service{
+		dir:    dir,
+		client: &http.Client{Timeout: 10 * time.Second},
+		log:    log,
+	}
+}
+
+// Fetch downloads the picture at pictureURL and stores it as the avatar of a user,
+// replacing any stored avatar.
+//
+// The picture must be served over HTTPS, be one of the accepted image types (by both
+// the Content-Type header and its content), and be at most MaxBytes. Otherwise a
+// ErrInvalidSource is returned and the stored avatar is unchanged.
+func (s *Service) Fetch(ctx context.Context, userID string, pictureURL string) error {
+	u, err := url.Parse(pictureURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%w: %q is not a https URL", ErrInvalidSource, pictureURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting picture: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: status code %d", ErrInvalidSource, resp.StatusCode)
+	}
+
+	if ct := mediaType(resp.Header.Get("Content-Type")); !contentTypes[ct] {
+		return fmt.Errorf("%w: content type %q", ErrInvalidSource, ct)
+	}
+
+	if resp.ContentLength > MaxBytes {
+		return fmt.Errorf("%w: %d bytes is larger than %d", ErrInvalidSource, resp.ContentLength, MaxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxBytes+1))
+	if err != nil {
+		return fmt.Errorf("reading picture: %w", err)
+	}
+
+	if len(data) > MaxBytes {
+		return fmt.Errorf("%w: larger than %d bytes", ErrInvalidSource, MaxBytes)
+	}
+
+	if ct := mediaType(http.DetectContentType(data)); !contentTypes[ct] {
+		return fmt.Errorf("%w: detected content type %q", ErrInvalidSource, ct)
+	}
+
+	return s.write(userID, data)
+}
+
+// write stores data as the avatar of a user. The data is written to a temporary file
+// that is renamed, so a partially written avatar is never served.
+func (s *Service) write(userID string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("creating avatar directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing temporary file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(userID)); err != nil {
+		return fmt.Errorf("renaming temporary file: %w", err)
+	}
+
+	return nil
+}
+
+// Open opens the stored avatar of a user. The caller must close the file. If the user
+// does not have a stored avatar, a ErrNotFound is returned.
+func (s *Service) Open(userID string) (*os.File, fs.FileInfo, error) {
+	f, err := os.Open(s.path(userID))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, ErrNotFound
+		}
+
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, info, nil
+}
+
+// FetchAsync calls Fetch in the background and logs the result. It should be used when
+// the caller does not need to wait for the avatar, such as when a user registers.
+func (s *Service) FetchAsync(userID string, pictureURL string) {
+	if pictureURL == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if err := s.Fetch(ctx, userID, pictureURL); err != nil {
+			s.log.Printf("[ERROR] Fetching avatar [user: %s]: %v\n", userID, err)
+			return
+		}
+
+		s.log.Printf("[INFO] Stored avatar [user: %s]\n", userID)
+	}()
+}
+
+// path returns the file path of the avatar of a user. User IDs may contain characters
+// that are not valid in file names, so the file is named by the hash of the ID.
+func (s *Service) path(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// mediaType returns the media type of a Content-Type value, without parameters.
+func mediaType(contentType string) string {
+	mt, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
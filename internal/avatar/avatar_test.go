@@ -0,0 +1,155 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// png is the start of a PNG image, enough for http.DetectContentType.
+var png = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// newTestService creates a Service storing avatars in a new temporary directory, and
+// a TLS server that answers every request with handler. The Service trusts the server.
+func newTestService(t *testing.T, handler http.HandlerFunc) (*Service, string) {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+
+	s := NewService(t.TempDir(), log.New(io.Discard, "", 0))
+	s.client = srv.Client()
+
+	return s, srv.URL
+}
+
+// readAvatar returns the stored avatar of a user, or nil if there is none.
+func readAvatar(t *testing.T, s *Service, userID string) []byte {
+	t.Helper()
+
+	f, _, err := s.Open(userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading avatar: %v", err)
+	}
+
+	return data
+}
+
+func TestFetch(t *testing.T) {
+	s, url := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	})
+
+	if err := s.Fetch(context.Background(), "google|1", url+"/ada.png"); err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+
+	if got := readAvatar(t, s, "google|1"); !bytes.Equal(got, png) {
+		t.Errorf("avatar = %q, want the fetched picture", got)
+	}
+
+	// The file is named by the hash of the user ID, and no temporary file is left.
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		t.Fatalf("reading avatar directory: %v", err)
+	}
+
+	if len(entries) != 1 || strings.Contains(entries[0].Name(), "|") {
+		t.Errorf("avatar directory entries = %v, want one hashed file", entries)
+	}
+}
+
+func TestFetchInvalidSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		url     func(srv string) string
+	}{
+		{
+			name: "not https",
+			url:  func(srv string) string { return strings.Replace(srv, "https://", "http://", 1) },
+		},
+		{
+			name:    "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+		},
+		{
+			name: "content type not an image",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Write(png)
+			},
+		},
+		{
+			name: "content not an image",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write([]byte("<html><body>not a picture</body></html>"))
+			},
+		},
+		{
+			name: "too large",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write(png)
+				w.Write(make([]byte, MaxBytes))
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := tc.handler
+			if handler == nil {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "image/png")
+					w.Write(png)
+				}
+			}
+
+			s, url := newTestService(t, handler)
+			if tc.url != nil {
+				url = tc.url(url)
+			}
+
+			// A stored avatar is kept when the new picture is rejected.
+			if err := s.write("google|1", []byte("stored")); err != nil {
+				t.Fatalf("writing avatar: %v", err)
+			}
+
+			err := s.Fetch(context.Background(), "google|1", url+"/ada.png")
+			if !errors.Is(err, ErrInvalidSource) {
+				t.Fatalf("Fetch() error = %v, want ErrInvalidSource", err)
+			}
+
+			if got := readAvatar(t, s, "google|1"); string(got) != "stored" {
+				t.Errorf("avatar = %q, want the stored avatar unchanged", got)
+			}
+		})
+	}
+}
+
+func TestOpenNotFound(t *testing.T) {
+	s := NewService(t.TempDir(), log.New(io.Discard, "", 0))
+
+	if _, _, err := s.Open("google|1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() error = %v, want ErrNotFound", err)
+	}
+}
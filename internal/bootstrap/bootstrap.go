@@ -0,0 +1,213 @@
+// Package bootstrap opens the connections and builds the services shared by the Clox
+// binaries, so the API and the server side app are always wired the same way.
+//
+// A binary loads its configuration, calls Open with the shared app.Config, and passes the
+// Services to the NewServer function of its app package, which builds the services that
+// are specific to it.
+package bootstrap
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/db"
+	"github.com/cicconee/clox/internal/hook"
+	"github.com/cicconee/clox/internal/jwt"
+	"github.com/cicconee/clox/internal/pagination"
+	"github.com/cicconee/clox/internal/security"
+	"github.com/cicconee/clox/internal/token"
+	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/pkg/env"
+
+	_ "github.com/lib/pq"
+)
+
+// Services are the connections and services shared by the Clox binaries.
+//
+// Services should be created using the Open function, or the New function when the database and
+// cache are already open, and closed with Close once the binary stops.
+type Services struct {
+	Logger  *log.Logger
+	DB      *db.Postgres
+	Cache   *cache.Redis
+	JWTs    *jwt.Manager
+	Cursors *pagination.Codec
+
+	Users    *user.Service
+	Tokens   *token.Service
+	Security *security.Recorder
+
+	// Hooks runs the callbacks registered on CloudHooks and TokenHooks.
+	Hooks      *hook.Queue
+	CloudHooks *cloudstore.Hooks
+	TokenHooks *token.Hooks
+
+	// DirPerm and FilePerm are the parsed file store permissions.
+	DirPerm  cloudstore.Perm
+	FilePerm cloudstore.Perm
+
+	// Faults forces the database and file system of the cloudstore services to fail. It is nil
+	// unless fault injection is enabled, see app.Config.FaultInjection.
+	Faults *cloudstore.Faults
+
+	CloudStorage cloudstore.Storage
+	CloudPaths   *cloudstore.FSPathMapper
+	CloudIO      *cloudstore.IO
+	CloudDirs    *cloudstore.DirService
+	CloudFiles   *cloudstore.FileService
+}
+
+// Loader creates the environment variable loader of envFile.
+func Loader(envFile string) (*env.Loader, error) {
+	loader, err := env.NewFileLoader(envFile)
+	if err != nil {
+		return nil, fmt.Errorf("creating file loader for file %s: %w", envFile, err)
+	}
+
+	return loader, nil
+}
+
+// Open opens the database and cache of config and builds the shared services with New. If an
+// error is returned, everything that was opened is closed.
+func Open(config *app.Config, logger *log.Logger) (*Services, error) {
+	pg := &db.Postgres{}
+	if err := config.OpenDB(pg); err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	redis := &cache.Redis{}
+	if err := config.OpenCache(redis); err != nil {
+		if err := pg.Close(); err != nil {
+			logger.Printf("[ERROR] Closing the database connection: %v\n", err)
+		}
+		return nil, fmt.Errorf("opening cache: %w", err)
+	}
+
+	s, err := New(config, pg, redis, logger)
+	if err != nil {
+		(&Services{Logger: logger, DB: pg, Cache: redis}).Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// New builds the shared services of config on the opened database and cache. The database and
+// cache are not pinged, and are not closed if an error is returned.
+func New(config *app.Config, pg *db.Postgres, redis *cache.Redis, logger *log.Logger) (*Services, error) {
+	dirPerm, filePerm, err := cloudstore.ParsePerms(cloudstore.PermConfig{
+		Dir:                config.FSDirPerm,
+		File:               config.FSFilePerm,
+		AllowWorldWritable: config.AllowWorldWritable,
+	}, "FS_DIR_PERM", "FS_FILE_PERM")
+	if err != nil {
+		return nil, fmt.Errorf("parsing file store permissions: %w", err)
+	}
+
+	layout, err := cloudstore.ParseLayout(config.StorageLayout, "STORAGE_LAYOUT")
+	if err != nil {
+		return nil, fmt.Errorf("parsing file store layout: %w", err)
+	}
+
+	loopMode, err := cloudstore.ParseLoopMode(config.UploadLoopMode, "UPLOAD_LOOP_MODE")
+	if err != nil {
+		return nil, fmt.Errorf("parsing upload loop mode: %w", err)
+	}
+
+	s := &Services{Logger: logger, DB: pg, Cache: redis, DirPerm: dirPerm, FilePerm: filePerm}
+
+	if config.FaultInjection {
+		s.Faults, err = cloudstore.ParseFaults(config.Faults)
+		if err != nil {
+			return nil, fmt.Errorf("parsing FAULTS: %w", err)
+		}
+
+		logger.Printf("[WARN] Fault injection is enabled [armed: %v]\n", s.Faults.Armed())
+	}
+
+	s.JWTs = jwt.NewManager("clox-server-side-app", "clox-api")
+	config.SetJWTSecret(s.JWTs)
+
+	// Cursors derive their signing key from the JWT secret.
+	s.Cursors = pagination.NewCodec(0)
+	config.SetJWTSecret(s.Cursors)
+
+	// Hooks run the callbacks registered to extend Clox in the background.
+	s.Hooks = hook.NewQueue(0, logger)
+	s.CloudHooks = cloudstore.NewHooks(s.Hooks)
+	s.TokenHooks = token.NewHooks(s.Hooks)
+
+	s.Users = user.NewService(user.NewRepo(s.DB))
+	s.Users.SetCache(user.NewCache(config.UserCacheSize, config.UserCacheTTL))
+	s.Tokens = token.NewService(s.JWTs, s.Cache, token.NewRepo(s.DB))
+	s.Tokens.SetHooks(s.TokenHooks)
+	s.Tokens.SetLog(logger)
+	s.Security = security.NewRecorder(security.NewRepo(s.DB), 0, logger)
+
+	// Configure cloudstore dependencies.
+	store := cloudstore.NewStore(s.DB)
+	store.SetFaults(s.Faults)
+	s.CloudStorage = store
+	s.CloudPaths = cloudstore.NewFSPathMapper(config.FileStorePath)
+	s.CloudIO = cloudstore.NewIO(&cloudstore.OSFileSystem{Faults: s.Faults}, s.CloudPaths)
+
+	// Both binaries share the file store, whichever starts first creates the root.
+	if err := s.CloudIO.SetupRoot(dirPerm); err != nil {
+		return nil, fmt.Errorf("setting up root storage directory: %w", err)
+	}
+
+	listings := cloudstore.NewListingCache(s.Cache, config.ListingCacheTTL, logger)
+
+	// Configure cloudstore services.
+	s.CloudDirs = cloudstore.NewDirService(cloudstore.DirServiceConfig{
+		Store:    s.CloudStorage,
+		IO:       s.CloudIO,
+		Log:      logger,
+		PathMap:  s.CloudPaths,
+		Perm:     dirPerm,
+		Listings: listings,
+		Cache:    s.Cache,
+		Location: config.DisplayLocation,
+		Layout:   layout,
+		Hooks:    s.CloudHooks,
+		TreeThresholds: cloudstore.TreeThresholds{
+			Depth:       config.TreeDepthThreshold,
+			ClosureRows: int64(config.TreeClosureThreshold),
+		},
+	})
+
+	s.CloudFiles = cloudstore.NewFileService(cloudstore.FileServiceConfig{
+		Store:        s.CloudStorage,
+		IO:           s.CloudIO,
+		Log:          logger,
+		ValidateUser: s.CloudDirs.ValidateUser,
+		PathMap:      s.CloudPaths,
+		Perm:         filePerm,
+		DirPerm:      dirPerm,
+		Listings:     listings,
+		Hooks:        s.CloudHooks,
+		Strict:       config.StrictFileWrites,
+		Loops: cloudstore.NewLoopDetector(s.Cache, cloudstore.LoopDetectorConfig{
+			Threshold: config.UploadLoopThreshold,
+			Window:    config.UploadLoopWindow,
+			Mode:      loopMode,
+			Log:       logger,
+		}),
+	})
+
+	return s, nil
+}
+
+// Close closes the cache and database. Errors are logged.
+func (s *Services) Close() {
+	if err := s.Cache.Close(); err != nil {
+		s.Logger.Printf("[ERROR] Closing the cache connection: %v\n", err)
+	}
+
+	if err := s.DB.Close(); err != nil {
+		s.Logger.Printf("[ERROR] Closing the database connection: %v\n", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -104,3 +105,73 @@ func (r *Redis) Get(ctx context.Context, key string) (string, error) {
 func (r *Redis) Del(ctx context.Context, keys ...string) error {
 	return r.conn.Del(ctx, keys...).Err()
 }
+
+// HIncrBy increments each field of the hash at key by its value in values and sets the expiration of the key. All the
+// increments will succeed or none will. Open must be called before calling this function.
+func (r *Redis) HIncrBy(ctx context.Context, key string, values map[string]int64, expiration time.Duration) error {
+	pipe := r.conn.TxPipeline()
+	for field, v := range values {
+		pipe.HIncrBy(ctx, key, field, v)
+	}
+	pipe.Expire(ctx, key, expiration)
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// HGetAll gets all the fields of the hash at key. If the key does not exist, the map is empty. Open must be called
+// before calling this function.
+func (r *Redis) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return r.conn.HGetAll(ctx, key).Result()
+}
+
+// SAdd adds the members to the set at key and sets the expiration of the key. Open must be called before calling this
+// function.
+func (r *Redis) SAdd(ctx context.Context, key string, expiration time.Duration, members ...string) error {
+	pipe := r.conn.TxPipeline()
+	for _, m := range members {
+		pipe.SAdd(ctx, key, m)
+	}
+	pipe.Expire(ctx, key, expiration)
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SMembers gets all the members of the set at key. If the key does not exist, the slice is empty. Open must be called
+// before calling this function.
+func (r *Redis) SMembers(ctx context.Context, key string) ([]string, error) {
+	return r.conn.SMembers(ctx, key).Result()
+}
+
+// WindowCount removes the members of the sorted set at key scored before since and returns the number of members
+// left. Members are scored by the Unix time in milliseconds they were added at, see WindowAdd. Open must be called
+// before calling this function.
+func (r *Redis) WindowCount(ctx context.Context, key string, since time.Time) (int64, error) {
+	pipe := r.conn.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(since.UnixMilli(), 10))
+	card := pipe.ZCard(ctx, key)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return card.Val(), nil
+}
+
+// WindowAdd adds member to the sorted set at key scored by at, removes the members scored before since, and sets
+// the expiration of the key. It returns the number of members left, including member. Open must be called before
+// calling this function.
+func (r *Redis) WindowAdd(ctx context.Context, key string, member string, at time.Time, since time.Time, expiration time.Duration) (int64, error) {
+	pipe := r.conn.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(at.UnixMilli()), Member: member})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(since.UnixMilli(), 10))
+	card := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, expiration)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return card.Val(), nil
+}
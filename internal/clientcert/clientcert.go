@@ -0,0 +1,56 @@
+// Package clientcert manages the client certificates users authenticate to the API with
+// over mutual TLS.
+//
+// The TLS listener verifies a client certificate against the configured CA bundle. A
+// verified certificate only authenticates a user if its fingerprint was registered for
+// the user by an admin. Removing the registration revokes the certificate.
+package clientcert
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrUnknown signals a client certificate is not registered.
+var ErrUnknown = errors.New("unknown client certificate")
+
+// ErrNotFound signals a client certificate of a user does not exist.
+var ErrNotFound = errors.New("client certificate not found")
+
+// ErrDuplicate signals a client certificate is already registered.
+var ErrDuplicate = errors.New("duplicate client certificate")
+
+// ErrUserNotFound signals a client certificate was registered for a user that does not
+// exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// Cert is a client certificate registered for a user.
+type Cert struct {
+	ID     string
+	UserID string
+	Name   string
+
+	// Fingerprint is the hex encoded SHA-256 digest of the DER encoded certificate.
+	Fingerprint string
+
+	// Subject is the distinguished name of the certificate subject.
+	Subject string
+
+	// NotAfter is the expiration time of the certificate.
+	NotAfter time.Time
+
+	CreatedAt time.Time
+
+	// LastUsed is the last time the certificate authenticated a request. It is zero if
+	// the certificate was never used.
+	LastUsed time.Time
+}
+
+// Fingerprint returns the hex encoded SHA-256 digest of the DER encoding of cert.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,135 @@
+package clientcert
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/lib/pq"
+)
+
+// Repo is the client certificate repository.
+type Repo struct {
+	// The database connection.
+	db app.DB
+}
+
+// NewRepo creates a new Repo.
+func NewRepo(db app.DB) *Repo {
+	return &Repo{db: db}
+}
+
+// Row represents a client_certs row in the database.
+type Row struct {
+	ID          string
+	UserID      string
+	Name        string
+	Fingerprint string
+	Subject     string
+	NotAfter    time.Time
+	CreatedAt   time.Time
+	LastUsed    sql.NullTime
+}
+
+// cert returns this row as a Cert.
+func (r *Row) cert() Cert {
+	return Cert{
+		ID:          r.ID,
+		UserID:      r.UserID,
+		Name:        r.Name,
+		Fingerprint: r.Fingerprint,
+		Subject:     r.Subject,
+		NotAfter:    r.NotAfter.UTC(),
+		CreatedAt:   r.CreatedAt.UTC(),
+		LastUsed:    r.LastUsed.Time.UTC(),
+	}
+}
+
+// Insert inserts a client certificate into the database. If the fingerprint is already
+// registered, ErrDuplicate is returned. If the user does not exist, ErrUserNotFound is
+// returned.
+func (r *Repo) Insert(ctx context.Context, row Row) error {
+	query := `INSERT INTO client_certs(id, user_id, name, fingerprint, subject, not_after, created_at)
+			  VALUES($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := r.db.Exec(ctx, query, row.ID, row.UserID, row.Name, row.Fingerprint, row.Subject, row.NotAfter.UTC(), row.CreatedAt.UTC())
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			if pqErr.Code == "23505" && pqErr.Constraint == "unique_client_cert_fingerprint" {
+				return fmt.Errorf("%w: %v", ErrDuplicate, err)
+			}
+
+			if pqErr.Code == "23503" && pqErr.Constraint == "client_certs_user_id_fkey" {
+				return fmt.Errorf("%w: %v", ErrUserNotFound, err)
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// SelectByUser selects the client certificates of a user, newest first.
+func (r *Repo) SelectByUser(ctx context.Context, userID string) ([]Row, error) {
+	query := `SELECT id, user_id, name, fingerprint, subject, not_after, created_at, last_used
+			  FROM client_certs
+			  WHERE user_id = $1
+			  ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certs := []Row{}
+	for rows.Next() {
+		var row Row
+		if err := rows.Scan(&row.ID, &row.UserID, &row.Name, &row.Fingerprint, &row.Subject, &row.NotAfter, &row.CreatedAt, &row.LastUsed); err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, row)
+	}
+
+	return certs, rows.Err()
+}
+
+// Use sets the last used time of the client certificate with fingerprint to now and
+// returns it. If no certificate has the fingerprint, sql.ErrNoRows is returned.
+func (r *Repo) Use(ctx context.Context, fingerprint string, now time.Time) (Row, error) {
+	query := `UPDATE client_certs
+			  SET last_used = $2
+			  WHERE fingerprint = $1
+			  RETURNING id, user_id, name, fingerprint, subject, not_after, created_at, last_used`
+
+	var row Row
+	err := r.db.QueryRow(ctx, query, fingerprint, now.UTC()).
+		Scan(&row.ID, &row.UserID, &row.Name, &row.Fingerprint, &row.Subject, &row.NotAfter, &row.CreatedAt, &row.LastUsed)
+
+	return row, err
+}
+
+// Delete deletes the client certificate with id of a user. It returns false if the user
+// has no client certificate with id.
+func (r *Repo) Delete(ctx context.Context, userID string, id string) (bool, error) {
+	query := `DELETE FROM client_certs
+			  WHERE id = $1 AND user_id = $2`
+
+	result, err := r.db.Exec(ctx, query, id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
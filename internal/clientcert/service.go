@@ -0,0 +1,169 @@
+package clientcert
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/google/uuid"
+)
+
+// MaxNameLength is the length of the longest client certificate name.
+const MaxNameLength = 255
+
+// Service is the business logic for client certificates.
+type Service struct {
+	repo *Repo
+}
+
+// NewService creates a new Service.
+func NewService(repo *Repo) *Service {
+	return &Service{repo: repo}
+}
+
+// Register registers the PEM encoded client certificate certPEM for the user userID. Once
+// registered, a request presenting the certificate over mutual TLS is authenticated as the
+// user. The certificate must still be verified against the CA bundle of the listener.
+//
+// If the name is empty or too long, the certificate cannot be parsed or has expired, a 400
+// app.WrappedSafeError is returned. If the certificate is already registered, a 409
+// app.WrappedSafeError wrapping ErrDuplicate is returned. If the user does not exist, a 404
+// app.WrappedSafeError wrapping ErrUserNotFound is returned.
+func (s *Service) Register(ctx context.Context, userID string, name string, certPEM string) (Cert, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MaxNameLength {
+		return Cert{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid client certificate name %q", name),
+			SafeMessage: fmt.Sprintf("Name must be between 1 and %d characters", MaxNameLength),
+			StatusCode:  http.StatusBadRequest,
+			Field:       "name",
+		})
+	}
+
+	cert, err := parse(certPEM)
+	if err != nil {
+		return Cert{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("parsing client certificate: %w", err),
+			SafeMessage: "Certificate must be a PEM encoded X.509 certificate",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "certificate",
+		})
+	}
+
+	now := time.Now().UTC()
+	if !now.Before(cert.NotAfter) {
+		return Cert{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("client certificate expired at %s", cert.NotAfter),
+			SafeMessage: "Certificate has expired",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "certificate",
+		})
+	}
+
+	row := Row{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Name:        name,
+		Fingerprint: Fingerprint(cert),
+		Subject:     cert.Subject.String(),
+		NotAfter:    cert.NotAfter,
+		CreatedAt:   now,
+	}
+
+	if err := s.repo.Insert(ctx, row); err != nil {
+		if errors.Is(err, ErrDuplicate) {
+			return Cert{}, app.Wrap(app.WrapParams{
+				Err:         err,
+				SafeMessage: "Certificate is already registered",
+				StatusCode:  http.StatusConflict,
+				Field:       "certificate",
+			})
+		}
+
+		if errors.Is(err, ErrUserNotFound) {
+			return Cert{}, app.Wrap(app.WrapParams{
+				Err:         err,
+				SafeMessage: "User not found",
+				StatusCode:  http.StatusNotFound,
+			})
+		}
+
+		return Cert{}, fmt.Errorf("inserting client certificate [user: %s]: %w", userID, err)
+	}
+
+	return row.cert(), nil
+}
+
+// List lists the client certificates of the user userID, newest first.
+func (s *Service) List(ctx context.Context, userID string) ([]Cert, error) {
+	rows, err := s.repo.SelectByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("selecting client certificates [user: %s]: %w", userID, err)
+	}
+
+	certs := []Cert{}
+	for _, row := range rows {
+		certs = append(certs, row.cert())
+	}
+
+	return certs, nil
+}
+
+// Revoke deletes the client certificate with id of the user userID. The certificate no
+// longer authenticates requests. If the user has no such certificate, a 404
+// app.WrappedSafeError wrapping ErrNotFound is returned.
+func (s *Service) Revoke(ctx context.Context, userID string, id string) error {
+	deleted, err := s.repo.Delete(ctx, userID, id)
+	if err != nil {
+		return fmt.Errorf("deleting client certificate [user: %s, id: %s]: %w", userID, id, err)
+	}
+
+	if !deleted {
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("%w [user: %s, id: %s]", ErrNotFound, userID, id),
+			SafeMessage: "Certificate not found",
+			StatusCode:  http.StatusNotFound,
+		})
+	}
+
+	return nil
+}
+
+// Authenticate returns the registered Cert of a verified client certificate and records
+// its use. If it is not registered, a 401 app.WrappedSafeError wrapping ErrUnknown is
+// returned.
+func (s *Service) Authenticate(ctx context.Context, cert *x509.Certificate) (Cert, error) {
+	fingerprint := Fingerprint(cert)
+
+	row, err := s.repo.Use(ctx, fingerprint, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Cert{}, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("%w [fingerprint: %s, subject: %s]", ErrUnknown, fingerprint, cert.Subject),
+				SafeMessage: "Client certificate is not registered",
+				StatusCode:  http.StatusUnauthorized,
+			})
+		}
+
+		return Cert{}, fmt.Errorf("using client certificate [fingerprint: %s]: %w", fingerprint, err)
+	}
+
+	return row.cert(), nil
+}
+
+// parse parses the first PEM encoded certificate in s.
+func parse(s string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate block")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,102 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is wrapped by the errors of DirNotFound and FileNotFound.
+var ErrNotFound = errors.New("not found")
+
+// Access decides if a user may access a file or directory. Queries that read a
+// file or directory by ID are not scoped to a user, Access should be consulted
+// before returning the result to a user.
+//
+// Every lookup by ID follows the same policy: a file or directory that does not
+// exist, is not owned by the user, or has a malformed ID is not found. All three
+// return the same 404 app.WrappedSafeError, so a user cannot tell whether another
+// users file or directory exists. Lookups by path are always scoped to the users
+// root directory, so they never reach another users files.
+//
+// Access should be created using the NewAccess function.
+type Access struct {
+	store Storage
+}
+
+// NewAccess creates a new Access.
+func NewAccess(store Storage) *Access {
+	return &Access{store: store}
+}
+
+// CanRead returns if the user may read the file. A user may read a file if they
+// own it. If the file does not exist, CanRead returns false and a nil error.
+//
+// When sharing is implemented, CanRead should check grants and public shares
+// after ownership.
+func (a *Access) CanRead(ctx context.Context, userID string, fileID string) (bool, error) {
+	if userID == "" || !validID(fileID) {
+		return false, nil
+	}
+
+	row, err := a.store.SelectFileByID(ctx, fileID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return row.UserID == userID, nil
+}
+
+// Dir gets a directory the user owns. If the directory does not exist, is not
+// owned by the user, or dirID is malformed, the error of DirNotFound is returned.
+func (a *Access) Dir(ctx context.Context, userID string, dirID string) (DirectoryRow, error) {
+	if userID == "" || !validID(dirID) {
+		return DirectoryRow{}, DirNotFound(dirID, sql.ErrNoRows)
+	}
+
+	row, err := a.store.SelectDirectoryByIDUser(ctx, dirID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DirectoryRow{}, DirNotFound(dirID, err)
+		}
+
+		return DirectoryRow{}, err
+	}
+
+	return row, nil
+}
+
+// DirNotFound returns the 404 app.WrappedSafeError of a directory that a user
+// may not access. The safe message never includes dirID.
+func DirNotFound(dirID string, err error) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("directory '%s' %w: %w", dirID, ErrNotFound, err),
+		SafeMessage: "Directory not found",
+		StatusCode:  http.StatusNotFound,
+	})
+}
+
+// FileNotFound returns the 404 app.WrappedSafeError of a file that a user may
+// not access. The safe message never includes fileID.
+func FileNotFound(fileID string, err error) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("file '%s' %w: %w", fileID, ErrNotFound, err),
+		SafeMessage: "File not found",
+		StatusCode:  http.StatusNotFound,
+	})
+}
+
+// validID returns true if id is a well formed file or directory ID.
+func validID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil
+}
@@ -0,0 +1,184 @@
+package cloudstore
+
+import (
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+
+	"github.com/google/uuid"
+)
+
+func TestAccessCanRead(t *testing.T) {
+	f := newFakeStorage(t)
+	_, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	file, _ := addTestFile(t, f, root, userRoot, "a.txt")
+	other := addTestRoot(t, f, root)
+
+	a := NewAccess(f)
+
+	tests := []struct {
+		name   string
+		userID string
+		fileID string
+		want   bool
+	}{
+		{name: "owner", userID: userRoot.UserID, fileID: file.ID, want: true},
+		{name: "other user", userID: other.UserID, fileID: file.ID, want: false},
+		{name: "empty user", userID: "", fileID: file.ID, want: false},
+		{name: "missing file", userID: userRoot.UserID, fileID: uuid.NewString(), want: false},
+		{name: "malformed id", userID: userRoot.UserID, fileID: "not-a-uuid", want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := a.CanRead(context.Background(), tc.userID, tc.fileID)
+			if err != nil {
+				t.Fatalf("CanRead() error = %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("CanRead() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAccessDir(t *testing.T) {
+	f := newFakeStorage(t)
+	_, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	dir := addTestDir(t, f, root, userRoot, "photos")
+	other := addTestRoot(t, f, root)
+
+	a := NewAccess(f)
+
+	row, err := a.Dir(context.Background(), userRoot.UserID, dir.ID)
+	if err != nil || row.ID != dir.ID {
+		t.Fatalf("Dir() = %s, %v, want %s", row.ID, err, dir.ID)
+	}
+
+	for _, tc := range []struct{ userID, dirID string }{
+		{userID: other.UserID, dirID: dir.ID},
+		{userID: "", dirID: dir.ID},
+		{userID: userRoot.UserID, dirID: uuid.NewString()},
+		{userID: userRoot.UserID, dirID: "not-a-uuid"},
+	} {
+		_, err := a.Dir(context.Background(), tc.userID, tc.dirID)
+		assertSafeError(t, err, http.StatusNotFound, ErrNotFound)
+
+		if msg, _ := err.(app.SafeError).Safe(); msg != "Directory not found" {
+			t.Errorf("Dir(%q, %q) message = %q, want %q", tc.userID, tc.dirID, msg, "Directory not found")
+		}
+	}
+}
+
+// TestAccessForeignIDs probes every lookup by ID as a second user. A file or directory
+// of another user must be reported exactly like one that does not exist.
+func TestAccessForeignIDs(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	dirs, root := newTestDirService(t, f)
+	files, _ := newTestFileService(t, f)
+	files.pathMap = dirs.pathMap
+	files.validateUser = dirs.ValidateUser
+
+	owner := addTestRoot(t, f, root)
+	dir := addTestDir(t, f, root, owner, "photos")
+	file, _ := addTestFile(t, f, root, owner, "a.txt")
+	other := addTestRoot(t, f, root)
+	otherDir := addTestDir(t, f, root, other, "docs")
+	otherFile, _ := addTestFile(t, f, root, other, "b.txt")
+	before := f.data()
+
+	tests := []struct {
+		name string
+		id   string
+		call func(id string) error
+	}{
+		{name: "dir info", id: dir.ID, call: func(id string) error {
+			_, err := dirs.Info(ctx, other.UserID, id)
+			return err
+		}},
+		{name: "list entries", id: dir.ID, call: func(id string) error {
+			_, err := dirs.ListEntries(ctx, other.UserID, id, ListOptions{Dirs: true, Files: true, Limit: 10})
+			return err
+		}},
+		{name: "new dir parent", id: dir.ID, call: func(id string) error {
+			_, err := dirs.New(ctx, other.UserID, "new", id)
+			return err
+		}},
+		{name: "delete dir", id: dir.ID, call: func(id string) error {
+			return dirs.Delete(ctx, other.UserID, id)
+		}},
+		{name: "rename dir", id: dir.ID, call: func(id string) error {
+			_, err := dirs.Rename(ctx, other.UserID, id, "renamed")
+			return err
+		}},
+		{name: "move dir", id: dir.ID, call: func(id string) error {
+			_, err := dirs.Move(ctx, other.UserID, id, other.ID)
+			return err
+		}},
+		{name: "move dir target", id: dir.ID, call: func(id string) error {
+			_, err := dirs.Move(ctx, other.UserID, otherDir.ID, id)
+			return err
+		}},
+		{name: "set inbox", id: dir.ID, call: func(id string) error {
+			_, err := dirs.SetInbox(ctx, other.UserID, id)
+			return err
+		}},
+		{name: "upload dir", id: dir.ID, call: func(id string) error {
+			_, err := files.SaveBatch(ctx, other.UserID, id, []*multipart.FileHeader{newTestFileHeader(t, "c.txt", "c")}, nil)
+			return err
+		}},
+		{name: "file info", id: file.ID, call: func(id string) error {
+			_, err := files.Info(ctx, other.UserID, id)
+			return err
+		}},
+		{name: "delete file", id: file.ID, call: func(id string) error {
+			return files.Delete(ctx, other.UserID, id)
+		}},
+		{name: "rename file", id: file.ID, call: func(id string) error {
+			_, err := files.Rename(ctx, other.UserID, id, "renamed.txt")
+			return err
+		}},
+		{name: "move file", id: file.ID, call: func(id string) error {
+			_, err := files.Move(ctx, other.UserID, id, other.ID)
+			return err
+		}},
+		{name: "move file target", id: dir.ID, call: func(id string) error {
+			_, err := files.Move(ctx, other.UserID, otherFile.ID, id)
+			return err
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			foreign := tc.call(tc.id)
+			missing := tc.call(uuid.NewString())
+
+			assertSafeError(t, foreign, http.StatusNotFound, ErrNotFound)
+			assertSafeError(t, missing, http.StatusNotFound, ErrNotFound)
+
+			var foreignErr, missingErr app.SafeError
+			errors.As(foreign, &foreignErr)
+			errors.As(missing, &missingErr)
+
+			foreignMsg, _ := foreignErr.Safe()
+			missingMsg, _ := missingErr.Safe()
+			if foreignMsg != missingMsg {
+				t.Errorf("message = %q for another users ID, %q for a missing ID, want the same", foreignMsg, missingMsg)
+			}
+		})
+	}
+
+	// Nothing of the owner was changed.
+	after := f.data()
+	if after.dirs[dir.ID] != before.dirs[dir.ID] || after.files[file.ID] != before.files[file.ID] {
+		t.Errorf("owner's directory or file changed by another user")
+	}
+}
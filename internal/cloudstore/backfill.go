@@ -0,0 +1,430 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	goio "io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The default Backfill configuration.
+const (
+	DefaultBackfillBatchSize   = 100
+	DefaultBackfillConcurrency = 4
+)
+
+// backfillName is the name of the files backfill in the backfill_checkpoints table.
+const backfillName = "files"
+
+// ErrBackfillRunning signals a backfill was started while one is already running.
+var ErrBackfillRunning = errors.New("backfill already running")
+
+// backfillMetrics counts the files processed, updated, flagged as missing, and failed by
+// Backfill. It is published with expvar as "cloudstore_backfill".
+var backfillMetrics = expvar.NewMap("cloudstore_backfill")
+
+// Backfill computes the size, checksum, content type, and search text of the files
+// uploaded before they were recorded on upload.
+//
+// Files are read in increasing ID order, a batch at a time. The ID of the last file in a
+// batch is checkpointed in the backfill_checkpoints table, so a run that stops is resumed
+// from the last checkpoint. A file whose content is not on the file system is flagged as
+// missing, it does not fail the run.
+//
+// Backfill should be created using the NewBackfill function.
+type Backfill struct {
+	store       Storage
+	io          *IO
+	paths       *FSPathMapper
+	log         *log.Logger
+	batchSize   int
+	concurrency int
+	throttle    *throttle
+	running     atomic.Bool
+}
+
+// BackfillConfig is the Backfill configuration.
+type BackfillConfig struct {
+	Store   Storage
+	IO      *IO
+	PathMap *FSPathMapper
+	Log     *log.Logger
+
+	// BatchSize is the number of files read from the database at a time.
+	BatchSize int
+
+	// Concurrency is the number of files processed at the same time.
+	Concurrency int
+
+	// BytesPerSecond is the maximum number of bytes read from the file system per second,
+	// shared by all files being processed. If not positive, reads are not throttled.
+	BytesPerSecond int64
+}
+
+// NewBackfill creates a new Backfill.
+//
+// Store, IO, and PathMap must be set otherwise it will panic.
+//
+// If Log is not set, it will default to log.Default(). BatchSize and Concurrency will
+// default to their Default value if not set.
+func NewBackfill(c BackfillConfig) *Backfill {
+	if c.Store == nil {
+		panic("cloudstore.NewBackfill: cannot create Backfill with nil Store")
+	}
+
+	if c.IO == nil {
+		panic("cloudstore.NewBackfill: cannot create Backfill with nil IO")
+	}
+
+	if c.PathMap == nil {
+		panic("cloudstore.NewBackfill: cannot create Backfill with nil PathMap")
+	}
+
+	if c.Log == nil {
+		c.Log = log.Default()
+	}
+
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultBackfillBatchSize
+	}
+
+	if c.Concurrency <= 0 {
+		c.Concurrency = DefaultBackfillConcurrency
+	}
+
+	return &Backfill{
+		store:       c.Store,
+		io:          c.IO,
+		paths:       c.PathMap,
+		log:         c.Log,
+		batchSize:   c.BatchSize,
+		concurrency: c.Concurrency,
+		throttle:    &throttle{rate: c.BytesPerSecond},
+	}
+}
+
+// BackfillProgress is the progress of the last backfill run.
+type BackfillProgress struct {
+	// Running is true if a run is in progress.
+	Running bool
+
+	// LastID is the ID of the last file checkpointed.
+	LastID string
+
+	Processed int64
+	Updated   int64
+	Missing   int64
+	Failed    int64
+
+	// StartedAt is zero if the backfill has never run.
+	StartedAt time.Time
+	UpdatedAt time.Time
+
+	// FinishedAt is zero if the last run did not finish.
+	FinishedAt time.Time
+
+	// Versions is the number of files at each RecordVersion. Files are bumped to
+	// FileVersionContent as they are backfilled.
+	Versions map[RecordVersion]int64
+}
+
+// Progress gets the progress of the last backfill run.
+func (b *Backfill) Progress(ctx context.Context) (BackfillProgress, error) {
+	row, err := b.store.SelectBackfillCheckpoint(ctx, backfillName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return BackfillProgress{}, fmt.Errorf("selecting checkpoint: %w", err)
+	}
+
+	versions, err := b.store.SelectFileVersionCounts(ctx)
+	if err != nil {
+		return BackfillProgress{}, fmt.Errorf("selecting file versions: %w", err)
+	}
+
+	return BackfillProgress{
+		Running:    b.running.Load(),
+		LastID:     row.LastID,
+		Processed:  row.Processed,
+		Updated:    row.Updated,
+		Missing:    row.Missing,
+		Failed:     row.Failed,
+		StartedAt:  row.StartedAt,
+		UpdatedAt:  row.UpdatedAt,
+		FinishedAt: row.FinishedAt.Time,
+		Versions:   versions,
+	}, nil
+}
+
+// BackfillReporter is reported the progress of a backfill run started with Start.
+type BackfillReporter interface {
+	// Progress is called after every checkpoint with the number of files processed and
+	// failed so far.
+	Progress(ctx context.Context, processed int64, failed int64)
+
+	// Finish is called once the run stops, err is the error the run stopped with.
+	Finish(ctx context.Context, err error)
+}
+
+// Start runs the backfill in the background. The result of the run is logged, and reported
+// to reporter if it is not nil. If a run is already in progress, Start does nothing and
+// returns false.
+func (b *Backfill) Start(reporter BackfillReporter) bool {
+	if !b.running.CompareAndSwap(false, true) {
+		return false
+	}
+
+	go func() {
+		defer b.running.Store(false)
+
+		ctx := context.Background()
+		err := b.run(ctx, reporter)
+		if err != nil {
+			b.log.Printf("[ERROR] Running backfill: %v\n", err)
+		}
+
+		if reporter != nil {
+			reporter.Finish(ctx, err)
+		}
+	}()
+
+	return true
+}
+
+// Run runs the backfill until every file is processed or ctx is done. It resumes from the
+// last checkpoint, unless the last run finished, then a new run is started. If a run is
+// already in progress, ErrBackfillRunning is returned.
+func (b *Backfill) Run(ctx context.Context) error {
+	if !b.running.CompareAndSwap(false, true) {
+		return ErrBackfillRunning
+	}
+	defer b.running.Store(false)
+
+	return b.run(ctx, nil)
+}
+
+func (b *Backfill) run(ctx context.Context, reporter BackfillReporter) error {
+	checkpoint, err := b.store.SelectBackfillCheckpoint(ctx, backfillName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("selecting checkpoint: %w", err)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) || checkpoint.FinishedAt.Valid {
+		checkpoint = BackfillCheckpointRow{Name: backfillName, StartedAt: time.Now()}
+	} else {
+		b.log.Printf("[INFO] Resuming backfill [last_id: %s, processed: %d]\n", checkpoint.LastID, checkpoint.Processed)
+	}
+
+	for {
+		rows, err := b.store.SelectBackfillFiles(ctx, checkpoint.LastID, b.batchSize)
+		if err != nil {
+			return fmt.Errorf("selecting files after '%s': %w", checkpoint.LastID, err)
+		}
+
+		if len(rows) == 0 {
+			checkpoint.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		} else {
+			counts := b.batch(ctx, rows)
+			checkpoint.LastID = rows[len(rows)-1].ID
+			checkpoint.Processed += counts.processed
+			checkpoint.Updated += counts.updated
+			checkpoint.Missing += counts.missing
+			checkpoint.Failed += counts.failed
+		}
+
+		// A cancelled batch is not checkpointed, the files not processed would be skipped.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		checkpoint.UpdatedAt = time.Now()
+		if err := b.store.UpsertBackfillCheckpoint(ctx, checkpoint); err != nil {
+			return fmt.Errorf("checkpointing backfill [last_id: %s]: %w", checkpoint.LastID, err)
+		}
+
+		if reporter != nil {
+			reporter.Progress(ctx, checkpoint.Processed, checkpoint.Failed)
+		}
+
+		if checkpoint.FinishedAt.Valid {
+			b.log.Printf("[INFO] Backfill finished [processed: %d, updated: %d, missing: %d, failed: %d]\n",
+				checkpoint.Processed, checkpoint.Updated, checkpoint.Missing, checkpoint.Failed)
+			return nil
+		}
+	}
+}
+
+// backfillCounts is the number of files processed in a batch, by outcome.
+type backfillCounts struct {
+	processed int64
+	updated   int64
+	missing   int64
+	failed    int64
+}
+
+// batch processes rows with at most concurrency files at a time.
+func (b *Backfill) batch(ctx context.Context, rows []BackfillFileRow) backfillCounts {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		counts backfillCounts
+	)
+
+	sem := make(chan struct{}, b.concurrency)
+	for _, row := range rows {
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func(row BackfillFileRow) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			outcome := b.file(ctx, row)
+			backfillMetrics.Add(outcome, 1)
+			backfillMetrics.Add("processed", 1)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			counts.processed++
+			switch outcome {
+			case "updated":
+				counts.updated++
+			case "missing":
+				counts.missing++
+			case "failed":
+				counts.failed++
+			}
+		}(row)
+	}
+
+	wg.Wait()
+
+	return counts
+}
+
+// file backfills the content of a file. The outcome is returned, it is one of "updated",
+// "missing", "failed", or "skipped" if the recorded content is already complete. Errors are
+// logged.
+func (b *Backfill) file(ctx context.Context, row BackfillFileRow) string {
+	path, err := b.paths.GetFileFS(ctx, b.store, row.DirectoryID, row.ID)
+	if err != nil {
+		b.log.Printf("[ERROR] Backfilling file [id: %s]: getting path: %v\n", row.ID, err)
+		return "failed"
+	}
+
+	info, err := b.io.fs.Stat(path)
+	if err != nil {
+		if !b.io.fs.IsNotExist(err) {
+			b.log.Printf("[ERROR] Backfilling file [id: %s]: getting file info: %v\n", row.ID, err)
+			return "failed"
+		}
+
+		if !row.ContentMissing {
+			if err := b.store.UpdateFileContentMissing(ctx, row.ID, true); err != nil {
+				b.log.Printf("[ERROR] Backfilling file [id: %s]: flagging content missing: %v\n", row.ID, err)
+				return "failed"
+			}
+		}
+
+		return "missing"
+	}
+
+	caps := FileCapabilities(row.RecordVersion)
+	complete := caps.HasChecksum && caps.HasSearchText && !row.ContentMissing && info.Size() == row.Size
+	if complete {
+		return "skipped"
+	}
+
+	content, err := b.content(ctx, path, row.Name, info.Size())
+	if err != nil {
+		b.log.Printf("[ERROR] Backfilling file [id: %s]: reading content: %v\n", row.ID, err)
+		return "failed"
+	}
+
+	if err := b.store.UpdateFileContent(ctx, row.ID, content); err != nil {
+		b.log.Printf("[ERROR] Backfilling file [id: %s]: updating content: %v\n", row.ID, err)
+		return "failed"
+	}
+
+	return "updated"
+}
+
+// content reads the file named name at path with size bytes and returns its FileContent.
+// Reads are throttled.
+func (b *Backfill) content(ctx context.Context, path string, name string, size int64) (FileContent, error) {
+	f, err := b.io.fs.Open(path)
+	if err != nil {
+		return FileContent{}, err
+	}
+	defer f.Close()
+
+	cw := newContentWriter()
+	cw.indexText(name, size)
+	src := &throttledReader{ctx: ctx, r: f, throttle: b.throttle}
+	if _, err := b.io.fs.CopyContext(ctx, cw, src, CopyOptions{}); err != nil {
+		return FileContent{}, err
+	}
+
+	return cw.content(), nil
+}
+
+// throttle limits the rate bytes are read at. It is shared by every reader it throttles.
+type throttle struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n more bytes may be read, or ctx is done.
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t.rate <= 0 || n <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / t.rate))
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledReader is a io.Reader that waits on a throttle before returning what it read.
+type throttledReader struct {
+	ctx      context.Context
+	r        goio.Reader
+	throttle *throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if werr := r.throttle.wait(r.ctx, n); werr != nil {
+		return n, werr
+	}
+
+	return n, err
+}
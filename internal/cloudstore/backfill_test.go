@@ -0,0 +1,207 @@
+package cloudstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestBackfill creates a Backfill on f that reads the file store root, with a batch
+// size of 2.
+func newTestBackfill(f *fakeStorage, root string) *Backfill {
+	paths := NewFSPathMapper(root)
+	return NewBackfill(BackfillConfig{
+		Store:       f,
+		IO:          NewIO(&OSFileSystem{}, paths),
+		PathMap:     paths,
+		Log:         log.New(io.Discard, "", 0),
+		BatchSize:   2,
+		Concurrency: 2,
+	})
+}
+
+// checksum returns the hex encoded SHA-256 digest of s.
+func checksum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestBackfillRun(t *testing.T) {
+	f := newFakeStorage(t)
+	_, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	a, _ := addTestFile(t, f, root, userRoot, "a.txt")
+	b, _ := addTestFile(t, f, root, userRoot, "b.txt")
+	gone, path := addTestFile(t, f, root, userRoot, "gone.txt")
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("removing file content: %v", err)
+	}
+
+	bf := newTestBackfill(f, root)
+	if err := bf.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	data := f.data()
+	for _, file := range []FileRow{a, b} {
+		got := data.files[file.ID]
+		if got.Checksum.String != checksum(file.Name) || got.ContentType.String != "text/plain; charset=utf-8" {
+			t.Errorf("%s checksum = %q, content type = %q, want the recorded content", file.Name, got.Checksum.String, got.ContentType.String)
+		}
+
+		if data.sizes[file.ID] != int64(len(file.Name)) {
+			t.Errorf("%s size = %d, want %d", file.Name, data.sizes[file.ID], len(file.Name))
+		}
+	}
+
+	// A file without content is flagged, it does not fail the run.
+	if !data.missing[gone.ID] || data.files[gone.ID].Checksum.Valid {
+		t.Errorf("missing file flagged = %v, checksum = %q, want flagged without a checksum", data.missing[gone.ID], data.files[gone.ID].Checksum.String)
+	}
+
+	ids := []string{a.ID, b.ID, gone.ID}
+	sort.Strings(ids)
+
+	checkpoint := data.checkpoints[backfillName]
+	if !checkpoint.FinishedAt.Valid || checkpoint.LastID != ids[2] {
+		t.Errorf("checkpoint finished = %v, last id = %s, want finished at %s", checkpoint.FinishedAt.Valid, checkpoint.LastID, ids[2])
+	}
+
+	if checkpoint.Processed != 3 || checkpoint.Updated != 2 || checkpoint.Missing != 1 || checkpoint.Failed != 0 {
+		t.Errorf("checkpoint processed = %d, updated = %d, missing = %d, failed = %d, want 3, 2, 1, 0",
+			checkpoint.Processed, checkpoint.Updated, checkpoint.Missing, checkpoint.Failed)
+	}
+
+	// A finished backfill starts over, files already recorded are skipped.
+	if err := bf.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	checkpoint = f.data().checkpoints[backfillName]
+	if checkpoint.Processed != 3 || checkpoint.Updated != 0 || checkpoint.Missing != 1 {
+		t.Errorf("second run processed = %d, updated = %d, missing = %d, want 3, 0, 1",
+			checkpoint.Processed, checkpoint.Updated, checkpoint.Missing)
+	}
+}
+
+func TestBackfillResume(t *testing.T) {
+	f := newFakeStorage(t)
+	_, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+
+	files := []FileRow{}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		file, _ := addTestFile(t, f, root, userRoot, name)
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+
+	// A run stopped after the first file.
+	f.concurrent(func(d *fakeData) {
+		d.checkpoints[backfillName] = BackfillCheckpointRow{Name: backfillName, LastID: files[0].ID, Processed: 1, Updated: 1, StartedAt: time.Now()}
+	})
+
+	if err := newTestBackfill(f, root).Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	data := f.data()
+	if data.files[files[0].ID].Checksum.Valid {
+		t.Errorf("file before the checkpoint was backfilled again")
+	}
+
+	for _, file := range files[1:] {
+		if !data.files[file.ID].Checksum.Valid {
+			t.Errorf("%s was not backfilled", file.Name)
+		}
+	}
+
+	if checkpoint := data.checkpoints[backfillName]; checkpoint.Processed != 3 || !checkpoint.FinishedAt.Valid {
+		t.Errorf("checkpoint processed = %d, finished = %v, want 3, finished", checkpoint.Processed, checkpoint.FinishedAt.Valid)
+	}
+}
+
+func TestBackfillCanceled(t *testing.T) {
+	f := newFakeStorage(t)
+	_, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	addTestFile(t, f, root, userRoot, "a.txt")
+
+	// The run is canceled while the first batch is read.
+	ctx, cancel := context.WithCancel(context.Background())
+	f.failOn("SelectBackfillFiles", func() error {
+		cancel()
+		return nil
+	})
+
+	err := newTestBackfill(f, root).Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+
+	// A canceled batch is not checkpointed, its files would be skipped on resume.
+	if _, ok := f.data().checkpoints[backfillName]; ok {
+		t.Errorf("checkpoint written for a canceled batch")
+	}
+}
+
+func TestBackfillRunning(t *testing.T) {
+	f := newFakeStorage(t)
+	_, root := newTestDirService(t, f)
+
+	bf := newTestBackfill(f, root)
+	bf.running.Store(true)
+
+	if err := bf.Run(context.Background()); !errors.Is(err, ErrBackfillRunning) {
+		t.Errorf("Run() error = %v, want ErrBackfillRunning", err)
+	}
+
+	if bf.Start(nil) {
+		t.Errorf("Start() = true, want false while running")
+	}
+
+	progress, err := bf.Progress(context.Background())
+	if err != nil {
+		t.Fatalf("Progress() error = %v", err)
+	}
+
+	if !progress.Running || !progress.StartedAt.IsZero() {
+		t.Errorf("Progress() running = %v, started = %s, want running and never started", progress.Running, progress.StartedAt)
+	}
+}
+
+func TestThrottleWait(t *testing.T) {
+	th := &throttle{rate: 1000}
+	ctx := context.Background()
+
+	// The first read is not delayed, the next waits for the bytes already read.
+	start := time.Now()
+	if err := th.wait(ctx, 50); err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+	if err := th.wait(ctx, 50); err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("waited %s, want at least 50ms", elapsed)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := th.wait(canceled, 1000); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait() error = %v, want context.Canceled", err)
+	}
+
+	// A throttle without a rate never waits.
+	if err := (&throttle{}).wait(canceled, 1<<30); err != nil {
+		t.Errorf("wait() without a rate error = %v", err)
+	}
+}
@@ -0,0 +1,382 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// IssueKind is the kind of a StorageIssue.
+type IssueKind string
+
+// The kinds of StorageIssue.
+const (
+	// IssueStorageState is a user whose storage state is not StorageReady, or who has
+	// no root directory. It is fixed by provisioning the root directory with NewUser.
+	IssueStorageState IssueKind = "storage_state"
+
+	// IssueDetachedDir is a directory whose rows in the paths table do not link it to
+	// the root directory through its parent. It is not fixed automatically.
+	IssueDetachedDir IssueKind = "detached_directory"
+
+	// IssueForeignPath is a row in the paths table that links a directory of the user
+	// to a directory of another user. It is not fixed automatically.
+	IssueForeignPath IssueKind = "foreign_path"
+
+	// IssueMissingDirFS is a directory that is not on the file system. It is fixed by
+	// creating the empty directory.
+	IssueMissingDirFS IssueKind = "missing_directory_fs"
+
+	// IssueMissingFileFS is a file whose content is not on the file system and is not
+	// flagged as missing. It is fixed by flagging its content as missing.
+	IssueMissingFileFS IssueKind = "missing_file_fs"
+
+	// IssueStaleMissingFlag is a file flagged as missing whose content is on the file
+	// system. It is fixed by clearing the flag.
+	IssueStaleMissingFlag IssueKind = "stale_missing_flag"
+
+	// IssuePendingFile is a FilePending file. It is resolved by FileService.ResolvePending,
+	// not by a repair.
+	IssuePendingFile IssueKind = "pending_file"
+
+	// IssueFSError is a path that could not be checked on the file system. It is not
+	// fixed automatically.
+	IssueFSError IssueKind = "fs_error"
+)
+
+// StorageIssue is a inconsistency found in the storage of a user.
+type StorageIssue struct {
+	Kind IssueKind
+
+	// ID is the ID of the directory or file, or the parent and child IDs of a paths row
+	// separated by a "/".
+	ID string
+
+	// Path is the file system path, if the issue has one.
+	Path   string
+	Detail string
+
+	// CreatedVia is the path that created the directory, if the issue is of a directory.
+	CreatedVia CreatedVia
+
+	// Fixable is true if RepairStorage fixes the issue.
+	Fixable bool
+
+	// Err is the error of fixing the issue. It is only set by RepairStorage.
+	Err error
+
+	fix func(ctx context.Context) error
+}
+
+// StorageReport is the result of checking the storage of a user.
+type StorageReport struct {
+	UserID string
+	State  StorageState
+
+	// RootID and RootCreatedAt are empty if the user has no root directory.
+	RootID        string
+	RootCreatedAt time.Time
+
+	Directories int
+	Files       int
+
+	// CreatedVia is the number of directories created by each path.
+	CreatedVia map[CreatedVia]int
+
+	// Bytes is the size of the FileReady files. Files uploaded before sizes were
+	// recorded are not counted.
+	Bytes int64
+
+	Issues []StorageIssue
+}
+
+// FilterCreatedVia returns r with only the issues of directories created by via. The
+// counts of r are not changed.
+func (r StorageReport) FilterCreatedVia(via CreatedVia) StorageReport {
+	issues := []StorageIssue{}
+	for _, i := range r.Issues {
+		if i.CreatedVia == via {
+			issues = append(issues, i)
+		}
+	}
+
+	r.Issues = issues
+	return r
+}
+
+// StorageRepair is the result of repairing the storage of a user.
+type StorageRepair struct {
+	// Report is the report the repair was made from.
+	Report StorageReport
+
+	// DryRun is true if nothing was changed. Fixed is then the issues that would be
+	// fixed.
+	DryRun bool
+
+	Fixed  []StorageIssue
+	Failed []StorageIssue
+}
+
+// CheckStorage checks the storage of a user for inconsistencies between the users
+// storage state, the directories and paths tables, and the file system. Only the
+// storage of the user is read, so it is fast enough to run on demand.
+//
+// If the user does not exist, a 404 app.WrappedSafeError is returned.
+func (s *DirService) CheckStorage(ctx context.Context, userID string) (StorageReport, error) {
+	if err := requireUserID(userID); err != nil {
+		return StorageReport{}, err
+	}
+
+	state, err := s.store.SelectStorageState(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return StorageReport{}, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("selecting storage state [user: %s]: %w", userID, err),
+				SafeMessage: "User not found",
+				StatusCode:  http.StatusNotFound,
+			})
+		}
+
+		return StorageReport{}, fmt.Errorf("selecting storage state [user: %s]: %w", userID, err)
+	}
+
+	report := StorageReport{UserID: userID, State: state, Issues: []StorageIssue{}}
+
+	root, err := s.store.SelectUserRootDirectory(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return StorageReport{}, fmt.Errorf("selecting root directory [user: %s]: %w", userID, err)
+	}
+	report.RootID = root.ID
+	report.RootCreatedAt = root.CreatedAt
+
+	dirPaths, err := s.checkDirs(ctx, &report)
+	if err != nil {
+		return StorageReport{}, err
+	}
+
+	if err := s.checkFiles(ctx, &report, dirPaths); err != nil {
+		return StorageReport{}, err
+	}
+
+	// The state is fixed last, after the root directory is on the file system.
+	if state != StorageReady || report.RootID == "" {
+		detail := fmt.Sprintf("storage state is %s", state)
+		if report.RootID == "" {
+			detail += " and there is no root directory"
+		}
+
+		report.Issues = append(report.Issues, StorageIssue{
+			Kind:    IssueStorageState,
+			ID:      userID,
+			Detail:  detail,
+			Fixable: true,
+			fix: func(ctx context.Context) error {
+				_, err := s.NewUser(ctx, userID)
+				return err
+			},
+		})
+	}
+
+	return report, nil
+}
+
+// checkDirs checks the directories and paths rows of the user of report. It returns
+// the file system path and Layout of every directory linked to the root directory.
+func (s *DirService) checkDirs(ctx context.Context, report *StorageReport) (map[string]checkedDir, error) {
+	dirs, err := s.store.SelectCheckDirectories(ctx, report.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("selecting directories [user: %s]: %w", report.UserID, err)
+	}
+	report.Directories = len(dirs)
+	report.CreatedVia = map[CreatedVia]int{}
+	for _, d := range dirs {
+		report.CreatedVia[d.CreatedVia]++
+	}
+
+	foreign, err := s.store.SelectForeignPaths(ctx, report.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("selecting foreign paths [user: %s]: %w", report.UserID, err)
+	}
+
+	for _, p := range foreign {
+		report.Issues = append(report.Issues, StorageIssue{
+			Kind:   IssueForeignPath,
+			ID:     p.ParentID + "/" + p.ChildID,
+			Detail: "paths row links directories of different users",
+		})
+	}
+
+	checked := map[string]checkedDir{}
+	for _, d := range dirs {
+		if !d.attached(report.RootID) {
+			report.Issues = append(report.Issues, StorageIssue{
+				Kind:       IssueDetachedDir,
+				ID:         d.ID,
+				Detail:     "directory is not linked to the root directory through its parent in the paths table",
+				CreatedVia: d.CreatedVia,
+			})
+			continue
+		}
+
+		fsPath := fmt.Sprintf("%s/%s", s.pathMap.Root(), strings.Join(d.IDPath, "/"))
+		checked[d.ID] = checkedDir{fsPath: fsPath, layout: d.Layout}
+
+		_, err := s.io.fs.Stat(fsPath)
+		switch {
+		case err == nil:
+		case s.io.fs.IsNotExist(err):
+			report.Issues = append(report.Issues, StorageIssue{
+				Kind:       IssueMissingDirFS,
+				ID:         d.ID,
+				Path:       fsPath,
+				Detail:     "directory is not on the file system",
+				CreatedVia: d.CreatedVia,
+				Fixable:    true,
+				fix: func(ctx context.Context) error {
+					return s.io.fs.Mkdir(fsPath, s.perm.FileMode())
+				},
+			})
+		default:
+			report.Issues = append(report.Issues, StorageIssue{Kind: IssueFSError, ID: d.ID, Path: fsPath, Detail: err.Error(), CreatedVia: d.CreatedVia})
+		}
+	}
+
+	return checked, nil
+}
+
+// checkedDir is a directory checked by checkDirs.
+type checkedDir struct {
+	fsPath string
+	layout Layout
+}
+
+// attached returns true if the IDPath of d links it to the root directory rootID
+// through its parent.
+func (d CheckDirRow) attached(rootID string) bool {
+	n := len(d.IDPath)
+	if rootID == "" || n == 0 || d.IDPath[0] != rootID || d.IDPath[n-1] != d.ID {
+		return false
+	}
+
+	if !d.ParentID.Valid {
+		return d.ID == rootID && n == 1
+	}
+
+	return n >= 2 && d.IDPath[n-2] == d.ParentID.String
+}
+
+// checkFiles checks the files of the user of report are on the file system. Files in
+// directories that are not in dirs are counted but not checked.
+func (s *DirService) checkFiles(ctx context.Context, report *StorageReport, dirs map[string]checkedDir) error {
+	files, err := s.store.SelectCheckFiles(ctx, report.UserID)
+	if err != nil {
+		return fmt.Errorf("selecting files [user: %s]: %w", report.UserID, err)
+	}
+	report.Files = len(files)
+
+	for _, f := range files {
+		if f.Status == FilePending {
+			report.Issues = append(report.Issues, StorageIssue{
+				Kind:   IssuePendingFile,
+				ID:     f.ID,
+				Detail: "file is pending, it is resolved by the pending file janitor",
+			})
+			continue
+		}
+
+		report.Bytes += f.Size.Int64
+
+		dir, ok := dirs[f.DirectoryID]
+		if !ok {
+			continue
+		}
+
+		id, dirID := f.ID, f.DirectoryID
+		fsPath := dir.layout.FilePath(dir.fsPath, id)
+
+		_, err := s.io.fs.Stat(fsPath)
+		switch {
+		case err == nil && f.ContentMissing:
+			report.Issues = append(report.Issues, StorageIssue{
+				Kind:    IssueStaleMissingFlag,
+				ID:      id,
+				Path:    fsPath,
+				Detail:  "file is flagged as missing but is on the file system",
+				Fixable: true,
+				fix: func(ctx context.Context) error {
+					if err := s.store.UpdateFileContentMissing(ctx, id, false); err != nil {
+						return err
+					}
+
+					s.listings.Invalidate(ctx, dirID)
+					return nil
+				},
+			})
+		case err == nil:
+		case s.io.fs.IsNotExist(err):
+			if f.ContentMissing {
+				continue
+			}
+
+			report.Issues = append(report.Issues, StorageIssue{
+				Kind:    IssueMissingFileFS,
+				ID:      id,
+				Path:    fsPath,
+				Detail:  "file is not on the file system",
+				Fixable: true,
+				fix: func(ctx context.Context) error {
+					if err := s.store.UpdateFileContentMissing(ctx, id, true); err != nil {
+						return err
+					}
+
+					s.listings.Invalidate(ctx, dirID)
+					return nil
+				},
+			})
+		default:
+			report.Issues = append(report.Issues, StorageIssue{Kind: IssueFSError, ID: id, Path: fsPath, Detail: err.Error()})
+		}
+	}
+
+	return nil
+}
+
+// RepairStorage checks the storage of a user with CheckStorage and applies the fixes
+// of the Fixable issues, in the order they were found. A fix that fails does not stop
+// the others, it is returned in Failed. If dryRun is true, nothing is changed and the
+// Fixable issues are returned in Fixed.
+//
+// Only safe fixes are made. Directories are created empty and files are only flagged,
+// no row or content is ever deleted.
+func (s *DirService) RepairStorage(ctx context.Context, userID string, dryRun bool) (StorageRepair, error) {
+	report, err := s.CheckStorage(ctx, userID)
+	if err != nil {
+		return StorageRepair{}, err
+	}
+
+	repair := StorageRepair{Report: report, DryRun: dryRun, Fixed: []StorageIssue{}, Failed: []StorageIssue{}}
+	for _, issue := range report.Issues {
+		if !issue.Fixable {
+			continue
+		}
+
+		if !dryRun {
+			if err := issue.fix(ctx); err != nil {
+				issue.Err = err
+				repair.Failed = append(repair.Failed, issue)
+				s.log.Printf("[ERROR] Repairing storage [user: %s, kind: %s, id: %s]: %v\n", userID, issue.Kind, issue.ID, err)
+				continue
+			}
+		}
+
+		repair.Fixed = append(repair.Fixed, issue)
+	}
+
+	return repair, nil
+}
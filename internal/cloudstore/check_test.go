@@ -0,0 +1,253 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// corruptStorage is a user storage with one of each issue CheckStorage finds.
+type corruptStorage struct {
+	userRoot DirectoryRow
+
+	// missingDir is not on the file system.
+	missingDir DirectoryRow
+
+	// detachedDir is under a directory that does not exist.
+	detachedDir DirectoryRow
+
+	// missingFile is not on the file system, staleFile is flagged as missing but is on
+	// the file system, and pendingFile is FilePending.
+	missingFile FileRow
+	staleFile   FileRow
+	pendingFile FileRow
+}
+
+// newCorruptStorage adds a corruptStorage to f and the file store root. The storage
+// state of the user is left StoragePending.
+func newCorruptStorage(t *testing.T, f *fakeStorage, root string) corruptStorage {
+	t.Helper()
+
+	userRoot := addTestRoot(t, f, root)
+	photos := addTestDir(t, f, root, userRoot, "photos")
+	addTestFile(t, f, root, photos, "ok.txt")
+
+	missingDir := addTestDir(t, f, root, userRoot, "docs")
+	if err := os.Remove(filepath.Join(root, userRoot.ID, missingDir.ID)); err != nil {
+		t.Fatalf("removing directory: %v", err)
+	}
+
+	detachedDir := DirectoryRow{
+		ID:         uuid.NewString(),
+		UserID:     userRoot.UserID,
+		Name:       "lost",
+		ParentID:   sql.NullString{String: uuid.NewString(), Valid: true},
+		CreatedVia: CreatedImport,
+	}
+	f.addDir(detachedDir)
+
+	missingFile, path := addTestFile(t, f, root, photos, "missing.txt")
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("removing file: %v", err)
+	}
+
+	staleFile, _ := addTestFile(t, f, root, photos, "stale.txt")
+	pendingFile, _ := addTestFile(t, f, root, photos, "pending.txt")
+	f.concurrent(func(d *fakeData) {
+		d.missing[staleFile.ID] = true
+		d.statuses[pendingFile.ID] = FilePending
+	})
+
+	return corruptStorage{
+		userRoot:    userRoot,
+		missingDir:  missingDir,
+		detachedDir: detachedDir,
+		missingFile: missingFile,
+		staleFile:   staleFile,
+		pendingFile: pendingFile,
+	}
+}
+
+// issueKinds returns the kind and ID of each issue, sorted.
+func issueKinds(issues []StorageIssue) []string {
+	kinds := []string{}
+	for _, i := range issues {
+		kinds = append(kinds, string(i.Kind)+" "+i.ID)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// assertIssues fails t if the kinds and IDs of the issues are not want, in any order.
+func assertIssues(t *testing.T, name string, got []StorageIssue, want ...string) {
+	t.Helper()
+
+	want = append([]string{}, want...)
+	sort.Strings(want)
+	if kinds := issueKinds(got); !slices.Equal(kinds, want) {
+		t.Errorf("%s = %v, want %v", name, kinds, want)
+	}
+}
+
+func TestDirServiceCheckStorage(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	c := newCorruptStorage(t, f, root)
+
+	report, err := s.CheckStorage(context.Background(), c.userRoot.UserID)
+	if err != nil {
+		t.Fatalf("CheckStorage() error = %v", err)
+	}
+
+	if report.RootID != c.userRoot.ID || report.State != StoragePending {
+		t.Errorf("root = %s, state = %s, want %s, %s", report.RootID, report.State, c.userRoot.ID, StoragePending)
+	}
+
+	if report.Directories != 4 || report.Files != 4 {
+		t.Errorf("counts = %d directories, %d files, want 4, 4", report.Directories, report.Files)
+	}
+
+	assertIssues(t, "issues", report.Issues,
+		string(IssueMissingDirFS)+" "+c.missingDir.ID,
+		string(IssueDetachedDir)+" "+c.detachedDir.ID,
+		string(IssueMissingFileFS)+" "+c.missingFile.ID,
+		string(IssueStaleMissingFlag)+" "+c.staleFile.ID,
+		string(IssuePendingFile)+" "+c.pendingFile.ID,
+		string(IssueStorageState)+" "+c.userRoot.UserID,
+	)
+
+	// Only the issues of directories created by the import are kept by the filter.
+	filtered := report.FilterCreatedVia(CreatedImport)
+	assertIssues(t, "filtered issues", filtered.Issues, string(IssueDetachedDir)+" "+c.detachedDir.ID)
+}
+
+func TestDirServiceCheckStorageClean(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	addTestFile(t, f, root, addTestDir(t, f, root, userRoot, "photos"), "a.txt")
+	f.UpdateStorageState(context.Background(), userRoot.UserID, StorageReady)
+
+	report, err := s.CheckStorage(context.Background(), userRoot.UserID)
+	if err != nil {
+		t.Fatalf("CheckStorage() error = %v", err)
+	}
+
+	assertIssues(t, "issues", report.Issues)
+	if report.Directories != 2 || report.Files != 1 {
+		t.Errorf("counts = %d directories, %d files, want 2, 1", report.Directories, report.Files)
+	}
+}
+
+func TestDirServiceCheckStorageUserNotFound(t *testing.T) {
+	f := newFakeStorage(t)
+	s, _ := newTestDirService(t, f)
+	f.fail("SelectStorageState", sql.ErrNoRows)
+
+	_, err := s.CheckStorage(context.Background(), uuid.NewString())
+	assertSafeError(t, err, http.StatusNotFound, sql.ErrNoRows)
+}
+
+func TestDirServiceRepairStorage(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	c := newCorruptStorage(t, f, root)
+	ctx := context.Background()
+
+	fixable := []string{
+		string(IssueMissingDirFS) + " " + c.missingDir.ID,
+		string(IssueMissingFileFS) + " " + c.missingFile.ID,
+		string(IssueStaleMissingFlag) + " " + c.staleFile.ID,
+		string(IssueStorageState) + " " + c.userRoot.UserID,
+	}
+
+	// A dry run changes nothing.
+	before := f.data()
+	repair, err := s.RepairStorage(ctx, c.userRoot.UserID, true)
+	if err != nil {
+		t.Fatalf("RepairStorage() dry run error = %v", err)
+	}
+
+	if !repair.DryRun {
+		t.Error("DryRun = false, want true")
+	}
+	assertIssues(t, "dry run fixed", repair.Fixed, fixable...)
+
+	after := f.data()
+	if len(after.missing) != len(before.missing) || after.states[c.userRoot.UserID] != before.states[c.userRoot.UserID] {
+		t.Errorf("dry run changed the storage: missing %v, state %q", after.missing, after.states[c.userRoot.UserID])
+	}
+
+	if _, err := os.Stat(filepath.Join(root, c.userRoot.ID, c.missingDir.ID)); !os.IsNotExist(err) {
+		t.Errorf("dry run created the missing directory, stat error = %v", err)
+	}
+
+	// The repair applies the safe fixes, nothing is deleted.
+	repair, err = s.RepairStorage(ctx, c.userRoot.UserID, false)
+	if err != nil {
+		t.Fatalf("RepairStorage() error = %v", err)
+	}
+	assertIssues(t, "fixed", repair.Fixed, fixable...)
+	assertIssues(t, "failed", repair.Failed)
+
+	data := f.data()
+	if !data.missing[c.missingFile.ID] || data.missing[c.staleFile.ID] {
+		t.Errorf("missing = %v, want only %s flagged", data.missing, c.missingFile.ID)
+	}
+
+	if data.states[c.userRoot.UserID] != StorageReady {
+		t.Errorf("state = %q, want %q", data.states[c.userRoot.UserID], StorageReady)
+	}
+
+	if len(data.dirs) != 4 || len(data.files) != 4 {
+		t.Errorf("counts = %d directories, %d files, want nothing deleted", len(data.dirs), len(data.files))
+	}
+
+	// Only the issues that are not fixed automatically are left.
+	report, err := s.CheckStorage(ctx, c.userRoot.UserID)
+	if err != nil {
+		t.Fatalf("CheckStorage() error = %v", err)
+	}
+
+	assertIssues(t, "issues after the repair", report.Issues,
+		string(IssueDetachedDir)+" "+c.detachedDir.ID,
+		string(IssuePendingFile)+" "+c.pendingFile.ID,
+	)
+}
+
+func TestDirServiceRepairStorageFailure(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	c := newCorruptStorage(t, f, root)
+	f.fail("UpdateFileContentMissing", errors.New("connection reset"))
+
+	repair, err := s.RepairStorage(context.Background(), c.userRoot.UserID, false)
+	if err != nil {
+		t.Fatalf("RepairStorage() error = %v", err)
+	}
+
+	// A failed fix does not stop the others.
+	assertIssues(t, "failed", repair.Failed,
+		string(IssueMissingFileFS)+" "+c.missingFile.ID,
+		string(IssueStaleMissingFlag)+" "+c.staleFile.ID,
+	)
+	assertIssues(t, "fixed", repair.Fixed,
+		string(IssueMissingDirFS)+" "+c.missingDir.ID,
+		string(IssueStorageState)+" "+c.userRoot.UserID,
+	)
+
+	for _, issue := range repair.Failed {
+		if issue.Err == nil {
+			t.Errorf("failed issue %s has no error", issue.ID)
+		}
+	}
+}
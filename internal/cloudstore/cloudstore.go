@@ -1,6 +1,17 @@
 package cloudstore
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// ErrEmptyUserID signals a user ID was not provided. This usually means a handler
+// was not wrapped with a authentication middleware.
+var ErrEmptyUserID = errors.New("empty user id")
 
 // idFunc is the function that gets the ID that a new file or directory
 // should be written under. The function is passed a fallback ID (r) incase
@@ -13,3 +24,71 @@ type idFunc func(r string) (string, error)
 // mechanism is set up for the user. If it is not, it should set up the
 // users storage.
 type UserValidatorFunc func(ctx context.Context, userID string) (Dir, error)
+
+// requireUserID returns a app.WrappedSafeError with a 401 status code if userID
+// is empty. Any operation that may create or modify a users storage should call
+// requireUserID first, so that storage is never created for a empty user ID.
+func requireUserID(userID string) error {
+	if userID == "" {
+		return app.Wrap(app.WrapParams{
+			Err:         ErrEmptyUserID,
+			SafeMessage: "Unauthorized",
+			StatusCode:  http.StatusUnauthorized,
+		})
+	}
+
+	return nil
+}
+
+// StorageState is the provisioning state of a users root storage.
+type StorageState string
+
+const (
+	// StoragePending is the state of a user whose root storage has not been
+	// created yet.
+	StoragePending StorageState = "pending"
+
+	// StorageReady is the state of a user whose root storage exists.
+	StorageReady StorageState = "ready"
+
+	// StorageFailed is the state of a user whose root storage could not be created.
+	// It is retried when the user next uses their storage, or by calling
+	// DirService.NewUser.
+	StorageFailed StorageState = "failed"
+)
+
+// CreatedVia is the path that created a directory. It is recorded when the directory is
+// created and never changes.
+type CreatedVia string
+
+const (
+	// CreatedExplicit is a directory created by a request of the user.
+	CreatedExplicit CreatedVia = "explicit"
+
+	// CreatedAutoRoot is a root directory created when the storage of a user was first
+	// used or provisioned.
+	CreatedAutoRoot CreatedVia = "auto_root"
+
+	// CreatedAutoInbox is a directory named InboxName created by DirService.Inbox when the
+	// user uploaded to their inbox without a default upload directory.
+	CreatedAutoInbox CreatedVia = "auto_inbox"
+
+	// CreatedImport is a directory created by applying a sync manifest.
+	CreatedImport CreatedVia = "import"
+)
+
+// ParseCreatedVia parses the name of a CreatedVia. If value is not a CreatedVia, a 400
+// app.WrappedSafeError is returned for the field "created_via".
+func ParseCreatedVia(value string) (CreatedVia, error) {
+	switch v := CreatedVia(value); v {
+	case CreatedExplicit, CreatedAutoRoot, CreatedAutoInbox, CreatedImport:
+		return v, nil
+	default:
+		return "", app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid created_via: %q", value),
+			SafeMessage: fmt.Sprintf("created_via must be one of %s, %s, %s, or %s", CreatedExplicit, CreatedAutoRoot, CreatedAutoInbox, CreatedImport),
+			StatusCode:  http.StatusBadRequest,
+			Field:       "created_via",
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package cloudstore
+
+// RecordVersion is the version of the data recorded in a row of the files or directories
+// table. A row is bumped to a new version when the data that version adds is recorded,
+// either when the row is written or by the migration or backfill that records it for
+// older rows. Rows at different versions are read the same way, what a row holds is
+// decided by its Capabilities rather than by checking individual columns for NULL.
+//
+// The progress of a backfill can be queried with:
+//
+//	SELECT record_version, count(*) FROM files GROUP BY record_version;
+type RecordVersion int
+
+// The versions of the files table.
+const (
+	// FileVersionBase is a file uploaded before its content was recorded.
+	FileVersionBase RecordVersion = 1
+
+	// FileVersionContent is a file whose size, checksum, and content type are recorded.
+	FileVersionContent RecordVersion = 2
+
+	// FileVersionSearch is a file whose search text is recorded, if it is a text file
+	// that is indexed for content search.
+	FileVersionSearch RecordVersion = 3
+
+	// CurrentFileVersion is the version of a file once it is uploaded.
+	CurrentFileVersion = FileVersionSearch
+)
+
+// The versions of the directories table.
+const (
+	// DirVersionBase is a directory created before its layout was recorded. It is always
+	// stored with LayoutFlat.
+	DirVersionBase RecordVersion = 1
+
+	// DirVersionLayout is a directory whose storage_layout is recorded.
+	DirVersionLayout RecordVersion = 2
+
+	// CurrentDirVersion is the version of a directory when it is created.
+	CurrentDirVersion = DirVersionLayout
+)
+
+// Capabilities is the data a row holds at its RecordVersion. A version newer than the
+// ones known has every known capability, versions only add data.
+type Capabilities struct {
+	// HasChecksum is true if the size, checksum, and content type of a file are recorded.
+	HasChecksum bool
+
+	// HasSearchText is true if the search text of a file is recorded. A file that is
+	// not indexed has no search text even with this capability.
+	HasSearchText bool
+
+	// HasFanOutLayout is true if the storage_layout of a directory is recorded, so the
+	// directory may be stored with LayoutFanOut.
+	HasFanOutLayout bool
+}
+
+// FileCapabilities returns the Capabilities of a file at version v.
+func FileCapabilities(v RecordVersion) Capabilities {
+	return Capabilities{
+		HasChecksum:   v >= FileVersionContent,
+		HasSearchText: v >= FileVersionSearch,
+	}
+}
+
+// DirCapabilities returns the Capabilities of a directory at version v.
+func DirCapabilities(v RecordVersion) Capabilities {
+	return Capabilities{
+		HasFanOutLayout: v >= DirVersionLayout,
+	}
+}
+
+// layout returns the Layout of a directory with c whose storage_layout column holds
+// recorded. A directory that predates layouts is flat whatever the column holds.
+func (c Capabilities) layout(recorded Layout) Layout {
+	if !c.HasFanOutLayout {
+		return LayoutFlat
+	}
+
+	return recorded
+}
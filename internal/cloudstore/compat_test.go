@@ -0,0 +1,151 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+func TestFileCapabilities(t *testing.T) {
+	tests := []struct {
+		version RecordVersion
+		want    Capabilities
+	}{
+		{FileVersionBase, Capabilities{}},
+		{FileVersionContent, Capabilities{HasChecksum: true}},
+		{FileVersionSearch, Capabilities{HasChecksum: true, HasSearchText: true}},
+		// A newer version keeps every known capability.
+		{CurrentFileVersion + 1, Capabilities{HasChecksum: true, HasSearchText: true}},
+	}
+
+	for _, tc := range tests {
+		if got := FileCapabilities(tc.version); got != tc.want {
+			t.Errorf("FileCapabilities(%d) = %+v, want %+v", tc.version, got, tc.want)
+		}
+	}
+}
+
+func TestDirCapabilities(t *testing.T) {
+	tests := []struct {
+		version  RecordVersion
+		recorded Layout
+		want     Layout
+	}{
+		// A directory that predates layouts is flat whatever the column holds.
+		{DirVersionBase, LayoutFanOut, LayoutFlat},
+		{DirVersionLayout, LayoutFlat, LayoutFlat},
+		{DirVersionLayout, LayoutFanOut, LayoutFanOut},
+		{CurrentDirVersion + 1, LayoutFanOut, LayoutFanOut},
+	}
+
+	for _, tc := range tests {
+		c := DirCapabilities(tc.version)
+		if c.HasChecksum || c.HasSearchText {
+			t.Errorf("DirCapabilities(%d) = %+v, want no file capabilities", tc.version, c)
+		}
+
+		if got := c.layout(tc.recorded); got != tc.want {
+			t.Errorf("DirCapabilities(%d) layout of %s = %s, want %s", tc.version, tc.recorded, got, tc.want)
+		}
+	}
+}
+
+func TestRecordVersions(t *testing.T) {
+	ctx := context.Background()
+	q := NewQuery(dbtest.Tx(t))
+
+	userID := uuid.NewString()
+	_, err := q.db.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+
+	// A directory that predates layouts is read as flat, until its layout is recorded.
+	dirID := uuid.NewString()
+	if _, err := q.InsertDirectory(ctx, InsertDirectoryConfig{ID: dirID, UserID: userID, Name: RootName, Layout: LayoutFanOut}); err != nil {
+		t.Fatalf("inserting directory: %v", err)
+	}
+
+	if _, err := q.db.Exec(ctx, `UPDATE directories SET record_version = $1 WHERE id = $2`, DirVersionBase, dirID); err != nil {
+		t.Fatalf("setting directory version: %v", err)
+	}
+
+	assertLayout := func(want Layout) {
+		t.Helper()
+
+		got, err := q.SelectDirectoryLayout(ctx, dirID)
+		if err != nil {
+			t.Fatalf("SelectDirectoryLayout() error = %v", err)
+		}
+
+		if got != want {
+			t.Errorf("SelectDirectoryLayout() = %s, want %s", got, want)
+		}
+	}
+
+	assertLayout(LayoutFlat)
+
+	if err := q.UpdateDirectoryLayout(ctx, dirID, LayoutFanOut); err != nil {
+		t.Fatalf("UpdateDirectoryLayout() error = %v", err)
+	}
+
+	assertLayout(LayoutFanOut)
+
+	// A file is inserted at FileVersionBase and bumped once its content is recorded.
+	before, err := q.SelectFileVersionCounts(ctx)
+	if err != nil {
+		t.Fatalf("SelectFileVersionCounts() error = %v", err)
+	}
+
+	fileID := uuid.NewString()
+	if _, err := q.InsertFile(ctx, InsertFileConfig{ID: fileID, UserID: userID, DirectoryID: dirID, Name: "a.txt", Size: 5}); err != nil {
+		t.Fatalf("inserting file: %v", err)
+	}
+
+	assertVersion := func(want RecordVersion) {
+		t.Helper()
+
+		files, err := q.SelectBackfillFiles(ctx, "", 1_000_000)
+		if err != nil {
+			t.Fatalf("SelectBackfillFiles() error = %v", err)
+		}
+
+		for _, f := range files {
+			if f.ID == fileID {
+				if f.RecordVersion != want {
+					t.Errorf("file version = %d, want %d", f.RecordVersion, want)
+				}
+				return
+			}
+		}
+
+		t.Fatal("file not selected by SelectBackfillFiles")
+	}
+
+	assertVersion(FileVersionBase)
+
+	counts, err := q.SelectFileVersionCounts(ctx)
+	if err != nil {
+		t.Fatalf("SelectFileVersionCounts() error = %v", err)
+	}
+
+	if got := counts[FileVersionBase] - before[FileVersionBase]; got != 1 {
+		t.Errorf("files added at version %d = %d, want 1", FileVersionBase, got)
+	}
+
+	err = q.UpdateFileContent(ctx, fileID, FileContent{
+		Size:        5,
+		Checksum:    checksum("hello"),
+		ContentType: "text/plain; charset=utf-8",
+		SearchText:  sql.NullString{String: "hello", Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("UpdateFileContent() error = %v", err)
+	}
+
+	assertVersion(FileVersionSearch)
+}
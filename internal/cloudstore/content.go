@@ -0,0 +1,101 @@
+package cloudstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is the number of bytes used to detect the content type of a file.
+const sniffLen = 512
+
+// MaxSearchTextBytes is the size of the largest file indexed for content search.
+const MaxSearchTextBytes = 1 << 20
+
+// searchableExts are the extensions of the text files indexed for content search. If
+// this changes, the migration that adds the search_text column should be followed by
+// one that resets the record_version of the affected files.
+var searchableExts = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".csv": true, ".tsv": true, ".log": true,
+	".json": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".xml": true,
+	".html": true, ".htm": true, ".css": true, ".sql": true, ".sh": true, ".go": true,
+	".py": true, ".js": true, ".ts": true, ".java": true, ".c": true, ".h": true,
+	".cpp": true, ".hpp": true, ".rs": true, ".rb": true, ".php": true,
+}
+
+// FileContent is the size, checksum, content type, and search text of a files content.
+type FileContent struct {
+	Size int64
+
+	// Checksum is the hex encoded SHA-256 digest of the content.
+	Checksum string
+
+	// ContentType is the content type detected with http.DetectContentType.
+	ContentType string
+
+	// SearchText is the content indexed for content search. It is only valid for UTF-8
+	// text files with a searchable extension and at most MaxSearchTextBytes.
+	SearchText sql.NullString
+}
+
+// contentWriter records the size, checksum, and content type of everything written
+// to it, and the search text if indexText was called. Writes never fail.
+type contentWriter struct {
+	size int64
+	hash hash.Hash
+	head []byte
+
+	indexing bool
+	text     []byte
+}
+
+func newContentWriter() *contentWriter {
+	return &contentWriter{hash: sha256.New()}
+}
+
+func (w *contentWriter) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	w.hash.Write(p)
+
+	if len(w.head) < sniffLen {
+		w.head = append(w.head, p[:min(sniffLen-len(w.head), len(p))]...)
+	}
+
+	if w.indexing {
+		if len(w.text)+len(p) > MaxSearchTextBytes {
+			w.indexing, w.text = false, nil
+		} else {
+			w.text = append(w.text, p...)
+		}
+	}
+
+	return len(p), nil
+}
+
+// indexText records everything written as the search text if the file named name
+// with size bytes is indexed for content search.
+func (w *contentWriter) indexText(name string, size int64) {
+	w.indexing = size <= MaxSearchTextBytes && searchableExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// content returns the FileContent of everything written.
+func (w *contentWriter) content() FileContent {
+	c := FileContent{
+		Size:        w.size,
+		Checksum:    hex.EncodeToString(w.hash.Sum(nil)),
+		ContentType: http.DetectContentType(w.head),
+	}
+
+	// Postgres text cannot hold invalid UTF-8 or NUL bytes, such content is not text.
+	if w.indexing && utf8.Valid(w.text) && !bytes.ContainsRune(w.text, 0) {
+		c.SearchText = sql.NullString{String: string(w.text), Valid: true}
+	}
+
+	return c
+}
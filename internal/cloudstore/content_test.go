@@ -0,0 +1,62 @@
+package cloudstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContentWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		searchText  bool
+	}{
+		{name: "notes.txt", content: "hello", contentType: "text/plain; charset=utf-8", searchText: true},
+		{name: "README.MD", content: "# Title", contentType: "text/plain; charset=utf-8", searchText: true},
+		{name: "photo.png", content: "\x89PNG\r\n\x1a\n", contentType: "image/png"},
+		{name: "notes", content: "hello", contentType: "text/plain; charset=utf-8"},
+		{name: "invalid.txt", content: "caf\xe9", contentType: "text/plain; charset=utf-8"},
+		{name: "nul.txt", content: "a\x00b", contentType: "application/octet-stream"},
+		{name: "large.txt", content: strings.Repeat("a", MaxSearchTextBytes+1), contentType: "text/plain; charset=utf-8"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newContentWriter()
+			w.indexText(tc.name, int64(len(tc.content)))
+
+			// Written in small pieces, the sniffed head spans several writes.
+			for rest := tc.content; rest != ""; {
+				n := min(len(rest), 100)
+				w.Write([]byte(rest[:n]))
+				rest = rest[n:]
+			}
+
+			c := w.content()
+			if c.Size != int64(len(tc.content)) || c.Checksum != checksum(tc.content) {
+				t.Errorf("size = %d, checksum = %s, want %d, %s", c.Size, c.Checksum, len(tc.content), checksum(tc.content))
+			}
+
+			if c.ContentType != tc.contentType {
+				t.Errorf("content type = %q, want %q", c.ContentType, tc.contentType)
+			}
+
+			if c.SearchText.Valid != tc.searchText || (tc.searchText && c.SearchText.String != tc.content) {
+				t.Errorf("search text = %q, valid %v, want valid %v", c.SearchText.String, c.SearchText.Valid, tc.searchText)
+			}
+		})
+	}
+}
+
+func TestContentWriterGrowsPastLimit(t *testing.T) {
+	// The declared size is indexable, but more is written than declared.
+	w := newContentWriter()
+	w.indexText("notes.txt", 5)
+	w.Write([]byte(strings.Repeat("a", MaxSearchTextBytes)))
+	w.Write([]byte("a"))
+
+	if c := w.content(); c.SearchText.Valid {
+		t.Errorf("search text valid for %d bytes, want not indexed", c.Size)
+	}
+}
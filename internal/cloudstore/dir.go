@@ -2,7 +2,9 @@ package cloudstore
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
@@ -10,7 +12,9 @@ import (
 	"time"
 
 	"github.com/cicconee/clox/internal/app"
-	"github.com/cicconee/clox/internal/db"
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/internal/event"
+	"github.com/cicconee/clox/internal/pagination"
 	"github.com/google/uuid"
 )
 
@@ -19,18 +23,53 @@ import (
 //
 // DirService should be created using the NewDirService function.
 type DirService struct {
-	store   *Store
-	io      *IO
-	log     *log.Logger
-	pathMap *PathMapper
+	store    Storage
+	io       *IO
+	log      *log.Logger
+	pathMap  *FSPathMapper
+	perm     Perm
+	listings *ListingCache
+	cache    *cache.Redis
+	location *time.Location
+	access   *Access
+	layout   Layout
+	hooks    *Hooks
+
+	treeThresholds TreeThresholds
 }
 
 // DirServiceConfig is the DirService configuration.
 type DirServiceConfig struct {
-	Store   *Store
+	Store   Storage
 	IO      *IO
 	Log     *log.Logger
-	PathMap *PathMapper
+	PathMap *FSPathMapper
+	Perm    Perm
+
+	// Listings caches directory listings. If nil, listings are not cached. Every
+	// service that writes to directories should share the same ListingCache.
+	Listings *ListingCache
+
+	// Cache caches aggregated statistics. If nil, statistics are not cached.
+	Cache *cache.Redis
+
+	// Location is the time zone statistics are grouped into days in.
+	Location *time.Location
+
+	// Access decides if a user may access a directory.
+	Access *Access
+
+	// Layout is the file system layout of new directories. Existing directories keep the
+	// layout they were created with. It should be parsed with ParseLayout.
+	Layout Layout
+
+	// Hooks are run after directories are created. If nil, no hooks are run. It should
+	// be the same Hooks as the FileService.
+	Hooks *Hooks
+
+	// TreeThresholds are the limits above which TreeReport flags the directory tree of
+	// a user.
+	TreeThresholds TreeThresholds
 }
 
 // NewDirService creates a new DirService.
@@ -43,6 +82,18 @@ type DirServiceConfig struct {
 // multiple IO's.
 //
 // If Log is not set, it will default to log.Default().
+//
+// If Perm is not set, it will default to DefaultDirPerm. Perm should be parsed
+// with ParsePerms.
+//
+// If Location is not set, it will default to time.UTC.
+//
+// If Access is not set, it will default to NewAccess(c.Store).
+//
+// If Layout is not set, it will default to LayoutFlat.
+//
+// If a TreeThresholds field is not set, it will default to the field of
+// DefaultTreeThresholds.
 func NewDirService(c DirServiceConfig) *DirService {
 	if c.Store == nil {
 		panic("cloudstore.NewDirService: cannot create DirService with nil Store")
@@ -60,24 +111,45 @@ func NewDirService(c DirServiceConfig) *DirService {
 		c.Log = log.Default()
 	}
 
-	return &DirService{
-		store:   c.Store,
-		io:      c.IO,
-		log:     c.Log,
-		pathMap: c.PathMap,
+	if c.Perm == 0 {
+		c.Perm = DefaultDirPerm
 	}
-}
 
-// SetupRoot will validate that the root storage directory exists. If it
-// does not exist, it will be created.
-//
-// The directory created will have permissions 0700. Only the system user
-// that is running this application can read, write, and execute this
-// directory.
-//
-// This method should be called once before executing any other DirService methods.
-func (s *DirService) SetupRoot() error {
-	return s.io.SetupFSRoot(0700)
+	if c.Location == nil {
+		c.Location = time.UTC
+	}
+
+	if c.Access == nil {
+		c.Access = NewAccess(c.Store)
+	}
+
+	if c.Layout == 0 {
+		c.Layout = LayoutFlat
+	}
+
+	if c.TreeThresholds.Depth == 0 {
+		c.TreeThresholds.Depth = DefaultTreeThresholds.Depth
+	}
+
+	if c.TreeThresholds.ClosureRows == 0 {
+		c.TreeThresholds.ClosureRows = DefaultTreeThresholds.ClosureRows
+	}
+
+	return &DirService{
+		store:    c.Store,
+		io:       c.IO,
+		log:      c.Log,
+		pathMap:  c.PathMap,
+		perm:     c.Perm,
+		listings: c.Listings,
+		cache:    c.Cache,
+		location: c.Location,
+		access:   c.Access,
+		layout:   c.Layout,
+		hooks:    c.Hooks,
+
+		treeThresholds: c.TreeThresholds,
+	}
 }
 
 type Dir struct {
@@ -89,63 +161,134 @@ type Dir struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	LastWrite time.Time
-	fsPath    string
+
+	// CreatedVia is the path that created the directory.
+	CreatedVia CreatedVia
+
+	fsPath string
+
+	// touched are the IDs of the directories whose last write was updated by writing
+	// the directory.
+	touched []string
+}
+
+// DirChange is a directory that was renamed or moved.
+type DirChange struct {
+	// Dir is the directory after it changed.
+	Dir Dir
+
+	// OldParentID and OldPath are the parent and path of the directory before it
+	// changed.
+	OldParentID string
+	OldPath     string
 }
 
+// RootName is the name of every users root directory. The root directory is the only
+// directory of a user without a parent, the name is not used to find it. It is reserved
+// for the direct children of the root directory, so a path never starts with it.
+const RootName = "root"
+
 // NewUser creates a new root directory for a user. All sub directories will be
-// persisted under this directory. Every users root directory will be named "root". The
-// file permissions are set to 0700.
+// persisted under this directory. Every users root directory will be named RootName.
+// The file permissions are set to the DirService's permissions, 0700 by default.
 //
 // The directory ID and name on the file system will be a randomly generated UUID.
 // The path "/" will correspond to this directory.
+//
+// NewUser is idempotent. If the user already has a root directory, it is returned. The
+// users storage state is set to StorageReady on success and StorageFailed on failure.
 func (s *DirService) NewUser(ctx context.Context, userID string) (Dir, error) {
-	return s.write(ctx, userID, "root", "")
+	dir, err := s.ValidateUser(ctx, userID)
+	if err != nil {
+		if stateErr := s.store.UpdateStorageState(ctx, userID, StorageFailed); stateErr != nil {
+			s.log.Printf("[ERROR] Setting storage state [user: %s, state: %s]: %v\n", userID, StorageFailed, stateErr)
+		}
+
+		return Dir{}, err
+	}
+
+	if err := s.store.UpdateStorageState(ctx, userID, StorageReady); err != nil {
+		return Dir{}, fmt.Errorf("setting storage state: %w", err)
+	}
+
+	return dir, nil
+}
+
+// StorageState gets the provisioning state of a users root storage.
+func (s *DirService) StorageState(ctx context.Context, userID string) (StorageState, error) {
+	if err := requireUserID(userID); err != nil {
+		return "", err
+	}
+
+	return s.store.SelectStorageState(ctx, userID)
 }
 
 // NewPath creates a new directory for a user under the provided path. The file
-// permissions are set to 0700. The path is cleaned using the filepath.Clean func.
-// An empty path will default to the users root directory.
+// permissions are set to the DirService's permissions, 0700 by default. The path is
+// cleaned using the filepath.Clean func. An empty path will default to the users
+// root directory.
 //
 // NewPath validates that a users root directory has been created. If it does not exist
 // it will create it.
 //
 // The directory ID and name on the file system will be a randomly generated UUID.
 func (s *DirService) NewPath(ctx context.Context, userID string, name string, path string) (Dir, error) {
-	return s.new(ctx, userID, name, func(rootID string) (string, error) {
-		return s.pathMap.FindDir(ctx, s.store.Query, PathSearch{
+	return s.NewPathRelative(ctx, userID, name, "", path)
+}
+
+// NewPathRelative creates a new directory for a user under the path relative to the
+// base directory (baseID). If baseID is empty, it is the same as NewPath. The user must
+// own the base directory, see UserPathMapper.FindDir for the errors of the base directory
+// and the path under it.
+func (s *DirService) NewPathRelative(ctx context.Context, userID string, name string, baseID string, path string) (Dir, error) {
+	return s.newPathRelative(ctx, userID, name, baseID, path, CreatedExplicit)
+}
+
+// newPathRelative is NewPathRelative with the path that created the directory.
+func (s *DirService) newPathRelative(ctx context.Context, userID string, name string, baseID string, path string, via CreatedVia) (Dir, error) {
+	return s.new(ctx, userID, name, via, func(rootID string) (string, error) {
+		return s.pathMap.FindDir(ctx, s.store, PathSearch{
 			UserID: userID,
 			RootID: rootID,
 			Path:   path,
+			BaseID: baseID,
 		})
 	})
 }
 
-// New creates a new directory for a user under a specific parent directory. The
-// file permissions are set to 0700. If parentID is empty, it will default to the users
-// root directory.
+// New creates a new directory for a user under a specific parent directory. The file
+// permissions are set to the DirService's permissions, 0700 by default. If parentID
+// is empty, it will default to the users root directory. If the parent directory does
+// not belong to the user, a 404 app.WrappedSafeError is returned.
 //
 // New validates that a users root directory has been created. If it does not exist
 // it will create it.
 //
 // The directory ID and name on the file system will be a randomly generated UUID.
 func (s *DirService) New(ctx context.Context, userID string, name string, parentID string) (Dir, error) {
-	return s.new(ctx, userID, name, func(rootID string) (string, error) {
+	return s.new(ctx, userID, name, CreatedExplicit, func(rootID string) (string, error) {
 		if parentID == "" {
 			return rootID, nil
 		}
 
+		if _, err := s.access.Dir(ctx, userID, parentID); err != nil {
+			return "", err
+		}
+
 		return parentID, nil
 	})
 }
 
-// new creates a new directory with the given name. The root directory is validated
-// and then passes the root directory ID to getParentID. This function should return
-// the ID of the parent directory that the new directory will be written under.
+// new creates a new directory with the given name, recording via as the path that
+// created it. The root directory is validated and then passes the root directory ID
+// to getParentID. This function should return the ID of the parent directory that
+// the new directory will be written under.
 //
 // If getParentID returns an error, new will not modify it and return it as is.
 //
-// If name is empty an error is returned.
-func (s *DirService) new(ctx context.Context, userID string, name string, getParentID idFunc) (Dir, error) {
+// If name is empty, or is RootName and the parent is the root directory, an error is
+// returned. A directory named RootName deeper in the tree is allowed.
+func (s *DirService) new(ctx context.Context, userID string, name string, via CreatedVia, getParentID idFunc) (Dir, error) {
 	if name == "" {
 		return Dir{}, app.Wrap(app.WrapParams{
 			Err:         errors.New("empty directory name"),
@@ -164,27 +307,48 @@ func (s *DirService) new(ctx context.Context, userID string, name string, getPar
 		return Dir{}, err
 	}
 
-	return s.write(ctx, userID, name, parentID)
+	if err := reservedName(parentID == root.ID, name); err != nil {
+		return Dir{}, err
+	}
+
+	return s.write(ctx, userID, name, parentID, via)
+}
+
+// reservedName returns a 400 app.WrappedSafeError if name is RootName and underRoot is
+// true, the directory would be named RootName directly under a root directory. A
+// directory named RootName deeper in the tree is allowed.
+func reservedName(underRoot bool, name string) error {
+	if !underRoot || name != RootName {
+		return nil
+	}
+
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("reserved directory name %q under the root directory", name),
+		SafeMessage: fmt.Sprintf("Directory name '%s' is reserved at the top level", name),
+		StatusCode:  http.StatusBadRequest,
+	})
 }
 
 // write writes and returns a user directory. The location of the directory is defined by
-// the parentID. Directories will be a direct child of the parent.
+// the parentID. Directories will be a direct child of the parent. via is recorded as the
+// path that created the directory.
 //
 // This DirService's io is used to persist the directory to the file system, and store the
 // information in the database. The operation is wrapped in a database transaction. If
 // commiting the transaction fails, it will attempt to delete the directory from the file
 // system.
-func (s *DirService) write(ctx context.Context, userID string, name string, parentID string) (Dir, error) {
+func (s *DirService) write(ctx context.Context, userID string, name string, parentID string, via CreatedVia) (Dir, error) {
 	var dir Dir
 
-	err := s.store.Tx(ctx, func(tx *db.Tx) error {
-		dirIO, err := s.io.NewDir(ctx, NewQuery(tx), NewDirIO{
-			ID:        uuid.NewString(),
-			UserID:    userID,
-			Name:      name,
-			ParentID:  sql.NullString{String: parentID, Valid: parentID != ""},
-			CreatedAt: time.Now().UTC(),
-			FSPerm:    0700,
+	err := s.store.Tx(ctx, func(q Tx) error {
+		dirIO, err := s.io.NewDir(ctx, q, NewDirIO{
+			ID:         uuid.NewString(),
+			UserID:     userID,
+			Name:       name,
+			ParentID:   sql.NullString{String: parentID, Valid: parentID != ""},
+			FSPerm:     s.perm,
+			Layout:     s.layout,
+			CreatedVia: via,
 		})
 		if err != nil {
 			return err
@@ -195,47 +359,562 @@ func (s *DirService) write(ctx context.Context, userID string, name string, pare
 	})
 	if err != nil {
 		switch {
-		case errors.Is(err, ErrForeignKeyParentID):
-			err = app.Wrap(app.WrapParams{
-				Err:         fmt.Errorf("parent directory does not exist [parent_id: %s]: %w", parentID, err),
-				SafeMessage: fmt.Sprintf("Directory '%s' does not exist", parentID),
-				StatusCode:  http.StatusBadRequest,
-			})
+		case errors.Is(err, ErrForeignKeyParentID), errors.Is(err, ErrSyntaxParentID):
+			// The parent was checked before the write, it may have been removed since.
+			err = DirNotFound(parentID, err)
 		case errors.Is(err, ErrUniqueNameParentID):
 			err = app.Wrap(app.WrapParams{
 				Err:         fmt.Errorf("directory name not available [name: %s, parent_id: %s]: %w", name, parentID, err),
 				SafeMessage: fmt.Sprintf("Directory '%s' already exists", name),
 				StatusCode:  http.StatusBadRequest,
 			})
-		case errors.Is(err, ErrSyntaxParentID):
-			err = app.Wrap(app.WrapParams{
-				Err:         fmt.Errorf("invalid parent directory [parent_id: %s]: %w", parentID, err),
-				SafeMessage: fmt.Sprintf("'%s' is a invalid parent ID", parentID),
-				StatusCode:  http.StatusBadRequest,
-			})
 		case errors.Is(err, ErrCommitTx):
 			// At this point the file was written to disk, so the application is in a inconsistent state.
 			// Remove the directory since the information failed to be commited to the database.
-			go s.Remove(ctx, dir.fsPath)
+			go s.Remove(context.Background(), dir.fsPath)
 		}
 
 		return Dir{}, err
 	}
 
+	s.listings.Invalidate(ctx, dir.touched...)
+	s.hooks.runDirCreated(dir)
+
 	return dir, nil
 }
 
+// DeletePlan is what deleting a directory of a user removes.
+type DeletePlan struct {
+	// Dir is the deleted directory.
+	Dir Dir
+
+	// Dirs and Files are the number of directories and files deleted, Dir included.
+	Dirs  int
+	Files int
+
+	// Bytes is the size of the deleted files. Files uploaded before sizes were recorded
+	// are not counted.
+	Bytes int64
+}
+
+// Delete deletes a directory of a user with every directory and file under it. The
+// rows of the files and directories are deleted in a single transaction, and then the
+// directory is removed from the file system with Remove. If it cannot be removed, it
+// is logged and queued for Cleanup, the directory is still deleted.
+//
+// The directory, its parent, and every directory under it are locked for the
+// transaction, so no directory is created and no file is uploaded under it while it is
+// deleted. A TypeDirDeleted event is written in the transaction, and the
+// AfterDirDeleted hooks are run once it commits.
+//
+// If the directory does not exist or is not owned by the user, a 404
+// app.WrappedSafeError is returned. A users root directory cannot be deleted, a 400
+// app.WrappedSafeError is returned.
+func (s *DirService) Delete(ctx context.Context, userID string, dirID string) error {
+	_, err := s.delete(ctx, userID, dirID, false)
+	return err
+}
+
+// PlanDelete returns what Delete would delete, without deleting anything. It runs the
+// same transaction as Delete and rolls it back, so it returns the same errors and
+// locks the same directories while it runs.
+func (s *DirService) PlanDelete(ctx context.Context, userID string, dirID string) (DeletePlan, error) {
+	return s.delete(ctx, userID, dirID, true)
+}
+
+// delete deletes a directory of a user, see Delete. If dryRun is true, the transaction is
+// rolled back and nothing is removed from the file system.
+func (s *DirService) delete(ctx context.Context, userID string, dirID string, dryRun bool) (DeletePlan, error) {
+	dir, err := s.read(ctx, userID, dirID)
+	if err != nil {
+		return DeletePlan{}, err
+	}
+
+	if dir.ParentID == "" {
+		return DeletePlan{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("deleting root directory [id: %s]", dir.ID),
+			SafeMessage: "Root directory cannot be deleted",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	var plan DeletePlan
+	var fsPath string
+	var dirIDs, touched []string
+	err = s.store.Tx(ctx, func(q Tx) error {
+		dirIDs, err = q.LockSubtree(ctx, dir.ID, dir.ParentID)
+		if err != nil {
+			return fmt.Errorf("locking directories: %w", err)
+		}
+
+		if len(dirIDs) == 0 {
+			return DirNotFound(dir.ID, sql.ErrNoRows)
+		}
+
+		// The directory may have been moved before it was locked.
+		parentID := dir.ParentID
+		if dir, err = s.reread(ctx, q, dir); err != nil {
+			return err
+		}
+
+		if dir.ParentID != parentID {
+			if err := q.LockDirectories(ctx, dir.ParentID); err != nil {
+				return err
+			}
+		}
+
+		// The path is read before the rows it is built from are deleted.
+		fsPath, err = s.pathMap.GetDirFS(ctx, q, dir.ID)
+		if err != nil {
+			return fmt.Errorf("getting directory file system path [id: %s]: %w", dir.ID, err)
+		}
+
+		fileIDs, err := q.SelectFileIDsInDirectories(ctx, dirIDs)
+		if err != nil {
+			return fmt.Errorf("selecting descendant files: %w", err)
+		}
+
+		bytes, err := q.SelectFilesSize(ctx, fileIDs)
+		if err != nil {
+			return fmt.Errorf("selecting size of descendant files: %w", err)
+		}
+
+		if _, err := q.DeleteFiles(ctx, fileIDs); err != nil {
+			return fmt.Errorf("deleting files: %w", err)
+		}
+
+		if err := q.DeleteDirectories(ctx, dirIDs); err != nil {
+			return fmt.Errorf("deleting directories: %w", err)
+		}
+
+		touched, err = q.UpdateLastWrite(ctx, dir.ParentID)
+		if err != nil {
+			return fmt.Errorf("updating last write: %w", err)
+		}
+
+		err = q.InsertEvent(ctx, userID, event.TypeDirDeleted, event.DirDeleted{
+			ID:       dir.ID,
+			ParentID: dir.ParentID,
+			Name:     dir.Name,
+			Path:     dir.Path,
+			Dirs:     len(dirIDs),
+			Files:    len(fileIDs),
+		})
+		if err != nil {
+			return err
+		}
+
+		plan = DeletePlan{Dir: dir, Dirs: len(dirIDs), Files: len(fileIDs), Bytes: bytes}
+		if dryRun {
+			return errDryRun
+		}
+
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		return plan, nil
+	}
+
+	if err != nil {
+		return DeletePlan{}, err
+	}
+
+	s.listings.Invalidate(ctx, append(touched, dirIDs...)...)
+	s.Remove(ctx, fsPath)
+	s.hooks.runDirDeleted(dir)
+
+	return plan, nil
+}
+
+// Rename renames a directory of a user. The directory stays under its parent, and the
+// file system paths are built from IDs, so only the name of the directory is changed.
+// The returned Dir has the new path.
+//
+// The directory and its parent are locked for the transaction. A TypeDirRenamed event is
+// written in the transaction, and the AfterDirRenamed hooks are run once it commits.
+//
+// If newName is empty, "." or "..", or contains a path separator, a 400
+// app.WrappedSafeError is returned, the same as FileService.Rename. RootName is reserved
+// under the root directory the same way as New. A users root directory cannot be renamed,
+// a 400 app.WrappedSafeError is returned. If another directory under the parent is
+// using newName, a 409 app.WrappedSafeError is returned. If the directory does not
+// exist or is not owned by the user, a 404 app.WrappedSafeError is returned.
+func (s *DirService) Rename(ctx context.Context, userID string, dirID string, newName string) (Dir, error) {
+	if err := validateName(EntryDir, newName); err != nil {
+		return Dir{}, err
+	}
+
+	dir, err := s.read(ctx, userID, dirID)
+	if err != nil {
+		return Dir{}, err
+	}
+
+	if dir.ParentID == "" {
+		return Dir{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("renaming root directory [id: %s]", dir.ID),
+			SafeMessage: "Root directory cannot be renamed",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	var change DirChange
+	var dirIDs, touched []string
+	err = s.store.Tx(ctx, func(q Tx) error {
+		if err := q.LockDirectories(ctx, dir.ID, dir.ParentID); err != nil {
+			return fmt.Errorf("locking directories: %w", err)
+		}
+
+		// The directory may have been moved before it was locked.
+		parentID := dir.ParentID
+		if dir, err = s.reread(ctx, q, dir); err != nil {
+			return err
+		}
+
+		if dir.ParentID != parentID {
+			if err := q.LockDirectories(ctx, dir.ParentID); err != nil {
+				return err
+			}
+		}
+
+		if newName == RootName {
+			parent, err := q.SelectDirectoryByIDUser(ctx, dir.ParentID, userID)
+			if err != nil {
+				return fmt.Errorf("selecting parent directory [id: %s]: %w", dir.ParentID, err)
+			}
+
+			if err := reservedName(!parent.ParentID.Valid, newName); err != nil {
+				return err
+			}
+		}
+
+		if err := q.UpdateDirectoryName(ctx, dir.ID, userID, newName); err != nil {
+			return fmt.Errorf("updating directory name: %w", err)
+		}
+
+		touched, err = q.UpdateLastWrite(ctx, dir.ParentID)
+		if err != nil {
+			return fmt.Errorf("updating last write: %w", err)
+		}
+
+		// The listings of every directory under it hold the old path.
+		dirIDs, err = q.SelectDescendantDirectoryIDs(ctx, dir.ID)
+		if err != nil {
+			return fmt.Errorf("selecting descendant directories: %w", err)
+		}
+
+		change = DirChange{OldParentID: dir.ParentID, OldPath: dir.Path}
+		if change.Dir, err = s.reread(ctx, q, dir); err != nil {
+			return err
+		}
+
+		return q.InsertEvent(ctx, userID, event.TypeDirRenamed, event.DirRenamed{
+			ID:       dir.ID,
+			ParentID: dir.ParentID,
+			Name:     newName,
+			Path:     change.Dir.Path,
+			OldName:  dir.Name,
+			OldPath:  dir.Path,
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUniqueNameParentID):
+			return Dir{}, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("directory name not available [name: %s, parent_id: %s]: %w", newName, dir.ParentID, err),
+				SafeMessage: fmt.Sprintf("Directory '%s' already exists", newName),
+				StatusCode:  http.StatusConflict,
+			})
+		case errors.Is(err, sql.ErrNoRows):
+			return Dir{}, DirNotFound(dirID, err)
+		default:
+			return Dir{}, err
+		}
+	}
+
+	s.listings.Invalidate(ctx, append(touched, dirIDs...)...)
+	s.hooks.runDirRenamed(change)
+
+	return s.read(ctx, userID, dir.ID)
+}
+
+// Move moves a directory of a user, with every directory and file under it, under the
+// directory newParentID of the user. The parent of the directory and the paths of the
+// moved subtree are updated in a transaction. The file system path of a directory is
+// built from the IDs of its ancestors, so the directory is moved on the file system
+// before the transaction commits. If the commit fails, it is moved back. The returned
+// Dir has the new path.
+//
+// If the directory or new parent does not exist or is not owned by the user, a 404
+// app.WrappedSafeError is returned. A users root directory cannot be moved, and a
+// directory cannot be moved under itself or one of its descendants, a 400
+// app.WrappedSafeError is returned. If a directory under the new parent is using the
+// name of the directory, a 409 app.WrappedSafeError is returned. Moving a directory
+// under its parent does nothing.
+//
+// The directory, its parent, the new parent, and every directory under it are locked for
+// the transaction, see Query.LockSubtree. A TypeDirMoved event is written in the
+// transaction, and the AfterDirMoved hooks are run once it commits.
+func (s *DirService) Move(ctx context.Context, userID string, dirID string, newParentID string) (Dir, error) {
+	dir, err := s.read(ctx, userID, dirID)
+	if err != nil {
+		return Dir{}, err
+	}
+
+	if dir.ParentID == "" {
+		return Dir{}, moveRootError(dir)
+	}
+
+	parent, err := s.access.Dir(ctx, userID, newParentID)
+	if err != nil {
+		return Dir{}, err
+	}
+
+	if parent.ID == dir.ParentID {
+		return dir, nil
+	}
+
+	if err := checkDirMove(dir, parent); err != nil {
+		return Dir{}, err
+	}
+
+	var m dirMove
+	err = s.store.Tx(ctx, func(q Tx) error {
+		return s.moveTx(ctx, q, userID, dir, parent, &m, false)
+	})
+	if err != nil {
+		s.moveBack(m)
+		return Dir{}, moveDirError(err, dir, parent)
+	}
+
+	s.afterMove(ctx, m)
+
+	return s.read(ctx, userID, dir.ID)
+}
+
+// dirMove is a directory moved by DirService.moveTx.
+type dirMove struct {
+	change DirChange
+
+	// from and to are the file system paths of the directory before and after the move.
+	// moved is set once it is moved on the file system.
+	from, to string
+	moved    bool
+
+	// dirIDs are the directories of the moved subtree, and touched the directories whose
+	// last write was updated.
+	dirIDs, touched []string
+}
+
+// moveRootError returns the 400 app.WrappedSafeError of moving the root directory dir.
+func moveRootError(dir Dir) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("moving root directory [id: %s]", dir.ID),
+		SafeMessage: "Root directory cannot be moved",
+		StatusCode:  http.StatusBadRequest,
+	})
+}
+
+// checkDirMove checks that dir can be moved under parent before the transaction of the
+// move. A directory named RootName cannot be moved under a root directory.
+func checkDirMove(dir Dir, parent DirectoryRow) error {
+	return reservedName(!parent.ParentID.Valid, dir.Name)
+}
+
+// moveTx moves the directory dir of a user under parent in the transaction q, see Move.
+// The move is recorded in m, if the transaction does not commit and m.moved is set, the
+// directory must be moved back with moveBack. If dryRun is true, every statement is run
+// but the directory is not moved on the file system, the transaction must be rolled back.
+func (s *DirService) moveTx(ctx context.Context, q Tx, userID string, dir Dir, parent DirectoryRow, m *dirMove, dryRun bool) error {
+	dirIDs, err := q.LockSubtree(ctx, dir.ID, dir.ParentID, parent.ID)
+	if err != nil {
+		return fmt.Errorf("locking directories: %w", err)
+	}
+
+	if len(dirIDs) == 0 {
+		return DirNotFound(dir.ID, sql.ErrNoRows)
+	}
+
+	// The directory may have been moved before it was locked.
+	parentID := dir.ParentID
+	if dir, err = s.reread(ctx, q, dir); err != nil {
+		return err
+	}
+
+	if dir.ParentID != parentID {
+		if err := q.LockDirectories(ctx, dir.ParentID); err != nil {
+			return err
+		}
+	}
+
+	// The parent may have been deleted before it was locked.
+	if _, err := q.SelectDirectoryByIDUser(ctx, parent.ID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DirNotFound(parent.ID, err)
+		}
+
+		return fmt.Errorf("selecting parent directory [id: %s]: %w", parent.ID, err)
+	}
+
+	cycle, err := q.SelectIsDescendant(ctx, dir.ID, parent.ID)
+	if err != nil {
+		return fmt.Errorf("selecting descendant: %w", err)
+	}
+
+	if cycle {
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("moving directory [id: %s] under its descendant [id: %s]", dir.ID, parent.ID),
+			SafeMessage: "Directory cannot be moved into itself",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	from, err := s.pathMap.GetDirFS(ctx, q, dir.ID)
+	if err != nil {
+		return fmt.Errorf("getting directory file system path [id: %s]: %w", dir.ID, err)
+	}
+
+	if err := q.UpdateDirectoryParent(ctx, dir.ID, userID, parent.ID); err != nil {
+		return fmt.Errorf("updating directory parent: %w", err)
+	}
+
+	if err := q.MoveSubtreePaths(ctx, dir.ID, parent.ID); err != nil {
+		return fmt.Errorf("moving paths: %w", err)
+	}
+
+	to, err := s.pathMap.GetDirFS(ctx, q, dir.ID)
+	if err != nil {
+		return fmt.Errorf("getting directory file system path [id: %s]: %w", dir.ID, err)
+	}
+
+	var touched []string
+	for _, id := range []string{dir.ParentID, parent.ID} {
+		ids, err := q.UpdateLastWrite(ctx, id)
+		if err != nil {
+			return fmt.Errorf("updating last write: %w", err)
+		}
+		touched = append(touched, ids...)
+	}
+
+	change := DirChange{OldParentID: dir.ParentID, OldPath: dir.Path}
+	if change.Dir, err = s.reread(ctx, q, dir); err != nil {
+		return err
+	}
+
+	err = q.InsertEvent(ctx, userID, event.TypeDirMoved, event.DirMoved{
+		ID:          dir.ID,
+		ParentID:    parent.ID,
+		Name:        dir.Name,
+		Path:        change.Dir.Path,
+		OldParentID: dir.ParentID,
+		OldPath:     dir.Path,
+		Dirs:        len(dirIDs),
+	})
+	if err != nil {
+		return err
+	}
+
+	*m = dirMove{change: change, from: from, to: to, dirIDs: dirIDs, touched: touched}
+	if dryRun {
+		return nil
+	}
+
+	if err := s.io.MoveFS(from, to); err != nil {
+		return fmt.Errorf("moving directory [%s]: %w", from, err)
+	}
+
+	m.moved = true
+	return nil
+}
+
+// moveBack moves the directory of m back on the file system, if it was moved. It is
+// called when the transaction of the move does not commit.
+func (s *DirService) moveBack(m dirMove) {
+	if !m.moved {
+		return
+	}
+
+	if err := s.io.MoveFS(m.to, m.from); err != nil {
+		s.log.Printf("[ERROR] Moving directory back after failed move [from: %s, to: %s]: %v\n", m.to, m.from, err)
+	}
+}
+
+// afterMove invalidates the listings of the directory of m and runs the AfterDirMoved
+// hooks, once the transaction of the move commits.
+func (s *DirService) afterMove(ctx context.Context, m dirMove) {
+	// The listings of every directory of the subtree hold the old paths.
+	s.listings.Invalidate(ctx, append(m.touched, m.dirIDs...)...)
+	s.hooks.runDirMoved(m.change)
+}
+
+// moveDirError returns the error of the move of dir under parent that failed with err.
+func moveDirError(err error, dir Dir, parent DirectoryRow) error {
+	var safeErr *app.WrappedSafeError
+	switch {
+	case errors.As(err, &safeErr):
+		return err
+	case errors.Is(err, ErrUniqueNameParentID):
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("directory name not available [name: %s, parent_id: %s]: %w", dir.Name, parent.ID, err),
+			SafeMessage: fmt.Sprintf("Directory '%s' already exists", dir.Name),
+			StatusCode:  http.StatusConflict,
+		})
+	case errors.Is(err, sql.ErrNoRows):
+		return DirNotFound(dir.ID, err)
+	default:
+		return err
+	}
+}
+
 // Remove accepts the path to a directory and removes it from the file system.
-// All sub directories and files will be removed. If directory cannot be removed,
-// the path will be logged.
+// All sub directories and files will be removed. Transient errors are retried. If
+// the directory still cannot be removed, it is queued to be removed by Cleanup.
 //
 // Remove only removes the directory from the file system. The database remains
 // unchanged.
-func (s *DirService) Remove(ctx context.Context, fsPath string) {
-	err := s.io.RemoveFSDir(fsPath)
+func (s *DirService) Remove(ctx context.Context, fsPath string) RemoveResult {
+	result := s.io.RemoveFSDir(fsPath)
+	if !result.OK() {
+		s.log.Printf("[ERROR] Removing directory [path: %s, attempts: %d]: %v\n", fsPath, result.Attempts, result.Err)
+		queueCleanup(ctx, s.store, s.log, result)
+	}
+
+	return result
+}
+
+// CleanupBatchSize is the maximum number of queued paths Cleanup retries.
+const CleanupBatchSize = 100
+
+// Cleanup retries removing the paths that Remove, or a failed file write, could not
+// remove from the file system. Paths that are removed are dequeued. It returns the
+// number of paths removed and the number that remain queued.
+func (s *DirService) Cleanup(ctx context.Context) (removed int, remaining int, err error) {
+	rows, err := s.store.SelectFSCleanup(ctx, CleanupBatchSize)
 	if err != nil {
-		s.log.Printf("[ERROR] Removing directory [path: %s]: %v\n", fsPath, err)
+		return 0, 0, fmt.Errorf("selecting queued cleanups: %w", err)
+	}
+
+	for _, row := range rows {
+		var result RemoveResult
+		if row.Recursive {
+			result = s.io.RemoveFSDir(row.Path)
+		} else {
+			result = s.io.RemoveFS(row.Path)
+		}
+
+		if !result.OK() {
+			remaining++
+			queueCleanup(ctx, s.store, s.log, result)
+			continue
+		}
+
+		if err := s.store.DeleteFSCleanup(ctx, row.Path); err != nil {
+			return removed, len(rows) - removed, fmt.Errorf("dequeueing cleanup [path: %s]: %w", row.Path, err)
+		}
+
+		removed++
 	}
+
+	return removed, remaining, nil
 }
 
 // ValidateUser validates that a root directory exists for the user. If it does
@@ -245,11 +924,23 @@ func (s *DirService) Remove(ctx context.Context, fsPath string) {
 // If a root directory exists and cannot be created, there is a serious problem with
 // the account and/or server. A app.WrappedSafeError will be returned with a message
 // stating they need to contact support.
+//
+// When a root directory is created, the users storage state is set to StorageReady.
+//
+// If userID is empty, a app.WrappedSafeError with a 401 status code is returned.
 func (s *DirService) ValidateUser(ctx context.Context, userID string) (Dir, error) {
+	if err := requireUserID(userID); err != nil {
+		return Dir{}, err
+	}
+
 	row, err := s.store.SelectUserRootDirectory(ctx, userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			rootDir, err := s.write(ctx, userID, "root", "")
+			rootDir, err := s.write(ctx, userID, RootName, "", CreatedAutoRoot)
+			if errors.Is(err, ErrUniqueUserRoot) {
+				// The root directory was created by a concurrent request.
+				return s.ValidateUser(ctx, userID)
+			}
 			if err != nil {
 				return Dir{}, app.Wrap(app.WrapParams{
 					Err:         fmt.Errorf("creating users root directory: %w", err),
@@ -258,6 +949,10 @@ func (s *DirService) ValidateUser(ctx context.Context, userID string) (Dir, erro
 				})
 			}
 
+			if err := s.store.UpdateStorageState(ctx, userID, StorageReady); err != nil {
+				s.log.Printf("[ERROR] Setting storage state [user: %s, state: %s]: %v\n", userID, StorageReady, err)
+			}
+
 			return rootDir, nil
 		}
 
@@ -265,12 +960,308 @@ func (s *DirService) ValidateUser(ctx context.Context, userID string) (Dir, erro
 	}
 
 	return Dir{
-		ID:        row.ID,
-		Owner:     row.UserID,
-		Name:      row.Name,
-		Path:      "/",
-		CreatedAt: row.CreatedAt,
-		UpdatedAt: row.UpdatedAt.Time,
-		LastWrite: row.LastWrite.Time,
+		ID:         row.ID,
+		Owner:      row.UserID,
+		Name:       row.Name,
+		Path:       "/",
+		CreatedAt:  row.CreatedAt,
+		UpdatedAt:  row.UpdatedAt.Time,
+		LastWrite:  row.LastWrite.Time,
+		CreatedVia: row.CreatedVia,
+	}, nil
+}
+
+// Usage is the storage used by a user.
+type Usage struct {
+	// Used is the total size in bytes of all the files the user owns.
+	Used int64
+}
+
+// Usage gets the storage used by a user. Storage quotas are not supported, so only
+// the used storage is returned.
+func (s *DirService) Usage(ctx context.Context, userID string) (Usage, error) {
+	if err := requireUserID(userID); err != nil {
+		return Usage{}, err
+	}
+
+	used, err := s.store.SelectStorageUsed(ctx, userID)
+	if err != nil {
+		return Usage{}, fmt.Errorf("selecting storage used: %w", err)
+	}
+
+	return Usage{Used: used}, nil
+}
+
+// InboxName is the name of the directory created under a users root directory
+// when they upload to their inbox without a default upload directory set.
+const InboxName = "Inbox"
+
+// Inbox returns the users default upload directory. If the user has not set a
+// default upload directory, or it was deleted, a directory named InboxName is
+// created under the users root directory and set as the default upload directory.
+// If a directory named InboxName already exists under the users root directory, it
+// is used instead.
+//
+// Inbox validates that a users root directory has been created. If it does not exist
+// it will create it.
+func (s *DirService) Inbox(ctx context.Context, userID string) (Dir, error) {
+	root, err := s.ValidateUser(ctx, userID)
+	if err != nil {
+		return Dir{}, err
+	}
+
+	dirID, err := s.store.SelectDefaultUploadDir(ctx, userID)
+	if err != nil {
+		return Dir{}, fmt.Errorf("selecting default upload directory [user_id: %s]: %w", userID, err)
+	}
+
+	if dirID.Valid {
+		return s.read(ctx, userID, dirID.String)
+	}
+
+	dir, err := s.write(ctx, userID, InboxName, root.ID, CreatedAutoInbox)
+	if err != nil {
+		if !errors.Is(err, ErrUniqueNameParentID) {
+			return Dir{}, err
+		}
+
+		row, err := s.store.SelectDirectoryByUserNameParent(ctx, userID, InboxName, root.ID)
+		if err != nil {
+			return Dir{}, fmt.Errorf("selecting existing inbox directory [user_id: %s]: %w", userID, err)
+		}
+
+		dir, err = s.read(ctx, userID, row.ID)
+		if err != nil {
+			return Dir{}, err
+		}
+	}
+
+	err = s.store.UpdateDefaultUploadDir(ctx, userID, sql.NullString{String: dir.ID, Valid: true})
+	if err != nil {
+		return Dir{}, fmt.Errorf("updating default upload directory [user_id: %s, directory_id: %s]: %w", userID, dir.ID, err)
+	}
+
+	return dir, nil
+}
+
+// SetInbox sets the users default upload directory. The directory must exist and
+// belong to the user. If dirID is empty, the default upload directory is reset and
+// the next call to Inbox will fall back to a directory named InboxName.
+//
+// The directory is returned as a Dir. If the default upload directory is reset, a
+// empty Dir is returned.
+func (s *DirService) SetInbox(ctx context.Context, userID string, dirID string) (Dir, error) {
+	if err := requireUserID(userID); err != nil {
+		return Dir{}, err
+	}
+
+	if dirID == "" {
+		err := s.store.UpdateDefaultUploadDir(ctx, userID, sql.NullString{})
+		if err != nil {
+			return Dir{}, fmt.Errorf("resetting default upload directory [user_id: %s]: %w", userID, err)
+		}
+
+		return Dir{}, nil
+	}
+
+	dir, err := s.read(ctx, userID, dirID)
+	if err != nil {
+		return Dir{}, err
+	}
+
+	err = s.store.UpdateDefaultUploadDir(ctx, userID, sql.NullString{String: dir.ID, Valid: true})
+	if err != nil {
+		return Dir{}, fmt.Errorf("updating default upload directory [user_id: %s, directory_id: %s]: %w", userID, dir.ID, err)
+	}
+
+	return dir, nil
+}
+
+// DirInfo is a directory and its tree ETag.
+type DirInfo struct {
+	Dir
+
+	// TreeETag is a hash over the ID, name, and version of every direct child of the
+	// directory. It changes whenever a direct child is added, renamed, or removed, or
+	// when anything beneath a child directory is written, since writes update the
+	// last write time of every ancestor.
+	//
+	// TreeETag is not a hash of the content. Two directories with the same children
+	// and versions will have the same TreeETag.
+	TreeETag string
+}
+
+// Info gets a users directory and its tree ETag. If dirID is empty, it will default
+// to the users root directory.
+//
+// Info validates that a users root directory has been created. If it does not exist
+// it will create it.
+func (s *DirService) Info(ctx context.Context, userID string, dirID string) (DirInfo, error) {
+	root, err := s.ValidateUser(ctx, userID)
+	if err != nil {
+		return DirInfo{}, err
+	}
+
+	if dirID == "" {
+		dirID = root.ID
+	}
+
+	dir, err := s.read(ctx, userID, dirID)
+	if err != nil {
+		return DirInfo{}, err
+	}
+
+	versions, err := s.store.SelectChildVersions(ctx, dir.ID)
+	if err != nil {
+		return DirInfo{}, fmt.Errorf("selecting child versions [directory_id: %s]: %w", dir.ID, err)
+	}
+
+	h := sha256.New()
+	for _, v := range versions {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\n", v.Type, v.ID, v.Name, v.Version.UnixNano())
+	}
+
+	return DirInfo{
+		Dir:      dir,
+		TreeETag: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// DirContents is a directory and a page of its direct children.
+type DirContents struct {
+	Dir     Dir
+	Entries pagination.Page[Entry]
+}
+
+// List gets a users directory with a page of its sub directories and files. If dirID
+// is empty, it will default to the users root directory. The page is listed by
+// ListEntriesPage with opts and req, so it is served from the ListingCache the same as
+// every other listing.
+//
+// If the directory does not exist or does not belong to the user, a 404
+// app.WrappedSafeError is returned.
+func (s *DirService) List(ctx context.Context, cursors *pagination.Codec, userID string, dirID string, opts ListOptions, req pagination.Request) (DirContents, error) {
+	root, err := s.ValidateUser(ctx, userID)
+	if err != nil {
+		return DirContents{}, err
+	}
+
+	if dirID == "" {
+		dirID = root.ID
+	}
+
+	return s.contents(ctx, cursors, userID, dirID, opts, req)
+}
+
+// ListPath gets a users directory at the provided path with a page of its sub
+// directories and files, see List. The path is resolved with FSPathMapper.FindDir,
+// the page is then listed the same as List.
+func (s *DirService) ListPath(ctx context.Context, cursors *pagination.Codec, userID string, path string, opts ListOptions, req pagination.Request) (DirContents, error) {
+	root, err := s.ValidateUser(ctx, userID)
+	if err != nil {
+		return DirContents{}, err
+	}
+
+	dirID, err := s.pathMap.FindDir(ctx, s.store, PathSearch{
+		UserID: userID,
+		RootID: root.ID,
+		Path:   path,
+	})
+	if err != nil {
+		return DirContents{}, err
+	}
+
+	return s.contents(ctx, cursors, userID, dirID, opts, req)
+}
+
+// Contents gets a users directory with a page of its sub directories and files.
+//
+// Deprecated: Use List.
+func (s *DirService) Contents(ctx context.Context, cursors *pagination.Codec, userID string, dirID string, opts ListOptions, req pagination.Request) (DirContents, error) {
+	return s.List(ctx, cursors, userID, dirID, opts, req)
+}
+
+// ContentsPath gets a users directory at the provided path with a page of its sub
+// directories and files.
+//
+// Deprecated: Use ListPath.
+func (s *DirService) ContentsPath(ctx context.Context, cursors *pagination.Codec, userID string, path string, opts ListOptions, req pagination.Request) (DirContents, error) {
+	return s.ListPath(ctx, cursors, userID, path, opts, req)
+}
+
+// contents gets the directory dirID of a user with a page of its entries listed by
+// ListEntriesPage.
+func (s *DirService) contents(ctx context.Context, cursors *pagination.Codec, userID string, dirID string, opts ListOptions, req pagination.Request) (DirContents, error) {
+	dir, err := s.read(ctx, userID, dirID)
+	if err != nil {
+		return DirContents{}, err
+	}
+
+	page, err := s.ListEntriesPage(ctx, cursors, userID, dir.ID, opts, req)
+	if err != nil {
+		return DirContents{}, err
+	}
+
+	return DirContents{Dir: dir, Entries: page}, nil
+}
+
+// childPath returns the name based path of the child named name of the directory at
+// dirPath.
+func childPath(dirPath string, name string) string {
+	if dirPath == "/" {
+		return dirPath + name
+	}
+
+	return dirPath + "/" + name
+}
+
+// reread reads the parent and path of dir again with q. It is called once dir is locked,
+// a concurrent move may have changed them. If dir no longer exists, the error of
+// DirNotFound is returned.
+func (s *DirService) reread(ctx context.Context, q Queries, dir Dir) (Dir, error) {
+	row, err := q.SelectDirectoryByIDUser(ctx, dir.ID, dir.Owner)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Dir{}, DirNotFound(dir.ID, err)
+		}
+
+		return Dir{}, err
+	}
+
+	path, err := s.pathMap.GetDir(ctx, q, dir.ID)
+	if err != nil {
+		return Dir{}, err
+	}
+
+	dir.ParentID = row.ParentID.String
+	dir.Name = row.Name
+	dir.Path = path
+
+	return dir, nil
+}
+
+// read gets a users directory and returns it as a Dir. If the directory does not
+// exist or does not belong to the user, a 404 app.WrappedSafeError is returned.
+func (s *DirService) read(ctx context.Context, userID string, dirID string) (Dir, error) {
+	row, err := s.access.Dir(ctx, userID, dirID)
+	if err != nil {
+		return Dir{}, err
+	}
+
+	path, err := s.pathMap.GetDir(ctx, s.store, row.ID)
+	if err != nil {
+		return Dir{}, err
+	}
+
+	return Dir{
+		ID:         row.ID,
+		Owner:      row.UserID,
+		ParentID:   row.ParentID.String,
+		Name:       row.Name,
+		Path:       path,
+		CreatedAt:  row.CreatedAt,
+		UpdatedAt:  row.UpdatedAt.Time,
+		LastWrite:  row.LastWrite.Time,
+		CreatedVia: row.CreatedVia,
 	}, nil
 }
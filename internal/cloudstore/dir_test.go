@@ -0,0 +1,1246 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/event"
+	"github.com/cicconee/clox/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// newTestDirService creates a DirService on f that stores directories in a new temporary
+// directory, which is returned.
+func newTestDirService(t *testing.T, f *fakeStorage) (*DirService, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	return NewDirService(DirServiceConfig{
+		Store:   f,
+		PathMap: NewPathMapper(root),
+		Log:     log.New(io.Discard, "", 0),
+	}), root
+}
+
+// newTestDBServices creates a DirService and FileService on the test database, that
+// store directories and files in a new temporary directory. A new user is inserted and
+// its root directory is created, it is returned. The user is deleted when t finishes.
+//
+// If TEST_POSTGRES_HOST is not set, t is skipped.
+func newTestDBServices(t *testing.T) (*DirService, *FileService, Dir) {
+	t.Helper()
+
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	userID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	store := NewStore(p)
+	pathMap := NewPathMapper(t.TempDir())
+	dirs := NewDirService(DirServiceConfig{Store: store, PathMap: pathMap, Log: log.New(io.Discard, "", 0)})
+	files := NewFileService(FileServiceConfig{
+		Store:        store,
+		PathMap:      pathMap,
+		Log:          log.New(io.Discard, "", 0),
+		ValidateUser: dirs.ValidateUser,
+	})
+
+	userRoot, err := dirs.ValidateUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("ValidateUser() error = %v", err)
+	}
+
+	return dirs, files, userRoot
+}
+
+// addTestRoot adds the root directory of a new user to f and creates it in the file
+// store root. The root directory is returned.
+func addTestRoot(t *testing.T, f *fakeStorage, root string) DirectoryRow {
+	t.Helper()
+
+	row := DirectoryRow{
+		ID:         uuid.NewString(),
+		UserID:     uuid.NewString(),
+		Name:       RootName,
+		CreatedAt:  time.Now().UTC(),
+		CreatedVia: CreatedAutoRoot,
+	}
+	f.addDir(row)
+
+	if err := os.Mkdir(filepath.Join(root, row.ID), 0700); err != nil {
+		t.Fatalf("creating root directory: %v", err)
+	}
+
+	return row
+}
+
+// addTestDir adds the directory name under parent to f and creates it in the file store
+// root. The directory is returned.
+func addTestDir(t *testing.T, f *fakeStorage, root string, parent DirectoryRow, name string) DirectoryRow {
+	t.Helper()
+
+	row := DirectoryRow{
+		ID:         uuid.NewString(),
+		UserID:     parent.UserID,
+		Name:       name,
+		ParentID:   sql.NullString{String: parent.ID, Valid: true},
+		CreatedAt:  time.Now().UTC(),
+		CreatedVia: CreatedExplicit,
+	}
+	f.addDir(row)
+
+	f.mu.Lock()
+	ids := []string{}
+	for _, d := range f.ancestors(row.ID) {
+		ids = append([]string{d.ID}, ids...)
+	}
+	f.mu.Unlock()
+
+	if err := os.Mkdir(filepath.Join(append([]string{root}, ids...)...), 0700); err != nil {
+		t.Fatalf("creating directory: %v", err)
+	}
+
+	return row
+}
+
+// assertSafeError fails t if err is not a app.WrappedSafeError with the status code, or
+// does not wrap target.
+func assertSafeError(t *testing.T, err error, status int, target error) {
+	t.Helper()
+
+	var safeErr *app.WrappedSafeError
+	if !errors.As(err, &safeErr) {
+		t.Fatalf("error = %v, want a app.WrappedSafeError", err)
+	}
+
+	if _, got := safeErr.Safe(); got != status {
+		t.Errorf("status = %d, want %d", got, status)
+	}
+
+	if target != nil && !errors.Is(err, target) {
+		t.Errorf("error = %v, want it to wrap %v", err, target)
+	}
+}
+
+// dirEntries returns the names in the directory path.
+func dirEntries(t *testing.T, path string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatalf("reading directory: %v", err)
+	}
+
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	return names
+}
+
+func TestDirServiceNewUniqueName(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	addTestDir(t, f, root, userRoot, "photos")
+
+	_, err := s.New(context.Background(), userRoot.UserID, "photos", "")
+	assertSafeError(t, err, http.StatusBadRequest, ErrUniqueNameParentID)
+
+	if got := len(f.data().dirs); got != 2 {
+		t.Errorf("directories = %d, want 2", got)
+	}
+
+	if got := dirEntries(t, filepath.Join(root, userRoot.ID)); len(got) != 1 {
+		t.Errorf("root directory entries = %v, want only the existing directory", got)
+	}
+}
+
+func TestDirServiceNewParentRemoved(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	parent := addTestDir(t, f, root, userRoot, "photos")
+
+	// The parent is removed by a concurrent request after it was checked.
+	f.failOn("InsertDirectory", func() error {
+		f.concurrent(func(d *fakeData) { delete(d.dirs, parent.ID) })
+		return nil
+	})
+
+	_, err := s.New(context.Background(), userRoot.UserID, "2024", parent.ID)
+	assertSafeError(t, err, http.StatusNotFound, ErrForeignKeyParentID)
+
+	if got := f.data().events; len(got) != 0 {
+		t.Errorf("events = %v, want none", got)
+	}
+}
+
+func TestDirServiceNewCommitFailure(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	f.failCommit(errors.New("connection reset"))
+
+	_, err := s.New(context.Background(), userRoot.UserID, "photos", "")
+	if !errors.Is(err, ErrCommitTx) {
+		t.Fatalf("New() error = %v, want ErrCommitTx", err)
+	}
+
+	data := f.data()
+	if len(data.dirs) != 1 || len(data.events) != 0 {
+		t.Errorf("directories = %d, events = %v, want the transaction rolled back", len(data.dirs), data.events)
+	}
+
+	// The directory was created on the file system in the transaction, it is removed in
+	// the background once the commit fails.
+	userRootFS := filepath.Join(root, userRoot.ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dirEntries(t, userRootFS)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("root directory entries = %v, want the uncommitted directory removed", dirEntries(t, userRootFS))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDirServiceNewLastWriteFailure(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+
+	// The ancestors are updated last, once the directory is on the file system.
+	var created []string
+	f.failOn("UpdateLastWrite", func() error {
+		created = dirEntries(t, filepath.Join(root, userRoot.ID))
+		return errors.New("deadlock detected")
+	})
+
+	if _, err := s.New(context.Background(), userRoot.UserID, "photos", ""); err == nil {
+		t.Fatal("New() error = nil, want the update error")
+	}
+
+	if len(created) != 1 {
+		t.Errorf("root directory entries when the last write was updated = %v, want the new directory", created)
+	}
+
+	if got := dirEntries(t, filepath.Join(root, userRoot.ID)); len(got) != 0 {
+		t.Errorf("root directory entries = %v, want the directory removed", got)
+	}
+
+	if got := len(f.data().dirs); got != 1 {
+		t.Errorf("directories = %d, want the transaction rolled back", got)
+	}
+}
+
+func TestValidateUserCreatesRoot(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userID := uuid.NewString()
+
+	dir, err := s.ValidateUser(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("ValidateUser() error = %v", err)
+	}
+
+	if dir.Name != RootName || dir.Path != "/" || dir.CreatedVia != CreatedAutoRoot {
+		t.Errorf("ValidateUser() = %+v, want a root directory created via %s", dir, CreatedAutoRoot)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, dir.ID)); err != nil {
+		t.Errorf("root directory on the file system: %v", err)
+	}
+
+	data := f.data()
+	if data.states[userID] != StorageReady {
+		t.Errorf("storage state = %q, want %q", data.states[userID], StorageReady)
+	}
+
+	if len(data.events) != 1 || data.events[0] != event.TypeDirCreated {
+		t.Errorf("events = %v, want [%s]", data.events, event.TypeDirCreated)
+	}
+
+	again, err := s.ValidateUser(context.Background(), userID)
+	if err != nil || again.ID != dir.ID {
+		t.Errorf("ValidateUser() again = %s, %v, want the same root directory %s", again.ID, err, dir.ID)
+	}
+}
+
+func TestValidateUserRootRace(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userID := uuid.NewString()
+
+	// A concurrent request creates the root directory after this request found none.
+	concurrent := DirectoryRow{
+		ID:         uuid.NewString(),
+		UserID:     userID,
+		Name:       RootName,
+		CreatedAt:  time.Now().UTC(),
+		CreatedVia: CreatedAutoRoot,
+	}
+	f.failOn("InsertDirectory", func() error {
+		f.concurrent(func(d *fakeData) { d.dirs[concurrent.ID] = concurrent })
+		return nil
+	})
+
+	dir, err := s.ValidateUser(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("ValidateUser() error = %v", err)
+	}
+
+	if dir.ID != concurrent.ID {
+		t.Errorf("ValidateUser() = %s, want the root directory of the concurrent request %s", dir.ID, concurrent.ID)
+	}
+
+	data := f.data()
+	if len(data.dirs) != 1 || len(data.events) != 0 {
+		t.Errorf("directories = %d, events = %v, want only the concurrent root directory", len(data.dirs), data.events)
+	}
+
+	// The request that lost the race does not set the storage state.
+	if _, ok := data.states[userID]; ok {
+		t.Errorf("storage state = %q, want it not set", data.states[userID])
+	}
+
+	if got := dirEntries(t, root); len(got) != 0 {
+		t.Errorf("file store entries = %v, want none", got)
+	}
+}
+
+func TestValidateUserRootFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(f *fakeStorage)
+		status int
+		target error
+	}{
+		{
+			name:   "commit fails",
+			setup:  func(f *fakeStorage) { f.failCommit(errors.New("connection reset")) },
+			status: http.StatusInternalServerError,
+			target: ErrCommitTx,
+		},
+		{
+			name: "insert fails",
+			setup: func(f *fakeStorage) {
+				f.failOn("InsertDirectory", func() error {
+					return errors.New("connection reset")
+				})
+			},
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeStorage(t)
+			s, _ := newTestDirService(t, f)
+			userID := uuid.NewString()
+			tc.setup(f)
+
+			_, err := s.ValidateUser(context.Background(), userID)
+			assertSafeError(t, err, tc.status, tc.target)
+
+			if state, ok := f.data().states[userID]; ok {
+				t.Errorf("storage state = %q, want it not set", state)
+			}
+		})
+	}
+}
+
+func TestValidateUserLookupFailure(t *testing.T) {
+	f := newFakeStorage(t)
+	s, _ := newTestDirService(t, f)
+
+	lookupErr := errors.New("connection reset")
+	f.fail("SelectUserRootDirectory", lookupErr)
+
+	if _, err := s.ValidateUser(context.Background(), uuid.NewString()); !errors.Is(err, lookupErr) {
+		t.Errorf("ValidateUser() error = %v, want %v", err, lookupErr)
+	}
+
+	if got := f.data().dirs; len(got) != 0 {
+		t.Errorf("directories = %d, want none created", len(got))
+	}
+}
+
+func TestDirServicePlanDelete(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	photos := addTestDir(t, f, root, userRoot, "photos")
+	trip := addTestDir(t, f, root, photos, "trip")
+	a, _ := addTestFile(t, f, root, photos, "a.jpg")
+	b, _ := addTestFile(t, f, root, trip, "b.jpg")
+	addTestFile(t, f, root, trip, "c.jpg")
+	kept, keptPath := addTestFile(t, f, root, userRoot, "kept.txt")
+	f.setSize(a.ID, 100)
+	f.setSize(b.ID, 20)
+	f.setSize(kept.ID, 3)
+
+	before := f.data()
+	photosFS := filepath.Join(root, userRoot.ID, photos.ID)
+
+	plan, err := s.PlanDelete(context.Background(), userRoot.UserID, photos.ID)
+	if err != nil {
+		t.Fatalf("PlanDelete() error = %v", err)
+	}
+
+	want := DeletePlan{Dir: plan.Dir, Dirs: 2, Files: 3, Bytes: 120}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("PlanDelete() = %+v, want %+v", plan, want)
+	}
+
+	if plan.Dir.ID != photos.ID || plan.Dir.Path != "/photos" {
+		t.Errorf("PlanDelete() dir = %+v, want /photos", plan.Dir)
+	}
+
+	if !reflect.DeepEqual(f.data(), before) {
+		t.Errorf("data changed by PlanDelete()")
+	}
+
+	if got := dirEntries(t, photosFS); len(got) != 2 {
+		t.Errorf("entries of photos = %v, want them kept", got)
+	}
+
+	// The plan is what the delete that follows it deletes.
+	if err := s.Delete(context.Background(), userRoot.UserID, photos.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	after := f.data()
+	var bytes int64
+	for id := range before.files {
+		if _, ok := after.files[id]; !ok {
+			bytes += before.sizes[id]
+		}
+	}
+
+	deleted := DeletePlan{
+		Dir:   plan.Dir,
+		Dirs:  len(before.dirs) - len(after.dirs),
+		Files: len(before.files) - len(after.files),
+		Bytes: bytes,
+	}
+	if !reflect.DeepEqual(deleted, plan) {
+		t.Errorf("Delete() deleted %+v, want the plan %+v", deleted, plan)
+	}
+
+	if len(after.events) != 1 || after.events[0] != event.TypeDirDeleted {
+		t.Errorf("events = %v, want [%s]", after.events, event.TypeDirDeleted)
+	}
+
+	if _, err := os.Stat(photosFS); !os.IsNotExist(err) {
+		t.Errorf("photos on the file system: %v, want it removed", err)
+	}
+
+	assertContent(t, keptPath, "kept.txt")
+}
+
+func TestDirServicePlanDeleteRoot(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+
+	_, err := s.PlanDelete(context.Background(), userRoot.UserID, userRoot.ID)
+	assertSafeError(t, err, http.StatusBadRequest, nil)
+
+	_, err = s.PlanDelete(context.Background(), userRoot.UserID, uuid.NewString())
+	assertSafeError(t, err, http.StatusNotFound, ErrNotFound)
+}
+
+func TestDirServiceMoveParentDeleted(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	dir := addTestDir(t, f, root, userRoot, "photos")
+	parent := addTestDir(t, f, root, userRoot, "archive")
+
+	// The new parent is deleted by a concurrent request after it was checked, before
+	// its lock was taken.
+	f.failOn("LockSubtree", func() error {
+		f.concurrent(func(d *fakeData) { delete(d.dirs, parent.ID) })
+		return nil
+	})
+
+	_, err := s.Move(context.Background(), userRoot.UserID, dir.ID, parent.ID)
+	assertSafeError(t, err, http.StatusNotFound, sql.ErrNoRows)
+
+	data := f.data()
+	if got := data.dirs[dir.ID].ParentID.String; got != userRoot.ID {
+		t.Errorf("directory parent = %s, want %s", got, userRoot.ID)
+	}
+
+	if len(data.events) != 0 {
+		t.Errorf("events = %v, want none", data.events)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, userRoot.ID, dir.ID)); err != nil {
+		t.Errorf("directory not at its path: %v", err)
+	}
+}
+
+func TestDirServiceDeleteMoveRace(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	userID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	store := NewStore(p)
+	root := t.TempDir()
+	s := NewDirService(DirServiceConfig{
+		Store:   store,
+		PathMap: NewPathMapper(root),
+		Log:     log.New(io.Discard, "", 0),
+	})
+
+	userRoot, err := s.ValidateUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("ValidateUser() error = %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		a, err := s.New(ctx, userID, "a", "")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		b, err := s.New(ctx, userID, "b", "")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		// Either the move commits first and b is deleted with a, or the delete commits
+		// first and the move fails.
+		var wg sync.WaitGroup
+		var deleteErr, moveErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			deleteErr = s.Delete(ctx, userID, a.ID)
+		}()
+		go func() {
+			defer wg.Done()
+			_, moveErr = s.Move(ctx, userID, b.ID, a.ID)
+		}()
+		wg.Wait()
+
+		if deleteErr != nil {
+			t.Fatalf("Delete() error = %v", deleteErr)
+		}
+
+		_, err = store.SelectDirectoryByIDUser(ctx, b.ID, userID)
+		if moveErr == nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Fatalf("moved directory not deleted with its parent, error = %v", err)
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("selecting directory not moved: %v", err)
+			}
+
+			path, err := store.SelectDirectoryFSPath(ctx, b.ID)
+			if err != nil {
+				t.Fatalf("SelectDirectoryFSPath() error = %v", err)
+			}
+
+			if want := []string{userRoot.ID, b.ID}; !reflect.DeepEqual(path, want) {
+				t.Fatalf("path = %v, want %v", path, want)
+			}
+
+			if _, err := os.Stat(filepath.Join(root, userRoot.ID, b.ID)); err != nil {
+				t.Fatalf("directory not moved is not at its path: %v", err)
+			}
+
+			if err := s.Delete(ctx, userID, b.ID); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+		}
+
+		dirs, err := store.SelectCheckDirectories(ctx, userID)
+		if err != nil {
+			t.Fatalf("SelectCheckDirectories() error = %v", err)
+		}
+
+		for _, d := range dirs {
+			n := len(d.IDPath)
+			if n == 0 || d.IDPath[n-1] != d.ID || (d.ParentID.Valid && (n < 2 || d.IDPath[n-2] != d.ParentID.String)) {
+				t.Fatalf("directory %s has path %v, parent %v", d.ID, d.IDPath, d.ParentID)
+			}
+		}
+
+		foreign, err := store.SelectForeignPaths(ctx, userID)
+		if err != nil {
+			t.Fatalf("SelectForeignPaths() error = %v", err)
+		}
+
+		if len(foreign) != 0 {
+			t.Fatalf("foreign paths = %v, want none", foreign)
+		}
+	}
+}
+
+// TestDirServiceListEntriesStatements lists directories of a few and many entries, the
+// statements executed do not grow with the entries listed.
+func TestDirServiceListEntriesStatements(t *testing.T) {
+	ctx := context.Background()
+	dirs, files, userRoot := newTestDBServices(t)
+
+	// The root is looked up, the directory is read and its path selected, and the
+	// entries are selected in one query.
+	const maxStatements = 4
+
+	for _, n := range []int{1, 20} {
+		dir, err := dirs.New(ctx, userRoot.Owner, fmt.Sprintf("entries-%d", n), "")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		headers := []*multipart.FileHeader{}
+		for i := 0; i < n; i++ {
+			if _, err := dirs.New(ctx, userRoot.Owner, fmt.Sprintf("dir-%d", i), dir.ID); err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			headers = append(headers, newTestFileHeader(t, fmt.Sprintf("file-%d.txt", i), "content"))
+		}
+
+		if _, err := files.SaveBatch(ctx, userRoot.Owner, dir.ID, headers, nil); err != nil {
+			t.Fatalf("SaveBatch() error = %v", err)
+		}
+
+		dbtest.AssertMaxQueries(t, maxStatements, func(ctx context.Context) {
+			entries, err := dirs.ListEntries(ctx, userRoot.Owner, dir.ID, ListOptions{Dirs: true, Files: true, Limit: 100})
+			if err != nil {
+				t.Fatalf("ListEntries() error = %v", err)
+			}
+
+			if len(entries) != 2*n {
+				t.Errorf("ListEntries() = %d entries, want %d", len(entries), 2*n)
+			}
+		})
+	}
+}
+
+func TestDirServiceListEntriesPage(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	for _, name := range []string{"c", "a", "b"} {
+		addTestDir(t, f, root, userRoot, name)
+	}
+
+	cursors := pagination.NewCodec(0)
+	cursors.SetSecret("test")
+
+	ctx := context.Background()
+	opts := ListOptions{Sort: SortName, Dirs: true, Files: true}
+
+	names := []string{}
+	req := pagination.Request{Limit: 2}
+	for {
+		page, err := s.ListEntriesPage(ctx, cursors, userRoot.UserID, "", opts, req)
+		if err != nil {
+			t.Fatalf("ListEntriesPage() error = %v", err)
+		}
+
+		for _, e := range page.Items {
+			names = append(names, e.Name)
+		}
+
+		if !page.HasMore {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+}
+
+func TestDirServiceListEntriesPageSortMismatch(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	for _, name := range []string{"a", "b"} {
+		addTestDir(t, f, root, userRoot, name)
+	}
+
+	cursors := pagination.NewCodec(0)
+	cursors.SetSecret("test")
+
+	ctx := context.Background()
+	byName := ListOptions{Sort: SortName, Dirs: true, Files: true}
+	page, err := s.ListEntriesPage(ctx, cursors, userRoot.UserID, "", byName, pagination.Request{Limit: 1})
+	if err != nil {
+		t.Fatalf("ListEntriesPage() error = %v", err)
+	}
+
+	// A cursor taken for the name sort cannot be used for the created sort.
+	byCreated := ListOptions{Sort: SortCreated, Dirs: true, Files: true}
+	req := pagination.Request{Cursor: page.NextCursor, Limit: 1}
+
+	_, err = s.ListEntriesPage(ctx, cursors, userRoot.UserID, "", byCreated, req)
+	assertSafeError(t, err, http.StatusBadRequest, pagination.ErrInvalidCursor)
+
+	_, err = s.List(ctx, cursors, userRoot.UserID, "", byCreated, req)
+	assertSafeError(t, err, http.StatusBadRequest, pagination.ErrInvalidCursor)
+
+	_, err = s.ListEntriesWindow(ctx, cursors, userRoot.UserID, "", byCreated, page.NextCursor, "", 1)
+	assertSafeError(t, err, http.StatusBadRequest, pagination.ErrInvalidCursor)
+}
+
+func TestDirServiceList(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	photos := addTestDir(t, f, root, userRoot, "photos")
+	for _, name := range []string{"beach", "city"} {
+		addTestDir(t, f, root, photos, name)
+	}
+
+	cursors := pagination.NewCodec(0)
+	cursors.SetSecret("test")
+	opts := ListOptions{Dirs: true, Files: true}
+	req := pagination.Request{Limit: 10}
+
+	names := func(contents DirContents) []string {
+		var names []string
+		for _, e := range contents.Entries.Items {
+			names = append(names, e.Path)
+		}
+		return names
+	}
+
+	contents, err := s.List(ctx, cursors, userRoot.UserID, "", opts, req)
+	if err != nil {
+		t.Fatalf("List() root error = %v", err)
+	}
+
+	if contents.Dir.ID != userRoot.ID || !reflect.DeepEqual(names(contents), []string{"/photos"}) {
+		t.Errorf("List() root = %s %v, want %s [/photos]", contents.Dir.ID, names(contents), userRoot.ID)
+	}
+
+	byID, err := s.List(ctx, cursors, userRoot.UserID, photos.ID, opts, req)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	byPath, err := s.ListPath(ctx, cursors, userRoot.UserID, "/photos", opts, req)
+	if err != nil {
+		t.Fatalf("ListPath() error = %v", err)
+	}
+
+	want := []string{"/photos/beach", "/photos/city"}
+	for _, contents := range []DirContents{byID, byPath} {
+		if contents.Dir.Path != "/photos" || !reflect.DeepEqual(names(contents), want) {
+			t.Errorf("listing = %s %v, want /photos %v", contents.Dir.Path, names(contents), want)
+		}
+	}
+
+	_, err = s.List(ctx, cursors, "other", photos.ID, opts, req)
+	assertSafeError(t, err, http.StatusNotFound, nil)
+
+	_, err = s.ListPath(ctx, cursors, userRoot.UserID, "/videos", opts, req)
+	assertSafeError(t, err, http.StatusNotFound, nil)
+}
+
+func TestDirServiceInbox(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+
+	inbox, err := s.Inbox(ctx, userRoot.UserID)
+	if err != nil {
+		t.Fatalf("Inbox() error = %v", err)
+	}
+
+	if inbox.Name != InboxName || inbox.ParentID != userRoot.ID || inbox.CreatedVia != CreatedAutoInbox {
+		t.Errorf("Inbox() = %+v, want %s under the root directory created via %s", inbox, InboxName, CreatedAutoInbox)
+	}
+
+	if got := f.data().uploadDirs[userRoot.UserID]; got != inbox.ID {
+		t.Errorf("default upload directory = %s, want the created inbox %s", got, inbox.ID)
+	}
+
+	again, err := s.Inbox(ctx, userRoot.UserID)
+	if err != nil || again.ID != inbox.ID {
+		t.Errorf("Inbox() again = %s, %v, want the same directory %s", again.ID, err, inbox.ID)
+	}
+
+	if got := len(f.data().dirs); got != 2 {
+		t.Errorf("directories = %d, want the root and inbox", got)
+	}
+}
+
+func TestDirServiceInboxExisting(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	existing := addTestDir(t, f, root, userRoot, InboxName)
+
+	inbox, err := s.Inbox(context.Background(), userRoot.UserID)
+	if err != nil {
+		t.Fatalf("Inbox() error = %v", err)
+	}
+
+	if inbox.ID != existing.ID {
+		t.Errorf("Inbox() = %s, want the existing %s directory %s", inbox.ID, InboxName, existing.ID)
+	}
+
+	if got := f.data().uploadDirs[userRoot.UserID]; got != existing.ID {
+		t.Errorf("default upload directory = %s, want %s", got, existing.ID)
+	}
+}
+
+func TestDirServiceSetInbox(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	photos := addTestDir(t, f, root, userRoot, "photos")
+
+	dir, err := s.SetInbox(ctx, userRoot.UserID, photos.ID)
+	if err != nil || dir.ID != photos.ID {
+		t.Fatalf("SetInbox() = %s, %v, want %s", dir.ID, err, photos.ID)
+	}
+
+	inbox, err := s.Inbox(ctx, userRoot.UserID)
+	if err != nil || inbox.ID != photos.ID {
+		t.Errorf("Inbox() = %s, %v, want the set directory %s", inbox.ID, err, photos.ID)
+	}
+
+	// A directory of another user cannot be set.
+	other := addTestRoot(t, f, root)
+	_, err = s.SetInbox(ctx, userRoot.UserID, other.ID)
+	assertSafeError(t, err, http.StatusNotFound, ErrNotFound)
+
+	if got := f.data().uploadDirs[userRoot.UserID]; got != photos.ID {
+		t.Errorf("default upload directory = %s, want it unchanged %s", got, photos.ID)
+	}
+
+	// Resetting falls back to a directory named InboxName.
+	if _, err := s.SetInbox(ctx, userRoot.UserID, ""); err != nil {
+		t.Fatalf("SetInbox() reset error = %v", err)
+	}
+
+	inbox, err = s.Inbox(ctx, userRoot.UserID)
+	if err != nil || inbox.Name != InboxName {
+		t.Errorf("Inbox() after reset = %+v, %v, want a %s directory", inbox, err, InboxName)
+	}
+}
+
+func TestDirServiceInboxDeleted(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	photos := addTestDir(t, f, root, userRoot, "photos")
+
+	if _, err := s.SetInbox(ctx, userRoot.UserID, photos.ID); err != nil {
+		t.Fatalf("SetInbox() error = %v", err)
+	}
+
+	f.concurrent(func(d *fakeData) { delete(d.dirs, photos.ID) })
+
+	inbox, err := s.Inbox(ctx, userRoot.UserID)
+	if err != nil {
+		t.Fatalf("Inbox() error = %v", err)
+	}
+
+	if inbox.Name != InboxName || inbox.ParentID != userRoot.ID {
+		t.Errorf("Inbox() = %+v, want a new %s directory once the default was deleted", inbox, InboxName)
+	}
+}
+
+func TestDirServiceEmptyUserID(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, _ := newTestDirService(t, f)
+
+	_, err := s.ValidateUser(ctx, "")
+	assertSafeError(t, err, http.StatusUnauthorized, ErrEmptyUserID)
+
+	_, err = s.NewUser(ctx, "")
+	assertSafeError(t, err, http.StatusUnauthorized, ErrEmptyUserID)
+
+	_, err = s.SetInbox(ctx, "", "")
+	assertSafeError(t, err, http.StatusUnauthorized, ErrEmptyUserID)
+
+	if got := len(f.data().dirs); got != 0 {
+		t.Errorf("directories = %d, want no root directory for the empty user ID", got)
+	}
+}
+
+func TestDirServiceInfoTreeETag(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	photos := addTestDir(t, f, root, userRoot, "photos")
+
+	etag := func() string {
+		t.Helper()
+
+		info, err := s.Info(ctx, userRoot.UserID, "")
+		if err != nil {
+			t.Fatalf("Info() error = %v", err)
+		}
+
+		if info.ID != userRoot.ID {
+			t.Fatalf("Info() = %s, want the root directory %s", info.ID, userRoot.ID)
+		}
+
+		return info.TreeETag
+	}
+
+	first := etag()
+	if again := etag(); again != first {
+		t.Errorf("TreeETag = %s, want it unchanged %s", again, first)
+	}
+
+	// A write beneath a child updates the last write of the child.
+	if _, err := s.New(ctx, userRoot.UserID, "2024", photos.ID); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	nested := etag()
+	if nested == first {
+		t.Error("TreeETag unchanged after a directory was created beneath a child")
+	}
+
+	if _, err := s.New(ctx, userRoot.UserID, "music", ""); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if etag() == nested {
+		t.Error("TreeETag unchanged after a child was added")
+	}
+}
+
+func TestDirServiceNewUser(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, _ := newTestDirService(t, f)
+	userID := uuid.NewString()
+
+	dir, err := s.NewUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("NewUser() error = %v", err)
+	}
+
+	// NewUser is idempotent, a retry returns the same root directory.
+	again, err := s.NewUser(ctx, userID)
+	if err != nil || again.ID != dir.ID {
+		t.Errorf("NewUser() again = %s, %v, want the same root directory %s", again.ID, err, dir.ID)
+	}
+
+	if state, err := s.StorageState(ctx, userID); err != nil || state != StorageReady {
+		t.Errorf("StorageState() = %q, %v, want %q", state, err, StorageReady)
+	}
+
+	if got := len(f.data().dirs); got != 1 {
+		t.Errorf("directories = %d, want 1", got)
+	}
+}
+
+func TestDirServiceNewUserFailure(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, _ := newTestDirService(t, f)
+	userID := uuid.NewString()
+	f.failCommit(errors.New("connection reset"))
+
+	if _, err := s.NewUser(ctx, userID); !errors.Is(err, ErrCommitTx) {
+		t.Fatalf("NewUser() error = %v, want ErrCommitTx", err)
+	}
+
+	if state, err := s.StorageState(ctx, userID); err != nil || state != StorageFailed {
+		t.Errorf("StorageState() = %q, %v, want %q", state, err, StorageFailed)
+	}
+}
+
+func TestDirServiceUsage(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	files, root := newTestFileService(t, f)
+	s := NewDirService(DirServiceConfig{Store: f, PathMap: NewPathMapper(root), Log: log.New(io.Discard, "", 0)})
+	userRoot := addTestRoot(t, f, root)
+	other := addTestRoot(t, f, root)
+	files.validateUser = s.ValidateUser
+
+	for _, dir := range []DirectoryRow{userRoot, other} {
+		_, err := files.SaveBatch(ctx, dir.UserID, "", []*multipart.FileHeader{
+			newTestFileHeader(t, "a.txt", "hello"),
+			newTestFileHeader(t, "b.txt", "world!"),
+		}, nil)
+		if err != nil {
+			t.Fatalf("SaveBatch() error = %v", err)
+		}
+	}
+
+	usage, err := s.Usage(ctx, userRoot.UserID)
+	if err != nil {
+		t.Fatalf("Usage() error = %v", err)
+	}
+
+	if usage.Used != 11 {
+		t.Errorf("Usage() = %d bytes, want the 11 bytes of the users files", usage.Used)
+	}
+}
+
+// TestDirServiceCreatedVia creates a directory through every creation path and checks
+// the path is stamped on the directory, its row, and its dir.created event.
+func TestDirServiceCreatedVia(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+
+		// root is true if the root directory of a user is set up before create.
+		root bool
+
+		// create creates a directory and returns it.
+		create func(t *testing.T, s *DirService, f *fakeStorage, userRoot DirectoryRow) (Dir, error)
+		want   CreatedVia
+	}{
+		{
+			name: "new",
+			root: true,
+			create: func(t *testing.T, s *DirService, f *fakeStorage, userRoot DirectoryRow) (Dir, error) {
+				return s.New(ctx, userRoot.UserID, "photos", "")
+			},
+			want: CreatedExplicit,
+		},
+		{
+			name: "new path",
+			root: true,
+			create: func(t *testing.T, s *DirService, f *fakeStorage, userRoot DirectoryRow) (Dir, error) {
+				return s.NewPath(ctx, userRoot.UserID, "photos", "/")
+			},
+			want: CreatedExplicit,
+		},
+		{
+			name: "new path relative",
+			root: true,
+			create: func(t *testing.T, s *DirService, f *fakeStorage, userRoot DirectoryRow) (Dir, error) {
+				return s.NewPathRelative(ctx, userRoot.UserID, "photos", userRoot.ID, "")
+			},
+			want: CreatedExplicit,
+		},
+		{
+			name: "first use",
+			create: func(t *testing.T, s *DirService, f *fakeStorage, userRoot DirectoryRow) (Dir, error) {
+				return s.ValidateUser(ctx, uuid.NewString())
+			},
+			want: CreatedAutoRoot,
+		},
+		{
+			name: "registration",
+			create: func(t *testing.T, s *DirService, f *fakeStorage, userRoot DirectoryRow) (Dir, error) {
+				return s.NewUser(ctx, uuid.NewString())
+			},
+			want: CreatedAutoRoot,
+		},
+		{
+			name: "inbox",
+			root: true,
+			create: func(t *testing.T, s *DirService, f *fakeStorage, userRoot DirectoryRow) (Dir, error) {
+				return s.Inbox(ctx, userRoot.UserID)
+			},
+			want: CreatedAutoInbox,
+		},
+		{
+			name: "sync manifest",
+			root: true,
+			create: func(t *testing.T, s *DirService, f *fakeStorage, userRoot DirectoryRow) (Dir, error) {
+				plan, err := ParseSyncPlan(`{"operations": [{"id": "1", "op": "mkdir", "path": "photos"}]}`, nil)
+				if err != nil {
+					t.Fatalf("ParseSyncPlan() error = %v", err)
+				}
+
+				files := NewFileService(FileServiceConfig{
+					Store:        f,
+					Log:          log.New(io.Discard, "", 0),
+					ValidateUser: s.ValidateUser,
+					PathMap:      s.pathMap,
+				})
+
+				results := NewSyncService(s, files, 1).Apply(ctx, userRoot.UserID, plan, nil)
+				if results[0].Err != nil {
+					return Dir{}, results[0].Err
+				}
+
+				return s.read(ctx, userRoot.UserID, results[0].ID)
+			},
+			want: CreatedImport,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeStorage(t)
+			s, root := newTestDirService(t, f)
+
+			var userRoot DirectoryRow
+			if tc.root {
+				userRoot = addTestRoot(t, f, root)
+			}
+
+			dir, err := tc.create(t, s, f, userRoot)
+			if err != nil {
+				t.Fatalf("creating directory: %v", err)
+			}
+
+			if dir.CreatedVia != tc.want {
+				t.Errorf("CreatedVia = %q, want %q", dir.CreatedVia, tc.want)
+			}
+
+			data := f.data()
+			if got := data.dirs[dir.ID].CreatedVia; got != tc.want {
+				t.Errorf("row created_via = %q, want %q", got, tc.want)
+			}
+
+			var payload event.DirCreated
+			for _, p := range data.payloads {
+				if d, ok := p.(event.DirCreated); ok && d.ID == dir.ID {
+					payload = d
+				}
+			}
+
+			if payload.CreatedVia != string(tc.want) {
+				t.Errorf("dir.created payload = %+v, want created_via %q", payload, tc.want)
+			}
+		})
+	}
+}
+
+// TestRenameInvalidName renames a directory and a file to names that do not name one
+// entry of their directory. Both are rejected the same way and left as they were.
+// TestDirServiceReservedRootName creates, renames, and moves directories named RootName.
+// The name is reserved directly under the root directory, and allowed below it.
+func TestDirServiceReservedRootName(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	userID := userRoot.UserID
+	photos := addTestDir(t, f, root, userRoot, "photos")
+	archive := addTestDir(t, f, root, userRoot, "archive")
+
+	assertReserved := func(op string, err error) {
+		t.Helper()
+
+		assertSafeError(t, err, http.StatusBadRequest, nil)
+		if msg, _ := err.(*app.WrappedSafeError).Safe(); msg != "Directory name 'root' is reserved at the top level" {
+			t.Errorf("%s error = %q, want the reserved name message", op, msg)
+		}
+	}
+
+	_, err := s.New(ctx, userID, RootName, "")
+	assertReserved("New() at the top level", err)
+
+	_, err = s.New(ctx, userID, RootName, userRoot.ID)
+	assertReserved("New() under the root ID", err)
+
+	nested, err := s.New(ctx, userID, RootName, photos.ID)
+	if err != nil {
+		t.Fatalf("New() under a directory error = %v", err)
+	}
+
+	if nested.Path != "/photos/root" {
+		t.Errorf("New() path = %s, want /photos/root", nested.Path)
+	}
+
+	_, err = s.Rename(ctx, userID, archive.ID, RootName)
+	assertReserved("Rename() at the top level", err)
+
+	_, err = s.Move(ctx, userID, nested.ID, userRoot.ID)
+	assertReserved("Move() to the top level", err)
+
+	moved, err := s.Move(ctx, userID, nested.ID, archive.ID)
+	if err != nil || moved.Path != "/archive/root" {
+		t.Fatalf("Move() under a directory = %s, %v, want /archive/root", moved.Path, err)
+	}
+
+	renamed, err := s.Rename(ctx, userID, moved.ID, "old")
+	if err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	renamed, err = s.Rename(ctx, userID, renamed.ID, RootName)
+	if err != nil || renamed.Path != "/archive/root" {
+		t.Errorf("Rename() under a directory = %s, %v, want /archive/root", renamed.Path, err)
+	}
+
+	data := f.data()
+	if got := data.dirs[archive.ID].Name; got != "archive" {
+		t.Errorf("top level directory renamed to %s", got)
+	}
+
+	if got := data.dirs[nested.ID].ParentID.String; got != archive.ID {
+		t.Errorf("nested directory parent = %s, want %s", got, archive.ID)
+	}
+}
+
+func TestRenameInvalidName(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	moves, root := newTestMoveService(t, f)
+	tree := newMoveTree(t, f, root)
+	f.failOn("UpdateDirectoryName", func() error { t.Error("directory name updated"); return nil })
+	f.failOn("UpdateFileName", func() error { t.Error("file name updated"); return nil })
+
+	for _, name := range []string{"", "a/b", `a\b`, "/", ".", ".."} {
+		_, dirErr := moves.dirs.Rename(ctx, tree.root.UserID, tree.docs.ID, name)
+		_, fileErr := moves.files.Rename(ctx, tree.root.UserID, tree.a.ID, name)
+
+		var errs [2]*app.WrappedSafeError
+		for i, err := range []error{dirErr, fileErr} {
+			if !errors.As(err, &errs[i]) || errs[i].Field() != "name" {
+				t.Fatalf("Rename(%q) error = %v, want a app.WrappedSafeError of the name field", name, err)
+			}
+		}
+
+		dirMsg, dirStatus := errs[0].Safe()
+		fileMsg, fileStatus := errs[1].Safe()
+		if dirStatus != http.StatusBadRequest || dirMsg != fileMsg || dirStatus != fileStatus {
+			t.Errorf("Rename(%q) = %d %q and %d %q, want the same 400 for both", name, dirStatus, dirMsg, fileStatus, fileMsg)
+		}
+	}
+
+	data := f.data()
+	if data.dirs[tree.docs.ID].Name != tree.docs.Name || data.files[tree.a.ID].Name != tree.a.Name {
+		t.Errorf("names = %s and %s, want them unchanged", data.dirs[tree.docs.ID].Name, data.files[tree.a.ID].Name)
+	}
+}
@@ -0,0 +1,378 @@
+package cloudstore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/pagination"
+)
+
+// EntryType is the type of a directory entry.
+type EntryType string
+
+const (
+	EntryDir  EntryType = "d"
+	EntryFile EntryType = "f"
+)
+
+// Name returns the name of the entry type as written in responses, "directory" or
+// "file".
+func (t EntryType) Name() string {
+	if t == EntryDir {
+		return "directory"
+	}
+
+	return "file"
+}
+
+// validateName returns a 400 app.WrappedSafeError of the "name" field if name cannot be
+// the new name of an entry of type t. A name must not be empty, "." or "..", or contain
+// a path separator, so it always names one entry of its directory.
+func validateName(t EntryType, name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid %s name [name: %s]", t.Name(), name),
+			SafeMessage: "Name cannot be empty or contain a path separator",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "name",
+		})
+	}
+
+	return nil
+}
+
+// Entry is a directory or file within a directory. Every listing of a directory,
+// regardless of who serves it, is built from Entry.
+type Entry struct {
+	Type EntryType
+	ID   string
+	Name string
+	Path string
+
+	// Size is the size of a file in bytes. It is nil for directories, and for files
+	// uploaded before sizes were recorded.
+	Size *int64
+
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+
+	// ClientModifiedAt is the modification time the client declared when uploading a
+	// file, or its upload time if none was declared. It is zero for directories.
+	ClientModifiedAt time.Time
+
+	// ContentMissing is true if the content of a file was not found on the file
+	// system. The file is listed, but cannot be read.
+	ContentMissing bool
+}
+
+// EntrySort is the field entries are sorted by.
+type EntrySort string
+
+const (
+	SortName    EntrySort = "name"
+	SortCreated EntrySort = "created"
+)
+
+// EntryKey is the ordering key of an Entry. It is the value encoded in a pagination
+// cursor.
+type EntryKey struct {
+	// Sort is the sort the key was taken for. A cursor is only valid for the sort it
+	// was taken for.
+	Sort EntrySort `json:"s"`
+
+	Type EntryType `json:"t"`
+	Name string    `json:"n,omitempty"`
+	Time time.Time `json:"c,omitempty"`
+	ID   string    `json:"i"`
+}
+
+// Key returns the ordering key of e for sort.
+func (e Entry) Key(sort EntrySort) EntryKey {
+	if sort == SortCreated {
+		return EntryKey{Sort: sort, Type: e.Type, Time: e.CreatedAt, ID: e.ID}
+	}
+
+	return EntryKey{Sort: sort, Type: e.Type, Name: e.Name, ID: e.ID}
+}
+
+// ListOptions is the options when listing the entries of a directory.
+type ListOptions struct {
+	// Sort is the field the entries are sorted by. Directories are always listed
+	// before files. If not set, it will default to SortName.
+	Sort EntrySort
+
+	// Order is the direction of the sort.
+	Order pagination.Order
+
+	// Dirs and Files set which entry types are listed.
+	Dirs  bool
+	Files bool
+
+	// After is the ordering key of the last entry of the previous page. If nil,
+	// the first page is listed.
+	After *EntryKey
+
+	// Limit is the maximum number of entries listed.
+	Limit int
+}
+
+// ParseListOptions parses the "sort", "order", and "include" URL query parameters
+// into a ListOptions. Sort is "name" or "created", order is "asc" or "desc", and
+// include is a comma separated list of "dirs" and "files". By default, both types
+// are listed by name in ascending order.
+//
+// After and Limit are not set. All errors returned are a app.WrappedSafeError.
+func ParseListOptions(q url.Values) (ListOptions, error) {
+	opts := ListOptions{Sort: SortName, Order: pagination.Asc, Dirs: true, Files: true}
+
+	switch s := EntrySort(q.Get("sort")); s {
+	case "", SortName:
+	case SortCreated:
+		opts.Sort = s
+	default:
+		return ListOptions{}, listOptionError("sort", s, "Sort must be one of: name, created")
+	}
+
+	switch o := q.Get("order"); o {
+	case "", "asc":
+	case "desc":
+		opts.Order = pagination.Desc
+	default:
+		return ListOptions{}, listOptionError("order", o, "Order must be one of: asc, desc")
+	}
+
+	if include := q.Get("include"); include != "" {
+		opts.Dirs, opts.Files = false, false
+
+		for _, v := range strings.Split(include, ",") {
+			switch strings.TrimSpace(v) {
+			case "dirs":
+				opts.Dirs = true
+			case "files":
+				opts.Files = true
+			default:
+				return ListOptions{}, listOptionError("include", v, "Include must be one of: dirs, files")
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+func listOptionError[T ~string](name string, value T, safeMessage string) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("invalid %s: %q", name, value),
+		SafeMessage: safeMessage,
+		StatusCode:  http.StatusBadRequest,
+	})
+}
+
+// ListEntries lists the sub directories and files of a users directory as Entry. If
+// dirID is empty, it will default to the users root directory.
+//
+// ListEntries validates that a users root directory has been created. If it does not
+// exist it will create it.
+//
+// If the DirService has a ListingCache, listings are read from and written to it.
+func (s *DirService) ListEntries(ctx context.Context, userID string, dirID string, opts ListOptions) ([]Entry, error) {
+	root, err := s.ValidateUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if dirID == "" {
+		dirID = root.ID
+	}
+
+	if opts.Sort == "" {
+		opts.Sort = SortName
+	}
+
+	// Listings are cached per user, so a cached listing was already authorized.
+	cached, key, ok := s.listings.Get(ctx, userID, dirID, opts)
+	if ok {
+		return cached, nil
+	}
+
+	dir, err := s.read(ctx, userID, dirID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.store.SelectEntries(ctx, dir.ID, opts)
+	if err != nil {
+		return nil, fmt.Errorf("selecting entries [directory_id: %s]: %w", dir.ID, err)
+	}
+
+	entries := []Entry{}
+	for _, row := range rows {
+		e := Entry{
+			Type:       row.Type,
+			ID:         row.ID,
+			Name:       row.Name,
+			Path:       strings.TrimSuffix(dir.Path, "/") + "/" + row.Name,
+			CreatedAt:  row.CreatedAt,
+			ModifiedAt: row.ModifiedAt,
+
+			ContentMissing: row.ContentMissing,
+		}
+
+		if row.Size.Valid {
+			size := row.Size.Int64
+			e.Size = &size
+		}
+
+		if row.ClientModifiedAt.Valid {
+			e.ClientModifiedAt = row.ClientModifiedAt.Time.UTC()
+		}
+
+		entries = append(entries, e)
+	}
+
+	s.listings.Set(ctx, key, entries)
+
+	return entries, nil
+}
+
+// ListEntriesPage lists a page of the entries of a users directory. The entries are
+// listed with opts, as parsed by ParseListOptions, and the page with req, as parsed by
+// pagination.ParseRequest. Cursors are encoded and decoded with cursors, a cursor taken
+// for another Sort is rejected.
+//
+// Every handler that lists entries should use ListEntriesPage, so that listings are
+// paginated and filtered the same way.
+func (s *DirService) ListEntriesPage(ctx context.Context, cursors *pagination.Codec, userID string, dirID string, opts ListOptions, req pagination.Request) (pagination.Page[Entry], error) {
+	if opts.Sort == "" {
+		opts.Sort = SortName
+	}
+
+	after, err := decodeEntryCursor(cursors, req.Cursor, opts.Sort)
+	if err != nil {
+		return pagination.Page[Entry]{}, err
+	}
+	opts.After = after
+	opts.Limit = req.Limit + 1
+
+	entries, err := s.ListEntries(ctx, userID, dirID, opts)
+	if err != nil {
+		return pagination.Page[Entry]{}, err
+	}
+
+	return pagination.NewPage(cursors, entries, req.Limit, func(e Entry) any {
+		return e.Key(opts.Sort)
+	})
+}
+
+// ListEntriesWindow lists a window of at most limit entries of a users directory, either
+// after the "after" cursor or before the "before" cursor. If neither is set the first
+// window is listed, and if both are set before is ignored. Cursors are encoded and decoded
+// with cursors, a cursor taken for another Sort than the Sort of opts is rejected.
+//
+// A window before a cursor is listed in the reverse order and then reversed, so it holds
+// the entries directly before the first entry of the window the cursor was taken from.
+//
+// All cursor errors returned are a app.WrappedSafeError that wraps
+// pagination.ErrInvalidCursor or pagination.ErrExpiredCursor.
+func (s *DirService) ListEntriesWindow(ctx context.Context, cursors *pagination.Codec, userID string, dirID string, opts ListOptions, after string, before string, limit int) (pagination.Window[Entry], error) {
+	cursor, backward := after, false
+	if after == "" && before != "" {
+		cursor, backward = before, true
+	}
+
+	if opts.Sort == "" {
+		opts.Sort = SortName
+	}
+
+	key, err := decodeEntryCursor(cursors, cursor, opts.Sort)
+	if err != nil {
+		return pagination.Window[Entry]{}, err
+	}
+	hasCursor := key != nil
+	opts.After = key
+	if backward {
+		opts.Order = reverseOrder(opts.Order)
+	}
+	opts.Limit = limit + 1
+
+	entries, err := s.ListEntries(ctx, userID, dirID, opts)
+	if err != nil {
+		return pagination.Window[Entry]{}, err
+	}
+
+	more := len(entries) > limit
+	if more {
+		entries = entries[:limit]
+	}
+
+	if backward {
+		reversed := make([]Entry, len(entries))
+		for i, e := range entries {
+			reversed[len(entries)-1-i] = e
+		}
+		entries = reversed
+	}
+
+	window := pagination.Window[Entry]{Items: entries}
+	if len(entries) == 0 {
+		return window, nil
+	}
+
+	// A window listed after a cursor always has a window before it, and a window listed
+	// before a cursor always has the window the cursor was taken from after it.
+	hasPrev, hasNext := hasCursor, more
+	if backward {
+		hasPrev, hasNext = more, true
+	}
+
+	if hasPrev {
+		window.PrevCursor, err = cursors.Encode(entries[0].Key(opts.Sort))
+		if err != nil {
+			return pagination.Window[Entry]{}, err
+		}
+	}
+
+	if hasNext {
+		window.NextCursor, err = cursors.Encode(entries[len(entries)-1].Key(opts.Sort))
+		if err != nil {
+			return pagination.Window[Entry]{}, err
+		}
+	}
+
+	return window, nil
+}
+
+// decodeEntryCursor decodes cursor into an EntryKey of sort. If cursor is empty, nil is
+// returned. A cursor taken for another sort is rejected as a pagination.ErrInvalidCursor.
+//
+// All errors returned are a app.WrappedSafeError.
+func decodeEntryCursor(cursors *pagination.Codec, cursor string, sort EntrySort) (*EntryKey, error) {
+	var key EntryKey
+	hasCursor, err := pagination.Request{Cursor: cursor}.DecodeCursor(cursors, &key)
+	if err != nil || !hasCursor {
+		return nil, err
+	}
+
+	if key.Sort != sort {
+		return nil, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("%w: cursor of sort %q used with sort %q", pagination.ErrInvalidCursor, key.Sort, sort),
+			SafeMessage: "Cursor does not match the sort",
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	return &key, nil
+}
+
+// reverseOrder returns the opposite of order.
+func reverseOrder(order pagination.Order) pagination.Order {
+	if order == pagination.Desc {
+		return pagination.Asc
+	}
+
+	return pagination.Desc
+}
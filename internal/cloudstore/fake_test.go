@@ -0,0 +1,1510 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/pagination"
+)
+
+// fakeStorage is an in-memory Storage. Its methods read and write the directories and
+// files it holds in maps. Tx snapshots the maps and restores them if the transaction
+// is rolled back.
+//
+// The unique and foreign key constraints of directories and files are enforced the
+// same as Postgres, and return the same errors as Query. Other errors are injected with
+// failOn, fail, and failCommit.
+type fakeStorage struct {
+	mu sync.Mutex
+	fakeData
+
+	// hooks run before the methods they are keyed by.
+	hooks map[string][]func() error
+
+	// commitErr is the error every commit fails with.
+	commitErr error
+
+	// snapshots are the snapshots of the open transactions.
+	snapshots map[*fakeData]struct{}
+}
+
+var _ Storage = (*fakeStorage)(nil)
+
+// fakeData is the data of a fakeStorage. It is copied when a transaction begins and
+// restored if the transaction is rolled back.
+type fakeData struct {
+	dirs   map[string]DirectoryRow
+	files  map[string]FileRow
+	states map[string]StorageState
+
+	// sizes are the sizes of the files that have one, by file ID.
+	sizes map[string]int64
+
+	// statuses are the statuses of the files that are not FileReady, by file ID.
+	statuses map[string]FileStatus
+
+	// missing are the files whose content is missing, by file ID.
+	missing map[string]bool
+
+	// layouts are the layouts of the directories that are not LayoutFlat, by ID.
+	layouts map[string]Layout
+
+	// uploadDirs are the default upload directories, by user ID.
+	uploadDirs map[string]string
+
+	// events are the types of the events inserted in the outbox, and payloads their
+	// payloads.
+	events   []string
+	payloads []any
+
+	cleanup []FSCleanupRow
+
+	// checkpoints are the backfill checkpoints, by name.
+	checkpoints map[string]BackfillCheckpointRow
+}
+
+func (d fakeData) copy() fakeData {
+	return fakeData{
+		dirs:       copyMap(d.dirs),
+		files:      copyMap(d.files),
+		states:     copyMap(d.states),
+		sizes:      copyMap(d.sizes),
+		statuses:   copyMap(d.statuses),
+		missing:    copyMap(d.missing),
+		layouts:    copyMap(d.layouts),
+		uploadDirs: copyMap(d.uploadDirs),
+		events:     append([]string(nil), d.events...),
+		payloads:   append([]any(nil), d.payloads...),
+		cleanup:    append([]FSCleanupRow(nil), d.cleanup...),
+
+		checkpoints: copyMap(d.checkpoints),
+	}
+}
+
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
+
+// newFakeStorage creates an empty fakeStorage.
+func newFakeStorage(t *testing.T) *fakeStorage {
+	t.Helper()
+
+	return &fakeStorage{
+		fakeData:  fakeData{}.copy(),
+		hooks:     map[string][]func() error{},
+		snapshots: map[*fakeData]struct{}{},
+	}
+}
+
+// failOn calls fn before every call of the Storage or Tx method. If fn returns an error,
+// the method fails with it. fn may change the data, for example to act as a concurrent
+// request, see concurrent.
+func (f *fakeStorage) failOn(method string, fn func() error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.hooks[method] = append(f.hooks[method], fn)
+}
+
+// fail makes the Storage or Tx method fail with err.
+func (f *fakeStorage) fail(method string, err error) {
+	f.failOn(method, func() error { return err })
+}
+
+// clearFailures removes the hooks of every method and the commit error, as if the
+// failures they injected were resolved.
+func (f *fakeStorage) clearFailures() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.hooks = map[string][]func() error{}
+	f.commitErr = nil
+}
+
+// failCommit makes every commit fail with err.
+func (f *fakeStorage) failCommit(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.commitErr = err
+}
+
+// call runs the hooks of the method. It must be called without f.mu held, the hooks may
+// take it.
+func (f *fakeStorage) call(method string) error {
+	f.mu.Lock()
+	hooks := append([]func() error(nil), f.hooks[method]...)
+	f.mu.Unlock()
+
+	for _, fn := range hooks {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addDir adds the directory row.
+func (f *fakeStorage) addDir(row DirectoryRow) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.dirs[row.ID] = row
+}
+
+// addFile adds the file row.
+func (f *fakeStorage) addFile(row FileRow) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.files[row.ID] = row
+}
+
+// setSize sets the size of the file id.
+func (f *fakeStorage) setSize(id string, size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sizes[id] = size
+}
+
+// concurrent calls fn with the data and the snapshot of every open transaction, as if
+// fn was committed by a concurrent request. Unlike changes made by a transaction, they
+// are kept when it rolls back.
+func (f *fakeStorage) concurrent(fn func(d *fakeData)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fn(&f.fakeData)
+	for s := range f.snapshots {
+		fn(s)
+	}
+}
+
+// data returns a copy of the data.
+func (f *fakeStorage) data() fakeData {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.fakeData.copy()
+}
+
+// Tx runs txFunc with a fakeTx. The data written by txFunc is kept only if txFunc
+// succeeds and the commit does not fail. Like Store.Tx, a failed commit is returned as
+// a ErrCommitTx, and a ErrLockBusy as a 409 app.WrappedSafeError.
+func (f *fakeStorage) Tx(ctx context.Context, txFunc func(tx Tx) error) error {
+	f.mu.Lock()
+	snapshot := f.fakeData.copy()
+	f.snapshots[&snapshot] = struct{}{}
+	f.mu.Unlock()
+
+	err := txFunc(&fakeTx{fakeStorage: f, savepoints: map[string]fakeData{}})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil && f.commitErr != nil {
+		err = fmt.Errorf("%w: %v", ErrCommitTx, f.commitErr)
+	}
+
+	if err != nil {
+		f.fakeData = snapshot
+	}
+	delete(f.snapshots, &snapshot)
+
+	return lockBusy(err)
+}
+
+func (f *fakeStorage) ProbeDB(ctx context.Context) app.Probe {
+	return app.RunProbe("db_query", func() error { return f.call("ProbeDB") })
+}
+
+// ancestors returns the directory id and its ancestors, the directory first. f.mu must be
+// held.
+func (f *fakeStorage) ancestors(id string) []DirectoryRow {
+	dirs := []DirectoryRow{}
+	for d, ok := f.dirs[id]; ok; d, ok = f.dirs[d.ParentID.String] {
+		dirs = append(dirs, d)
+		if !d.ParentID.Valid {
+			break
+		}
+	}
+
+	return dirs
+}
+
+// descendants returns the IDs of the directory id and every directory under it, by
+// depth. f.mu must be held.
+func (f *fakeStorage) descendants(id string) []string {
+	if _, ok := f.dirs[id]; !ok {
+		return nil
+	}
+
+	ids := []string{id}
+	for n := 0; n < len(ids); n++ {
+		children := []string{}
+		for _, d := range f.dirs {
+			if d.ParentID.String == ids[n] {
+				children = append(children, d.ID)
+			}
+		}
+		sort.Strings(children)
+		ids = append(ids, children...)
+	}
+
+	return ids
+}
+
+// dirNameTaken reports if a directory other than id is named name under parentID. f.mu
+// must be held.
+func (f *fakeStorage) dirNameTaken(id string, parentID string, name string) bool {
+	for _, d := range f.dirs {
+		if d.ID != id && d.ParentID.Valid && d.ParentID.String == parentID && d.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fileNameTaken reports if a file other than id is named name in the directory dirID.
+// f.mu must be held.
+func (f *fakeStorage) fileNameTaken(id string, dirID string, name string) bool {
+	for _, file := range f.files {
+		if file.ID != id && file.DirectoryID == dirID && file.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *fakeStorage) InsertDirectory(ctx context.Context, c InsertDirectoryConfig) (time.Time, error) {
+	if err := f.call("InsertDirectory"); err != nil {
+		return time.Time{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if c.CreatedVia == "" {
+		c.CreatedVia = CreatedExplicit
+	}
+
+	row := DirectoryRow{
+		ID:         c.ID,
+		UserID:     c.UserID,
+		Name:       c.Name,
+		ParentID:   c.ParentID,
+		CreatedAt:  time.Now().UTC(),
+		CreatedVia: c.CreatedVia,
+	}
+
+	if !row.ParentID.Valid {
+		for _, d := range f.dirs {
+			if !d.ParentID.Valid && d.UserID == row.UserID {
+				return time.Time{}, fmt.Errorf("%w: fake storage", ErrUniqueUserRoot)
+			}
+		}
+	} else {
+		if _, ok := f.dirs[row.ParentID.String]; !ok {
+			return time.Time{}, fmt.Errorf("%w: fake storage", ErrForeignKeyParentID)
+		}
+
+		if f.dirNameTaken(row.ID, row.ParentID.String, row.Name) {
+			return time.Time{}, fmt.Errorf("%w: fake storage", ErrUniqueNameParentID)
+		}
+	}
+
+	f.dirs[row.ID] = row
+	if c.Layout != 0 && c.Layout != LayoutFlat {
+		f.layouts[row.ID] = c.Layout
+	}
+
+	return row.CreatedAt, nil
+}
+
+// InsertSelfPath and InsertParentPaths do nothing, the paths of a directory are derived
+// from the parent IDs.
+func (f *fakeStorage) InsertSelfPath(ctx context.Context, directoryID string) error {
+	return f.call("InsertSelfPath")
+}
+
+func (f *fakeStorage) InsertParentPaths(ctx context.Context, c InsertParentPathsConfig) error {
+	return f.call("InsertParentPaths")
+}
+
+func (f *fakeStorage) SelectIsDescendant(ctx context.Context, ancestorID string, id string) (bool, error) {
+	if err := f.call("SelectIsDescendant"); err != nil {
+		return false, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, d := range f.ancestors(id) {
+		if d.ID == ancestorID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (f *fakeStorage) MoveSubtreePaths(ctx context.Context, id string, parentID string) error {
+	return f.call("MoveSubtreePaths")
+}
+
+func (f *fakeStorage) SelectDirectoryFSPath(ctx context.Context, directoryID string) ([]string, error) {
+	if err := f.call("SelectDirectoryFSPath"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var ids []string
+	for _, d := range f.ancestors(directoryID) {
+		ids = append([]string{d.ID}, ids...)
+	}
+
+	return ids, nil
+}
+
+func (f *fakeStorage) SelectDirectoryPath(ctx context.Context, directoryID string) ([]string, error) {
+	if err := f.call("SelectDirectoryPath"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var names []string
+	for _, d := range f.ancestors(directoryID) {
+		names = append([]string{d.Name}, names...)
+	}
+
+	return names, nil
+}
+
+func (f *fakeStorage) SelectDirectoryLayout(ctx context.Context, directoryID string) (Layout, error) {
+	if err := f.call("SelectDirectoryLayout"); err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.dirs[directoryID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	if layout, ok := f.layouts[directoryID]; ok {
+		return layout, nil
+	}
+
+	return LayoutFlat, nil
+}
+
+func (f *fakeStorage) UpdateDirectoryLayout(ctx context.Context, directoryID string, layout Layout) error {
+	if err := f.call("UpdateDirectoryLayout"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.layouts[directoryID] = layout
+	return nil
+}
+
+func (f *fakeStorage) SelectDirectoryByIDUser(ctx context.Context, id string, userID string) (DirectoryRow, error) {
+	if err := f.call("SelectDirectoryByIDUser"); err != nil {
+		return DirectoryRow{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	row, ok := f.dirs[id]
+	if !ok || row.UserID != userID {
+		return DirectoryRow{}, sql.ErrNoRows
+	}
+
+	return row, nil
+}
+
+func (f *fakeStorage) SelectDirectoryByUserNameParent(ctx context.Context, userID string, name string, parentID string) (DirectoryRow, error) {
+	if err := f.call("SelectDirectoryByUserNameParent"); err != nil {
+		return DirectoryRow{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, row := range f.dirs {
+		if row.UserID == userID && row.Name == name && row.ParentID.String == parentID {
+			return row, nil
+		}
+	}
+
+	return DirectoryRow{}, sql.ErrNoRows
+}
+
+func (f *fakeStorage) SelectUserRootDirectory(ctx context.Context, userID string) (DirectoryRow, error) {
+	if err := f.call("SelectUserRootDirectory"); err != nil {
+		return DirectoryRow{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, row := range f.dirs {
+		if row.UserID == userID && !row.ParentID.Valid {
+			return row, nil
+		}
+	}
+
+	return DirectoryRow{}, sql.ErrNoRows
+}
+
+func (f *fakeStorage) SelectDescendantDirectoryIDs(ctx context.Context, directoryID string) ([]string, error) {
+	if err := f.call("SelectDescendantDirectoryIDs"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.descendants(directoryID), nil
+}
+
+func (f *fakeStorage) UpdateDirectoryName(ctx context.Context, id string, userID string, name string) error {
+	if err := f.call("UpdateDirectoryName"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dir, ok := f.dirs[id]
+	if !ok || dir.UserID != userID {
+		return sql.ErrNoRows
+	}
+
+	if dir.ParentID.Valid && f.dirNameTaken(id, dir.ParentID.String, name) {
+		return fmt.Errorf("%w: fake storage", ErrUniqueNameParentID)
+	}
+
+	dir.Name = name
+	dir.UpdatedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	f.dirs[id] = dir
+	return nil
+}
+
+func (f *fakeStorage) UpdateDirectoryParent(ctx context.Context, id string, userID string, parentID string) error {
+	if err := f.call("UpdateDirectoryParent"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dir, ok := f.dirs[id]
+	if !ok || dir.UserID != userID {
+		return sql.ErrNoRows
+	}
+
+	if f.dirNameTaken(id, parentID, dir.Name) {
+		return fmt.Errorf("%w: fake storage", ErrUniqueNameParentID)
+	}
+
+	dir.ParentID = sql.NullString{String: parentID, Valid: true}
+	dir.UpdatedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	f.dirs[id] = dir
+	return nil
+}
+
+func (f *fakeStorage) UpdateLastWrite(ctx context.Context, directoryID string) ([]string, error) {
+	if err := f.call("UpdateLastWrite"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	ids := []string{}
+	for _, d := range f.ancestors(directoryID) {
+		d.LastWrite = now
+		f.dirs[d.ID] = d
+		ids = append(ids, d.ID)
+	}
+
+	return ids, nil
+}
+
+// DeleteDirectories deletes the directories. Like the foreign key of the files table,
+// it fails if a file is left in a deleted directory.
+func (f *fakeStorage) DeleteDirectories(ctx context.Context, ids []string) error {
+	if err := f.call("DeleteDirectories"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deleted := map[string]bool{}
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	for _, file := range f.files {
+		if deleted[file.DirectoryID] {
+			return fmt.Errorf("fake storage: file %s is in deleted directory %s", file.ID, file.DirectoryID)
+		}
+	}
+
+	for _, id := range ids {
+		delete(f.dirs, id)
+		delete(f.layouts, id)
+	}
+
+	return nil
+}
+
+func (f *fakeStorage) SelectChildVersions(ctx context.Context, directoryID string) ([]ChildVersionRow, error) {
+	if err := f.call("SelectChildVersions"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := []ChildVersionRow{}
+	for _, d := range f.dirs {
+		if d.ParentID.String == directoryID {
+			version := d.CreatedAt
+			if d.LastWrite.Valid {
+				version = d.LastWrite.Time
+			}
+
+			rows = append(rows, ChildVersionRow{Type: string(EntryDir), ID: d.ID, Name: d.Name, Version: version})
+		}
+	}
+	for _, file := range f.files {
+		if file.DirectoryID == directoryID && f.status(file.ID) == FileReady {
+			rows = append(rows, ChildVersionRow{Type: string(EntryFile), ID: file.ID, Name: file.Name, Version: file.UploadedAt})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Type != rows[j].Type {
+			return rows[i].Type < rows[j].Type
+		}
+
+		return rows[i].ID < rows[j].ID
+	})
+
+	return rows, nil
+}
+
+// SelectEntries orders the entries the same as Query.SelectEntries: by type, the sort
+// field, and ID in the direction of opts.Order, starting after opts.After.
+func (f *fakeStorage) SelectEntries(ctx context.Context, directoryID string, opts ListOptions) ([]EntryRow, error) {
+	if err := f.call("SelectEntries"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries := []EntryRow{}
+	if opts.Dirs {
+		for _, d := range f.dirs {
+			if d.ParentID.String != directoryID {
+				continue
+			}
+
+			modified := d.CreatedAt
+			if d.LastWrite.Valid {
+				modified = d.LastWrite.Time
+			}
+
+			entries = append(entries, EntryRow{Type: EntryDir, ID: d.ID, Name: d.Name, CreatedAt: d.CreatedAt, ModifiedAt: modified})
+		}
+	}
+	if opts.Files {
+		for _, file := range f.files {
+			if file.DirectoryID != directoryID || f.status(file.ID) != FileReady {
+				continue
+			}
+
+			e := EntryRow{
+				Type:             EntryFile,
+				ID:               file.ID,
+				Name:             file.Name,
+				CreatedAt:        file.UploadedAt,
+				ModifiedAt:       file.UploadedAt,
+				ClientModifiedAt: sql.NullTime{Time: file.ClientModifiedAt, Valid: true},
+				ContentMissing:   f.missing[file.ID],
+			}
+			if size, ok := f.sizes[file.ID]; ok {
+				e.Size = sql.NullInt64{Int64: size, Valid: true}
+			}
+
+			entries = append(entries, e)
+		}
+	}
+
+	// compare returns the order of the keys of a and b in ascending order.
+	compare := func(a EntryKey, b EntryKey) int {
+		switch {
+		case a.Type != b.Type:
+			return strings.Compare(string(a.Type), string(b.Type))
+		case opts.Sort == SortCreated && !a.Time.Equal(b.Time):
+			return a.Time.Compare(b.Time)
+		case opts.Sort != SortCreated && a.Name != b.Name:
+			return strings.Compare(a.Name, b.Name)
+		}
+
+		return strings.Compare(a.ID, b.ID)
+	}
+
+	key := func(e EntryRow) EntryKey {
+		return EntryKey{Type: e.Type, Name: e.Name, Time: e.CreatedAt, ID: e.ID}
+	}
+
+	if opts.Order == pagination.Desc {
+		ascending := compare
+		compare = func(a EntryKey, b EntryKey) int { return ascending(b, a) }
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return compare(key(entries[i]), key(entries[j])) < 0 })
+
+	page := []EntryRow{}
+	for _, e := range entries {
+		if opts.After != nil && compare(key(e), *opts.After) <= 0 {
+			continue
+		}
+
+		if len(page) == opts.Limit {
+			break
+		}
+
+		page = append(page, e)
+	}
+
+	return page, nil
+}
+
+// status returns the status of the file id. f.mu must be held.
+func (f *fakeStorage) status(id string) FileStatus {
+	if status, ok := f.statuses[id]; ok {
+		return status
+	}
+
+	return FileReady
+}
+
+func (f *fakeStorage) InsertFile(ctx context.Context, c InsertFileConfig) (InsertedFile, error) {
+	if err := f.call("InsertFile"); err != nil {
+		return InsertedFile{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.dirs[c.DirectoryID]; !ok {
+		return InsertedFile{}, fmt.Errorf("%w: fake storage", ErrForeignKeyDirectoryID)
+	}
+
+	if f.fileNameTaken(c.ID, c.DirectoryID, c.Name) {
+		return InsertedFile{}, fmt.Errorf("%w: fake storage", ErrUniqueDirectoryIDName)
+	}
+
+	now := time.Now().UTC()
+	inserted := InsertedFile{UploadedAt: now, ClientModifiedAt: now}
+	if !c.ClientModifiedAt.IsZero() {
+		inserted.ClientModifiedAt = c.ClientModifiedAt.UTC()
+	}
+
+	f.files[c.ID] = FileRow{
+		ID:               c.ID,
+		UserID:           c.UserID,
+		DirectoryID:      c.DirectoryID,
+		Name:             c.Name,
+		UploadedAt:       inserted.UploadedAt,
+		ClientModifiedAt: inserted.ClientModifiedAt,
+	}
+	f.sizes[c.ID] = c.Size
+	if c.Status != "" && c.Status != FileReady {
+		f.statuses[c.ID] = c.Status
+	}
+
+	return inserted, nil
+}
+
+func (f *fakeStorage) SelectFileByID(ctx context.Context, id string) (FileRow, error) {
+	if err := f.call("SelectFileByID"); err != nil {
+		return FileRow{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	row, ok := f.files[id]
+	if !ok || f.status(id) != FileReady {
+		return FileRow{}, sql.ErrNoRows
+	}
+
+	return row, nil
+}
+
+func (f *fakeStorage) SelectFileByIDUser(ctx context.Context, id string, userID string) (FileRow, error) {
+	if err := f.call("SelectFileByIDUser"); err != nil {
+		return FileRow{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	row, ok := f.files[id]
+	if !ok || row.UserID != userID || f.status(id) != FileReady {
+		return FileRow{}, sql.ErrNoRows
+	}
+
+	return row, nil
+}
+
+func (f *fakeStorage) SelectFileByUserDirName(ctx context.Context, userID string, dirID string, name string) (FileRow, error) {
+	if err := f.call("SelectFileByUserDirName"); err != nil {
+		return FileRow{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, file := range f.files {
+		if file.UserID == userID && file.DirectoryID == dirID && file.Name == name && f.status(file.ID) == FileReady {
+			return file, nil
+		}
+	}
+
+	return FileRow{}, sql.ErrNoRows
+}
+
+func (f *fakeStorage) SelectFileIDsByDirectory(ctx context.Context, directoryID string) ([]string, error) {
+	if err := f.call("SelectFileIDsByDirectory"); err != nil {
+		return nil, err
+	}
+
+	return f.fileIDsIn([]string{directoryID}), nil
+}
+
+func (f *fakeStorage) SelectFileIDsInDirectories(ctx context.Context, directoryIDs []string) ([]string, error) {
+	if err := f.call("SelectFileIDsInDirectories"); err != nil {
+		return nil, err
+	}
+
+	return f.fileIDsIn(directoryIDs), nil
+}
+
+// fileIDsIn returns the sorted IDs of the files in the directories.
+func (f *fakeStorage) fileIDsIn(directoryIDs []string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	in := map[string]bool{}
+	for _, id := range directoryIDs {
+		in[id] = true
+	}
+
+	ids := []string{}
+	for _, file := range f.files {
+		if in[file.DirectoryID] {
+			ids = append(ids, file.ID)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (f *fakeStorage) SelectFilesSize(ctx context.Context, ids []string) (int64, error) {
+	if err := f.call("SelectFilesSize"); err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var size int64
+	for _, id := range ids {
+		size += f.sizes[id]
+	}
+
+	return size, nil
+}
+
+func (f *fakeStorage) UpdateFileName(ctx context.Context, id string, userID string, name string) error {
+	if err := f.call("UpdateFileName"); err != nil {
+		return err
+	}
+
+	return f.updateFile(id, userID, func(file *FileRow) { file.Name = name })
+}
+
+func (f *fakeStorage) UpdateFileDirectory(ctx context.Context, id string, userID string, directoryID string) error {
+	if err := f.call("UpdateFileDirectory"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	_, ok := f.dirs[directoryID]
+	f.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("fake storage: directory %s does not exist", directoryID)
+	}
+
+	return f.updateFile(id, userID, func(file *FileRow) { file.DirectoryID = directoryID })
+}
+
+// updateFile applies update to the file id of the user, enforcing the unique name of
+// the files in a directory.
+func (f *fakeStorage) updateFile(id string, userID string, update func(file *FileRow)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, ok := f.files[id]
+	if !ok || file.UserID != userID {
+		return sql.ErrNoRows
+	}
+
+	update(&file)
+	if f.fileNameTaken(file.ID, file.DirectoryID, file.Name) {
+		return fmt.Errorf("%w: fake storage", ErrUniqueDirectoryIDName)
+	}
+
+	f.files[id] = file
+	return nil
+}
+
+func (f *fakeStorage) UpdateFileContent(ctx context.Context, id string, c FileContent) error {
+	if err := f.call("UpdateFileContent"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, ok := f.files[id]
+	if !ok {
+		return nil
+	}
+
+	file.Checksum = sql.NullString{String: c.Checksum, Valid: true}
+	file.ContentType = sql.NullString{String: c.ContentType, Valid: true}
+	f.files[id] = file
+	f.sizes[id] = c.Size
+	delete(f.missing, id)
+
+	return nil
+}
+
+func (f *fakeStorage) UpdateFileContentMissing(ctx context.Context, id string, missing bool) error {
+	if err := f.call("UpdateFileContentMissing"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if missing {
+		f.missing[id] = true
+	} else {
+		delete(f.missing, id)
+	}
+
+	return nil
+}
+
+func (f *fakeStorage) UpdateFileStatus(ctx context.Context, id string, status FileStatus) error {
+	if err := f.call("UpdateFileStatus"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.files[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	if status == FileReady {
+		delete(f.statuses, id)
+	} else {
+		f.statuses[id] = status
+	}
+
+	return nil
+}
+
+func (f *fakeStorage) DeleteFiles(ctx context.Context, ids []string) (int64, error) {
+	if err := f.call("DeleteFiles"); err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var deleted int64
+	for _, id := range ids {
+		if _, ok := f.files[id]; ok {
+			deleted++
+		}
+
+		delete(f.files, id)
+		delete(f.sizes, id)
+		delete(f.statuses, id)
+		delete(f.missing, id)
+	}
+
+	return deleted, nil
+}
+
+// SearchFiles matches the names of the files, ignoring case. Content search is not
+// supported, it matches nothing.
+func (f *fakeStorage) SearchFiles(ctx context.Context, c SearchFilesConfig) ([]SearchRow, error) {
+	if err := f.call("SearchFiles"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := []SearchRow{}
+	if c.Content {
+		return rows, nil
+	}
+
+	for _, file := range f.files {
+		if file.UserID != c.UserID || f.status(file.ID) != FileReady {
+			continue
+		}
+
+		if strings.Contains(strings.ToLower(file.Name), strings.ToLower(c.Query)) {
+			rows = append(rows, SearchRow{
+				ID:          file.ID,
+				DirectoryID: file.DirectoryID,
+				Name:        file.Name,
+				Size:        f.sizes[file.ID],
+				UploadedAt:  file.UploadedAt,
+			})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Name != rows[j].Name {
+			return rows[i].Name < rows[j].Name
+		}
+
+		return rows[i].ID < rows[j].ID
+	})
+
+	if len(rows) > c.Limit {
+		rows = rows[:c.Limit]
+	}
+
+	return rows, nil
+}
+
+func (f *fakeStorage) SelectPendingFiles(ctx context.Context, before time.Time, limit int) ([]PendingFileRow, error) {
+	if err := f.call("SelectPendingFiles"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := []PendingFileRow{}
+	for _, file := range f.files {
+		if f.status(file.ID) == FilePending && file.UploadedAt.Before(before) {
+			rows = append(rows, PendingFileRow{
+				ID:          file.ID,
+				DirectoryID: file.DirectoryID,
+				Size:        f.sizes[file.ID],
+				UploadedAt:  file.UploadedAt,
+			})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].UploadedAt.Before(rows[j].UploadedAt) })
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	return rows, nil
+}
+
+func (f *fakeStorage) DeletePendingFile(ctx context.Context, id string) (bool, error) {
+	if err := f.call("DeletePendingFile"); err != nil {
+		return false, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.files[id]; !ok || f.status(id) != FilePending {
+		return false, nil
+	}
+
+	delete(f.files, id)
+	delete(f.sizes, id)
+	delete(f.statuses, id)
+
+	return true, nil
+}
+
+// SelectDefaultUploadDir returns a NULL directory if the default upload directory was
+// deleted, like the ON DELETE SET NULL foreign key of the users table.
+func (f *fakeStorage) SelectDefaultUploadDir(ctx context.Context, userID string) (sql.NullString, error) {
+	if err := f.call("SelectDefaultUploadDir"); err != nil {
+		return sql.NullString{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id, ok := f.uploadDirs[userID]
+	if _, exists := f.dirs[id]; !ok || !exists {
+		return sql.NullString{}, nil
+	}
+
+	return sql.NullString{String: id, Valid: true}, nil
+}
+
+func (f *fakeStorage) UpdateDefaultUploadDir(ctx context.Context, userID string, dirID sql.NullString) error {
+	if err := f.call("UpdateDefaultUploadDir"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if dirID.Valid {
+		f.uploadDirs[userID] = dirID.String
+	} else {
+		delete(f.uploadDirs, userID)
+	}
+
+	return nil
+}
+
+func (f *fakeStorage) SelectStorageState(ctx context.Context, userID string) (StorageState, error) {
+	if err := f.call("SelectStorageState"); err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state, ok := f.states[userID]
+	if !ok {
+		return StoragePending, nil
+	}
+
+	return state, nil
+}
+
+func (f *fakeStorage) UpdateStorageState(ctx context.Context, userID string, state StorageState) error {
+	if err := f.call("UpdateStorageState"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.states[userID] = state
+	return nil
+}
+
+func (f *fakeStorage) SelectStorageUsed(ctx context.Context, userID string) (int64, error) {
+	if err := f.call("SelectStorageUsed"); err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var used int64
+	for _, file := range f.files {
+		if file.UserID == userID {
+			used += f.sizes[file.ID]
+		}
+	}
+
+	return used, nil
+}
+
+func (f *fakeStorage) SelectCheckDirectories(ctx context.Context, userID string) ([]CheckDirRow, error) {
+	if err := f.call("SelectCheckDirectories"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := []CheckDirRow{}
+	for _, d := range f.dirs {
+		if d.UserID != userID {
+			continue
+		}
+
+		row := CheckDirRow{ID: d.ID, ParentID: d.ParentID, Layout: LayoutFlat, CreatedVia: d.CreatedVia}
+		if layout, ok := f.layouts[d.ID]; ok {
+			row.Layout = layout
+		}
+		for _, a := range f.ancestors(d.ID) {
+			row.IDPath = append([]string{a.ID}, row.IDPath...)
+		}
+
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if len(rows[i].IDPath) != len(rows[j].IDPath) {
+			return len(rows[i].IDPath) < len(rows[j].IDPath)
+		}
+
+		return rows[i].ID < rows[j].ID
+	})
+
+	return rows, nil
+}
+
+// SelectForeignPaths returns no rows, the paths of the fake are derived from the parent
+// IDs and cannot link the directories of two users.
+func (f *fakeStorage) SelectForeignPaths(ctx context.Context, userID string) ([]ForeignPathRow, error) {
+	return []ForeignPathRow{}, f.call("SelectForeignPaths")
+}
+
+func (f *fakeStorage) SelectCheckFiles(ctx context.Context, userID string) ([]CheckFileRow, error) {
+	if err := f.call("SelectCheckFiles"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := []CheckFileRow{}
+	for _, file := range f.files {
+		if file.UserID != userID {
+			continue
+		}
+
+		row := CheckFileRow{ID: file.ID, DirectoryID: file.DirectoryID, Status: f.status(file.ID), ContentMissing: f.missing[file.ID]}
+		if size, ok := f.sizes[file.ID]; ok {
+			row.Size = sql.NullInt64{Int64: size, Valid: true}
+		}
+
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+	return rows, nil
+}
+
+func (f *fakeStorage) SelectUploadsPerDay(ctx context.Context, userID string, since time.Time, timeZone string) (map[string]int, error) {
+	if err := f.call("SelectUploadsPerDay"); err != nil {
+		return nil, err
+	}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	days := map[string]int{}
+	for _, file := range f.files {
+		if file.UserID == userID && f.status(file.ID) == FileReady && !file.UploadedAt.Before(since) {
+			days[file.UploadedAt.In(loc).Format(time.DateOnly)]++
+		}
+	}
+
+	return days, nil
+}
+
+func (f *fakeStorage) UpsertFSCleanup(ctx context.Context, r FSCleanupRow) error {
+	if err := f.call("UpsertFSCleanup"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, c := range f.cleanup {
+		if c.Path == r.Path {
+			c.Attempts += r.Attempts
+			c.LastError = r.LastError
+			c.UpdatedAt = r.UpdatedAt
+			f.cleanup[i] = c
+			return nil
+		}
+	}
+
+	f.cleanup = append(f.cleanup, r)
+	return nil
+}
+
+func (f *fakeStorage) SelectFSCleanup(ctx context.Context, limit int) ([]FSCleanupRow, error) {
+	if err := f.call("SelectFSCleanup"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := append([]FSCleanupRow{}, f.cleanup...)
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	return rows, nil
+}
+
+func (f *fakeStorage) DeleteFSCleanup(ctx context.Context, path string) error {
+	if err := f.call("DeleteFSCleanup"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := []FSCleanupRow{}
+	for _, r := range f.cleanup {
+		if r.Path != path {
+			rows = append(rows, r)
+		}
+	}
+	f.cleanup = rows
+
+	return nil
+}
+
+// SelectBackfillFiles selects the ready files in increasing ID order. A file with a
+// checksum is at CurrentFileVersion, any other file is at FileVersionBase.
+func (f *fakeStorage) SelectBackfillFiles(ctx context.Context, afterID string, limit int) ([]BackfillFileRow, error) {
+	if err := f.call("SelectBackfillFiles"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := []BackfillFileRow{}
+	for _, file := range f.files {
+		if file.ID <= afterID || f.status(file.ID) != FileReady {
+			continue
+		}
+
+		version := FileVersionBase
+		if file.Checksum.Valid {
+			version = CurrentFileVersion
+		}
+
+		rows = append(rows, BackfillFileRow{
+			ID:             file.ID,
+			DirectoryID:    file.DirectoryID,
+			Name:           file.Name,
+			Size:           f.sizes[file.ID],
+			ContentMissing: f.missing[file.ID],
+			RecordVersion:  version,
+		})
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	return rows, nil
+}
+
+// The tree statistics queries are not used by the tests of the fake, they return no
+// rows.
+
+func (f *fakeStorage) SelectFileVersionCounts(ctx context.Context) (map[RecordVersion]int64, error) {
+	return map[RecordVersion]int64{}, f.call("SelectFileVersionCounts")
+}
+
+func (f *fakeStorage) SelectBackfillCheckpoint(ctx context.Context, name string) (BackfillCheckpointRow, error) {
+	if err := f.call("SelectBackfillCheckpoint"); err != nil {
+		return BackfillCheckpointRow{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	row, ok := f.checkpoints[name]
+	if !ok {
+		return BackfillCheckpointRow{}, sql.ErrNoRows
+	}
+
+	return row, nil
+}
+
+func (f *fakeStorage) UpsertBackfillCheckpoint(ctx context.Context, r BackfillCheckpointRow) error {
+	if err := f.call("UpsertBackfillCheckpoint"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.checkpoints[r.Name] = r
+
+	return nil
+}
+
+func (f *fakeStorage) SelectUserTrees(ctx context.Context) ([]UserTreeRow, error) {
+	if err := f.call("SelectUserTrees"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// The paths rows of a directory are its own and one for each ancestor, so a
+	// directory at depth n has n+1 rows.
+	byUser := map[string]*UserTreeRow{}
+	depths := map[string]int{}
+	for id, dir := range f.dirs {
+		depth := len(f.ancestors(id)) - 1
+		depths[dir.UserID] += depth
+
+		row, ok := byUser[dir.UserID]
+		if !ok {
+			row = &UserTreeRow{UserID: dir.UserID}
+			byUser[dir.UserID] = row
+		}
+
+		row.Directories++
+		row.MaxDepth = max(row.MaxDepth, depth)
+		row.ClosureRows += int64(depth + 1)
+	}
+
+	trees := []UserTreeRow{}
+	for userID, row := range byUser {
+		row.AvgDepth = float64(depths[userID]) / float64(row.Directories)
+		trees = append(trees, *row)
+	}
+
+	return trees, nil
+}
+
+func (f *fakeStorage) InsertEvent(ctx context.Context, userID string, eventType string, payload any) error {
+	if err := f.call("InsertEvent"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = append(f.events, eventType)
+	f.payloads = append(f.payloads, payload)
+	return nil
+}
+
+// fakeTx is the Tx of a fakeStorage. Its queries read and write the data of the
+// fakeStorage directly, the changes are undone by fakeStorage.Tx on rollback.
+type fakeTx struct {
+	*fakeStorage
+
+	// savepoints are the data when each savepoint was set, by name.
+	savepoints map[string]fakeData
+}
+
+var _ Tx = (*fakeTx)(nil)
+
+// LockDirectories takes no lock, the tests run one transaction at a time. A hook on
+// "LockDirectories" can act as a request that held the lock.
+func (t *fakeTx) LockDirectories(ctx context.Context, ids ...string) error {
+	return t.call("LockDirectories")
+}
+
+func (t *fakeTx) LockSubtree(ctx context.Context, id string, ids ...string) ([]string, error) {
+	if err := t.call("LockSubtree"); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.descendants(id), nil
+}
+
+func (t *fakeTx) SelectFileByIDUserForUpdate(ctx context.Context, id string, userID string) (FileRow, error) {
+	if err := t.call("SelectFileByIDUserForUpdate"); err != nil {
+		return FileRow{}, err
+	}
+
+	return t.fakeStorage.SelectFileByIDUser(ctx, id, userID)
+}
+
+func (t *fakeTx) SelectDirectoryLayoutForUpdate(ctx context.Context, directoryID string) (Layout, error) {
+	if err := t.call("SelectDirectoryLayoutForUpdate"); err != nil {
+		return 0, err
+	}
+
+	return t.fakeStorage.SelectDirectoryLayout(ctx, directoryID)
+}
+
+func (t *fakeTx) Savepoint(ctx context.Context, name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.savepoints[name] = t.fakeData.copy()
+	return nil
+}
+
+func (t *fakeTx) RollbackToSavepoint(ctx context.Context, name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, ok := t.savepoints[name]
+	if !ok {
+		return errors.New("fake storage: savepoint does not exist")
+	}
+
+	t.fakeData = data
+	delete(t.savepoints, name)
+	return nil
+}
+
+func (t *fakeTx) ReleaseSavepoint(ctx context.Context, name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.savepoints, name)
+	return nil
+}
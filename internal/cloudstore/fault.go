@@ -0,0 +1,169 @@
+package cloudstore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// ErrInjectedFault signals a error was forced by Faults.
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultPoint is a named point where Faults can force a error.
+type FaultPoint string
+
+// The fault injection points.
+const (
+	// FaultCommit fails Store.Tx after txFunc succeeded, as if the commit failed. The
+	// error is a ErrCommitTx, so the compensation of a commit failure runs.
+	FaultCommit FaultPoint = "commit"
+
+	// FaultCopy fails OSFileSystem.CopyContext before anything is copied. The error is
+	// a ErrCopy.
+	FaultCopy FaultPoint = "fs.copy"
+
+	// FaultMkdir fails OSFileSystem.Mkdir before the directory is created.
+	FaultMkdir FaultPoint = "fs.mkdir"
+
+	// FaultRemove fails OSFileSystem.Remove and OSFileSystem.RemoveAll before anything
+	// is removed.
+	FaultRemove FaultPoint = "fs.remove"
+)
+
+// FaultPoints are all of the fault injection points.
+var FaultPoints = []FaultPoint{FaultCommit, FaultCopy, FaultMkdir, FaultRemove}
+
+// Faults forces the next calls at a FaultPoint to fail. It exists to exercise the
+// compensation and cleanup paths, such as removing the content of a file whose row
+// failed to commit, that cannot be triggered by hand. It must only be used in
+// development.
+//
+// A nil *Faults never fails, so the Store and OSFileSystem only pay a nil check when
+// fault injection is disabled. Faults is safe for concurrent use.
+type Faults struct {
+	mu    sync.Mutex
+	armed map[FaultPoint]int
+}
+
+// NewFaults creates a new Faults with no armed points.
+func NewFaults() *Faults {
+	return &Faults{armed: map[FaultPoint]int{}}
+}
+
+// Arm forces the next n calls at the point to fail. It replaces the count the point
+// was armed with. If n is zero, the point is disarmed.
+func (f *Faults) Arm(point FaultPoint, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if n <= 0 {
+		delete(f.armed, point)
+		return
+	}
+
+	f.armed[point] = n
+}
+
+// Armed returns the number of calls left to fail at each point. Points that are not
+// armed are 0.
+func (f *Faults) Armed() map[FaultPoint]int {
+	armed := map[FaultPoint]int{}
+	for _, p := range FaultPoints {
+		armed[p] = 0
+	}
+
+	if f == nil {
+		return armed
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for p, n := range f.armed {
+		armed[p] = n
+	}
+
+	return armed
+}
+
+// check returns a ErrInjectedFault if the point is armed, and counts it down.
+func (f *Faults) check(point FaultPoint) error {
+	if f == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := f.armed[point]
+	if n == 0 {
+		return nil
+	}
+
+	if n == 1 {
+		delete(f.armed, point)
+	} else {
+		f.armed[point] = n - 1
+	}
+
+	return fmt.Errorf("%w [point: %s]", ErrInjectedFault, point)
+}
+
+// ParseFaultPoint parses a FaultPoint. If it is not one of FaultPoints, a 400
+// app.WrappedSafeError of the "point" field is returned.
+func ParseFaultPoint(s string) (FaultPoint, error) {
+	for _, p := range FaultPoints {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+
+	names := []string{}
+	for _, p := range FaultPoints {
+		names = append(names, string(p))
+	}
+
+	return "", app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("invalid fault point %q", s),
+		SafeMessage: "point must be one of: " + strings.Join(names, ", "),
+		StatusCode:  http.StatusBadRequest,
+		Field:       "point",
+	})
+}
+
+// ParseFaults parses a comma separated list of points and counts, such as
+// "commit=1,fs.copy=2", and arms them on a new Faults. An empty string arms nothing.
+func ParseFaults(s string) (*Faults, error) {
+	f := NewFaults()
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		name, count, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault %q: must be point=count", v)
+		}
+
+		point, err := ParseFaultPoint(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault %q: %w", v, err)
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid fault %q: count must be a non negative integer", v)
+		}
+
+		f.Arm(point, n)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,237 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+// faultTest is a DirService and FileService on the test database and a new temporary
+// file store, whose commits and file system calls fail at the points armed on faults.
+// New directories use LayoutFanOut, so uploads create a shard.
+type faultTest struct {
+	store  *Store
+	faults *Faults
+	dirs   *DirService
+	files  *FileService
+	root   string
+	userID string
+	dir    Dir
+}
+
+func newFaultTest(t *testing.T) faultTest {
+	t.Helper()
+
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	userID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	faults := NewFaults()
+	store := NewStore(p)
+	store.SetFaults(faults)
+
+	root := t.TempDir()
+	pathMap := NewFSPathMapper(root)
+	fsIO := NewIO(&OSFileSystem{Faults: faults}, pathMap)
+	discard := log.New(io.Discard, "", 0)
+
+	dirs := NewDirService(DirServiceConfig{
+		Store:   store,
+		IO:      fsIO,
+		PathMap: pathMap,
+		Log:     discard,
+		Layout:  LayoutFanOut,
+	})
+	files := NewFileService(FileServiceConfig{
+		Store:        store,
+		IO:           fsIO,
+		PathMap:      pathMap,
+		Log:          discard,
+		ValidateUser: dirs.ValidateUser,
+	})
+
+	dir, err := dirs.New(ctx, userID, "uploads", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return faultTest{store: store, faults: faults, dirs: dirs, files: files, root: root, userID: userID, dir: dir}
+}
+
+// upload uploads the file a.txt to the directory of ft and returns the error of the
+// save.
+func (ft faultTest) upload(t *testing.T) error {
+	t.Helper()
+
+	batch, err := ft.files.SaveBatch(context.Background(), ft.userID, ft.dir.ID, []*multipart.FileHeader{
+		newTestFileHeader(t, "a.txt", "hello"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+
+	return batch.Saves[0].Err
+}
+
+// contents returns the paths of the files in the file store.
+func (ft faultTest) contents(t *testing.T) []string {
+	t.Helper()
+
+	paths := []string{}
+	err := filepath.WalkDir(ft.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			paths = append(paths, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking file store: %v", err)
+	}
+
+	return paths
+}
+
+// waitContents waits for the file store to have n files, the content of a failed
+// upload is removed in the background.
+func (ft faultTest) waitContents(t *testing.T, n int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		paths := ft.contents(t)
+		if len(paths) == n {
+			return paths
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("file store contents = %v, want %d files", paths, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// assertNotSaved fails t if a.txt has a row in the directory of ft.
+func (ft faultTest) assertNotSaved(t *testing.T) {
+	t.Helper()
+
+	_, err := ft.store.SelectFileByUserDirName(context.Background(), ft.userID, ft.dir.ID, "a.txt")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("selecting file error = %v, want no row", err)
+	}
+}
+
+// assertDisarmed fails t if a point of ft is still armed.
+func (ft faultTest) assertDisarmed(t *testing.T) {
+	t.Helper()
+
+	for point, n := range ft.faults.Armed() {
+		if n != 0 {
+			t.Errorf("point %s armed %d times, want the fault used", point, n)
+		}
+	}
+}
+
+// TestUploadFaultPoints walks a upload through every fault point. After each fault,
+// nothing of the upload is left behind and the same upload succeeds.
+func TestUploadFaultPoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []FaultPoint
+
+		// queued is true if the content is left on the file system and queued for
+		// DirService.Cleanup.
+		queued bool
+	}{
+		{name: "copy", points: []FaultPoint{FaultCopy}},
+		{name: "shard mkdir", points: []FaultPoint{FaultMkdir}},
+		{name: "commit", points: []FaultPoint{FaultCommit}},
+		{name: "commit then remove", points: []FaultPoint{FaultCommit, FaultRemove}, queued: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ft := newFaultTest(t)
+			for _, point := range tc.points {
+				ft.faults.Arm(point, 1)
+			}
+
+			err := ft.upload(t)
+			if !errors.Is(err, ErrInjectedFault) {
+				t.Fatalf("upload error = %v, want a ErrInjectedFault", err)
+			}
+			ft.assertNotSaved(t)
+
+			if !tc.queued {
+				ft.waitContents(t, 0)
+			} else {
+				left := ft.waitContents(t, 1)
+
+				// The content whose removal failed is removed by the cleanup.
+				deadline := time.Now().Add(5 * time.Second)
+				for {
+					rows, err := ft.store.SelectFSCleanup(context.Background(), CleanupBatchSize)
+					if err != nil {
+						t.Fatalf("SelectFSCleanup() error = %v", err)
+					}
+
+					if queued(rows, left[0]) {
+						break
+					}
+
+					if time.Now().After(deadline) {
+						t.Fatalf("content %s not queued for cleanup", left[0])
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				if _, _, err := ft.dirs.Cleanup(context.Background()); err != nil {
+					t.Fatalf("Cleanup() error = %v", err)
+				}
+				ft.waitContents(t, 0)
+			}
+			ft.assertDisarmed(t)
+
+			if err := ft.upload(t); err != nil {
+				t.Fatalf("upload after the fault error = %v", err)
+			}
+
+			if paths := ft.contents(t); len(paths) != 1 || !strings.HasPrefix(paths[0], ft.root) {
+				t.Errorf("file store contents = %v, want the uploaded file", paths)
+			}
+		})
+	}
+}
+
+// queued returns true if path is one of the rows.
+func queued(rows []FSCleanupRow, path string) bool {
+	for _, r := range rows {
+		if r.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
@@ -8,10 +8,11 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"github.com/cicconee/clox/internal/app"
-	"github.com/cicconee/clox/internal/db"
+	"github.com/cicconee/clox/internal/event"
 	"github.com/google/uuid"
 )
 
@@ -21,20 +22,49 @@ import (
 // FileService should be created using the NewFileService
 // function.
 type FileService struct {
-	store        *Store
+	store        Storage
 	io           *IO
 	log          *log.Logger
 	validateUser UserValidatorFunc
-	pathMap      *PathMapper
+	pathMap      *FSPathMapper
+	access       *Access
+	perm         Perm
+	dirPerm      Perm
+	listings     *ListingCache
+	hooks        *Hooks
+	loops        *LoopDetector
+	strict       bool
 }
 
 // FileServiceConfig is the FileService configuration.
 type FileServiceConfig struct {
-	Store        *Store
+	Store        Storage
 	IO           *IO
 	Log          *log.Logger
 	ValidateUser UserValidatorFunc
-	PathMap      *PathMapper
+	PathMap      *FSPathMapper
+	Access       *Access
+	Perm         Perm
+
+	// DirPerm is the permission of the shards of directories that use LayoutFanOut.
+	DirPerm Perm
+
+	// Listings caches directory listings. If nil, listings are not cached. It should
+	// be the same ListingCache as the DirService.
+	Listings *ListingCache
+
+	// Hooks are run after files are uploaded. If nil, no hooks are run. It should be
+	// the same Hooks as the DirService.
+	Hooks *Hooks
+
+	// Loops detects the same content being uploaded over and over. If nil, uploads are
+	// not checked.
+	Loops *LoopDetector
+
+	// Strict couples the file system write and the database commit of uploads, see
+	// FilePending. It trades a rename and a update per file for never having a
+	// readable file whose content was not fully written.
+	Strict bool
 }
 
 // NewFileService creates a new FileService.
@@ -48,6 +78,13 @@ type FileServiceConfig struct {
 // multiple IO's.
 //
 // If Log is not set, it will default to log.Default().
+//
+// If Access is not set, it will default to NewAccess(c.Store).
+//
+// If Perm is not set, it will default to DefaultFilePerm. Perm should be parsed
+// with ParsePerms.
+//
+// If DirPerm is not set, it will default to DefaultDirPerm.
 func NewFileService(c FileServiceConfig) *FileService {
 	if c.Store == nil {
 		panic("cloudstore.NewFileService: cannot create FileService with nil Store")
@@ -69,12 +106,31 @@ func NewFileService(c FileServiceConfig) *FileService {
 		c.Log = log.Default()
 	}
 
+	if c.Access == nil {
+		c.Access = NewAccess(c.Store)
+	}
+
+	if c.Perm == 0 {
+		c.Perm = DefaultFilePerm
+	}
+
+	if c.DirPerm == 0 {
+		c.DirPerm = DefaultDirPerm
+	}
+
 	return &FileService{
 		store:        c.Store,
 		io:           c.IO,
 		log:          c.Log,
 		validateUser: c.ValidateUser,
 		pathMap:      c.PathMap,
+		access:       c.Access,
+		perm:         c.Perm,
+		dirPerm:      c.DirPerm,
+		listings:     c.Listings,
+		hooks:        c.Hooks,
+		loops:        c.Loops,
+		strict:       c.Strict,
 	}
 }
 
@@ -88,6 +144,34 @@ type FileInfo struct {
 	Size        int64
 	UploadedAt  time.Time
 	FSPath      string
+
+	// ClientModifiedAt is the modification time the client declared when uploading the
+	// file, or its upload time if none was declared.
+	ClientModifiedAt time.Time
+
+	// Checksum is the hex encoded SHA-256 digest of the content, and ContentType the
+	// detected content type. They are empty until the content of the file is recorded.
+	Checksum    string
+	ContentType string
+
+	// writePath is the path the content was written to. It is FSPath, or the staged
+	// path of the file if it was written in strict mode.
+	writePath string
+
+	// touched are the IDs of the directories whose last write was updated by writing
+	// the file.
+	touched []string
+}
+
+// FileChange is a file that was renamed or moved.
+type FileChange struct {
+	// File is the file after it changed. Its Size and content fields are not set.
+	File FileInfo
+
+	// OldDirectoryID and OldPath are the directory and path of the file before it
+	// changed.
+	OldDirectoryID string
+	OldPath        string
 }
 
 // BatchSave is the result of saving a file when the files are being
@@ -99,6 +183,10 @@ type FileInfo struct {
 type BatchSave struct {
 	FileInfo
 	Err error
+
+	// Warning is a message for the user about a file that was saved, such as the
+	// LoopDetector suspecting it is uploaded in a loop. It is empty if there is none.
+	Warning string
 }
 
 // Msg returns the status of this BatchSave as a user friendly message.
@@ -123,90 +211,120 @@ func (b *BatchSave) Msg() string {
 	return ""
 }
 
-// SaveBatch writes all the files for a user under the specified directory. The
-// file permissions are set to 0600. If directoryID is empty, it will default to the
-// users root directory. The file names persisted will be the FileName value of each
-// multipart.FileHeader.
+// BatchDir is the directory the files of a Batch are saved in.
+type BatchDir struct {
+	ID   string
+	Name string
+	Path string
+}
+
+// Batch is the result of saving files as a batch. Every file is saved in Dir.
+type Batch struct {
+	Dir   BatchDir
+	Saves []BatchSave
+}
+
+// SaveBatch writes all the files for a user under the specified directory. The file
+// permissions are set to the FileService's permissions, 0600 by default. If
+// directoryID is empty, it will default to the users root directory. The file names
+// persisted will be the FileName value of each multipart.FileHeader.
 //
-// For each BatchSave that is returned, if an error occured while saving the file, it
-// will be set in the Err field. Every BatchSave will always have its Name and Size
-// fields set.
+// For each BatchSave of the Batch that is returned, if an error occured while saving
+// the file, it will be set in the Err field. Every BatchSave will always have its Name
+// and Size fields set.
 //
 // SaveBatch validates that a users root directory has been created. If it does not
 // exist it will create it.
 //
+// The modification time of each file is taken from mtimes by its FileName. Files not
+// in mtimes, or all files if mtimes is nil, default to their upload time.
+//
 // The file ID and name on the file system will be a randomly generated UUID.
-func (s *FileService) SaveBatch(ctx context.Context, userID string, directoryID string, fileHeaders []*multipart.FileHeader) ([]BatchSave, error) {
-	return s.saveBatch(ctx, userID, fileHeaders, func(r string) (string, error) {
+func (s *FileService) SaveBatch(ctx context.Context, userID string, directoryID string, fileHeaders []*multipart.FileHeader, mtimes ClientModTimes) (Batch, error) {
+	return s.saveBatch(ctx, userID, fileHeaders, mtimes, func(r string) (string, error) {
 		if directoryID == "" {
 			return r, nil
 		}
 
-		_, err := s.store.SelectDirectoryByIDUser(ctx, directoryID, userID)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return "", app.Wrap(app.WrapParams{
-					Err:         fmt.Errorf("directory '%s' does not exist: %w", directoryID, err),
-					SafeMessage: fmt.Sprintf("Directory '%s' does not exist", directoryID),
-					StatusCode:  http.StatusBadRequest,
-				})
-			}
-
-			return "", err
-		}
-
+		// The user owning the directory is validated by saveBatch.
 		return directoryID, nil
 	})
 }
 
 // SaveBatchPath writes all the files for a user under the specified path. The file
-// permissions are set to 0600. The path is cleaned using the filepath.Clean func.
-// An empty path will default to the users root directory.
+// permissions are set to the FileService's permissions, 0600 by default. The path is
+// cleaned using the filepath.Clean func. An empty path will default to the users
+// root directory.
 //
-// For each BatchSave that is returned, if an error occured while saving the file, it
-// will be set in the Err field. Every BatchSave will always have its Name and Size
-// fields set.
+// For each BatchSave of the Batch that is returned, if an error occured while saving
+// the file, it will be set in the Err field. Every BatchSave will always have its Name
+// and Size fields set.
 //
 // SaveBatchPath validates that a users root directory has been created. If it does not
 // exist it will create it.
 //
+// The modification times of the files are taken from mtimes, the same as SaveBatch.
+//
 // The file ID and name on the file system will be a randomly generated UUID.
-func (s *FileService) SaveBatchPath(ctx context.Context, userID string, path string, fileHeaders []*multipart.FileHeader) ([]BatchSave, error) {
-	return s.saveBatch(ctx, userID, fileHeaders, func(r string) (string, error) {
-		return s.pathMap.FindDir(ctx, s.store.Query, PathSearch{
+func (s *FileService) SaveBatchPath(ctx context.Context, userID string, path string, fileHeaders []*multipart.FileHeader, mtimes ClientModTimes) (Batch, error) {
+	return s.SaveBatchPathRelative(ctx, userID, "", path, fileHeaders, mtimes)
+}
+
+// SaveBatchPathRelative writes all the files for a user under the path relative to the
+// base directory (baseID). If baseID is empty, it is the same as SaveBatchPath. The user
+// must own the base directory, see UserPathMapper.FindDir for the errors of the base
+// directory and the path under it.
+func (s *FileService) SaveBatchPathRelative(ctx context.Context, userID string, baseID string, path string, fileHeaders []*multipart.FileHeader, mtimes ClientModTimes) (Batch, error) {
+	return s.saveBatch(ctx, userID, fileHeaders, mtimes, func(r string) (string, error) {
+		return s.pathMap.FindDir(ctx, s.store, PathSearch{
 			UserID: userID,
 			RootID: r,
 			Path:   path,
+			BaseID: baseID,
 		})
 	})
 }
 
 // saveBatch saves all the files in fileHeaders. Each file name is saved as FileHeader
 // Filename value. The users root directory is validated and then passes the ID to
-// getDirID. This function will return the ID of the files parent directory.
-func (s *FileService) saveBatch(ctx context.Context, userID string, fileHeaders []*multipart.FileHeader, getDirID idFunc) ([]BatchSave, error) {
+// getDirID. getDirID returns the ID of the files parent directory, which must be
+// owned by the user. The parent directory is returned in the Batch.
+func (s *FileService) saveBatch(ctx context.Context, userID string, fileHeaders []*multipart.FileHeader, mtimes ClientModTimes, getDirID idFunc) (Batch, error) {
 	root, err := s.validateUser(ctx, userID)
 	if err != nil {
-		return nil, err
+		return Batch{}, err
 	}
 
 	directoryID, err := getDirID(root.ID)
 	if err != nil {
-		return nil, err
+		return Batch{}, err
+	}
+
+	dir, err := s.access.Dir(ctx, userID, directoryID)
+	if err != nil {
+		return Batch{}, err
 	}
 
-	results := []BatchSave{}
+	path, err := s.pathMap.GetDir(ctx, s.store, dir.ID)
+	if err != nil {
+		return Batch{}, fmt.Errorf("getting directory path [id: %s]: %w", dir.ID, err)
+	}
+
+	batch := Batch{
+		Dir:   BatchDir{ID: dir.ID, Name: dir.Name, Path: path},
+		Saves: []BatchSave{},
+	}
 	for _, header := range fileHeaders {
-		file, err := s.write(ctx, userID, directoryID, header)
-		batchSave := BatchSave{FileInfo: file}
+		file, warning, err := s.write(ctx, userID, dir.ID, header, mtimes[header.Filename])
+		batchSave := BatchSave{FileInfo: file, Warning: warning}
 		if err != nil {
 			batchSave.Err = err
 		}
 
-		results = append(results, batchSave)
+		batch.Saves = append(batch.Saves, batchSave)
 	}
 
-	return results, nil
+	return batch, nil
 }
 
 // write writes a file to the server and returns a FileInfo. The location of
@@ -217,25 +335,38 @@ func (s *FileService) saveBatch(ctx context.Context, userID string, fileHeaders
 // an error associated with a inconsistent state, this method will attempt to delete the
 // file from the file system. If that fails it will be logged for manual intervention.
 //
+// If clientModifiedAt is zero, the files modification time defaults to its upload time.
+//
+// Once the content is written, the upload is checked by the LoopDetector. A blocked
+// upload is removed and a 429 app.WrappedSafeError wrapping ErrUploadLoop is returned,
+// a warning about a saved upload is returned with the FileInfo.
+//
 // The FileInfo returned will always have its Name and Size fields set even if there is
 // an error.
-func (s *FileService) write(ctx context.Context, userID string, directoryID string, header *multipart.FileHeader) (FileInfo, error) {
+func (s *FileService) write(ctx context.Context, userID string, directoryID string, header *multipart.FileHeader, clientModifiedAt time.Time) (FileInfo, string, error) {
 	var file FileInfo
 
-	err := s.store.Tx(ctx, func(tx *db.Tx) error {
-		fileIO, err := s.io.NewFile(ctx, NewQuery(tx), NewFileIO{
+	err := s.store.Tx(ctx, func(q Tx) error {
+		fileIO, err := s.io.NewFile(ctx, q, NewFileIO{
 			ID:          uuid.NewString(),
 			UserID:      userID,
 			DirectoryID: directoryID,
-			UploadedAt:  time.Now().UTC(),
 			Header:      header,
-			FSPerm:      0600,
+			FSPerm:      s.perm,
+			ShardPerm:   s.dirPerm,
+
+			ClientModifiedAt: clientModifiedAt,
+			Staged:           s.strict,
 		})
 		if err != nil {
 			return err
 		}
 
 		file = fileIO
+		if s.loops.blocked(ctx, userID, file) {
+			return ErrUploadLoop
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -246,43 +377,562 @@ func (s *FileService) write(ctx context.Context, userID string, directoryID stri
 				SafeMessage: fmt.Sprintf("File '%s' already exists", header.Filename),
 				StatusCode:  http.StatusBadRequest,
 			})
+		case errors.Is(err, ErrSizeLimitExceeded):
+			err = app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("file too large [name: %s]: %w", header.Filename, err),
+				SafeMessage: fmt.Sprintf("File '%s' is too large", header.Filename),
+				StatusCode:  http.StatusRequestEntityTooLarge,
+			})
+		case errors.Is(err, ErrStorageFull):
+			// Alert right away, every upload to this server fails until space is freed.
+			s.log.Printf("[ERROR] Storage full [name: %s, directory_id: %s]: %v\n", header.Filename, directoryID, err)
+			err = app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("storage full [name: %s]: %w", header.Filename, err),
+				SafeMessage: "Server storage is full; try again later",
+				StatusCode:  http.StatusInsufficientStorage,
+			})
+		case errors.Is(err, ErrUploadLoop):
+			go s.removeFS(file.writePath)
+			err = app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("upload loop [name: %s, directory_id: %s]: %w", header.Filename, directoryID, err),
+				SafeMessage: fmt.Sprintf("File '%s' was uploaded to this directory too many times recently; try again later", header.Filename),
+				StatusCode:  http.StatusTooManyRequests,
+			})
 		case errors.Is(err, ErrCommitTx), errors.Is(err, ErrCopy):
-			go s.removeFS(file.FSPath)
+			go s.removeFS(file.writePath)
 		}
 
-		return FileInfo{Name: header.Filename, Size: header.Size}, err
+		return FileInfo{Name: header.Filename, Size: header.Size}, "", err
 	}
 
-	return file, nil
+	if s.strict {
+		// The row and the staged content are both committed, so the file is saved even if
+		// promoting it fails. It is promoted by ResolvePending.
+		if err := s.io.PromoteFile(ctx, s.store, file.ID, file.FSPath); err != nil {
+			s.log.Printf("[ERROR] Promoting file, left pending [id: %s, path: %s]: %v\n", file.ID, file.FSPath, err)
+			return file, s.loops.record(ctx, userID, file), nil
+		}
+	}
+
+	s.listings.Invalidate(ctx, file.touched...)
+	s.hooks.runFileSaved(file)
+
+	return file, s.loops.record(ctx, userID, file), nil
 }
 
-// removeFS removes a file from the file system. If it fails it will be logged.
+// removeFS removes a file from the file system. Transient errors are retried. If it
+// still fails it will be logged and queued to be removed by DirService.Cleanup.
 func (s *FileService) removeFS(fsPath string) {
-	err := s.io.RemoveFS(fsPath)
-	if err != nil {
-		s.log.Printf("[ERROR] Removing file [path: %s]: %v\n", fsPath, err)
+	result := s.io.RemoveFS(fsPath)
+	if !result.OK() {
+		s.log.Printf("[ERROR] Removing file [path: %s, attempts: %d]: %v\n", fsPath, result.Attempts, result.Err)
+		queueCleanup(context.Background(), s.store, s.log, result)
 	}
 }
 
-// Info gets the information for a users file. It is returned as a FileInfo.
+// Info gets the information for a file the user may read. It is returned as a
+// FileInfo. If the file does not exist or the user may not read it, a 404
+// app.WrappedSafeError is returned. If the content of the file is not on the file
+// system, the file is flagged as content_missing and a 503 app.WrappedSafeError
+// wrapping ErrContentMissing is returned.
 func (s *FileService) Info(ctx context.Context, userID string, fileID string) (FileInfo, error) {
-	file, err := s.io.ReadFileInfo(ctx, s.store.Query, ReadFileInfoIO{
-		UserID: userID,
+	canRead, err := s.access.CanRead(ctx, userID, fileID)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	if !canRead {
+		return FileInfo{}, FileNotFound(fileID, fmt.Errorf("user '%s' cannot read file: %w", userID, sql.ErrNoRows))
+	}
+
+	file, err := s.io.ReadFileInfo(ctx, s.store, ReadFileInfoIO{
 		FileID: fileID,
 	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			return FileInfo{}, FileNotFound(fileID, err)
+		}
+
+		if errors.Is(err, ErrContentMissing) {
+			return FileInfo{}, s.contentMissing(ctx, file, err)
+		}
+
+		return FileInfo{}, err
+	}
+
+	return file, nil
+}
+
+// Delete deletes a file of a user. The row of the file is deleted in a transaction,
+// and its content is only removed from the file system once the transaction commits,
+// so a failed commit never leaves a row without its content. If the content cannot be
+// removed, it is logged and queued for DirService.Cleanup, the file is still deleted.
+// A file.deleted event is recorded with the delete, and the AfterFileDeleted hooks are
+// run once it commits.
+//
+// If the file does not exist or is not owned by the user, a 404 app.WrappedSafeError
+// is returned. If the file is moved by another request at the same time, a 409
+// app.WrappedSafeError is returned.
+func (s *FileService) Delete(ctx context.Context, userID string, fileID string) error {
+	if userID == "" || !validID(fileID) {
+		return FileNotFound(fileID, sql.ErrNoRows)
+	}
+
+	file, err := s.store.SelectFileByIDUser(ctx, fileID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return FileNotFound(fileID, err)
+		}
+
+		return err
+	}
+
+	var info FileInfo
+	var fsPath string
+	var touched []string
+	err = s.store.Tx(ctx, func(q Tx) error {
+		// The directory cannot be moved or change its layout while the file is deleted.
+		file, err := s.lockFile(ctx, q, file)
+		if err != nil {
+			return err
+		}
+
+		info = FileInfo{
+			ID:               file.ID,
+			OwnerID:          file.UserID,
+			DirectoryID:      file.DirectoryID,
+			Name:             file.Name,
+			UploadedAt:       file.UploadedAt,
+			ClientModifiedAt: file.ClientModifiedAt,
+		}
+
+		fsPath, err = s.pathMap.GetFileFS(ctx, q, file.DirectoryID, file.ID)
+		if err != nil {
+			return fmt.Errorf("getting file system path [id: %s]: %w", file.ID, err)
+		}
+
+		info.Path, err = s.pathMap.GetFile(ctx, q, file.DirectoryID, file.Name)
+		if err != nil {
+			return fmt.Errorf("getting file path [id: %s]: %w", file.ID, err)
+		}
+
+		deleted, err := q.DeleteFiles(ctx, []string{file.ID})
+		if err != nil {
+			return fmt.Errorf("deleting file: %w", err)
+		}
+
+		if deleted == 0 {
+			return sql.ErrNoRows
+		}
+
+		touched, err = q.UpdateLastWrite(ctx, file.DirectoryID)
+		if err != nil {
+			return fmt.Errorf("updating last write: %w", err)
+		}
+
+		return q.InsertEvent(ctx, userID, event.TypeFileDeleted, event.FileDeleted{
+			ID:          info.ID,
+			DirectoryID: info.DirectoryID,
+			Name:        info.Name,
+			Path:        info.Path,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return FileNotFound(fileID, err)
+		}
+
+		return err
+	}
+
+	s.listings.Invalidate(ctx, touched...)
+	s.removeFS(fsPath)
+	s.hooks.runFileDeleted(info)
+
+	return nil
+}
+
+// Rename renames a file of a user. The file stays in its directory, and its content is
+// stored under its ID, so only the name of the file is changed. A file.renamed event is
+// recorded with the rename, and the AfterFileRenamed hooks are run once it commits.
+//
+// If newName is empty, "." or "..", or contains a path separator, a 400
+// app.WrappedSafeError is returned. If another file in the directory is using newName,
+// a 409 app.WrappedSafeError is returned, as it is if the file is moved by another
+// request at the same time. If the file does not exist or is not owned by the user, a 404
+// app.WrappedSafeError is returned.
+func (s *FileService) Rename(ctx context.Context, userID string, fileID string, newName string) (FileInfo, error) {
+	if err := validateName(EntryFile, newName); err != nil {
+		return FileInfo{}, err
+	}
+
+	if userID == "" || !validID(fileID) {
+		return FileInfo{}, FileNotFound(fileID, sql.ErrNoRows)
+	}
+
+	file, err := s.store.SelectFileByIDUser(ctx, fileID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return FileInfo{}, FileNotFound(fileID, err)
+		}
+
+		return FileInfo{}, err
+	}
+
+	var change FileChange
+	var touched []string
+	err = s.store.Tx(ctx, func(q Tx) error {
+		// The directory cannot be moved while the paths of the file are read.
+		file, err := s.lockFile(ctx, q, file)
+		if err != nil {
+			return err
+		}
+
+		change = FileChange{
+			File: FileInfo{
+				ID:               file.ID,
+				OwnerID:          file.UserID,
+				DirectoryID:      file.DirectoryID,
+				Name:             newName,
+				UploadedAt:       file.UploadedAt,
+				ClientModifiedAt: file.ClientModifiedAt,
+			},
+			OldDirectoryID: file.DirectoryID,
+		}
+
+		if err := q.UpdateFileName(ctx, file.ID, userID, newName); err != nil {
+			return fmt.Errorf("updating file name: %w", err)
+		}
+
+		change.OldPath, err = s.pathMap.GetFile(ctx, q, file.DirectoryID, file.Name)
+		if err != nil {
+			return fmt.Errorf("getting file path [id: %s]: %w", file.ID, err)
+		}
+
+		change.File.Path, err = s.pathMap.GetFile(ctx, q, file.DirectoryID, newName)
+		if err != nil {
+			return fmt.Errorf("getting file path [id: %s]: %w", file.ID, err)
+		}
+
+		touched, err = q.UpdateLastWrite(ctx, file.DirectoryID)
+		if err != nil {
+			return fmt.Errorf("updating last write: %w", err)
+		}
+
+		return q.InsertEvent(ctx, userID, event.TypeFileRenamed, event.FileRenamed{
+			ID:          file.ID,
+			DirectoryID: file.DirectoryID,
+			Name:        newName,
+			Path:        change.File.Path,
+			OldName:     file.Name,
+			OldPath:     change.OldPath,
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUniqueDirectoryIDName):
 			return FileInfo{}, app.Wrap(app.WrapParams{
-				Err:         fmt.Errorf("file '%s' does not exist: %w", fileID, err),
-				SafeMessage: "File not found",
-				StatusCode:  http.StatusNotFound,
+				Err:         fmt.Errorf("file name not available [name: %s, directory_id: %s]: %w", newName, file.DirectoryID, err),
+				SafeMessage: fmt.Sprintf("File '%s' already exists", newName),
+				StatusCode:  http.StatusConflict,
+				Field:       "name",
 			})
+		case errors.Is(err, sql.ErrNoRows):
+			return FileInfo{}, FileNotFound(fileID, err)
+		default:
+			return FileInfo{}, err
+		}
+	}
+
+	s.listings.Invalidate(ctx, touched...)
+	s.hooks.runFileRenamed(change)
+
+	return s.Info(ctx, userID, file.ID)
+}
+
+// Move moves a file of a user to the directory targetDirID of the user. The row of
+// the file is updated in a transaction, and because the content of a file is stored
+// under the directory on the file system, the content is moved into the target
+// directory before the transaction commits. If the commit fails, the content is moved
+// back. A file.moved event is recorded with the move, and the AfterFileMoved hooks are
+// run once it commits.
+//
+// If the file or target directory does not exist or is not owned by the user, a 404
+// app.WrappedSafeError is returned. If a file in the target directory is using the
+// name of the file, or the file is moved by another request at the same time, a 409
+// app.WrappedSafeError is returned. If the content of the file is not on the file
+// system, the file is not moved, it is flagged as content_missing and a 503
+// app.WrappedSafeError wrapping ErrContentMissing is returned. Moving a file to the
+// directory it is in does nothing.
+func (s *FileService) Move(ctx context.Context, userID string, fileID string, targetDirID string) (FileInfo, error) {
+	if userID == "" || !validID(fileID) {
+		return FileInfo{}, FileNotFound(fileID, sql.ErrNoRows)
+	}
+
+	file, err := s.store.SelectFileByIDUser(ctx, fileID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return FileInfo{}, FileNotFound(fileID, err)
 		}
 
 		return FileInfo{}, err
 	}
 
-	return file, nil
+	target, err := s.access.Dir(ctx, userID, targetDirID)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	if target.ID == file.DirectoryID {
+		return s.Info(ctx, userID, file.ID)
+	}
+
+	var m fileMove
+	err = s.store.Tx(ctx, func(q Tx) error {
+		return s.moveTx(ctx, q, userID, file, target, &m, false)
+	})
+	if err != nil {
+		s.moveBack(m)
+		return FileInfo{}, s.moveError(ctx, err, file, target, m)
+	}
+
+	s.afterMove(ctx, m)
+
+	return s.Info(ctx, userID, file.ID)
+}
+
+// fileMove is a file moved by FileService.moveTx.
+type fileMove struct {
+	change FileChange
+
+	// from and to are the file system paths of the content before and after the move.
+	// moved is set once the content is moved on the file system.
+	from, to string
+	moved    bool
+
+	// touched are the directories whose last write was updated.
+	touched []string
+}
+
+// moveTx moves the file of a user to the directory target in the transaction q, see
+// Move. The row of file is selected again once its directory is locked, see lockFile. The
+// move is recorded in m, if the transaction does not commit and m.moved is set, the
+// content must be moved back with moveBack. If the content is missing, a error wrapping
+// ErrContentMissing is returned. If dryRun is true, every statement is run but the content
+// is not moved, the transaction must be rolled back.
+func (s *FileService) moveTx(ctx context.Context, q Tx, userID string, file FileRow, target DirectoryRow, m *fileMove, dryRun bool) error {
+	// The layouts of the directories cannot change while the content is moved.
+	file, err := s.lockFile(ctx, q, file, target.ID)
+	if err != nil {
+		return err
+	}
+
+	// The target may have been deleted before it was locked.
+	if _, err := q.SelectDirectoryByIDUser(ctx, target.ID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DirNotFound(target.ID, err)
+		}
+
+		return fmt.Errorf("selecting target directory [id: %s]: %w", target.ID, err)
+	}
+
+	m.change = FileChange{
+		File: FileInfo{
+			ID:               file.ID,
+			OwnerID:          file.UserID,
+			DirectoryID:      target.ID,
+			Name:             file.Name,
+			UploadedAt:       file.UploadedAt,
+			ClientModifiedAt: file.ClientModifiedAt,
+		},
+		OldDirectoryID: file.DirectoryID,
+	}
+
+	m.change.OldPath, err = s.pathMap.GetFile(ctx, q, file.DirectoryID, file.Name)
+	if err != nil {
+		return fmt.Errorf("getting file path [id: %s]: %w", file.ID, err)
+	}
+
+	m.change.File.Path, err = s.pathMap.GetFile(ctx, q, target.ID, file.Name)
+	if err != nil {
+		return fmt.Errorf("getting file path [id: %s, directory_id: %s]: %w", file.ID, target.ID, err)
+	}
+
+	m.from, err = s.pathMap.GetFileFS(ctx, q, file.DirectoryID, file.ID)
+	if err != nil {
+		return fmt.Errorf("getting file system path [id: %s]: %w", file.ID, err)
+	}
+
+	m.to, err = s.pathMap.GetFileFS(ctx, q, target.ID, file.ID)
+	if err != nil {
+		return fmt.Errorf("getting file system path [id: %s, directory_id: %s]: %w", file.ID, target.ID, err)
+	}
+
+	if err := q.UpdateFileDirectory(ctx, file.ID, userID, target.ID); err != nil {
+		return fmt.Errorf("updating file directory: %w", err)
+	}
+
+	for _, dirID := range []string{file.DirectoryID, target.ID} {
+		ids, err := q.UpdateLastWrite(ctx, dirID)
+		if err != nil {
+			return fmt.Errorf("updating last write: %w", err)
+		}
+		m.touched = append(m.touched, ids...)
+	}
+
+	err = q.InsertEvent(ctx, userID, event.TypeFileMoved, event.FileMoved{
+		ID:             file.ID,
+		DirectoryID:    target.ID,
+		Name:           file.Name,
+		Path:           m.change.File.Path,
+		OldDirectoryID: file.DirectoryID,
+		OldPath:        m.change.OldPath,
+	})
+	if err != nil {
+		return err
+	}
+
+	layout, err := q.SelectDirectoryLayout(ctx, target.ID)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	if layout == LayoutFanOut {
+		if err := s.io.mkdirShard(m.to, s.dirPerm); err != nil {
+			return fmt.Errorf("creating shard [%s]: %w", filepath.Dir(m.to), err)
+		}
+	}
+
+	if err := s.io.MoveFS(m.from, m.to); err != nil {
+		if s.io.fs.IsNotExist(err) {
+			return fmt.Errorf("%w [id: %s, path: %s]: %w", ErrContentMissing, file.ID, m.from, err)
+		}
+
+		return fmt.Errorf("moving file [%s]: %w", m.from, err)
+	}
+
+	m.moved = true
+	return nil
+}
+
+// lockFile locks the directory of file and the directories ids in the transaction q, see
+// Query.LockDirectories, and selects the row of file again with
+// Query.SelectFileByIDUserForUpdate. The selected row is returned, it cannot be changed by
+// another request until the transaction ends.
+//
+// If the file was deleted after it was read, sql.ErrNoRows is returned. If it was moved to
+// another directory, the lock taken is not the lock of its directory and a 409
+// app.WrappedSafeError is returned.
+func (s *FileService) lockFile(ctx context.Context, q Tx, file FileRow, ids ...string) (FileRow, error) {
+	if err := q.LockDirectories(ctx, append([]string{file.DirectoryID}, ids...)...); err != nil {
+		return FileRow{}, err
+	}
+
+	locked, err := q.SelectFileByIDUserForUpdate(ctx, file.ID, file.UserID)
+	if err != nil {
+		return FileRow{}, err
+	}
+
+	if locked.DirectoryID != file.DirectoryID {
+		return FileRow{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("file moved by another request [id: %s, from: %s, to: %s]", file.ID, file.DirectoryID, locked.DirectoryID),
+			SafeMessage: "File was moved by another request, try again",
+			StatusCode:  http.StatusConflict,
+		})
+	}
+
+	return locked, nil
+}
+
+// moveBack moves the content of m back on the file system, if it was moved. It is called
+// when the transaction of the move does not commit.
+func (s *FileService) moveBack(m fileMove) {
+	if !m.moved {
+		return
+	}
+
+	if err := s.io.MoveFS(m.to, m.from); err != nil {
+		s.log.Printf("[ERROR] Moving file back after failed move [from: %s, to: %s]: %v\n", m.to, m.from, err)
+	}
+}
+
+// moveError returns the error of the move m of file to target that failed with err, see
+// moveFileError. If the content of the file was missing, the file is flagged with
+// contentMissing. It must be called once the transaction of the move has ended.
+func (s *FileService) moveError(ctx context.Context, err error, file FileRow, target DirectoryRow, m fileMove) error {
+	if errors.Is(err, ErrContentMissing) {
+		return s.contentMissing(ctx, FileInfo{ID: file.ID, DirectoryID: file.DirectoryID, FSPath: m.from}, err)
+	}
+
+	return moveFileError(err, file, target)
+}
+
+// moveFileError returns the error of the move of file to target that failed with err.
+func moveFileError(err error, file FileRow, target DirectoryRow) error {
+	var safeErr *app.WrappedSafeError
+	switch {
+	case errors.As(err, &safeErr):
+		return err
+	case errors.Is(err, ErrUniqueDirectoryIDName):
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("file name not available [name: %s, directory_id: %s]: %w", file.Name, target.ID, err),
+			SafeMessage: fmt.Sprintf("File '%s' already exists", file.Name),
+			StatusCode:  http.StatusConflict,
+		})
+	case errors.Is(err, sql.ErrNoRows):
+		return FileNotFound(file.ID, err)
+	default:
+		return err
+	}
+}
+
+// afterMove invalidates the listings of the directories of m and runs the AfterFileMoved
+// hooks, once the transaction of the move commits.
+func (s *FileService) afterMove(ctx context.Context, m fileMove) {
+	s.listings.Invalidate(ctx, m.touched...)
+	s.hooks.runFileMoved(m.change)
+}
+
+// MovePath moves a users file to the directory at the provided path, see Move.
+func (s *FileService) MovePath(ctx context.Context, userID string, fileID string, path string) (FileInfo, error) {
+	root, err := s.validateUser(ctx, userID)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	dirID, err := s.pathMap.FindDir(ctx, s.store, PathSearch{
+		UserID: userID,
+		RootID: root.ID,
+		Path:   path,
+	})
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	return s.Move(ctx, userID, fileID, dirID)
+}
+
+// DeletePath deletes a users file at the provided path, see Delete.
+func (s *FileService) DeletePath(ctx context.Context, userID string, path string) error {
+	root, err := s.validateUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	fileID, err := s.pathMap.FindFile(ctx, s.store, PathSearch{
+		UserID: userID,
+		RootID: root.ID,
+		Path:   path,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Delete(ctx, userID, fileID)
 }
 
 // InfoPath gets the information for a users file at the provided path.
@@ -292,7 +942,7 @@ func (s *FileService) InfoPath(ctx context.Context, userID string, path string)
 		return FileInfo{}, err
 	}
 
-	fileID, err := s.pathMap.FindFile(ctx, s.store.Query, PathSearch{
+	fileID, err := s.pathMap.FindFile(ctx, s.store, PathSearch{
 		UserID: userID,
 		RootID: root.ID,
 		Path:   path,
@@ -0,0 +1,634 @@
+package cloudstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/event"
+	"github.com/google/uuid"
+)
+
+// newTestFileService creates a FileService on f that stores files in a new temporary
+// directory, which is returned.
+func newTestFileService(t *testing.T, f *fakeStorage) (*FileService, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	return NewFileService(FileServiceConfig{
+		Store: f,
+		Log:   log.New(io.Discard, "", 0),
+		ValidateUser: func(ctx context.Context, userID string) (Dir, error) {
+			return Dir{}, errors.New("not used")
+		},
+		PathMap: NewPathMapper(root),
+	}), root
+}
+
+// addTestFile adds the file name to the directory dir of f and writes its content to
+// the file store root. The file is returned with the path of its content.
+func addTestFile(t *testing.T, f *fakeStorage, root string, dir DirectoryRow, name string) (FileRow, string) {
+	t.Helper()
+
+	row := FileRow{
+		ID:          uuid.NewString(),
+		UserID:      dir.UserID,
+		DirectoryID: dir.ID,
+		Name:        name,
+		UploadedAt:  time.Now().UTC(),
+	}
+	f.addFile(row)
+
+	f.mu.Lock()
+	dirFS := root
+	ancestors := f.ancestors(dir.ID)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		dirFS += "/" + ancestors[i].ID
+	}
+	f.mu.Unlock()
+
+	path := LayoutFlat.FilePath(dirFS, row.ID)
+	if err := os.WriteFile(path, []byte(name), 0600); err != nil {
+		t.Fatalf("writing file content: %v", err)
+	}
+
+	return row, path
+}
+
+// assertContent fails t if the content of the file at path is not want.
+func assertContent(t *testing.T, path string, want string) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading file content: %v", err)
+	}
+
+	if string(got) != want {
+		t.Errorf("content of %s = %q, want %q", path, got, want)
+	}
+}
+
+func TestFileServiceRenameUniqueName(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	addTestFile(t, f, root, userRoot, "a.txt")
+	file, _ := addTestFile(t, f, root, userRoot, "b.txt")
+
+	_, err := s.Rename(context.Background(), userRoot.UserID, file.ID, "a.txt")
+	assertSafeError(t, err, http.StatusConflict, ErrUniqueDirectoryIDName)
+
+	var safeErr *app.WrappedSafeError
+	if errors.As(err, &safeErr) && safeErr.Field() != "name" {
+		t.Errorf("field = %q, want %q", safeErr.Field(), "name")
+	}
+
+	data := f.data()
+	if got := data.files[file.ID].Name; got != "b.txt" {
+		t.Errorf("file name = %q, want it unchanged", got)
+	}
+
+	if len(data.events) != 0 {
+		t.Errorf("events = %v, want none", data.events)
+	}
+}
+
+func TestFileServiceMoveUniqueName(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	target := addTestDir(t, f, root, userRoot, "photos")
+	addTestFile(t, f, root, target, "a.txt")
+	file, from := addTestFile(t, f, root, userRoot, "a.txt")
+
+	_, err := s.Move(context.Background(), userRoot.UserID, file.ID, target.ID)
+	assertSafeError(t, err, http.StatusConflict, ErrUniqueDirectoryIDName)
+
+	if got := f.data().files[file.ID].DirectoryID; got != userRoot.ID {
+		t.Errorf("file directory = %s, want it unchanged %s", got, userRoot.ID)
+	}
+
+	assertContent(t, from, "a.txt")
+}
+
+func TestFileServiceMoveCommitFailure(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	target := addTestDir(t, f, root, userRoot, "photos")
+	file, from := addTestFile(t, f, root, userRoot, "a.txt")
+	f.failCommit(errors.New("connection reset"))
+
+	_, err := s.Move(context.Background(), userRoot.UserID, file.ID, target.ID)
+	if !errors.Is(err, ErrCommitTx) {
+		t.Fatalf("Move() error = %v, want ErrCommitTx", err)
+	}
+
+	data := f.data()
+	if got := data.files[file.ID].DirectoryID; got != userRoot.ID {
+		t.Errorf("file directory = %s, want it unchanged %s", got, userRoot.ID)
+	}
+
+	if len(data.events) != 0 {
+		t.Errorf("events = %v, want none", data.events)
+	}
+
+	// The content was moved in the transaction, it is moved back once the commit fails.
+	assertContent(t, from, "a.txt")
+
+	to := LayoutFlat.FilePath(filepath.Join(root, userRoot.ID, target.ID), file.ID)
+	if _, err := os.Stat(to); !os.IsNotExist(err) {
+		t.Errorf("content in the target directory: %v, want it not to exist", err)
+	}
+}
+
+func TestFileServiceMoveMovedConcurrently(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	target := addTestDir(t, f, root, userRoot, "photos")
+	other := addTestDir(t, f, root, userRoot, "docs")
+	file, from := addTestFile(t, f, root, userRoot, "a.txt")
+
+	// The file is moved by a concurrent request after it was read, before the lock of
+	// its directory was taken.
+	f.failOn("LockDirectories", func() error {
+		f.concurrent(func(d *fakeData) {
+			moved := d.files[file.ID]
+			moved.DirectoryID = other.ID
+			d.files[file.ID] = moved
+		})
+		return nil
+	})
+
+	_, err := s.Move(context.Background(), userRoot.UserID, file.ID, target.ID)
+	assertSafeError(t, err, http.StatusConflict, nil)
+
+	data := f.data()
+	if got := data.files[file.ID].DirectoryID; got != other.ID {
+		t.Errorf("file directory = %s, want the directory of the concurrent move %s", got, other.ID)
+	}
+
+	if len(data.events) != 0 {
+		t.Errorf("events = %v, want none", data.events)
+	}
+
+	assertContent(t, from, "a.txt")
+}
+
+func TestFileServiceInfoContentMissing(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	dirs, _ := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	file, path := addTestFile(t, f, root, userRoot, "a.txt")
+	ctx := context.Background()
+
+	// The backing file is deleted behind the row.
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("removing file content: %v", err)
+	}
+
+	reads := storageMetric("content_missing_reads")
+	_, err := s.Info(ctx, userRoot.UserID, file.ID)
+	assertSafeError(t, err, http.StatusServiceUnavailable, ErrContentMissing)
+
+	if got := storageMetric("content_missing_reads"); got != reads+1 {
+		t.Errorf("content_missing_reads = %d, want %d", got, reads+1)
+	}
+
+	if !f.data().missing[file.ID] {
+		t.Error("file not flagged as content missing")
+	}
+
+	// The file is still listed, with the flag.
+	entries, err := dirs.ListEntries(ctx, userRoot.UserID, userRoot.ID, ListOptions{Files: true, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListEntries() error = %v", err)
+	}
+
+	if len(entries) != 1 || !entries[0].ContentMissing {
+		t.Errorf("ListEntries() = %+v, want the file flagged as content missing", entries)
+	}
+}
+
+func TestFileServiceOpenContentMissing(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	file, path := addTestFile(t, f, root, userRoot, "a.txt")
+	ctx := context.Background()
+
+	info, err := s.Info(ctx, userRoot.UserID, file.ID)
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+
+	// The backing file is deleted between the info and the download.
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("removing file content: %v", err)
+	}
+
+	_, err = s.Open(ctx, info)
+	assertSafeError(t, err, http.StatusServiceUnavailable, ErrContentMissing)
+
+	if !f.data().missing[file.ID] {
+		t.Error("file not flagged as content missing")
+	}
+
+	// A failure to flag the file does not change the error.
+	f.fail("UpdateFileContentMissing", errors.New("connection reset"))
+	_, err = s.Open(ctx, info)
+	assertSafeError(t, err, http.StatusServiceUnavailable, ErrContentMissing)
+}
+
+func TestFileServiceMoveContentMissing(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	target := addTestDir(t, f, root, userRoot, "photos")
+	file, from := addTestFile(t, f, root, userRoot, "a.txt")
+
+	if err := os.Remove(from); err != nil {
+		t.Fatalf("removing file content: %v", err)
+	}
+
+	_, err := s.Move(context.Background(), userRoot.UserID, file.ID, target.ID)
+	assertSafeError(t, err, http.StatusServiceUnavailable, ErrContentMissing)
+
+	data := f.data()
+	if got := data.files[file.ID].DirectoryID; got != userRoot.ID {
+		t.Errorf("file directory = %s, want it unchanged %s", got, userRoot.ID)
+	}
+
+	if !data.missing[file.ID] {
+		t.Error("file not flagged as content missing")
+	}
+
+	if len(data.events) != 0 {
+		t.Errorf("events = %v, want none", data.events)
+	}
+}
+
+func TestFileServiceDeleteTwice(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	file, path := addTestFile(t, f, root, userRoot, "a.txt")
+
+	if err := s.Delete(context.Background(), userRoot.UserID, file.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	err := s.Delete(context.Background(), userRoot.UserID, file.ID)
+	assertSafeError(t, err, http.StatusNotFound, ErrNotFound)
+
+	data := f.data()
+	if len(data.events) != 1 || data.events[0] != event.TypeFileDeleted {
+		t.Errorf("events = %v, want [%s]", data.events, event.TypeFileDeleted)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file content: %v, want it removed", err)
+	}
+}
+
+func TestFileServiceDeleteConcurrent(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+	}{
+		// The file is deleted after it was read, before the lock of its directory was
+		// taken.
+		{name: "before lock", method: "LockDirectories"},
+		// The file is deleted after it was locked, its row is already gone.
+		{name: "before delete", method: "DeleteFiles"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeStorage(t)
+			s, root := newTestFileService(t, f)
+			userRoot := addTestRoot(t, f, root)
+			file, _ := addTestFile(t, f, root, userRoot, "a.txt")
+
+			f.failOn(tc.method, func() error {
+				f.concurrent(func(d *fakeData) { delete(d.files, file.ID) })
+				return nil
+			})
+
+			err := s.Delete(context.Background(), userRoot.UserID, file.ID)
+			assertSafeError(t, err, http.StatusNotFound, ErrNotFound)
+
+			if got := f.data().events; len(got) != 0 {
+				t.Errorf("events = %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestFileServiceRenameMovedConcurrently(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	other := addTestDir(t, f, root, userRoot, "docs")
+	file, _ := addTestFile(t, f, root, userRoot, "a.txt")
+
+	// The file is moved by a concurrent request after it was read, before the lock of
+	// its directory was taken.
+	f.failOn("LockDirectories", func() error {
+		f.concurrent(func(d *fakeData) {
+			moved := d.files[file.ID]
+			moved.DirectoryID = other.ID
+			d.files[file.ID] = moved
+		})
+		return nil
+	})
+
+	_, err := s.Rename(context.Background(), userRoot.UserID, file.ID, "b.txt")
+	assertSafeError(t, err, http.StatusConflict, nil)
+
+	data := f.data()
+	if got := data.files[file.ID]; got.Name != "a.txt" || got.DirectoryID != other.ID {
+		t.Errorf("file = %s in %s, want a.txt in the directory of the concurrent move %s", got.Name, got.DirectoryID, other.ID)
+	}
+
+	if len(data.events) != 0 {
+		t.Errorf("events = %v, want none", data.events)
+	}
+}
+
+func TestFileServiceRenameRenamedConcurrently(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	file, _ := addTestFile(t, f, root, userRoot, "a.txt")
+
+	// The file is renamed by a concurrent request after it was read. The rename records
+	// the name the file had once it was locked.
+	f.failOn("LockDirectories", func() error {
+		f.concurrent(func(d *fakeData) {
+			renamed := d.files[file.ID]
+			renamed.Name = "b.txt"
+			d.files[file.ID] = renamed
+		})
+		return nil
+	})
+
+	if _, err := s.Rename(context.Background(), userRoot.UserID, file.ID, "c.txt"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	data := f.data()
+	if got := data.files[file.ID].Name; got != "c.txt" {
+		t.Errorf("file name = %q, want %q", got, "c.txt")
+	}
+
+	want := event.FileRenamed{ID: file.ID, DirectoryID: userRoot.ID, Name: "c.txt", Path: "/c.txt", OldName: "b.txt", OldPath: "/b.txt"}
+	if len(data.payloads) != 1 || data.payloads[0] != want {
+		t.Errorf("events = %+v, want [%+v]", data.payloads, want)
+	}
+}
+
+// newTestFileHeader returns the multipart.FileHeader of a file name with content, as
+// parsed from a upload request.
+func newTestFileHeader(t *testing.T, name string, content string) *multipart.FileHeader {
+	t.Helper()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("files", name)
+	if err != nil {
+		t.Fatalf("creating form file: %v", err)
+	}
+	part.Write([]byte(content))
+	w.Close()
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("reading form: %v", err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+
+	return form.File["files"][0]
+}
+
+func TestFileServiceSaveBatchEmptyFile(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	s.validateUser = func(ctx context.Context, userID string) (Dir, error) {
+		return Dir{ID: userRoot.ID}, nil
+	}
+
+	// The size declared by the client is not trusted.
+	empty := newTestFileHeader(t, "empty.txt", "")
+	empty.Size = 99
+
+	batch, err := s.SaveBatch(context.Background(), userRoot.UserID, "", []*multipart.FileHeader{
+		empty,
+		newTestFileHeader(t, "a.txt", "hello"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+
+	// The stored size is the number of bytes copied, zero-byte files are saved.
+	for i, want := range []int64{0, 5} {
+		save := batch.Saves[i]
+		if save.Err != nil {
+			t.Fatalf("SaveBatch() %s error = %v", save.Name, save.Err)
+		}
+
+		if save.Size != want {
+			t.Errorf("%s size = %d, want %d", save.Name, save.Size, want)
+		}
+
+		info, err := os.Stat(save.FSPath)
+		if err != nil {
+			t.Fatalf("stat %s: %v", save.Name, err)
+		}
+
+		if info.Size() != want {
+			t.Errorf("%s size on the file system = %d, want %d", save.Name, info.Size(), want)
+		}
+	}
+
+	// Every upload is recorded in the outbox.
+	data := f.data()
+	if len(data.events) != 2 || data.events[0] != event.TypeFileUploaded || data.events[1] != event.TypeFileUploaded {
+		t.Errorf("events = %v, want [%s %s]", data.events, event.TypeFileUploaded, event.TypeFileUploaded)
+	}
+}
+
+// TestFileServiceSaveBatchStatements uploads batches of a few and many files, the
+// statements executed grow by a fixed number per file.
+func TestFileServiceSaveBatchStatements(t *testing.T) {
+	_, files, userRoot := newTestDBServices(t)
+
+	// The root is looked up, and the directory is read and its path selected, once per
+	// batch. Each file locks the directory, inserts its row, selects the user path, the
+	// file system path and layout, and the layout again, updates its content, inserts
+	// its file.uploaded event, and updates the last write of its ancestors.
+	const batchStatements = 3
+	const fileStatements = 9
+
+	for _, n := range []int{1, 5} {
+		headers := []*multipart.FileHeader{}
+		for i := 0; i < n; i++ {
+			headers = append(headers, newTestFileHeader(t, fmt.Sprintf("batch-%d-%d.txt", n, i), "content"))
+		}
+
+		dbtest.AssertMaxQueries(t, batchStatements+n*fileStatements, func(ctx context.Context) {
+			batch, err := files.SaveBatch(ctx, userRoot.Owner, "", headers, nil)
+			if err != nil {
+				t.Fatalf("SaveBatch() error = %v", err)
+			}
+
+			for _, save := range batch.Saves {
+				if save.Err != nil {
+					t.Fatalf("SaveBatch() %s error = %v", save.Name, save.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileServiceSaveBatchClientModTimes(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	s.validateUser = func(ctx context.Context, userID string) (Dir, error) {
+		return Dir{ID: userRoot.ID}, nil
+	}
+
+	mtime := time.Date(2024, time.March, 1, 8, 30, 0, 0, time.UTC)
+	batch, err := s.SaveBatch(context.Background(), userRoot.UserID, "", []*multipart.FileHeader{
+		newTestFileHeader(t, "a.txt", "a"),
+		newTestFileHeader(t, "b.txt", "b"),
+	}, ClientModTimes{"a.txt": mtime})
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+
+	// A file without a declared time defaults to its upload time.
+	for i, want := range []time.Time{mtime, batch.Saves[1].UploadedAt} {
+		save := batch.Saves[i]
+		if save.Err != nil {
+			t.Fatalf("SaveBatch() %s error = %v", save.Name, save.Err)
+		}
+
+		if !save.ClientModifiedAt.Equal(want) {
+			t.Errorf("%s client modified at = %s, want %s", save.Name, save.ClientModifiedAt, want)
+		}
+
+		if stored := f.data().files[save.ID].ClientModifiedAt; !stored.Equal(want) {
+			t.Errorf("%s stored client modified at = %s, want %s", save.Name, stored, want)
+		}
+	}
+}
+
+func TestFileServiceSaveBatchLastWriteAfterCopy(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	dir := addTestDir(t, f, root, userRoot, "photos")
+	s.validateUser = func(ctx context.Context, userID string) (Dir, error) {
+		return Dir{ID: userRoot.ID}, nil
+	}
+
+	// The ancestors are locked by the update, the content must already be written.
+	var written []string
+	f.failOn("UpdateLastWrite", func() error {
+		entries, err := os.ReadDir(filepath.Join(root, userRoot.ID, dir.ID))
+		for _, e := range entries {
+			written = append(written, e.Name())
+		}
+
+		return err
+	})
+
+	batch, err := s.SaveBatch(context.Background(), userRoot.UserID, dir.ID, []*multipart.FileHeader{
+		newTestFileHeader(t, "a.txt", "hello"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+
+	save := batch.Saves[0]
+	if save.Err != nil {
+		t.Fatalf("SaveBatch() error = %v", save.Err)
+	}
+
+	if len(written) != 1 || written[0] != save.ID {
+		t.Errorf("content when the last write was updated = %v, want [%s]", written, save.ID)
+	}
+
+	for _, id := range []string{userRoot.ID, dir.ID} {
+		if !f.data().dirs[id].LastWrite.Valid {
+			t.Errorf("last write of %s not set", id)
+		}
+	}
+}
+
+func TestFileServiceSaveBatchLastWriteFailure(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	s.validateUser = func(ctx context.Context, userID string) (Dir, error) {
+		return Dir{ID: userRoot.ID}, nil
+	}
+
+	f.fail("UpdateLastWrite", errors.New("deadlock detected"))
+
+	batch, err := s.SaveBatch(context.Background(), userRoot.UserID, "", []*multipart.FileHeader{
+		newTestFileHeader(t, "a.txt", "hello"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+
+	if batch.Saves[0].Err == nil {
+		t.Fatal("SaveBatch() error = nil, want the update error")
+	}
+
+	// The content was written before the update failed, it is not left behind.
+	if entries := dirEntries(t, filepath.Join(root, userRoot.ID)); len(entries) != 0 {
+		t.Errorf("file system entries = %v, want none", entries)
+	}
+
+	if files := f.data().files; len(files) != 0 {
+		t.Errorf("files = %v, want none", files)
+	}
+}
+
+func TestFileServiceInfoNotFound(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	file, _ := addTestFile(t, f, root, userRoot, "a.txt")
+	other := addTestRoot(t, f, root)
+
+	info, err := s.Info(context.Background(), userRoot.UserID, file.ID)
+	if err != nil || info.ID != file.ID {
+		t.Fatalf("Info() = %s, %v, want %s", info.ID, err, file.ID)
+	}
+
+	// A file of another user and a missing file return the same error.
+	for _, id := range []string{file.ID, uuid.NewString()} {
+		_, err := s.Info(context.Background(), other.UserID, id)
+		assertSafeError(t, err, http.StatusNotFound, ErrNotFound)
+	}
+}
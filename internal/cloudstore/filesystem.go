@@ -1,6 +1,7 @@
 package cloudstore
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -11,8 +12,19 @@ import (
 // ErrCopy signals an error occured while copying data to an io.Writer.
 var ErrCopy = errors.New("failed to copy data")
 
+// ErrSizeLimitExceeded signals more data was copied than the limit allows.
+var ErrSizeLimitExceeded = errors.New("size limit exceeded")
+
+// DefaultCopyChunkSize is the chunk size used by CopyContext when a chunk size is
+// not set.
+const DefaultCopyChunkSize = 32 * 1024
+
 // OSFileSystem is a wrapper around the io and os package file system functions.
-type OSFileSystem struct{}
+type OSFileSystem struct {
+	// Faults can force Mkdir, CopyContext, Remove, and RemoveAll to fail. If nil,
+	// they never fail on purpose.
+	Faults *Faults
+}
 
 // Set calls the os.Stat function.
 //
@@ -22,11 +34,23 @@ func (fs *OSFileSystem) Stat(name string) (fs.FileInfo, error) {
 	return os.Stat(name)
 }
 
+// Open calls the os.Open function.
+//
+// Open opens the named file for reading. If there is an error, it will be of type
+// *PathError.
+func (fs *OSFileSystem) Open(name string) (*os.File, error) {
+	return os.Open(name)
+}
+
 // Mkdir calls the os.Mkdir function.
 //
 // Mkdir creates a new directory with the specified name and permission bits
 // (before umask). If there is an error, it will be of type *PathError.
 func (fs *OSFileSystem) Mkdir(name string, perm fs.FileMode) error {
+	if err := fs.Faults.check(FaultMkdir); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
 	return os.Mkdir(name, perm)
 }
 
@@ -41,25 +65,96 @@ func (fs *OSFileSystem) Create(name string, perm fs.FileMode) (*os.File, error)
 	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 }
 
-// Copy calls the io.Copy function. If a error occurs, it will be a ErrCopy.
-//
 // Copy copies from src to dst until either EOF is reached on src or an error
 // occurs. It returns the number of bytes copied and the first error encountered
-// while copying, if any.
-//
-// A successful Copy returns err == nil, not err == EOF. Because Copy is defined
-// to read from src until EOF, it does not treat an EOF from Read as an error to
-// be reported.
+// while copying, if any. If a error occurs, it will be a ErrCopy.
 //
-// If src implements the WriterTo interface, the copy is implemented by calling
-// src.WriteTo(dst). Otherwise, if dst implements the ReaderFrom interface, the
-// copy is implemented by calling dst.ReadFrom(src).
+// Copy calls CopyContext with a background context and no options.
 func (fs *OSFileSystem) Copy(dst io.Writer, src io.Reader) (int64, error) {
-	n, err := io.Copy(dst, src)
-	if err != nil {
-		err = fmt.Errorf("%w: %v", ErrCopy, err)
+	return fs.CopyContext(context.Background(), dst, src, CopyOptions{})
+}
+
+// CopyOptions is the options when copying with CopyContext.
+type CopyOptions struct {
+	// The number of bytes read from src at a time. If not set, it will default
+	// to DefaultCopyChunkSize.
+	ChunkSize int
+
+	// The maximum number of bytes that may be copied. If not set, there is no
+	// limit.
+	MaxBytes int64
+
+	// Progress is called after every chunk is written with the total number of
+	// bytes written. It is optional.
+	Progress func(written int64)
+}
+
+// CopyContext copies from src to dst in chunks until either EOF is reached on src
+// or an error occurs. It returns the number of bytes copied and the first error
+// encountered while copying, if any.
+//
+// The context is checked before every chunk. If it is done, the copy stops and the
+// error is a ErrCopy wrapping the context error.
+//
+// If src has more than MaxBytes, the copy stops before writing the chunk that
+// exceeds the limit and the error is a ErrSizeLimitExceeded. All other errors are
+// a ErrCopy. If dst failed because the file system is full, the error is also a
+// ErrStorageFull.
+func (fs *OSFileSystem) CopyContext(ctx context.Context, dst io.Writer, src io.Reader, opts CopyOptions) (int64, error) {
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultCopyChunkSize
+	}
+
+	if err := fs.Faults.check(FaultCopy); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrCopy, err)
+	}
+
+	buf := make([]byte, chunkSize)
+	var written int64
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, fmt.Errorf("%w: %w", ErrCopy, err)
+		}
+
+		nr, readErr := src.Read(buf)
+		if nr > 0 {
+			if opts.MaxBytes > 0 && written+int64(nr) > opts.MaxBytes {
+				return written, fmt.Errorf("%w: more than %d bytes", ErrSizeLimitExceeded, opts.MaxBytes)
+			}
+
+			nw, err := dst.Write(buf[:nr])
+			written += int64(nw)
+			if err != nil {
+				return written, storageFull(fmt.Errorf("%w: %w", ErrCopy, err))
+			}
+
+			if nw != nr {
+				return written, fmt.Errorf("%w: %v", ErrCopy, io.ErrShortWrite)
+			}
+
+			if opts.Progress != nil {
+				opts.Progress(written)
+			}
+		}
+
+		if readErr == io.EOF {
+			return written, nil
+		}
+
+		if readErr != nil {
+			return written, fmt.Errorf("%w: %v", ErrCopy, readErr)
+		}
 	}
-	return n, err
+}
+
+// Rename calls the os.Rename function.
+//
+// Rename renames (moves) oldpath to newpath. If newpath already exists and is not a
+// directory, Rename replaces it. If there is an error, it will be of type *LinkError.
+func (fs *OSFileSystem) Rename(oldpath string, newpath string) error {
+	return os.Rename(oldpath, newpath)
 }
 
 // Remove calls the os.Remove function.
@@ -67,6 +162,10 @@ func (fs *OSFileSystem) Copy(dst io.Writer, src io.Reader) (int64, error) {
 // Remove removes the named file or (empty) directory. If there is an error, it
 // will be of type *PathError.
 func (fs *OSFileSystem) Remove(name string) error {
+	if err := fs.Faults.check(FaultRemove); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
 	return os.Remove(name)
 }
 
@@ -77,9 +176,39 @@ func (fs *OSFileSystem) Remove(name string) error {
 // RemoveAll returns nil (no error). If there is an error, it will be of type
 // *PathError.
 func (fs *OSFileSystem) RemoveAll(path string) error {
+	if err := fs.Faults.check(FaultRemove); err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+
 	return os.RemoveAll(path)
 }
 
+// ReadDir calls the os.ReadDir function.
+//
+// ReadDir reads the named directory, returning all its directory entries sorted
+// by filename. If an error occurs reading the directory, ReadDir returns the
+// entries it was able to read before the error, along with the error.
+func (fs *OSFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// WriteFile calls the os.WriteFile function.
+//
+// WriteFile writes data to the named file, creating it if necessary. If the file
+// does not exist, WriteFile creates it with permissions perm; otherwise WriteFile
+// truncates it before writing, without changing permissions.
+func (fs *OSFileSystem) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+// ReadFile calls the os.ReadFile function.
+//
+// ReadFile reads the named file and returns the contents. A successful call
+// returns err == nil, not err == EOF.
+func (fs *OSFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
 // IsNotExist calls the os.IsNotExist function.
 //
 // IsNotExist returns a boolean indicating whether the error is known to
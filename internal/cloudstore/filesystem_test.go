@@ -0,0 +1,90 @@
+package cloudstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// shortWriter writes at most n bytes of every write.
+type shortWriter struct {
+	n int
+}
+
+func (w shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return w.n, nil
+	}
+
+	return len(p), nil
+}
+
+func TestCopyContext(t *testing.T) {
+	fs := &OSFileSystem{}
+	src := strings.Repeat("a", 10)
+
+	t.Run("chunks and progress", func(t *testing.T) {
+		var dst bytes.Buffer
+		var progress []int64
+
+		n, err := fs.CopyContext(context.Background(), &dst, strings.NewReader(src), CopyOptions{
+			ChunkSize: 4,
+			Progress:  func(written int64) { progress = append(progress, written) },
+		})
+		if err != nil || n != 10 || dst.String() != src {
+			t.Fatalf("CopyContext() = %d, %v, copied %q, want 10 bytes", n, err, dst.String())
+		}
+
+		if got := fmt.Sprint(progress); got != "[4 8 10]" {
+			t.Errorf("progress = %s, want [4 8 10]", got)
+		}
+	})
+
+	t.Run("at the limit", func(t *testing.T) {
+		var dst bytes.Buffer
+		n, err := fs.CopyContext(context.Background(), &dst, strings.NewReader(src), CopyOptions{ChunkSize: 4, MaxBytes: 10})
+		if err != nil || n != 10 {
+			t.Errorf("CopyContext() = %d, %v, want 10 bytes", n, err)
+		}
+	})
+
+	t.Run("over the limit", func(t *testing.T) {
+		var dst bytes.Buffer
+		n, err := fs.CopyContext(context.Background(), &dst, strings.NewReader(src), CopyOptions{ChunkSize: 4, MaxBytes: 9})
+		if !errors.Is(err, ErrSizeLimitExceeded) {
+			t.Fatalf("CopyContext() error = %v, want ErrSizeLimitExceeded", err)
+		}
+
+		// The chunk that exceeds the limit is not written.
+		if n != 8 || dst.Len() != 8 {
+			t.Errorf("CopyContext() = %d, wrote %d bytes, want 8", n, dst.Len())
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var dst bytes.Buffer
+		n, err := fs.CopyContext(ctx, &dst, strings.NewReader(src), CopyOptions{
+			ChunkSize: 4,
+			Progress:  func(written int64) { cancel() },
+		})
+		if !errors.Is(err, ErrCopy) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("CopyContext() error = %v, want ErrCopy wrapping context.Canceled", err)
+		}
+
+		if n != 4 {
+			t.Errorf("CopyContext() = %d, want the copy stopped after the first chunk", n)
+		}
+	})
+
+	t.Run("short write", func(t *testing.T) {
+		_, err := fs.CopyContext(context.Background(), shortWriter{n: 2}, strings.NewReader(src), CopyOptions{ChunkSize: 4})
+		if !errors.Is(err, ErrCopy) {
+			t.Errorf("CopyContext() error = %v, want ErrCopy", err)
+		}
+	})
+}
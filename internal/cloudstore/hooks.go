@@ -0,0 +1,263 @@
+package cloudstore
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/cicconee/clox/internal/hook"
+)
+
+// The cloudstore hook points.
+const (
+	HookAfterFileSaved   = "cloudstore.AfterFileSaved"
+	HookAfterFileDeleted = "cloudstore.AfterFileDeleted"
+	HookAfterFileRenamed = "cloudstore.AfterFileRenamed"
+	HookAfterFileMoved   = "cloudstore.AfterFileMoved"
+	HookAfterDirCreated  = "cloudstore.AfterDirCreated"
+	HookAfterDirDeleted  = "cloudstore.AfterDirDeleted"
+	HookAfterDirRenamed  = "cloudstore.AfterDirRenamed"
+	HookAfterDirMoved    = "cloudstore.AfterDirMoved"
+)
+
+// Hooks are the callbacks run after the cloudstore services change a users storage. They are
+// run on a hook.Queue once the change is committed, every callback is queued on its own.
+//
+// Callbacks should be registered before the services are used. A nil Hooks is valid, it runs
+// nothing.
+//
+// Hooks should be created using the NewHooks function.
+type Hooks struct {
+	queue *hook.Queue
+
+	mu          sync.RWMutex
+	fileSaved   []func(ctx context.Context, f FileInfo)
+	fileDeleted []func(ctx context.Context, f FileInfo)
+	fileRenamed []func(ctx context.Context, c FileChange)
+	fileMoved   []func(ctx context.Context, c FileChange)
+	dirCreated  []func(ctx context.Context, d Dir)
+	dirDeleted  []func(ctx context.Context, d Dir)
+	dirRenamed  []func(ctx context.Context, c DirChange)
+	dirMoved    []func(ctx context.Context, c DirChange)
+}
+
+// NewHooks creates a new Hooks that runs its callbacks on queue.
+func NewHooks(queue *hook.Queue) *Hooks {
+	return &Hooks{queue: queue}
+}
+
+// AfterFileSaved registers fn to be called after a file is uploaded.
+func (h *Hooks) AfterFileSaved(fn func(ctx context.Context, f FileInfo)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fileSaved = append(h.fileSaved, fn)
+}
+
+// AfterFileDeleted registers fn to be called after a file is deleted. The FileInfo is the
+// file as it was before it was deleted, its Size and content fields are not set.
+func (h *Hooks) AfterFileDeleted(fn func(ctx context.Context, f FileInfo)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fileDeleted = append(h.fileDeleted, fn)
+}
+
+// AfterFileRenamed registers fn to be called after a file is renamed.
+func (h *Hooks) AfterFileRenamed(fn func(ctx context.Context, c FileChange)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fileRenamed = append(h.fileRenamed, fn)
+}
+
+// AfterFileMoved registers fn to be called after a file is moved to another directory.
+func (h *Hooks) AfterFileMoved(fn func(ctx context.Context, c FileChange)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fileMoved = append(h.fileMoved, fn)
+}
+
+// AfterDirCreated registers fn to be called after a directory is created, including the root
+// directory of a user.
+func (h *Hooks) AfterDirCreated(fn func(ctx context.Context, d Dir)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.dirCreated = append(h.dirCreated, fn)
+}
+
+// AfterDirDeleted registers fn to be called after a directory is deleted with every directory
+// and file under it. The Dir is the directory as it was before it was deleted.
+func (h *Hooks) AfterDirDeleted(fn func(ctx context.Context, d Dir)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.dirDeleted = append(h.dirDeleted, fn)
+}
+
+// AfterDirRenamed registers fn to be called after a directory is renamed.
+func (h *Hooks) AfterDirRenamed(fn func(ctx context.Context, c DirChange)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.dirRenamed = append(h.dirRenamed, fn)
+}
+
+// AfterDirMoved registers fn to be called after a directory is moved under another
+// directory with every directory and file under it.
+func (h *Hooks) AfterDirMoved(fn func(ctx context.Context, c DirChange)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.dirMoved = append(h.dirMoved, fn)
+}
+
+// Log registers callbacks that log every hook point as a key=value line to logger.
+func (h *Hooks) Log(logger *log.Logger) {
+	h.AfterFileSaved(func(ctx context.Context, f FileInfo) {
+		logger.Printf("[INFO] hook=%s user_id=%s file_id=%s directory_id=%s path=%q size=%d\n",
+			HookAfterFileSaved, f.OwnerID, f.ID, f.DirectoryID, f.Path, f.Size)
+	})
+
+	h.AfterFileDeleted(func(ctx context.Context, f FileInfo) {
+		logger.Printf("[INFO] hook=%s user_id=%s file_id=%s directory_id=%s path=%q\n",
+			HookAfterFileDeleted, f.OwnerID, f.ID, f.DirectoryID, f.Path)
+	})
+
+	h.AfterFileRenamed(func(ctx context.Context, c FileChange) {
+		logger.Printf("[INFO] hook=%s user_id=%s file_id=%s directory_id=%s path=%q old_path=%q\n",
+			HookAfterFileRenamed, c.File.OwnerID, c.File.ID, c.File.DirectoryID, c.File.Path, c.OldPath)
+	})
+
+	h.AfterFileMoved(func(ctx context.Context, c FileChange) {
+		logger.Printf("[INFO] hook=%s user_id=%s file_id=%s directory_id=%s old_directory_id=%s path=%q old_path=%q\n",
+			HookAfterFileMoved, c.File.OwnerID, c.File.ID, c.File.DirectoryID, c.OldDirectoryID, c.File.Path, c.OldPath)
+	})
+
+	h.AfterDirCreated(func(ctx context.Context, d Dir) {
+		logger.Printf("[INFO] hook=%s user_id=%s directory_id=%s parent_id=%s path=%q created_via=%s\n",
+			HookAfterDirCreated, d.Owner, d.ID, d.ParentID, d.Path, d.CreatedVia)
+	})
+
+	h.AfterDirDeleted(func(ctx context.Context, d Dir) {
+		logger.Printf("[INFO] hook=%s user_id=%s directory_id=%s parent_id=%s path=%q\n",
+			HookAfterDirDeleted, d.Owner, d.ID, d.ParentID, d.Path)
+	})
+
+	h.AfterDirRenamed(func(ctx context.Context, c DirChange) {
+		logger.Printf("[INFO] hook=%s user_id=%s directory_id=%s parent_id=%s path=%q old_path=%q\n",
+			HookAfterDirRenamed, c.Dir.Owner, c.Dir.ID, c.Dir.ParentID, c.Dir.Path, c.OldPath)
+	})
+
+	h.AfterDirMoved(func(ctx context.Context, c DirChange) {
+		logger.Printf("[INFO] hook=%s user_id=%s directory_id=%s parent_id=%s old_parent_id=%s path=%q old_path=%q\n",
+			HookAfterDirMoved, c.Dir.Owner, c.Dir.ID, c.Dir.ParentID, c.OldParentID, c.Dir.Path, c.OldPath)
+	})
+}
+
+// runFileSaved queues the AfterFileSaved callbacks with f.
+func (h *Hooks) runFileSaved(f FileInfo) {
+	if h == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	enqueue(h.queue, HookAfterFileSaved, h.fileSaved, f)
+}
+
+// runFileDeleted queues the AfterFileDeleted callbacks with f.
+func (h *Hooks) runFileDeleted(f FileInfo) {
+	if h == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	enqueue(h.queue, HookAfterFileDeleted, h.fileDeleted, f)
+}
+
+// runFileRenamed queues the AfterFileRenamed callbacks with c.
+func (h *Hooks) runFileRenamed(c FileChange) {
+	if h == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	enqueue(h.queue, HookAfterFileRenamed, h.fileRenamed, c)
+}
+
+// runFileMoved queues the AfterFileMoved callbacks with c.
+func (h *Hooks) runFileMoved(c FileChange) {
+	if h == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	enqueue(h.queue, HookAfterFileMoved, h.fileMoved, c)
+}
+
+// runDirCreated queues the AfterDirCreated callbacks with d.
+func (h *Hooks) runDirCreated(d Dir) {
+	if h == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	enqueue(h.queue, HookAfterDirCreated, h.dirCreated, d)
+}
+
+// runDirDeleted queues the AfterDirDeleted callbacks with d.
+func (h *Hooks) runDirDeleted(d Dir) {
+	if h == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	enqueue(h.queue, HookAfterDirDeleted, h.dirDeleted, d)
+}
+
+// runDirRenamed queues the AfterDirRenamed callbacks with c.
+func (h *Hooks) runDirRenamed(c DirChange) {
+	if h == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	enqueue(h.queue, HookAfterDirRenamed, h.dirRenamed, c)
+}
+
+// runDirMoved queues the AfterDirMoved callbacks with c.
+func (h *Hooks) runDirMoved(c DirChange) {
+	if h == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	enqueue(h.queue, HookAfterDirMoved, h.dirMoved, c)
+}
+
+// enqueue queues every callback of fns with v on queue, each on its own, under the hook
+// point name.
+func enqueue[T any](queue *hook.Queue, name string, fns []func(ctx context.Context, v T), v T) {
+	for _, fn := range fns {
+		fn := fn
+		queue.Enqueue(name, func(ctx context.Context) { fn(ctx, v) })
+	}
+}
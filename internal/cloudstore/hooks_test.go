@@ -0,0 +1,120 @@
+package cloudstore
+
+import (
+	"context"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/hook"
+)
+
+// newTestHooks creates Hooks on a Queue that is run until the test ends.
+func newTestHooks(t *testing.T) *Hooks {
+	t.Helper()
+
+	q := hook.NewQueue(0, log.New(io.Discard, "", 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return NewHooks(q)
+}
+
+// receive returns the next value sent on c, it fails t if none is sent within a second.
+func receive[T any](t *testing.T, c <-chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("hook was not run")
+	}
+
+	var zero T
+	return zero
+}
+
+func TestHooksNil(t *testing.T) {
+	var h *Hooks
+
+	// A nil Hooks runs nothing.
+	h.runFileSaved(FileInfo{})
+	h.runDirCreated(Dir{})
+}
+
+func TestDirServiceNewHooks(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+
+	created := make(chan Dir, 2)
+	s.hooks = newTestHooks(t)
+	s.hooks.AfterDirCreated(func(ctx context.Context, d Dir) { created <- d })
+
+	dir, err := s.New(context.Background(), userRoot.UserID, "photos", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if got := receive(t, created); got.ID != dir.ID || got.Owner != userRoot.UserID {
+		t.Errorf("AfterDirCreated directory = %s owned by %s, want %s owned by %s", got.ID, got.Owner, dir.ID, userRoot.UserID)
+	}
+
+	// A failed create runs no hooks.
+	_, err = s.New(context.Background(), userRoot.UserID, "photos", "")
+	assertSafeError(t, err, http.StatusBadRequest, ErrUniqueNameParentID)
+
+	select {
+	case d := <-created:
+		t.Errorf("AfterDirCreated run with %s after a failed create", d.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFileServiceHooks(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	s.validateUser = func(ctx context.Context, userID string) (Dir, error) {
+		return Dir{ID: userRoot.ID}, nil
+	}
+
+	saved := make(chan FileInfo, 1)
+	renamed := make(chan FileChange, 1)
+	s.hooks = newTestHooks(t)
+	s.hooks.AfterFileSaved(func(ctx context.Context, f FileInfo) { saved <- f })
+	s.hooks.AfterFileRenamed(func(ctx context.Context, c FileChange) { renamed <- c })
+
+	batch, err := s.SaveBatch(context.Background(), userRoot.UserID, "", []*multipart.FileHeader{
+		newTestFileHeader(t, "a.txt", "hello"),
+	}, nil)
+	if err != nil || batch.Saves[0].Err != nil {
+		t.Fatalf("SaveBatch() error = %v, %v", err, batch.Saves[0].Err)
+	}
+
+	if got := receive(t, saved); got.ID != batch.Saves[0].ID || got.Size != 5 {
+		t.Errorf("AfterFileSaved file = %s of %d bytes, want %s of 5 bytes", got.ID, got.Size, batch.Saves[0].ID)
+	}
+
+	if _, err := s.Rename(context.Background(), userRoot.UserID, batch.Saves[0].ID, "b.txt"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	change := receive(t, renamed)
+	if change.File.Name != "b.txt" || change.OldPath == change.File.Path {
+		t.Errorf("AfterFileRenamed = %s from %q to %q, want it renamed to b.txt", change.File.Name, change.OldPath, change.File.Path)
+	}
+}
@@ -3,64 +3,107 @@ package cloudstore
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	goio "io"
 	"io/fs"
 	"mime/multipart"
+	"path/filepath"
 	"time"
+
+	"github.com/cicconee/clox/internal/event"
 )
 
 type IO struct {
 	fs    *OSFileSystem
-	paths *PathMapper
+	paths *FSPathMapper
 }
 
-func NewIO(fs *OSFileSystem, paths *PathMapper) *IO {
+func NewIO(fs *OSFileSystem, paths *FSPathMapper) *IO {
 	return &IO{fs: fs, paths: paths}
 }
 
-// SetupFSRoot will validate that the root storage directory exists. If it
-// does not exist, it will be created.
+// SetupRoot validates that the root storage directory of the FSPathMapper exists. If it
+// does not exist, it is created with perm.
+//
+// SetupRoot is idempotent, it may be called by every binary that shares the file store,
+// and it is not an error if another binary creates the root at the same time. A root
+// that exists is left as is, even if its permissions are not perm, but it must be a
+// directory that grants the owner read, write, and execute.
 //
-// This method should be called once before executing any other methods.
-func (io *IO) SetupFSRoot(perm fs.FileMode) error {
+// It should be called once before executing any other methods, see bootstrap.Open.
+func (io *IO) SetupRoot(perm Perm) error {
 	path := io.paths.Root()
 
-	_, err := io.fs.Stat(path)
-	if err != nil {
-		if io.fs.IsNotExist(err) {
-			if err := io.fs.Mkdir(path, perm); err != nil {
-				return fmt.Errorf("creating directory [%s]: %w", path, err)
-			}
-
+	info, err := io.fs.Stat(path)
+	if err != nil && io.fs.IsNotExist(err) {
+		err = io.fs.Mkdir(path, perm.FileMode())
+		if err == nil {
 			return nil
 		}
 
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("creating directory [%s]: %w", path, err)
+		}
+
+		info, err = io.fs.Stat(path)
+	}
+	if err != nil {
 		return fmt.Errorf("getting file info [%s]: %w", path, err)
 	}
 
+	if !info.IsDir() {
+		return fmt.Errorf("root [%s] is not a directory", path)
+	}
+
+	if Perm(info.Mode().Perm())&requiredDirPerm != requiredDirPerm {
+		return fmt.Errorf("%w: root [%s] is %s, it must grant the owner %s", ErrInvalidPerm, path, Perm(info.Mode().Perm()), requiredDirPerm)
+	}
+
 	return nil
 }
 
 // NewDirIO is the parameters when creating a new directory.
 type NewDirIO struct {
-	ID        string
-	UserID    string
-	Name      string
-	ParentID  sql.NullString
-	CreatedAt time.Time
-	FSPerm    fs.FileMode
+	ID       string
+	UserID   string
+	Name     string
+	ParentID sql.NullString
+	FSPerm   Perm
+
+	// Layout is the file system layout of the directory. If not set, it will default to
+	// LayoutFlat.
+	Layout Layout
+
+	// CreatedVia is the path that created the directory. If not set, it will default to
+	// CreatedExplicit.
+	CreatedVia CreatedVia
 }
 
 // NewDir writes a directory to the file system and persists its information
 // in the database. If the directory is a sub directory, all the ancesteral
 // paths are persisted. The directory is returned as a Dir.
-func (io *IO) NewDir(ctx context.Context, q *Query, d NewDirIO) (Dir, error) {
-	err := q.InsertDirectory(ctx, InsertDirectoryConfig{
-		ID:        d.ID,
-		UserID:    d.UserID,
-		Name:      d.Name,
-		ParentID:  d.ParentID,
-		CreatedAt: d.CreatedAt,
+func (io *IO) NewDir(ctx context.Context, q Tx, d NewDirIO) (Dir, error) {
+	if d.CreatedVia == "" {
+		d.CreatedVia = CreatedExplicit
+	}
+
+	// The parent must not change structure while the child is added to it.
+	if d.ParentID.Valid {
+		if err := q.LockDirectories(ctx, d.ParentID.String); err != nil {
+			return Dir{}, err
+		}
+	}
+
+	// The creation time is set by the database, so it never depends on the clock of
+	// the server that created the directory.
+	createdAt, err := q.InsertDirectory(ctx, InsertDirectoryConfig{
+		ID:         d.ID,
+		UserID:     d.UserID,
+		Name:       d.Name,
+		ParentID:   d.ParentID,
+		Layout:     d.Layout,
+		CreatedVia: d.CreatedVia,
 	})
 	if err != nil {
 		return Dir{}, err
@@ -91,33 +134,67 @@ func (io *IO) NewDir(ctx context.Context, q *Query, d NewDirIO) (Dir, error) {
 		return Dir{}, err
 	}
 
-	if err := io.fs.Mkdir(fsPath, d.FSPerm); err != nil {
+	err = q.InsertEvent(ctx, d.UserID, event.TypeDirCreated, event.DirCreated{
+		ID:         d.ID,
+		ParentID:   d.ParentID.String,
+		Name:       d.Name,
+		Path:       userPath,
+		CreatedVia: string(d.CreatedVia),
+	})
+	if err != nil {
+		return Dir{}, err
+	}
+
+	if err := io.fs.Mkdir(fsPath, d.FSPerm.FileMode()); err != nil {
 		return Dir{}, fmt.Errorf("creating directory [%s]: %w", fsPath, err)
 	}
 
+	// Updating the last write locks the rows of every ancestor up to the root, it is
+	// done last so the locks are held for as short as possible.
+	var touched []string
+	if d.ParentID.Valid {
+		touched, err = q.UpdateLastWrite(ctx, d.ParentID.String)
+		if err != nil {
+			io.fs.Remove(fsPath)
+			return Dir{}, err
+		}
+	}
+
 	return Dir{
-		ID:        d.ID,
-		Owner:     d.UserID,
-		ParentID:  d.ParentID.String,
-		Name:      d.Name,
-		Path:      userPath,
-		CreatedAt: d.CreatedAt,
-		UpdatedAt: time.Time{},
-		LastWrite: time.Time{},
-		fsPath:    fsPath,
+		ID:         d.ID,
+		Owner:      d.UserID,
+		ParentID:   d.ParentID.String,
+		Name:       d.Name,
+		Path:       userPath,
+		CreatedAt:  createdAt,
+		UpdatedAt:  time.Time{},
+		LastWrite:  time.Time{},
+		CreatedVia: d.CreatedVia,
+		fsPath:     fsPath,
+		touched:    touched,
 	}, nil
 }
 
 // RemoveFSDir accepts the path to a directory and removes it from the file system.
 // All sub directories and files will be removed.
-func (io *IO) RemoveFSDir(fsPath string) error {
-	return io.fs.RemoveAll(fsPath)
+//
+// Transient errors are retried with backoff. The outcome is returned as a RemoveResult.
+func (io *IO) RemoveFSDir(fsPath string) RemoveResult {
+	return io.remove(fsPath, true, io.fs.RemoveAll)
 }
 
 // RemoveFS accpets the path to a file or (empty) directory and removes it from the
 // file system.
-func (io *IO) RemoveFS(fsPath string) error {
-	return io.fs.Remove(fsPath)
+//
+// Transient errors are retried with backoff. The outcome is returned as a RemoveResult.
+func (io *IO) RemoveFS(fsPath string) RemoveResult {
+	return io.remove(fsPath, false, io.fs.Remove)
+}
+
+// MoveFS moves the file or directory at the path from to the path to on the file
+// system. If to is an existing file, it is replaced.
+func (io *IO) MoveFS(from string, to string) error {
+	return io.fs.Rename(from, to)
 }
 
 // NewFileIO is the parameters when creating a new file.
@@ -125,25 +202,55 @@ type NewFileIO struct {
 	ID          string
 	UserID      string
 	DirectoryID string
-	UploadedAt  time.Time
 	Header      *multipart.FileHeader
-	FSPerm      fs.FileMode
+	FSPerm      Perm
+
+	// ShardPerm is the permission of the shard the file is stored in if the directory
+	// uses LayoutFanOut. If not set, it will default to DefaultDirPerm.
+	ShardPerm Perm
+
+	// ClientModifiedAt is the modification time declared by the client. If zero, it
+	// defaults to the upload time.
+	ClientModifiedAt time.Time
+
+	// Staged writes the content to the staged path of the file and inserts it as
+	// FilePending. The file is not readable until it is promoted with PromoteFile.
+	Staged bool
 }
 
 // NewFile writes a file under a specified directory on the file system and
 // persists its information to the database. The file is returned as a FileInfo.
-func (io *IO) NewFile(ctx context.Context, q *Query, f NewFileIO) (FileInfo, error) {
+//
+// If f.Staged is true, the content is written to the staged path of the file
+// instead, see PromoteFile.
+func (io *IO) NewFile(ctx context.Context, q Tx, f NewFileIO) (FileInfo, error) {
 	file, err := f.Header.Open()
 	if err != nil {
 		return FileInfo{}, err
 	}
+	defer file.Close()
 
-	err = q.InsertFile(ctx, InsertFileConfig{
-		ID:          f.ID,
-		UserID:      f.UserID,
-		DirectoryID: f.DirectoryID,
-		Name:        f.Header.Filename,
-		UploadedAt:  time.Now().UTC(),
+	status := FileReady
+	if f.Staged {
+		status = FilePending
+	}
+
+	// A directory that is moved or deleted is locked with its subtree, the file is not
+	// written to a directory while it is moved or deleted.
+	if err := q.LockDirectories(ctx, f.DirectoryID); err != nil {
+		return FileInfo{}, err
+	}
+
+	// The upload time is set by the database, so it never depends on the clock of the
+	// server that received the upload.
+	inserted, err := q.InsertFile(ctx, InsertFileConfig{
+		ID:               f.ID,
+		UserID:           f.UserID,
+		DirectoryID:      f.DirectoryID,
+		Name:             f.Header.Filename,
+		Size:             f.Header.Size,
+		ClientModifiedAt: f.ClientModifiedAt,
+		Status:           status,
 	})
 	if err != nil {
 		return FileInfo{}, err
@@ -159,42 +266,148 @@ func (io *IO) NewFile(ctx context.Context, q *Query, f NewFileIO) (FileInfo, err
 		return FileInfo{}, err
 	}
 
-	// Create the file and set the file permissions on the file system.
-	dst, err := io.fs.Create(fsPath, f.FSPerm)
+	layout, err := q.SelectDirectoryLayout(ctx, f.DirectoryID)
 	if err != nil {
 		return FileInfo{}, err
 	}
 
-	// Write the file content to the file on the file system.
-	_, err = io.fs.Copy(dst, file)
+	if layout == LayoutFanOut {
+		if f.ShardPerm == 0 {
+			f.ShardPerm = DefaultDirPerm
+		}
+
+		if err := io.mkdirShard(fsPath, f.ShardPerm); err != nil {
+			return FileInfo{}, storageFull(fmt.Errorf("creating shard [%s]: %w", filepath.Dir(fsPath), err))
+		}
+	}
+
+	writePath := fsPath
+	if f.Staged {
+		writePath = stagedPath(fsPath)
+	}
+
+	// Create the file and set the file permissions on the file system.
+	dst, err := io.fs.Create(writePath, f.FSPerm.FileMode())
 	if err != nil {
+		return FileInfo{}, storageFull(err)
+	}
+
+	// Write the file content to the file on the file system. Zero-byte files
+	// are valid, the file is still created and persisted with a size of 0.
+	cw := newContentWriter()
+	cw.indexText(f.Header.Filename, f.Header.Size)
+	size, err := io.fs.CopyContext(ctx, goio.MultiWriter(dst, cw), file, CopyOptions{})
+	if err != nil {
+		// The copy may have stopped part way through, do not leave a partial file.
+		dst.Close()
+		io.fs.Remove(writePath)
 		return FileInfo{}, err
 	}
-	dst.Close()
 
-	return FileInfo{
+	if err := dst.Close(); err != nil {
+		// Delayed write errors, such as the file system being full, may only be reported
+		// on close.
+		io.fs.Remove(writePath)
+		return FileInfo{}, storageFull(fmt.Errorf("%w: closing file [%s]: %w", ErrCopy, writePath, err))
+	}
+
+	content := cw.content()
+	if err := q.UpdateFileContent(ctx, f.ID, content); err != nil {
+		io.fs.Remove(writePath)
+		return FileInfo{}, err
+	}
+
+	// The size is only known once the file is written, so the event is inserted last.
+	err = q.InsertEvent(ctx, f.UserID, event.TypeFileUploaded, event.FileUploaded{
 		ID:          f.ID,
-		OwnerID:     f.UserID,
 		DirectoryID: f.DirectoryID,
 		Name:        f.Header.Filename,
 		Path:        userPath,
-		Size:        f.Header.Size,
-		UploadedAt:  f.UploadedAt.UTC(),
-		FSPath:      fsPath,
+		Size:        size,
+		ModifiedAt:  inserted.ClientModifiedAt,
+	})
+	if err != nil {
+		io.fs.Remove(writePath)
+		return FileInfo{}, err
+	}
+
+	// Updating the last write locks the rows of every ancestor up to the root. It is
+	// done after the content is copied, so the uploads of a user are not serialized on
+	// the root while they stream.
+	touched, err := q.UpdateLastWrite(ctx, f.DirectoryID)
+	if err != nil {
+		io.fs.Remove(writePath)
+		return FileInfo{}, err
+	}
+
+	return FileInfo{
+		ID:               f.ID,
+		OwnerID:          f.UserID,
+		DirectoryID:      f.DirectoryID,
+		Name:             f.Header.Filename,
+		Path:             userPath,
+		Size:             size,
+		UploadedAt:       inserted.UploadedAt,
+		ClientModifiedAt: inserted.ClientModifiedAt,
+		FSPath:           fsPath,
+		Checksum:         content.Checksum,
+		ContentType:      content.ContentType,
+		writePath:        writePath,
+		touched:          touched,
 	}, nil
 }
 
+// PromoteFile renames the staged content of a FilePending file into place at fsPath
+// and sets the file to FileReady. If the rename succeeds and the update fails, the
+// file is left in place and it is still FilePending, calling PromoteFile again only
+// updates it.
+func (io *IO) PromoteFile(ctx context.Context, q Queries, id string, fsPath string) error {
+	if err := io.fs.Rename(stagedPath(fsPath), fsPath); err != nil && !io.promoted(fsPath, err) {
+		return fmt.Errorf("renaming staged file [%s]: %w", fsPath, err)
+	}
+
+	if err := q.UpdateFileStatus(ctx, id, FileReady); err != nil {
+		return fmt.Errorf("updating file status [id: %s]: %w", id, err)
+	}
+
+	return nil
+}
+
+// promoted returns true if err is the error of renaming a staged file that was
+// already renamed into place at fsPath.
+func (io *IO) promoted(fsPath string, err error) bool {
+	if !io.fs.IsNotExist(err) {
+		return false
+	}
+
+	_, statErr := io.fs.Stat(fsPath)
+	return statErr == nil
+}
+
+// mkdirShard creates the shard directory of the file at fsPath if it does not exist.
+func (io *IO) mkdirShard(fsPath string, perm Perm) error {
+	err := io.fs.Mkdir(filepath.Dir(fsPath), perm.FileMode())
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	return nil
+}
+
 type ReadFileInfoIO struct {
-	UserID string
 	FileID string
 }
 
-// FileInfo gets the information for a users file. The information is gathered
-// from both the database and file system, and returns it as a FileInfo.
+// FileInfo gets the information for a file. The information is gathered from
+// both the database and file system, and returns it as a FileInfo.
+//
+// ReadFileInfo does not check if a user may read the file, use Access before
+// calling ReadFileInfo. The actual file content is not returned by this function.
 //
-// The actual file content is not returned by this function.
-func (io *IO) ReadFileInfo(ctx context.Context, q *Query, f ReadFileInfoIO) (FileInfo, error) {
-	row, err := q.SelectFileByIDUser(ctx, f.FileID, f.UserID)
+// If the content of the file is not on the file system, the FileInfo without its
+// Size is returned with a error wrapping ErrContentMissing.
+func (io *IO) ReadFileInfo(ctx context.Context, q Queries, f ReadFileInfoIO) (FileInfo, error) {
+	row, err := q.SelectFileByID(ctx, f.FileID)
 	if err != nil {
 		return FileInfo{}, err
 	}
@@ -209,20 +422,30 @@ func (io *IO) ReadFileInfo(ctx context.Context, q *Query, f ReadFileInfoIO) (Fil
 		return FileInfo{}, err
 	}
 
+	info := FileInfo{
+		ID:               row.ID,
+		OwnerID:          row.UserID,
+		DirectoryID:      row.DirectoryID,
+		Name:             row.Name,
+		Path:             userPath,
+		UploadedAt:       row.UploadedAt.UTC(),
+		ClientModifiedAt: row.ClientModifiedAt.UTC(),
+		FSPath:           fsPath,
+		Checksum:         row.Checksum.String,
+		ContentType:      row.ContentType.String,
+	}
+
 	// Get the file size on the file system.
 	stat, err := io.fs.Stat(fsPath)
 	if err != nil {
+		if io.fs.IsNotExist(err) {
+			return info, fmt.Errorf("%w [id: %s, path: %s]: %w", ErrContentMissing, row.ID, fsPath, err)
+		}
+
 		return FileInfo{}, err
 	}
 
-	return FileInfo{
-		ID:          row.ID,
-		OwnerID:     row.UserID,
-		DirectoryID: row.DirectoryID,
-		Name:        row.Name,
-		Path:        userPath,
-		Size:        stat.Size(),
-		UploadedAt:  row.UploadedAt.UTC(),
-		FSPath:      fsPath,
-	}, nil
+	info.Size = stat.Size()
+
+	return info, nil
 }
@@ -0,0 +1,125 @@
+package cloudstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestIOSetupRoot(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// setup creates what is at the root path before SetupRoot.
+		setup func(t *testing.T, path string)
+
+		// wantPerm is the permission of the root after SetupRoot.
+		wantPerm Perm
+
+		// wantErr is in the error, SetupRoot succeeds if it is empty.
+		wantErr string
+
+		// wantIs is wrapped by the error, if it is set.
+		wantIs error
+	}{
+		{
+			name:     "fresh root",
+			setup:    func(t *testing.T, path string) {},
+			wantPerm: 0700,
+		},
+		{
+			// A root that exists is left with its permission.
+			name: "already exists",
+			setup: func(t *testing.T, path string) {
+				if err := os.Mkdir(path, 0750); err != nil {
+					t.Fatalf("creating root: %v", err)
+				}
+			},
+			wantPerm: 0750,
+		},
+		{
+			name: "exists as a file",
+			setup: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("not a directory"), 0600); err != nil {
+					t.Fatalf("creating file: %v", err)
+				}
+			},
+			wantErr: "is not a directory",
+		},
+		{
+			name: "permission mismatch",
+			setup: func(t *testing.T, path string) {
+				if err := os.Mkdir(path, 0500); err != nil {
+					t.Fatalf("creating root: %v", err)
+				}
+				t.Cleanup(func() { os.Chmod(path, 0700) })
+			},
+			wantErr: "it must grant the owner",
+			wantIs:  ErrInvalidPerm,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store")
+			tc.setup(t, path)
+
+			fsIO := NewIO(&OSFileSystem{}, NewFSPathMapper(path))
+			err := fsIO.SetupRoot(0700)
+
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("SetupRoot() error = %v, want %q", err, tc.wantErr)
+				}
+
+				if tc.wantIs != nil && !errors.Is(err, tc.wantIs) {
+					t.Errorf("SetupRoot() error = %v, want it to wrap %v", err, tc.wantIs)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("SetupRoot() error = %v", err)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("stat root: %v", err)
+			}
+
+			if got := Perm(info.Mode().Perm()); !info.IsDir() || got != tc.wantPerm {
+				t.Errorf("root = directory %t with permission %s, want a directory with %s", info.IsDir(), got, tc.wantPerm)
+			}
+
+			// SetupRoot is idempotent.
+			if err := fsIO.SetupRoot(0700); err != nil {
+				t.Errorf("SetupRoot() again error = %v", err)
+			}
+		})
+	}
+}
+
+func TestIOSetupRootConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+
+	// Every binary sharing the file store sets up the root at the same time.
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = NewIO(&OSFileSystem{}, NewFSPathMapper(path)).SetupRoot(0700)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("SetupRoot() %d error = %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Layout is how the files of a directory are stored on the file system. It is recorded
+// per directory in the storage_layout column, so directories created before a layout was
+// introduced keep the layout they were created with.
+type Layout int
+
+// The file system layouts.
+const (
+	// LayoutFlat stores every file directly under its directory, <dirID>/<fileID>.
+	LayoutFlat Layout = 1
+
+	// LayoutFanOut stores every file in a shard of its directory named by the first two
+	// characters of the file ID, <dirID>/<shard>/<fileID>. This keeps the number of entries
+	// in a single file system directory small for directories with many files.
+	LayoutFanOut Layout = 2
+)
+
+// The names of the layouts, as accepted by ParseLayout.
+const (
+	layoutFlatName   = "flat"
+	layoutFanOutName = "fanout"
+)
+
+// shardLength is the number of characters of a file ID that name its shard.
+const shardLength = 2
+
+// ParseLayout parses the name of a layout, "flat" or "fanout". If value is empty, LayoutFlat
+// is returned. The error names variable, the name of the variable value was read from.
+func ParseLayout(value string, variable string) (Layout, error) {
+	switch value {
+	case "", layoutFlatName:
+		return LayoutFlat, nil
+	case layoutFanOutName:
+		return LayoutFanOut, nil
+	default:
+		return 0, fmt.Errorf("invalid %s %q: must be %q or %q", variable, value, layoutFlatName, layoutFanOutName)
+	}
+}
+
+// String returns the name of l.
+func (l Layout) String() string {
+	switch l {
+	case LayoutFlat:
+		return layoutFlatName
+	case LayoutFanOut:
+		return layoutFanOutName
+	default:
+		return fmt.Sprintf("Layout(%d)", int(l))
+	}
+}
+
+// FilePath returns the file system path of the file fileID stored under the directory at
+// dirPath.
+func (l Layout) FilePath(dirPath string, fileID string) string {
+	if l == LayoutFanOut && len(fileID) > shardLength {
+		return fmt.Sprintf("%s/%s/%s", dirPath, fileID[:shardLength], fileID)
+	}
+
+	return fmt.Sprintf("%s/%s", dirPath, fileID)
+}
+
+// LayoutConversion is the result of converting a directory to LayoutFanOut.
+type LayoutConversion struct {
+	DirectoryID string
+
+	// Converted is false if the directory already used LayoutFanOut.
+	Converted bool
+
+	// Moved is the number of files moved into shards. Files whose content is not on the
+	// file system are not moved.
+	Moved int
+}
+
+// layoutMove is a file moved by ConvertLayout.
+type layoutMove struct {
+	from string
+	to   string
+}
+
+// ConvertLayout converts the directory dirID from LayoutFlat to LayoutFanOut in place. Only
+// the files of the directory are moved, its sub directories keep their own layout.
+//
+// The directory row is locked while its files are moved into shards, and its layout is
+// updated in the same transaction. Uploads to the directory update its row, so they wait
+// until the conversion is done. If the conversion fails, the files that were moved are moved
+// back. A conversion that stopped part way through can be run again.
+//
+// A download of a file in the directory that starts while it is converted may not find the
+// file.
+func (s *DirService) ConvertLayout(ctx context.Context, dirID string) (LayoutConversion, error) {
+	if !validID(dirID) {
+		return LayoutConversion{}, DirNotFound(dirID, sql.ErrNoRows)
+	}
+
+	result := LayoutConversion{DirectoryID: dirID}
+	var moves []layoutMove
+
+	err := s.store.Tx(ctx, func(q Tx) error {
+		if err := q.LockDirectories(ctx, dirID); err != nil {
+			return err
+		}
+
+		layout, err := q.SelectDirectoryLayoutForUpdate(ctx, dirID)
+		if err != nil {
+			return err
+		}
+
+		if layout == LayoutFanOut {
+			return nil
+		}
+
+		dirPath, err := s.pathMap.GetDirFS(ctx, q, dirID)
+		if err != nil {
+			return err
+		}
+
+		fileIDs, err := q.SelectFileIDsByDirectory(ctx, dirID)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range fileIDs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			m := layoutMove{from: LayoutFlat.FilePath(dirPath, id), to: LayoutFanOut.FilePath(dirPath, id)}
+
+			if err := s.io.mkdirShard(m.to, s.perm); err != nil {
+				return fmt.Errorf("creating shard [%s]: %w", filepath.Dir(m.to), err)
+			}
+
+			if err := s.io.fs.Rename(m.from, m.to); err != nil {
+				if !s.io.fs.IsNotExist(err) {
+					return fmt.Errorf("moving file [%s]: %w", m.from, err)
+				}
+
+				// The content of a FilePending file may still be staged.
+				m = layoutMove{from: stagedPath(m.from), to: stagedPath(m.to)}
+				if err := s.io.fs.Rename(m.from, m.to); err != nil {
+					// The content is missing, or was moved by a conversion that failed to move
+					// it back.
+					if s.io.fs.IsNotExist(err) {
+						continue
+					}
+
+					return fmt.Errorf("moving file [%s]: %w", m.from, err)
+				}
+			}
+
+			moves = append(moves, m)
+		}
+
+		if err := q.UpdateDirectoryLayout(ctx, dirID, LayoutFanOut); err != nil {
+			return err
+		}
+
+		result.Converted = true
+		return nil
+	})
+	if err != nil {
+		for i := len(moves) - 1; i >= 0; i-- {
+			if rerr := s.io.fs.Rename(moves[i].to, moves[i].from); rerr != nil {
+				s.log.Printf("[ERROR] Moving file back after failed layout conversion [from: %s, to: %s]: %v\n", moves[i].to, moves[i].from, rerr)
+			}
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			err = DirNotFound(dirID, err)
+		}
+
+		return LayoutConversion{}, err
+	}
+
+	result.Moved = len(moves)
+
+	return result, nil
+}
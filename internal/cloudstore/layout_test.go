@@ -0,0 +1,137 @@
+package cloudstore
+
+import (
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Layout
+		wantErr bool
+	}{
+		{value: "", want: LayoutFlat},
+		{value: "flat", want: LayoutFlat},
+		{value: "fanout", want: LayoutFanOut},
+		{value: "FANOUT", wantErr: true},
+		{value: "sharded", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseLayout(tc.value, "STORAGE_LAYOUT")
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseLayout(%q) = %s, %v, want %s, error %v", tc.value, got, err, tc.want, tc.wantErr)
+		}
+
+		if got != 0 && got.String() != tc.value && tc.value != "" {
+			t.Errorf("%s.String() = %q, want %q", got, got.String(), tc.value)
+		}
+	}
+}
+
+func TestLayoutFilePath(t *testing.T) {
+	const id = "7e1a3c5b-8f2d-4e6a-9b0c-4d7f1e3a8c5b"
+
+	if got, want := LayoutFlat.FilePath("/store/dir", id), "/store/dir/"+id; got != want {
+		t.Errorf("LayoutFlat.FilePath() = %q, want %q", got, want)
+	}
+
+	if got, want := LayoutFanOut.FilePath("/store/dir", id), "/store/dir/7e/"+id; got != want {
+		t.Errorf("LayoutFanOut.FilePath() = %q, want %q", got, want)
+	}
+}
+
+func TestDirServiceConvertLayout(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	dir := addTestDir(t, f, root, userRoot, "photos")
+	a, _ := addTestFile(t, f, root, dir, "a.jpg")
+	b, _ := addTestFile(t, f, root, dir, "b.jpg")
+	gone, path := addTestFile(t, f, root, dir, "gone.jpg")
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("removing file content: %v", err)
+	}
+
+	result, err := s.ConvertLayout(ctx, dir.ID)
+	if err != nil {
+		t.Fatalf("ConvertLayout() error = %v", err)
+	}
+
+	// A file without content is not moved.
+	if !result.Converted || result.Moved != 2 {
+		t.Errorf("ConvertLayout() = %+v, want converted with 2 files moved", result)
+	}
+
+	if layout := f.data().layouts[dir.ID]; layout != LayoutFanOut {
+		t.Errorf("layout = %s, want %s", layout, LayoutFanOut)
+	}
+
+	dirFS := filepath.Join(root, userRoot.ID, dir.ID)
+	for _, file := range []FileRow{a, b} {
+		assertContent(t, LayoutFanOut.FilePath(dirFS, file.ID), file.Name)
+	}
+
+	if _, err := os.Stat(LayoutFanOut.FilePath(dirFS, gone.ID)); !os.IsNotExist(err) {
+		t.Errorf("missing file stat error = %v, want not exist", err)
+	}
+
+	// A converted directory is left as is.
+	result, err = s.ConvertLayout(ctx, dir.ID)
+	if err != nil || result.Converted || result.Moved != 0 {
+		t.Errorf("second ConvertLayout() = %+v, %v, want not converted", result, err)
+	}
+
+	// Uploads to the directory are stored in shards.
+	files, _ := newTestFileService(t, f)
+	files.pathMap, files.io = s.pathMap, s.io
+	files.validateUser = s.ValidateUser
+
+	batch, err := files.SaveBatch(ctx, userRoot.UserID, dir.ID, []*multipart.FileHeader{newTestFileHeader(t, "c.jpg", "c")}, nil)
+	if err != nil || batch.Saves[0].Err != nil {
+		t.Fatalf("SaveBatch() error = %v, %v", err, batch.Saves[0].Err)
+	}
+
+	assertContent(t, LayoutFanOut.FilePath(dirFS, batch.Saves[0].ID), "c")
+}
+
+func TestDirServiceConvertLayoutFailure(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	dir := addTestDir(t, f, root, userRoot, "photos")
+	a, path := addTestFile(t, f, root, dir, "a.jpg")
+
+	errUpdate := errors.New("connection reset")
+	f.fail("UpdateDirectoryLayout", errUpdate)
+
+	if _, err := s.ConvertLayout(context.Background(), dir.ID); !errors.Is(err, errUpdate) {
+		t.Fatalf("ConvertLayout() error = %v, want %v", err, errUpdate)
+	}
+
+	// The moved files are moved back, the directory stays flat.
+	assertContent(t, path, a.Name)
+
+	if layout, ok := f.data().layouts[dir.ID]; ok {
+		t.Errorf("layout = %s, want the directory flat", layout)
+	}
+}
+
+func TestDirServiceConvertLayoutNotFound(t *testing.T) {
+	f := newFakeStorage(t)
+	s, _ := newTestDirService(t, f)
+
+	for _, id := range []string{"not-a-uuid", uuid.NewString()} {
+		_, err := s.ConvertLayout(context.Background(), id)
+		assertSafeError(t, err, http.StatusNotFound, ErrNotFound)
+	}
+}
@@ -0,0 +1,167 @@
+package cloudstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/pkg/random"
+)
+
+// listingCacheMetrics counts the directory listing cache hits, misses, and invalidations.
+// It is published with expvar as "cloudstore_listing_cache".
+var listingCacheMetrics = expvar.NewMap("cloudstore_listing_cache")
+
+// minGenerationTTL is the least time the generation of a directory is kept after it was
+// last invalidated, see ListingCache.
+const minGenerationTTL = 24 * time.Hour
+
+// ListingCache caches directory listings in Redis.
+//
+// Every cached listing is keyed by the user, the directory, a hash of the ListOptions, and
+// the generation of the directory. Invalidating a directory starts a new generation, so all
+// the listings of the previous generation are no longer read. A listing is cached for at most
+// the TTL, so a listing is never stale for longer than the TTL even if an invalidation is
+// missed.
+//
+// A generation is kept much longer than the TTL. An expired generation is read as the first
+// generation again, so it must not expire before every listing cached under the first
+// generation has, including a listing read before the invalidation and cached after it.
+//
+// A nil ListingCache is valid and caches nothing. ListingCache should be created using the
+// NewListingCache function.
+type ListingCache struct {
+	cache  *cache.Redis
+	ttl    time.Duration
+	genTTL time.Duration
+	log    *log.Logger
+}
+
+// NewListingCache creates a new ListingCache. If ttl is not positive, caching is disabled
+// and nil is returned.
+//
+// If logger is nil, it will default to log.Default().
+func NewListingCache(c *cache.Redis, ttl time.Duration, logger *log.Logger) *ListingCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	if c == nil {
+		panic("cloudstore.NewListingCache: cannot create ListingCache with nil cache")
+	}
+
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &ListingCache{cache: c, ttl: ttl, genTTL: max(minGenerationTTL, 10*ttl), log: logger}
+}
+
+// Get gets the cached listing of a users directory. If the listing is not cached, false
+// is returned. Cache errors are logged and treated as a miss.
+//
+// The cache key of the listing is always returned, and should be passed to Set after the
+// listing is read. The key is read before the listing so that a listing read before an
+// invalidation is never cached under the new generation.
+func (l *ListingCache) Get(ctx context.Context, userID string, dirID string, opts ListOptions) ([]Entry, string, bool) {
+	if l == nil {
+		return nil, "", false
+	}
+
+	key, err := l.key(ctx, userID, dirID, opts)
+	if err != nil {
+		l.log.Printf("[ERROR] Getting listing cache key [directory_id: %s]: %v\n", dirID, err)
+		listingCacheMetrics.Add("misses", 1)
+		return nil, "", false
+	}
+
+	val, err := l.cache.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			l.log.Printf("[ERROR] Getting cached listing [directory_id: %s]: %v\n", dirID, err)
+		}
+
+		listingCacheMetrics.Add("misses", 1)
+		return nil, key, false
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal([]byte(val), &entries); err != nil {
+		l.log.Printf("[ERROR] Decoding cached listing [directory_id: %s]: %v\n", dirID, err)
+		listingCacheMetrics.Add("misses", 1)
+		return nil, key, false
+	}
+
+	listingCacheMetrics.Add("hits", 1)
+	return entries, key, true
+}
+
+// Set caches a listing with the key returned by Get. If key is empty, nothing is cached.
+// Cache errors are logged.
+func (l *ListingCache) Set(ctx context.Context, key string, entries []Entry) {
+	if l == nil || key == "" {
+		return
+	}
+
+	val, err := json.Marshal(entries)
+	if err != nil {
+		l.log.Printf("[ERROR] Encoding listing [key: %s]: %v\n", key, err)
+		return
+	}
+
+	if err := l.cache.Set(ctx, key, val, l.ttl); err != nil {
+		l.log.Printf("[ERROR] Caching listing [key: %s]: %v\n", key, err)
+	}
+}
+
+// Invalidate starts a new generation for every directory in dirIDs, so their cached
+// listings are no longer read. Cache errors are logged.
+func (l *ListingCache) Invalidate(ctx context.Context, dirIDs ...string) {
+	if l == nil || len(dirIDs) == 0 {
+		return
+	}
+
+	txs := []cache.SetTxParams{}
+	for _, id := range dirIDs {
+		txs = append(txs, cache.SetTxParams{Key: generationKey(id), Val: random.ID(16), Exp: l.genTTL})
+	}
+
+	if err := l.cache.SetTx(ctx, txs...); err != nil {
+		l.log.Printf("[ERROR] Invalidating listings %v: %v\n", dirIDs, err)
+		return
+	}
+
+	listingCacheMetrics.Add("invalidations", int64(len(dirIDs)))
+}
+
+// key returns the cache key of the listing of a users directory.
+func (l *ListingCache) key(ctx context.Context, userID string, dirID string, opts ListOptions) (string, error) {
+	gen, err := l.cache.Get(ctx, generationKey(dirID))
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			return "", err
+		}
+
+		gen = "0"
+	}
+
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(b)
+
+	return fmt.Sprintf("cloudstore:listing:%s:%s:%s:%s", userID, dirID, gen, hex.EncodeToString(hash[:])), nil
+}
+
+// generationKey returns the cache key of the generation of a directory.
+func generationKey(dirID string) string {
+	return fmt.Sprintf("cloudstore:listing:generation:%s", dirID)
+}
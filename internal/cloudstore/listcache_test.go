@@ -0,0 +1,212 @@
+package cloudstore
+
+import (
+	"context"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/pkg/random"
+)
+
+// newTestListingCache creates a ListingCache on the test Redis cache. It is configured by
+// the TEST_REDIS_HOST, TEST_REDIS_PORT, TEST_REDIS_USERNAME, and TEST_REDIS_PASSWORD
+// environment variables. Listings are cached for ttl. If TEST_REDIS_HOST is not set, t
+// is skipped.
+func newTestListingCache(t *testing.T, ttl time.Duration) *ListingCache {
+	t.Helper()
+
+	host := os.Getenv("TEST_REDIS_HOST")
+	if host == "" {
+		t.Skip("TEST_REDIS_HOST is not set")
+	}
+
+	redis := &cache.Redis{}
+	redis.Open(host,
+		os.Getenv("TEST_REDIS_PORT"),
+		os.Getenv("TEST_REDIS_USERNAME"),
+		os.Getenv("TEST_REDIS_PASSWORD"))
+	t.Cleanup(func() { redis.Close() })
+
+	if err := redis.Ping(); err != nil {
+		t.Fatalf("pinging test cache: %v", err)
+	}
+
+	return NewListingCache(redis, ttl, log.New(io.Discard, "", 0))
+}
+
+// countSelectEntries returns a func reporting the number of listings read from f.
+func countSelectEntries(f *fakeStorage) func() int {
+	n := 0
+	f.failOn("SelectEntries", func() error {
+		n++
+		return nil
+	})
+
+	return func() int { return n }
+}
+
+// entryNames returns the names of entries.
+func entryNames(entries []Entry) []string {
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+
+	return names
+}
+
+func TestNewListingCacheDisabled(t *testing.T) {
+	l := NewListingCache(&cache.Redis{}, 0, nil)
+	if l != nil {
+		t.Fatalf("NewListingCache() = %v, want nil", l)
+	}
+
+	// A nil ListingCache caches nothing.
+	ctx := context.Background()
+	if _, key, ok := l.Get(ctx, "user", "dir", ListOptions{}); ok || key != "" {
+		t.Errorf("Get() = %q, %t, want a miss without a key", key, ok)
+	}
+	l.Set(ctx, "key", []Entry{})
+	l.Invalidate(ctx, "dir")
+}
+
+func TestNewListingCacheGenerationTTL(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{ttl: 30 * time.Second, want: minGenerationTTL},
+		{ttl: time.Hour, want: minGenerationTTL},
+		{ttl: 3 * time.Hour, want: 30 * time.Hour},
+		{ttl: 12 * time.Hour, want: 120 * time.Hour},
+	}
+
+	for _, tc := range tests {
+		l := NewListingCache(&cache.Redis{}, tc.ttl, nil)
+		if l.genTTL != tc.want {
+			t.Errorf("NewListingCache(%v) generation TTL = %v, want %v", tc.ttl, l.genTTL, tc.want)
+		}
+	}
+}
+
+func TestListingCacheGenerationOutlivesListings(t *testing.T) {
+	const ttl = 200 * time.Millisecond
+
+	ctx := context.Background()
+	l := newTestListingCache(t, ttl)
+	userID, dirID := random.ID(16), random.ID(16)
+	t.Cleanup(func() { l.cache.Del(ctx, generationKey(dirID)) })
+
+	// A listing is read before an invalidation and cached after it, under the first
+	// generation.
+	_, key, _ := l.Get(ctx, userID, dirID, ListOptions{})
+	l.Invalidate(ctx, dirID)
+	time.Sleep(ttl / 2)
+	l.Set(ctx, key, []Entry{{Name: "stale"}})
+
+	// The stale listing is still cached after a listing TTL has passed since the
+	// invalidation. The generation must not have expired back to the first one.
+	time.Sleep(ttl * 3 / 4)
+	entries, newKey, ok := l.Get(ctx, userID, dirID, ListOptions{})
+	if ok || newKey == key {
+		t.Errorf("Get() = %v, %t with key %s, want a miss under a new generation", entryNames(entries), ok, newKey)
+	}
+}
+
+func TestDirServiceListEntriesCacheUnreachable(t *testing.T) {
+	redis := &cache.Redis{}
+	redis.Open("127.0.0.1", "1", "", "")
+	t.Cleanup(func() { redis.Close() })
+
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	s.listings = NewListingCache(redis, time.Minute, log.New(io.Discard, "", 0))
+	userRoot := addTestRoot(t, f, root)
+	addTestDir(t, f, root, userRoot, "photos")
+	selects := countSelectEntries(f)
+	all := ListOptions{Dirs: true, Files: true, Limit: 10}
+
+	// Cache errors are misses, the listing is read from the store every time.
+	for i := 0; i < 2; i++ {
+		entries, err := s.ListEntries(context.Background(), userRoot.UserID, "", all)
+		if err != nil {
+			t.Fatalf("ListEntries() error = %v", err)
+		}
+
+		if got := entryNames(entries); !reflect.DeepEqual(got, []string{"photos"}) {
+			t.Errorf("ListEntries() names = %v, want [photos]", got)
+		}
+	}
+
+	if got := selects(); got != 2 {
+		t.Errorf("listings read from the store = %d, want 2", got)
+	}
+}
+
+func TestDirServiceListEntriesCache(t *testing.T) {
+	listings := newTestListingCache(t, time.Minute)
+	ctx := context.Background()
+
+	f := newFakeStorage(t)
+	dirs, root := newTestDirService(t, f)
+	dirs.listings = listings
+	userRoot := addTestRoot(t, f, root)
+	addTestDir(t, f, root, userRoot, "photos")
+	selects := countSelectEntries(f)
+	all := ListOptions{Dirs: true, Files: true, Limit: 10}
+
+	files := NewFileService(FileServiceConfig{
+		Store:        f,
+		Log:          log.New(io.Discard, "", 0),
+		ValidateUser: dirs.ValidateUser,
+		PathMap:      NewPathMapper(root),
+		Listings:     listings,
+	})
+
+	list := func(opts ListOptions) []string {
+		t.Helper()
+
+		entries, err := dirs.ListEntries(ctx, userRoot.UserID, "", opts)
+		if err != nil {
+			t.Fatalf("ListEntries() error = %v", err)
+		}
+
+		return entryNames(entries)
+	}
+
+	// Miss, then hit.
+	list(all)
+	if got := list(all); !reflect.DeepEqual(got, []string{"photos"}) {
+		t.Errorf("cached names = %v, want [photos]", got)
+	}
+	if got := selects(); got != 1 {
+		t.Errorf("listings read from the store = %d, want 1", got)
+	}
+
+	// Other options are cached separately.
+	list(ListOptions{Dirs: true, Limit: 10})
+	if got := selects(); got != 2 {
+		t.Errorf("listings read from the store = %d, want 2", got)
+	}
+
+	// An upload invalidates the listing of its directory.
+	_, err := files.SaveBatch(ctx, userRoot.UserID, userRoot.ID, []*multipart.FileHeader{
+		newTestFileHeader(t, "a.txt", "hello"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+
+	if got := list(all); !reflect.DeepEqual(got, []string{"photos", "a.txt"}) {
+		t.Errorf("names after upload = %v, want [photos a.txt]", got)
+	}
+	if got := selects(); got != 3 {
+		t.Errorf("listings read from the store = %d, want 3", got)
+	}
+}
@@ -0,0 +1,179 @@
+package cloudstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cicconee/clox/internal/cache"
+)
+
+// ErrUploadLoop is returned when an upload is blocked by a LoopDetector.
+var ErrUploadLoop = errors.New("upload loop detected")
+
+// loopMetrics counts the uploads a LoopDetector warned about and blocked. It is published
+// with expvar as "cloudstore_upload_loops".
+var loopMetrics = expvar.NewMap("cloudstore_upload_loops")
+
+// DefaultLoopWindow is the default window a LoopDetector counts uploads in.
+const DefaultLoopWindow = time.Hour
+
+// LoopMode is what a LoopDetector does with an upload past its threshold.
+type LoopMode string
+
+const (
+	// LoopWarn saves the upload and warns about it in the batch result. It is the
+	// default.
+	LoopWarn LoopMode = "warn"
+
+	// LoopBlock rejects the upload with ErrUploadLoop until the window cools down.
+	LoopBlock LoopMode = "block"
+)
+
+// ParseLoopMode parses the name of a LoopMode, "warn" or "block". If value is empty,
+// LoopWarn is returned. The error names variable, the name of the variable value was read
+// from.
+func ParseLoopMode(value string, variable string) (LoopMode, error) {
+	switch m := LoopMode(value); m {
+	case "":
+		return LoopWarn, nil
+	case LoopWarn, LoopBlock:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q: must be %q or %q", variable, value, LoopWarn, LoopBlock)
+	}
+}
+
+// LoopDetectorConfig is the LoopDetector configuration.
+type LoopDetectorConfig struct {
+	// Threshold is the number of copies of the same content a user may upload under the
+	// same name to a directory within Window before the LoopDetector acts. If it is not
+	// positive, loop detection is disabled.
+	Threshold int
+
+	// Window is how far back uploads are counted. If it is not set, it will default to
+	// DefaultLoopWindow.
+	Window time.Duration
+
+	// Mode is what is done with an upload past Threshold. If it is not set, it will
+	// default to LoopWarn.
+	Mode LoopMode
+
+	// Log is used to log cache errors. If it is not set, it will default to log.Default().
+	Log *log.Logger
+}
+
+// LoopDetector detects a client, such as a misbehaving sync tool, uploading the same
+// content to the same directory over and over.
+//
+// Uploads are counted per user, directory, file name stem, and checksum in a sliding
+// window in Redis. The stem is the file name without its extension and without a trailing
+// copy number, such as " (2)", so "report (2).pdf" counts as a copy of "report.pdf". Cache
+// errors are logged and the upload is let through.
+//
+// A nil LoopDetector is valid and detects nothing. LoopDetector should be created using
+// the NewLoopDetector function.
+type LoopDetector struct {
+	cache     *cache.Redis
+	threshold int64
+	window    time.Duration
+	mode      LoopMode
+	log       *log.Logger
+	now       func() time.Time
+}
+
+// NewLoopDetector creates a new LoopDetector. If c.Threshold is not positive, loop
+// detection is disabled and nil is returned.
+func NewLoopDetector(r *cache.Redis, c LoopDetectorConfig) *LoopDetector {
+	if c.Threshold <= 0 {
+		return nil
+	}
+
+	if r == nil {
+		panic("cloudstore.NewLoopDetector: cannot create LoopDetector with nil cache")
+	}
+
+	if c.Window <= 0 {
+		c.Window = DefaultLoopWindow
+	}
+
+	if c.Mode == "" {
+		c.Mode = LoopWarn
+	}
+
+	if c.Log == nil {
+		c.Log = log.Default()
+	}
+
+	return &LoopDetector{
+		cache:     r,
+		threshold: int64(c.Threshold),
+		window:    c.Window,
+		mode:      c.Mode,
+		log:       c.Log,
+		now:       time.Now,
+	}
+}
+
+// blocked reports if an upload of file must be rejected. It is only true in LoopBlock
+// mode, when Threshold copies of the file were already uploaded within the window.
+func (d *LoopDetector) blocked(ctx context.Context, userID string, file FileInfo) bool {
+	if d == nil || d.mode != LoopBlock {
+		return false
+	}
+
+	n, err := d.cache.WindowCount(ctx, loopKey(userID, file), d.now().Add(-d.window))
+	if err != nil {
+		d.log.Printf("[ERROR] Counting uploads [directory_id: %s, name: %s]: %v\n", file.DirectoryID, file.Name, err)
+		return false
+	}
+
+	if n < d.threshold {
+		return false
+	}
+
+	loopMetrics.Add("blocks", 1)
+	return true
+}
+
+// record counts the saved upload of file. If it is past Threshold in LoopWarn mode, a
+// warning for the user is returned, otherwise it is empty.
+func (d *LoopDetector) record(ctx context.Context, userID string, file FileInfo) string {
+	if d == nil {
+		return ""
+	}
+
+	now := d.now()
+	n, err := d.cache.WindowAdd(ctx, loopKey(userID, file), file.ID, now, now.Add(-d.window), d.window)
+	if err != nil {
+		d.log.Printf("[ERROR] Recording upload [id: %s]: %v\n", file.ID, err)
+		return ""
+	}
+
+	if d.mode != LoopWarn || n <= d.threshold {
+		return ""
+	}
+
+	loopMetrics.Add("warnings", 1)
+	return fmt.Sprintf("File '%s' has the same content as %d other uploads to this directory in the last %s; a sync client may be uploading it in a loop", file.Name, n-1, d.window)
+}
+
+// copySuffix matches the copy number clients append to the name of a file, such as " (2)".
+var copySuffix = regexp.MustCompile(` \(\d+\)$`)
+
+// loopKey returns the cache key the uploads of file are counted under.
+func loopKey(userID string, file FileInfo) string {
+	stem := strings.TrimSuffix(file.Name, filepath.Ext(file.Name))
+	stem = copySuffix.ReplaceAllString(stem, "")
+	hash := sha256.Sum256([]byte(stem))
+
+	return fmt.Sprintf("cloudstore:upload_loop:%s:%s:%s:%s", userID, file.DirectoryID, hex.EncodeToString(hash[:8]), file.Checksum)
+}
@@ -0,0 +1,228 @@
+package cloudstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/cache"
+)
+
+func TestParseLoopMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    LoopMode
+		wantErr bool
+	}{
+		{value: "", want: LoopWarn},
+		{value: "warn", want: LoopWarn},
+		{value: "block", want: LoopBlock},
+		{value: "Block", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseLoopMode(tc.value, "UPLOAD_LOOP_MODE")
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseLoopMode(%q) = %q, %v, want %q", tc.value, got, err, tc.want)
+		}
+
+		if err != nil && !strings.Contains(err.Error(), "UPLOAD_LOOP_MODE") {
+			t.Errorf("ParseLoopMode(%q) error = %v, want the variable named", tc.value, err)
+		}
+	}
+}
+
+func TestLoopKey(t *testing.T) {
+	file := FileInfo{Name: "report.pdf", DirectoryID: "dir", Checksum: "abc"}
+	key := loopKey("user", file)
+
+	// Copies renamed by a client count as the same file.
+	for _, name := range []string{"report (2).pdf", "report (10).pdf", "report.txt", "report"} {
+		renamed := file
+		renamed.Name = name
+		if got := loopKey("user", renamed); got != key {
+			t.Errorf("loopKey() of %s = %s, want the key of %s %s", name, got, file.Name, key)
+		}
+	}
+
+	// The user, directory, stem, and content each count on their own.
+	others := []FileInfo{
+		{Name: "report(2).pdf", DirectoryID: "dir", Checksum: "abc"},
+		{Name: "report (2) (3).pdf", DirectoryID: "dir", Checksum: "abc"},
+		{Name: "summary.pdf", DirectoryID: "dir", Checksum: "abc"},
+		{Name: "report.pdf", DirectoryID: "other", Checksum: "abc"},
+		{Name: "report.pdf", DirectoryID: "dir", Checksum: "def"},
+	}
+	for _, other := range others {
+		if got := loopKey("user", other); got == key {
+			t.Errorf("loopKey() of %+v = %s, want a key other than %+v", other, got, file)
+		}
+	}
+
+	if got := loopKey("other", file); got == key {
+		t.Errorf("loopKey() of another user = %s, want a key other than %s", got, key)
+	}
+}
+
+func TestNewLoopDetectorDisabled(t *testing.T) {
+	// The cache is not needed when detection is disabled.
+	if d := NewLoopDetector(nil, LoopDetectorConfig{Threshold: 0}); d != nil {
+		t.Errorf("NewLoopDetector() = %+v, want nil", d)
+	}
+
+	var d *LoopDetector
+	if d.blocked(context.Background(), "user", FileInfo{}) || d.record(context.Background(), "user", FileInfo{}) != "" {
+		t.Error("nil LoopDetector detected a loop")
+	}
+}
+
+// loopTest is a FileService on a fakeStorage that detects upload loops on the test Redis
+// cache, with a fake clock.
+type loopTest struct {
+	files    *FileService
+	userRoot DirectoryRow
+	now      time.Time
+
+	// uploads is the number of uploads, every upload is named as a new copy.
+	uploads int
+}
+
+// newLoopTest creates a loopTest whose LoopDetector is configured by c. The cache is
+// configured by the TEST_REDIS_HOST, TEST_REDIS_PORT, TEST_REDIS_USERNAME, and
+// TEST_REDIS_PASSWORD environment variables. If TEST_REDIS_HOST is not set, t is
+// skipped.
+func newLoopTest(t *testing.T, c LoopDetectorConfig) *loopTest {
+	t.Helper()
+
+	host := os.Getenv("TEST_REDIS_HOST")
+	if host == "" {
+		t.Skip("TEST_REDIS_HOST is not set")
+	}
+
+	redis := &cache.Redis{}
+	redis.Open(host,
+		os.Getenv("TEST_REDIS_PORT"),
+		os.Getenv("TEST_REDIS_USERNAME"),
+		os.Getenv("TEST_REDIS_PASSWORD"))
+	t.Cleanup(func() { redis.Close() })
+
+	if err := redis.Ping(); err != nil {
+		t.Fatalf("pinging test cache: %v", err)
+	}
+
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	s.validateUser = func(ctx context.Context, userID string) (Dir, error) {
+		return Dir{ID: userRoot.ID}, nil
+	}
+
+	c.Log = log.New(io.Discard, "", 0)
+	lt := &loopTest{files: s, userRoot: userRoot, now: time.Now()}
+	s.loops = NewLoopDetector(redis, c)
+	s.loops.now = func() time.Time { return lt.now }
+
+	return lt
+}
+
+// upload uploads content as the next copy of report.pdf, after advancing the clock by
+// d. The result of the upload is returned.
+func (lt *loopTest) upload(t *testing.T, d time.Duration, content string) BatchSave {
+	t.Helper()
+
+	lt.now = lt.now.Add(d)
+	lt.uploads++
+
+	batch, err := lt.files.SaveBatch(context.Background(), lt.userRoot.UserID, "", []*multipart.FileHeader{
+		newTestFileHeader(t, fmt.Sprintf("report (%d).pdf", lt.uploads), content),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+
+	return batch.Saves[0]
+}
+
+// loopCount returns the count of name in loopMetrics.
+func loopCount(name string) string {
+	if v := loopMetrics.Get(name); v != nil {
+		return v.String()
+	}
+
+	return "0"
+}
+
+// TestLoopDetectorWarn uploads the same content once a minute, as a runaway sync client
+// would, in the LoopWarn mode.
+func TestLoopDetectorWarn(t *testing.T) {
+	lt := newLoopTest(t, LoopDetectorConfig{Threshold: 3, Window: time.Hour})
+	warnings := loopCount("warnings")
+
+	for i := 0; i < 3; i++ {
+		save := lt.upload(t, time.Minute, "same")
+		if save.Err != nil || save.Warning != "" {
+			t.Fatalf("upload %d = %v, %q, want saved without a warning", i+1, save.Err, save.Warning)
+		}
+	}
+
+	// Past the threshold the upload is saved with a warning.
+	save := lt.upload(t, time.Minute, "same")
+	if save.Err != nil || !strings.Contains(save.Warning, "3 other uploads") {
+		t.Errorf("upload past the threshold = %v, %q, want saved with a warning", save.Err, save.Warning)
+	}
+
+	if loopCount("warnings") == warnings {
+		t.Error("warnings metric not counted")
+	}
+
+	// Other content under the same name is counted on its own.
+	if save := lt.upload(t, time.Minute, "other"); save.Warning != "" {
+		t.Errorf("upload of other content warning = %q, want none", save.Warning)
+	}
+
+	// Once the window has passed the uploads count from the start.
+	if save := lt.upload(t, 2*time.Hour, "same"); save.Err != nil || save.Warning != "" {
+		t.Errorf("upload after the window = %v, %q, want saved without a warning", save.Err, save.Warning)
+	}
+}
+
+// TestLoopDetectorBlock uploads the same content once a minute, as a runaway sync client
+// would, in the LoopBlock mode.
+func TestLoopDetectorBlock(t *testing.T) {
+	lt := newLoopTest(t, LoopDetectorConfig{Threshold: 3, Window: time.Hour, Mode: LoopBlock})
+	blocks := loopCount("blocks")
+
+	for i := 0; i < 3; i++ {
+		if save := lt.upload(t, time.Minute, "same"); save.Err != nil {
+			t.Fatalf("upload %d error = %v", i+1, save.Err)
+		}
+	}
+
+	save := lt.upload(t, time.Minute, "same")
+	if !errors.Is(save.Err, ErrUploadLoop) {
+		t.Fatalf("upload past the threshold error = %v, want a ErrUploadLoop", save.Err)
+	}
+	assertSafeError(t, save.Err, http.StatusTooManyRequests, nil)
+
+	if loopCount("blocks") == blocks {
+		t.Error("blocks metric not counted")
+	}
+
+	// The window slides, once the first upload is out of it one more copy is let
+	// through.
+	if save := lt.upload(t, time.Hour-2*time.Minute, "same"); save.Err != nil {
+		t.Fatalf("upload once the first copy left the window error = %v", save.Err)
+	}
+
+	if save := lt.upload(t, 0, "same"); !errors.Is(save.Err, ErrUploadLoop) {
+		t.Errorf("upload right after error = %v, want a ErrUploadLoop", save.Err)
+	}
+}
@@ -0,0 +1,58 @@
+package cloudstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// ErrContentMissing signals a file has a row in the database but its content is not
+// on the file system, such as after a partial crash or a manual deletion.
+var ErrContentMissing = errors.New("file content missing")
+
+// contentMissing handles a file whose content was not found on the file system while
+// reading it. It is logged and counted in the "content_missing_reads" metric, and the
+// file is flagged as content_missing so listings show it and CheckStorage reports it.
+// A failure to flag the file is logged.
+//
+// The returned error is a 503 app.WrappedSafeError that wraps ErrContentMissing and err.
+func (s *FileService) contentMissing(ctx context.Context, file FileInfo, err error) error {
+	s.log.Printf("[ERROR] File content missing [id: %s, path: %s]: %v\n", file.ID, file.FSPath, err)
+	storageMetrics.Add("content_missing_reads", 1)
+
+	if flagErr := s.store.UpdateFileContentMissing(ctx, file.ID, true); flagErr != nil {
+		s.log.Printf("[ERROR] Flagging file content missing [id: %s]: %v\n", file.ID, flagErr)
+	} else {
+		s.listings.Invalidate(ctx, file.DirectoryID)
+	}
+
+	if !errors.Is(err, ErrContentMissing) {
+		err = fmt.Errorf("%w [id: %s, path: %s]: %w", ErrContentMissing, file.ID, file.FSPath, err)
+	}
+
+	return app.Wrap(app.WrapParams{
+		Err:         err,
+		SafeMessage: "This file's content is unavailable; our team has been notified",
+		StatusCode:  http.StatusServiceUnavailable,
+	})
+}
+
+// Open opens the content of a file returned by Info for reading. If the content is not
+// on the file system, the file is flagged as content_missing and a 503
+// app.WrappedSafeError wrapping ErrContentMissing is returned.
+func (s *FileService) Open(ctx context.Context, file FileInfo) (*os.File, error) {
+	f, err := s.io.fs.Open(file.FSPath)
+	if err != nil {
+		if s.io.fs.IsNotExist(err) {
+			return nil, s.contentMissing(ctx, file, err)
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,516 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// MaxMoveSources is the maximum number of sources of a BulkMove.
+const MaxMoveSources = 1000
+
+// MoveSource is a directory or file of a BulkMove, by ID or by path. Exactly one of ID
+// and Path is set. Path is resolved under the users root directory.
+type MoveSource struct {
+	Type EntryType
+	ID   string
+	Path string
+}
+
+// BulkMove moves many directories and files of a user to a single destination
+// directory, by ID or by path. Exactly one of DirectoryID and Path is set.
+type BulkMove struct {
+	Sources []MoveSource
+
+	DirectoryID string
+	Path        string
+
+	// Atomic moves every source or none of them. If not set, a source that cannot be
+	// moved does not stop the others.
+	Atomic bool
+
+	// DryRun returns the results the move would have without moving anything.
+	DryRun bool
+}
+
+// MoveStatus is the status of a moved MoveSource.
+type MoveStatus string
+
+const (
+	MoveDone   MoveStatus = "done"
+	MoveFailed MoveStatus = "failed"
+
+	// MoveSkipped is a source of a atomic BulkMove that was not moved because another
+	// source failed.
+	MoveSkipped MoveStatus = "skipped"
+)
+
+// MoveResult is the result of moving a MoveSource.
+type MoveResult struct {
+	Source MoveSource
+	Status MoveStatus
+
+	// ID is the ID of the directory or file of the source. It is empty if the path of
+	// the source was not found.
+	ID string
+
+	// Path is the path of the directory or file after the move. It is empty if the
+	// source was not moved.
+	Path string
+
+	Err error
+}
+
+// Msg returns the error of this MoveResult as a user friendly message. It is empty if
+// the source was moved.
+func (r MoveResult) Msg() string {
+	if r.Err == nil {
+		return ""
+	}
+
+	var wrapErr *app.WrappedSafeError
+	if errors.As(r.Err, &wrapErr) {
+		msg, _ := wrapErr.Safe()
+		return msg
+	}
+
+	return "Problem moving the entry"
+}
+
+// MoveService moves many directories and files at once through the DirService and
+// FileService. Every source is moved with the same checks and transaction steps as the
+// single directory and file move endpoints.
+type MoveService struct {
+	dirs  *DirService
+	files *FileService
+}
+
+// NewMoveService creates a new MoveService.
+func NewMoveService(dirs *DirService, files *FileService) *MoveService {
+	if dirs == nil || files == nil {
+		panic("cloudstore: NewMoveService requires a DirService and FileService")
+	}
+
+	return &MoveService{dirs: dirs, files: files}
+}
+
+// pendingMove is a source of a BulkMove that was found and can be moved. Only one of
+// dir and file is set.
+type pendingMove struct {
+	i    int
+	dir  *Dir
+	file *FileRow
+
+	dirMove  dirMove
+	fileMove fileMove
+}
+
+// path returns the path of the directory or file of p after it was moved.
+func (p pendingMove) path() string {
+	if p.dir != nil {
+		return p.dirMove.change.Dir.Path
+	}
+
+	return p.fileMove.change.File.Path
+}
+
+// moveSavepoint is the savepoint of the source being moved by a BulkMove that is not
+// atomic.
+const moveSavepoint = "bulk_move_source"
+
+// Move moves the sources of the BulkMove of a user to its destination and returns the
+// result of every source, in request order.
+//
+// If the request is invalid, a 400 app.WrappedSafeError is returned, and if the
+// destination does not exist or is not owned by the user, a 404 app.WrappedSafeError.
+// Otherwise a source that cannot be moved is only reported in its result, with the same
+// errors as DirService.Move and FileService.Move. A source that is already in the
+// destination is done without being moved.
+//
+// Every source is moved in a single transaction, with DirService.moveTx and
+// FileService.moveTx. The directories of every source are locked at once when it
+// begins. If the move is not atomic, each source is moved under a savepoint, so a source
+// that fails is rolled back alone. If it is atomic, the first source that fails rolls
+// back the transaction and the other sources are MoveSkipped. If the transaction does
+// not commit, every moved directory and file is moved back on the file system.
+//
+// If m.DryRun is true, the same transaction is run without moving anything on the file
+// system, and it is rolled back once every source is moved. The results are the ones
+// the move would have had, but nothing is changed and no hooks are run.
+func (s *MoveService) Move(ctx context.Context, userID string, m BulkMove) ([]MoveResult, error) {
+	if err := validateBulkMove(m); err != nil {
+		return nil, err
+	}
+
+	root, err := s.dirs.ValidateUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	targetID := m.DirectoryID
+	if m.Path != "" {
+		targetID, err = s.dirs.pathMap.FindDir(ctx, s.dirs.store, PathSearch{
+			UserID: userID,
+			RootID: root.ID,
+			Path:   m.Path,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	target, err := s.dirs.access.Dir(ctx, userID, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]MoveResult, len(m.Sources))
+	pending := s.resolve(ctx, userID, root, target, m.Sources, results)
+
+	if m.Atomic && anyFailed(results) {
+		skipPending(results, pending, "Not moved because another entry cannot be moved")
+		return results, nil
+	}
+
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	// failed is the pending move that failed a atomic move.
+	failed := -1
+	err = s.dirs.store.Tx(ctx, func(q Tx) error {
+		// Every directory is locked before the first source is moved, the moves of the
+		// sources do not wait for a lock, see Query.LockDirectories.
+		if err := s.lock(ctx, q, target, pending); err != nil {
+			return fmt.Errorf("locking directories: %w", err)
+		}
+
+		for n := range pending {
+			p := &pending[n]
+
+			if !m.Atomic {
+				if err := q.Savepoint(ctx, moveSavepoint); err != nil {
+					return err
+				}
+			}
+
+			err := s.moveTx(ctx, q, userID, target, p, m.DryRun)
+			if err == nil {
+				if !m.Atomic {
+					if err := q.ReleaseSavepoint(ctx, moveSavepoint); err != nil {
+						return err
+					}
+				}
+
+				continue
+			}
+
+			if m.Atomic {
+				failed = n
+				return err
+			}
+
+			if rerr := q.RollbackToSavepoint(ctx, moveSavepoint); rerr != nil {
+				return fmt.Errorf("rolling back source %d: %w", p.i, rerr)
+			}
+
+			results[p.i].Status = MoveFailed
+			results[p.i].Err = s.moveError(err, target, p)
+		}
+
+		if m.DryRun {
+			return errDryRun
+		}
+
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		for _, p := range pending {
+			if results[p.i].Status == "" {
+				results[p.i].Status = MoveDone
+				results[p.i].Path = p.path()
+			}
+		}
+
+		return results, nil
+	}
+
+	if err != nil {
+		// The moved sources are moved back in reverse, a source moved under a directory
+		// that was moved after it is moved back before the directory.
+		for n := len(pending) - 1; n >= 0; n-- {
+			s.dirs.moveBack(pending[n].dirMove)
+			s.files.moveBack(pending[n].fileMove)
+		}
+
+		if failed >= 0 {
+			p := pending[failed]
+			results[p.i].Status = MoveFailed
+			results[p.i].Err = s.moveError(err, target, &p)
+			skipPending(results, pending, fmt.Sprintf("Not moved because entry %d cannot be moved", p.i))
+			s.flagMissing(ctx, results, pending)
+			return results, nil
+		}
+
+		for _, p := range pending {
+			if results[p.i].Status == "" {
+				results[p.i].Status = MoveFailed
+				results[p.i].Err = err
+			}
+		}
+
+		s.flagMissing(ctx, results, pending)
+		return results, nil
+	}
+
+	s.flagMissing(ctx, results, pending)
+
+	for _, p := range pending {
+		if results[p.i].Status != "" {
+			continue
+		}
+
+		results[p.i].Status = MoveDone
+		results[p.i].Path = p.path()
+		if p.dir != nil {
+			s.dirs.afterMove(ctx, p.dirMove)
+		} else {
+			s.files.afterMove(ctx, p.fileMove)
+		}
+	}
+
+	return results, nil
+}
+
+// validateBulkMove validates the sources and destination of m. All errors returned are a
+// 400 app.WrappedSafeError.
+func validateBulkMove(m BulkMove) error {
+	if (m.DirectoryID == "") == (m.Path == "") {
+		return app.Wrap(app.WrapParams{
+			Err:         errors.New("bulk move needs exactly one of directory_id and path"),
+			SafeMessage: "Either directory_id or path is required",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "directory_id",
+		})
+	}
+
+	if len(m.Sources) == 0 || len(m.Sources) > MaxMoveSources {
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("bulk move has %d sources", len(m.Sources)),
+			SafeMessage: fmt.Sprintf("Sources must have between 1 and %d entries", MaxMoveSources),
+			StatusCode:  http.StatusBadRequest,
+			Field:       "sources",
+		})
+	}
+
+	for i, src := range m.Sources {
+		if src.Type != EntryDir && src.Type != EntryFile {
+			return sourceError(i, "type must be one of: directory, file")
+		}
+
+		if (src.ID == "") == (src.Path == "") {
+			return sourceError(i, "needs exactly one of id and path")
+		}
+	}
+
+	return nil
+}
+
+func sourceError(i int, reason string) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("invalid bulk move source %d: %s", i, reason),
+		SafeMessage: fmt.Sprintf("Source %d %s", i, reason),
+		StatusCode:  http.StatusBadRequest,
+		Field:       "sources",
+	})
+}
+
+// resolve finds the directory or file of every source and checks that it can be moved
+// to target. Sources that cannot be moved are MoveFailed, and sources already in target
+// are MoveDone. The other sources are returned in request order.
+func (s *MoveService) resolve(ctx context.Context, userID string, root Dir, target DirectoryRow, sources []MoveSource, results []MoveResult) []pendingMove {
+	pending := []pendingMove{}
+	seen := map[string]int{}
+
+	for i, src := range sources {
+		results[i].Source = src
+
+		id, err := s.sourceID(ctx, userID, root, src)
+		if err != nil {
+			results[i].Status = MoveFailed
+			results[i].Err = err
+			continue
+		}
+		results[i].ID = id
+
+		if first, ok := seen[id]; ok {
+			results[i].Status = MoveFailed
+			results[i].Err = app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("source %d is the same entry as source %d [id: %s]", i, first, id),
+				SafeMessage: fmt.Sprintf("Entry is the same as source %d", first),
+				StatusCode:  http.StatusBadRequest,
+			})
+			continue
+		}
+		seen[id] = i
+
+		p := pendingMove{i: i}
+		if src.Type == EntryDir {
+			dir, err := s.dirs.read(ctx, userID, id)
+			if err == nil && dir.ParentID == "" {
+				err = moveRootError(dir)
+			}
+			if err == nil && dir.ParentID != target.ID {
+				err = checkDirMove(dir, target)
+			}
+			if err != nil {
+				results[i].Status = MoveFailed
+				results[i].Err = err
+				continue
+			}
+
+			if dir.ParentID == target.ID {
+				results[i].Status = MoveDone
+				results[i].Path = dir.Path
+				continue
+			}
+
+			p.dir = &dir
+		} else {
+			file, err := s.files.store.SelectFileByIDUser(ctx, id, userID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					err = FileNotFound(id, err)
+				}
+
+				results[i].Status = MoveFailed
+				results[i].Err = err
+				continue
+			}
+
+			if file.DirectoryID == target.ID {
+				results[i].Status = MoveDone
+				results[i].Path, results[i].Err = s.files.pathMap.GetFile(ctx, s.files.store, file.DirectoryID, file.Name)
+				if results[i].Err != nil {
+					results[i].Status = MoveFailed
+				}
+				continue
+			}
+
+			p.file = &file
+		}
+
+		pending = append(pending, p)
+	}
+
+	return pending
+}
+
+// sourceID returns the ID of the directory or file of src.
+func (s *MoveService) sourceID(ctx context.Context, userID string, root Dir, src MoveSource) (string, error) {
+	if src.Path == "" {
+		if !validID(src.ID) {
+			if src.Type == EntryDir {
+				return "", DirNotFound(src.ID, sql.ErrNoRows)
+			}
+
+			return "", FileNotFound(src.ID, sql.ErrNoRows)
+		}
+
+		return src.ID, nil
+	}
+
+	search := PathSearch{UserID: userID, RootID: root.ID, Path: src.Path}
+	if src.Type == EntryDir {
+		return s.dirs.pathMap.FindDir(ctx, s.dirs.store, search)
+	}
+
+	return s.files.pathMap.FindFile(ctx, s.files.store, search)
+}
+
+// lock locks target and the directories of pending in the transaction q, in a single
+// call of LockDirectories. The directory a file is in is locked, and the parent and every
+// directory under a directory.
+func (s *MoveService) lock(ctx context.Context, q Tx, target DirectoryRow, pending []pendingMove) error {
+	ids := []string{target.ID}
+	for _, p := range pending {
+		if p.file != nil {
+			ids = append(ids, p.file.DirectoryID)
+			continue
+		}
+
+		subtree, err := q.SelectDescendantDirectoryIDs(ctx, p.dir.ID)
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, p.dir.ParentID)
+		ids = append(ids, subtree...)
+	}
+
+	return q.LockDirectories(ctx, ids...)
+}
+
+// moveTx moves the directory or file of p to target in the transaction q. If dryRun is
+// true, nothing is moved on the file system.
+func (s *MoveService) moveTx(ctx context.Context, q Tx, userID string, target DirectoryRow, p *pendingMove, dryRun bool) error {
+	if p.dir != nil {
+		return s.dirs.moveTx(ctx, q, userID, *p.dir, target, &p.dirMove, dryRun)
+	}
+
+	return s.files.moveTx(ctx, q, userID, *p.file, target, &p.fileMove, dryRun)
+}
+
+// moveError returns the error of moving p to target that failed with err.
+func (s *MoveService) moveError(err error, target DirectoryRow, p *pendingMove) error {
+	err = lockBusy(err)
+	if p.dir != nil {
+		return moveDirError(err, *p.dir, target)
+	}
+
+	return moveFileError(err, *p.file, target)
+}
+
+// flagMissing flags the files of pending that were not moved because their content was
+// missing, see FileService.contentMissing. It is called once the transaction of the move has
+// ended, so the flag is not rolled back with it.
+func (s *MoveService) flagMissing(ctx context.Context, results []MoveResult, pending []pendingMove) {
+	for _, p := range pending {
+		if p.file != nil && errors.Is(results[p.i].Err, ErrContentMissing) {
+			file := FileInfo{ID: p.file.ID, DirectoryID: p.file.DirectoryID, FSPath: p.fileMove.from}
+			results[p.i].Err = s.files.contentMissing(ctx, file, results[p.i].Err)
+		}
+	}
+}
+
+// anyFailed returns true if a result is MoveFailed.
+func anyFailed(results []MoveResult) bool {
+	for _, r := range results {
+		if r.Status == MoveFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skipPending sets the pending sources that have no status to MoveSkipped, with the safe
+// message msg.
+func skipPending(results []MoveResult, pending []pendingMove, msg string) {
+	for _, p := range pending {
+		if results[p.i].Status != "" {
+			continue
+		}
+
+		results[p.i].Status = MoveSkipped
+		results[p.i].Err = app.Wrap(app.WrapParams{
+			Err:         errors.New("atomic bulk move did not succeed"),
+			SafeMessage: msg,
+			StatusCode:  http.StatusFailedDependency,
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package cloudstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cicconee/clox/internal/event"
+	"github.com/google/uuid"
+)
+
+// newTestMoveService creates a MoveService on f that stores directories and files in a
+// new temporary directory, which is returned.
+func newTestMoveService(t *testing.T, f *fakeStorage) (*MoveService, string) {
+	t.Helper()
+
+	dirs, root := newTestDirService(t, f)
+	files := NewFileService(FileServiceConfig{
+		Store:        f,
+		Log:          log.New(io.Discard, "", 0),
+		ValidateUser: dirs.ValidateUser,
+		PathMap:      dirs.pathMap,
+	})
+
+	return NewMoveService(dirs, files), root
+}
+
+// moveTree is the tree the bulk move tests move entries of:
+//
+//	/a.txt
+//	/photos/b.txt
+//	/docs/
+//	/docs/target/a.txt
+type moveTree struct {
+	root, photos, docs, target DirectoryRow
+	a, b                       FileRow
+
+	// aPath and bPath are the paths of the content of a and b.
+	aPath, bPath string
+}
+
+func newMoveTree(t *testing.T, f *fakeStorage, root string) moveTree {
+	t.Helper()
+
+	var tree moveTree
+	tree.root = addTestRoot(t, f, root)
+	tree.photos = addTestDir(t, f, root, tree.root, "photos")
+	tree.docs = addTestDir(t, f, root, tree.root, "docs")
+	tree.target = addTestDir(t, f, root, tree.docs, "target")
+	tree.a, tree.aPath = addTestFile(t, f, root, tree.root, "a.txt")
+	tree.b, tree.bPath = addTestFile(t, f, root, tree.photos, "b.txt")
+	addTestFile(t, f, root, tree.target, "a.txt")
+
+	return tree
+}
+
+// targetFS returns the file system path of the target directory of the tree.
+func (tree moveTree) targetFS(root string) string {
+	return filepath.Join(root, tree.root.ID, tree.docs.ID, tree.target.ID)
+}
+
+// assertResult fails t if the result does not have the status and path, or if a failed
+// result is not a app.WrappedSafeError with the status code.
+func assertResult(t *testing.T, r MoveResult, status MoveStatus, path string, code int) {
+	t.Helper()
+
+	if r.Status != status {
+		t.Errorf("result of %+v status = %s (%v), want %s", r.Source, r.Status, r.Err, status)
+	}
+
+	if r.Path != path {
+		t.Errorf("result of %+v path = %q, want %q", r.Source, r.Path, path)
+	}
+
+	if code != 0 {
+		assertSafeError(t, r.Err, code, nil)
+	}
+}
+
+func TestMoveServiceMove(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestMoveService(t, f)
+	tree := newMoveTree(t, f, root)
+	missing := uuid.NewString()
+
+	results, err := s.Move(context.Background(), tree.root.UserID, BulkMove{
+		Sources: []MoveSource{
+			{Type: EntryDir, ID: tree.photos.ID},
+			// The target is under docs, moving docs into it is a cycle.
+			{Type: EntryDir, ID: tree.docs.ID},
+			{Type: EntryDir, ID: tree.target.ID},
+			{Type: EntryFile, ID: tree.a.ID},
+			{Type: EntryFile, ID: missing},
+			{Type: EntryDir, Path: "/target/photos"},
+			{Type: EntryDir, ID: tree.photos.ID},
+		},
+		Path: "/docs/target",
+	})
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	if len(results) != 7 {
+		t.Fatalf("Move() = %d results, want 7", len(results))
+	}
+
+	assertResult(t, results[0], MoveDone, "/docs/target/photos", 0)
+	assertResult(t, results[1], MoveFailed, "", http.StatusBadRequest)
+	assertResult(t, results[2], MoveFailed, "", http.StatusBadRequest)
+	assertResult(t, results[3], MoveFailed, "", http.StatusConflict)
+	assertResult(t, results[4], MoveFailed, "", http.StatusNotFound)
+	assertResult(t, results[5], MoveFailed, "", http.StatusNotFound)
+	assertResult(t, results[6], MoveFailed, "", http.StatusBadRequest)
+
+	if msg := results[1].Msg(); msg != "Directory cannot be moved into itself" {
+		t.Errorf("cycle Msg() = %q", msg)
+	}
+
+	data := f.data()
+	if got := data.dirs[tree.photos.ID].ParentID.String; got != tree.target.ID {
+		t.Errorf("photos parent = %s, want the target %s", got, tree.target.ID)
+	}
+
+	if got := data.dirs[tree.docs.ID].ParentID.String; got != tree.root.ID {
+		t.Errorf("docs parent = %s, want it unchanged", got)
+	}
+
+	if got := data.files[tree.a.ID].DirectoryID; got != tree.root.ID {
+		t.Errorf("a.txt directory = %s, want it unchanged", got)
+	}
+
+	// Only the move that succeeded is recorded, the failed sources are rolled back.
+	if len(data.events) != 1 || data.events[0] != event.TypeDirMoved {
+		t.Errorf("events = %v, want [%s]", data.events, event.TypeDirMoved)
+	}
+
+	assertContent(t, filepath.Join(tree.targetFS(root), tree.photos.ID, tree.b.ID), "b.txt")
+	assertContent(t, tree.aPath, "a.txt")
+}
+
+func TestMoveServiceMoveAtomic(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestMoveService(t, f)
+	tree := newMoveTree(t, f, root)
+
+	before := f.data()
+
+	results, err := s.Move(context.Background(), tree.root.UserID, BulkMove{
+		Sources: []MoveSource{
+			{Type: EntryDir, ID: tree.photos.ID},
+			{Type: EntryDir, ID: tree.docs.ID},
+			{Type: EntryFile, Path: "/photos/b.txt"},
+		},
+		DirectoryID: tree.target.ID,
+		Atomic:      true,
+	})
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	// photos is moved before the cycle of docs is found, it is rolled back with it.
+	assertResult(t, results[0], MoveSkipped, "", http.StatusFailedDependency)
+	assertResult(t, results[1], MoveFailed, "", http.StatusBadRequest)
+	assertResult(t, results[2], MoveSkipped, "", http.StatusFailedDependency)
+
+	data := f.data()
+	if got := data.dirs[tree.photos.ID].ParentID.String; got != tree.root.ID {
+		t.Errorf("photos parent = %s, want it unchanged", got)
+	}
+
+	if len(data.events) != len(before.events) {
+		t.Errorf("events = %v, want none", data.events)
+	}
+
+	assertContent(t, tree.bPath, "b.txt")
+	if _, err := os.Stat(filepath.Join(tree.targetFS(root), tree.photos.ID)); !os.IsNotExist(err) {
+		t.Errorf("photos in the target directory: %v, want it moved back", err)
+	}
+}
+
+func TestMoveServiceMoveAtomicNotFound(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestMoveService(t, f)
+	tree := newMoveTree(t, f, root)
+
+	results, err := s.Move(context.Background(), tree.root.UserID, BulkMove{
+		Sources: []MoveSource{
+			{Type: EntryDir, ID: tree.photos.ID},
+			{Type: EntryFile, Path: "/photos/missing.txt"},
+		},
+		DirectoryID: tree.target.ID,
+		Atomic:      true,
+	})
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	assertResult(t, results[0], MoveSkipped, "", http.StatusFailedDependency)
+	assertResult(t, results[1], MoveFailed, "", http.StatusNotFound)
+
+	if got := f.data().dirs[tree.photos.ID].ParentID.String; got != tree.root.ID {
+		t.Errorf("photos parent = %s, want it unchanged", got)
+	}
+}
+
+func TestMoveServiceMoveCommitFailure(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestMoveService(t, f)
+	tree := newMoveTree(t, f, root)
+	f.failCommit(errors.New("connection reset"))
+
+	results, err := s.Move(context.Background(), tree.root.UserID, BulkMove{
+		Sources: []MoveSource{
+			{Type: EntryDir, ID: tree.photos.ID},
+			{Type: EntryFile, ID: tree.a.ID},
+			// a.txt is moved before its name is taken in docs.
+			{Type: EntryDir, ID: tree.target.ID},
+		},
+		DirectoryID: tree.docs.ID,
+	})
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	assertResult(t, results[0], MoveFailed, "", 0)
+	assertResult(t, results[1], MoveFailed, "", 0)
+	assertResult(t, results[2], MoveDone, "/docs/target", 0)
+
+	for _, r := range results[:2] {
+		if !errors.Is(r.Err, ErrCommitTx) {
+			t.Errorf("result of %+v error = %v, want ErrCommitTx", r.Source, r.Err)
+		}
+	}
+
+	assertContent(t, tree.aPath, "a.txt")
+	assertContent(t, tree.bPath, "b.txt")
+}
+
+func TestMoveServiceMoveInvalid(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestMoveService(t, f)
+	tree := newMoveTree(t, f, root)
+
+	source := []MoveSource{{Type: EntryFile, ID: tree.a.ID}}
+	tooMany := make([]MoveSource, MaxMoveSources+1)
+	for i := range tooMany {
+		tooMany[i] = source[0]
+	}
+
+	tests := []struct {
+		name   string
+		move   BulkMove
+		status int
+	}{
+		{name: "no destination", move: BulkMove{Sources: source}, status: http.StatusBadRequest},
+		{name: "two destinations", move: BulkMove{Sources: source, DirectoryID: tree.docs.ID, Path: "/docs"}, status: http.StatusBadRequest},
+		{name: "no sources", move: BulkMove{DirectoryID: tree.docs.ID}, status: http.StatusBadRequest},
+		{name: "too many sources", move: BulkMove{Sources: tooMany, DirectoryID: tree.docs.ID}, status: http.StatusBadRequest},
+		{name: "unknown type", move: BulkMove{Sources: []MoveSource{{Type: "x", ID: tree.a.ID}}, DirectoryID: tree.docs.ID}, status: http.StatusBadRequest},
+		{name: "id and path", move: BulkMove{Sources: []MoveSource{{Type: EntryFile, ID: tree.a.ID, Path: "/a.txt"}}, DirectoryID: tree.docs.ID}, status: http.StatusBadRequest},
+		{name: "destination not found", move: BulkMove{Sources: source, DirectoryID: uuid.NewString()}, status: http.StatusNotFound},
+		{name: "destination path not found", move: BulkMove{Sources: source, Path: "/missing"}, status: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.Move(context.Background(), tree.root.UserID, tc.move)
+			assertSafeError(t, err, tc.status, nil)
+		})
+	}
+
+	if got := f.data().events; len(got) != 0 {
+		t.Errorf("events = %v, want none", got)
+	}
+}
+
+func TestMoveServiceMoveDryRun(t *testing.T) {
+	for _, atomic := range []bool{false, true} {
+		t.Run(fmt.Sprintf("atomic=%t", atomic), func(t *testing.T) {
+			f := newFakeStorage(t)
+			s, root := newTestMoveService(t, f)
+			tree := newMoveTree(t, f, root)
+
+			move := BulkMove{
+				Sources: []MoveSource{
+					{Type: EntryDir, ID: tree.photos.ID},
+					{Type: EntryFile, ID: tree.a.ID},
+				},
+				DirectoryID: tree.target.ID,
+				Atomic:      atomic,
+				DryRun:      true,
+			}
+			if !atomic {
+				// The name of a.txt is taken in the target, it conflicts.
+				move.Sources = append(move.Sources, MoveSource{Type: EntryDir, ID: tree.docs.ID})
+			}
+
+			before := f.data()
+
+			plan, err := s.Move(context.Background(), tree.root.UserID, move)
+			if err != nil {
+				t.Fatalf("Move() dry run error = %v", err)
+			}
+
+			if !reflect.DeepEqual(f.data(), before) {
+				t.Errorf("data changed by the dry run")
+			}
+
+			assertContent(t, tree.aPath, "a.txt")
+			assertContent(t, tree.bPath, "b.txt")
+			if _, err := os.Stat(filepath.Join(tree.targetFS(root), tree.photos.ID)); !os.IsNotExist(err) {
+				t.Errorf("photos in the target directory: %v, want it not moved", err)
+			}
+
+			// The dry run has the results of the move that follows it.
+			move.DryRun = false
+			results, err := s.Move(context.Background(), tree.root.UserID, move)
+			if err != nil {
+				t.Fatalf("Move() error = %v", err)
+			}
+
+			if len(plan) != len(results) {
+				t.Fatalf("dry run = %d results, want %d", len(plan), len(results))
+			}
+
+			for i := range results {
+				if plan[i].Status != results[i].Status || plan[i].ID != results[i].ID || plan[i].Path != results[i].Path || plan[i].Msg() != results[i].Msg() {
+					t.Errorf("dry run result %d = %+v, want %+v", i, plan[i], results[i])
+				}
+			}
+
+			assertResult(t, plan[1], MoveFailed, "", http.StatusConflict)
+			if atomic {
+				assertResult(t, plan[0], MoveSkipped, "", http.StatusFailedDependency)
+			} else {
+				assertResult(t, plan[0], MoveDone, "/docs/target/photos", 0)
+				assertResult(t, plan[2], MoveFailed, "", http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestMoveServiceMoveContentMissing(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestMoveService(t, f)
+	tree := newMoveTree(t, f, root)
+
+	if err := os.Remove(tree.bPath); err != nil {
+		t.Fatalf("removing file content: %v", err)
+	}
+
+	results, err := s.Move(context.Background(), tree.root.UserID, BulkMove{
+		Sources: []MoveSource{
+			{Type: EntryFile, ID: tree.b.ID},
+			{Type: EntryDir, ID: tree.photos.ID},
+		},
+		DirectoryID: tree.docs.ID,
+	})
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	assertResult(t, results[0], MoveFailed, "", http.StatusServiceUnavailable)
+	assertResult(t, results[1], MoveDone, "/docs/photos", 0)
+
+	if !errors.Is(results[0].Err, ErrContentMissing) {
+		t.Errorf("result error = %v, want ErrContentMissing", results[0].Err)
+	}
+
+	data := f.data()
+	if got := data.files[tree.b.ID].DirectoryID; got != tree.photos.ID {
+		t.Errorf("b.txt directory = %s, want it unchanged %s", got, tree.photos.ID)
+	}
+
+	if !data.missing[tree.b.ID] {
+		t.Error("b.txt not flagged as content missing")
+	}
+}
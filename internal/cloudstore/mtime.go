@@ -0,0 +1,74 @@
+package cloudstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// MaxClientClockSkew is how far in the future a client modification time may be. A
+// time further in the future is rejected.
+const MaxClientClockSkew = 24 * time.Hour
+
+// ClientModTimes are the modification times a client declares for the files it
+// uploads, keyed by file name. A file without a time defaults to its upload time.
+type ClientModTimes map[string]time.Time
+
+// ParseClientModTime parses a client modification time. It must be RFC3339 and not
+// more than MaxClientClockSkew after now. If it is invalid, a 400 app.WrappedSafeError
+// with field set as the Field is returned.
+func ParseClientModTime(value string, now time.Time, field string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("parsing client modification time %q: %w", value, err),
+			SafeMessage: "Modification time must be a RFC3339 timestamp",
+			StatusCode:  http.StatusBadRequest,
+			Field:       field,
+		})
+	}
+
+	if t.After(now.Add(MaxClientClockSkew)) {
+		return time.Time{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("client modification time %q is in the future", value),
+			SafeMessage: "Modification time cannot be in the future",
+			StatusCode:  http.StatusBadRequest,
+			Field:       field,
+		})
+	}
+
+	return t.UTC(), nil
+}
+
+// ParseClientModTimes parses a JSON object of file names to RFC3339 modification times.
+// Every time is validated with ParseClientModTime. If value is empty, nil is returned.
+func ParseClientModTimes(value string, now time.Time, field string) (ClientModTimes, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	raw := map[string]string{}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("unmarshalling client modification times: %w", err),
+			SafeMessage: "Modification times must be a JSON object of file names to RFC3339 timestamps",
+			StatusCode:  http.StatusBadRequest,
+			Field:       field,
+		})
+	}
+
+	times := ClientModTimes{}
+	for name, v := range raw {
+		t, err := ParseClientModTime(v, now, field)
+		if err != nil {
+			return nil, err
+		}
+
+		times[name] = t
+	}
+
+	return times, nil
+}
@@ -0,0 +1,65 @@
+package cloudstore
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseClientModTime(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2024-03-01T08:30:00Z", want: time.Date(2024, time.March, 1, 8, 30, 0, 0, time.UTC)},
+		{value: "2024-03-01T08:30:00-05:00", want: time.Date(2024, time.March, 1, 13, 30, 0, 0, time.UTC)},
+		{value: "2024-03-11T12:00:00Z", want: now.Add(MaxClientClockSkew)},
+		{value: "1970-01-01T00:00:00Z", want: time.Unix(0, 0).UTC()},
+		{value: "2024-03-11T12:00:01Z", wantErr: true},
+		{value: "2024-03-01", wantErr: true},
+		{value: "1709281800", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseClientModTime(tc.value, now, "mtime")
+		if tc.wantErr {
+			assertSafeError(t, err, http.StatusBadRequest, nil)
+			continue
+		}
+
+		if err != nil || !got.Equal(tc.want) || got.Location() != time.UTC {
+			t.Errorf("ParseClientModTime(%q) = %s, %v, want %s", tc.value, got, err, tc.want)
+		}
+	}
+}
+
+func TestParseClientModTimes(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	got, err := ParseClientModTimes(`{"a.txt":"2024-03-01T08:30:00Z","b.txt":"2024-02-29T23:00:00+01:00"}`, now, "file_mtimes")
+	if err != nil {
+		t.Fatalf("ParseClientModTimes() error = %v", err)
+	}
+
+	want := ClientModTimes{
+		"a.txt": time.Date(2024, time.March, 1, 8, 30, 0, 0, time.UTC),
+		"b.txt": time.Date(2024, time.February, 29, 22, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseClientModTimes() = %v, want %v", got, want)
+	}
+
+	if got, err := ParseClientModTimes("", now, "file_mtimes"); got != nil || err != nil {
+		t.Errorf("ParseClientModTimes(\"\") = %v, %v, want nil, nil", got, err)
+	}
+
+	for _, value := range []string{`["a.txt"]`, `{"a.txt":1}`, `{"a.txt":"yesterday"}`, `{"a.txt":"2030-01-01T00:00:00Z"}`} {
+		_, err := ParseClientModTimes(value, now, "file_mtimes")
+		assertSafeError(t, err, http.StatusBadRequest, nil)
+	}
+}
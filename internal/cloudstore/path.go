@@ -12,23 +12,59 @@ import (
 	"github.com/cicconee/clox/internal/app"
 )
 
-// PathMapper maps user-facing paths to the path structure on the server.
-type PathMapper struct {
+// UserPathMapper maps user-facing paths to directory and file IDs, and IDs back to
+// user-facing paths. It only reads the database, so it does not need the root
+// storage path.
+type UserPathMapper struct{}
+
+// NewUserPathMapper creates a new UserPathMapper.
+func NewUserPathMapper() *UserPathMapper {
+	return &UserPathMapper{}
+}
+
+// FSPathMapper maps directories and files to their paths on the file system. It
+// embeds a UserPathMapper, so it also maps user-facing paths.
+type FSPathMapper struct {
+	UserPathMapper
+
 	// The path to the root storage. All paths will begin here.
 	root string
 }
 
-// NewPathMapper creates a new PathMapper.
+// NewFSPathMapper creates a new FSPathMapper under the root storage path.
+func NewFSPathMapper(root string) *FSPathMapper {
+	return &FSPathMapper{root: root}
+}
+
+// PathMapper is the FSPathMapper.
+//
+// Deprecated: Use UserPathMapper if only user-facing paths are needed, otherwise
+// FSPathMapper.
+type PathMapper = FSPathMapper
+
+// NewPathMapper creates a new FSPathMapper.
+//
+// Deprecated: Use NewUserPathMapper or NewFSPathMapper.
 func NewPathMapper(root string) *PathMapper {
-	return &PathMapper{root: root}
+	return NewFSPathMapper(root)
 }
 
 // Root returns the root storage path. All paths will be children of
 // this path.
-func (pm *PathMapper) Root() string {
+func (pm *FSPathMapper) Root() string {
 	return pm.root
 }
 
+// SystemDir is the name of the directory under the root storage path that is
+// reserved for the application. User directories are named by UUID, so it can
+// never collide with a user directory.
+const SystemDir = ".system"
+
+// System returns the path to elem under the reserved system directory.
+func (pm *FSPathMapper) System(elem ...string) string {
+	return filepath.Join(append([]string{pm.root, SystemDir}, elem...)...)
+}
+
 // PathSearch is the parameters for finding a directory or file ID based
 // on the Path, that belongs to a specific user (UserID), under their root
 // directory (RootID).
@@ -36,36 +72,53 @@ type PathSearch struct {
 	UserID string
 	RootID string
 	Path   string
+
+	// BaseID is the directory Path is resolved under. If empty, Path is resolved
+	// under RootID. A leading slash in Path does not escape BaseID.
+	BaseID string
 }
 
 // FindDir parses a path to a directory and searches for the ID. The directory
 // names in the path are mapped to the ID's on the server. If found, the ID
-// is returned.
+// is returned. The path is normalized before it is split, see splitPath, so
+// "", "/", and "//" are the starting directory and "/a//b/" is the same as "a/b".
 //
 // The directory must belong to the user (userID) and live under their
-// root directory (rootID).
-func (pm *PathMapper) FindDir(ctx context.Context, q *Query, d PathSearch) (string, error) {
-	fp := filepath.Clean(d.Path)
-	var p string
-	if fp == "." || fp == "/" {
-		p = "root"
-	} else if fp[0] == '/' {
-		p = "root" + fp
-	} else {
-		p = "root/" + fp
-	}
-	paths := strings.Split(p, "/")
-
+// root directory (rootID), or under the base directory (BaseID) if set. The
+// user must own the base directory. Errors of the base directory are field
+// errors of "base_id", and errors of the path under it are field errors of
+// "path".
+func (pm *UserPathMapper) FindDir(ctx context.Context, q Queries, d PathSearch) (string, error) {
 	directoryID := d.RootID
-	for i := 1; i < len(paths); i++ {
-		pName := paths[i]
+	if d.BaseID != "" {
+		if err := pm.findBase(ctx, q, d); err != nil {
+			return "", err
+		}
+		directoryID = d.BaseID
+	}
+
+	names, err := splitPath(d.Path)
+	if err != nil {
+		return "", err
+	}
+
+	for i, pName := range names {
 		dir, err := q.SelectDirectoryByUserNameParent(ctx, d.UserID, pName, directoryID)
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) && d.BaseID != "" {
+				return "", app.Wrap(app.WrapParams{
+					Err:         fmt.Errorf("directory '%s' does not exist [base_id: %s, path: %s]", pName, d.BaseID, d.Path),
+					SafeMessage: fmt.Sprintf("Directory '%s' does not exist under the base directory", strings.Join(names[:i+1], "/")),
+					StatusCode:  http.StatusNotFound,
+					Field:       "path",
+				})
+			}
+
 			if errors.Is(err, sql.ErrNoRows) {
 				return "", app.Wrap(app.WrapParams{
 					Err:         fmt.Errorf("directory '%s' does not exist [path: %s]", pName, d.Path),
-					SafeMessage: fmt.Sprintf("Directory '%s' does not exist", strings.Join(paths[1:i+1], "/")),
-					StatusCode:  http.StatusBadRequest,
+					SafeMessage: fmt.Sprintf("Directory '%s' does not exist", strings.Join(names[:i+1], "/")),
+					StatusCode:  http.StatusNotFound,
 				})
 			}
 
@@ -78,12 +131,106 @@ func (pm *PathMapper) FindDir(ctx context.Context, q *Query, d PathSearch) (stri
 	return directoryID, nil
 }
 
-// FindFile parses a path to a file and returns its ID.
+// findBase checks the user of d owns the base directory of d. If not, a 404
+// app.WrappedSafeError of the "base_id" field is returned.
+func (pm *UserPathMapper) findBase(ctx context.Context, q Queries, d PathSearch) error {
+	if !validID(d.BaseID) {
+		return baseNotFound(d.BaseID, sql.ErrNoRows)
+	}
+
+	if _, err := q.SelectDirectoryByIDUser(ctx, d.BaseID, d.UserID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return baseNotFound(d.BaseID, err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// baseNotFound returns the 404 app.WrappedSafeError of a base directory that a user
+// may not access. The safe message never includes baseID.
+func baseNotFound(baseID string, err error) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("base directory '%s' not found: %w", baseID, err),
+		SafeMessage: "Base directory not found",
+		StatusCode:  http.StatusNotFound,
+		Field:       "base_id",
+	})
+}
+
+// The limits of a user-facing path. They are checked before the path is normalized, so a
+// absurd path is rejected without walking it.
+const (
+	// MaxPathLength is the length in bytes of the longest path.
+	MaxPathLength = 4096
+
+	// MaxPathDepth is the largest number of names in a path.
+	MaxPathDepth = 256
+)
+
+// splitPath normalizes a user-facing path to a directory and returns the names of the
+// directories in it, relative to the directory the path is resolved under.
+//
+// A leading slash, duplicate slashes, a trailing slash, and "." elements are ignored,
+// and ".." removes the name before it. "", ".", and "/" are the directory itself and
+// have no names. A path that climbs above the directory it is resolved under, or is
+// longer than MaxPathLength or has more than MaxPathDepth names, returns a 400
+// app.WrappedSafeError of the "path" field.
+func splitPath(path string) ([]string, error) {
+	if len(path) > MaxPathLength {
+		return nil, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("path longer than %d bytes [length: %d]", MaxPathLength, len(path)),
+			SafeMessage: fmt.Sprintf("Path cannot be longer than %d characters", MaxPathLength),
+			StatusCode:  http.StatusBadRequest,
+			Field:       "path",
+		})
+	}
+
+	p := filepath.Clean(strings.TrimLeft(path, "/"))
+	if p == "." {
+		return nil, nil
+	}
+
+	if strings.Count(p, "/") >= MaxPathDepth {
+		return nil, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("path deeper than %d [path: %s]", MaxPathDepth, p),
+			SafeMessage: fmt.Sprintf("Path cannot have more than %d names", MaxPathDepth),
+			StatusCode:  http.StatusBadRequest,
+			Field:       "path",
+		})
+	}
+
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return nil, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("path climbs above its directory [path: %s]", path),
+			SafeMessage: "Path cannot go above its starting directory",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "path",
+		})
+	}
+
+	return strings.Split(p, "/"), nil
+}
+
+// FindFile parses a path to a file and returns its ID. The path is normalized the
+// same as FindDir. A path that ends with a slash or has no names is not a file path.
 //
 // All directories and files in the path must belong to the user and live
 // within the users root directory on the server.
-func (pm *PathMapper) FindFile(ctx context.Context, q *Query, s PathSearch) (string, error) {
-	dirs, file := filepath.Split(s.Path)
+func (pm *UserPathMapper) FindFile(ctx context.Context, q Queries, s PathSearch) (string, error) {
+	names, err := splitPath(s.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var dirs, file string
+	if len(names) > 0 && !strings.HasSuffix(s.Path, "/") {
+		dirs = strings.Join(names[:len(names)-1], "/")
+		file = names[len(names)-1]
+	}
+
 	if file == "" {
 		return "", app.Wrap(app.WrapParams{
 			Err:         fmt.Errorf("file name undefined [path: %s]", s.Path),
@@ -107,7 +254,7 @@ func (pm *PathMapper) FindFile(ctx context.Context, q *Query, s PathSearch) (str
 			return "", app.Wrap(app.WrapParams{
 				Err:         fmt.Errorf("file %q does not exist [path: %s]", file, s.Path),
 				SafeMessage: fmt.Sprintf("File '%s' does not exist", file),
-				StatusCode:  http.StatusBadRequest,
+				StatusCode:  http.StatusNotFound,
 			})
 		}
 
@@ -120,38 +267,32 @@ func (pm *PathMapper) FindFile(ctx context.Context, q *Query, s PathSearch) (str
 // GetDir returns the name based path to the directory (id). The path will not
 // contain a trailing slash unless it is the users root path. Users root path
 // will be returned as "/".
-func (pm *PathMapper) GetDir(ctx context.Context, q *Query, id string) (string, error) {
+func (pm *UserPathMapper) GetDir(ctx context.Context, q Queries, id string) (string, error) {
 	namePath, err := q.SelectDirectoryPath(ctx, id)
 	if err != nil {
 		return "", err
 	}
 
-	// Ignore the "root" level.
-	userPath := strings.Join(namePath, "/")
-	userPath = strings.TrimPrefix(userPath, "root")
-	if userPath == "" {
-		userPath = "/"
+	// Ignore the root directory, it is the first name of every path.
+	if len(namePath) > 0 {
+		namePath = namePath[1:]
 	}
 
-	return userPath, nil
+	return "/" + strings.Join(namePath, "/"), nil
 }
 
 // GetFile returns the name based path to the file.
-func (pm *PathMapper) GetFile(ctx context.Context, q *Query, dirID string, filename string) (string, error) {
+func (pm *UserPathMapper) GetFile(ctx context.Context, q Queries, dirID string, filename string) (string, error) {
 	dirPath, err := pm.GetDir(ctx, q, dirID)
 	if err != nil {
 		return "", err
 	}
 
-	if dirPath == "/" {
-		return dirPath + filename, nil
-	}
-
-	return dirPath + "/" + filename, nil
+	return childPath(dirPath, filename), nil
 }
 
 // GetDirFS returns the file system path to the directory (id).
-func (pm *PathMapper) GetDirFS(ctx context.Context, q *Query, id string) (string, error) {
+func (pm *FSPathMapper) GetDirFS(ctx context.Context, q Queries, id string) (string, error) {
 	// Get the path used on the file system.
 	idPath, err := q.SelectDirectoryFSPath(ctx, id)
 	if err != nil {
@@ -161,12 +302,18 @@ func (pm *PathMapper) GetDirFS(ctx context.Context, q *Query, id string) (string
 	return fmt.Sprintf("%s/%s", pm.root, strings.Join(idPath, "/")), nil
 }
 
-// GetFileFS returns the file system path to the file.
-func (pm *PathMapper) GetFileFS(ctx context.Context, q *Query, dirID string, fileID string) (string, error) {
+// GetFileFS returns the file system path to the file. The path depends on the Layout
+// of the directory.
+func (pm *FSPathMapper) GetFileFS(ctx context.Context, q Queries, dirID string, fileID string) (string, error) {
 	dirIDPath, err := q.SelectDirectoryFSPath(ctx, dirID)
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("%s/%s/%s", pm.root, strings.Join(dirIDPath, "/"), fileID), nil
+	layout, err := q.SelectDirectoryLayout(ctx, dirID)
+	if err != nil {
+		return "", err
+	}
+
+	return layout.FilePath(fmt.Sprintf("%s/%s", pm.root, strings.Join(dirIDPath, "/")), fileID), nil
 }
@@ -0,0 +1,388 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/google/uuid"
+)
+
+func TestSplitPath(t *testing.T) {
+	deep := strings.Repeat("a/", MaxPathDepth)
+
+	tests := []struct {
+		name string
+		path string
+		want []string
+
+		// wantErr is a part of the error message, or empty if the path is valid.
+		wantErr string
+	}{
+		{name: "empty", path: ""},
+		{name: "slash", path: "/"},
+		{name: "dot", path: "."},
+		{name: "names", path: "/photos/2024/", want: []string{"photos", "2024"}},
+		{name: "duplicate slashes", path: "//photos//2024", want: []string{"photos", "2024"}},
+		{name: "dot dot", path: "/photos/../docs/./a", want: []string{"docs", "a"}},
+		{name: "climbs", path: "/photos/../..", wantErr: "climbs above"},
+		{name: "at length", path: "/" + strings.Repeat("a", MaxPathLength-1), want: []string{strings.Repeat("a", MaxPathLength-1)}},
+		{name: "too long", path: "/" + strings.Repeat("a", MaxPathLength), wantErr: "longer than"},
+		{name: "too long after clean", path: strings.Repeat("/", MaxPathLength+1), wantErr: "longer than"},
+		{name: "at depth", path: deep[:len(deep)-1], want: strings.Split(deep[:len(deep)-1], "/")},
+		{name: "too deep", path: deep + "a", wantErr: "deeper than"},
+		{name: "climbs at depth", path: strings.Repeat("../", MaxPathDepth), wantErr: "climbs above"},
+		{name: "too deep climbing", path: strings.Repeat("../", MaxPathDepth+1), wantErr: "deeper than"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := splitPath(tc.path)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("splitPath() error = %v, want nil", err)
+				}
+
+				if len(got) != 0 || len(tc.want) != 0 {
+					if !reflect.DeepEqual(got, tc.want) {
+						t.Errorf("splitPath() = %v, want %v", got, tc.want)
+					}
+				}
+				return
+			}
+
+			assertSafeError(t, err, http.StatusBadRequest, nil)
+
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("splitPath() error = %q, want it to contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplitPathAdversarial(t *testing.T) {
+	// Paths far over the limits are rejected by their length, without normalizing them.
+	paths := []string{
+		strings.Repeat("a/", 1<<20),
+		strings.Repeat("../", 1<<20),
+		strings.Repeat("/", 1<<22),
+		strings.Repeat("./", 1<<20),
+	}
+
+	start := time.Now()
+	for _, p := range paths {
+		if _, err := splitPath(p); err == nil {
+			t.Errorf("splitPath() of a %d byte path error = nil, want an error", len(p))
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("splitPath() took %s, want the paths rejected without walking them", elapsed)
+	}
+}
+
+// assertFieldError fails t if err is not a app.WrappedSafeError with the status code, safe
+// message, and field.
+func assertFieldError(t *testing.T, err error, status int, msg string, field string) {
+	t.Helper()
+
+	var safeErr *app.WrappedSafeError
+	if !errors.As(err, &safeErr) {
+		t.Fatalf("error = %v, want a app.WrappedSafeError", err)
+	}
+
+	if gotMsg, gotStatus := safeErr.Safe(); gotStatus != status || gotMsg != msg {
+		t.Errorf("error = %d %q, want %d %q", gotStatus, gotMsg, status, msg)
+	}
+
+	if got := safeErr.Field(); got != field {
+		t.Errorf("field = %q, want %q", got, field)
+	}
+}
+
+func TestFindDirBase(t *testing.T) {
+	f := newFakeStorage(t)
+	root := t.TempDir()
+	userRoot := addTestRoot(t, f, root)
+	reports := addTestDir(t, f, root, userRoot, "reports")
+	year := addTestDir(t, f, root, reports, "2024")
+	foreign := addTestRoot(t, f, root)
+
+	tests := []struct {
+		name   string
+		baseID string
+		path   string
+		want   string
+
+		// wantMsg and wantField are the error, or empty if the directory is found.
+		wantMsg   string
+		wantField string
+	}{
+		{name: "root", path: "/reports/2024", want: year.ID},
+		{name: "base", baseID: reports.ID, path: "2024", want: year.ID},
+		{name: "base itself", baseID: reports.ID, path: "", want: reports.ID},
+		{name: "leading slash", baseID: reports.ID, path: "/2024", want: year.ID},
+		{
+			name:      "path not under base",
+			baseID:    reports.ID,
+			path:      "reports/2024",
+			wantMsg:   "Directory 'reports' does not exist under the base directory",
+			wantField: "path",
+		},
+		{name: "foreign base", baseID: foreign.ID, path: "", wantMsg: "Base directory not found", wantField: "base_id"},
+		{name: "missing base", baseID: uuid.NewString(), path: "", wantMsg: "Base directory not found", wantField: "base_id"},
+		{name: "malformed base", baseID: "reports", path: "2024", wantMsg: "Base directory not found", wantField: "base_id"},
+	}
+
+	pm := NewUserPathMapper()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := pm.FindDir(context.Background(), f, PathSearch{
+				UserID: userRoot.UserID,
+				RootID: userRoot.ID,
+				Path:   tc.path,
+				BaseID: tc.baseID,
+			})
+			if tc.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("FindDir() error = %v", err)
+				}
+
+				if got != tc.want {
+					t.Errorf("FindDir() = %s, want %s", got, tc.want)
+				}
+				return
+			}
+
+			assertFieldError(t, err, http.StatusNotFound, tc.wantMsg, tc.wantField)
+		})
+	}
+}
+
+func TestDirServiceNewPathRelative(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	reports := addTestDir(t, f, root, userRoot, "reports")
+	year := addTestDir(t, f, root, reports, "2024")
+
+	dir, err := s.NewPathRelative(context.Background(), userRoot.UserID, "q1", reports.ID, "2024")
+	if err != nil {
+		t.Fatalf("NewPathRelative() error = %v", err)
+	}
+
+	if dir.ParentID != year.ID || dir.Path != "/reports/2024/q1" {
+		t.Errorf("NewPathRelative() = %s under %s, want /reports/2024/q1 under %s", dir.Path, dir.ParentID, year.ID)
+	}
+
+	// Without a base, the path is resolved under the root directory.
+	_, err = s.NewPathRelative(context.Background(), userRoot.UserID, "q1", "", "2024")
+	assertSafeError(t, err, http.StatusNotFound, nil)
+}
+
+func TestFindPathological(t *testing.T) {
+	f := newFakeStorage(t)
+	root := t.TempDir()
+	userRoot := addTestRoot(t, f, root)
+	a := addTestDir(t, f, root, userRoot, "a")
+	ab := addTestDir(t, f, root, a, "b")
+	b := addTestDir(t, f, root, userRoot, "b")
+	fileAB, _ := addTestFile(t, f, root, a, "b")
+	fileB, _ := addTestFile(t, f, root, userRoot, "b")
+
+	// The IDs each path resolves to as a directory and as a file. A empty ID is a 400
+	// error.
+	tests := []struct {
+		path string
+		dir  string
+		file string
+	}{
+		{path: "", dir: userRoot.ID},
+		{path: ".", dir: userRoot.ID},
+		{path: "/", dir: userRoot.ID},
+		{path: "//", dir: userRoot.ID},
+		{path: ".."},
+		{path: "/a//b/", dir: ab.ID},
+		{path: "/a//b", dir: ab.ID, file: fileAB.ID},
+		{path: "a/./b", dir: ab.ID, file: fileAB.ID},
+		{path: "a/../b", dir: b.ID, file: fileB.ID},
+	}
+
+	pm := NewUserPathMapper()
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			search := PathSearch{UserID: userRoot.UserID, RootID: userRoot.ID, Path: tc.path}
+
+			dir, err := pm.FindDir(context.Background(), f, search)
+			if tc.dir == "" {
+				assertSafeError(t, err, http.StatusBadRequest, nil)
+			} else if err != nil || dir != tc.dir {
+				t.Errorf("FindDir() = %s, %v, want %s", dir, err, tc.dir)
+			}
+
+			file, err := pm.FindFile(context.Background(), f, search)
+			if tc.file == "" {
+				assertSafeError(t, err, http.StatusBadRequest, nil)
+			} else if err != nil || file != tc.file {
+				t.Errorf("FindFile() = %s, %v, want %s", file, err, tc.file)
+			}
+		})
+	}
+}
+
+func TestFindNotFoundMessage(t *testing.T) {
+	f := newFakeStorage(t)
+	root := t.TempDir()
+	userRoot := addTestRoot(t, f, root)
+	addTestDir(t, f, root, userRoot, "a")
+
+	// The message names the normalized path, never a empty name.
+	pm := NewUserPathMapper()
+	_, err := pm.FindDir(context.Background(), f, PathSearch{UserID: userRoot.UserID, RootID: userRoot.ID, Path: "//a//missing/"})
+	assertFieldError(t, err, http.StatusNotFound, "Directory 'a/missing' does not exist", "")
+
+	_, err = pm.FindFile(context.Background(), f, PathSearch{UserID: userRoot.UserID, RootID: userRoot.ID, Path: "//a//missing"})
+	assertFieldError(t, err, http.StatusNotFound, "File 'missing' does not exist", "")
+}
+
+func TestUserPathMapper(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	userRoot := addTestRoot(t, f, t.TempDir())
+	photos := DirectoryRow{ID: uuid.NewString(), UserID: userRoot.UserID, Name: "photos", ParentID: sql.NullString{String: userRoot.ID, Valid: true}}
+	f.addDir(photos)
+
+	// A UserPathMapper has no root, it only reads the Queries.
+	pm := NewUserPathMapper()
+
+	tests := []struct {
+		dirID string
+		want  string
+	}{
+		{dirID: userRoot.ID, want: "/"},
+		{dirID: photos.ID, want: "/photos"},
+	}
+
+	for _, tc := range tests {
+		got, err := pm.GetDir(ctx, f, tc.dirID)
+		if err != nil || got != tc.want {
+			t.Errorf("GetDir() = %q, %v, want %q", got, err, tc.want)
+		}
+
+		file, err := pm.GetFile(ctx, f, tc.dirID, "a.txt")
+		if want := childPath(tc.want, "a.txt"); err != nil || file != want {
+			t.Errorf("GetFile() = %q, %v, want %q", file, err, want)
+		}
+	}
+
+	id, err := pm.FindDir(ctx, f, PathSearch{UserID: userRoot.UserID, RootID: userRoot.ID, Path: "/photos"})
+	if err != nil || id != photos.ID {
+		t.Errorf("FindDir() = %s, %v, want %s", id, err, photos.ID)
+	}
+}
+
+// TestUserPathMapperNamedRoot finds and gets the paths of directories named RootName
+// below the root directory. Only the root directory is dropped from a path.
+func TestUserPathMapperNamedRoot(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	root := t.TempDir()
+	userRoot := addTestRoot(t, f, root)
+
+	// root
+	// └── root
+	//     └── a
+	//         └── root
+	top := addTestDir(t, f, root, userRoot, RootName)
+	a := addTestDir(t, f, root, top, "a")
+	deep := addTestDir(t, f, root, a, RootName)
+
+	pm := NewUserPathMapper()
+	tests := []struct {
+		dirID string
+		path  string
+	}{
+		{dirID: userRoot.ID, path: "/"},
+		{dirID: top.ID, path: "/root"},
+		{dirID: a.ID, path: "/root/a"},
+		{dirID: deep.ID, path: "/root/a/root"},
+	}
+
+	for _, tc := range tests {
+		got, err := pm.GetDir(ctx, f, tc.dirID)
+		if err != nil || got != tc.path {
+			t.Errorf("GetDir() = %q, %v, want %q", got, err, tc.path)
+		}
+
+		id, err := pm.FindDir(ctx, f, PathSearch{UserID: userRoot.UserID, RootID: userRoot.ID, Path: tc.path})
+		if err != nil || id != tc.dirID {
+			t.Errorf("FindDir(%q) = %s, %v, want %s", tc.path, id, err, tc.dirID)
+		}
+	}
+
+	// A path relative to a directory named RootName starts below it.
+	id, err := pm.FindDir(ctx, f, PathSearch{UserID: userRoot.UserID, RootID: userRoot.ID, BaseID: top.ID, Path: "a/root"})
+	if err != nil || id != deep.ID {
+		t.Errorf("FindDir() relative = %s, %v, want %s", id, err, deep.ID)
+	}
+
+	_, err = pm.FindDir(ctx, f, PathSearch{UserID: userRoot.UserID, RootID: userRoot.ID, Path: "/root/root"})
+	assertSafeError(t, err, http.StatusNotFound, nil)
+}
+
+func TestFSPathMapper(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	root := t.TempDir()
+	userRoot := addTestRoot(t, f, root)
+	photos := addTestDir(t, f, root, userRoot, "photos")
+	f.concurrent(func(d *fakeData) { d.layouts[photos.ID] = LayoutFanOut })
+
+	pm := NewFSPathMapper(root)
+	if pm.Root() != root {
+		t.Errorf("Root() = %s, want %s", pm.Root(), root)
+	}
+
+	if got, want := pm.System("backfill"), filepath.Join(root, SystemDir, "backfill"); got != want {
+		t.Errorf("System() = %s, want %s", got, want)
+	}
+
+	dirFS, err := pm.GetDirFS(ctx, f, photos.ID)
+	if want := root + "/" + userRoot.ID + "/" + photos.ID; err != nil || dirFS != want {
+		t.Errorf("GetDirFS() = %s, %v, want %s", dirFS, err, want)
+	}
+
+	// The path of a file depends on the layout of its directory.
+	fileID := uuid.NewString()
+	tests := []struct {
+		dirID string
+		want  string
+	}{
+		{dirID: userRoot.ID, want: root + "/" + userRoot.ID + "/" + fileID},
+		{dirID: photos.ID, want: dirFS + "/" + fileID[:shardLength] + "/" + fileID},
+	}
+
+	for _, tc := range tests {
+		got, err := pm.GetFileFS(ctx, f, tc.dirID, fileID)
+		if err != nil || got != tc.want {
+			t.Errorf("GetFileFS() = %s, %v, want %s", got, err, tc.want)
+		}
+	}
+
+	// The FSPathMapper also maps user-facing paths.
+	if got, err := pm.GetDir(ctx, f, photos.ID); err != nil || got != "/photos" {
+		t.Errorf("GetDir() = %q, %v, want /photos", got, err)
+	}
+
+	// The deprecated constructor is the FSPathMapper.
+	if NewPathMapper(root).Root() != root {
+		t.Errorf("NewPathMapper() root = %s, want %s", NewPathMapper(root).Root(), root)
+	}
+}
@@ -0,0 +1,89 @@
+package cloudstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FileStatus is the status of a row in the files table.
+//
+// In strict mode, see FileServiceConfig.Strict, a upload is written in two phases. The
+// content is written to the staged path of the file, and the row is committed as
+// FilePending. The staged content is then renamed into place and the row is set to
+// FileReady. A crash between the phases leaves a FilePending row that is resolved by
+// ResolvePending. The queries that read files for users only select FileReady rows,
+// so a file is never readable before its content is in place.
+type FileStatus string
+
+const (
+	// FilePending is a file whose content may not be in place yet.
+	FilePending FileStatus = "pending"
+
+	// FileReady is a file whose content is in place. Files uploaded outside of strict
+	// mode are always FileReady.
+	FileReady FileStatus = "ready"
+)
+
+// stagedPath returns the path the content of the file at fsPath is written to before
+// it is promoted. It is in the same directory, so the rename is atomic.
+func stagedPath(fsPath string) string {
+	return fsPath + ".staged"
+}
+
+// PendingGrace is how long a file may be FilePending before ResolvePending resolves it.
+// It is longer than a upload takes to promote, so the files of uploads in progress are
+// not resolved.
+const PendingGrace = 15 * time.Minute
+
+// ResolvePending resolves at most CleanupBatchSize files that have been FilePending for
+// longer than PendingGrace. A file whose staged content, or content in place, is the
+// size it was uploaded with is promoted. Any other file was never fully written, its
+// row and staged content are deleted. It returns the number of files promoted and
+// deleted.
+//
+// Hooks are not run for the files promoted by ResolvePending.
+func (s *FileService) ResolvePending(ctx context.Context) (promoted int, deleted int, err error) {
+	rows, err := s.store.SelectPendingFiles(ctx, time.Now().Add(-PendingGrace), CleanupBatchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("selecting pending files: %w", err)
+	}
+
+	q := s.store
+
+	for _, row := range rows {
+		fsPath, err := s.pathMap.GetFileFS(ctx, q, row.DirectoryID, row.ID)
+		if err != nil {
+			return promoted, deleted, fmt.Errorf("getting file path [id: %s]: %w", row.ID, err)
+		}
+
+		if s.complete(stagedPath(fsPath), row.Size) || s.complete(fsPath, row.Size) {
+			if err := s.io.PromoteFile(ctx, q, row.ID, fsPath); err != nil {
+				s.log.Printf("[ERROR] Promoting pending file [id: %s, path: %s]: %v\n", row.ID, fsPath, err)
+				continue
+			}
+
+			s.listings.Invalidate(ctx, row.DirectoryID)
+			promoted++
+			continue
+		}
+
+		ok, err := s.store.DeletePendingFile(ctx, row.ID)
+		if err != nil {
+			return promoted, deleted, fmt.Errorf("deleting pending file [id: %s]: %w", row.ID, err)
+		}
+
+		if ok {
+			s.removeFS(stagedPath(fsPath))
+			deleted++
+		}
+	}
+
+	return promoted, deleted, nil
+}
+
+// complete returns true if the file at fsPath exists and is size bytes.
+func (s *FileService) complete(fsPath string, size int64) bool {
+	stat, err := s.io.fs.Stat(fsPath)
+	return err == nil && !stat.IsDir() && stat.Size() == size
+}
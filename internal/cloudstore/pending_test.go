@@ -0,0 +1,247 @@
+package cloudstore
+
+import (
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// strictTest is a FileService in strict mode and a DirService on the same fakeStorage.
+type strictTest struct {
+	f        *fakeStorage
+	files    *FileService
+	dirs     *DirService
+	root     string
+	userRoot DirectoryRow
+}
+
+func newStrictTest(t *testing.T) strictTest {
+	t.Helper()
+
+	f := newFakeStorage(t)
+	files, root := newTestFileService(t, f)
+	files.strict = true
+	userRoot := addTestRoot(t, f, root)
+	files.validateUser = func(ctx context.Context, userID string) (Dir, error) {
+		return Dir{ID: userRoot.ID}, nil
+	}
+
+	dirs, _ := newTestDirService(t, f)
+
+	return strictTest{f: f, files: files, dirs: dirs, root: root, userRoot: userRoot}
+}
+
+// upload uploads the file a.txt, which is hello, and returns it.
+func (s strictTest) upload(t *testing.T) FileInfo {
+	t.Helper()
+
+	batch, err := s.files.SaveBatch(context.Background(), s.userRoot.UserID, "", []*multipart.FileHeader{
+		newTestFileHeader(t, "a.txt", "hello"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+
+	return batch.Saves[0].FileInfo
+}
+
+// age makes the pending files older than PendingGrace, as if the server stopped long
+// enough ago for ResolvePending to resolve them.
+func (s strictTest) age() {
+	s.f.concurrent(func(d *fakeData) {
+		for id, file := range d.files {
+			file.UploadedAt = file.UploadedAt.Add(-PendingGrace - time.Minute)
+			d.files[id] = file
+		}
+	})
+}
+
+// assertResolve fails t if ResolvePending does not promote and delete the files.
+func (s strictTest) assertResolve(t *testing.T, promoted int, deleted int) {
+	t.Helper()
+
+	p, d, err := s.files.ResolvePending(context.Background())
+	if err != nil {
+		t.Fatalf("ResolvePending() error = %v", err)
+	}
+
+	if p != promoted || d != deleted {
+		t.Errorf("ResolvePending() = %d promoted, %d deleted, want %d, %d", p, d, promoted, deleted)
+	}
+}
+
+// assertHidden fails t if the file id can be read, listed, or found by the user.
+func (s strictTest) assertHidden(t *testing.T, id string) {
+	t.Helper()
+
+	ctx := context.Background()
+	userID := s.userRoot.UserID
+
+	_, err := s.files.Info(ctx, userID, id)
+	assertSafeError(t, err, http.StatusNotFound, nil)
+
+	entries, err := s.dirs.ListEntries(ctx, userID, "", ListOptions{Files: true, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListEntries() error = %v", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("ListEntries() = %v, want no files", entries)
+	}
+
+	hits, err := s.files.Search(ctx, userID, SearchParams{Query: "a.txt"})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+
+	if len(hits) != 0 {
+		t.Errorf("Search() = %v, want no hits", hits)
+	}
+}
+
+// assertReady fails t if the file id cannot be read with its content in place.
+func (s strictTest) assertReady(t *testing.T, id string) {
+	t.Helper()
+
+	info, err := s.files.Info(context.Background(), s.userRoot.UserID, id)
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+
+	assertContent(t, info.FSPath, "hello")
+	if _, err := os.Stat(stagedPath(info.FSPath)); !os.IsNotExist(err) {
+		t.Errorf("staged content stat error = %v, want it promoted", err)
+	}
+}
+
+func TestStrictUpload(t *testing.T) {
+	s := newStrictTest(t)
+
+	file := s.upload(t)
+	s.assertReady(t, file.ID)
+
+	s.age()
+	s.assertResolve(t, 0, 0)
+}
+
+func TestStrictCrashBeforeCommit(t *testing.T) {
+	s := newStrictTest(t)
+
+	// The server stops once the content is staged, the transaction is never committed.
+	var id string
+	s.f.failOn("UpdateLastWrite", func() error {
+		for fileID := range s.f.data().files {
+			id = fileID
+		}
+
+		return nil
+	})
+	s.f.failCommit(errors.New("server stopped"))
+	s.upload(t)
+
+	if id == "" {
+		t.Fatal("file not inserted before the commit")
+	}
+
+	if len(s.f.data().files) != 0 {
+		t.Errorf("files = %v, want the row rolled back", s.f.data().files)
+	}
+	s.assertHidden(t, id)
+
+	// The failed commit removes the staged content in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dirEntries(t, filepath.Join(s.root, s.userRoot.ID))) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("staged content of the uncommitted file not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.f.clearFailures()
+	s.age()
+	s.assertResolve(t, 0, 0)
+}
+
+func TestStrictCrashBeforePromote(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// staged is the staged content left by the crash, it is removed if empty.
+		staged   string
+		promoted int
+		deleted  int
+	}{
+		{name: "staged content complete", staged: "hello", promoted: 1},
+		{name: "staged content partial", staged: "hel", deleted: 1},
+		{name: "staged content lost", deleted: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newStrictTest(t)
+
+			// The row is committed as pending, the server stops before the content is
+			// renamed into place.
+			s.f.fail("UpdateFileStatus", errors.New("server stopped"))
+			file := s.upload(t)
+			if err := os.Remove(file.FSPath); err != nil {
+				t.Fatalf("removing promoted content: %v", err)
+			}
+			if tc.staged != "" {
+				if err := os.WriteFile(stagedPath(file.FSPath), []byte(tc.staged), 0600); err != nil {
+					t.Fatalf("writing staged content: %v", err)
+				}
+			}
+			s.f.clearFailures()
+
+			if got := s.f.data().statuses[file.ID]; got != FilePending {
+				t.Fatalf("status = %q, want %q", got, FilePending)
+			}
+			s.assertHidden(t, file.ID)
+
+			// A pending file that is not past the grace may still be promoted by its upload.
+			s.assertResolve(t, 0, 0)
+
+			s.age()
+			s.assertResolve(t, tc.promoted, tc.deleted)
+
+			if tc.promoted > 0 {
+				s.assertReady(t, file.ID)
+				return
+			}
+
+			s.assertHidden(t, file.ID)
+			if _, ok := s.f.data().files[file.ID]; ok {
+				t.Error("pending row not deleted")
+			}
+
+			if entries := dirEntries(t, filepath.Join(s.root, s.userRoot.ID)); len(entries) != 0 {
+				t.Errorf("file system entries = %v, want the staged content removed", entries)
+			}
+		})
+	}
+}
+
+func TestStrictCrashAfterPromote(t *testing.T) {
+	s := newStrictTest(t)
+
+	// The content is renamed into place, the server stops before the row is set ready.
+	s.f.fail("UpdateFileStatus", errors.New("server stopped"))
+	file := s.upload(t)
+	assertContent(t, file.FSPath, "hello")
+	s.assertHidden(t, file.ID)
+
+	// The janitor stops the same way, the file is left pending for the next run.
+	s.age()
+	s.assertResolve(t, 0, 0)
+	s.assertHidden(t, file.ID)
+
+	s.f.clearFailures()
+	s.assertResolve(t, 1, 0)
+	s.assertReady(t, file.ID)
+}
@@ -0,0 +1,98 @@
+package cloudstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+)
+
+// ErrInvalidPerm signals a permission value cannot be used for the file store.
+var ErrInvalidPerm = errors.New("invalid permission")
+
+// Perm is the permission bits of a file or directory in the file store.
+type Perm fs.FileMode
+
+// The default permissions. Only the system user that is running this application can
+// access the file store.
+const (
+	DefaultDirPerm  Perm = 0700
+	DefaultFilePerm Perm = 0600
+)
+
+// The permission bits a directory and file must grant the owner.
+const (
+	requiredDirPerm  Perm = 0700
+	requiredFilePerm Perm = 0600
+	otherWritePerm   Perm = 0002
+)
+
+// FileMode returns p as a fs.FileMode.
+func (p Perm) FileMode() fs.FileMode {
+	return fs.FileMode(p)
+}
+
+// String returns p as an octal string, for example "0700".
+func (p Perm) String() string {
+	return fmt.Sprintf("%04o", uint32(p))
+}
+
+// PermConfig is the raw permission configuration of the file store.
+type PermConfig struct {
+	// Dir is the octal directory permission. If empty, DefaultDirPerm is used.
+	Dir string
+
+	// File is the octal file permission. If empty, DefaultFilePerm is used.
+	File string
+
+	// AllowWorldWritable allows permissions that grant write to others.
+	AllowWorldWritable bool
+}
+
+// ParsePerms parses and validates the directory and file permissions of c. The
+// directory permission must grant the owner read, write, and execute, and the file
+// permission must grant the owner read and write. Neither may grant write to others
+// unless AllowWorldWritable is set.
+//
+// Errors are a ErrInvalidPerm naming dirVar or fileVar, the names of the variables
+// the values were read from.
+func ParsePerms(c PermConfig, dirVar string, fileVar string) (dir Perm, file Perm, err error) {
+	dir, err = parsePerm(c.Dir, DefaultDirPerm, requiredDirPerm, c.AllowWorldWritable)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", dirVar, err)
+	}
+
+	file, err = parsePerm(c.File, DefaultFilePerm, requiredFilePerm, c.AllowWorldWritable)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", fileVar, err)
+	}
+
+	return dir, file, nil
+}
+
+// parsePerm parses s as octal permission bits. If s is empty, def is returned.
+func parsePerm(s string, def Perm, required Perm, allowWorldWritable bool) (Perm, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w %q: must be an octal number such as %s", ErrInvalidPerm, s, def)
+	}
+
+	p := Perm(v)
+	if p&^0777 != 0 {
+		return 0, fmt.Errorf("%w %q: only permission bits (0777) may be set", ErrInvalidPerm, s)
+	}
+
+	if p&required != required {
+		return 0, fmt.Errorf("%w %q: must grant the owner at least %s", ErrInvalidPerm, s, required)
+	}
+
+	if p&otherWritePerm != 0 && !allowWorldWritable {
+		return 0, fmt.Errorf("%w %q: grants write to others, set ALLOW_WORLD_WRITABLE to allow it", ErrInvalidPerm, s)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,76 @@
+package cloudstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePerms(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   PermConfig
+		wantDir  Perm
+		wantFile Perm
+
+		// wantErr is the variable the error names, or empty if the config is valid.
+		wantErr string
+	}{
+		{name: "defaults", wantDir: DefaultDirPerm, wantFile: DefaultFilePerm},
+		{name: "group readable", config: PermConfig{Dir: "0750", File: "640"}, wantDir: 0750, wantFile: 0640},
+		{name: "world writable allowed", config: PermConfig{Dir: "0777", File: "0666", AllowWorldWritable: true}, wantDir: 0777, wantFile: 0666},
+		{name: "not octal", config: PermConfig{Dir: "0790"}, wantErr: "FS_DIR_PERM"},
+		{name: "decimal", config: PermConfig{File: "rw-------"}, wantErr: "FS_FILE_PERM"},
+		{name: "setuid", config: PermConfig{Dir: "4700"}, wantErr: "FS_DIR_PERM"},
+		{name: "directory without owner execute", config: PermConfig{Dir: "0600"}, wantErr: "FS_DIR_PERM"},
+		{name: "file without owner write", config: PermConfig{File: "0400"}, wantErr: "FS_FILE_PERM"},
+		{name: "world writable directory", config: PermConfig{Dir: "0777"}, wantErr: "FS_DIR_PERM"},
+		{name: "world writable file", config: PermConfig{File: "0602"}, wantErr: "FS_FILE_PERM"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, file, err := ParsePerms(tc.config, "FS_DIR_PERM", "FS_FILE_PERM")
+			if tc.wantErr == "" {
+				if err != nil || dir != tc.wantDir || file != tc.wantFile {
+					t.Errorf("ParsePerms() = %s, %s, %v, want %s, %s", dir, file, err, tc.wantDir, tc.wantFile)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrInvalidPerm) {
+				t.Fatalf("ParsePerms() error = %v, want ErrInvalidPerm", err)
+			}
+
+			if !strings.HasPrefix(err.Error(), tc.wantErr+":") {
+				t.Errorf("ParsePerms() error = %q, want it to name %s", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDirServiceNewPerm(t *testing.T) {
+	f := newFakeStorage(t)
+	root := t.TempDir()
+	s := NewDirService(DirServiceConfig{Store: f, PathMap: NewPathMapper(root), Perm: 0750, Log: log.New(io.Discard, "", 0)})
+	userRoot := addTestRoot(t, f, root)
+
+	dir, err := s.New(context.Background(), userRoot.UserID, "photos", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(root, userRoot.ID, dir.ID))
+	if err != nil {
+		t.Fatalf("stat directory: %v", err)
+	}
+
+	if got := Perm(info.Mode().Perm()); got != 0750 {
+		t.Errorf("directory permission = %s, want 0750", got)
+	}
+}
@@ -0,0 +1,40 @@
+package cloudstore
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// previewTypes are the content types of the extensions of the files a preview serves
+// inline. The extension is trusted over the detected content type, since
+// http.DetectContentType only recognizes a few media containers and mime.TypeByExtension
+// has no audio or video types without the mime database of the system.
+var previewTypes = map[string]string{
+	".mp4": "video/mp4", ".m4v": "video/mp4", ".webm": "video/webm", ".mov": "video/quicktime",
+	".mkv": "video/x-matroska", ".ogv": "video/ogg",
+	".mp3": "audio/mpeg", ".m4a": "audio/mp4", ".aac": "audio/aac", ".wav": "audio/wav",
+	".ogg": "audio/ogg", ".oga": "audio/ogg", ".opus": "audio/ogg", ".flac": "audio/flac",
+	".png": "image/png", ".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".gif": "image/gif",
+	".webp": "image/webp", ".avif": "image/avif", ".bmp": "image/bmp",
+}
+
+// PreviewType returns the content type a preview of the file is served with, and true if
+// a browser may render it inline. Video, audio, and image files are served inline with
+// the type of their extension, or the detected content type if the extension is not
+// known. Every other file is "application/octet-stream" and must be served as an
+// attachment. SVG images are never inline, since they can run scripts.
+func (f FileInfo) PreviewType() (string, bool) {
+	if t, ok := previewTypes[strings.ToLower(filepath.Ext(f.Name))]; ok {
+		return t, true
+	}
+
+	t, _, err := mime.ParseMediaType(f.ContentType)
+	if err == nil && t != "image/svg+xml" {
+		if strings.HasPrefix(t, "video/") || strings.HasPrefix(t, "audio/") || strings.HasPrefix(t, "image/") {
+			return t, true
+		}
+	}
+
+	return "application/octet-stream", false
+}
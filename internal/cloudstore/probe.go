@@ -0,0 +1,76 @@
+package cloudstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/google/uuid"
+)
+
+// ProbeFS checks that the root storage directory is usable. It stats the root,
+// lists its first-level entries, and writes, reads, and deletes a small probe
+// file. Every check is run, even if a previous check failed.
+func (io *IO) ProbeFS() []app.Probe {
+	root := io.paths.Root()
+
+	probes := []app.Probe{
+		app.RunProbe("fs_stat", func() error {
+			_, err := io.fs.Stat(root)
+			return err
+		}),
+		app.RunProbe("fs_list", func() error {
+			_, err := io.fs.ReadDir(root)
+			return err
+		}),
+	}
+
+	probes = append(probes, app.RunProbe("fs_write_read_delete", func() error {
+		name := fmt.Sprintf("%s/.probe-%s", root, uuid.NewString())
+		data := []byte("clox")
+
+		if err := io.fs.WriteFile(name, data, 0600); err != nil {
+			return fmt.Errorf("writing probe file: %w", err)
+		}
+
+		read, err := io.fs.ReadFile(name)
+		if err != nil {
+			io.fs.Remove(name)
+			return fmt.Errorf("reading probe file: %w", err)
+		}
+
+		if err := io.fs.Remove(name); err != nil {
+			return fmt.Errorf("removing probe file: %w", err)
+		}
+
+		if !bytes.Equal(read, data) {
+			return errors.New("probe file content mismatch")
+		}
+
+		return nil
+	}))
+
+	return probes
+}
+
+// ProbeDB runs a trivial query to check the database is reachable and to open a
+// connection in the pool.
+func (s *Store) ProbeDB(ctx context.Context) app.Probe {
+	return app.RunProbe("db_query", func() error {
+		var one int
+		return s.db.QueryRow(ctx, "SELECT 1").Scan(&one)
+	})
+}
+
+// WarmUp probes the file store and the database. See IO.ProbeFS and Store.ProbeDB.
+func (s *DirService) WarmUp(ctx context.Context) []app.Probe {
+	return append(s.io.ProbeFS(), s.store.ProbeDB(ctx))
+}
+
+// CheckFreeSpace returns a error if the root storage directory has less than min bytes
+// available. See IO.CheckFreeSpace.
+func (s *DirService) CheckFreeSpace(min int64) error {
+	return s.io.CheckFreeSpace(min)
+}
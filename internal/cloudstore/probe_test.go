@@ -0,0 +1,32 @@
+package cloudstore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIOProbeFS(t *testing.T) {
+	root := t.TempDir()
+
+	for _, p := range NewIO(&OSFileSystem{}, NewFSPathMapper(root)).ProbeFS() {
+		if p.Err != nil {
+			t.Errorf("probe %s error = %v", p.Name, p.Err)
+		}
+	}
+
+	if got := dirEntries(t, root); len(got) != 0 {
+		t.Errorf("root entries = %v, want the probe file removed", got)
+	}
+
+	// Every check runs even though the root is missing.
+	probes := NewIO(&OSFileSystem{}, NewFSPathMapper(filepath.Join(root, "missing"))).ProbeFS()
+	if len(probes) != 3 {
+		t.Fatalf("probes = %d, want 3", len(probes))
+	}
+
+	for _, p := range probes {
+		if p.Err == nil {
+			t.Errorf("probe %s of a missing root passed", p.Name)
+		}
+	}
+}
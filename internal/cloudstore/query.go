@@ -5,8 +5,14 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/cicconee/clox/internal/event"
+	"github.com/cicconee/clox/internal/pagination"
 	"github.com/lib/pq"
 )
 
@@ -14,10 +20,14 @@ var (
 	ErrForeignKeyParentID    = fmt.Errorf("%q foreign key violation", "parent_id")
 	ErrForeignKeyDirectoryID = fmt.Errorf("%q foreign key violation", "directory_id")
 	ErrUniqueNameParentID    = fmt.Errorf("%q [columns: %q, %q] unique constraint violation", "unique_directory_name_parent", "name", "parent_id")
-	ErrUniqueUserRoot        = fmt.Errorf("%q [columns: %q, %q] unique constraint violation", "unique_user_root_directory", "user_id", "name")
+	ErrUniqueUserRoot        = fmt.Errorf("%q [columns: %q] unique constraint violation", "unique_user_root_directory", "user_id")
 	ErrUniqueDirectoryIDName = fmt.Errorf("%q [columns: %q, %q] unique constraint violation", "unique_file_directory_name", "directory_id", "name")
 	ErrSyntaxParentID        = fmt.Errorf("%q invalid input syntax", "parent_id")
 	ErrSyntaxDirectoryID     = fmt.Errorf("%q invalid input syntax", "directory_id")
+
+	// ErrLockBusy is returned by LockDirectories when it does not wait for a directory
+	// locked by another transaction.
+	ErrLockBusy = errors.New("directory locked by another transaction")
 )
 
 type DBTX interface {
@@ -31,6 +41,11 @@ type Query struct {
 	// The connection to the database. This can be a database
 	// connection or a database transaction.
 	db DBTX
+
+	// locked are the directories locked by LockDirectories in the transaction, and
+	// savepoints the directories that were locked when each savepoint was set.
+	locked     map[string]bool
+	savepoints map[string]map[string]bool
 }
 
 // NewQuery creates a new Query with the DBTX.
@@ -38,58 +53,85 @@ func NewQuery(db DBTX) *Query {
 	return &Query{db: db}
 }
 
+var _ Tx = (*Query)(nil)
+
+// InsertEvent adds a event to the outbox of the database, see event.Repo.Insert.
+func (q *Query) InsertEvent(ctx context.Context, userID string, eventType string, payload any) error {
+	return event.NewRepo(q.db).Insert(ctx, userID, eventType, payload)
+}
+
 type InsertDirectoryConfig struct {
-	ID        string
-	UserID    string
-	Name      string
-	ParentID  sql.NullString
-	CreatedAt time.Time
+	ID       string
+	UserID   string
+	Name     string
+	ParentID sql.NullString
+
+	// Layout is the file system layout of the directory. If not set, it will default
+	// to LayoutFlat.
+	Layout Layout
+
+	// CreatedVia is the path that created the directory. If not set, it will default to
+	// CreatedExplicit.
+	CreatedVia CreatedVia
 }
 
-// InsertDirectory inserts a directory into the directories table.
-func (q *Query) InsertDirectory(ctx context.Context, c InsertDirectoryConfig) error {
-	query := `INSERT INTO directories (id, user_id, name, parent_id, created_at)
-			  VALUES($1, $2, $3, $4, $5)`
+// InsertDirectory inserts a directory into the directories table. The created_at
+// column is set by the database, its value is returned.
+func (q *Query) InsertDirectory(ctx context.Context, c InsertDirectoryConfig) (time.Time, error) {
+	query := `INSERT INTO directories (id, user_id, name, parent_id, storage_layout, record_version, created_via)
+			  VALUES($1, $2, $3, $4, $5, $6, $7)
+			  RETURNING created_at`
 
-	_, err := q.db.Exec(ctx, query,
+	if c.Layout == 0 {
+		c.Layout = LayoutFlat
+	}
+
+	if c.CreatedVia == "" {
+		c.CreatedVia = CreatedExplicit
+	}
+
+	var createdAt time.Time
+	err := q.db.QueryRow(ctx, query,
 		c.ID,
 		c.UserID,
 		c.Name,
 		c.ParentID,
-		c.CreatedAt.UTC(),
-	)
+		c.Layout,
+		CurrentDirVersion,
+		c.CreatedVia,
+	).Scan(&createdAt)
 	if err != nil {
 		var pqErr *pq.Error
 		if errors.As(err, &pqErr) {
 			// Foreign key constraint violation on the parent_id column.
 			if pqErr.Code == "23503" && pqErr.Constraint == "directories_parent_id_fkey" {
-				return fmt.Errorf("%w: %v", ErrForeignKeyParentID, err)
+				return time.Time{}, fmt.Errorf("%w: %v", ErrForeignKeyParentID, err)
 			}
 
 			// Unique constraint violation on the parent_id and name. Another
 			// directory that is a child of parent_id is using this name.
 			if pqErr.Code == "23505" && pqErr.Constraint == "unique_directory_name_parent" {
-				return fmt.Errorf("%w: %v", ErrUniqueNameParentID, err)
+				return time.Time{}, fmt.Errorf("%w: %v", ErrUniqueNameParentID, err)
 			}
 
 			// Unique constraint violation on the user_id and name where parent_id
 			// is null. Another directory was already created for the users root storage.
 			if pqErr.Code == "23505" && pqErr.Constraint == "unique_user_root_directory" {
-				return fmt.Errorf("%w: %v", ErrUniqueUserRoot, err)
+				return time.Time{}, fmt.Errorf("%w: %v", ErrUniqueUserRoot, err)
 			}
 
 			// Invalid input syntax for the parent_id column. The directory id
 			// column is generated by the application, so this should always be
 			// due to the parent_id.
 			if pqErr.Code == "22P02" {
-				return fmt.Errorf("%w: %v", ErrSyntaxParentID, err)
+				return time.Time{}, fmt.Errorf("%w: %v", ErrSyntaxParentID, err)
 			}
 		}
 
-		return err
+		return time.Time{}, err
 	}
 
-	return nil
+	return createdAt.UTC(), nil
 }
 
 type InsertFileConfig struct {
@@ -97,47 +139,74 @@ type InsertFileConfig struct {
 	UserID      string
 	DirectoryID string
 	Name        string
-	UploadedAt  time.Time
+	Size        int64
+
+	// ClientModifiedAt is the modification time declared by the client. If zero, it
+	// is set to the upload time.
+	ClientModifiedAt time.Time
+
+	// Status is the status of the file. If empty, it is FileReady.
+	Status FileStatus
+}
+
+// InsertedFile is the values of a inserted file that are set by the database.
+type InsertedFile struct {
+	UploadedAt       time.Time
+	ClientModifiedAt time.Time
 }
 
-// InsertFile inserts a file into the files table.
-func (q *Query) InsertFile(ctx context.Context, c InsertFileConfig) error {
-	query := `INSERT INTO files (id, user_id, directory_id, name, uploaded_at)
-			  VALUES($1, $2, $3, $4, $5)`
+// InsertFile inserts a file into the files table. The uploaded_at column is set by
+// the database, it is returned with the client_modified_at column.
+func (q *Query) InsertFile(ctx context.Context, c InsertFileConfig) (InsertedFile, error) {
+	query := `INSERT INTO files (id, user_id, directory_id, name, size, client_modified_at, status)
+			  VALUES($1, $2, $3, $4, $5, COALESCE($6::timestamptz, now()), $7)
+			  RETURNING uploaded_at, client_modified_at`
 
-	_, err := q.db.Exec(ctx, query,
+	clientModifiedAt := sql.NullTime{Time: c.ClientModifiedAt.UTC(), Valid: !c.ClientModifiedAt.IsZero()}
+
+	if c.Status == "" {
+		c.Status = FileReady
+	}
+
+	var f InsertedFile
+	err := q.db.QueryRow(ctx, query,
 		c.ID,
 		c.UserID,
 		c.DirectoryID,
 		c.Name,
-		c.UploadedAt.UTC(),
-	)
+		c.Size,
+		clientModifiedAt,
+		c.Status,
+	).Scan(&f.UploadedAt, &f.ClientModifiedAt)
 	if err != nil {
 		var pqErr *pq.Error
 		if errors.As(err, &pqErr) {
 			// Foreign key constraint violation on the directory_id column.
 			if pqErr.Code == "23503" && pqErr.Constraint == "files_directory_id_fkey" {
-				return fmt.Errorf("%w: %v", ErrForeignKeyDirectoryID, err)
+				return InsertedFile{}, fmt.Errorf("%w: %v", ErrForeignKeyDirectoryID, err)
 			}
 
 			// Unique constraint violation on the directory_id and name. Another
 			// file that is a child of directory_id is using this name.
 			if pqErr.Code == "23505" && pqErr.Constraint == "unique_file_directory_name" {
-				return fmt.Errorf("%w: %v", ErrUniqueDirectoryIDName, err)
+				return InsertedFile{}, fmt.Errorf("%w: %v", ErrUniqueDirectoryIDName, err)
 			}
 
 			// Invalid input syntax for the directory_id column. The file id
 			// column is generated by the application, so this should always be
 			// due to the directory_id.
 			if pqErr.Code == "22P02" {
-				return fmt.Errorf("%w: %v", ErrSyntaxDirectoryID, err)
+				return InsertedFile{}, fmt.Errorf("%w: %v", ErrSyntaxDirectoryID, err)
 			}
 		}
 
-		return err
+		return InsertedFile{}, err
 	}
 
-	return nil
+	f.UploadedAt = f.UploadedAt.UTC()
+	f.ClientModifiedAt = f.ClientModifiedAt.UTC()
+
+	return f, nil
 }
 
 // InsertSelfPath inserts the 0th path into the paths table. This row holds
@@ -173,6 +242,46 @@ func (q *Query) InsertParentPaths(ctx context.Context, c InsertParentPathsConfig
 	return err
 }
 
+// SelectIsDescendant selects if the directory id is ancestorID or one of its
+// descendants.
+func (q *Query) SelectIsDescendant(ctx context.Context, ancestorID string, id string) (bool, error) {
+	query := `SELECT EXISTS (
+				  SELECT 1 FROM paths WHERE parent_id = $1 AND child_id = $2
+			  )`
+
+	var ok bool
+	err := q.db.QueryRow(ctx, query, ancestorID, id).Scan(&ok)
+
+	return ok, err
+}
+
+// MoveSubtreePaths moves the paths of the directory id and all of its descendants
+// under the directory parentID. The paths to the ancestors of id are deleted, and the
+// paths to parentID and its ancestors are inserted for every directory in the subtree.
+// The paths within the subtree are kept.
+//
+// The parent_id of the directory is not changed, see UpdateDirectoryParent.
+func (q *Query) MoveSubtreePaths(ctx context.Context, id string, parentID string) error {
+	deleteQuery := `DELETE FROM paths
+					WHERE child_id IN (SELECT child_id FROM paths WHERE parent_id = $1)
+					AND parent_id NOT IN (SELECT child_id FROM paths WHERE parent_id = $1)`
+
+	if _, err := q.db.Exec(ctx, deleteQuery, id); err != nil {
+		return err
+	}
+
+	insertQuery := `INSERT INTO paths (parent_id, child_id, depth)
+					SELECT super.parent_id, sub.child_id, super.depth + sub.depth + 1
+					FROM paths super
+					CROSS JOIN paths sub
+					WHERE super.child_id = $1
+					AND sub.parent_id = $2`
+
+	_, err := q.db.Exec(ctx, insertQuery, parentID, id)
+
+	return err
+}
+
 func (q *Query) SelectDirectoryFSPath(ctx context.Context, directoryID string) ([]string, error) {
 	query := `SELECT d.id
 		  	  FROM paths p
@@ -199,6 +308,200 @@ func (q *Query) SelectDirectoryFSPath(ctx context.Context, directoryID string) (
 	return idPath, nil
 }
 
+// LockDirectories takes a transaction level advisory lock on each directory in ids. The locks
+// are released when the transaction ends, it must be called in a transaction.
+//
+// Structural mutations of a directory, such as creating a child, take the lock of every directory
+// they change, so they are serialized with each other.
+//
+// The first call in a transaction waits for the locks, they are taken in a single statement in
+// sorted order. Two transactions that wait can only wait on a lock the other takes later, so they
+// cannot deadlock. A later call that needs a directory the transaction has not locked yet does not
+// wait, it would be out of order. If the directory is locked by another transaction, a error
+// wrapping ErrLockBusy is returned. A mutation that knows every directory it changes should lock
+// them in its first call.
+func (q *Query) LockDirectories(ctx context.Context, ids ...string) error {
+	sorted := []string{}
+	for _, id := range ids {
+		if !q.locked[id] {
+			sorted = append(sorted, id)
+		}
+	}
+	sort.Strings(sorted)
+	sorted = slices.Compact(sorted)
+
+	if len(sorted) == 0 {
+		return nil
+	}
+
+	// unnest returns the ids in the order of the array, the locks are taken in that order.
+	if len(q.locked) == 0 {
+		query := `SELECT pg_advisory_xact_lock(hashtextextended('directories:' || id, 0))
+				  FROM unnest($1::text[]) AS id`
+
+		if _, err := q.db.Exec(ctx, query, pq.Array(sorted)); err != nil {
+			return fmt.Errorf("locking directories %v: %w", sorted, err)
+		}
+	} else {
+		query := `SELECT bool_and(pg_try_advisory_xact_lock(hashtextextended('directories:' || id, 0)))
+				  FROM unnest($1::text[]) AS id`
+
+		var ok bool
+		if err := q.db.QueryRow(ctx, query, pq.Array(sorted)).Scan(&ok); err != nil {
+			return fmt.Errorf("locking directories %v: %w", sorted, err)
+		}
+
+		if !ok {
+			return fmt.Errorf("%w [ids: %v]", ErrLockBusy, sorted)
+		}
+	}
+
+	if q.locked == nil {
+		q.locked = map[string]bool{}
+	}
+	for _, id := range sorted {
+		q.locked[id] = true
+	}
+
+	return nil
+}
+
+// Savepoint sets the savepoint name, it must be called in a transaction. The statements
+// after it can be undone with RollbackToSavepoint without ending the transaction.
+func (q *Query) Savepoint(ctx context.Context, name string) error {
+	if _, err := q.db.Exec(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+
+	if q.savepoints == nil {
+		q.savepoints = map[string]map[string]bool{}
+	}
+	q.savepoints[name] = maps.Clone(q.locked)
+
+	return nil
+}
+
+// RollbackToSavepoint undoes every statement since the savepoint name was set and
+// releases it. A transaction that failed after the savepoint can be used again. The
+// locks taken since the savepoint are released.
+func (q *Query) RollbackToSavepoint(ctx context.Context, name string) error {
+	if _, err := q.db.Exec(ctx, "ROLLBACK TO SAVEPOINT "+name); err != nil {
+		return err
+	}
+	q.locked = q.savepoints[name]
+
+	return q.ReleaseSavepoint(ctx, name)
+}
+
+// ReleaseSavepoint releases the savepoint name, keeping the statements since it was set.
+func (q *Query) ReleaseSavepoint(ctx context.Context, name string) error {
+	_, err := q.db.Exec(ctx, "RELEASE SAVEPOINT "+name)
+	delete(q.savepoints, name)
+
+	return err
+}
+
+// LockSubtree takes the lock of the directory id, every directory under it, and every
+// directory in ids, see LockDirectories. The IDs of the directory and the directories
+// under it are returned.
+//
+// The subtree is selected again after it is locked, a directory created under it before
+// the lock was taken is locked as well, without waiting for it. If another transaction
+// holds its lock, a error wrapping ErrLockBusy is returned. Once every directory of the
+// subtree is locked, no directory can be created under it and no file can be uploaded
+// to it until the transaction ends.
+func (q *Query) LockSubtree(ctx context.Context, id string, ids ...string) ([]string, error) {
+	subtree, err := q.SelectDescendantDirectoryIDs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := q.LockDirectories(ctx, append(subtree, ids...)...); err != nil {
+		return nil, err
+	}
+
+	for {
+		subtree, err = q.SelectDescendantDirectoryIDs(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		created := []string{}
+		for _, d := range subtree {
+			if !q.locked[d] {
+				created = append(created, d)
+			}
+		}
+
+		if len(created) == 0 {
+			return subtree, nil
+		}
+
+		if err := q.LockDirectories(ctx, created...); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// SelectDirectoryLayout selects the file system layout of a directory. A directory that
+// predates layouts is LayoutFlat, see DirCapabilities.
+func (q *Query) SelectDirectoryLayout(ctx context.Context, directoryID string) (Layout, error) {
+	query := `SELECT storage_layout, record_version FROM directories WHERE id = $1`
+
+	var layout Layout
+	var version RecordVersion
+	err := q.db.QueryRow(ctx, query, directoryID).Scan(&layout, &version)
+
+	return DirCapabilities(version).layout(layout), err
+}
+
+// SelectDirectoryLayoutForUpdate selects the file system layout of a directory and locks
+// the directory row until the transaction ends. It must be called in a transaction.
+func (q *Query) SelectDirectoryLayoutForUpdate(ctx context.Context, directoryID string) (Layout, error) {
+	query := `SELECT storage_layout, record_version FROM directories WHERE id = $1 FOR UPDATE`
+
+	var layout Layout
+	var version RecordVersion
+	err := q.db.QueryRow(ctx, query, directoryID).Scan(&layout, &version)
+
+	return DirCapabilities(version).layout(layout), err
+}
+
+// UpdateDirectoryLayout updates the file system layout of a directory. The directory is
+// bumped to DirVersionLayout so the layout is read back.
+func (q *Query) UpdateDirectoryLayout(ctx context.Context, directoryID string, layout Layout) error {
+	query := `UPDATE directories
+			  SET storage_layout = $1, record_version = GREATEST(record_version, $2)
+			  WHERE id = $3`
+
+	_, err := q.db.Exec(ctx, query, layout, DirVersionLayout, directoryID)
+
+	return err
+}
+
+// SelectFileIDsByDirectory selects the IDs of the files directly under a directory.
+func (q *Query) SelectFileIDsByDirectory(ctx context.Context, directoryID string) ([]string, error) {
+	query := `SELECT id FROM files WHERE directory_id = $1`
+
+	rows, err := q.db.Query(ctx, query, directoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
 func (q *Query) SelectDirectoryPath(ctx context.Context, directoryID string) ([]string, error) {
 	query := `SELECT d.name
 		  	  FROM paths p
@@ -233,13 +536,14 @@ type DirectoryRow struct {
 	CreatedAt time.Time
 	UpdatedAt sql.NullTime
 	LastWrite sql.NullTime
+
+	CreatedVia CreatedVia
 }
 
 func (q *Query) SelectUserRootDirectory(ctx context.Context, userID string) (DirectoryRow, error) {
-	query := `SELECT id, user_id, name, parent_id, created_at, updated_at, last_write
+	query := `SELECT id, user_id, name, parent_id, created_at, updated_at, last_write, created_via
 			  FROM directories
 			  WHERE parent_id IS NULL
-			  AND name = 'root'
 			  AND user_id = $1`
 
 	var r DirectoryRow
@@ -251,6 +555,7 @@ func (q *Query) SelectUserRootDirectory(ctx context.Context, userID string) (Dir
 		&r.CreatedAt,
 		&r.UpdatedAt,
 		&r.LastWrite,
+		&r.CreatedVia,
 	)
 	if err != nil {
 		return DirectoryRow{}, err
@@ -262,7 +567,7 @@ func (q *Query) SelectUserRootDirectory(ctx context.Context, userID string) (Dir
 // SelectDirectoryByUserNameParent selects a row from the directories table by user_id,
 // name, and parent_id.
 func (q *Query) SelectDirectoryByUserNameParent(ctx context.Context, userID string, name string, parentID string) (DirectoryRow, error) {
-	query := `SELECT id, user_id, name, parent_id, created_at, updated_at, last_write
+	query := `SELECT id, user_id, name, parent_id, created_at, updated_at, last_write, created_via
 			  FROM directories
 			  WHERE user_id = $1
 			  AND name = $2
@@ -277,6 +582,7 @@ func (q *Query) SelectDirectoryByUserNameParent(ctx context.Context, userID stri
 		&r.CreatedAt,
 		&r.UpdatedAt,
 		&r.LastWrite,
+		&r.CreatedVia,
 	)
 	if err != nil {
 		return DirectoryRow{}, err
@@ -287,7 +593,7 @@ func (q *Query) SelectDirectoryByUserNameParent(ctx context.Context, userID stri
 
 // SelectDirectoryByIDUser selects a row from the directories table by id and user_id.
 func (q *Query) SelectDirectoryByIDUser(ctx context.Context, id string, userID string) (DirectoryRow, error) {
-	query := `SELECT id, user_id, name, parent_id, created_at, updated_at, last_write
+	query := `SELECT id, user_id, name, parent_id, created_at, updated_at, last_write, created_via
 			  FROM directories
 			  WHERE id = $1
 			  AND user_id = $2`
@@ -301,6 +607,7 @@ func (q *Query) SelectDirectoryByIDUser(ctx context.Context, id string, userID s
 		&r.CreatedAt,
 		&r.UpdatedAt,
 		&r.LastWrite,
+		&r.CreatedVia,
 	)
 	if err != nil {
 		return DirectoryRow{}, err
@@ -309,56 +616,1113 @@ func (q *Query) SelectDirectoryByIDUser(ctx context.Context, id string, userID s
 	return r, nil
 }
 
+// UpdateDirectoryName sets the name of a directory owned by a user. Its updated_at is
+// set by the directories_set_updated_at trigger. If no such directory exists,
+// sql.ErrNoRows is returned. If another directory with the same parent is using name,
+// ErrUniqueNameParentID is returned.
+func (q *Query) UpdateDirectoryName(ctx context.Context, id string, userID string, name string) error {
+	query := `UPDATE directories
+			  SET name = $1
+			  WHERE id = $2 AND user_id = $3`
+
+	result, err := q.db.Exec(ctx, query, name, id, userID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "unique_directory_name_parent" {
+			return fmt.Errorf("%w: %v", ErrUniqueNameParentID, err)
+		}
+
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UpdateDirectoryParent sets the parent of a directory owned by a user. Its updated_at
+// is set by the directories_set_updated_at trigger. If no such directory exists,
+// sql.ErrNoRows is returned. If a directory under parentID is using the name of the
+// directory, ErrUniqueNameParentID is returned.
+//
+// The paths of the directory are not changed, see MoveSubtreePaths.
+func (q *Query) UpdateDirectoryParent(ctx context.Context, id string, userID string, parentID string) error {
+	query := `UPDATE directories
+			  SET parent_id = $1
+			  WHERE id = $2 AND user_id = $3`
+
+	result, err := q.db.Exec(ctx, query, parentID, id, userID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "unique_directory_name_parent" {
+			return fmt.Errorf("%w: %v", ErrUniqueNameParentID, err)
+		}
+
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 type FileRow struct {
+	ID               string
+	UserID           string
+	DirectoryID      string
+	Name             string
+	UploadedAt       time.Time
+	ClientModifiedAt time.Time
+
+	// Checksum and ContentType are NULL until the content of the file is recorded. They
+	// are only selected by SelectFileByID.
+	Checksum    sql.NullString
+	ContentType sql.NullString
+}
+
+// UpdateFileContent sets the size, checksum, content type, and search text of a file,
+// and clears its content_missing flag. The search text is re-indexed every time the
+// content is recorded. The file is bumped to FileVersionSearch.
+func (q *Query) UpdateFileContent(ctx context.Context, id string, c FileContent) error {
+	query := `UPDATE files
+			  SET size = $1, checksum = $2, content_type = $3, search_text = $4, content_missing = false,
+			      record_version = GREATEST(record_version, $5)
+			  WHERE id = $6`
+
+	_, err := q.db.Exec(ctx, query, c.Size, c.Checksum, c.ContentType, c.SearchText, FileVersionSearch, id)
+
+	return err
+}
+
+// SearchFilesConfig is the parameters when searching the files of a user.
+type SearchFilesConfig struct {
+	UserID  string
+	Query   string
+	Content bool
+	Limit   int
+}
+
+// SearchRow is a file row selected by SearchFiles.
+type SearchRow struct {
 	ID          string
-	UserID      string
 	DirectoryID string
 	Name        string
+	Size        int64
 	UploadedAt  time.Time
+	Snippet     string
+	Rank        float64
 }
 
-// SelectFileByIDUser selects a row from the files table by id and user_id.
-func (q *Query) SelectFileByIDUser(ctx context.Context, id string, userID string) (FileRow, error) {
-	query := `SELECT id, user_id, directory_id, name, uploaded_at
-			  FROM files 
-			  WHERE id = $1
-			  AND user_id = $2`
+// SearchFiles selects at most c.Limit files of a user that match c.Query. If c.Content
+// is true, the search_vector is matched and the rows are ordered by rank with a snippet
+// of the search text. Otherwise names containing the query, ignoring case, are ordered
+// by name.
+func (q *Query) SearchFiles(ctx context.Context, c SearchFilesConfig) ([]SearchRow, error) {
+	query := `SELECT id, directory_id, name, size, uploaded_at, '', 0::real
+			  FROM files
+			  WHERE user_id = $1 AND status = 'ready' AND name ILIKE '%' || $2 || '%' ESCAPE '\'
+			  ORDER BY name, id
+			  LIMIT $3`
+	// The LIKE wildcards are escaped so the query is matched literally.
+	arg := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(c.Query)
 
-	var f FileRow
-	err := q.db.QueryRow(ctx, query, id, userID).Scan(
-		&f.ID,
-		&f.UserID,
-		&f.DirectoryID,
-		&f.Name,
-		&f.UploadedAt,
-	)
+	if c.Content {
+		query = `SELECT f.id, f.directory_id, f.name, f.size, f.uploaded_at,
+					 ts_headline('english', f.search_text, tsq, 'MaxFragments=1, MaxWords=30, MinWords=10'),
+					 ts_rank(f.search_vector, tsq)
+				 FROM files f, websearch_to_tsquery('english', $2) tsq
+				 WHERE f.user_id = $1 AND f.status = 'ready' AND f.search_vector @@ tsq
+				 ORDER BY ts_rank(f.search_vector, tsq) DESC, f.id
+				 LIMIT $3`
+		arg = c.Query
+	}
+
+	rows, err := q.db.Query(ctx, query, c.UserID, arg, c.Limit)
 	if err != nil {
-		return FileRow{}, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return f, nil
+	files := []SearchRow{}
+	for rows.Next() {
+		var f SearchRow
+
+		if err := rows.Scan(&f.ID, &f.DirectoryID, &f.Name, &f.Size, &f.UploadedAt, &f.Snippet, &f.Rank); err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return files, rows.Err()
 }
 
-// SelectFileByUserDirName selects a row from the files table by user_id,
-// directory_id, and name.
-func (q *Query) SelectFileByUserDirName(ctx context.Context, userID string, dirID string, name string) (FileRow, error) {
-	query := `SELECT id, user_id, directory_id, name, uploaded_at
-			  FROM files 
-			  WHERE user_id = $1
-			  AND directory_id = $2
-			  AND name = $3`
+// UpdateFileStatus sets the status of a file.
+func (q *Query) UpdateFileStatus(ctx context.Context, id string, status FileStatus) error {
+	query := `UPDATE files
+			  SET status = $1
+			  WHERE id = $2`
 
-	var f FileRow
-	err := q.db.QueryRow(ctx, query, userID, dirID, name).Scan(
-		&f.ID,
-		&f.UserID,
-		&f.DirectoryID,
-		&f.Name,
-		&f.UploadedAt,
-	)
+	_, err := q.db.Exec(ctx, query, status, id)
+
+	return err
+}
+
+// UpdateFileName sets the name of a file owned by a user. If no such file exists,
+// sql.ErrNoRows is returned. If another file in the same directory is using name,
+// ErrUniqueDirectoryIDName is returned.
+func (q *Query) UpdateFileName(ctx context.Context, id string, userID string, name string) error {
+	query := `UPDATE files
+			  SET name = $1
+			  WHERE id = $2 AND user_id = $3`
+
+	result, err := q.db.Exec(ctx, query, name, id, userID)
 	if err != nil {
-		return FileRow{}, err
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "unique_file_directory_name" {
+			return fmt.Errorf("%w: %v", ErrUniqueDirectoryIDName, err)
+		}
+
+		return err
 	}
 
-	return f, nil
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UpdateFileDirectory moves a file owned by a user to the directory directoryID. If no
+// such file exists, sql.ErrNoRows is returned. If a file in directoryID is using the
+// name of the file, ErrUniqueDirectoryIDName is returned.
+func (q *Query) UpdateFileDirectory(ctx context.Context, id string, userID string, directoryID string) error {
+	query := `UPDATE files
+			  SET directory_id = $1
+			  WHERE id = $2 AND user_id = $3`
+
+	result, err := q.db.Exec(ctx, query, directoryID, id, userID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "unique_file_directory_name" {
+			return fmt.Errorf("%w: %v", ErrUniqueDirectoryIDName, err)
+		}
+
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// PendingFileRow is a row in the files table that is FilePending.
+type PendingFileRow struct {
+	ID          string
+	DirectoryID string
+	Size        int64
+	UploadedAt  time.Time
+}
+
+// SelectPendingFiles selects at most limit FilePending rows from the files table that
+// were uploaded before, oldest first.
+func (q *Query) SelectPendingFiles(ctx context.Context, before time.Time, limit int) ([]PendingFileRow, error) {
+	query := `SELECT id, directory_id, size, uploaded_at
+			  FROM files
+			  WHERE status = 'pending' AND uploaded_at < $1
+			  ORDER BY uploaded_at
+			  LIMIT $2`
+
+	rows, err := q.db.Query(ctx, query, before.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []PendingFileRow{}
+	for rows.Next() {
+		var f PendingFileRow
+
+		if err := rows.Scan(&f.ID, &f.DirectoryID, &f.Size, &f.UploadedAt); err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return files, rows.Err()
+}
+
+// DeletePendingFile deletes a row from the files table if it is FilePending. A file
+// that is FileReady is never deleted, it returns false.
+func (q *Query) DeletePendingFile(ctx context.Context, id string) (bool, error) {
+	query := `DELETE FROM files WHERE id = $1 AND status = 'pending'`
+
+	result, err := q.db.Exec(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// SelectDescendantDirectoryIDs selects the IDs of a directory and every directory
+// under it from the paths table. The directory itself is the first ID.
+func (q *Query) SelectDescendantDirectoryIDs(ctx context.Context, directoryID string) ([]string, error) {
+	query := `SELECT child_id
+			  FROM paths
+			  WHERE parent_id = $1
+			  ORDER BY depth`
+
+	return q.selectIDs(ctx, query, directoryID)
+}
+
+// SelectFileIDsInDirectories selects the IDs of every file that is a direct child
+// of one of the directories.
+func (q *Query) SelectFileIDsInDirectories(ctx context.Context, directoryIDs []string) ([]string, error) {
+	query := `SELECT id
+			  FROM files
+			  WHERE directory_id = ANY($1)`
+
+	return q.selectIDs(ctx, query, pq.Array(directoryIDs))
+}
+
+// SelectFilesSize selects the total size in bytes of the files. Files uploaded before
+// sizes were recorded are not counted.
+func (q *Query) SelectFilesSize(ctx context.Context, ids []string) (int64, error) {
+	query := `SELECT COALESCE(SUM(size), 0)
+			  FROM files
+			  WHERE id = ANY($1)`
+
+	var size int64
+	if err := q.db.QueryRow(ctx, query, pq.Array(ids)).Scan(&size); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// DeleteFiles deletes the rows of the files from the files table. The number of rows
+// deleted is returned, a file that was already deleted is not counted.
+func (q *Query) DeleteFiles(ctx context.Context, ids []string) (int64, error) {
+	query := `DELETE FROM files WHERE id = ANY($1)`
+
+	result, err := q.db.Exec(ctx, query, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteDirectories deletes the rows of the directories from the directories table.
+// Their rows in the paths table are deleted with them.
+func (q *Query) DeleteDirectories(ctx context.Context, ids []string) error {
+	query := `DELETE FROM directories WHERE id = ANY($1)`
+
+	_, err := q.db.Exec(ctx, query, pq.Array(ids))
+	return err
+}
+
+// selectIDs runs a query that selects a single ID column and returns the IDs.
+func (q *Query) selectIDs(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := q.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// UpdateFileContentMissing sets the content_missing flag of a file. A file is flagged
+// when its content cannot be found on the file system.
+func (q *Query) UpdateFileContentMissing(ctx context.Context, id string, missing bool) error {
+	query := `UPDATE files
+			  SET content_missing = $1
+			  WHERE id = $2`
+
+	_, err := q.db.Exec(ctx, query, missing, id)
+
+	return err
+}
+
+// SelectFileByID selects a row from the files table by id. The row is not scoped
+// to a user, callers must decide if the user may access the file.
+func (q *Query) SelectFileByID(ctx context.Context, id string) (FileRow, error) {
+	query := `SELECT id, user_id, directory_id, name, uploaded_at, client_modified_at, checksum, content_type
+			  FROM files 
+			  WHERE id = $1 AND status = 'ready'`
+
+	var f FileRow
+	err := q.db.QueryRow(ctx, query, id).Scan(
+		&f.ID,
+		&f.UserID,
+		&f.DirectoryID,
+		&f.Name,
+		&f.UploadedAt,
+		&f.ClientModifiedAt,
+		&f.Checksum,
+		&f.ContentType,
+	)
+	if err != nil {
+		return FileRow{}, err
+	}
+
+	return f, nil
+}
+
+// SelectFileByIDUser selects a row from the files table by id and user_id. Only
+// FileReady files are selected.
+func (q *Query) SelectFileByIDUser(ctx context.Context, id string, userID string) (FileRow, error) {
+	query := `SELECT id, user_id, directory_id, name, uploaded_at, client_modified_at
+			  FROM files
+			  WHERE id = $1
+			  AND user_id = $2
+			  AND status = 'ready'`
+
+	var f FileRow
+	err := q.db.QueryRow(ctx, query, id, userID).Scan(
+		&f.ID,
+		&f.UserID,
+		&f.DirectoryID,
+		&f.Name,
+		&f.UploadedAt,
+		&f.ClientModifiedAt,
+	)
+	if err != nil {
+		return FileRow{}, err
+	}
+
+	return f, nil
+}
+
+// SelectFileByIDUserForUpdate selects a row from the files table by id and user_id, like
+// SelectFileByIDUser, and locks it until the transaction ends. It must be called in a
+// transaction.
+func (q *Query) SelectFileByIDUserForUpdate(ctx context.Context, id string, userID string) (FileRow, error) {
+	query := `SELECT id, user_id, directory_id, name, uploaded_at, client_modified_at
+			  FROM files
+			  WHERE id = $1
+			  AND user_id = $2
+			  AND status = 'ready'
+			  FOR UPDATE`
+
+	var f FileRow
+	err := q.db.QueryRow(ctx, query, id, userID).Scan(
+		&f.ID,
+		&f.UserID,
+		&f.DirectoryID,
+		&f.Name,
+		&f.UploadedAt,
+		&f.ClientModifiedAt,
+	)
+	if err != nil {
+		return FileRow{}, err
+	}
+
+	return f, nil
+}
+
+// SelectFileByUserDirName selects a row from the files table by user_id,
+// directory_id, and name.
+func (q *Query) SelectFileByUserDirName(ctx context.Context, userID string, dirID string, name string) (FileRow, error) {
+	query := `SELECT id, user_id, directory_id, name, uploaded_at, client_modified_at
+			  FROM files 
+			  WHERE user_id = $1
+			  AND directory_id = $2
+			  AND name = $3
+			  AND status = 'ready'`
+
+	var f FileRow
+	err := q.db.QueryRow(ctx, query, userID, dirID, name).Scan(
+		&f.ID,
+		&f.UserID,
+		&f.DirectoryID,
+		&f.Name,
+		&f.UploadedAt,
+		&f.ClientModifiedAt,
+	)
+	if err != nil {
+		return FileRow{}, err
+	}
+
+	return f, nil
+}
+
+// SelectDefaultUploadDir selects the default_upload_dir column of a user from the
+// users table. The column is NULL if the user has not set a default upload directory
+// or the directory was deleted.
+func (q *Query) SelectDefaultUploadDir(ctx context.Context, userID string) (sql.NullString, error) {
+	query := `SELECT default_upload_dir
+			  FROM users
+			  WHERE id = $1`
+
+	var dirID sql.NullString
+	if err := q.db.QueryRow(ctx, query, userID).Scan(&dirID); err != nil {
+		return sql.NullString{}, err
+	}
+
+	return dirID, nil
+}
+
+// UpdateDefaultUploadDir sets the default_upload_dir column of a user in the users
+// table. A NULL dirID resets the users default upload directory.
+func (q *Query) UpdateDefaultUploadDir(ctx context.Context, userID string, dirID sql.NullString) error {
+	query := `UPDATE users
+			  SET default_upload_dir = $1
+			  WHERE id = $2`
+
+	_, err := q.db.Exec(ctx, query, dirID, userID)
+
+	return err
+}
+
+// SelectStorageState selects the storage_state column of a user from the users table.
+func (q *Query) SelectStorageState(ctx context.Context, userID string) (StorageState, error) {
+	query := `SELECT storage_state
+			  FROM users
+			  WHERE id = $1`
+
+	var state StorageState
+	if err := q.db.QueryRow(ctx, query, userID).Scan(&state); err != nil {
+		return "", err
+	}
+
+	return state, nil
+}
+
+// UpdateStorageState sets the storage_state column of a user in the users table.
+func (q *Query) UpdateStorageState(ctx context.Context, userID string, state StorageState) error {
+	query := `UPDATE users
+			  SET storage_state = $1
+			  WHERE id = $2`
+
+	_, err := q.db.Exec(ctx, query, state, userID)
+
+	return err
+}
+
+// SelectStorageUsed selects the total size in bytes of all the files a user owns.
+// Files uploaded before sizes were recorded are not counted.
+func (q *Query) SelectStorageUsed(ctx context.Context, userID string) (int64, error) {
+	query := `SELECT COALESCE(SUM(size), 0)
+			  FROM files
+			  WHERE user_id = $1 AND status = 'ready'`
+
+	var used int64
+	if err := q.db.QueryRow(ctx, query, userID).Scan(&used); err != nil {
+		return 0, err
+	}
+
+	return used, nil
+}
+
+// SelectUploadsPerDay counts the files a user uploaded on or after since, grouped by the
+// day they were uploaded in the time zone. The counts are keyed by date formatted as
+// "2006-01-02". Days without uploads are not included.
+func (q *Query) SelectUploadsPerDay(ctx context.Context, userID string, since time.Time, timeZone string) (map[string]int, error) {
+	query := `SELECT to_char(uploaded_at AT TIME ZONE $2, 'YYYY-MM-DD') AS day, COUNT(*)
+			  FROM files
+			  WHERE user_id = $1 AND status = 'ready' AND uploaded_at >= $3
+			  GROUP BY day`
+
+	rows, err := q.db.Query(ctx, query, userID, timeZone, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var (
+			day   string
+			count int
+		)
+
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, err
+		}
+
+		counts[day] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// CheckDirRow is a directory of a user selected by SelectCheckDirectories.
+type CheckDirRow struct {
+	ID         string
+	ParentID   sql.NullString
+	Layout     Layout
+	CreatedVia CreatedVia
+
+	// IDPath is the IDs of the ancestors of the directory in the paths table, from the
+	// furthest ancestor to the directory itself.
+	IDPath []string
+}
+
+// SelectCheckDirectories selects every directory of a user with its ancestors in the
+// paths table. The rows are ordered by depth, so a directory is selected after its
+// ancestors.
+func (q *Query) SelectCheckDirectories(ctx context.Context, userID string) ([]CheckDirRow, error) {
+	query := `SELECT d.id, d.parent_id, d.storage_layout, d.record_version, d.created_via,
+				  COALESCE(string_agg(p.parent_id::text, '/' ORDER BY p.depth DESC), '')
+			  FROM directories d
+			  LEFT JOIN paths p ON p.child_id = d.id
+			  WHERE d.user_id = $1
+			  GROUP BY d.id
+			  ORDER BY count(p.parent_id), d.id`
+
+	rows, err := q.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dirs := []CheckDirRow{}
+	for rows.Next() {
+		var d CheckDirRow
+		var version RecordVersion
+		var idPath string
+
+		if err := rows.Scan(&d.ID, &d.ParentID, &d.Layout, &version, &d.CreatedVia, &idPath); err != nil {
+			return nil, err
+		}
+
+		d.Layout = DirCapabilities(version).layout(d.Layout)
+		if idPath != "" {
+			d.IDPath = strings.Split(idPath, "/")
+		}
+
+		dirs = append(dirs, d)
+	}
+
+	return dirs, rows.Err()
+}
+
+// ForeignPathRow is a row in the paths table that links a directory of a user to a
+// directory of another user.
+type ForeignPathRow struct {
+	ParentID string
+	ChildID  string
+}
+
+// SelectForeignPaths selects the rows in the paths table that link a directory of a
+// user to a directory of another user.
+func (q *Query) SelectForeignPaths(ctx context.Context, userID string) ([]ForeignPathRow, error) {
+	query := `SELECT p.parent_id, p.child_id
+			  FROM paths p
+			  JOIN directories parent ON parent.id = p.parent_id
+			  JOIN directories child ON child.id = p.child_id
+			  WHERE (parent.user_id = $1) <> (child.user_id = $1)
+			  AND (parent.user_id = $1 OR child.user_id = $1)
+			  ORDER BY p.parent_id, p.child_id`
+
+	rows, err := q.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []ForeignPathRow{}
+	for rows.Next() {
+		var p ForeignPathRow
+
+		if err := rows.Scan(&p.ParentID, &p.ChildID); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, p)
+	}
+
+	return paths, rows.Err()
+}
+
+// CheckFileRow is a file of a user selected by SelectCheckFiles.
+type CheckFileRow struct {
+	ID             string
+	DirectoryID    string
+	Size           sql.NullInt64
+	Status         FileStatus
+	ContentMissing bool
+}
+
+// SelectCheckFiles selects every file of a user, including the FilePending files.
+func (q *Query) SelectCheckFiles(ctx context.Context, userID string) ([]CheckFileRow, error) {
+	query := `SELECT id, directory_id, size, status, content_missing
+			  FROM files
+			  WHERE user_id = $1
+			  ORDER BY id`
+
+	rows, err := q.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []CheckFileRow{}
+	for rows.Next() {
+		var f CheckFileRow
+
+		if err := rows.Scan(&f.ID, &f.DirectoryID, &f.Size, &f.Status, &f.ContentMissing); err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return files, rows.Err()
+}
+
+// UpdateLastWrite sets the last_write column of the directory and all of its
+// ancestors to the start of the transaction, the same time the database sets as
+// the created_at or uploaded_at of a directory or file inserted in it. The IDs of
+// the updated directories are returned.
+func (q *Query) UpdateLastWrite(ctx context.Context, directoryID string) ([]string, error) {
+	query := `UPDATE directories
+			  SET last_write = now()
+			  WHERE id IN (
+				  SELECT parent_id
+				  FROM paths
+				  WHERE child_id = $1
+			  )
+			  RETURNING id`
+
+	rows, err := q.db.Query(ctx, query, directoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// ChildVersionRow is the version of a directory or file that is a direct child
+// of a directory.
+type ChildVersionRow struct {
+	// Type is "d" for a directory and "f" for a file.
+	Type string
+	ID   string
+	Name string
+
+	// Version is the last write time of a directory, or the upload time of a
+	// file. If a directory has never been written to, it is the creation time.
+	Version time.Time
+}
+
+// SelectChildVersions selects the version of every directory and file that is a
+// direct child of the directory. The rows are ordered by type and then ID.
+func (q *Query) SelectChildVersions(ctx context.Context, directoryID string) ([]ChildVersionRow, error) {
+	query := `SELECT 'd', id, name, COALESCE(last_write, created_at)
+			  FROM directories
+			  WHERE parent_id = $1
+			  UNION ALL
+			  SELECT 'f', id, name, uploaded_at
+			  FROM files
+			  WHERE directory_id = $1 AND status = 'ready'
+			  ORDER BY 1, 2`
+
+	rows, err := q.db.Query(ctx, query, directoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []ChildVersionRow{}
+	for rows.Next() {
+		var v ChildVersionRow
+
+		if err := rows.Scan(&v.Type, &v.ID, &v.Name, &v.Version); err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
+}
+
+// FSCleanupRow is a row in the fs_cleanup table. It is a path that failed to be
+// removed from the file system.
+type FSCleanupRow struct {
+	Path      string
+	Recursive bool
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UpsertFSCleanup inserts a row into the fs_cleanup table. If the path already
+// exists, its attempts are incremented and the last error and updated time are set.
+func (q *Query) UpsertFSCleanup(ctx context.Context, r FSCleanupRow) error {
+	query := `INSERT INTO fs_cleanup (path, recursive, attempts, last_error, created_at, updated_at)
+			  VALUES($1, $2, $3, $4, $5, $6)
+			  ON CONFLICT (path) DO UPDATE
+			  SET attempts = fs_cleanup.attempts + EXCLUDED.attempts,
+				  last_error = EXCLUDED.last_error,
+				  updated_at = EXCLUDED.updated_at`
+
+	_, err := q.db.Exec(ctx, query,
+		r.Path,
+		r.Recursive,
+		r.Attempts,
+		r.LastError,
+		r.CreatedAt.UTC(),
+		r.UpdatedAt.UTC(),
+	)
+
+	return err
+}
+
+// SelectFSCleanup selects at most limit rows from the fs_cleanup table, least
+// recently attempted first.
+func (q *Query) SelectFSCleanup(ctx context.Context, limit int) ([]FSCleanupRow, error) {
+	query := `SELECT path, recursive, attempts, last_error, created_at, updated_at
+			  FROM fs_cleanup
+			  ORDER BY updated_at
+			  LIMIT $1`
+
+	rows, err := q.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cleanups := []FSCleanupRow{}
+	for rows.Next() {
+		var r FSCleanupRow
+
+		if err := rows.Scan(&r.Path, &r.Recursive, &r.Attempts, &r.LastError, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		cleanups = append(cleanups, r)
+	}
+
+	return cleanups, rows.Err()
+}
+
+// DeleteFSCleanup deletes a row from the fs_cleanup table by path.
+func (q *Query) DeleteFSCleanup(ctx context.Context, path string) error {
+	query := `DELETE FROM fs_cleanup WHERE path = $1`
+
+	_, err := q.db.Exec(ctx, query, path)
+
+	return err
+}
+
+// EntryRow is a directory or file row selected by SelectEntries.
+type EntryRow struct {
+	Type       EntryType
+	ID         string
+	Name       string
+	Size       sql.NullInt64
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+
+	// ClientModifiedAt is the modification time declared by the client. It is only
+	// valid for files.
+	ClientModifiedAt sql.NullTime
+
+	// ContentMissing is true if the content of a file is not on the file system. It
+	// is always false for directories.
+	ContentMissing bool
+}
+
+// SelectEntries selects the directories and files that are a direct child of the
+// directory. The rows are ordered by type, the sort field, and ID in the direction
+// of opts.Order.
+func (q *Query) SelectEntries(ctx context.Context, directoryID string, opts ListOptions) ([]EntryRow, error) {
+	sortColumn := "name"
+	if opts.Sort == SortCreated {
+		sortColumn = "created_at"
+	}
+	columns := []string{"type", sortColumn, "id"}
+
+	types := []string{}
+	if opts.Dirs {
+		types = append(types, string(EntryDir))
+	}
+	if opts.Files {
+		types = append(types, string(EntryFile))
+	}
+
+	args := []any{directoryID, pq.Array(types)}
+	where := "type = ANY($2)"
+	if opts.After != nil {
+		where += " AND " + pagination.KeysetWhere(columns, opts.Order, 3)
+		if opts.Sort == SortCreated {
+			args = append(args, opts.After.Type, opts.After.Time, opts.After.ID)
+		} else {
+			args = append(args, opts.After.Type, opts.After.Name, opts.After.ID)
+		}
+	}
+	args = append(args, opts.Limit)
+
+	query := fmt.Sprintf(`SELECT type, id, name, size, created_at, modified_at, client_modified_at, content_missing
+			  FROM (
+				  SELECT 'd' AS type, id, name, NULL::BIGINT AS size, created_at, COALESCE(last_write, created_at) AS modified_at,
+					  NULL::TIMESTAMPTZ AS client_modified_at, false AS content_missing
+				  FROM directories
+				  WHERE parent_id = $1
+				  UNION ALL
+				  SELECT 'f', id, name, size, uploaded_at, uploaded_at, client_modified_at, content_missing
+				  FROM files
+				  WHERE directory_id = $1 AND status = 'ready'
+			  ) AS entries
+			  WHERE %s
+			  ORDER BY %s
+			  LIMIT $%d`, where, pagination.KeysetOrderBy(columns, opts.Order), len(args))
+
+	rows, err := q.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []EntryRow{}
+	for rows.Next() {
+		var e EntryRow
+
+		if err := rows.Scan(&e.Type, &e.ID, &e.Name, &e.Size, &e.CreatedAt, &e.ModifiedAt, &e.ClientModifiedAt, &e.ContentMissing); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// BackfillFileRow is a row in the files table with the fields needed to backfill
+// its content.
+type BackfillFileRow struct {
+	ID             string
+	DirectoryID    string
+	Name           string
+	Size           int64
+	ContentMissing bool
+	RecordVersion  RecordVersion
+}
+
+// SelectBackfillFiles selects at most limit rows from the files table with an id
+// greater than afterID, in increasing id order. If afterID is empty, the first rows
+// are selected.
+func (q *Query) SelectBackfillFiles(ctx context.Context, afterID string, limit int) ([]BackfillFileRow, error) {
+	query := `SELECT id, directory_id, name, size, content_missing, record_version
+			  FROM files
+			  WHERE ($1 = '' OR id > $1::uuid) AND status = 'ready'
+			  ORDER BY id
+			  LIMIT $2`
+
+	rows, err := q.db.Query(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []BackfillFileRow{}
+	for rows.Next() {
+		var f BackfillFileRow
+
+		if err := rows.Scan(&f.ID, &f.DirectoryID, &f.Name, &f.Size, &f.ContentMissing, &f.RecordVersion); err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return files, rows.Err()
+}
+
+// SelectFileVersionCounts selects the number of files at each RecordVersion.
+func (q *Query) SelectFileVersionCounts(ctx context.Context) (map[RecordVersion]int64, error) {
+	query := `SELECT record_version, count(*) FROM files GROUP BY record_version`
+
+	rows, err := q.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[RecordVersion]int64{}
+	for rows.Next() {
+		var version RecordVersion
+		var count int64
+
+		if err := rows.Scan(&version, &count); err != nil {
+			return nil, err
+		}
+
+		counts[version] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// BackfillCheckpointRow is a row in the backfill_checkpoints table. It is the
+// progress of a backfill run.
+type BackfillCheckpointRow struct {
+	Name       string
+	LastID     string
+	Processed  int64
+	Updated    int64
+	Missing    int64
+	Failed     int64
+	StartedAt  time.Time
+	UpdatedAt  time.Time
+	FinishedAt sql.NullTime
+}
+
+// SelectBackfillCheckpoint selects a row from the backfill_checkpoints table by name.
+func (q *Query) SelectBackfillCheckpoint(ctx context.Context, name string) (BackfillCheckpointRow, error) {
+	query := `SELECT name, COALESCE(last_id::text, ''), processed, updated, missing, failed, started_at, updated_at, finished_at
+			  FROM backfill_checkpoints
+			  WHERE name = $1`
+
+	var r BackfillCheckpointRow
+	err := q.db.QueryRow(ctx, query, name).Scan(
+		&r.Name,
+		&r.LastID,
+		&r.Processed,
+		&r.Updated,
+		&r.Missing,
+		&r.Failed,
+		&r.StartedAt,
+		&r.UpdatedAt,
+		&r.FinishedAt,
+	)
+
+	return r, err
+}
+
+// UpsertBackfillCheckpoint inserts a row into the backfill_checkpoints table. If the
+// name already exists, the row is replaced.
+func (q *Query) UpsertBackfillCheckpoint(ctx context.Context, r BackfillCheckpointRow) error {
+	query := `INSERT INTO backfill_checkpoints (name, last_id, processed, updated, missing, failed, started_at, updated_at, finished_at)
+			  VALUES($1, NULLIF($2, '')::uuid, $3, $4, $5, $6, $7, $8, $9)
+			  ON CONFLICT (name) DO UPDATE
+			  SET last_id = EXCLUDED.last_id,
+				  processed = EXCLUDED.processed,
+				  updated = EXCLUDED.updated,
+				  missing = EXCLUDED.missing,
+				  failed = EXCLUDED.failed,
+				  started_at = EXCLUDED.started_at,
+				  updated_at = EXCLUDED.updated_at,
+				  finished_at = EXCLUDED.finished_at`
+
+	var finishedAt sql.NullTime
+	if r.FinishedAt.Valid {
+		finishedAt = sql.NullTime{Time: r.FinishedAt.Time.UTC(), Valid: true}
+	}
+
+	_, err := q.db.Exec(ctx, query,
+		r.Name,
+		r.LastID,
+		r.Processed,
+		r.Updated,
+		r.Missing,
+		r.Failed,
+		r.StartedAt.UTC(),
+		r.UpdatedAt.UTC(),
+		finishedAt,
+	)
+
+	return err
+}
+
+// UserTreeRow is the shape of the directory tree of a user.
+type UserTreeRow struct {
+	UserID      string
+	Directories int64
+	MaxDepth    int
+	AvgDepth    float64
+	ClosureRows int64
+}
+
+// SelectUserTrees selects the number of directories, the max and average depth of
+// the directories, and the number of paths rows of the directories of every user
+// with a directory. The depth of a directory is the depth of its row to the root
+// directory, so the root directory is 0.
+func (q *Query) SelectUserTrees(ctx context.Context) ([]UserTreeRow, error) {
+	query := `SELECT d.user_id, count(*), max(p.depth), avg(p.depth)::float8, sum(p.rows)::bigint
+			  FROM directories d
+			  JOIN (
+				  SELECT child_id, max(depth) AS depth, count(*) AS rows
+				  FROM paths
+				  GROUP BY child_id
+			  ) p ON p.child_id = d.id
+			  GROUP BY d.user_id`
+
+	rows, err := q.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trees := []UserTreeRow{}
+	for rows.Next() {
+		var t UserTreeRow
+
+		if err := rows.Scan(&t.UserID, &t.Directories, &t.MaxDepth, &t.AvgDepth, &t.ClosureRows); err != nil {
+			return nil, err
+		}
+
+		trees = append(trees, t)
+	}
+
+	return trees, rows.Err()
 }
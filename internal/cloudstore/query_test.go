@@ -0,0 +1,455 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+func TestMoveSubtreePaths(t *testing.T) {
+	ctx := context.Background()
+	q := NewQuery(dbtest.Tx(t))
+
+	userID := uuid.NewString()
+	_, err := q.db.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+
+	// root
+	// ├── a
+	// │   └── b
+	// │       ├── c
+	// │       │   └── d
+	// │       │       └── e
+	// │       └── f
+	// └── x
+	//     └── y
+	ids := map[string]string{}
+	mkdir := func(name string, parent string) {
+		t.Helper()
+
+		ids[name] = uuid.NewString()
+		parentID := sql.NullString{String: ids[parent], Valid: parent != ""}
+
+		_, err := q.InsertDirectory(ctx, InsertDirectoryConfig{
+			ID:       ids[name],
+			UserID:   userID,
+			Name:     name,
+			ParentID: parentID,
+		})
+		if err != nil {
+			t.Fatalf("inserting directory %s: %v", name, err)
+		}
+
+		if err := q.InsertSelfPath(ctx, ids[name]); err != nil {
+			t.Fatalf("inserting self path %s: %v", name, err)
+		}
+
+		if parentID.Valid {
+			err := q.InsertParentPaths(ctx, InsertParentPathsConfig{ParentID: parentID.String, ChildID: ids[name]})
+			if err != nil {
+				t.Fatalf("inserting parent paths %s: %v", name, err)
+			}
+		}
+	}
+	mkdir("root", "")
+	mkdir("a", "root")
+	mkdir("b", "a")
+	mkdir("c", "b")
+	mkdir("d", "c")
+	mkdir("e", "d")
+	mkdir("f", "b")
+	mkdir("x", "root")
+	mkdir("y", "x")
+
+	move := func(name string, parent string) {
+		t.Helper()
+
+		if err := q.UpdateDirectoryParent(ctx, ids[name], userID, ids[parent]); err != nil {
+			t.Fatalf("updating parent of %s: %v", name, err)
+		}
+
+		if err := q.MoveSubtreePaths(ctx, ids[name], ids[parent]); err != nil {
+			t.Fatalf("moving paths of %s: %v", name, err)
+		}
+	}
+
+	names := map[string]string{}
+	for name, id := range ids {
+		names[id] = name
+	}
+
+	tests := []struct {
+		name   string
+		dir    string
+		parent string
+	}{
+		{name: "subtree of depth 3 to sibling branch", dir: "b", parent: "y"},
+		{name: "leaf subtree up the tree", dir: "d", parent: "a"},
+		{name: "branch under a moved subtree", dir: "x", parent: "f"},
+		{name: "back under the root", dir: "b", parent: "root"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			move(tc.dir, tc.parent)
+
+			want := closure(t, q, userID, names)
+			got := selectPaths(t, q, userID, names)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("paths after moving %s under %s\n got: %v\nwant: %v", tc.dir, tc.parent, got, want)
+			}
+		})
+	}
+}
+
+// closure builds the rows the paths table should hold for the directories of a user by
+// walking the parent_id column, as "ancestor>descendant@depth" sorted.
+func closure(t *testing.T, q *Query, userID string, names map[string]string) []string {
+	t.Helper()
+
+	rows, err := q.db.Query(context.Background(), `SELECT id, parent_id FROM directories WHERE user_id = $1`, userID)
+	if err != nil {
+		t.Fatalf("selecting directories: %v", err)
+	}
+	defer rows.Close()
+
+	parents := map[string]string{}
+	for rows.Next() {
+		var id string
+		var parentID sql.NullString
+		if err := rows.Scan(&id, &parentID); err != nil {
+			t.Fatalf("scanning directory: %v", err)
+		}
+		parents[id] = parentID.String
+	}
+
+	paths := []string{}
+	for id := range parents {
+		depth := 0
+		for ancestor := id; ancestor != ""; ancestor = parents[ancestor] {
+			paths = append(paths, fmt.Sprintf("%s>%s@%d", names[ancestor], names[id], depth))
+			depth++
+		}
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// selectPaths selects the rows of the paths table of the directories of a user, as
+// "ancestor>descendant@depth" sorted.
+func selectPaths(t *testing.T, q *Query, userID string, names map[string]string) []string {
+	t.Helper()
+
+	rows, err := q.db.Query(context.Background(), `SELECT p.parent_id, p.child_id, p.depth
+		FROM paths p
+		JOIN directories d ON p.child_id = d.id
+		WHERE d.user_id = $1`, userID)
+	if err != nil {
+		t.Fatalf("selecting paths: %v", err)
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var parentID, childID string
+		var depth int
+		if err := rows.Scan(&parentID, &childID, &depth); err != nil {
+			t.Fatalf("scanning path: %v", err)
+		}
+		paths = append(paths, fmt.Sprintf("%s>%s@%d", names[parentID], names[childID], depth))
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+func TestLockDirectories(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	begin := func() (*Query, func()) {
+		t.Helper()
+
+		tx, err := p.Tx(ctx, nil)
+		if err != nil {
+			t.Fatalf("beginning transaction: %v", err)
+		}
+
+		return NewQuery(tx), func() { tx.Rollback() }
+	}
+
+	a, b, c, d := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+
+	q1, end1 := begin()
+	defer end1()
+	if err := q1.LockDirectories(ctx, b, a, b); err != nil {
+		t.Fatalf("first LockDirectories() error = %v", err)
+	}
+
+	// A lock the transaction holds is not taken again.
+	if err := q1.LockDirectories(ctx, a); err != nil {
+		t.Fatalf("LockDirectories() of a held lock error = %v", err)
+	}
+
+	q2, end2 := begin()
+	defer end2()
+	if err := q2.LockDirectories(ctx, c); err != nil {
+		t.Fatalf("first LockDirectories() error = %v", err)
+	}
+
+	// The second call of the transaction does not wait for the lock of the first.
+	if err := q2.LockDirectories(ctx, b); !errors.Is(err, ErrLockBusy) {
+		t.Errorf("LockDirectories() of a busy lock error = %v, want ErrLockBusy", err)
+	}
+
+	if err := q2.LockDirectories(ctx, d); err != nil {
+		t.Errorf("LockDirectories() of a free lock error = %v", err)
+	}
+
+	// The locks taken after a savepoint are released when it is rolled back to.
+	if err := q1.Savepoint(ctx, "lock_test"); err != nil {
+		t.Fatalf("Savepoint() error = %v", err)
+	}
+
+	e := uuid.NewString()
+	if err := q1.LockDirectories(ctx, e); err != nil {
+		t.Fatalf("LockDirectories() after savepoint error = %v", err)
+	}
+
+	if err := q1.RollbackToSavepoint(ctx, "lock_test"); err != nil {
+		t.Fatalf("RollbackToSavepoint() error = %v", err)
+	}
+
+	if err := q2.LockDirectories(ctx, e); err != nil {
+		t.Errorf("LockDirectories() of a lock released by a savepoint error = %v", err)
+	}
+}
+
+func TestDatabaseTimestamps(t *testing.T) {
+	ctx := context.Background()
+	q := NewQuery(dbtest.Tx(t))
+
+	userID := uuid.NewString()
+	_, err := q.db.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+
+	// now() is the start of the transaction, every timestamp the database assigns in it
+	// is the same.
+	var now time.Time
+	if err := q.db.QueryRow(ctx, `SELECT now()`).Scan(&now); err != nil {
+		t.Fatalf("selecting now: %v", err)
+	}
+
+	rootID, dirID := uuid.NewString(), uuid.NewString()
+	rootCreated, err := q.InsertDirectory(ctx, InsertDirectoryConfig{ID: rootID, UserID: userID, Name: RootName})
+	if err != nil {
+		t.Fatalf("inserting root directory: %v", err)
+	}
+
+	dirCreated, err := q.InsertDirectory(ctx, InsertDirectoryConfig{
+		ID:       dirID,
+		UserID:   userID,
+		Name:     "photos",
+		ParentID: sql.NullString{String: rootID, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("inserting directory: %v", err)
+	}
+
+	if !rootCreated.Equal(now) || !dirCreated.Equal(now) {
+		t.Errorf("created at = %s and %s, want the database time %s", rootCreated, dirCreated, now)
+	}
+
+	if dirCreated.Before(rootCreated) {
+		t.Errorf("created at = %s before the previous insert at %s", dirCreated, rootCreated)
+	}
+
+	// A file without a client time defaults to its upload time.
+	mtime := time.Date(2024, time.March, 1, 8, 30, 0, 0, time.UTC)
+	for _, c := range []struct {
+		mtime time.Time
+		want  time.Time
+	}{{time.Time{}, now}, {mtime, mtime}} {
+		file, err := q.InsertFile(ctx, InsertFileConfig{ID: uuid.NewString(), UserID: userID, DirectoryID: dirID, Name: uuid.NewString(), ClientModifiedAt: c.mtime})
+		if err != nil {
+			t.Fatalf("inserting file: %v", err)
+		}
+
+		if !file.UploadedAt.Equal(now) || !file.ClientModifiedAt.Equal(c.want) {
+			t.Errorf("uploaded at = %s, client modified at = %s, want %s and %s", file.UploadedAt, file.ClientModifiedAt, now, c.want)
+		}
+	}
+
+	// Renaming a directory sets its updated_at with the trigger.
+	if _, err := q.db.Exec(ctx, `UPDATE directories SET updated_at = $1 WHERE id = $2`, mtime, dirID); err != nil {
+		t.Fatalf("setting updated at: %v", err)
+	}
+
+	if err := q.UpdateDirectoryName(ctx, dirID, userID, "2024"); err != nil {
+		t.Fatalf("UpdateDirectoryName() error = %v", err)
+	}
+
+	var updated time.Time
+	if err := q.db.QueryRow(ctx, `SELECT updated_at FROM directories WHERE id = $1`, dirID).Scan(&updated); err != nil {
+		t.Fatalf("selecting updated at: %v", err)
+	}
+
+	if !updated.Equal(now) {
+		t.Errorf("updated at = %s, want the database time %s", updated, now)
+	}
+}
+
+// TestUserRootDirectoryNamedRoot checks the root directory of a user is the directory
+// without a parent, not a directory named RootName.
+func TestUserRootDirectoryNamedRoot(t *testing.T) {
+	ctx := context.Background()
+	q := NewQuery(dbtest.Tx(t))
+
+	userID := uuid.NewString()
+	_, err := q.db.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+
+	// root
+	// └── root
+	//     └── a
+	//         └── root
+	mkdir := func(name string, parentID string) string {
+		t.Helper()
+
+		id := uuid.NewString()
+		parent := sql.NullString{String: parentID, Valid: parentID != ""}
+		if _, err := q.InsertDirectory(ctx, InsertDirectoryConfig{ID: id, UserID: userID, Name: name, ParentID: parent}); err != nil {
+			t.Fatalf("inserting directory %s: %v", name, err)
+		}
+
+		if err := q.InsertSelfPath(ctx, id); err != nil {
+			t.Fatalf("inserting self path %s: %v", name, err)
+		}
+
+		if parent.Valid {
+			if err := q.InsertParentPaths(ctx, InsertParentPathsConfig{ParentID: parentID, ChildID: id}); err != nil {
+				t.Fatalf("inserting parent paths %s: %v", name, err)
+			}
+		}
+
+		return id
+	}
+
+	rootID := mkdir(RootName, "")
+	topID := mkdir(RootName, rootID)
+	aID := mkdir("a", topID)
+	deepID := mkdir(RootName, aID)
+
+	row, err := q.SelectUserRootDirectory(ctx, userID)
+	if err != nil || row.ID != rootID {
+		t.Fatalf("SelectUserRootDirectory() = %s, %v, want the root directory %s", row.ID, err, rootID)
+	}
+
+	pm := NewUserPathMapper()
+	for id, want := range map[string]string{rootID: "/", topID: "/root", aID: "/root/a", deepID: "/root/a/root"} {
+		path, err := pm.GetDir(ctx, q, id)
+		if err != nil || path != want {
+			t.Errorf("GetDir() = %q, %v, want %q", path, err, want)
+		}
+
+		found, err := pm.FindDir(ctx, q, PathSearch{UserID: userID, RootID: rootID, Path: want})
+		if err != nil || found != id {
+			t.Errorf("FindDir(%q) = %s, %v, want %s", want, found, err, id)
+		}
+	}
+
+	// A user has one directory without a parent, whatever its name.
+	_, err = q.InsertDirectory(ctx, InsertDirectoryConfig{ID: uuid.NewString(), UserID: userID, Name: "other"})
+	if !errors.Is(err, ErrUniqueUserRoot) {
+		t.Errorf("inserting a second root directory error = %v, want a ErrUniqueUserRoot", err)
+	}
+}
+
+func TestSelectUserTrees(t *testing.T) {
+	ctx := context.Background()
+	q := NewQuery(dbtest.Tx(t))
+
+	userID := uuid.NewString()
+	_, err := q.db.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+
+	// root
+	// ├── a
+	// │   └── b
+	// │       └── c
+	// └── x
+	ids := map[string]string{}
+	mkdir := func(name string, parent string) {
+		t.Helper()
+
+		ids[name] = uuid.NewString()
+		parentID := sql.NullString{String: ids[parent], Valid: parent != ""}
+
+		_, err := q.InsertDirectory(ctx, InsertDirectoryConfig{
+			ID:       ids[name],
+			UserID:   userID,
+			Name:     name,
+			ParentID: parentID,
+		})
+		if err != nil {
+			t.Fatalf("inserting directory %s: %v", name, err)
+		}
+
+		if err := q.InsertSelfPath(ctx, ids[name]); err != nil {
+			t.Fatalf("inserting self path %s: %v", name, err)
+		}
+
+		if parentID.Valid {
+			err := q.InsertParentPaths(ctx, InsertParentPathsConfig{ParentID: parentID.String, ChildID: ids[name]})
+			if err != nil {
+				t.Fatalf("inserting parent paths %s: %v", name, err)
+			}
+		}
+	}
+	mkdir("root", "")
+	mkdir("a", "root")
+	mkdir("b", "a")
+	mkdir("c", "b")
+	mkdir("x", "root")
+
+	trees, err := q.SelectUserTrees(ctx)
+	if err != nil {
+		t.Fatalf("SelectUserTrees() error = %v", err)
+	}
+
+	// The depths are 0, 1, 2, 3, and 1, each directory has a paths row for itself and
+	// one for each ancestor.
+	want := UserTreeRow{UserID: userID, Directories: 5, MaxDepth: 3, AvgDepth: 1.4, ClosureRows: 12}
+	for _, tree := range trees {
+		if tree.UserID != userID {
+			continue
+		}
+
+		if tree != want {
+			t.Errorf("tree = %+v, want %+v", tree, want)
+		}
+		return
+	}
+
+	t.Errorf("SelectUserTrees() = %+v, want a tree for %s", trees, userID)
+}
@@ -0,0 +1,97 @@
+package cloudstore
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"syscall"
+	"time"
+)
+
+// The number of attempts and the backoff before the first retry when removing from
+// the file system. The backoff doubles after every attempt.
+const (
+	removeAttempts = 4
+	removeBackoff  = 100 * time.Millisecond
+)
+
+// RemoveResult is the result of removing a file or directory from the file system.
+type RemoveResult struct {
+	// The path that was removed.
+	Path string
+
+	// Recursive is true if the path was removed with all of its children.
+	Recursive bool
+
+	// The number of attempts made.
+	Attempts int
+
+	// The error of the final attempt. Nil if the path was removed.
+	Err error
+}
+
+// OK returns true if the path was removed.
+func (r RemoveResult) OK() bool {
+	return r.Err == nil
+}
+
+// isTransientFSError returns true if err is a file system error that may succeed if
+// retried, such as a stale NFS file handle or a busy resource.
+func isTransientFSError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+
+	switch errno {
+	case syscall.ESTALE, syscall.EBUSY, syscall.EAGAIN, syscall.EINTR:
+		return true
+	default:
+		return false
+	}
+}
+
+// remove calls removeFunc until it succeeds, returns a error that is not transient,
+// or removeAttempts is reached. A path that does not exist is considered removed.
+func (io *IO) remove(fsPath string, recursive bool, removeFunc func(string) error) RemoveResult {
+	result := RemoveResult{Path: fsPath, Recursive: recursive}
+	backoff := removeBackoff
+
+	for {
+		result.Attempts++
+
+		err := removeFunc(fsPath)
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			result.Err = nil
+			return result
+		}
+
+		result.Err = err
+		if !isTransientFSError(err) || result.Attempts >= removeAttempts {
+			return result
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// queueCleanup persists a failed RemoveResult to the fs_cleanup table so it can be
+// retried by DirService.Cleanup. If it cannot be persisted, the path is logged for
+// manual intervention.
+func queueCleanup(ctx context.Context, store Storage, log *log.Logger, r RemoveResult) {
+	now := time.Now().UTC()
+
+	err := store.UpsertFSCleanup(ctx, FSCleanupRow{
+		Path:      r.Path,
+		Recursive: r.Recursive,
+		Attempts:  r.Attempts,
+		LastError: r.Err.Error(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		log.Printf("[ERROR] Queueing cleanup [path: %s, remove error: %v]: %v\n", r.Path, r.Err, err)
+	}
+}
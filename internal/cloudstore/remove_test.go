@@ -0,0 +1,99 @@
+package cloudstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIORemove(t *testing.T) {
+	io := NewIO(&OSFileSystem{}, NewFSPathMapper(t.TempDir()))
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantOK       bool
+	}{
+		{name: "removed", errs: []error{nil}, wantAttempts: 1, wantOK: true},
+		{name: "missing counts as removed", errs: []error{os.ErrNotExist}, wantAttempts: 1, wantOK: true},
+		{name: "transient then removed", errs: []error{syscall.ESTALE, syscall.EBUSY, nil}, wantAttempts: 3, wantOK: true},
+		{name: "not transient", errs: []error{syscall.EACCES}, wantAttempts: 1},
+		{name: "transient every attempt", errs: []error{syscall.EAGAIN, syscall.EAGAIN, syscall.EINTR, syscall.EAGAIN}, wantAttempts: removeAttempts},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			result := io.remove("/store/a", false, func(string) error {
+				err := tc.errs[calls]
+				calls++
+				if err == nil {
+					return nil
+				}
+
+				return &os.PathError{Op: "remove", Path: "/store/a", Err: err}
+			})
+
+			if result.Attempts != tc.wantAttempts || result.OK() != tc.wantOK {
+				t.Errorf("remove() = %+v, want %d attempts, ok %v", result, tc.wantAttempts, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestDirServiceCleanup(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+
+	file := filepath.Join(root, "file")
+	if err := os.WriteFile(file, []byte("a"), 0600); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+
+	tree := filepath.Join(root, "tree")
+	if err := os.MkdirAll(filepath.Join(tree, "child"), 0700); err != nil {
+		t.Fatalf("creating directory: %v", err)
+	}
+
+	// A directory that is not empty is not removed without Recursive.
+	notEmpty := filepath.Join(root, "not-empty")
+	if err := os.MkdirAll(filepath.Join(notEmpty, "child"), 0700); err != nil {
+		t.Fatalf("creating directory: %v", err)
+	}
+
+	now := time.Now().UTC()
+	for _, row := range []FSCleanupRow{
+		{Path: file, Attempts: 4, LastError: "stale file handle"},
+		{Path: tree, Recursive: true, Attempts: 4, LastError: "stale file handle"},
+		{Path: filepath.Join(root, "missing"), Attempts: 4, LastError: "stale file handle"},
+		{Path: notEmpty, Attempts: 4, LastError: "stale file handle"},
+	} {
+		row.CreatedAt, row.UpdatedAt = now, now
+		if err := f.UpsertFSCleanup(ctx, row); err != nil {
+			t.Fatalf("queueing cleanup: %v", err)
+		}
+	}
+
+	removed, remaining, err := s.Cleanup(ctx)
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+
+	if removed != 3 || remaining != 1 {
+		t.Errorf("Cleanup() = %d removed, %d remaining, want 3 and 1", removed, remaining)
+	}
+
+	if got := dirEntries(t, root); len(got) != 1 || got[0] != "not-empty" {
+		t.Errorf("file store entries = %v, want only not-empty", got)
+	}
+
+	queued := f.data().cleanup
+	if len(queued) != 1 || queued[0].Path != notEmpty || queued[0].LastError == "stale file handle" {
+		t.Errorf("queued cleanups = %+v, want only not-empty with its new error", queued)
+	}
+}
@@ -0,0 +1,137 @@
+package cloudstore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// The number of hits returned by FileService.Search.
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// SearchParams is the parameters of a file search.
+type SearchParams struct {
+	// Query is the text searched for. It is matched against file names, or against the
+	// search text of files if Content is true.
+	Query string
+
+	// Content searches inside the files indexed for content search instead of their
+	// names. The query is parsed with websearch_to_tsquery, so it may use quoted phrases,
+	// "or", and "-" to exclude words.
+	Content bool
+
+	// Limit is the maximum number of hits. If zero, it defaults to DefaultSearchLimit.
+	Limit int
+}
+
+// ParseSearch parses the "q", "content", and "limit" query parameters. If any are not
+// valid, a 400 app.WrappedSafeError is returned.
+func ParseSearch(q url.Values) (SearchParams, error) {
+	p := SearchParams{Query: strings.TrimSpace(q.Get("q")), Limit: DefaultSearchLimit}
+	if p.Query == "" {
+		return SearchParams{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("empty search query"),
+			SafeMessage: "Search query is required",
+			StatusCode:  http.StatusBadRequest,
+			Field:       "q",
+		})
+	}
+
+	if v := q.Get("content"); v != "" {
+		content, err := strconv.ParseBool(v)
+		if err != nil {
+			return SearchParams{}, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("invalid content: %q", v),
+				SafeMessage: "Content must be true or false",
+				StatusCode:  http.StatusBadRequest,
+				Field:       "content",
+			})
+		}
+		p.Content = content
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxSearchLimit {
+			return SearchParams{}, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("invalid limit: %q", v),
+				SafeMessage: fmt.Sprintf("Limit must be a whole number between 1 and %d", MaxSearchLimit),
+				StatusCode:  http.StatusBadRequest,
+				Field:       "limit",
+			})
+		}
+		p.Limit = limit
+	}
+
+	return p, nil
+}
+
+// SearchHit is a file matched by a search.
+type SearchHit struct {
+	ID          string
+	DirectoryID string
+	Name        string
+	Path        string
+	Size        int64
+	UploadedAt  time.Time
+
+	// Snippet is the part of the search text around the match, with matched words
+	// wrapped in <b> tags. It is only set for content searches.
+	Snippet string
+
+	// Rank is the relevance of the hit, higher is more relevant. It is only set for
+	// content searches.
+	Rank float64
+}
+
+// Search searches the files of a user. The hits of a content search are ordered by rank,
+// otherwise they are ordered by name.
+func (s *FileService) Search(ctx context.Context, userID string, p SearchParams) ([]SearchHit, error) {
+	if p.Limit <= 0 {
+		p.Limit = DefaultSearchLimit
+	}
+
+	if p.Limit > MaxSearchLimit {
+		p.Limit = MaxSearchLimit
+	}
+
+	rows, err := s.store.SearchFiles(ctx, SearchFilesConfig{
+		UserID:  userID,
+		Query:   p.Query,
+		Content: p.Content,
+		Limit:   p.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("searching files [user_id: %s]: %w", userID, err)
+	}
+
+	hits := make([]SearchHit, 0, len(rows))
+	for _, r := range rows {
+		path, err := s.pathMap.GetFile(ctx, s.store, r.DirectoryID, r.Name)
+		if err != nil {
+			return nil, fmt.Errorf("getting path [file_id: %s]: %w", r.ID, err)
+		}
+
+		hits = append(hits, SearchHit{
+			ID:          r.ID,
+			DirectoryID: r.DirectoryID,
+			Name:        r.Name,
+			Path:        path,
+			Size:        r.Size,
+			UploadedAt:  r.UploadedAt.UTC(),
+			Snippet:     r.Snippet,
+			Rank:        r.Rank,
+		})
+	}
+
+	return hits, nil
+}
@@ -0,0 +1,165 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		want      SearchParams
+		wantField string
+	}{
+		{name: "name", query: "q=+report+", want: SearchParams{Query: "report", Limit: DefaultSearchLimit}},
+		{name: "content", query: "q=report&content=true&limit=5", want: SearchParams{Query: "report", Content: true, Limit: 5}},
+		{name: "no query", query: "q=+&content=true", wantField: "q"},
+		{name: "invalid content", query: "q=report&content=yes", wantField: "content"},
+		{name: "zero limit", query: "q=report&limit=0", wantField: "limit"},
+		{name: "limit too large", query: "q=report&limit=101", wantField: "limit"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(tc.query)
+
+			got, err := ParseSearch(q)
+			if tc.wantField == "" {
+				if err != nil || got != tc.want {
+					t.Errorf("ParseSearch() = %+v, %v, want %+v", got, err, tc.want)
+				}
+				return
+			}
+
+			var fieldErr app.FieldError
+			if !errors.As(err, &fieldErr) || fieldErr.Field() != tc.wantField {
+				t.Errorf("ParseSearch() error = %v, want a error of the %s field", err, tc.wantField)
+			}
+		})
+	}
+}
+
+func TestFileServiceSearch(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	reports := addTestDir(t, f, root, userRoot, "reports")
+	addTestFile(t, f, root, reports, "q2-report.txt")
+	addTestFile(t, f, root, userRoot, "Q1-Report.txt")
+	addTestFile(t, f, root, userRoot, "notes.txt")
+
+	// The files of other users are never matched.
+	foreign := addTestRoot(t, f, root)
+	addTestFile(t, f, root, foreign, "report.txt")
+
+	hits, err := s.Search(context.Background(), userRoot.UserID, SearchParams{Query: "report", Limit: MaxSearchLimit + 1})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+
+	paths := []string{}
+	for _, h := range hits {
+		paths = append(paths, h.Path)
+	}
+
+	if got := strings.Join(paths, ","); got != "/Q1-Report.txt,/reports/q2-report.txt" {
+		t.Errorf("Search() = %s, want /Q1-Report.txt,/reports/q2-report.txt", got)
+	}
+}
+
+func TestSearchFiles(t *testing.T) {
+	ctx := context.Background()
+	q := NewQuery(dbtest.Tx(t))
+
+	insertUser := func() (string, string) {
+		t.Helper()
+
+		userID, rootID := uuid.NewString(), uuid.NewString()
+		_, err := q.db.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+			userID, userID+"@example.com")
+		if err != nil {
+			t.Fatalf("inserting user: %v", err)
+		}
+
+		if _, err := q.InsertDirectory(ctx, InsertDirectoryConfig{ID: rootID, UserID: userID, Name: RootName}); err != nil {
+			t.Fatalf("inserting root directory: %v", err)
+		}
+
+		return userID, rootID
+	}
+
+	insertFile := func(userID string, dirID string, name string, text string) string {
+		t.Helper()
+
+		id := uuid.NewString()
+		if _, err := q.InsertFile(ctx, InsertFileConfig{ID: id, UserID: userID, DirectoryID: dirID, Name: name}); err != nil {
+			t.Fatalf("inserting file %s: %v", name, err)
+		}
+
+		err := q.UpdateFileContent(ctx, id, FileContent{
+			Size:        int64(len(text)),
+			Checksum:    checksum(text),
+			ContentType: "text/plain; charset=utf-8",
+			SearchText:  sql.NullString{String: text, Valid: text != ""},
+		})
+		if err != nil {
+			t.Fatalf("recording content of %s: %v", name, err)
+		}
+
+		return id
+	}
+
+	userID, rootID := insertUser()
+	often := insertFile(userID, rootID, "often.txt", "The quarterly budget, the budget review, and the budget plan.")
+	once := insertFile(userID, rootID, "once.md", "Notes from the meeting about the budget.")
+	insertFile(userID, rootID, "budget.csv", "")
+	insertFile(userID, rootID, "100%.txt", "")
+
+	foreignID, foreignRoot := insertUser()
+	insertFile(foreignID, foreignRoot, "budget.txt", "The budget of another user.")
+
+	search := func(query string, content bool) []SearchRow {
+		t.Helper()
+
+		rows, err := q.SearchFiles(ctx, SearchFilesConfig{UserID: userID, Query: query, Content: content, Limit: 10})
+		if err != nil {
+			t.Fatalf("SearchFiles(%q) error = %v", query, err)
+		}
+
+		return rows
+	}
+
+	// A content search ranks the file that matches the most first, with a snippet.
+	rows := search("budget", true)
+	if len(rows) != 2 || rows[0].ID != often || rows[1].ID != once {
+		t.Fatalf("content search = %+v, want often.txt then once.md", rows)
+	}
+
+	if rows[0].Rank <= rows[1].Rank || !strings.Contains(rows[0].Snippet, "<b>budget</b>") {
+		t.Errorf("content search rank = %f and %f, snippet %q, want decreasing rank and a marked snippet",
+			rows[0].Rank, rows[1].Rank, rows[0].Snippet)
+	}
+
+	// A word that is excluded removes the file.
+	if rows := search("budget -meeting", true); len(rows) != 1 || rows[0].ID != often {
+		t.Errorf("content search excluding meeting = %+v, want often.txt", rows)
+	}
+
+	// A name search matches the name only, the wildcards of LIKE are matched literally.
+	if rows := search("BUDGET", false); len(rows) != 1 || rows[0].Name != "budget.csv" {
+		t.Errorf("name search = %+v, want budget.csv", rows)
+	}
+
+	if rows := search("%", false); len(rows) != 1 || rows[0].Name != "100%.txt" {
+		t.Errorf("name search for %% = %+v, want 100%%.txt", rows)
+	}
+}
@@ -0,0 +1,135 @@
+package cloudstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cache"
+)
+
+// The default and maximum number of days in a statistics series.
+const (
+	DefaultStatsDays = 30
+	MaxStatsDays     = 365
+)
+
+// StatsCacheTTL is the time an aggregated statistics series is cached for.
+const StatsCacheTTL = time.Minute
+
+// DayCount is the number of events on a day. Date is formatted as "2006-01-02".
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// ParseStatsDays parses the "days" URL query parameter. If it is not set, DefaultStatsDays
+// is returned. If it is not a whole number between 1 and MaxStatsDays, a
+// app.WrappedSafeError with a 400 status code is returned.
+func ParseStatsDays(q url.Values) (int, error) {
+	v := q.Get("days")
+	if v == "" {
+		return DefaultStatsDays, nil
+	}
+
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 1 || days > MaxStatsDays {
+		return 0, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid days: %q", v),
+			SafeMessage: fmt.Sprintf("Days must be a whole number between 1 and %d", MaxStatsDays),
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	return days, nil
+}
+
+// Location returns the time zone the DirService groups statistics into days in.
+func (s *DirService) Location() *time.Location {
+	return s.location
+}
+
+// UploadStats counts the files a user uploaded on each of the last days, including today.
+// Days are in the DirService's display location. The series is dense, every day is
+// included even if no files were uploaded, and it is ordered from oldest to newest.
+//
+// If the DirService has a cache, the series is cached for StatsCacheTTL.
+func (s *DirService) UploadStats(ctx context.Context, userID string, days int) ([]DayCount, error) {
+	if err := requireUserID(userID); err != nil {
+		return nil, err
+	}
+
+	if days < 1 || days > MaxStatsDays {
+		return nil, fmt.Errorf("days out of range: %d", days)
+	}
+
+	key := fmt.Sprintf("cloudstore:stats:uploads:%s:%d:%s", userID, days, s.location)
+	if series, ok := s.cachedStats(ctx, key); ok {
+		return series, nil
+	}
+
+	now := time.Now().In(s.location)
+	start := time.Date(now.Year(), now.Month(), now.Day()-(days-1), 0, 0, 0, 0, s.location)
+
+	counts, err := s.store.SelectUploadsPerDay(ctx, userID, start, s.location.String())
+	if err != nil {
+		return nil, fmt.Errorf("selecting uploads per day [user: %s]: %w", userID, err)
+	}
+
+	series := make([]DayCount, days)
+	for i := range series {
+		date := start.AddDate(0, 0, i).Format(time.DateOnly)
+		series[i] = DayCount{Date: date, Count: counts[date]}
+	}
+
+	s.cacheStats(ctx, key, series)
+
+	return series, nil
+}
+
+// cachedStats gets the series cached with key. Cache errors are logged and treated as a miss.
+func (s *DirService) cachedStats(ctx context.Context, key string) ([]DayCount, bool) {
+	if s.cache == nil {
+		return nil, false
+	}
+
+	val, err := s.cache.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			s.log.Printf("[ERROR] Getting cached stats [key: %s]: %v\n", key, err)
+		}
+
+		return nil, false
+	}
+
+	var series []DayCount
+	if err := json.Unmarshal([]byte(val), &series); err != nil {
+		s.log.Printf("[ERROR] Decoding cached stats [key: %s]: %v\n", key, err)
+		return nil, false
+	}
+
+	return series, true
+}
+
+// cacheStats caches series with key for StatsCacheTTL. Cache errors are logged.
+func (s *DirService) cacheStats(ctx context.Context, key string, series []DayCount) {
+	if s.cache == nil {
+		return
+	}
+
+	val, err := json.Marshal(series)
+	if err != nil {
+		s.log.Printf("[ERROR] Encoding stats [key: %s]: %v\n", key, err)
+		return
+	}
+
+	if err := s.cache.Set(ctx, key, val, StatsCacheTTL); err != nil {
+		s.log.Printf("[ERROR] Caching stats [key: %s]: %v\n", key, err)
+	}
+}
@@ -0,0 +1,159 @@
+package cloudstore
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+func TestParseStatsDays(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: DefaultStatsDays},
+		{value: "1", want: 1},
+		{value: "365", want: MaxStatsDays},
+		{value: "0", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "366", wantErr: true},
+		{value: "7.5", wantErr: true},
+		{value: "week", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseStatsDays(url.Values{"days": {tc.value}})
+		if tc.wantErr {
+			assertSafeError(t, err, http.StatusBadRequest, nil)
+			continue
+		}
+
+		if err != nil || got != tc.want {
+			t.Errorf("ParseStatsDays(%q) = %d, %v, want %d", tc.value, got, err, tc.want)
+		}
+	}
+}
+
+func TestDirServiceUploadStats(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("loading time zone: %v", err)
+	}
+
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+	s.location = loc
+	userRoot := addTestRoot(t, f, root)
+
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	upload := func(at time.Time) {
+		f.addFile(FileRow{ID: uuid.NewString(), UserID: userRoot.UserID, DirectoryID: userRoot.ID, Name: uuid.NewString(), UploadedAt: at.UTC()})
+	}
+
+	// Late in the evening is the next day in UTC, it is counted on the local day.
+	upload(today)
+	upload(today.Add(-30 * time.Minute))
+	upload(today.AddDate(0, 0, -1).Add(23*time.Hour + 30*time.Minute))
+	upload(today.AddDate(0, 0, -39))
+	// Before the first day of the series.
+	upload(today.AddDate(0, 0, -39).Add(-time.Minute))
+
+	// Uploads of other users are not counted.
+	f.addFile(FileRow{ID: uuid.NewString(), UserID: uuid.NewString(), DirectoryID: userRoot.ID, Name: "other", UploadedAt: today.UTC()})
+
+	// 40 days always span a month boundary.
+	series, err := s.UploadStats(context.Background(), userRoot.UserID, 40)
+	if err != nil {
+		t.Fatalf("UploadStats() error = %v", err)
+	}
+
+	if len(series) != 40 {
+		t.Fatalf("series days = %d, want 40", len(series))
+	}
+
+	want := map[string]int{
+		today.Format(time.DateOnly):                    1,
+		today.AddDate(0, 0, -1).Format(time.DateOnly):  2,
+		today.AddDate(0, 0, -39).Format(time.DateOnly): 1,
+	}
+
+	for i, day := range series {
+		date := today.AddDate(0, 0, i-39).Format(time.DateOnly)
+		if day.Date != date {
+			t.Fatalf("series[%d] date = %s, want %s", i, day.Date, date)
+		}
+
+		if day.Count != want[date] {
+			t.Errorf("%s count = %d, want %d", date, day.Count, want[date])
+		}
+	}
+}
+
+func TestDirServiceUploadStatsInvalid(t *testing.T) {
+	f := newFakeStorage(t)
+	s, _ := newTestDirService(t, f)
+
+	for _, days := range []int{0, MaxStatsDays + 1} {
+		if _, err := s.UploadStats(context.Background(), uuid.NewString(), days); err == nil {
+			t.Errorf("UploadStats(%d) error = nil, want a error", days)
+		}
+	}
+}
+
+func TestSelectUploadsPerDay(t *testing.T) {
+	ctx := context.Background()
+	q := NewQuery(dbtest.Tx(t))
+
+	userID := uuid.NewString()
+	_, err := q.db.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+
+	rootID := uuid.NewString()
+	if _, err := q.InsertDirectory(ctx, InsertDirectoryConfig{ID: rootID, UserID: userID, Name: RootName}); err != nil {
+		t.Fatalf("inserting root directory: %v", err)
+	}
+
+	upload := func(at string, status FileStatus) {
+		t.Helper()
+
+		id := uuid.NewString()
+		_, err := q.InsertFile(ctx, InsertFileConfig{ID: id, UserID: userID, DirectoryID: rootID, Name: id, Status: status})
+		if err != nil {
+			t.Fatalf("inserting file: %v", err)
+		}
+
+		if _, err := q.db.Exec(ctx, `UPDATE files SET uploaded_at = $1 WHERE id = $2`, at, id); err != nil {
+			t.Fatalf("updating upload time: %v", err)
+		}
+	}
+
+	// The uploads span the end of January in New York, which is already February in UTC.
+	upload("2024-01-30T12:00:00Z", FileReady)
+	upload("2024-02-01T04:30:00Z", FileReady)
+	upload("2024-02-01T05:30:00Z", FileReady)
+	upload("2024-02-01T06:00:00Z", FilePending)
+	upload("2024-01-01T12:00:00Z", FileReady)
+
+	since := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+
+	got, err := q.SelectUploadsPerDay(ctx, userID, since, "America/New_York")
+	if err != nil {
+		t.Fatalf("SelectUploadsPerDay() error = %v", err)
+	}
+
+	want := map[string]int{"2024-01-30": 1, "2024-01-31": 1, "2024-02-01": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SelectUploadsPerDay() = %v, want %v", got, want)
+	}
+}
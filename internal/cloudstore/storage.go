@@ -0,0 +1,61 @@
+package cloudstore
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// ErrStorageFull signals the file system ran out of space, or the quota of the server
+// was exceeded, while writing.
+var ErrStorageFull = errors.New("storage full")
+
+// storageMetrics counts the writes that failed because the file system was full and
+// records the last free space measured by IO.CheckFreeSpace. It is published with
+// expvar as "cloudstore_storage".
+var storageMetrics = expvar.NewMap("cloudstore_storage")
+
+// storageFull wraps err with ErrStorageFull if it was caused by the file system
+// running out of space (ENOSPC) or quota (EDQUOT), and counts it. Other errors are
+// returned as is.
+func storageFull(err error) error {
+	if err == nil || errors.Is(err, ErrStorageFull) {
+		return err
+	}
+
+	if !errors.Is(err, syscall.ENOSPC) && !errors.Is(err, syscall.EDQUOT) {
+		return err
+	}
+
+	storageMetrics.Add("full_errors", 1)
+	storageMetrics.Set("last_full_unix", intVar(time.Now().Unix()))
+
+	return fmt.Errorf("%w: %w", ErrStorageFull, err)
+}
+
+// CheckFreeSpace returns a error if the file system the root storage directory is on
+// has less than min bytes available. The available bytes are recorded in the
+// "free_bytes" metric.
+func (io *IO) CheckFreeSpace(min int64) error {
+	free, err := freeSpace(io.paths.Root())
+	if err != nil {
+		return fmt.Errorf("getting free space [%s]: %w", io.paths.Root(), err)
+	}
+
+	storageMetrics.Set("free_bytes", intVar(free))
+
+	if free < min {
+		return fmt.Errorf("%w: %d bytes available, less than %d", ErrStorageFull, free, min)
+	}
+
+	return nil
+}
+
+// intVar returns v as a expvar.Var.
+func intVar(v int64) expvar.Var {
+	i := new(expvar.Int)
+	i.Set(v)
+	return i
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cloudstore
+
+import "errors"
+
+// freeSpace is not supported on this platform.
+func freeSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
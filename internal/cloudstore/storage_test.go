@@ -0,0 +1,169 @@
+package cloudstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// storageMetric returns the value of the integer metric name of storageMetrics.
+func storageMetric(name string) int64 {
+	v, ok := storageMetrics.Get(name).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+
+	return v.Value()
+}
+
+// fullWriter fails every write with err.
+type fullWriter struct {
+	err error
+}
+
+func (w fullWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestStorageFull(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil},
+		{name: "other", err: errors.New("permission denied")},
+		{name: "ENOSPC", err: syscall.ENOSPC, want: true},
+		{name: "EDQUOT", err: syscall.EDQUOT, want: true},
+		{name: "wrapped", err: &os.PathError{Op: "write", Path: "/srv/clox/a", Err: syscall.ENOSPC}, want: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := storageMetric("full_errors")
+
+			err := storageFull(tc.err)
+			if got := errors.Is(err, ErrStorageFull); got != tc.want {
+				t.Fatalf("storageFull() = %v, want ErrStorageFull %t", err, tc.want)
+			}
+
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("storageFull() = %v, want it to wrap %v", err, tc.err)
+			}
+
+			var want int64
+			if tc.want {
+				want = 1
+			}
+
+			if got := storageMetric("full_errors") - before; got != want {
+				t.Errorf("full_errors increased by %d, want %d", got, want)
+			}
+
+			// A error is only counted once.
+			storageFull(err)
+			if got := storageMetric("full_errors") - before; got != want {
+				t.Errorf("full_errors increased by %d after wrapping twice, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestCopyContextStorageFull(t *testing.T) {
+	fs := &OSFileSystem{}
+
+	_, err := fs.CopyContext(context.Background(), fullWriter{err: syscall.ENOSPC}, strings.NewReader("hello"), CopyOptions{})
+	if !errors.Is(err, ErrStorageFull) || !errors.Is(err, ErrCopy) {
+		t.Errorf("CopyContext() error = %v, want ErrStorageFull and ErrCopy", err)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	io := NewIO(&OSFileSystem{}, NewFSPathMapper(t.TempDir()))
+
+	if err := io.CheckFreeSpace(0); err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			t.Skip("free space is not supported on this platform")
+		}
+		t.Fatalf("CheckFreeSpace(0) error = %v", err)
+	}
+
+	if free := storageMetric("free_bytes"); free <= 0 {
+		t.Errorf("free_bytes = %d, want the free space recorded", free)
+	}
+
+	if err := io.CheckFreeSpace(math.MaxInt64); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("CheckFreeSpace(MaxInt64) error = %v, want ErrStorageFull", err)
+	}
+}
+
+func TestFileServiceSaveBatchStorageFull(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+
+	f := newFakeStorage(t)
+	s, root := newTestFileService(t, f)
+	userRoot := addTestRoot(t, f, root)
+	s.validateUser = func(ctx context.Context, userID string) (Dir, error) {
+		return Dir{ID: userRoot.ID}, nil
+	}
+
+	var logs bytes.Buffer
+	s.log = log.New(&logs, "", 0)
+
+	// The file is created on /dev/full, every write fails with ENOSPC.
+	f.failOn("SelectDirectoryFSPath", func() error {
+		var fileID string
+		f.concurrent(func(d *fakeData) {
+			for _, file := range d.files {
+				fileID = file.ID
+			}
+		})
+
+		return os.Symlink("/dev/full", filepath.Join(root, userRoot.ID, fileID))
+	})
+
+	before := storageMetric("full_errors")
+
+	batch, err := s.SaveBatch(context.Background(), userRoot.UserID, "", []*multipart.FileHeader{
+		newTestFileHeader(t, "a.txt", "hello"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+
+	saveErr := batch.Saves[0].Err
+	assertSafeError(t, saveErr, http.StatusInsufficientStorage, ErrStorageFull)
+
+	var safeErr *app.WrappedSafeError
+	if errors.As(saveErr, &safeErr) {
+		if msg, _ := safeErr.Safe(); msg != "Server storage is full; try again later" {
+			t.Errorf("message = %q, want the storage full message", msg)
+		}
+	}
+
+	if got := storageMetric("full_errors") - before; got != 1 {
+		t.Errorf("full_errors increased by %d, want 1", got)
+	}
+
+	if !strings.Contains(logs.String(), "[ERROR] Storage full") {
+		t.Errorf("logs = %q, want the storage full error logged", logs.String())
+	}
+
+	if got := len(f.data().files); got != 0 {
+		t.Errorf("files = %d, want the upload rolled back", got)
+	}
+}
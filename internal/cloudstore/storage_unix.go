@@ -0,0 +1,24 @@
+//go:build unix
+
+package cloudstore
+
+import (
+	"math"
+	"syscall"
+)
+
+// freeSpace returns the number of bytes available to unprivileged users on the file
+// system path is on.
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+
+	free := uint64(st.Bavail) * uint64(st.Bsize)
+	if free > math.MaxInt64 {
+		return math.MaxInt64, nil
+	}
+
+	return int64(free), nil
+}
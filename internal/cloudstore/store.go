@@ -2,8 +2,11 @@ package cloudstore
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/cicconee/clox/internal/app"
 	"github.com/cicconee/clox/internal/db"
@@ -11,8 +14,116 @@ import (
 
 var ErrCommitTx = errors.New("failed to commit transaction")
 
+// errDryRun is returned by the txFunc of a dry run once every statement has run, so Tx
+// rolls back the changes. It is never returned to the caller of the dry run.
+var errDryRun = errors.New("dry run")
+
+// Queries are the reads and writes of the cloudstore services. They take and return rows
+// and the errors of this package, such as ErrUniqueNameParentID, never SQL, so they can be
+// implemented without Postgres. *Query is the Postgres implementation.
+//
+// If a service calls a new query, add it to Queries, or to Tx if it is only valid in a
+// transaction.
+type Queries interface {
+	InsertDirectory(ctx context.Context, c InsertDirectoryConfig) (time.Time, error)
+	InsertSelfPath(ctx context.Context, directoryID string) error
+	InsertParentPaths(ctx context.Context, c InsertParentPathsConfig) error
+	SelectIsDescendant(ctx context.Context, ancestorID string, id string) (bool, error)
+	MoveSubtreePaths(ctx context.Context, id string, parentID string) error
+	SelectDirectoryFSPath(ctx context.Context, directoryID string) ([]string, error)
+	SelectDirectoryPath(ctx context.Context, directoryID string) ([]string, error)
+	SelectDirectoryLayout(ctx context.Context, directoryID string) (Layout, error)
+	UpdateDirectoryLayout(ctx context.Context, directoryID string, layout Layout) error
+	SelectDirectoryByIDUser(ctx context.Context, id string, userID string) (DirectoryRow, error)
+	SelectDirectoryByUserNameParent(ctx context.Context, userID string, name string, parentID string) (DirectoryRow, error)
+	SelectUserRootDirectory(ctx context.Context, userID string) (DirectoryRow, error)
+	SelectDescendantDirectoryIDs(ctx context.Context, directoryID string) ([]string, error)
+	UpdateDirectoryName(ctx context.Context, id string, userID string, name string) error
+	UpdateDirectoryParent(ctx context.Context, id string, userID string, parentID string) error
+	UpdateLastWrite(ctx context.Context, directoryID string) ([]string, error)
+	DeleteDirectories(ctx context.Context, ids []string) error
+	SelectChildVersions(ctx context.Context, directoryID string) ([]ChildVersionRow, error)
+	SelectEntries(ctx context.Context, directoryID string, opts ListOptions) ([]EntryRow, error)
+
+	InsertFile(ctx context.Context, c InsertFileConfig) (InsertedFile, error)
+	SelectFileByID(ctx context.Context, id string) (FileRow, error)
+	SelectFileByIDUser(ctx context.Context, id string, userID string) (FileRow, error)
+	SelectFileByUserDirName(ctx context.Context, userID string, dirID string, name string) (FileRow, error)
+	SelectFileIDsByDirectory(ctx context.Context, directoryID string) ([]string, error)
+	SelectFileIDsInDirectories(ctx context.Context, directoryIDs []string) ([]string, error)
+	SelectFilesSize(ctx context.Context, ids []string) (int64, error)
+	UpdateFileName(ctx context.Context, id string, userID string, name string) error
+	UpdateFileDirectory(ctx context.Context, id string, userID string, directoryID string) error
+	UpdateFileContent(ctx context.Context, id string, c FileContent) error
+	UpdateFileContentMissing(ctx context.Context, id string, missing bool) error
+	UpdateFileStatus(ctx context.Context, id string, status FileStatus) error
+	DeleteFiles(ctx context.Context, ids []string) (int64, error)
+	SearchFiles(ctx context.Context, c SearchFilesConfig) ([]SearchRow, error)
+	SelectPendingFiles(ctx context.Context, before time.Time, limit int) ([]PendingFileRow, error)
+	DeletePendingFile(ctx context.Context, id string) (bool, error)
+
+	SelectDefaultUploadDir(ctx context.Context, userID string) (sql.NullString, error)
+	UpdateDefaultUploadDir(ctx context.Context, userID string, dirID sql.NullString) error
+	SelectStorageState(ctx context.Context, userID string) (StorageState, error)
+	UpdateStorageState(ctx context.Context, userID string, state StorageState) error
+	SelectStorageUsed(ctx context.Context, userID string) (int64, error)
+	SelectCheckDirectories(ctx context.Context, userID string) ([]CheckDirRow, error)
+	SelectForeignPaths(ctx context.Context, userID string) ([]ForeignPathRow, error)
+	SelectCheckFiles(ctx context.Context, userID string) ([]CheckFileRow, error)
+	SelectUploadsPerDay(ctx context.Context, userID string, since time.Time, timeZone string) (map[string]int, error)
+
+	UpsertFSCleanup(ctx context.Context, r FSCleanupRow) error
+	SelectFSCleanup(ctx context.Context, limit int) ([]FSCleanupRow, error)
+	DeleteFSCleanup(ctx context.Context, path string) error
+
+	SelectBackfillFiles(ctx context.Context, afterID string, limit int) ([]BackfillFileRow, error)
+	SelectFileVersionCounts(ctx context.Context) (map[RecordVersion]int64, error)
+	SelectBackfillCheckpoint(ctx context.Context, name string) (BackfillCheckpointRow, error)
+	UpsertBackfillCheckpoint(ctx context.Context, r BackfillCheckpointRow) error
+
+	SelectUserTrees(ctx context.Context) ([]UserTreeRow, error)
+
+	// InsertEvent adds a event to the outbox, see event.Repo.Insert. It should be called
+	// in the transaction of the change the event describes.
+	InsertEvent(ctx context.Context, userID string, eventType string, payload any) error
+}
+
+// Tx is the Queries of a transaction, see Storage.Tx. The locks and savepoints only exist
+// in a transaction.
+type Tx interface {
+	Queries
+
+	LockDirectories(ctx context.Context, ids ...string) error
+	LockSubtree(ctx context.Context, id string, ids ...string) ([]string, error)
+	SelectDirectoryLayoutForUpdate(ctx context.Context, directoryID string) (Layout, error)
+	SelectFileByIDUserForUpdate(ctx context.Context, id string, userID string) (FileRow, error)
+
+	Savepoint(ctx context.Context, name string) error
+	RollbackToSavepoint(ctx context.Context, name string) error
+	ReleaseSavepoint(ctx context.Context, name string) error
+}
+
+// Storage is the database of the cloudstore services. The services only call the
+// database through Storage, so they can be run against an implementation other
+// than Postgres. Store is the Postgres implementation.
+//
+// Writes run in Tx, every other call runs on its own. An implementation that does not
+// use a database can still return the errors a transaction would, such as ErrCommitTx
+// or ErrUniqueUserRoot.
+type Storage interface {
+	Queries
+
+	// Tx runs txFunc in a transaction, see Store.Tx.
+	Tx(ctx context.Context, txFunc func(tx Tx) error) error
+
+	// ProbeDB checks the database is reachable.
+	ProbeDB(ctx context.Context) app.Probe
+}
+
+// Store is the Postgres Storage.
 type Store struct {
-	db app.DB
+	db     app.DB
+	faults *Faults
 	*Query
 }
 
@@ -20,23 +131,36 @@ func NewStore(db app.DB) *Store {
 	return &Store{db: db, Query: NewQuery(db)}
 }
 
+// SetFaults sets the Faults that can force Tx to fail at FaultCommit. If nil, Tx never
+// fails on purpose.
+func (s *Store) SetFaults(f *Faults) {
+	s.faults = f
+}
+
 // Tx begins a transaction and passes the transaction to txFunc. After txFunc
 // executes, it will be committed.
 //
 // If an error occurs, the transaction will be rolled back.
 //
+// If txFunc fails with a error wrapping ErrLockBusy, it is returned as a 409
+// app.WrappedSafeError, the request can be tried again.
+//
 // If a ErrCommitTx is returned, the txFunc successfully executed. Any state
 // that was changed in the txFunc that is dependent on the transaction being
 // committed should be rolled back.
-func (s *Store) Tx(ctx context.Context, txFunc func(tx *db.Tx) error) error {
+func (s *Store) Tx(ctx context.Context, txFunc func(tx Tx) error) error {
 	tx, err := s.db.Tx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	err = txFunc(tx)
+	err = txFunc(NewQuery(tx))
 	if err != nil {
-		return s.rollback(tx, err)
+		return s.rollback(tx, lockBusy(err))
+	}
+
+	if err := s.faults.check(FaultCommit); err != nil {
+		return s.rollback(tx, fmt.Errorf("%w: %w", ErrCommitTx, err))
 	}
 
 	err = tx.Commit()
@@ -57,3 +181,18 @@ func (s *Store) rollback(tx *db.Tx, err error) error {
 
 	return err
 }
+
+// lockBusy returns err as a 409 app.WrappedSafeError if it wraps ErrLockBusy and is not
+// already a app.WrappedSafeError. Otherwise err is returned.
+func lockBusy(err error) error {
+	var safeErr *app.WrappedSafeError
+	if !errors.Is(err, ErrLockBusy) || errors.As(err, &safeErr) {
+		return err
+	}
+
+	return app.Wrap(app.WrapParams{
+		Err:         err,
+		SafeMessage: "Directory is being changed by another request, try again",
+		StatusCode:  http.StatusConflict,
+	})
+}
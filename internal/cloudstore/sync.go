@@ -0,0 +1,784 @@
+package cloudstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// SyncOpKind is the kind of a SyncOp.
+type SyncOpKind string
+
+const (
+	// SyncMkdir creates the directory at Path.
+	SyncMkdir SyncOpKind = "mkdir"
+
+	// SyncUpload saves the file part Part in the directory at Path.
+	SyncUpload SyncOpKind = "upload"
+
+	// SyncDelete deletes the directory or file at Path.
+	SyncDelete SyncOpKind = "delete"
+
+	// SyncMove moves the directory or file at Path to the directory at To.
+	SyncMove SyncOpKind = "move"
+)
+
+// MaxSyncOps is the maximum number of operations in a SyncManifest.
+const MaxSyncOps = 1000
+
+// DefaultSyncConcurrency is the number of operations a SyncService runs at a time if
+// not set.
+const DefaultSyncConcurrency = 4
+
+// SyncOp is a single operation of a SyncManifest.
+type SyncOp struct {
+	// ID identifies the operation in the results. It is chosen by the client and must
+	// be unique within the manifest.
+	ID   string     `json:"id"`
+	Kind SyncOpKind `json:"op"`
+
+	// Path is the directory created by SyncMkdir, the directory a SyncUpload is saved
+	// in, or the directory or file deleted by SyncDelete or moved by SyncMove. It is
+	// resolved under the base directory of the manifest.
+	Path string `json:"path"`
+
+	// Type is the type of the entry at Path of a SyncDelete or SyncMove, "directory" or
+	// "file".
+	Type string `json:"type,omitempty"`
+
+	// To is the directory a SyncMove moves the entry to. It is resolved under the base
+	// directory of the manifest, an empty To is the base directory.
+	To string `json:"to,omitempty"`
+
+	// Part is the name of the multipart file part a SyncUpload saves.
+	Part string `json:"part,omitempty"`
+
+	// Name is the name a SyncUpload is saved as. If empty, it is the file name of the
+	// part.
+	Name string `json:"name,omitempty"`
+
+	// Mtime is the RFC3339 modification time of a SyncUpload. If empty, it is the
+	// upload time.
+	Mtime string `json:"mtime,omitempty"`
+}
+
+// SyncManifest is the changes a sync client applies in a single request.
+type SyncManifest struct {
+	// BaseID is the directory the paths are resolved under. If empty, they are
+	// resolved under the users root directory.
+	BaseID string `json:"base_id"`
+
+	Ops []SyncOp `json:"operations"`
+}
+
+// SyncPlan is a validated SyncManifest, ready to be applied by a SyncService.
+type SyncPlan struct {
+	baseID string
+	ops    []SyncOp
+
+	// dirs are the names of the directory of each operation, see SyncOp.Path.
+	dirs [][]string
+
+	// levels are the indexes of the SyncMkdir operations grouped by the depth of their
+	// path, shallowest first.
+	levels [][]int
+
+	// uploads are the indexes of the SyncUpload operations grouped by the key of their
+	// directory, in manifest order.
+	uploads map[string][]int
+
+	// types are the entry types of the SyncDelete and SyncMove operations, and to the
+	// names of the destination of each SyncMove.
+	types []EntryType
+	to    [][]string
+
+	// moves and deletes are the indexes of the SyncMove and SyncDelete operations, in
+	// manifest order.
+	moves   []int
+	deletes []int
+
+	headers []*multipart.FileHeader
+	mtimes  []time.Time
+}
+
+// Len returns the number of operations in the plan.
+func (p SyncPlan) Len() int {
+	return len(p.ops)
+}
+
+// dirKey returns the key of the directory with the path names.
+func dirKey(names []string) string {
+	return strings.Join(names, "/")
+}
+
+// ParseSyncPlan parses the manifest, a JSON SyncManifest, and validates it against the
+// file parts of the request. It is validated before anything is applied, the plan is
+// rejected if:
+//
+//   - there are no operations, or more than MaxSyncOps
+//   - a operation ID is empty or not unique
+//   - a operation is unknown
+//   - a path is invalid, or a SyncMkdir, SyncDelete, or SyncMove has no path
+//   - two SyncMkdir create the same directory
+//   - a SyncUpload references a part that does not exist or is referenced twice
+//   - two SyncUpload save the same name in the same directory
+//   - a modification time is invalid
+//   - a SyncDelete or SyncMove has no entry type, or the same path is deleted or moved
+//     twice
+//   - a SyncDelete deletes a path under another SyncDelete, or a path that a SyncMkdir,
+//     SyncUpload, or SyncMove writes under
+//   - a SyncMove moves a directory under itself, once the moves before it are applied
+//
+// The moves are checked for cycles in manifest order, the order SyncService.Apply moves
+// them in. A directory may be moved under a directory that was under it only if a
+// earlier SyncMove moved it out.
+//
+// All errors returned are a 400 app.WrappedSafeError of the "manifest" field.
+func ParseSyncPlan(manifest string, parts map[string][]*multipart.FileHeader) (SyncPlan, error) {
+	var m SyncManifest
+	if err := json.Unmarshal([]byte(manifest), &m); err != nil {
+		return SyncPlan{}, syncPlanError(fmt.Errorf("unmarshalling manifest: %w", err), "Manifest must be a JSON object with an operations array")
+	}
+
+	if len(m.Ops) == 0 {
+		return SyncPlan{}, syncPlanError(errors.New("no operations"), "Manifest has no operations")
+	}
+
+	if len(m.Ops) > MaxSyncOps {
+		return SyncPlan{}, syncPlanError(fmt.Errorf("%d operations", len(m.Ops)), fmt.Sprintf("Manifest cannot have more than %d operations", MaxSyncOps))
+	}
+
+	plan := SyncPlan{
+		baseID:  m.BaseID,
+		ops:     m.Ops,
+		dirs:    make([][]string, len(m.Ops)),
+		uploads: map[string][]int{},
+		headers: make([]*multipart.FileHeader, len(m.Ops)),
+		mtimes:  make([]time.Time, len(m.Ops)),
+		types:   make([]EntryType, len(m.Ops)),
+		to:      make([][]string, len(m.Ops)),
+	}
+
+	ids := map[string]bool{}
+	mkdirs := map[string]bool{}
+	files := map[string]bool{}
+	used := map[string]bool{}
+	entries := map[string]bool{}
+	depths := map[int][]int{}
+	now := time.Now()
+
+	for i, op := range m.Ops {
+		if op.ID == "" {
+			return SyncPlan{}, syncPlanError(fmt.Errorf("operation %d has no id", i), fmt.Sprintf("Operation %d has no id", i))
+		}
+
+		if ids[op.ID] {
+			return SyncPlan{}, syncOpError(op, "id is not unique")
+		}
+		ids[op.ID] = true
+
+		names, err := splitPath(op.Path)
+		if err != nil {
+			return SyncPlan{}, syncOpError(op, "path cannot go above the base directory")
+		}
+		plan.dirs[i] = names
+		key := dirKey(names)
+
+		switch op.Kind {
+		case SyncMkdir:
+			if len(names) == 0 {
+				return SyncPlan{}, syncOpError(op, "mkdir needs a path")
+			}
+
+			if mkdirs[key] {
+				return SyncPlan{}, syncOpError(op, fmt.Sprintf("directory %q is created twice", key))
+			}
+			mkdirs[key] = true
+			depths[len(names)] = append(depths[len(names)], i)
+		case SyncUpload:
+			headers := parts[op.Part]
+			if op.Part == "" || len(headers) != 1 {
+				return SyncPlan{}, syncOpError(op, fmt.Sprintf("part %q must be a single file part of the request", op.Part))
+			}
+
+			if used[op.Part] {
+				return SyncPlan{}, syncOpError(op, fmt.Sprintf("part %q is uploaded twice", op.Part))
+			}
+			used[op.Part] = true
+
+			// The header is copied, so saving it under Name does not change the request.
+			header := *headers[0]
+			if op.Name != "" {
+				header.Filename = op.Name
+			}
+
+			if header.Filename == "" || strings.Contains(header.Filename, "/") {
+				return SyncPlan{}, syncOpError(op, "name must be a file name without slashes")
+			}
+
+			file := dirKey(append(names[:len(names):len(names)], header.Filename))
+			if files[file] {
+				return SyncPlan{}, syncOpError(op, fmt.Sprintf("file %q is uploaded twice", file))
+			}
+			files[file] = true
+
+			if op.Mtime != "" {
+				t, err := ParseClientModTime(op.Mtime, now, "mtime")
+				if err != nil {
+					return SyncPlan{}, syncOpError(op, "mtime must be a RFC3339 timestamp that is not in the future")
+				}
+				plan.mtimes[i] = t
+			}
+
+			plan.headers[i] = &header
+			plan.uploads[key] = append(plan.uploads[key], i)
+		case SyncDelete, SyncMove:
+			if len(names) == 0 {
+				return SyncPlan{}, syncOpError(op, fmt.Sprintf("%s needs a path", op.Kind))
+			}
+
+			switch op.Type {
+			case EntryDir.Name():
+				plan.types[i] = EntryDir
+			case EntryFile.Name():
+				plan.types[i] = EntryFile
+			default:
+				return SyncPlan{}, syncOpError(op, "type must be one of: directory, file")
+			}
+
+			if entries[key] {
+				return SyncPlan{}, syncOpError(op, fmt.Sprintf("path %q is deleted or moved twice", key))
+			}
+			entries[key] = true
+
+			if op.Kind == SyncDelete {
+				plan.deletes = append(plan.deletes, i)
+				break
+			}
+
+			to, err := splitPath(op.To)
+			if err != nil {
+				return SyncPlan{}, syncOpError(op, "to cannot go above the base directory")
+			}
+			plan.to[i] = to
+			plan.moves = append(plan.moves, i)
+		default:
+			return SyncPlan{}, syncOpError(op, fmt.Sprintf("unknown operation %q, must be one of: mkdir, upload, delete, move", op.Kind))
+		}
+	}
+
+	if err := plan.checkDeletes(); err != nil {
+		return SyncPlan{}, err
+	}
+
+	if err := plan.checkMoves(); err != nil {
+		return SyncPlan{}, err
+	}
+
+	levels := []int{}
+	for depth := range depths {
+		levels = append(levels, depth)
+	}
+	sort.Ints(levels)
+
+	for _, depth := range levels {
+		plan.levels = append(plan.levels, depths[depth])
+	}
+
+	return plan, nil
+}
+
+// inPath reports whether the path key is the path prefix, or is under it.
+func inPath(key string, prefix string) bool {
+	return key == prefix || strings.HasPrefix(key, prefix+"/")
+}
+
+// checkDeletes checks that nothing the plan writes is under a path it deletes. The
+// entries are deleted after every other operation is applied, so a directory or file
+// created or moved under a deleted path would be deleted with it.
+//
+// A SyncMove may move a entry out of a deleted directory, it is moved before the
+// directory is deleted.
+func (p SyncPlan) checkDeletes() error {
+	for _, d := range p.deletes {
+		deleted := dirKey(p.dirs[d])
+
+		for i, op := range p.ops {
+			if i == d {
+				continue
+			}
+
+			var key string
+			switch op.Kind {
+			case SyncMkdir, SyncDelete:
+				key = dirKey(p.dirs[i])
+			case SyncUpload:
+				key = dirKey(append(p.dirs[i][:len(p.dirs[i]):len(p.dirs[i])], p.headers[i].Filename))
+			case SyncMove:
+				key = dirKey(p.to[i])
+			}
+
+			if inPath(key, deleted) {
+				return syncOpError(op, fmt.Sprintf("path %q is in %q, which is deleted by operation %q", key, deleted, p.ops[d].ID))
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkMoves checks that no SyncMove moves a directory under itself. The moves are
+// applied in manifest order on the paths of the entries before any was moved, so a
+// directory that was moved has the parent it was moved to, and every other entry keeps
+// the parent of its path.
+func (p SyncPlan) checkMoves() error {
+	// parents are the destinations of the directories moved so far.
+	parents := map[string]string{}
+
+	for _, i := range p.moves {
+		if p.types[i] != EntryDir {
+			continue
+		}
+
+		moved := dirKey(p.dirs[i])
+		for key := dirKey(p.to[i]); ; {
+			if key == moved {
+				return syncOpError(p.ops[i], fmt.Sprintf("directory %q cannot be moved under itself", moved))
+			}
+
+			if key == "" {
+				break
+			}
+
+			if parent, ok := parents[key]; ok {
+				key = parent
+				continue
+			}
+
+			names := strings.Split(key, "/")
+			key = dirKey(names[:len(names)-1])
+		}
+
+		parents[moved] = dirKey(p.to[i])
+	}
+
+	return nil
+}
+
+func syncPlanError(err error, safeMessage string) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("invalid sync plan: %w", err),
+		SafeMessage: safeMessage,
+		StatusCode:  http.StatusBadRequest,
+		Field:       "manifest",
+	})
+}
+
+func syncOpError(op SyncOp, reason string) error {
+	return syncPlanError(fmt.Errorf("operation %q: %s", op.ID, reason), fmt.Sprintf("Operation %q: %s", op.ID, reason))
+}
+
+// SyncStatus is the status of a applied SyncOp.
+type SyncStatus string
+
+const (
+	SyncDone   SyncStatus = "done"
+	SyncFailed SyncStatus = "failed"
+
+	// SyncSkipped is a operation that was not run because the SyncMkdir of its
+	// directory, or of a parent directory, did not succeed, or a SyncDelete of a
+	// directory that a SyncMove did not move a entry out of.
+	SyncSkipped SyncStatus = "skipped"
+)
+
+// SyncResult is the result of applying a SyncOp.
+type SyncResult struct {
+	Op     SyncOp
+	Status SyncStatus
+
+	// ID and Path are the ID and path of the directory or file that was created or
+	// moved, Path is the path it was moved to. The Path of a deleted directory or file
+	// is empty. They are empty if the operation did not succeed.
+	ID   string
+	Path string
+
+	Err error
+}
+
+// Msg returns the error of this SyncResult as a user friendly message. It is empty if
+// the operation succeeded.
+func (r SyncResult) Msg() string {
+	if r.Err == nil {
+		return ""
+	}
+
+	var wrapErr *app.WrappedSafeError
+	if errors.As(r.Err, &wrapErr) {
+		msg, _ := wrapErr.Safe()
+		return msg
+	}
+
+	return "Problem applying the operation"
+}
+
+// SyncService applies a SyncPlan through the DirService and FileService. It is a
+// composition layer, every operation is made with the same service methods as the
+// single directory and upload endpoints.
+type SyncService struct {
+	dirs        *DirService
+	files       *FileService
+	concurrency int
+}
+
+// NewSyncService creates a new SyncService that runs at most concurrency operations at
+// a time. If concurrency is less than 1, DefaultSyncConcurrency is used.
+func NewSyncService(dirs *DirService, files *FileService, concurrency int) *SyncService {
+	if dirs == nil || files == nil {
+		panic("cloudstore: NewSyncService requires a DirService and FileService")
+	}
+
+	if concurrency < 1 {
+		concurrency = DefaultSyncConcurrency
+	}
+
+	return &SyncService{dirs: dirs, files: files, concurrency: concurrency}
+}
+
+// Apply applies the plan for a user and returns the result of every operation, in
+// manifest order. progress, if not nil, is called with the number of operations
+// finished and failed so far after each step.
+//
+// Operations are applied in dependency order. The SyncMkdir operations are applied by
+// depth, so a directory is created after its parent, and the SyncUpload operations are
+// applied after every directory is created, batched by directory. Operations that do
+// not depend on each other run concurrently.
+//
+// The SyncMove and SyncDelete operations are applied last, with DirService.Move,
+// FileService.Move, DirService.Delete, and FileService.Delete. Their paths are resolved
+// once the directories and files are created, before any entry is moved, so a path
+// names the same entry even if a directory above it was moved. The moves are applied
+// one at a time in manifest order, then the deletes concurrently.
+//
+// A operation that fails does not stop the others. Operations under a directory whose
+// SyncMkdir did not succeed are SyncSkipped, and so is a SyncDelete of a directory that
+// a failed SyncMove would have moved a entry out of. Nothing is rolled back, a failed
+// apply may be submitted again without the operations that succeeded.
+func (s *SyncService) Apply(ctx context.Context, userID string, plan SyncPlan, progress func(finished int64, failed int64)) []SyncResult {
+	results := make([]SyncResult, len(plan.ops))
+	for i, op := range plan.ops {
+		results[i] = SyncResult{Op: op}
+	}
+
+	// failed are the keys of the directories whose SyncMkdir did not succeed.
+	failed := map[string]string{}
+	var mu sync.Mutex
+
+	report := func() {
+		if progress == nil {
+			return
+		}
+
+		var finished, failures int64
+		for _, r := range results {
+			if r.Status != "" {
+				finished++
+			}
+			if r.Status == SyncFailed || r.Status == SyncSkipped {
+				failures++
+			}
+		}
+		progress(finished, failures)
+	}
+
+	for _, level := range plan.levels {
+		s.each(level, func(i int) {
+			names := plan.dirs[i]
+
+			mu.Lock()
+			opID, skip := failedParent(failed, names[:len(names)-1])
+			mu.Unlock()
+
+			if skip {
+				results[i].Status = SyncSkipped
+				results[i].Err = skippedError(opID)
+			} else {
+				dir, err := s.dirs.newPathRelative(ctx, userID, names[len(names)-1], plan.baseID, dirKey(names[:len(names)-1]), CreatedImport)
+				if err != nil {
+					results[i].Status = SyncFailed
+					results[i].Err = err
+				} else {
+					results[i].Status = SyncDone
+					results[i].ID = dir.ID
+					results[i].Path = dir.Path
+				}
+			}
+
+			if results[i].Status != SyncDone {
+				mu.Lock()
+				failed[dirKey(names)] = plan.ops[i].ID
+				mu.Unlock()
+			}
+		})
+		report()
+	}
+
+	keys := []string{}
+	for key := range plan.uploads {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	groups := make([]int, len(keys))
+	for i := range groups {
+		groups[i] = i
+	}
+
+	s.each(groups, func(g int) {
+		key := keys[g]
+		idx := plan.uploads[key]
+
+		if opID, skip := failedParent(failed, plan.dirs[idx[0]]); skip {
+			for _, i := range idx {
+				results[i].Status = SyncSkipped
+				results[i].Err = skippedError(opID)
+			}
+			return
+		}
+
+		headers := []*multipart.FileHeader{}
+		mtimes := ClientModTimes{}
+		for _, i := range idx {
+			headers = append(headers, plan.headers[i])
+			if !plan.mtimes[i].IsZero() {
+				mtimes[plan.headers[i].Filename] = plan.mtimes[i]
+			}
+		}
+
+		batch, err := s.files.SaveBatchPathRelative(ctx, userID, plan.baseID, key, headers, mtimes)
+		for n, i := range idx {
+			switch {
+			case err != nil:
+				results[i].Status = SyncFailed
+				results[i].Err = err
+			case batch.Saves[n].Err != nil:
+				results[i].Status = SyncFailed
+				results[i].Err = batch.Saves[n].Err
+			default:
+				results[i].Status = SyncDone
+				results[i].ID = batch.Saves[n].ID
+				results[i].Path = batch.Saves[n].Path
+			}
+		}
+	})
+	report()
+
+	if len(plan.moves) == 0 && len(plan.deletes) == 0 {
+		return results
+	}
+
+	ids, targets := s.resolve(ctx, userID, plan, failed, results)
+
+	// unmoved are the IDs of the SyncMove operations that did not succeed, by the path
+	// of their entry.
+	unmoved := map[string]string{}
+	for _, i := range plan.moves {
+		if results[i].Status == "" {
+			var path string
+			var err error
+			if plan.types[i] == EntryDir {
+				var dir Dir
+				dir, err = s.dirs.Move(ctx, userID, ids[i], targets[i])
+				path = dir.Path
+			} else {
+				var file FileInfo
+				file, err = s.files.Move(ctx, userID, ids[i], targets[i])
+				path = file.Path
+			}
+
+			if err != nil {
+				results[i].Status = SyncFailed
+				results[i].Err = err
+			} else {
+				results[i].Status = SyncDone
+				results[i].ID = ids[i]
+				results[i].Path = path
+			}
+		}
+
+		if results[i].Status != SyncDone {
+			unmoved[dirKey(plan.dirs[i])] = plan.ops[i].ID
+		}
+	}
+	report()
+
+	s.each(plan.deletes, func(i int) {
+		if results[i].Status != "" {
+			return
+		}
+
+		deleted := dirKey(plan.dirs[i])
+		for key, opID := range unmoved {
+			if inPath(key, deleted) {
+				results[i].Status = SyncSkipped
+				results[i].Err = skippedError(opID)
+				return
+			}
+		}
+
+		var err error
+		if plan.types[i] == EntryDir {
+			err = s.dirs.Delete(ctx, userID, ids[i])
+		} else {
+			err = s.files.Delete(ctx, userID, ids[i])
+		}
+
+		if err != nil {
+			results[i].Status = SyncFailed
+			results[i].Err = err
+			return
+		}
+
+		results[i].Status = SyncDone
+		results[i].ID = ids[i]
+	})
+	report()
+
+	return results
+}
+
+// resolve returns the IDs of the entries of the SyncMove and SyncDelete operations of
+// the plan, and the IDs of the directories the SyncMove operations move them to, by
+// operation index.
+//
+// A operation whose path is under a directory whose SyncMkdir did not succeed is
+// SyncSkipped, and one whose entry or destination is not found is SyncFailed.
+func (s *SyncService) resolve(ctx context.Context, userID string, plan SyncPlan, failed map[string]string, results []SyncResult) ([]string, []string) {
+	ids := make([]string, len(plan.ops))
+	targets := make([]string, len(plan.ops))
+
+	idx := append(append([]int{}, plan.moves...), plan.deletes...)
+	root, err := s.dirs.ValidateUser(ctx, userID)
+	if err != nil {
+		for _, i := range idx {
+			results[i].Status = SyncFailed
+			results[i].Err = err
+		}
+
+		return ids, targets
+	}
+
+	s.each(idx, func(i int) {
+		opID, skip := failedParent(failed, plan.dirs[i])
+		if !skip && plan.ops[i].Kind == SyncMove {
+			opID, skip = failedParent(failed, plan.to[i])
+		}
+
+		if skip {
+			results[i].Status = SyncSkipped
+			results[i].Err = skippedError(opID)
+			return
+		}
+
+		id, err := s.findEntry(ctx, userID, root.ID, plan.baseID, plan.types[i], plan.dirs[i])
+		if err == nil && plan.ops[i].Kind == SyncMove {
+			targets[i], err = s.dirs.pathMap.FindDir(ctx, s.dirs.store, PathSearch{
+				UserID: userID,
+				RootID: root.ID,
+				Path:   dirKey(plan.to[i]),
+				BaseID: plan.baseID,
+			})
+		}
+
+		if err != nil {
+			results[i].Status = SyncFailed
+			results[i].Err = err
+			return
+		}
+
+		ids[i] = id
+	})
+
+	return ids, targets
+}
+
+// findEntry returns the ID of the directory or file of type t at the path names, under
+// the base directory baseID, or the users root directory (rootID) if empty.
+func (s *SyncService) findEntry(ctx context.Context, userID string, rootID string, baseID string, t EntryType, names []string) (string, error) {
+	search := PathSearch{UserID: userID, RootID: rootID, Path: dirKey(names), BaseID: baseID}
+	if t == EntryDir {
+		return s.dirs.pathMap.FindDir(ctx, s.dirs.store, search)
+	}
+
+	name := names[len(names)-1]
+	search.Path = dirKey(names[:len(names)-1])
+	dirID, err := s.dirs.pathMap.FindDir(ctx, s.dirs.store, search)
+	if err != nil {
+		return "", err
+	}
+
+	row, err := s.files.store.SelectFileByUserDirName(ctx, userID, dirID, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("file %q does not exist [path: %s]: %w", name, search.Path, err),
+				SafeMessage: fmt.Sprintf("File '%s' does not exist", dirKey(names)),
+				StatusCode:  http.StatusNotFound,
+				Field:       "path",
+			})
+		}
+
+		return "", err
+	}
+
+	return row.ID, nil
+}
+
+// each calls fn with every index in idx, running at most s.concurrency at a time, and
+// waits for them to return.
+func (s *SyncService) each(idx []int, fn func(i int)) {
+	sem := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+
+	for _, i := range idx {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// failedParent returns the ID of the SyncMkdir operation that did not succeed for the
+// directory with the path names, or for any of its parents.
+func failedParent(failed map[string]string, names []string) (string, bool) {
+	for n := len(names); n > 0; n-- {
+		if opID, ok := failed[dirKey(names[:n])]; ok {
+			return opID, true
+		}
+	}
+
+	return "", false
+}
+
+func skippedError(opID string) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("operation %q did not succeed", opID),
+		SafeMessage: fmt.Sprintf("Skipped because operation %q did not succeed", opID),
+		StatusCode:  http.StatusFailedDependency,
+	})
+}
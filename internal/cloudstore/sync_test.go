@@ -0,0 +1,235 @@
+package cloudstore
+
+import (
+	"context"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseSyncPlan(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  string
+
+		// err is in the error, the plan is valid if it is empty.
+		err string
+	}{
+		{
+			name: "mixed",
+			ops: `{"id": "1", "op": "mkdir", "path": "archive"},
+				{"id": "2", "op": "upload", "path": "archive", "part": "new"},
+				{"id": "3", "op": "move", "path": "photos/b.txt", "type": "file", "to": "archive"},
+				{"id": "4", "op": "move", "path": "docs/target", "type": "directory", "to": "archive"},
+				{"id": "5", "op": "delete", "path": "docs", "type": "directory"}`,
+		},
+		{
+			name: "move out then under",
+			ops: `{"id": "1", "op": "move", "path": "a/b", "type": "directory", "to": ""},
+				{"id": "2", "op": "move", "path": "a", "type": "directory", "to": "b"}`,
+		},
+		{
+			name: "delete without path",
+			ops:  `{"id": "1", "op": "delete", "path": "/", "type": "directory"}`,
+			err:  "delete needs a path",
+		},
+		{
+			name: "move without type",
+			ops:  `{"id": "1", "op": "move", "path": "a", "to": "b"}`,
+			err:  "type must be one of",
+		},
+		{
+			name: "moved twice",
+			ops: `{"id": "1", "op": "move", "path": "a", "type": "directory", "to": "b"},
+				{"id": "2", "op": "delete", "path": "/a/", "type": "directory"}`,
+			err: `path "a" is deleted or moved twice`,
+		},
+		{
+			name: "delete under delete",
+			ops: `{"id": "1", "op": "delete", "path": "a/b.txt", "type": "file"},
+				{"id": "2", "op": "delete", "path": "a", "type": "directory"}`,
+			err: `operation "1": path "a/b.txt" is in "a"`,
+		},
+		{
+			name: "upload in delete",
+			ops: `{"id": "1", "op": "upload", "path": "a", "part": "new"},
+				{"id": "2", "op": "delete", "path": "a/new.txt", "type": "file"}`,
+			err: `operation "1": path "a/new.txt" is in "a/new.txt"`,
+		},
+		{
+			name: "mkdir in delete",
+			ops: `{"id": "1", "op": "delete", "path": "a", "type": "directory"},
+				{"id": "2", "op": "mkdir", "path": "a/b"}`,
+			err: `operation "2": path "a/b" is in "a"`,
+		},
+		{
+			name: "move to delete",
+			ops: `{"id": "1", "op": "move", "path": "b", "type": "directory", "to": "a/c"},
+				{"id": "2", "op": "delete", "path": "a", "type": "directory"}`,
+			err: `operation "1": path "a/c" is in "a"`,
+		},
+		{
+			name: "move under itself",
+			ops:  `{"id": "1", "op": "move", "path": "a", "type": "directory", "to": "a/b"}`,
+			err:  `directory "a" cannot be moved under itself`,
+		},
+		{
+			name: "move cycle",
+			ops: `{"id": "1", "op": "move", "path": "a", "type": "directory", "to": "b"},
+				{"id": "2", "op": "move", "path": "b", "type": "directory", "to": "a/c"}`,
+			err: `operation "2": directory "b" cannot be moved under itself`,
+		},
+		{
+			name: "move to above base",
+			ops:  `{"id": "1", "op": "move", "path": "a", "type": "directory", "to": "../b"}`,
+			err:  "to cannot go above the base directory",
+		},
+		{
+			name: "unknown",
+			ops:  `{"id": "1", "op": "copy", "path": "a"}`,
+			err:  "must be one of: mkdir, upload, delete, move",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parts := map[string][]*multipart.FileHeader{"new": {newTestFileHeader(t, "new.txt", "hello")}}
+			plan, err := ParseSyncPlan(`{"operations": [`+tc.ops+`]}`, parts)
+
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("ParseSyncPlan() error = %v", err)
+				}
+				return
+			}
+
+			assertSafeError(t, err, http.StatusBadRequest, nil)
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("ParseSyncPlan() error = %v, want %q", err, tc.err)
+			}
+
+			if plan.Len() != 0 {
+				t.Errorf("ParseSyncPlan() = %d operations, want none", plan.Len())
+			}
+		})
+	}
+}
+
+// newTestSyncService creates a SyncService on f with the moveTree of the bulk move
+// tests.
+func newTestSyncService(t *testing.T, f *fakeStorage) (*SyncService, moveTree) {
+	t.Helper()
+
+	moves, root := newTestMoveService(t, f)
+	return NewSyncService(moves.dirs, moves.files, 2), newMoveTree(t, f, root)
+}
+
+// applySync parses the operations ops and applies them for the user of tree.
+func applySync(t *testing.T, s *SyncService, tree moveTree, ops string) []SyncResult {
+	t.Helper()
+
+	parts := map[string][]*multipart.FileHeader{"new": {newTestFileHeader(t, "new.txt", "hello")}}
+	plan, err := ParseSyncPlan(`{"operations": [`+ops+`]}`, parts)
+	if err != nil {
+		t.Fatalf("ParseSyncPlan() error = %v", err)
+	}
+
+	return s.Apply(context.Background(), tree.root.UserID, plan, nil)
+}
+
+// assertSync fails t if the statuses and paths of the results are not want, by
+// operation ID.
+func assertSync(t *testing.T, results []SyncResult, want map[string][2]string) {
+	t.Helper()
+
+	for _, r := range results {
+		w := want[r.Op.ID]
+		if string(r.Status) != w[0] || r.Path != w[1] {
+			t.Errorf("operation %s = %s %q (%v), want %s %q", r.Op.ID, r.Status, r.Path, r.Err, w[0], w[1])
+		}
+	}
+}
+
+func TestSyncServiceApplyMixed(t *testing.T) {
+	f := newFakeStorage(t)
+	s, tree := newTestSyncService(t, f)
+
+	results := applySync(t, s, tree, `
+		{"id": "mkdir", "op": "mkdir", "path": "archive"},
+		{"id": "upload", "op": "upload", "path": "archive", "part": "new"},
+		{"id": "move-file", "op": "move", "path": "photos/b.txt", "type": "file", "to": "archive"},
+		{"id": "move-dir", "op": "move", "path": "docs/target", "type": "directory", "to": "archive"},
+		{"id": "delete-file", "op": "delete", "path": "a.txt", "type": "file"},
+		{"id": "delete-dir", "op": "delete", "path": "docs", "type": "directory"}`)
+
+	assertSync(t, results, map[string][2]string{
+		"mkdir":       {"done", "/archive"},
+		"upload":      {"done", "/archive/new.txt"},
+		"move-file":   {"done", "/archive/b.txt"},
+		"move-dir":    {"done", "/archive/target"},
+		"delete-file": {"done", ""},
+		"delete-dir":  {"done", ""},
+	})
+
+	// The target directory was moved out of docs before docs was deleted.
+	data := f.data()
+	if _, ok := data.dirs[tree.docs.ID]; ok {
+		t.Error("docs not deleted")
+	}
+
+	if _, ok := data.dirs[tree.target.ID]; !ok {
+		t.Error("target deleted with docs")
+	}
+
+	if _, ok := data.files[tree.a.ID]; ok {
+		t.Error("a.txt not deleted")
+	}
+
+	if got := data.files[tree.b.ID].DirectoryID; got != results[0].ID {
+		t.Errorf("b.txt in %s, want it in archive %s", got, results[0].ID)
+	}
+}
+
+func TestSyncServiceApplyFailedMove(t *testing.T) {
+	f := newFakeStorage(t)
+	s, tree := newTestSyncService(t, f)
+
+	// The file is not found, so docs is not deleted, it would delete the file the move
+	// meant to keep.
+	results := applySync(t, s, tree, `
+		{"id": "move", "op": "move", "path": "docs/missing.txt", "type": "file", "to": ""},
+		{"id": "delete", "op": "delete", "path": "docs", "type": "directory"},
+		{"id": "delete-file", "op": "delete", "path": "photos/b.txt", "type": "file"}`)
+
+	assertSync(t, results, map[string][2]string{
+		"move":        {"failed", ""},
+		"delete":      {"skipped", ""},
+		"delete-file": {"done", ""},
+	})
+	assertSafeError(t, results[0].Err, http.StatusNotFound, nil)
+	assertSafeError(t, results[1].Err, http.StatusFailedDependency, nil)
+
+	if _, ok := f.data().dirs[tree.docs.ID]; !ok {
+		t.Error("docs deleted after the move out of it failed")
+	}
+}
+
+func TestSyncServiceApplySkippedMkdir(t *testing.T) {
+	f := newFakeStorage(t)
+	s, tree := newTestSyncService(t, f)
+
+	// The directory already exists, so the file is not moved into it.
+	results := applySync(t, s, tree, `
+		{"id": "mkdir", "op": "mkdir", "path": "photos"},
+		{"id": "move", "op": "move", "path": "a.txt", "type": "file", "to": "photos"}`)
+
+	assertSync(t, results, map[string][2]string{
+		"mkdir": {"failed", ""},
+		"move":  {"skipped", ""},
+	})
+
+	if got := f.data().files[tree.a.ID].DirectoryID; got != tree.root.ID {
+		t.Errorf("a.txt in %s, want it not moved", got)
+	}
+}
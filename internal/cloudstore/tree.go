@@ -0,0 +1,149 @@
+package cloudstore
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// treeMetrics is the shape of the directory trees of every user, as of the last
+// TreeReport. It is published with expvar as "cloudstore_tree".
+var treeMetrics = expvar.NewMap("cloudstore_tree")
+
+// TreeReportInterval is how often the API computes the TreeReport to publish the
+// "cloudstore_tree" metrics.
+const TreeReportInterval = time.Hour
+
+// The number of users in a TreeReport.
+const (
+	DefaultTreeReportLimit = 20
+	MaxTreeReportLimit     = 1000
+)
+
+// TreeThresholds are the limits above which the directory tree of a user is flagged
+// by TreeReport.
+type TreeThresholds struct {
+	// Depth is the depth of the deepest directory below the root directory.
+	Depth int
+
+	// ClosureRows is the number of rows in the paths table of the directories of the
+	// user. Every directory has a row for itself and for each of its ancestors, so it
+	// grows with the depth of the tree, not only its size.
+	ClosureRows int64
+}
+
+// DefaultTreeThresholds are the TreeThresholds used when a threshold is not set.
+var DefaultTreeThresholds = TreeThresholds{Depth: 32, ClosureRows: 100_000}
+
+// UserTree is the shape of the directory tree of a user.
+type UserTree struct {
+	UserID      string
+	Directories int64
+	MaxDepth    int
+	AvgDepth    float64
+	ClosureRows int64
+
+	// Flagged is true if MaxDepth or ClosureRows is above its threshold.
+	Flagged bool
+}
+
+// TreeReport is the shape of the directory trees of every user.
+type TreeReport struct {
+	Thresholds TreeThresholds
+
+	// Users are the users with the most closure rows, most first.
+	Users []UserTree
+
+	// The totals of every user, not only Users.
+	TotalUsers   int
+	FlaggedUsers int
+	Directories  int64
+	MaxDepth     int
+	ClosureRows  int64
+}
+
+// TreeReport computes the shape of the directory tree of every user with a single
+// aggregate query, and flags the users above the thresholds of the DirService. Users
+// has at most limit users, if limit is greater than zero. The totals are published
+// with expvar as "cloudstore_tree".
+//
+// It is meant for capacity planning, the query reads the whole paths table.
+func (s *DirService) TreeReport(ctx context.Context, limit int) (TreeReport, error) {
+	rows, err := s.store.SelectUserTrees(ctx)
+	if err != nil {
+		return TreeReport{}, fmt.Errorf("selecting user trees: %w", err)
+	}
+
+	report := TreeReport{Thresholds: s.treeThresholds, Users: []UserTree{}, TotalUsers: len(rows)}
+	for _, row := range rows {
+		tree := UserTree{
+			UserID:      row.UserID,
+			Directories: row.Directories,
+			MaxDepth:    row.MaxDepth,
+			AvgDepth:    row.AvgDepth,
+			ClosureRows: row.ClosureRows,
+			Flagged:     row.MaxDepth > s.treeThresholds.Depth || row.ClosureRows > s.treeThresholds.ClosureRows,
+		}
+
+		if tree.Flagged {
+			report.FlaggedUsers++
+		}
+		report.Directories += tree.Directories
+		report.ClosureRows += tree.ClosureRows
+		report.MaxDepth = max(report.MaxDepth, tree.MaxDepth)
+
+		report.Users = append(report.Users, tree)
+	}
+
+	sort.SliceStable(report.Users, func(i, j int) bool {
+		return report.Users[i].ClosureRows > report.Users[j].ClosureRows
+	})
+
+	if limit > 0 && len(report.Users) > limit {
+		report.Users = report.Users[:limit]
+	}
+
+	setMetric(treeMetrics, "users", int64(report.TotalUsers))
+	setMetric(treeMetrics, "flagged_users", int64(report.FlaggedUsers))
+	setMetric(treeMetrics, "directories", report.Directories)
+	setMetric(treeMetrics, "max_depth", int64(report.MaxDepth))
+	setMetric(treeMetrics, "closure_rows", report.ClosureRows)
+
+	return report, nil
+}
+
+// ParseTreeReportLimit parses the "limit" query parameter. If it is not set,
+// DefaultTreeReportLimit is returned. If it is not a whole number between 1 and
+// MaxTreeReportLimit, a 400 app.WrappedSafeError is returned.
+func ParseTreeReportLimit(q url.Values) (int, error) {
+	v := q.Get("limit")
+	if v == "" {
+		return DefaultTreeReportLimit, nil
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > MaxTreeReportLimit {
+		return 0, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid limit: %q", v),
+			SafeMessage: fmt.Sprintf("Limit must be a whole number between 1 and %d", MaxTreeReportLimit),
+			StatusCode:  http.StatusBadRequest,
+			Field:       "limit",
+		})
+	}
+
+	return limit, nil
+}
+
+// setMetric sets the key of m to v.
+func setMetric(m *expvar.Map, key string, v int64) {
+	i := new(expvar.Int)
+	i.Set(v)
+	m.Set(key, i)
+}
@@ -0,0 +1,139 @@
+package cloudstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+)
+
+// seedChain adds a chain of n directories under the root directory of a new user to
+// f, each one under the last. The root directory is returned.
+func seedChain(t *testing.T, f *fakeStorage, root string, n int) DirectoryRow {
+	t.Helper()
+
+	userRoot := addTestRoot(t, f, root)
+	parent := userRoot
+	for i := 0; i < n; i++ {
+		parent = addTestDir(t, f, root, parent, fmt.Sprintf("d%d", i))
+	}
+
+	return userRoot
+}
+
+// seedWide adds n directories directly under the root directory of a new user to f.
+// The root directory is returned.
+func seedWide(t *testing.T, f *fakeStorage, root string, n int) DirectoryRow {
+	t.Helper()
+
+	userRoot := addTestRoot(t, f, root)
+	for i := 0; i < n; i++ {
+		addTestDir(t, f, root, userRoot, fmt.Sprintf("d%d", i))
+	}
+
+	return userRoot
+}
+
+func TestDirServiceTreeReport(t *testing.T) {
+	f := newFakeStorage(t)
+	root := t.TempDir()
+	s := NewDirService(DirServiceConfig{
+		Store:          f,
+		PathMap:        NewPathMapper(root),
+		Log:            log.New(io.Discard, "", 0),
+		TreeThresholds: TreeThresholds{Depth: 4, ClosureRows: 20},
+	})
+
+	// deep is 5 directories deep: depths 0 to 5, 21 closure rows.
+	deep := seedChain(t, f, root, 5)
+
+	// wide has 10 directories at depth 1: 21 closure rows over 11 directories.
+	wide := seedWide(t, f, root, 10)
+
+	// small is not flagged: depths 0 to 2, 6 closure rows.
+	small := seedChain(t, f, root, 2)
+
+	report, err := s.TreeReport(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("TreeReport() error = %v", err)
+	}
+
+	want := map[string]UserTree{
+		deep.UserID:  {UserID: deep.UserID, Directories: 6, MaxDepth: 5, AvgDepth: 2.5, ClosureRows: 21, Flagged: true},
+		wide.UserID:  {UserID: wide.UserID, Directories: 11, MaxDepth: 1, AvgDepth: 10.0 / 11, ClosureRows: 21, Flagged: true},
+		small.UserID: {UserID: small.UserID, Directories: 3, MaxDepth: 2, AvgDepth: 1, ClosureRows: 6},
+	}
+
+	if len(report.Users) != len(want) {
+		t.Fatalf("Users = %+v, want %d users", report.Users, len(want))
+	}
+
+	for _, u := range report.Users {
+		if u != want[u.UserID] {
+			t.Errorf("user tree = %+v, want %+v", u, want[u.UserID])
+		}
+	}
+
+	// The users are ordered by closure rows, the two tied users first.
+	if report.Users[2].UserID != small.UserID {
+		t.Errorf("last user = %s, want %s with the fewest closure rows", report.Users[2].UserID, small.UserID)
+	}
+
+	if report.TotalUsers != 3 || report.FlaggedUsers != 2 || report.Directories != 20 || report.MaxDepth != 5 || report.ClosureRows != 48 {
+		t.Errorf("totals = %d users, %d flagged, %d directories, depth %d, %d closure rows, want 3, 2, 20, 5, 48",
+			report.TotalUsers, report.FlaggedUsers, report.Directories, report.MaxDepth, report.ClosureRows)
+	}
+
+	if got := treeMetrics.Get("closure_rows").String(); got != "48" {
+		t.Errorf("closure_rows metric = %s, want 48", got)
+	}
+
+	// The limit only cuts the users, not the totals.
+	limited, err := s.TreeReport(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("TreeReport() error = %v", err)
+	}
+
+	if len(limited.Users) != 1 || limited.Users[0].UserID == small.UserID || limited.TotalUsers != 3 {
+		t.Errorf("limited report = %+v, want one of the two largest users of 3", limited)
+	}
+}
+
+func TestDirServiceTreeReportDefaults(t *testing.T) {
+	f := newFakeStorage(t)
+	s, root := newTestDirService(t, f)
+
+	// A chain as deep as the default threshold is not flagged, one deeper is.
+	at := seedChain(t, f, root, DefaultTreeThresholds.Depth)
+	above := seedChain(t, f, root, DefaultTreeThresholds.Depth+1)
+
+	report, err := s.TreeReport(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("TreeReport() error = %v", err)
+	}
+
+	flagged := map[string]bool{}
+	for _, u := range report.Users {
+		flagged[u.UserID] = u.Flagged
+	}
+
+	if flagged[at.UserID] || !flagged[above.UserID] {
+		t.Errorf("flagged = %v, want only %s", flagged, above.UserID)
+	}
+
+	if report.Thresholds != DefaultTreeThresholds {
+		t.Errorf("Thresholds = %+v, want %+v", report.Thresholds, DefaultTreeThresholds)
+	}
+}
+
+func TestDirServiceTreeReportError(t *testing.T) {
+	f := newFakeStorage(t)
+	s, _ := newTestDirService(t, f)
+	f.fail("SelectUserTrees", errors.New("connection reset"))
+
+	if _, err := s.TreeReport(context.Background(), 0); err == nil {
+		t.Error("TreeReport() error = nil, want the query error")
+	}
+}
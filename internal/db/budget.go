@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// statementCounterKey is the context key of the statement counter of a request.
+type statementCounterKey struct{}
+
+// WithStatementCounter returns ctx with a new statement counter. Every statement executed by Postgres or Tx with
+// the returned context, or a context derived from it, is counted. If ctx already has a counter, ctx is returned so
+// the statements are counted once.
+func WithStatementCounter(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(statementCounterKey{}).(*atomic.Int64); ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, statementCounterKey{}, new(atomic.Int64))
+}
+
+// StatementCount returns the number of statements counted in ctx. If ctx has no counter, ok is false.
+func StatementCount(ctx context.Context) (n int64, ok bool) {
+	c, ok := ctx.Value(statementCounterKey{}).(*atomic.Int64)
+	if !ok {
+		return 0, false
+	}
+
+	return c.Load(), true
+}
+
+// countStatement increments the statement counter of ctx, if it has one.
+func countStatement(ctx context.Context) {
+	if c, ok := ctx.Value(statementCounterKey{}).(*atomic.Int64); ok {
+		c.Add(1)
+	}
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+func TestStatementCounter(t *testing.T) {
+	if n, ok := StatementCount(context.Background()); ok || n != 0 {
+		t.Errorf("StatementCount() without a counter = %d, %t, want 0, false", n, ok)
+	}
+
+	// Counting without a counter does nothing.
+	countStatement(context.Background())
+
+	ctx := WithStatementCounter(context.Background())
+	if n, ok := StatementCount(ctx); !ok || n != 0 {
+		t.Errorf("StatementCount() of a new counter = %d, %t, want 0, true", n, ok)
+	}
+
+	// Statements of a derived context are counted, and a counter already in the context
+	// is kept.
+	child, cancel := context.WithCancel(WithStatementCounter(ctx))
+	defer cancel()
+	countStatement(ctx)
+	countStatement(child)
+
+	if n, _ := StatementCount(ctx); n != 2 {
+		t.Errorf("StatementCount() = %d, want 2", n)
+	}
+
+	// Each counter counts on its own.
+	other := WithStatementCounter(context.Background())
+	countStatement(other)
+	if n, _ := StatementCount(ctx); n != 2 {
+		t.Errorf("StatementCount() after counting another counter = %d, want 2", n)
+	}
+}
+
+func TestStatementCounterConcurrent(t *testing.T) {
+	ctx := WithStatementCounter(context.Background())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				countStatement(ctx)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, _ := StatementCount(ctx); n != 800 {
+		t.Errorf("StatementCount() = %d, want 800", n)
+	}
+}
+
+func TestPostgresCountsStatements(t *testing.T) {
+	// Nothing listens on the port, every statement fails but is still counted.
+	p := &Postgres{}
+	if err := p.Open("127.0.0.1", "1", "clox", "clox", "clox"); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer p.Close()
+
+	ctx := WithStatementCounter(context.Background())
+	p.Exec(ctx, `SELECT 1`)
+	p.QueryRow(ctx, `SELECT 1`).Scan(new(int))
+	if rows, err := p.Query(ctx, `SELECT 1`); err == nil {
+		rows.Close()
+	}
+
+	if n, _ := StatementCount(ctx); n != 3 {
+		t.Errorf("StatementCount() = %d, want 3", n)
+	}
+}
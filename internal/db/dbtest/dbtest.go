@@ -0,0 +1,70 @@
+// Package dbtest has helpers for tests that use the database.
+package dbtest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/cicconee/clox/internal/db"
+	_ "github.com/lib/pq"
+)
+
+// AssertMaxQueries calls fn with a context that counts the statements executed with it, and fails t if more than
+// n statements were executed. Pass the context to the code under test, statements executed with any other context
+// are not counted.
+func AssertMaxQueries(t testing.TB, n int, fn func(ctx context.Context)) {
+	t.Helper()
+
+	ctx := db.WithStatementCounter(context.Background())
+	fn(ctx)
+
+	if count, _ := db.StatementCount(ctx); count > int64(n) {
+		t.Errorf("executed %d database statements, want at most %d", count, n)
+	}
+}
+
+// Open opens the test database. It is configured by the TEST_POSTGRES_HOST, TEST_POSTGRES_PORT,
+// TEST_POSTGRES_USERNAME, TEST_POSTGRES_PASSWORD, and TEST_POSTGRES_DBNAME environment variables, the same as the
+// POSTGRES_ variables of the server. The migrations must already be applied to it.
+//
+// If TEST_POSTGRES_HOST is not set, t is skipped. The connection is closed when t finishes.
+func Open(t testing.TB) *db.Postgres {
+	t.Helper()
+
+	host := os.Getenv("TEST_POSTGRES_HOST")
+	if host == "" {
+		t.Skip("TEST_POSTGRES_HOST is not set")
+	}
+
+	p := &db.Postgres{}
+	err := p.Open(host,
+		os.Getenv("TEST_POSTGRES_PORT"),
+		os.Getenv("TEST_POSTGRES_USERNAME"),
+		os.Getenv("TEST_POSTGRES_PASSWORD"),
+		os.Getenv("TEST_POSTGRES_DBNAME"))
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+
+	if err := p.Ping(); err != nil {
+		t.Fatalf("pinging test database: %v", err)
+	}
+
+	return p
+}
+
+// Tx opens the test database, see Open, and begins a transaction on it. The transaction is rolled back when t
+// finishes, so nothing written with it is kept.
+func Tx(t testing.TB) *db.Tx {
+	t.Helper()
+
+	tx, err := Open(t).Tx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("beginning transaction: %v", err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+
+	return tx
+}
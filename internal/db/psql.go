@@ -36,16 +36,19 @@ func (p *Postgres) Ping() error {
 
 // QueryRow queries a single row in the Postgres database. Open must be called before calling this function.
 func (p *Postgres) QueryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	countStatement(ctx)
 	return p.conn.QueryRowContext(ctx, query, args...)
 }
 
 // Query executes a query in the Postgres database. Open must be called before calling this function.
 func (p *Postgres) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	countStatement(ctx)
 	return p.conn.QueryContext(ctx, query, args...)
 }
 
 // Exec executes a statement in the Postgres database. Open must be called before calling this function.
 func (p *Postgres) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	countStatement(ctx)
 	return p.conn.ExecContext(ctx, query, args...)
 }
 
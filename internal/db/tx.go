@@ -15,16 +15,19 @@ func NewTx(tx *sql.Tx) *Tx {
 
 // QueryRow queries a single row in this Tx.
 func (tx *Tx) QueryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	countStatement(ctx)
 	return tx.conn.QueryRowContext(ctx, query, args...)
 }
 
 // Query executes a query in this Tx.
 func (tx *Tx) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	countStatement(ctx)
 	return tx.conn.QueryContext(ctx, query, args...)
 }
 
 // Exec executes a statement in this Tx.
 func (tx *Tx) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	countStatement(ctx)
 	return tx.conn.ExecContext(ctx, query, args...)
 }
 
@@ -0,0 +1,68 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Handler handles a published event. If a Handler returns an error, the event is published
+// again later, possibly to Handlers that already handled it.
+type Handler func(ctx context.Context, e Event) error
+
+// Publisher publishes events.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Bus is a in-process Publisher. Every event published to the Bus is passed to all of its
+// subscribed Handlers.
+//
+// Bus should be created using the NewBus function.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[int]Handler
+	next     int
+}
+
+// NewBus creates a new Bus.
+func NewBus() *Bus {
+	return &Bus{handlers: map[int]Handler{}}
+}
+
+// Subscribe adds h to the Handlers of b. The returned function removes it.
+func (b *Bus) Subscribe(h Handler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+	b.handlers[id] = h
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.handlers, id)
+	}
+}
+
+// Publish passes e to every subscribed Handler. All Handlers are called, the errors of
+// the Handlers that failed are returned joined.
+func (b *Bus) Publish(ctx context.Context, e Event) error {
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, h := range handlers {
+		if err := h(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
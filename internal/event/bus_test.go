@@ -0,0 +1,56 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBusPublish(t *testing.T) {
+	b := NewBus()
+
+	var got []string
+	errFailed := errors.New("failed")
+	b.Subscribe(func(ctx context.Context, e Event) error {
+		got = append(got, "failing:"+e.ID)
+		return errFailed
+	})
+	unsubscribe := b.Subscribe(func(ctx context.Context, e Event) error {
+		got = append(got, "removed:"+e.ID)
+		return nil
+	})
+	b.Subscribe(func(ctx context.Context, e Event) error {
+		got = append(got, "ok:"+e.ID)
+		return nil
+	})
+
+	// Every Handler is called even if one fails.
+	err := b.Publish(context.Background(), Event{ID: "1"})
+	if !errors.Is(err, errFailed) {
+		t.Errorf("Publish() error = %v, want %v", err, errFailed)
+	}
+
+	if len(got) != 3 {
+		t.Errorf("handled = %v, want every Handler called", got)
+	}
+
+	got = nil
+	unsubscribe()
+	b.Publish(context.Background(), Event{ID: "2"})
+
+	for _, h := range got {
+		if h == "removed:2" {
+			t.Errorf("handled = %v, want the unsubscribed Handler not called", got)
+		}
+	}
+
+	if len(got) != 2 {
+		t.Errorf("handled = %v, want 2 Handlers called", got)
+	}
+}
+
+func TestBusPublishNoHandlers(t *testing.T) {
+	if err := NewBus().Publish(context.Background(), Event{ID: "1"}); err != nil {
+		t.Errorf("Publish() error = %v", err)
+	}
+}
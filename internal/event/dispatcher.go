@@ -0,0 +1,180 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The default Dispatcher configuration.
+const (
+	DefaultInterval   = time.Second
+	DefaultBatchSize  = 100
+	DefaultBackoff    = time.Second
+	DefaultMaxBackoff = 5 * time.Minute
+)
+
+// Dispatcher publishes the events in the outbox.
+//
+// Events are published in increasing Seq order. If publishing a event fails, it is retried
+// with backoff, and the later events of the same user wait for it. An event is marked as
+// published after it is published, so if the process stops between the two, the event is
+// published again when the Dispatcher next runs.
+//
+// Dispatcher should be created using the NewDispatcher function.
+type Dispatcher struct {
+	repo       *Repo
+	publisher  Publisher
+	log        *log.Logger
+	interval   time.Duration
+	batchSize  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// DispatcherConfig is the Dispatcher configuration.
+type DispatcherConfig struct {
+	Repo      *Repo
+	Publisher Publisher
+	Log       *log.Logger
+
+	// Interval is the time between reading the outbox.
+	Interval time.Duration
+
+	// BatchSize is the maximum number of events read from the outbox at a time.
+	BatchSize int
+
+	// Backoff is the time before the first retry of a event. It doubles after every
+	// failed attempt, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// NewDispatcher creates a new Dispatcher.
+//
+// Repo and Publisher must be set otherwise it will panic.
+//
+// If Log is not set, it will default to log.Default(). All other fields that are not set
+// will default to their Default value.
+func NewDispatcher(c DispatcherConfig) *Dispatcher {
+	if c.Repo == nil {
+		panic("event.NewDispatcher: cannot create Dispatcher with nil Repo")
+	}
+
+	if c.Publisher == nil {
+		panic("event.NewDispatcher: cannot create Dispatcher with nil Publisher")
+	}
+
+	if c.Log == nil {
+		c.Log = log.Default()
+	}
+
+	if c.Interval <= 0 {
+		c.Interval = DefaultInterval
+	}
+
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+
+	if c.Backoff <= 0 {
+		c.Backoff = DefaultBackoff
+	}
+
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+
+	return &Dispatcher{
+		repo:       c.Repo,
+		publisher:  c.Publisher,
+		log:        c.Log,
+		interval:   c.Interval,
+		batchSize:  c.BatchSize,
+		backoff:    c.Backoff,
+		maxBackoff: c.MaxBackoff,
+	}
+}
+
+// Run dispatches the outbox every interval until ctx is done. Errors are logged.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			published, err := d.Dispatch(ctx)
+			if err != nil {
+				d.log.Printf("[ERROR] Dispatching events: %v\n", err)
+				break
+			}
+
+			// A full batch means there may be more events waiting.
+			if published < d.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch publishes one batch of the events that are due. The number of events published
+// is returned.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	now := time.Now()
+
+	pending, err := d.repo.SelectPending(ctx, now, d.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("selecting pending events: %w", err)
+	}
+
+	published := 0
+	blocked := map[string]bool{}
+	for _, p := range pending {
+		// Keep the events of a user in order, nothing after a failed event is published.
+		if blocked[p.UserID] {
+			continue
+		}
+
+		if err := d.publisher.Publish(ctx, p.Event); err != nil {
+			blocked[p.UserID] = true
+
+			next := now.Add(d.delay(p.Attempts + 1))
+			d.log.Printf("[ERROR] Publishing event [id: %s, type: %s, attempts: %d, retry: %s]: %v\n", p.ID, p.Type, p.Attempts+1, next.Format(time.RFC3339), err)
+
+			if err := d.repo.UpdateFailed(ctx, p.Seq, next, err); err != nil {
+				return published, fmt.Errorf("recording failed event [id: %s]: %w", p.ID, err)
+			}
+
+			continue
+		}
+
+		if err := d.repo.UpdatePublished(ctx, p.Seq, time.Now()); err != nil {
+			return published, fmt.Errorf("marking event published [id: %s]: %w", p.ID, err)
+		}
+
+		published++
+	}
+
+	return published, nil
+}
+
+// delay returns the backoff after attempts failed attempts.
+func (d *Dispatcher) delay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > d.maxBackoff {
+		return d.maxBackoff
+	}
+
+	return delay
+}
@@ -0,0 +1,162 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+// publisherFunc is a Publisher that calls itself.
+type publisherFunc func(ctx context.Context, e Event) error
+
+func (f publisherFunc) Publish(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+func TestDispatcherDelay(t *testing.T) {
+	d := NewDispatcher(DispatcherConfig{
+		Repo:       NewRepo(nil),
+		Publisher:  NewBus(),
+		Backoff:    time.Second,
+		MaxBackoff: 10 * time.Second,
+	})
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 3, want: 4 * time.Second},
+		{attempts: 4, want: 8 * time.Second},
+		{attempts: 5, want: 10 * time.Second},
+		{attempts: 100, want: 10 * time.Second},
+	}
+
+	for _, tc := range tests {
+		if got := d.delay(tc.attempts); got != tc.want {
+			t.Errorf("delay(%d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestDispatcherDispatch(t *testing.T) {
+	ctx := context.Background()
+	tx := dbtest.Tx(t)
+	repo := NewRepo(tx)
+
+	// The ids of users a and b, and the names of the ids.
+	ids := map[string]string{}
+	names := map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		id := uuid.NewString()
+		_, err := tx.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+			id, id+"@example.com")
+		if err != nil {
+			t.Fatalf("inserting user: %v", err)
+		}
+		ids[name] = id
+		names[id] = name
+	}
+
+	for _, e := range []struct{ user, name string }{{"a", "a1"}, {"b", "b1"}, {"a", "a2"}, {"b", "b2"}} {
+		if err := repo.Insert(ctx, ids[e.user], TypeDirCreated, DirCreated{Name: e.name}); err != nil {
+			t.Fatalf("inserting event: %v", err)
+		}
+	}
+
+	// The first event of user a fails once.
+	var published []string
+	failed := false
+	publisher := publisherFunc(func(ctx context.Context, e Event) error {
+		name, ok := names[e.UserID]
+		if !ok {
+			// A event of another test.
+			return nil
+		}
+
+		var p struct{ Name string }
+		if err := json.Unmarshal(e.Payload, &p); err != nil {
+			t.Fatalf("decoding payload: %v", err)
+		}
+
+		if p.Name == "a1" && !failed {
+			failed = true
+			return errors.New("subscriber unavailable")
+		}
+
+		published = append(published, name+":"+p.Name)
+		return nil
+	})
+
+	d := NewDispatcher(DispatcherConfig{
+		Repo:      repo,
+		Publisher: publisher,
+		Log:       log.New(io.Discard, "", 0),
+		Backoff:   time.Hour,
+	})
+
+	if _, err := d.Dispatch(ctx); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	// The events of user a wait for the failed event, user b is not blocked.
+	if want := []string{"b:b1", "b:b2"}; !reflect.DeepEqual(published, want) {
+		t.Errorf("published = %v, want %v", published, want)
+	}
+
+	var attempts int
+	var lastError string
+	err := tx.QueryRow(ctx, `SELECT attempts, last_error FROM outbox WHERE user_id = $1 ORDER BY seq LIMIT 1`,
+		ids["a"]).Scan(&attempts, &lastError)
+	if err != nil {
+		t.Fatalf("selecting failed event: %v", err)
+	}
+
+	if attempts != 1 || lastError != "subscriber unavailable" {
+		t.Errorf("attempts = %d, last error = %q, want 1 failed attempt", attempts, lastError)
+	}
+
+	// The failed event is not retried before its backoff.
+	published = nil
+	if _, err := d.Dispatch(ctx); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	if len(published) != 0 {
+		t.Errorf("published = %v, want nothing before the backoff", published)
+	}
+
+	// After the backoff the events of user a are published in order.
+	_, err = tx.Exec(ctx, `UPDATE outbox SET next_attempt_at = now() WHERE user_id = $1`, ids["a"])
+	if err != nil {
+		t.Fatalf("updating next attempt: %v", err)
+	}
+
+	if _, err := d.Dispatch(ctx); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	if want := []string{"a:a1", "a:a2"}; !reflect.DeepEqual(published, want) {
+		t.Errorf("published = %v, want %v", published, want)
+	}
+
+	var unpublished int
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM outbox WHERE user_id IN ($1, $2) AND published_at IS NULL`,
+		ids["a"], ids["b"]).Scan(&unpublished)
+	if err != nil {
+		t.Fatalf("counting unpublished events: %v", err)
+	}
+
+	if unpublished != 0 {
+		t.Errorf("unpublished events = %d, want 0", unpublished)
+	}
+}
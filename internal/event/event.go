@@ -0,0 +1,134 @@
+// Package event records the changes made to a users storage and delivers them to
+// subscribers.
+//
+// Events are written to the outbox table in the same transaction as the change they
+// describe, so an event exists if and only if the change was committed. A Dispatcher
+// publishes the outbox in order. Delivery is at least once, subscribers should use
+// Event.ID to ignore events they have already handled.
+package event
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The event types.
+const (
+	TypeDirCreated   = "dir.created"
+	TypeDirDeleted   = "dir.deleted"
+	TypeDirRenamed   = "dir.renamed"
+	TypeDirMoved     = "dir.moved"
+	TypeFileUploaded = "file.uploaded"
+	TypeFileDeleted  = "file.deleted"
+	TypeFileRenamed  = "file.renamed"
+	TypeFileMoved    = "file.moved"
+)
+
+// Event is a change made to a users storage.
+type Event struct {
+	// ID uniquely identifies the event. It is the idempotency key of the event, an event
+	// may be published more than once but always with the same ID.
+	ID string `json:"id"`
+
+	// Seq is the position of the event in the outbox. Events of a user are always
+	// published in increasing Seq order.
+	Seq int64 `json:"seq"`
+
+	UserID    string          `json:"user_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// DirCreated is the payload of a TypeDirCreated event.
+type DirCreated struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+
+	// CreatedVia is the path that created the directory, such as "explicit" or
+	// "auto_root".
+	CreatedVia string `json:"created_via"`
+}
+
+// DirDeleted is the payload of a TypeDirDeleted event. The directory was deleted with
+// every directory and file under it.
+type DirDeleted struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+
+	// Dirs and Files are the number of directories, including the deleted directory,
+	// and files that were deleted.
+	Dirs  int `json:"dirs"`
+	Files int `json:"files"`
+}
+
+// DirRenamed is the payload of a TypeDirRenamed event. The directory stays under its
+// parent, the paths of every directory and file under it change with it.
+type DirRenamed struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	OldName  string `json:"old_name"`
+	OldPath  string `json:"old_path"`
+}
+
+// DirMoved is the payload of a TypeDirMoved event. The directory was moved with every
+// directory and file under it, and keeps its name.
+type DirMoved struct {
+	ID          string `json:"id"`
+	ParentID    string `json:"parent_id"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	OldParentID string `json:"old_parent_id"`
+	OldPath     string `json:"old_path"`
+
+	// Dirs is the number of directories moved, including the moved directory.
+	Dirs int `json:"dirs"`
+}
+
+// FileUploaded is the payload of a TypeFileUploaded event.
+type FileUploaded struct {
+	ID          string `json:"id"`
+	DirectoryID string `json:"directory_id"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+
+	// ModifiedAt is the modification time declared by the client, or the upload time
+	// if the client did not declare one.
+	ModifiedAt time.Time `json:"client_modified_at"`
+}
+
+// FileDeleted is the payload of a TypeFileDeleted event.
+type FileDeleted struct {
+	ID          string `json:"id"`
+	DirectoryID string `json:"directory_id"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+}
+
+// FileRenamed is the payload of a TypeFileRenamed event. The file stays in its
+// directory.
+type FileRenamed struct {
+	ID          string `json:"id"`
+	DirectoryID string `json:"directory_id"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	OldName     string `json:"old_name"`
+	OldPath     string `json:"old_path"`
+}
+
+// FileMoved is the payload of a TypeFileMoved event. The file keeps its name.
+type FileMoved struct {
+	ID             string `json:"id"`
+	DirectoryID    string `json:"directory_id"`
+	Name           string `json:"name"`
+	Path           string `json:"path"`
+	OldDirectoryID string `json:"old_directory_id"`
+	OldPath        string `json:"old_path"`
+}
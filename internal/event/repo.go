@@ -0,0 +1,173 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DBTX is a database connection or transaction.
+type DBTX interface {
+	QueryRow(ctx context.Context, query string, args ...any) *sql.Row
+	Query(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	Exec(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Repo is the outbox repository.
+type Repo struct {
+	// The database connection. This can be a database connection or a database
+	// transaction.
+	db DBTX
+}
+
+// NewRepo creates a new Repo.
+func NewRepo(db DBTX) *Repo {
+	return &Repo{db: db}
+}
+
+// Insert adds a event to the outbox. The payload is marshalled to JSON. Insert should be
+// called with the transaction of the change the event describes.
+func (r *Repo) Insert(ctx context.Context, userID string, eventType string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshalling %s payload: %w", eventType, err)
+	}
+
+	query := `INSERT INTO outbox(id, user_id, type, payload, created_at, next_attempt_at)
+			  VALUES($1, $2, $3, $4, $5, $5)`
+
+	_, err = r.db.Exec(ctx, query, uuid.NewString(), userID, eventType, b, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("inserting %s event: %w", eventType, err)
+	}
+
+	return nil
+}
+
+// Row is a event in the outbox that has not been published.
+type Row struct {
+	Event
+
+	// Attempts is the number of failed attempts to publish the event.
+	Attempts int
+}
+
+// SelectPending selects at most limit unpublished events that are due at now, in
+// increasing Seq order.
+//
+// A event is not selected while an earlier unpublished event of the same user is
+// waiting to be retried, so the events of a user are never published out of order.
+func (r *Repo) SelectPending(ctx context.Context, now time.Time, limit int) ([]Row, error) {
+	query := `SELECT seq, id, user_id, type, payload, created_at, attempts
+			  FROM outbox o
+			  WHERE published_at IS NULL
+			  AND next_attempt_at <= $1
+			  AND NOT EXISTS (
+				  SELECT 1
+				  FROM outbox earlier
+				  WHERE earlier.user_id = o.user_id
+				  AND earlier.published_at IS NULL
+				  AND earlier.seq < o.seq
+				  AND earlier.next_attempt_at > $1
+			  )
+			  ORDER BY seq
+			  LIMIT $2`
+
+	rows, err := r.db.Query(ctx, query, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := []Row{}
+	for rows.Next() {
+		var p Row
+		if err := rows.Scan(&p.Seq, &p.ID, &p.UserID, &p.Type, &p.Payload, &p.CreatedAt, &p.Attempts); err != nil {
+			return nil, err
+		}
+
+		pending = append(pending, p)
+	}
+
+	return pending, rows.Err()
+}
+
+// UpdatePublished marks the event with seq as published at t.
+func (r *Repo) UpdatePublished(ctx context.Context, seq int64, t time.Time) error {
+	query := `UPDATE outbox
+			  SET published_at = $1, last_error = NULL
+			  WHERE seq = $2`
+
+	_, err := r.db.Exec(ctx, query, t.UTC(), seq)
+
+	return err
+}
+
+// UpdateFailed records a failed attempt to publish the event with seq. The event is
+// not selected again until next.
+func (r *Repo) UpdateFailed(ctx context.Context, seq int64, next time.Time, publishErr error) error {
+	query := `UPDATE outbox
+			  SET attempts = attempts + 1, next_attempt_at = $1, last_error = $2
+			  WHERE seq = $3`
+
+	_, err := r.db.Exec(ctx, query, next.UTC(), publishErr.Error(), seq)
+
+	return err
+}
+
+// SelectRange selects at most limit events created at or after from and before to, with a
+// Seq greater than afterSeq, in increasing Seq order. Published and unpublished events are
+// selected.
+func (r *Repo) SelectRange(ctx context.Context, from time.Time, to time.Time, afterSeq int64, limit int) ([]Event, error) {
+	query := `SELECT seq, id, user_id, type, payload, created_at
+			  FROM outbox
+			  WHERE created_at >= $1
+			  AND created_at < $2
+			  AND seq > $3
+			  ORDER BY seq
+			  LIMIT $4`
+
+	rows, err := r.db.Query(ctx, query, from.UTC(), to.UTC(), afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.Seq, &e.ID, &e.UserID, &e.Type, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		e.CreatedAt = e.CreatedAt.UTC()
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// DeletePublishedBefore deletes at most limit published events created before t, oldest
+// first. Unpublished events are never deleted. The number of events deleted is returned.
+func (r *Repo) DeletePublishedBefore(ctx context.Context, t time.Time, limit int) (int64, error) {
+	query := `DELETE FROM outbox
+			  WHERE seq IN (
+				  SELECT seq
+				  FROM outbox
+				  WHERE created_at < $1
+				  AND published_at IS NOT NULL
+				  ORDER BY seq
+				  LIMIT $2
+			  )`
+
+	result, err := r.db.Exec(ctx, query, t.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
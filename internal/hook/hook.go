@@ -0,0 +1,93 @@
+// Package hook runs the callbacks a deployment registers to extend Clox without forking it.
+//
+// The packages that have hook points, such as cloudstore and token, pass their callbacks to a
+// Queue. The Queue runs them in the background, so a slow or failing callback never delays or
+// changes the response of the request that triggered it. Callbacks are best effort, if the
+// Queue is full the callback is dropped.
+package hook
+
+import (
+	"context"
+	"expvar"
+	"log"
+)
+
+// DefaultQueueSize is the number of callbacks a Queue buffers if a size is not set.
+const DefaultQueueSize = 1024
+
+// metrics counts the callbacks run, dropped because the Queue was full, and that panicked. It
+// is published with expvar as "hooks".
+var metrics = expvar.NewMap("hooks")
+
+// job is a queued callback.
+type job struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+// Queue runs callbacks in the background, one at a time, in the order they were enqueued.
+//
+// Enqueue never blocks. A callback that panics is recovered and logged, the panic does not
+// stop the Queue. A nil Queue is valid for Enqueue, it drops every callback.
+//
+// Queue should be created using the NewQueue function.
+type Queue struct {
+	jobs chan job
+	log  *log.Logger
+}
+
+// NewQueue creates a new Queue that buffers at most size callbacks. If size is not positive,
+// it will default to DefaultQueueSize. If logger is nil, it will default to log.Default().
+func NewQueue(size int, logger *log.Logger) *Queue {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &Queue{jobs: make(chan job, size), log: logger}
+}
+
+// Enqueue queues fn to be run by Run. The name identifies the hook point in logs and metrics.
+// If the Queue is full, fn is dropped and false is returned.
+func (q *Queue) Enqueue(name string, fn func(ctx context.Context)) bool {
+	if q == nil {
+		return false
+	}
+
+	select {
+	case q.jobs <- job{name: name, fn: fn}:
+		return true
+	default:
+		metrics.Add("dropped", 1)
+		q.log.Printf("[WARN] Hook queue full, dropping hook %s\n", name)
+		return false
+	}
+}
+
+// Run runs the queued callbacks until ctx is done. The callbacks are passed ctx.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-q.jobs:
+			q.run(ctx, j)
+		}
+	}
+}
+
+// run runs j and recovers if it panics.
+func (q *Queue) run(ctx context.Context, j job) {
+	defer func() {
+		if v := recover(); v != nil {
+			metrics.Add("panics", 1)
+			q.log.Printf("[ERROR] Hook %s panicked: %v\n", j.name, v)
+		}
+	}()
+
+	metrics.Add("run", 1)
+	j.fn(ctx)
+}
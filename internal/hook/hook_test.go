@@ -0,0 +1,93 @@
+package hook
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// newTestQueue creates a new Queue of size that discards its logs.
+func newTestQueue(size int) *Queue {
+	return NewQueue(size, log.New(io.Discard, "", 0))
+}
+
+// runQueue runs q until the test ends.
+func runQueue(t *testing.T, q *Queue) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestQueueEnqueueNil(t *testing.T) {
+	var q *Queue
+	if q.Enqueue("test", func(ctx context.Context) {}) {
+		t.Error("Enqueue() = true on a nil Queue, want false")
+	}
+}
+
+func TestQueueEnqueueFull(t *testing.T) {
+	q := newTestQueue(1)
+
+	if !q.Enqueue("test", func(ctx context.Context) {}) {
+		t.Fatal("Enqueue() = false, want true")
+	}
+
+	if q.Enqueue("test", func(ctx context.Context) {}) {
+		t.Error("Enqueue() = true on a full Queue, want false")
+	}
+}
+
+func TestQueueRun(t *testing.T) {
+	q := newTestQueue(0)
+	ran := make(chan int, 3)
+
+	for i := 0; i < 3; i++ {
+		i := i
+		if !q.Enqueue("test", func(ctx context.Context) { ran <- i }) {
+			t.Fatalf("Enqueue() %d = false, want true", i)
+		}
+	}
+
+	runQueue(t, q)
+
+	// The callbacks are run in the order they were enqueued.
+	for want := 0; want < 3; want++ {
+		select {
+		case got := <-ran:
+			if got != want {
+				t.Errorf("callback %d ran, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("callback %d was not run", want)
+		}
+	}
+}
+
+func TestQueueRunPanic(t *testing.T) {
+	q := newTestQueue(0)
+	ran := make(chan struct{})
+
+	q.Enqueue("panics", func(ctx context.Context) { panic("boom") })
+	q.Enqueue("runs", func(ctx context.Context) { close(ran) })
+
+	runQueue(t, q)
+
+	// A callback that panics does not stop the Queue.
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("callback after a panic was not run")
+	}
+}
@@ -0,0 +1,66 @@
+// Package invite manages the invite codes required to register when registration is
+// invite only.
+//
+// An invite code is shown once, when it is generated. Only the SHA-256 hash of the code
+// is stored. A code may be redeemed by a single user before it expires.
+package invite
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// DefaultTTL is the time an invite is valid for if a TTL is not given.
+const DefaultTTL = 7 * 24 * time.Hour
+
+// MaxTTL is the longest time an invite may be valid for.
+const MaxTTL = 90 * 24 * time.Hour
+
+// ErrInvalid signals an invite code does not exist, was already redeemed, or expired.
+var ErrInvalid = errors.New("invalid invite code")
+
+// Invite is a generated invite. The code of an invite is not stored, so it is only known
+// when generated.
+type Invite struct {
+	ID        string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	// UsedBy is the ID of the user that redeemed the invite. It is empty if the invite has
+	// not been redeemed.
+	UsedBy string
+	UsedAt time.Time
+}
+
+// Status returns the status of the invite at now, "used", "expired", or "active".
+func (i Invite) Status(now time.Time) string {
+	switch {
+	case i.UsedBy != "":
+		return "used"
+	case !now.Before(i.ExpiresAt):
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+// newCode returns a random invite code and its hash.
+func newCode() (code string, hash string, err error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	code = hex.EncodeToString(b)
+	return code, hashCode(code), nil
+}
+
+// hashCode returns the stored hash of an invite code.
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
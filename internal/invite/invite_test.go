@@ -0,0 +1,44 @@
+package invite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInviteStatus(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		invite Invite
+		want   string
+	}{
+		{name: "active", invite: Invite{ExpiresAt: now.Add(time.Second)}, want: "active"},
+		{name: "expires now", invite: Invite{ExpiresAt: now}, want: "expired"},
+		{name: "expired", invite: Invite{ExpiresAt: now.Add(-time.Hour)}, want: "expired"},
+		{name: "used", invite: Invite{ExpiresAt: now.Add(time.Hour), UsedBy: "user"}, want: "used"},
+		{name: "used and expired", invite: Invite{ExpiresAt: now.Add(-time.Hour), UsedBy: "user"}, want: "used"},
+	}
+
+	for _, tc := range tests {
+		if got := tc.invite.Status(now); got != tc.want {
+			t.Errorf("%s: Status() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestNewCode(t *testing.T) {
+	code, hash, err := newCode()
+	if err != nil {
+		t.Fatalf("newCode() error = %v", err)
+	}
+
+	if len(code) != 32 || hash != hashCode(code) || hash == code {
+		t.Errorf("newCode() = %q, %q, want a 32 character code and its hash", code, hash)
+	}
+
+	other, _, err := newCode()
+	if err != nil || other == code {
+		t.Errorf("newCode() = %q twice, want a new code", code)
+	}
+}
@@ -0,0 +1,106 @@
+package invite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// Repo is the invite repository.
+type Repo struct {
+	// The database connection.
+	db app.DB
+}
+
+// NewRepo creates a new Repo.
+func NewRepo(db app.DB) *Repo {
+	return &Repo{db: db}
+}
+
+// Row represents a invite row in the database.
+type Row struct {
+	ID        string
+	CodeHash  string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedBy    sql.NullString
+	UsedAt    sql.NullTime
+}
+
+// invite returns this row as a Invite.
+func (r *Row) invite() Invite {
+	return Invite{
+		ID:        r.ID,
+		CreatedBy: r.CreatedBy,
+		CreatedAt: r.CreatedAt,
+		ExpiresAt: r.ExpiresAt,
+		UsedBy:    r.UsedBy.String,
+		UsedAt:    r.UsedAt.Time,
+	}
+}
+
+// Insert inserts a invite into the database.
+func (r *Repo) Insert(ctx context.Context, row Row) error {
+	query := `INSERT INTO invites(id, code_hash, created_by, created_at, expires_at)
+			  VALUES($1, $2, $3, $4, $5)`
+
+	_, err := r.db.Exec(ctx, query, row.ID, row.CodeHash, row.CreatedBy, row.CreatedAt.UTC(), row.ExpiresAt.UTC())
+
+	return err
+}
+
+// SelectRecent selects at most limit invites, newest first.
+func (r *Repo) SelectRecent(ctx context.Context, limit int) ([]Row, error) {
+	query := `SELECT id, code_hash, created_by, created_at, expires_at, used_by, used_at
+			  FROM invites
+			  ORDER BY created_at DESC
+			  LIMIT $1`
+
+	rows, err := r.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []Row{}
+	for rows.Next() {
+		var row Row
+		if err := rows.Scan(&row.ID, &row.CodeHash, &row.CreatedBy, &row.CreatedAt, &row.ExpiresAt, &row.UsedBy, &row.UsedAt); err != nil {
+			return nil, err
+		}
+
+		invites = append(invites, row)
+	}
+
+	return invites, rows.Err()
+}
+
+// Redeem marks the unused and unexpired invite with codeHash as used by userID at now. The
+// ID of the invite is returned. If no invite can be redeemed, sql.ErrNoRows is returned.
+func (r *Repo) Redeem(ctx context.Context, codeHash string, userID string, now time.Time) (string, error) {
+	query := `UPDATE invites
+			  SET used_by = $1, used_at = $2
+			  WHERE code_hash = $3
+			  AND used_by IS NULL
+			  AND expires_at > $2
+			  RETURNING id`
+
+	var id string
+	err := r.db.QueryRow(ctx, query, userID, now.UTC(), codeHash).Scan(&id)
+
+	return id, err
+}
+
+// Release marks the invite with id as not used.
+func (r *Repo) Release(ctx context.Context, id string) error {
+	query := `UPDATE invites
+			  SET used_by = NULL, used_at = NULL
+			  WHERE id = $1`
+
+	_, err := r.db.Exec(ctx, query, id)
+
+	return err
+}
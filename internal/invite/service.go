@@ -0,0 +1,124 @@
+package invite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/google/uuid"
+)
+
+// ListLimit is the maximum number of invites List returns.
+const ListLimit = 100
+
+// Service is the business logic for invites.
+type Service struct {
+	repo *Repo
+}
+
+// NewService creates a new Service.
+func NewService(repo *Repo) *Service {
+	return &Service{repo: repo}
+}
+
+// Generate generates a invite created by the user createdBy that is valid for ttl. If ttl
+// is not positive, it defaults to DefaultTTL. The invite and its code are returned, the
+// code cannot be retrieved again.
+//
+// If ttl is greater than MaxTTL, a app.WrappedSafeError is returned.
+func (s *Service) Generate(ctx context.Context, createdBy string, ttl time.Duration) (Invite, string, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	if ttl > MaxTTL {
+		return Invite{}, "", app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invite ttl %s exceeds %s", ttl, MaxTTL),
+			SafeMessage: fmt.Sprintf("Invites cannot be valid for more than %d days", int(MaxTTL.Hours()/24)),
+			StatusCode:  http.StatusBadRequest,
+			Field:       "days",
+		})
+	}
+
+	code, hash, err := newCode()
+	if err != nil {
+		return Invite{}, "", fmt.Errorf("generating invite code: %w", err)
+	}
+
+	now := time.Now().UTC()
+	row := Row{
+		ID:        uuid.NewString(),
+		CodeHash:  hash,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	if err := s.repo.Insert(ctx, row); err != nil {
+		return Invite{}, "", fmt.Errorf("inserting invite: %w", err)
+	}
+
+	return row.invite(), code, nil
+}
+
+// List lists the most recent invites, newest first.
+func (s *Service) List(ctx context.Context) ([]Invite, error) {
+	rows, err := s.repo.SelectRecent(ctx, ListLimit)
+	if err != nil {
+		return nil, fmt.Errorf("selecting invites: %w", err)
+	}
+
+	invites := []Invite{}
+	for _, row := range rows {
+		invites = append(invites, row.invite())
+	}
+
+	return invites, nil
+}
+
+// Redeem redeems the invite with code for the user userID. The ID of the invite is
+// returned, it should be passed to Release if the user is not registered.
+//
+// If the code does not exist, was already redeemed, or expired, ErrInvalid is returned
+// within a app.WrappedSafeError.
+func (s *Service) Redeem(ctx context.Context, code string, userID string) (string, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return "", invalid(errors.New("invite code is empty"))
+	}
+
+	id, err := s.repo.Redeem(ctx, hashCode(code), userID, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", invalid(err)
+		}
+
+		return "", fmt.Errorf("redeeming invite: %w", err)
+	}
+
+	return id, nil
+}
+
+// Release releases a redeemed invite so it may be redeemed again.
+func (s *Service) Release(ctx context.Context, id string) error {
+	if err := s.repo.Release(ctx, id); err != nil {
+		return fmt.Errorf("releasing invite [id: %s]: %w", id, err)
+	}
+
+	return nil
+}
+
+// invalid returns the app.WrappedSafeError of a invite code that cannot be redeemed.
+func invalid(err error) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("%w: %v", ErrInvalid, err),
+		SafeMessage: "Invite code is invalid or expired",
+		StatusCode:  http.StatusForbidden,
+		Field:       "invite",
+	})
+}
@@ -0,0 +1,104 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+// assertInvalid fails t if err is not the 403 ErrInvalid error of Redeem.
+func assertInvalid(t *testing.T, err error) {
+	t.Helper()
+
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("error = %v, want ErrInvalid", err)
+	}
+
+	var safeErr app.SafeError
+	if !errors.As(err, &safeErr) {
+		t.Fatalf("error = %v, want a app.SafeError", err)
+	}
+
+	if _, status := safeErr.Safe(); status != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestServiceGenerateMaxTTL(t *testing.T) {
+	s := NewService(nil)
+
+	_, _, err := s.Generate(context.Background(), "admin", MaxTTL+time.Hour)
+
+	var fieldErr app.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field() != "days" {
+		t.Errorf("Generate() error = %v, want a error of the days field", err)
+	}
+}
+
+func TestServiceRedeemEmpty(t *testing.T) {
+	_, err := NewService(nil).Redeem(context.Background(), "  ", "user")
+	assertInvalid(t, err)
+}
+
+func TestServiceRedeem(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+	s := NewService(NewRepo(p))
+
+	adminID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		adminID, adminID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, adminID) })
+
+	invite, code, err := s.Generate(ctx, adminID, 0)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if got := invite.ExpiresAt.Sub(invite.CreatedAt); got != DefaultTTL {
+		t.Errorf("invite valid for %s, want %s", got, DefaultTTL)
+	}
+
+	// The code is redeemed once, surrounding white space is ignored.
+	id, err := s.Redeem(ctx, " "+code+"\n", "user-a")
+	if err != nil || id != invite.ID {
+		t.Fatalf("Redeem() = %q, %v, want %q", id, err, invite.ID)
+	}
+
+	_, err = s.Redeem(ctx, code, "user-b")
+	assertInvalid(t, err)
+
+	// A released invite can be redeemed again.
+	if err := s.Release(ctx, id); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+
+	if _, err := s.Redeem(ctx, code, "user-b"); err != nil {
+		t.Fatalf("Redeem() after Release() error = %v", err)
+	}
+
+	// Unknown and expired codes cannot be redeemed.
+	_, err = s.Redeem(ctx, "unknown", "user-c")
+	assertInvalid(t, err)
+
+	_, expired, err := s.Generate(ctx, adminID, time.Hour)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if _, err := p.Exec(ctx, `UPDATE invites SET expires_at = now() - interval '1 second' WHERE code_hash = $1`, hashCode(expired)); err != nil {
+		t.Fatalf("expiring invite: %v", err)
+	}
+
+	_, err = s.Redeem(ctx, expired, "user-c")
+	assertInvalid(t, err)
+}
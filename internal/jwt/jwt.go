@@ -34,6 +34,10 @@ func (m *Manager) SetSecret(secret string) {
 // Claims is the JWT claims.
 type Claims struct {
 	jwt.RegisteredClaims
+
+	// Generation is the token generation of the subject when the token was created. Tokens
+	// created without it have a generation of 0.
+	Generation int64 `json:"gen,omitempty"`
 }
 
 // NewTokenClaims holds the claims used when creating a new JWT.
@@ -43,11 +47,12 @@ type NewTokenClaims struct {
 	Nbf time.Time
 	Iat time.Time
 	Jti string
+	Gen int64
 }
 
 // New creates a JWT and returns it as a string.
 //
-// The token claims sub, exp, nbf, iat, and jti are set to the NewTokenClaims fields. The iss and aud claims
+// The token claims sub, exp, nbf, iat, jti, and gen are set to the NewTokenClaims fields. The iss and aud claims
 // are set to this managers audience and issuer fields.
 //
 // Tokens are signed with this managers secret.
@@ -60,7 +65,7 @@ func (m *Manager) New(c NewTokenClaims) (string, error) {
 		NotBefore: jwt.NewNumericDate(c.Nbf),
 		IssuedAt:  jwt.NewNumericDate(c.Iat),
 		ID:        c.Jti,
-	}}
+	}, Generation: c.Gen}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	return token.SignedString([]byte(m.secret))
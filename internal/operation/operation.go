@@ -0,0 +1,151 @@
+// Package operation records the multi-step storage operations of users, such as a batch
+// upload, so a user can reference and look up an operation when something goes wrong.
+//
+// Every operation is given an ID when it starts. The ID is returned to the client in the
+// Header response header and the "operation_id" body field. The record of the operation is
+// updated as it makes progress, and when it finishes. A synchronous operation is started and
+// finished in the request, a asynchronous operation keeps updating its record after the
+// response is written.
+package operation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// Header is the response header the ID of the operation of a request is set in.
+const Header = "X-Clox-Operation"
+
+// The operation types.
+const (
+	// TypeUpload is a batch upload of files to a directory.
+	TypeUpload = "files.upload"
+
+	// TypeConvertLayout is a conversion of the files of a directory to the fan-out layout.
+	TypeConvertLayout = "directory.convert_layout"
+
+	// TypeBackfill is a run of the file content backfill.
+	TypeBackfill = "files.backfill"
+
+	// TypeStorageRepair is a repair of the storage of a user.
+	TypeStorageRepair = "storage.repair"
+
+	// TypeSyncApply is a set of changes applied by a sync client in a single request.
+	TypeSyncApply = "sync.apply"
+)
+
+// The operation statuses.
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// The list limits.
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+// maxErrorLength is the maximum length of a stored error.
+const maxErrorLength = 255
+
+// Operation is the record of a multi-step operation of a user.
+type Operation struct {
+	ID     string
+	UserID string
+	Type   string
+
+	// ParamsHash is the hex encoded SHA-256 digest of the JSON encoded parameters of the
+	// operation. Two operations with the same parameters have the same hash.
+	ParamsHash string
+
+	Status string
+
+	// Processed is the number of items the operation processed, and Failed the number of
+	// them that failed. What a item is depends on the Type.
+	Processed int64
+	Failed    int64
+
+	// Error is the reason the operation failed. It is shown to the user, so it is only ever
+	// the safe message of a error.
+	Error string
+
+	StartedAt time.Time
+	UpdatedAt time.Time
+
+	// FinishedAt is zero while the operation is running.
+	FinishedAt time.Time
+}
+
+// hashParams returns the ParamsHash of params.
+func hashParams(params any) (string, error) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// safeError returns the message of err that may be shown to the user.
+func safeError(err error) string {
+	msg := "Operation failed"
+
+	var wrapErr *app.WrappedSafeError
+	if errors.As(err, &wrapErr) {
+		msg, _ = wrapErr.Safe()
+	}
+
+	if len(msg) > maxErrorLength {
+		msg = msg[:maxErrorLength]
+	}
+
+	return msg
+}
+
+// ParseStatus parses the "status" query parameter. If it is not set, "" is returned and all
+// operations are listed. If it is not a status, a 400 app.WrappedSafeError is returned.
+func ParseStatus(q url.Values) (string, error) {
+	switch v := q.Get("status"); v {
+	case "", StatusRunning, StatusSucceeded, StatusFailed:
+		return v, nil
+	default:
+		return "", app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid status: %q", v),
+			SafeMessage: fmt.Sprintf("Status must be %q, %q, or %q", StatusRunning, StatusSucceeded, StatusFailed),
+			StatusCode:  http.StatusBadRequest,
+			Field:       "status",
+		})
+	}
+}
+
+// ParseLimit parses the "limit" query parameter. If it is not set, DefaultListLimit is returned.
+// If it is not a whole number between 1 and MaxListLimit, a 400 app.WrappedSafeError is returned.
+func ParseLimit(q url.Values) (int, error) {
+	v := q.Get("limit")
+	if v == "" {
+		return DefaultListLimit, nil
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > MaxListLimit {
+		return 0, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid limit: %q", v),
+			SafeMessage: fmt.Sprintf("Limit must be a whole number between 1 and %d", MaxListLimit),
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	return limit, nil
+}
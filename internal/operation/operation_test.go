@@ -0,0 +1,97 @@
+package operation
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+func TestHashParams(t *testing.T) {
+	a, err := hashParams(map[string]any{"path": "/photos", "files": 2})
+	if err != nil {
+		t.Fatalf("hashParams() error = %v", err)
+	}
+
+	// The same parameters always have the same hash.
+	b, _ := hashParams(map[string]any{"files": 2, "path": "/photos"})
+	if a != b {
+		t.Errorf("hashParams() = %s and %s, want the same hash", a, b)
+	}
+
+	c, _ := hashParams(map[string]any{"path": "/docs", "files": 2})
+	if a == c {
+		t.Errorf("hashParams() of different parameters = %s, want different hashes", a)
+	}
+
+	if _, err := hashParams(func() {}); err == nil {
+		t.Error("hashParams() of a func error = nil, want an error")
+	}
+}
+
+func TestSafeError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "unsafe", err: errors.New("pq: connection reset"), want: "Operation failed"},
+		{
+			name: "safe",
+			err:  app.Wrap(app.WrapParams{Err: errors.New("full"), SafeMessage: "Storage is full", StatusCode: http.StatusInsufficientStorage}),
+			want: "Storage is full",
+		},
+		{
+			name: "truncated",
+			err:  app.Wrap(app.WrapParams{Err: errors.New("long"), SafeMessage: strings.Repeat("a", 300), StatusCode: http.StatusBadRequest}),
+			want: strings.Repeat("a", maxErrorLength),
+		},
+	}
+
+	for _, tc := range tests {
+		if got := safeError(tc.err); got != tc.want {
+			t.Errorf("%s: safeError() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	for _, v := range []string{"", StatusRunning, StatusSucceeded, StatusFailed} {
+		got, err := ParseStatus(url.Values{"status": {v}})
+		if err != nil || got != v {
+			t.Errorf("ParseStatus(%q) = %q, %v, want %q", v, got, err, v)
+		}
+	}
+
+	_, err := ParseStatus(url.Values{"status": {"done"}})
+
+	var fieldErr app.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field() != "status" {
+		t.Errorf("ParseStatus(done) error = %v, want a error of the status field", err)
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    int
+		wantErr bool
+	}{
+		{v: "", want: DefaultListLimit},
+		{v: "1", want: 1},
+		{v: "100", want: MaxListLimit},
+		{v: "0", wantErr: true},
+		{v: "101", wantErr: true},
+		{v: "ten", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseLimit(url.Values{"limit": {tc.v}})
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseLimit(%q) = %d, %v, want %d, error %t", tc.v, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
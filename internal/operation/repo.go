@@ -0,0 +1,96 @@
+package operation
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// Repo is the operation repository.
+type Repo struct {
+	// The database connection.
+	db app.DB
+}
+
+// NewRepo creates a new Repo.
+func NewRepo(db app.DB) *Repo {
+	return &Repo{db: db}
+}
+
+// Insert inserts a operation into the database.
+func (r *Repo) Insert(ctx context.Context, o Operation) error {
+	query := `INSERT INTO operations(id, user_id, type, params_hash, status, started_at, updated_at)
+			  VALUES($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := r.db.Exec(ctx, query, o.ID, o.UserID, o.Type, o.ParamsHash, o.Status, o.StartedAt.UTC(), o.UpdatedAt.UTC())
+
+	return err
+}
+
+// Update updates the status, counts, error, and times of a operation.
+func (r *Repo) Update(ctx context.Context, o Operation) error {
+	query := `UPDATE operations
+			  SET status = $1, processed = $2, failed = $3, error = $4, updated_at = $5, finished_at = $6
+			  WHERE id = $7`
+
+	finishedAt := sql.NullTime{Time: o.FinishedAt.UTC(), Valid: !o.FinishedAt.IsZero()}
+	_, err := r.db.Exec(ctx, query, o.Status, o.Processed, o.Failed, o.Error, o.UpdatedAt.UTC(), finishedAt, o.ID)
+
+	return err
+}
+
+// Select selects a operation of a user.
+func (r *Repo) Select(ctx context.Context, id string, userID string) (Operation, error) {
+	query := `SELECT id, user_id, type, params_hash, status, processed, failed, error, started_at, updated_at, finished_at
+			  FROM operations
+			  WHERE id = $1 AND user_id = $2`
+
+	return scan(r.db.QueryRow(ctx, query, id, userID))
+}
+
+// SelectRecent selects at most limit operations of a user, newest first. If status is not
+// empty, only the operations with that status are selected.
+func (r *Repo) SelectRecent(ctx context.Context, userID string, status string, limit int) ([]Operation, error) {
+	query := `SELECT id, user_id, type, params_hash, status, processed, failed, error, started_at, updated_at, finished_at
+			  FROM operations
+			  WHERE user_id = $1
+			  AND ($2 = '' OR status = $2)
+			  ORDER BY started_at DESC
+			  LIMIT $3`
+
+	rows, err := r.db.Query(ctx, query, userID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := []Operation{}
+	for rows.Next() {
+		o, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		ops = append(ops, o)
+	}
+
+	return ops, rows.Err()
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scan scans a row of the operations table.
+func scan(row scanner) (Operation, error) {
+	var o Operation
+	var finishedAt sql.NullTime
+
+	err := row.Scan(&o.ID, &o.UserID, &o.Type, &o.ParamsHash, &o.Status, &o.Processed, &o.Failed,
+		&o.Error, &o.StartedAt, &o.UpdatedAt, &finishedAt)
+	o.FinishedAt = finishedAt.Time
+
+	return o, err
+}
@@ -0,0 +1,184 @@
+package operation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/google/uuid"
+)
+
+// Service starts operations and gets the operations of users.
+//
+// A nil Service is valid, operations are not recorded and Start returns a nil Run. Service
+// should be created using the NewService function.
+type Service struct {
+	repo *Repo
+	log  *log.Logger
+}
+
+// NewService creates a new Service. If logger is nil, it will default to log.Default().
+func NewService(repo *Repo, logger *log.Logger) *Service {
+	if repo == nil {
+		panic("operation.NewService: cannot create Service with nil Repo")
+	}
+
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &Service{repo: repo, log: logger}
+}
+
+// Start records a running operation of a user. The parameters of the operation are hashed,
+// params must be JSON encodable. The returned Run updates the record as the operation makes
+// progress, and must be finished with Run.Finish.
+func (s *Service) Start(ctx context.Context, userID string, opType string, params any) (*Run, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	hash, err := hashParams(params)
+	if err != nil {
+		return nil, fmt.Errorf("hashing %s parameters: %w", opType, err)
+	}
+
+	now := time.Now().UTC()
+	o := Operation{
+		ID:         uuid.NewString(),
+		UserID:     userID,
+		Type:       opType,
+		ParamsHash: hash,
+		Status:     StatusRunning,
+		StartedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := s.repo.Insert(ctx, o); err != nil {
+		return nil, fmt.Errorf("inserting %s operation [user: %s]: %w", opType, userID, err)
+	}
+
+	return &Run{repo: s.repo, log: s.log, op: o}, nil
+}
+
+// Get gets a operation of a user. If the operation does not exist or belongs to another user,
+// a 404 app.WrappedSafeError is returned.
+func (s *Service) Get(ctx context.Context, userID string, id string) (Operation, error) {
+	if _, err := uuid.Parse(id); err != nil || s == nil {
+		return Operation{}, notFound(id, sql.ErrNoRows)
+	}
+
+	o, err := s.repo.Select(ctx, id, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Operation{}, notFound(id, err)
+		}
+
+		return Operation{}, fmt.Errorf("selecting operation [id: %s]: %w", id, err)
+	}
+
+	return o, nil
+}
+
+// Recent gets at most limit of the most recent operations of a user, newest first. If status
+// is not empty, only the operations with that status are returned. If limit is not positive,
+// it defaults to DefaultListLimit. It is capped at MaxListLimit.
+func (s *Service) Recent(ctx context.Context, userID string, status string, limit int) ([]Operation, error) {
+	if s == nil {
+		return []Operation{}, nil
+	}
+
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	ops, err := s.repo.SelectRecent(ctx, userID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("selecting operations [user: %s]: %w", userID, err)
+	}
+
+	return ops, nil
+}
+
+// notFound returns the 404 app.WrappedSafeError of a operation a user may not access.
+func notFound(id string, err error) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("operation '%s' not found: %w", id, err),
+		SafeMessage: "Operation not found",
+		StatusCode:  http.StatusNotFound,
+	})
+}
+
+// Run is a running operation. It updates the record of the operation. Errors updating the
+// record are logged, they never fail the operation.
+//
+// A nil Run is valid and records nothing. A Run is safe for concurrent use.
+type Run struct {
+	repo *Repo
+	log  *log.Logger
+
+	mu sync.Mutex
+	op Operation
+}
+
+// ID returns the ID of the operation. If r is nil, "" is returned.
+func (r *Run) ID() string {
+	if r == nil {
+		return ""
+	}
+
+	return r.op.ID
+}
+
+// Progress records the number of items processed and failed so far.
+func (r *Run) Progress(ctx context.Context, processed int64, failed int64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.op.Processed = processed
+	r.op.Failed = failed
+	r.update(ctx)
+}
+
+// Finish records the operation finished. If err is not nil, the operation failed with the
+// safe message of err.
+func (r *Run) Finish(ctx context.Context, err error) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.op.Status = StatusSucceeded
+	if err != nil {
+		r.op.Status = StatusFailed
+		r.op.Error = safeError(err)
+	}
+	r.op.FinishedAt = time.Now().UTC()
+	r.update(ctx)
+}
+
+// update updates the record of the operation. The record is updated even if ctx is
+// cancelled, a cancelled request still finishes its operation. The lock must be held.
+func (r *Run) update(ctx context.Context) {
+	r.op.UpdatedAt = time.Now().UTC()
+
+	if err := r.repo.Update(context.WithoutCancel(ctx), r.op); err != nil {
+		r.log.Printf("[ERROR] Updating operation [id: %s, type: %s]: %v\n", r.op.ID, r.op.Type, err)
+	}
+}
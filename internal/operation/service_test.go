@@ -0,0 +1,147 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+// newTestService creates a Service on the test database, with a new user. The user and
+// its operations are deleted when the test ends.
+func newTestService(t *testing.T) (*Service, string) {
+	t.Helper()
+
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	userID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	return NewService(NewRepo(p), log.New(io.Discard, "", 0)), userID
+}
+
+// assertNotFound fails t if err is not a 404 app.WrappedSafeError.
+func assertNotFound(t *testing.T, err error) {
+	t.Helper()
+
+	var safeErr *app.WrappedSafeError
+	if !errors.As(err, &safeErr) {
+		t.Fatalf("error = %v, want a app.WrappedSafeError", err)
+	}
+
+	if _, status := safeErr.Safe(); status != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestServiceNil(t *testing.T) {
+	var s *Service
+
+	run, err := s.Start(context.Background(), "user", TypeUpload, nil)
+	if err != nil || run != nil {
+		t.Fatalf("Start() on a nil Service = %v, %v, want a nil Run", run, err)
+	}
+
+	// A nil Run records nothing.
+	run.Progress(context.Background(), 1, 0)
+	run.Finish(context.Background(), nil)
+	if id := run.ID(); id != "" {
+		t.Errorf("ID() of a nil Run = %q, want empty", id)
+	}
+
+	_, err = s.Get(context.Background(), "user", uuid.NewString())
+	assertNotFound(t, err)
+}
+
+func TestServiceSync(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newTestService(t)
+
+	run, err := s.Start(ctx, userID, TypeUpload, map[string]any{"path": "/photos"})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	op, err := s.Get(ctx, userID, run.ID())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if op.Status != StatusRunning || op.Type != TypeUpload || !op.FinishedAt.IsZero() {
+		t.Errorf("Get() before Finish = %+v, want a running upload", op)
+	}
+
+	run.Progress(ctx, 2, 1)
+	run.Finish(ctx, nil)
+
+	op, err = s.Get(ctx, userID, run.ID())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if op.Status != StatusSucceeded || op.Processed != 2 || op.Failed != 1 || op.FinishedAt.IsZero() {
+		t.Errorf("Get() after Finish = %+v, want succeeded with 2 processed and 1 failed", op)
+	}
+
+	// The operation of another user, or a malformed ID, is not found.
+	_, err = s.Get(ctx, uuid.NewString(), run.ID())
+	assertNotFound(t, err)
+
+	_, err = s.Get(ctx, userID, "operation")
+	assertNotFound(t, err)
+}
+
+func TestServiceAsync(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newTestService(t)
+
+	// The request that started the operation is done before the operation finishes.
+	reqCtx, cancel := context.WithCancel(ctx)
+	run, err := s.Start(reqCtx, userID, TypeBackfill, nil)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	cancel()
+
+	running, err := s.Recent(ctx, userID, StatusRunning, 0)
+	if err != nil {
+		t.Fatalf("Recent() error = %v", err)
+	}
+
+	if len(running) != 1 || running[0].ID != run.ID() {
+		t.Fatalf("Recent(running) = %+v, want the started operation", running)
+	}
+
+	run.Progress(reqCtx, 10, 0)
+	run.Finish(reqCtx, app.Wrap(app.WrapParams{
+		Err:         errors.New("disk full"),
+		SafeMessage: "Storage is full",
+		StatusCode:  http.StatusInsufficientStorage,
+	}))
+
+	op, err := s.Get(ctx, userID, run.ID())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if op.Status != StatusFailed || op.Processed != 10 || op.Error != "Storage is full" {
+		t.Errorf("Get() = %+v, want failed with 10 processed and the safe error", op)
+	}
+
+	running, err = s.Recent(ctx, userID, StatusRunning, 0)
+	if err != nil || len(running) != 0 {
+		t.Errorf("Recent(running) = %+v, %v, want none", running, err)
+	}
+}
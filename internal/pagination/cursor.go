@@ -0,0 +1,122 @@
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCursor signals a cursor was malformed or its signature did not match.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrExpiredCursor signals a cursor was valid but has expired.
+	ErrExpiredCursor = errors.New("expired cursor")
+)
+
+// DefaultCursorTTL is the default duration a cursor is valid for.
+var DefaultCursorTTL = 24 * time.Hour
+
+// Codec encodes and decodes opaque cursors. A cursor is the base64 encoded JSON payload
+// followed by its HMAC-SHA256 signature, so clients cannot tamper with the ordering keys.
+//
+// The same Codec (or a Codec with the same secret) must be used to decode the cursors it
+// encoded. SetSecret must be called before encoding or decoding.
+type Codec struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewCodec creates a new Codec. Cursors expire after ttl. If ttl is zero, DefaultCursorTTL
+// is used.
+func NewCodec(ttl time.Duration) *Codec {
+	if ttl == 0 {
+		ttl = DefaultCursorTTL
+	}
+
+	return &Codec{ttl: ttl, now: time.Now}
+}
+
+// SetSecret sets the secret used to sign cursors. The signing key is derived from secret,
+// so the same secret can safely be shared with other components (such as the JWT manager).
+func (c *Codec) SetSecret(secret string) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("clox-pagination-cursor"))
+	c.key = mac.Sum(nil)
+}
+
+// payload is the signed content of a cursor.
+type payload struct {
+	Key json.RawMessage `json:"k"`
+	Exp int64           `json:"e"`
+}
+
+// Encode encodes key into a cursor. The key is the ordering key of the last item on a page,
+// it must be JSON serializable.
+func (c *Codec) Encode(key any) (string, error) {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("marshalling cursor key: %w", err)
+	}
+
+	p, err := json.Marshal(payload{Key: k, Exp: c.now().Add(c.ttl).Unix()})
+	if err != nil {
+		return "", fmt.Errorf("marshalling cursor payload: %w", err)
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(p) + "." + enc.EncodeToString(c.sign(p)), nil
+}
+
+// Decode decodes cursor into key. If the cursor is malformed or was not signed by this Codec
+// an ErrInvalidCursor is returned. If the cursor has expired an ErrExpiredCursor is returned.
+func (c *Codec) Decode(cursor string, key any) error {
+	enc := base64.RawURLEncoding
+
+	p64, sig64, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return fmt.Errorf("%w: missing signature", ErrInvalidCursor)
+	}
+
+	p, err := enc.DecodeString(p64)
+	if err != nil {
+		return fmt.Errorf("%w: decoding payload: %v", ErrInvalidCursor, err)
+	}
+
+	sig, err := enc.DecodeString(sig64)
+	if err != nil {
+		return fmt.Errorf("%w: decoding signature: %v", ErrInvalidCursor, err)
+	}
+
+	if !hmac.Equal(sig, c.sign(p)) {
+		return fmt.Errorf("%w: signature mismatch", ErrInvalidCursor)
+	}
+
+	var pl payload
+	if err := json.Unmarshal(p, &pl); err != nil {
+		return fmt.Errorf("%w: unmarshalling payload: %v", ErrInvalidCursor, err)
+	}
+
+	if c.now().Unix() > pl.Exp {
+		return ErrExpiredCursor
+	}
+
+	if err := json.Unmarshal(pl.Key, key); err != nil {
+		return fmt.Errorf("%w: unmarshalling key: %v", ErrInvalidCursor, err)
+	}
+
+	return nil
+}
+
+// sign returns the HMAC-SHA256 signature of p.
+func (c *Codec) sign(p []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(p)
+	return mac.Sum(nil)
+}
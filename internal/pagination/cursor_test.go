@@ -0,0 +1,136 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testKey struct {
+	Name string `json:"n"`
+	ID   int    `json:"i"`
+}
+
+// newTestCodec creates a Codec with the secret that expires cursors after ttl.
+func newTestCodec(secret string, ttl time.Duration) *Codec {
+	c := NewCodec(ttl)
+	c.SetSecret(secret)
+	return c
+}
+
+// flipByte returns cursor with the byte at i of its decoded part, 0 for the payload and 1
+// for the signature, flipped.
+func flipByte(t *testing.T, cursor string, part int, i int) string {
+	t.Helper()
+
+	parts := strings.Split(cursor, ".")
+	b, err := base64.RawURLEncoding.DecodeString(parts[part])
+	if err != nil {
+		t.Fatalf("decoding cursor: %v", err)
+	}
+
+	b[i] ^= 0x01
+	parts[part] = base64.RawURLEncoding.EncodeToString(b)
+	return strings.Join(parts, ".")
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	c := newTestCodec("secret", time.Hour)
+	want := testKey{Name: "photos", ID: 42}
+
+	cursor, err := c.Encode(want)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	var got testKey
+	if err := c.Decode(cursor, &got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if got != want {
+		t.Errorf("Decode() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCodecDecodeInvalid(t *testing.T) {
+	c := newTestCodec("secret", time.Hour)
+
+	cursor, err := c.Encode(testKey{Name: "photos", ID: 42})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	expired := newTestCodec("secret", time.Hour)
+	expired.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	expiredCursor, err := expired.Encode(testKey{Name: "photos", ID: 42})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	payload, sig, _ := strings.Cut(cursor, ".")
+
+	tests := []struct {
+		name   string
+		codec  *Codec
+		cursor string
+		want   error
+	}{
+		{name: "flipped payload byte", codec: c, cursor: flipByte(t, cursor, 0, 0), want: ErrInvalidCursor},
+		{name: "flipped signature byte", codec: c, cursor: flipByte(t, cursor, 1, 0), want: ErrInvalidCursor},
+		{name: "missing signature", codec: c, cursor: payload, want: ErrInvalidCursor},
+		{name: "truncated signature", codec: c, cursor: payload + "." + sig[:len(sig)-4], want: ErrInvalidCursor},
+		{name: "truncated payload", codec: c, cursor: payload[:len(payload)-4] + "." + sig, want: ErrInvalidCursor},
+		{name: "invalid payload base64", codec: c, cursor: "!!!." + sig, want: ErrInvalidCursor},
+		{name: "invalid signature base64", codec: c, cursor: payload + ".!!!", want: ErrInvalidCursor},
+		{name: "empty", codec: c, cursor: "", want: ErrInvalidCursor},
+		{name: "wrong key", codec: newTestCodec("other", time.Hour), cursor: cursor, want: ErrInvalidCursor},
+		{name: "expired", codec: c, cursor: expiredCursor, want: ErrExpiredCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var key testKey
+			if err := tt.codec.Decode(tt.cursor, &key); !errors.Is(err, tt.want) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	c := newTestCodec("secret", time.Hour)
+
+	cursor, err := c.Encode(testKey{Name: "photos", ID: 42})
+	if err != nil {
+		f.Fatalf("Encode() error = %v", err)
+	}
+
+	f.Add(cursor)
+	f.Add("")
+	f.Add(".")
+	f.Add("e30.")
+
+	f.Fuzz(func(t *testing.T, cursor string) {
+		var key testKey
+		err := c.Decode(cursor, &key)
+		if err != nil && !errors.Is(err, ErrInvalidCursor) && !errors.Is(err, ErrExpiredCursor) {
+			t.Errorf("Decode() error = %v, want ErrInvalidCursor or ErrExpiredCursor", err)
+		}
+
+		// A cursor that decodes was signed by c, so it encodes the same key again.
+		if err == nil {
+			again, err := c.Encode(key)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+
+			var got testKey
+			if err := c.Decode(again, &got); err != nil || got != key {
+				t.Errorf("Decode(Encode(%+v)) = %+v, %v", key, got, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,165 @@
+// Package pagination provides the cursor encoding, keyset query helpers, and response
+// envelope shared by every paginated endpoint.
+package pagination
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// Page is the standard response envelope for paginated endpoints.
+type Page[T any] struct {
+	// Items is the items on this page. It is never nil, so it always marshals as a JSON array.
+	Items []T `json:"items"`
+
+	// NextCursor is the cursor to request the next page. It is empty if there are no more items.
+	NextCursor string `json:"next_cursor"`
+
+	// HasMore is true if there are items after this page.
+	HasMore bool `json:"has_more"`
+}
+
+// NewPage builds a Page from items that were selected with a limit of limit+1. If there are more
+// than limit items, the extra item is dropped and the ordering key of the last item on the page,
+// as returned by key, is encoded as the next cursor.
+func NewPage[T any](c *Codec, items []T, limit int, key func(T) any) (Page[T], error) {
+	if items == nil {
+		items = []T{}
+	}
+
+	if len(items) <= limit {
+		return Page[T]{Items: items}, nil
+	}
+
+	items = items[:limit]
+	cursor, err := c.Encode(key(items[len(items)-1]))
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	return Page[T]{Items: items, NextCursor: cursor, HasMore: true}, nil
+}
+
+// Window is a page of items that can be paged in both directions. It is used by the server
+// rendered pages, where the previous page is a link and cannot be kept by the client.
+type Window[T any] struct {
+	Items []T
+
+	// PrevCursor is the cursor to request the page before this page as a "before" cursor. It is
+	// empty if this is the first page.
+	PrevCursor string
+
+	// NextCursor is the cursor to request the page after this page as a "after" cursor. It is
+	// empty if there are no more items.
+	NextCursor string
+}
+
+// Request is the pagination parameters of a request.
+type Request struct {
+	// Cursor is the opaque cursor returned as the next cursor of the previous page. It is empty
+	// when requesting the first page.
+	Cursor string
+
+	// Limit is the maximum number of items on the page.
+	Limit int
+}
+
+// ParseRequest parses the "cursor" and "limit" URL query parameters. If limit is not set it
+// defaults to defaultLimit. A limit greater than maxLimit is lowered to maxLimit.
+//
+// All errors returned are a app.WrappedSafeError.
+func ParseRequest(r *http.Request, defaultLimit int, maxLimit int) (Request, error) {
+	req := Request{Cursor: r.URL.Query().Get("cursor"), Limit: defaultLimit}
+
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			return Request{}, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("invalid limit %q", l),
+				SafeMessage: "Limit must be a positive integer",
+				StatusCode:  http.StatusBadRequest,
+			})
+		}
+
+		req.Limit = limit
+	}
+
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+
+	return req, nil
+}
+
+// DecodeCursor decodes the cursor of this Request into key. If there is no cursor, key is
+// unchanged and false is returned.
+//
+// All errors returned are a app.WrappedSafeError.
+func (r Request) DecodeCursor(c *Codec, key any) (bool, error) {
+	if r.Cursor == "" {
+		return false, nil
+	}
+
+	if err := c.Decode(r.Cursor, key); err != nil {
+		msg := "Invalid cursor"
+		if errors.Is(err, ErrExpiredCursor) {
+			msg = "Cursor has expired"
+		}
+
+		return false, app.Wrap(app.WrapParams{
+			Err:         err,
+			SafeMessage: msg,
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	return true, nil
+}
+
+// Order is the direction of a keyset ordering.
+type Order int
+
+const (
+	Asc Order = iota
+	Desc
+)
+
+// KeysetWhere returns the keyset condition that selects the rows after a cursor for the
+// ordering columns. The placeholders begin at $firstArg and the cursor values must be passed
+// in the same order as columns.
+//
+// For example, KeysetWhere([]string{"issued_at", "token_id"}, Desc, 2) returns
+// "(issued_at, token_id) < ($2, $3)".
+func KeysetWhere(columns []string, order Order, firstArg int) string {
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", firstArg+i)
+	}
+
+	op := ">"
+	if order == Desc {
+		op = "<"
+	}
+
+	return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), op, strings.Join(placeholders, ", "))
+}
+
+// KeysetOrderBy returns the ORDER BY expression for the ordering columns.
+func KeysetOrderBy(columns []string, order Order) string {
+	dir := "ASC"
+	if order == Desc {
+		dir = "DESC"
+	}
+
+	ordered := make([]string, len(columns))
+	for i, c := range columns {
+		ordered[i] = c + " " + dir
+	}
+
+	return strings.Join(ordered, ", ")
+}
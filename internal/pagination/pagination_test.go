@@ -0,0 +1,81 @@
+package pagination
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// assertStatus fails t if err is not a app.WrappedSafeError with the status code.
+func assertStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var safeErr *app.WrappedSafeError
+	if !errors.As(err, &safeErr) {
+		t.Fatalf("error = %v, want a app.WrappedSafeError", err)
+	}
+
+	if _, got := safeErr.Safe(); got != status {
+		t.Errorf("status = %d, want %d", got, status)
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		query  string
+		want   Request
+		status int
+	}{
+		{query: "", want: Request{Limit: 50}},
+		{query: "?cursor=abc&limit=10", want: Request{Cursor: "abc", Limit: 10}},
+		{query: "?limit=1000", want: Request{Limit: 500}},
+		{query: "?limit=0", status: http.StatusBadRequest},
+		{query: "?limit=ten", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := ParseRequest(httptest.NewRequest(http.MethodGet, "/"+tt.query, nil), 50, 500)
+			if tt.status != 0 {
+				assertStatus(t, err, tt.status)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseRequest() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseRequest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestDecodeCursor(t *testing.T) {
+	c := newTestCodec("secret", time.Hour)
+
+	cursor, err := c.Encode(testKey{Name: "photos", ID: 42})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	var key testKey
+	if ok, err := (Request{}).DecodeCursor(c, &key); ok || err != nil {
+		t.Errorf("DecodeCursor() without a cursor = %v, %v, want false, nil", ok, err)
+	}
+
+	if ok, err := (Request{Cursor: cursor}).DecodeCursor(c, &key); !ok || err != nil {
+		t.Errorf("DecodeCursor() = %v, %v, want true, nil", ok, err)
+	}
+
+	_, err = Request{Cursor: cursor + "x"}.DecodeCursor(c, &key)
+	assertStatus(t, err, http.StatusBadRequest)
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("DecodeCursor() error = %v, want ErrInvalidCursor", err)
+	}
+}
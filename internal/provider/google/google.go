@@ -24,6 +24,8 @@ type User struct {
 	FamilyName string `json:"family_name"`
 	PictureURL string `json:"picture"`
 	Email      string `json:"email"`
+
+	EmailVerified bool `json:"email_verified"`
 }
 
 func (c *Client) UserInfo(ctx context.Context, token *oauth2.Token) (provider.User, error) {
@@ -45,5 +47,7 @@ func (c *Client) UserInfo(ctx context.Context, token *oauth2.Token) (provider.Us
 		LastName:   user.FamilyName,
 		PictureURL: user.PictureURL,
 		Email:      user.Email,
+
+		EmailVerified: user.Email != "" && user.EmailVerified,
 	}, nil
 }
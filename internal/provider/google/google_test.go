@@ -0,0 +1,59 @@
+package google
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cicconee/clox/internal/oauth2"
+)
+
+// staticClient is a provider.HTTPClient that answers every request with body.
+type staticClient struct {
+	body string
+}
+
+func (c staticClient) Client(ctx context.Context, token *oauth2.Token) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(c.body)),
+			Request:    r,
+		}, nil
+	})}
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
+
+func TestUserInfoEmailVerified(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{name: "verified", body: `{"sub":"1","email":"ada@example.com","email_verified":true}`, want: true},
+		{name: "unverified", body: `{"sub":"1","email":"ada@example.com","email_verified":false}`, want: false},
+		{name: "claim absent", body: `{"sub":"1","email":"ada@example.com"}`, want: false},
+		{name: "email absent", body: `{"sub":"1","email_verified":true}`, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			user, err := New(staticClient{body: tc.body}).UserInfo(context.Background(), &oauth2.Token{})
+			if err != nil {
+				t.Fatalf("UserInfo() error = %v", err)
+			}
+
+			if user.EmailVerified != tc.want {
+				t.Errorf("EmailVerified = %v, want %v", user.EmailVerified, tc.want)
+			}
+		})
+	}
+}
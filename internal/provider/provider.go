@@ -18,6 +18,10 @@ type User struct {
 	LastName   string `json:"family_name"`
 	PictureURL string `json:"picture"`
 	Email      string `json:"email"`
+
+	// EmailVerified is true if the provider verified the user owns Email. It is false if Email
+	// is empty.
+	EmailVerified bool `json:"email_verified"`
 }
 
 type ID struct {
@@ -0,0 +1,135 @@
+// Package reqinfo carries the information about a request that is shared by the middlewares, handlers, and logging
+// of both apps in a single Info in the request context.
+//
+// The Info is populated incrementally, every middleware sets the fields it knows about with a setter, and it is read
+// with From. Setters never modify the Info of the context they are given, they return a context with a copy, so the
+// order the middlewares run in does not matter and a request without a middleware simply has the zero value of its
+// fields.
+//
+// Values that only concern one package, such as the token.Principal of the API or the session user of the Server
+// Side App, are carried with a typed Key defined by that package.
+package reqinfo
+
+import "context"
+
+type contextKey struct{}
+
+// Info is the information about a request.
+type Info struct {
+	// RequestID identifies the request in logs. It is set by server.RequestInfo.
+	RequestID string
+
+	// ClientIP is the IP address of the client, see app.ClientIP. It is set by server.RequestInfo.
+	ClientIP string
+
+	// UserID is the ID of the authenticated user. It is set by the API token and client certificate middlewares,
+	// and by the session middleware of the Server Side App.
+	UserID string
+
+	// TokenID is the ID of the API token the request was authenticated with. It is empty if the request was not
+	// authenticated with a API token.
+	TokenID string
+
+	// SessionID is the ID of the session of the user. It is empty if the request does not have a active session.
+	SessionID string
+
+	// FlashMessage and FlashError are the flashes extracted from the cookies of the request by the Flash
+	// middleware of the Server Side App.
+	FlashMessage string
+	FlashError   string
+
+	// values are the values set with Set, keyed by the name of their Key.
+	values map[string]any
+}
+
+// From gets the Info of the request from ctx. If no Info is set, the zero value is returned.
+func From(ctx context.Context) Info {
+	if info, ok := ctx.Value(contextKey{}).(*Info); ok {
+		return *info
+	}
+
+	return Info{}
+}
+
+// update returns a context with a copy of the Info of ctx modified by fn.
+func update(ctx context.Context, fn func(info *Info)) context.Context {
+	info := From(ctx)
+
+	if info.values != nil {
+		values := make(map[string]any, len(info.values))
+		for k, v := range info.values {
+			values[k] = v
+		}
+		info.values = values
+	}
+
+	fn(&info)
+	return context.WithValue(ctx, contextKey{}, &info)
+}
+
+// SetRequest sets the request ID and client IP.
+func SetRequest(ctx context.Context, requestID string, clientIP string) context.Context {
+	return update(ctx, func(info *Info) {
+		info.RequestID = requestID
+		info.ClientIP = clientIP
+	})
+}
+
+// SetUser sets the ID of the authenticated user.
+func SetUser(ctx context.Context, userID string) context.Context {
+	return update(ctx, func(info *Info) {
+		info.UserID = userID
+	})
+}
+
+// SetToken sets the ID of the authenticated user and the ID of the API token the request was authenticated with.
+func SetToken(ctx context.Context, userID string, tokenID string) context.Context {
+	return update(ctx, func(info *Info) {
+		info.UserID = userID
+		info.TokenID = tokenID
+	})
+}
+
+// SetSession sets the ID of the user and the ID of their active session.
+func SetSession(ctx context.Context, userID string, sessionID string) context.Context {
+	return update(ctx, func(info *Info) {
+		info.UserID = userID
+		info.SessionID = sessionID
+	})
+}
+
+// SetFlash sets the flash message and flash error.
+func SetFlash(ctx context.Context, msg string, err string) context.Context {
+	return update(ctx, func(info *Info) {
+		info.FlashMessage = msg
+		info.FlashError = err
+	})
+}
+
+// Key is the key of a value of type T carried in the Info. Keys are compared by name, every Key should be created
+// once with NewKey and stored in a package level variable.
+type Key[T any] struct {
+	name string
+}
+
+// NewKey creates a new Key. The name must be unique, it should be prefixed with the name of the package that
+// defines the key, such as "auth.principal".
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+// Set sets the value of k.
+func Set[T any](ctx context.Context, k Key[T], v T) context.Context {
+	return update(ctx, func(info *Info) {
+		if info.values == nil {
+			info.values = map[string]any{}
+		}
+		info.values[k.name] = v
+	})
+}
+
+// Get gets the value of k. If the value is not set, ok is false.
+func Get[T any](ctx context.Context, k Key[T]) (v T, ok bool) {
+	v, ok = From(ctx).values[k.name].(T)
+	return v, ok
+}
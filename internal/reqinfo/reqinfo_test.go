@@ -0,0 +1,100 @@
+package reqinfo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+var testKey = NewKey[[]string]("reqinfo.test")
+
+func TestFromZero(t *testing.T) {
+	ctx := context.Background()
+
+	if info := From(ctx); !reflect.DeepEqual(info, Info{}) {
+		t.Errorf("From() = %+v, want the zero Info", info)
+	}
+
+	if v, ok := Get(ctx, testKey); ok || v != nil {
+		t.Errorf("Get() = %v, %v, want nil, false", v, ok)
+	}
+
+	// A value of a Key is not set by the setters of the fields.
+	ctx = SetUser(ctx, "user")
+	if v, ok := Get(ctx, testKey); ok || v != nil {
+		t.Errorf("Get() after SetUser = %v, %v, want nil, false", v, ok)
+	}
+}
+
+// TestSetOrder populates the Info in every order, as the middlewares of a request
+// would, and gets the same Info each time.
+func TestSetOrder(t *testing.T) {
+	setters := []func(ctx context.Context) context.Context{
+		func(ctx context.Context) context.Context { return SetRequest(ctx, "request", "203.0.113.9") },
+		func(ctx context.Context) context.Context { return SetToken(ctx, "user", "token") },
+		func(ctx context.Context) context.Context { return SetFlash(ctx, "saved", "") },
+		func(ctx context.Context) context.Context { return Set(ctx, testKey, []string{"a"}) },
+	}
+
+	want := Info{
+		RequestID:    "request",
+		ClientIP:     "203.0.113.9",
+		UserID:       "user",
+		TokenID:      "token",
+		FlashMessage: "saved",
+		values:       map[string]any{"reqinfo.test": []string{"a"}},
+	}
+
+	for _, order := range permutations(len(setters)) {
+		ctx := context.Background()
+		for _, i := range order {
+			ctx = setters[i](ctx)
+		}
+
+		if got := From(ctx); !reflect.DeepEqual(got, want) {
+			t.Errorf("From() in order %v = %+v, want %+v", order, got, want)
+		}
+	}
+}
+
+// permutations returns every order of the numbers 0 to n-1.
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+
+	var orders [][]int
+	for _, order := range permutations(n - 1) {
+		for i := 0; i <= len(order); i++ {
+			next := append(append(append([]int{}, order[:i]...), n-1), order[i:]...)
+			orders = append(orders, next)
+		}
+	}
+
+	return orders
+}
+
+func TestSetCopies(t *testing.T) {
+	parent := Set(SetToken(context.Background(), "user", "token"), testKey, []string{"a"})
+
+	// The setters of a child context leave the Info of its parent as it was.
+	child := SetSession(parent, "other", "session")
+	child = Set(child, testKey, []string{"b"})
+
+	if info := From(parent); info.UserID != "user" || info.SessionID != "" {
+		t.Errorf("parent Info = %+v, want user and no session", info)
+	}
+
+	if v, _ := Get(parent, testKey); !reflect.DeepEqual(v, []string{"a"}) {
+		t.Errorf("parent value = %v, want [a]", v)
+	}
+
+	// The last setter of a field wins, the other fields are kept.
+	if info := From(child); info.UserID != "other" || info.TokenID != "token" || info.SessionID != "session" {
+		t.Errorf("child Info = %+v, want the session user with the token", info)
+	}
+
+	if v, _ := Get(child, testKey); !reflect.DeepEqual(v, []string{"b"}) {
+		t.Errorf("child value = %v, want [b]", v)
+	}
+}
@@ -1,10 +1,17 @@
 package router
 
 import (
-	"github.com/go-chi/chi/v5"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// ErrInvalidRoute signals a route cannot be registered.
+var ErrInvalidRoute = errors.New("invalid route")
+
 // Chi is a router that wraps a *chi.Mux.
 type Chi struct {
 	*chi.Mux
@@ -15,23 +22,81 @@ func NewChi() *Chi {
 	return &Chi{Mux: chi.NewMux()}
 }
 
-// SetRoute sets a handler for the specified pattern and method. Supported methods are GET, POST, PUT, and DELETE.
-func (c *Chi) SetRoute(method string, pattern string, handler http.HandlerFunc) {
+// SetRoute sets a handler for the specified pattern and method. Supported methods are GET, HEAD, POST, PUT, PATCH, and
+// DELETE.
+//
+// The pattern is validated with ValidatePattern before it is passed to chi. If the method is not supported, the pattern
+// is invalid, or chi rejects the route, a ErrInvalidRoute is returned and the route is not set.
+func (c *Chi) SetRoute(method string, pattern string, handler http.HandlerFunc) (err error) {
+	if err := ValidatePattern(pattern); err != nil {
+		return err
+	}
+
+	// chi panics on routes it cannot register.
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("%w: %s %s: %v", ErrInvalidRoute, method, pattern, rec)
+		}
+	}()
+
 	switch method {
 	case "GET":
 		c.Mux.Get(pattern, handler)
+	case "HEAD":
+		c.Mux.Head(pattern, handler)
 	case "POST":
 		c.Mux.Post(pattern, handler)
 	case "PUT":
 		c.Mux.Put(pattern, handler)
+	case "PATCH":
+		c.Mux.Patch(pattern, handler)
 	case "DELETE":
 		c.Mux.Delete(pattern, handler)
 	default:
-		return
+		return fmt.Errorf("%w: %s %s: unsupported method", ErrInvalidRoute, method, pattern)
 	}
+
+	return nil
 }
 
 // SetStatic sets a GET route for static assets. You may call SetRoute instead, but this method is more explicit.
-func (c *Chi) SetStatic(pattern string, handler http.HandlerFunc) {
-	c.SetRoute("GET", pattern, handler)
+func (c *Chi) SetStatic(pattern string, handler http.HandlerFunc) error {
+	return c.SetRoute("GET", pattern, handler)
+}
+
+// ValidatePattern validates a route pattern. A pattern must begin with "/", URL parameters must be named and cannot be
+// nested, and a "*" wildcard may only be the last character. If the pattern is invalid, a ErrInvalidRoute is returned.
+func ValidatePattern(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("%w: %q: must begin with \"/\"", ErrInvalidRoute, pattern)
+	}
+
+	depth, start := 0, 0
+	for i, c := range pattern {
+		switch c {
+		case '{':
+			if depth > 0 {
+				return fmt.Errorf("%w: %q: nested URL parameter", ErrInvalidRoute, pattern)
+			}
+			depth, start = 1, i
+		case '}':
+			if depth == 0 {
+				return fmt.Errorf("%w: %q: unopened URL parameter", ErrInvalidRoute, pattern)
+			}
+			if name, _, _ := strings.Cut(pattern[start+1:i], ":"); name == "" {
+				return fmt.Errorf("%w: %q: unnamed URL parameter", ErrInvalidRoute, pattern)
+			}
+			depth = 0
+		case '*':
+			if depth == 0 && i != len(pattern)-1 {
+				return fmt.Errorf("%w: %q: wildcard must be last", ErrInvalidRoute, pattern)
+			}
+		}
+	}
+
+	if depth > 0 {
+		return fmt.Errorf("%w: %q: unclosed URL parameter", ErrInvalidRoute, pattern)
+	}
+
+	return nil
 }
@@ -0,0 +1,90 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidatePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+
+		// wantErr is a part of the error message, or empty if the pattern is valid.
+		wantErr string
+	}{
+		{pattern: "/"},
+		{pattern: "/api/dir/{id}"},
+		{pattern: "/api/dir/{id}/{name}"},
+		{pattern: "/api/dir/{id:[0-9]+}"},
+		{pattern: "/web/static/*"},
+		{pattern: "api/dir", wantErr: `must begin with "/"`},
+		{pattern: "", wantErr: `must begin with "/"`},
+		{pattern: "/api/{a{b}}", wantErr: "nested URL parameter"},
+		{pattern: "/api/id}", wantErr: "unopened URL parameter"},
+		{pattern: "/api/{}", wantErr: "unnamed URL parameter"},
+		{pattern: "/api/{:[0-9]+}", wantErr: "unnamed URL parameter"},
+		{pattern: "/api/{id", wantErr: "unclosed URL parameter"},
+		{pattern: "/web/*/static", wantErr: "wildcard must be last"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.pattern, func(t *testing.T) {
+			err := ValidatePattern(tc.pattern)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidatePattern() error = %v, want nil", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrInvalidRoute) {
+				t.Fatalf("ValidatePattern() error = %v, want ErrInvalidRoute", err)
+			}
+
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ValidatePattern() error = %q, want it to contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetRoute(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	tests := []struct {
+		name    string
+		method  string
+		pattern string
+		wantErr string
+	}{
+		{name: "get", method: "GET", pattern: "/dir/{id}"},
+		{name: "head", method: "HEAD", pattern: "/dir/{id}"},
+		{name: "patch", method: "PATCH", pattern: "/dir/{id}"},
+		{name: "unsupported method", method: "OPTIONS", pattern: "/dir/{id}", wantErr: "unsupported method"},
+		{name: "lowercase method", method: "get", pattern: "/dir/{id}", wantErr: "unsupported method"},
+		{name: "invalid pattern", method: "GET", pattern: "/dir/{id", wantErr: "unclosed URL parameter"},
+		{name: "rejected by chi", method: "GET", pattern: "/dir/{id}/{id}", wantErr: "chi"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewChi().SetRoute(tc.method, tc.pattern, handler)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("SetRoute() error = %v, want nil", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrInvalidRoute) {
+				t.Fatalf("SetRoute() error = %v, want ErrInvalidRoute", err)
+			}
+
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("SetRoute() error = %q, want it to contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
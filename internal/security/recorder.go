@@ -0,0 +1,133 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+
+	"github.com/google/uuid"
+)
+
+// The default Recorder configuration.
+const (
+	DefaultBufferSize = 256
+	DefaultListLimit  = 20
+	MaxListLimit      = 100
+)
+
+// Recorder records security events in the background and reads them back.
+//
+// Record never blocks. Events are buffered and inserted by Run. If the buffer is full, the
+// event is dropped and logged. A nil Recorder is valid for Record, it records nothing.
+//
+// Recorder should be created using the NewRecorder function.
+type Recorder struct {
+	repo   *Repo
+	log    *log.Logger
+	events chan Event
+}
+
+// NewRecorder creates a new Recorder that buffers at most bufferSize events. If bufferSize is
+// not positive, it will default to DefaultBufferSize. If logger is nil, it will default to
+// log.Default().
+func NewRecorder(repo *Repo, bufferSize int, logger *log.Logger) *Recorder {
+	if repo == nil {
+		panic("security.NewRecorder: cannot create Recorder with nil Repo")
+	}
+
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &Recorder{repo: repo, log: logger, events: make(chan Event, bufferSize)}
+}
+
+// Record queues e to be recorded. If e has no ID, one is generated.
+func (r *Recorder) Record(e Event) {
+	if r == nil {
+		return
+	}
+
+	if e.ID == "" {
+		e.ID = uuid.NewString()
+	}
+
+	select {
+	case r.events <- e:
+	default:
+		r.log.Printf("[WARN] Security event buffer full, dropping event [user: %s, type: %s]\n", e.UserID, e.Type)
+	}
+}
+
+// Run inserts the recorded events until ctx is done. Errors are logged.
+func (r *Recorder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-r.events:
+			if err := r.repo.Insert(ctx, e); err != nil {
+				r.log.Printf("[ERROR] Recording security event [user: %s, type: %s]: %v\n", e.UserID, e.Type, err)
+			}
+		}
+	}
+}
+
+// ParseLimit parses the "limit" query parameter. If it is not set, DefaultListLimit is returned.
+// If it is not a whole number between 1 and MaxListLimit, a 400 app.WrappedSafeError is returned.
+func ParseLimit(q url.Values) (int, error) {
+	v := q.Get("limit")
+	if v == "" {
+		return DefaultListLimit, nil
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > MaxListLimit {
+		return 0, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid limit: %q", v),
+			SafeMessage: fmt.Sprintf("Limit must be a whole number between 1 and %d", MaxListLimit),
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	return limit, nil
+}
+
+// Recent gets at most limit of the most recent events of a user, newest first. If limit is not
+// positive, it defaults to DefaultListLimit. It is capped at MaxListLimit.
+func (r *Recorder) Recent(ctx context.Context, userID string, limit int) ([]Event, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	events, err := r.repo.SelectRecent(ctx, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("selecting security events [user: %s]: %w", userID, err)
+	}
+
+	return events, nil
+}
+
+// CountAPIFailures counts the failed API authentications of a user in the duration before now.
+func (r *Recorder) CountAPIFailures(ctx context.Context, userID string, d time.Duration) (int, error) {
+	count, err := r.repo.CountSince(ctx, userID, APITypes, time.Now().Add(-d))
+	if err != nil {
+		return 0, fmt.Errorf("counting security events [user: %s]: %w", userID, err)
+	}
+
+	return count, nil
+}
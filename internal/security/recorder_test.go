@@ -0,0 +1,124 @@
+package security
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: DefaultListLimit},
+		{value: "1", want: 1},
+		{value: "100", want: MaxListLimit},
+		{value: "0", wantErr: true},
+		{value: "101", wantErr: true},
+		{value: "ten", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseLimit(url.Values{"limit": {tc.value}})
+		if tc.wantErr {
+			safeErr, ok := err.(app.SafeError)
+			if !ok {
+				t.Fatalf("ParseLimit(%q) error = %v, want a app.SafeError", tc.value, err)
+			}
+
+			if _, status := safeErr.Safe(); status != http.StatusBadRequest {
+				t.Errorf("ParseLimit(%q) status = %d, want %d", tc.value, status, http.StatusBadRequest)
+			}
+			continue
+		}
+
+		if err != nil || got != tc.want {
+			t.Errorf("ParseLimit(%q) = %d, %v, want %d", tc.value, got, err, tc.want)
+		}
+	}
+}
+
+func TestRecorderRecord(t *testing.T) {
+	// A nil Recorder records nothing.
+	var nilRecorder *Recorder
+	nilRecorder.Record(Event{UserID: "user"})
+
+	r := NewRecorder(NewRepo(nil), 1, log.New(io.Discard, "", 0))
+	r.Record(Event{UserID: "user", Type: TypeLoginFailed})
+
+	// Record never blocks, the event is dropped when the buffer is full.
+	r.Record(Event{UserID: "user", Type: TypeLoginBlocked})
+
+	if got := len(r.events); got != 1 {
+		t.Fatalf("buffered events = %d, want 1", got)
+	}
+
+	if e := <-r.events; e.ID == "" || e.Type != TypeLoginFailed {
+		t.Errorf("buffered event = %+v, want the first event with a ID", e)
+	}
+}
+
+func TestRecorderRun(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	userID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	r := NewRecorder(NewRepo(p), 0, log.New(io.Discard, "", 0))
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		r.Run(runCtx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	now := time.Now().UTC()
+	r.Record(Event{UserID: userID, Type: TypeTokenRejected, CreatedAt: now.Add(-8 * 24 * time.Hour)})
+	r.Record(Event{UserID: userID, Type: TypeTokenBlocked, CreatedAt: now.Add(-time.Hour)})
+	r.Record(Event{UserID: userID, Type: TypeLoginFailed, CreatedAt: now})
+
+	// The events are inserted in the background.
+	var events []Event
+	for deadline := time.Now().Add(5 * time.Second); len(events) < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("recorded events = %d, want 3", len(events))
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		events, err = r.Recent(ctx, userID, 0)
+		if err != nil {
+			t.Fatalf("Recent() error = %v", err)
+		}
+	}
+
+	if events[0].Type != TypeLoginFailed || events[2].Type != TypeTokenRejected {
+		t.Errorf("Recent() types = %s, %s, %s, want newest first", events[0].Type, events[1].Type, events[2].Type)
+	}
+
+	// Only the API failures of the last week are counted.
+	count, err := r.CountAPIFailures(ctx, userID, 7*24*time.Hour)
+	if err != nil || count != 1 {
+		t.Errorf("CountAPIFailures() = %d, %v, want 1", count, err)
+	}
+}
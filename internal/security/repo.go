@@ -0,0 +1,71 @@
+package security
+
+import (
+	"context"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/lib/pq"
+)
+
+// Repo is the security event repository.
+type Repo struct {
+	// The database connection.
+	db app.DB
+}
+
+// NewRepo creates a new Repo.
+func NewRepo(db app.DB) *Repo {
+	return &Repo{db: db}
+}
+
+// Insert inserts a event into the database.
+func (r *Repo) Insert(ctx context.Context, e Event) error {
+	query := `INSERT INTO security_events(id, user_id, type, detail, ip, user_agent, created_at)
+			  VALUES($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := r.db.Exec(ctx, query, e.ID, e.UserID, e.Type, e.Detail, e.IP, e.UserAgent, e.CreatedAt.UTC())
+
+	return err
+}
+
+// SelectRecent selects at most limit events of a user, newest first.
+func (r *Repo) SelectRecent(ctx context.Context, userID string, limit int) ([]Event, error) {
+	query := `SELECT id, user_id, type, detail, ip, user_agent, created_at
+			  FROM security_events
+			  WHERE user_id = $1
+			  ORDER BY created_at DESC
+			  LIMIT $2`
+
+	rows, err := r.db.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Type, &e.Detail, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// CountSince counts the events of a user with one of types created at or after since.
+func (r *Repo) CountSince(ctx context.Context, userID string, types []string, since time.Time) (int, error) {
+	query := `SELECT COUNT(*)
+			  FROM security_events
+			  WHERE user_id = $1
+			  AND type = ANY($2)
+			  AND created_at >= $3`
+
+	var count int
+	err := r.db.QueryRow(ctx, query, userID, pq.Array(types), since.UTC()).Scan(&count)
+
+	return count, err
+}
@@ -0,0 +1,102 @@
+// Package security records the failed attempts to authenticate as a user, so the user can
+// review them.
+//
+// Only failures that can be attributed to a known user are recorded, such as a revoked token
+// or a blocked user logging in. Events are recorded in the background by a Recorder, so
+// recording never delays or changes the response of the failing request.
+package security
+
+import (
+	"net"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cicconee/clox/internal/reqinfo"
+)
+
+// The security event types.
+const (
+	// TypeTokenRejected is a API request with a token that was revoked or used from an address
+	// it is not allowed from.
+	TypeTokenRejected = "api.token_rejected"
+
+	// TypeTokenBlocked is a API request with a valid token of a blocked user.
+	TypeTokenBlocked = "api.blocked_user"
+
+	// TypeLoginFailed is a login that failed after the provider identified the user.
+	TypeLoginFailed = "login.failed"
+
+	// TypeLoginBlocked is a login of a blocked user.
+	TypeLoginBlocked = "login.blocked_user"
+)
+
+// APITypes are the event types of failed API authentications.
+var APITypes = []string{TypeTokenRejected, TypeTokenBlocked}
+
+// maxUserAgentLength is the maximum length of a stored user agent.
+const maxUserAgentLength = 512
+
+// Event is a failed attempt to authenticate as a user.
+type Event struct {
+	ID     string
+	UserID string
+	Type   string
+
+	// Detail is a short description of why the attempt failed. It is shown to the user, so it
+	// must not contain secrets.
+	Detail string
+
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+// NewEvent creates a Event of the request r. The IP is the client IP of r, it should be
+// resolved with app.ClientIP. If clientIP is nil, the client IP of the reqinfo.Info of r
+// is used.
+func NewEvent(r *http.Request, clientIP net.IP, userID string, eventType string, detail string) Event {
+	ip := reqinfo.From(r.Context()).ClientIP
+	if clientIP != nil {
+		ip = clientIP.String()
+	}
+
+	return Event{
+		UserID:    userID,
+		Type:      eventType,
+		Detail:    detail,
+		IP:        ip,
+		UserAgent: truncate(r.UserAgent(), maxUserAgentLength),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Description returns a user friendly description of the event type.
+func (e Event) Description() string {
+	switch e.Type {
+	case TypeTokenRejected:
+		return "API request with a rejected token"
+	case TypeTokenBlocked:
+		return "API request while blocked"
+	case TypeLoginFailed:
+		return "Failed login"
+	case TypeLoginBlocked:
+		return "Login while blocked"
+	default:
+		return e.Type
+	}
+}
+
+// truncate returns s with at most n bytes without splitting a rune.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	s = s[:n]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
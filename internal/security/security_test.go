@@ -0,0 +1,69 @@
+package security
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/cicconee/clox/internal/reqinfo"
+)
+
+func TestNewEvent(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	r.Header.Set("User-Agent", strings.Repeat("é", maxUserAgentLength))
+	r = r.WithContext(reqinfo.SetRequest(r.Context(), "request", "198.51.100.7"))
+
+	e := NewEvent(r, net.ParseIP("203.0.113.9"), "user", TypeTokenRejected, "token revoked")
+	if e.IP != "203.0.113.9" || e.UserID != "user" || e.Type != TypeTokenRejected || e.Detail != "token revoked" {
+		t.Errorf("NewEvent() = %+v, want the client IP, user, type, and detail", e)
+	}
+
+	// A long user agent is truncated without splitting a rune.
+	if len(e.UserAgent) > maxUserAgentLength || !utf8.ValidString(e.UserAgent) {
+		t.Errorf("user agent length = %d, valid %v, want at most %d valid bytes", len(e.UserAgent), utf8.ValidString(e.UserAgent), maxUserAgentLength)
+	}
+
+	if e.CreatedAt.IsZero() {
+		t.Errorf("created at is zero")
+	}
+
+	// Without a client IP, the IP of the request info is used.
+	if e := NewEvent(r, nil, "user", TypeLoginFailed, ""); e.IP != "198.51.100.7" {
+		t.Errorf("NewEvent() IP = %q, want the request info client IP", e.IP)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{s: "curl/8.0", n: 20, want: "curl/8.0"},
+		{s: "curl/8.0", n: 4, want: "curl"},
+		{s: "aé", n: 2, want: "a"},
+		{s: "aé", n: 3, want: "aé"},
+		{s: "", n: 0, want: ""},
+	}
+
+	for _, tc := range tests {
+		if got := truncate(tc.s, tc.n); got != tc.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tc.s, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestEventDescription(t *testing.T) {
+	for _, typ := range []string{TypeTokenRejected, TypeTokenBlocked, TypeLoginFailed, TypeLoginBlocked} {
+		if got := (Event{Type: typ}).Description(); got == typ || got == "" {
+			t.Errorf("Description() of %s = %q, want a description", typ, got)
+		}
+	}
+
+	if got := (Event{Type: "other"}).Description(); got != "other" {
+		t.Errorf("Description() of a unknown type = %q, want the type", got)
+	}
+}
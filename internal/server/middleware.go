@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db"
+	"github.com/cicconee/clox/internal/reqinfo"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header the ID of a request is read from and written to, see RequestInfo.
+const RequestIDHeader = "X-Request-ID"
+
+// MaxQueryLength is the length in bytes of the longest query string accepted by MaxQuery.
+const MaxQueryLength = 8192
+
+// validRequestID matches the request IDs accepted from a trusted proxy.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// Recover returns a Middleware that recovers from a panic in the next handler. The panic and stack trace are
+// logged and a 500 Internal Server Error is written in the format negotiated with app.Negotiate. A request that
+// does not ask for JSON, on a route without a Format hint, is answered with plain text.
+//
+// Recover should be the outermost middleware, set it with Use before any other middleware. It reserves the Format
+// hint of the request, so the hints set inside it are used if the handler panics.
+func Recover(logger *log.Logger) Middleware {
+	return Named("server.Recover", func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(app.WithFormat(r.Context(), ""))
+
+			defer func() {
+				if rec := recover(); rec != nil {
+					if rec == http.ErrAbortHandler {
+						panic(rec)
+					}
+
+					logger.Printf("[ERROR] [%s %s] Recovered from panic: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+					app.WriteError(w, app.Wrap(app.WrapParams{
+						Err:         errors.New(fmt.Sprint(rec)),
+						SafeMessage: http.StatusText(http.StatusInternalServerError),
+						StatusCode:  http.StatusInternalServerError,
+					}), app.Negotiate(r, app.FormatHTML))
+				}
+			}()
+
+			next(w, r)
+		}
+	})
+}
+
+// Format returns a Middleware that sets the app.Format hint of the routes it wraps. Middlewares that write errors
+// use the hint when the request does not ask for a format, see app.Negotiate.
+func Format(f app.Format) Middleware {
+	return Named(fmt.Sprintf("server.Format(%s)", f), func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			next(w, r.WithContext(app.WithFormat(r.Context(), f)))
+		}
+	})
+}
+
+// StatementBudget returns a Middleware that counts the database statements executed by a request, see
+// db.WithStatementCounter, and logs a warning with the route pattern when more than budget statements were executed.
+// The pattern is logged instead of the path, so the warnings of a route are grouped and no IDs are logged. It is meant
+// for development, to catch N+1 query patterns before they show up under load.
+func StatementBudget(budget int, logger *log.Logger) Middleware {
+	return Named(fmt.Sprintf("server.StatementBudget(%d)", budget), func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(db.WithStatementCounter(r.Context()))
+
+			next(w, r)
+
+			if n, _ := db.StatementCount(r.Context()); n > int64(budget) {
+				logger.Printf("[WARN] [%s %s] Executed %d database statements, budget is %d [request_id: %s]\n", r.Method, routePattern(r), n, budget, reqinfo.From(r.Context()).RequestID)
+			}
+		}
+	})
+}
+
+// routePattern returns the pattern of the route that matched r, such as "/api/dir/{id}". The pattern is only known
+// once chi routed the request. If no route matched, "unmatched" is returned.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+
+	return "unmatched"
+}
+
+// RequestInfo returns a Middleware that sets the request ID and client IP in the reqinfo.Info of the request. The
+// request ID is written to the RequestIDHeader of the response, so a client can quote it when reporting a problem.
+//
+// A request made directly by one of the trustedProxies keeps the request ID in its RequestIDHeader, so a request can
+// be followed through the proxy logs. Any other request is given a new ID.
+//
+// RequestInfo should be set with Use right after Recover, so every other middleware can read the Info.
+func RequestInfo(trustedProxies []*net.IPNet) Middleware {
+	return Named("server.RequestInfo", func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID.MatchString(id) || !app.ContainsIP(trustedProxies, remoteIP(r)) {
+				id = uuid.NewString()
+			}
+
+			w.Header().Set(RequestIDHeader, id)
+			next(w, r.WithContext(reqinfo.SetRequest(r.Context(), id, app.ClientIP(r, trustedProxies).String())))
+		}
+	})
+}
+
+// remoteIP returns the IP address the request was made from.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// MaxQuery returns a Middleware that rejects a request with a query string longer than MaxQueryLength with a 414 URI
+// Too Long, written in the format negotiated with app.Negotiate. No route takes a query near the limit, a longer query
+// is rejected before any handler parses it.
+func MaxQuery() Middleware {
+	return Named("server.MaxQuery", func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if n := len(r.URL.RawQuery); n > MaxQueryLength {
+				app.WriteError(w, app.Wrap(app.WrapParams{
+					Err:         fmt.Errorf("query string longer than %d bytes [length: %d]", MaxQueryLength, n),
+					SafeMessage: "Query string is too long",
+					StatusCode:  http.StatusRequestURITooLong,
+				}), app.Negotiate(r, app.FormatHTML))
+				return
+			}
+
+			next(w, r)
+		}
+	})
+}
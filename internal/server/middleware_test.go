@@ -0,0 +1,174 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db"
+	"github.com/cicconee/clox/internal/router"
+	_ "github.com/lib/pq"
+)
+
+func TestMaxQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		header     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "no query", wantStatus: http.StatusOK},
+		{name: "at limit", query: strings.Repeat("a", MaxQueryLength), wantStatus: http.StatusOK},
+		{
+			name:       "over limit",
+			query:      strings.Repeat("a", MaxQueryLength+1),
+			wantStatus: http.StatusRequestURITooLong,
+			wantBody:   "Query string is too long",
+		},
+		{
+			name:       "over limit json",
+			query:      "q=" + strings.Repeat("%00", MaxQueryLength),
+			header:     "XMLHttpRequest",
+			wantStatus: http.StatusRequestURITooLong,
+			wantBody:   `"Query string is too long"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			h := MaxQuery().Func(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+
+			r := httptest.NewRequest("GET", "/api/dir", nil)
+			r.URL.RawQuery = tc.query
+			if tc.header != "" {
+				r.Header.Set("X-Requested-With", tc.header)
+			}
+
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tc.wantStatus)
+			}
+
+			if called != (tc.wantStatus == http.StatusOK) {
+				t.Errorf("handler called = %t, want %t", called, !called)
+			}
+
+			if !strings.Contains(w.Body.String(), tc.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", w.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestRecoverFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		format   app.Format
+		wantType string
+	}{
+		{name: "no hint", wantType: "text/plain; charset=utf-8"},
+		{name: "hint set inside", format: app.FormatJSON, wantType: "application/json"},
+		{name: "accept json", accept: "application/json", wantType: "application/json"},
+		{name: "browser on a json route", accept: "text/html", format: app.FormatJSON, wantType: "text/plain; charset=utf-8"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+			if tc.format != "" {
+				h = Format(tc.format).Func(h)
+			}
+			h = Recover(log.New(io.Discard, "", 0)).Func(h)
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != tc.wantType {
+				t.Errorf("response = %d %s, want 500 %s", w.Code, w.Header().Get("Content-Type"), tc.wantType)
+			}
+		})
+	}
+}
+
+func TestStatementBudget(t *testing.T) {
+	// Nothing listens on the port, every statement fails but is still counted.
+	p := &db.Postgres{}
+	if err := p.Open("127.0.0.1", "1", "clox", "clox", "clox"); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer p.Close()
+
+	tests := []struct {
+		name       string
+		statements int
+		wantWarn   bool
+	}{
+		{name: "under budget", statements: 2},
+		{name: "at budget", statements: 3},
+		{name: "over budget", statements: 4, wantWarn: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs strings.Builder
+			s := New("localhost", "0", router.NewChi())
+			s.Use(StatementBudget(3, log.New(&logs, "", 0)))
+			s.SetRoute("GET", "/api/dir/{id}", func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < tc.statements; i++ {
+					p.Exec(r.Context(), `SELECT 1`)
+				}
+			})
+
+			s.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/dir/8d0b4f5e-secret", nil))
+
+			if !tc.wantWarn {
+				if logs.Len() != 0 {
+					t.Errorf("logged %q, want no warning", logs.String())
+				}
+				return
+			}
+
+			want := fmt.Sprintf("[WARN] [GET /api/dir/{id}] Executed %d database statements, budget is 3", tc.statements)
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("logged %q, want %q", logs.String(), want)
+			}
+
+			if strings.Contains(logs.String(), "secret") {
+				t.Errorf("logged %q, want the path not logged", logs.String())
+			}
+		})
+	}
+}
+
+func TestStatementBudgetUnmatched(t *testing.T) {
+	var logs strings.Builder
+	h := StatementBudget(0, log.New(&logs, "", 0)).Func(func(w http.ResponseWriter, r *http.Request) {
+		p := &db.Postgres{}
+		p.Open("127.0.0.1", "1", "clox", "clox", "clox")
+		defer p.Close()
+		p.Exec(r.Context(), `SELECT 1`)
+	})
+
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing/8d0b4f5e", nil))
+
+	if !strings.Contains(logs.String(), "[GET unmatched]") || strings.Contains(logs.String(), "8d0b4f5e") {
+		t.Errorf("logged %q, want the request logged as unmatched", logs.String())
+	}
+}
@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cicconee/clox/internal/router"
+	"github.com/cicconee/clox/internal/server"
+)
+
+// The route errors name the first call site outside of package server, so these tests
+// are in package server_test.
+
+// nextLine returns the file and line after the line of its caller.
+func nextLine() string {
+	_, file, n, _ := runtime.Caller(1)
+	return fmt.Sprintf("%s:%d", file, n+1)
+}
+
+func noop(w http.ResponseWriter, r *http.Request) {}
+
+func TestSetRouteDuplicate(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  string
+		second string
+	}{
+		{name: "same pattern", first: "/dir/{id}", second: "/dir/{id}"},
+		{name: "renamed parameter", first: "/dir/{id}", second: "/dir/{name}"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := server.New("localhost", "0", router.NewChi())
+
+			first := nextLine()
+			s.SetRoute("GET", tc.first, noop)
+			second := nextLine()
+			s.SetRoute("GET", tc.second, noop, server.Named("route", func(next http.HandlerFunc) http.HandlerFunc { return next }))
+
+			err := s.Err()
+			if !errors.Is(err, server.ErrDuplicateRoute) {
+				t.Fatalf("Err() = %v, want ErrDuplicateRoute", err)
+			}
+
+			want := fmt.Sprintf("set at %s, already set at %s", second, first)
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Err() = %q, want it to contain %q", err, want)
+			}
+
+			if routes := s.Routes(); len(routes) != 1 || routes[0].Pattern != tc.first {
+				t.Errorf("Routes() = %v, want only the first route", routes)
+			}
+		})
+	}
+}
+
+func TestSetRouteInvalid(t *testing.T) {
+	s := server.New("localhost", "0", router.NewChi())
+
+	invalidPattern := nextLine()
+	s.SetRoute("GET", "/dir/{id", noop)
+	invalidMethod := nextLine()
+	s.SetRoute("TRACE", "/dir", noop)
+	s.SetRoute("GET", "/dir/{id}", noop)
+
+	err := s.Err()
+	if !errors.Is(err, router.ErrInvalidRoute) {
+		t.Fatalf("Err() = %v, want router.ErrInvalidRoute", err)
+	}
+
+	for _, want := range []string{
+		"setting route at " + invalidPattern + `: invalid route: "/dir/{id": unclosed URL parameter`,
+		"setting route at " + invalidMethod + ": invalid route: TRACE /dir: unsupported method",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Err() = %q, want it to contain %q", err, want)
+		}
+	}
+
+	// The invalid routes are skipped, so the valid route with the same pattern is set.
+	if routes := s.Routes(); len(routes) != 1 || routes[0].Pattern != "/dir/{id}" {
+		t.Errorf("Routes() = %v, want only the valid route", routes)
+	}
+}
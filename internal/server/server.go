@@ -2,15 +2,41 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"github.com/cicconee/clox/internal/router"
 	"net/http"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/cicconee/clox/internal/router"
 )
 
+// ErrDuplicateRoute signals a method and pattern was set more than once.
+var ErrDuplicateRoute = errors.New("duplicate route")
+
 // HTTP is a http server that will serve the route handler and static assets.
 type HTTP struct {
 	httpServer *http.Server
 	handler    *router.Chi
+
+	// global is the middlewares applied to every request with Use.
+	global []Middleware
+
+	// routes is every route set with SetRoute, in the order they were set.
+	routes []Route
+
+	// callers is the call site that set each route, keyed by routeKey.
+	callers map[string]string
+
+	// errs is every error that occurred setting routes.
+	errs []error
+
+	// certFile and keyFile are the certificate and key the server listens with. If empty,
+	// the server does not use TLS.
+	certFile string
+	keyFile  string
 }
 
 // New will create a HTTP that will serve the handler on the host address and port.
@@ -21,35 +47,163 @@ func New(host string, port string, handler *router.Chi) *HTTP {
 			Handler: handler,
 		},
 		handler: handler,
+		callers: map[string]string{},
 	}
 }
 
-// Middleware is a function type that wraps a http.HandlerFunc around another http.HandlerFunc.
-type Middleware func(handlerFunc http.HandlerFunc) http.HandlerFunc
+// MiddlewareFunc is a function type that wraps a http.HandlerFunc around another http.HandlerFunc.
+type MiddlewareFunc func(handlerFunc http.HandlerFunc) http.HandlerFunc
+
+// Middleware is a named MiddlewareFunc. The name is used to introspect the middleware chain of a route with Routes.
+//
+// Middleware should be created using the Named function.
+type Middleware struct {
+	// Name identifies the middleware, for example "session.Active".
+	Name string
+
+	// Func is the middleware function.
+	Func MiddlewareFunc
+}
+
+// Named creates a Middleware with the name.
+func Named(name string, fn MiddlewareFunc) Middleware {
+	return Middleware{Name: name, Func: fn}
+}
+
+// Route is a route set with SetRoute and its middleware chain.
+type Route struct {
+	Method  string
+	Pattern string
+
+	// Middlewares is the names of the middlewares that wrap the route handler, outermost first. The global
+	// middlewares set with Use come before the route middlewares.
+	Middlewares []string
+}
 
 // SetRoute sets this HTTP server to serve the http.HandlerFunc for the specified method and pattern. All the
 // middlewares will be applied starting at index 0 to n. For example, middleware[0] will wrap middleware[1], until
 // middleware[n], middleware[n] will then wrap the handler.
+//
+// Middlewares set with Use wrap all the middlewares passed to SetRoute.
+//
+// SetRoute does not panic if the route cannot be set. If the method is not supported, the pattern is invalid, or the
+// method and pattern was already set, the route is skipped and the error, naming the call site, is returned by Err.
 func (s *HTTP) SetRoute(method string, pattern string, handler http.HandlerFunc, middlewares ...Middleware) {
+	caller := callSite()
+
+	key := routeKey(method, pattern)
+	if first, ok := s.callers[key]; ok {
+		s.errs = append(s.errs, fmt.Errorf("%w: %s %s set at %s, already set at %s", ErrDuplicateRoute, method, pattern, caller, first))
+		return
+	}
+
 	for i := len(middlewares) - 1; i >= 0; i-- {
-		mw := middlewares[i]
-		handler = mw(handler)
+		handler = middlewares[i].Func(handler)
+	}
+
+	if err := s.handler.SetRoute(method, pattern, handler); err != nil {
+		s.errs = append(s.errs, fmt.Errorf("setting route at %s: %w", caller, err))
+		return
+	}
+	s.callers[key] = caller
+
+	names := []string{}
+	for _, mw := range s.global {
+		names = append(names, mw.Name)
+	}
+	for _, mw := range middlewares {
+		names = append(names, mw.Name)
 	}
+	s.routes = append(s.routes, Route{Method: method, Pattern: pattern, Middlewares: names})
+}
+
+// Err returns the errors that occurred setting routes joined as a single error, or nil if every route was set.
+func (s *HTTP) Err() error {
+	return errors.Join(s.errs...)
+}
+
+// paramPattern matches a URL parameter in a route pattern.
+var paramPattern = regexp.MustCompile(`\{[^}]*\}`)
 
-	s.handler.SetRoute(method, pattern, handler)
+// routeKey returns the key that identifies a route. URL parameter names are removed, since chi matches "/dir/{id}"
+// and "/dir/{name}" as the same route.
+func routeKey(method string, pattern string) string {
+	return method + " " + paramPattern.ReplaceAllString(pattern, "{}")
+}
+
+// callSite returns the file and line that set a route. Frames in this package and in functions named setRoute, which
+// are helpers that wrap SetRoute, are skipped.
+func callSite() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	for {
+		frame, more := frames.Next()
+		if !strings.Contains(frame.Function, "/internal/server.") && !strings.HasSuffix(frame.Function, ".setRoute") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// Use applies the middlewares to every request this HTTP server handles, including requests that do not match a
+// route. The middlewares are applied in the same order as SetRoute, and wrap the middlewares of every route. Use must
+// be called before any routes are set.
+func (s *HTTP) Use(middlewares ...Middleware) {
+	for _, mw := range middlewares {
+		mw := mw
+		s.global = append(s.global, mw)
+		s.handler.Use(func(next http.Handler) http.Handler {
+			return mw.Func(next.ServeHTTP)
+		})
+	}
+}
+
+// Routes returns every route set with SetRoute, in the order they were set.
+func (s *HTTP) Routes() []Route {
+	routes := make([]Route, len(s.routes))
+	copy(routes, s.routes)
+	return routes
 }
 
 // SetStatic sets this HTTP server to serve the static asset handler. You may call SetRoute instead without any
 // middlewares, but this method is more explicit.
+//
+// If the route cannot be set, the error is returned by Err.
 func (s *HTTP) SetStatic(pattern string, handler http.HandlerFunc) {
-	s.handler.SetStatic(pattern, handler)
+	if err := s.handler.SetStatic(pattern, handler); err != nil {
+		s.errs = append(s.errs, fmt.Errorf("setting static route at %s: %w", callSite(), err))
+	}
 }
 
-// Start will initiate this HTTP server to listen on its host and port.
+// SetTLS sets this HTTP server to listen with TLS, using the certificate and key in certFile and keyFile. The
+// config may set how client certificates are verified, see ClientCertTLS. If config is nil, the defaults of
+// http.Server are used. SetTLS must be called before Start.
+func (s *HTTP) SetTLS(config *tls.Config, certFile string, keyFile string) {
+	s.httpServer.TLSConfig = config
+	s.certFile = certFile
+	s.keyFile = keyFile
+}
+
+// Start will initiate this HTTP server to listen on its host and port. If SetTLS was called, it listens with TLS.
 func (s *HTTP) Start() error {
+	if s.certFile != "" {
+		return s.httpServer.ListenAndServeTLS(s.certFile, s.keyFile)
+	}
+
 	return s.httpServer.ListenAndServe()
 }
 
+// ServeHTTP serves the request with the routes and global middlewares of this HTTP, so it
+// can be served without listening, such as by a httptest.Server.
+func (s *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
 // Shutdown will stop this HTTP server.
 func (s *HTTP) Shutdown(ctx context.Context) error {
 	return s.httpServer.Shutdown(ctx)
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cicconee/clox/internal/router"
+)
+
+// record returns a Middleware named name that appends name to calls when a request
+// enters it.
+func record(name string, calls *[]string) Middleware {
+	return Named(name, func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name)
+			next(w, r)
+		}
+	})
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	calls := []string{}
+
+	s := New("localhost", "0", router.NewChi())
+	s.Use(record("global0", &calls), record("global1", &calls))
+	s.SetRoute("GET", "/route", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}, record("route0", &calls), record("route1", &calls))
+	if err := s.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+
+	s.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/route", nil))
+
+	want := "global0,global1,route0,route1,handler"
+	if got := strings.Join(calls, ","); got != want {
+		t.Errorf("executed %s, want %s", got, want)
+	}
+
+	routes := s.Routes()
+	if len(routes) != 1 {
+		t.Fatalf("Routes() = %v, want 1 route", routes)
+	}
+
+	// Routes lists the chain in the order it executes, without the handler.
+	want = strings.TrimSuffix(want, ",handler")
+	if got := strings.Join(routes[0].Middlewares, ","); got != want {
+		t.Errorf("Routes() middlewares = %s, want %s", got, want)
+	}
+}
+
+func TestUseUnmatchedRoute(t *testing.T) {
+	calls := []string{}
+
+	s := New("localhost", "0", router.NewChi())
+	s.Use(record("global", &calls))
+	s.SetRoute("GET", "/route", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if got := strings.Join(calls, ","); got != "global" {
+		t.Errorf("executed %q, want the global middleware on unmatched routes", got)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultStreamIdleTimeout is the idle timeout of Stream if one is not set.
+const DefaultStreamIdleTimeout = time.Minute
+
+// Stream returns a Middleware for routes that stream large request or response bodies, such as uploads and
+// downloads. The connection read and write deadlines are extended while bytes are moving, so a slow client is never
+// cut off by a server timeout. A request is aborted once no bytes are read or written for the idle duration.
+//
+// The deadlines are set with http.ResponseController, a middleware that wraps the http.ResponseWriter inside Stream
+// must implement Unwrap. If idle is not positive, it will default to DefaultStreamIdleTimeout.
+func Stream(idle time.Duration) Middleware {
+	if idle <= 0 {
+		idle = DefaultStreamIdleTimeout
+	}
+
+	return Named("server.Stream", func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			m := &streamMonitor{rc: http.NewResponseController(w), idle: idle}
+			m.extend(time.Now())
+
+			// Clear the deadlines so they do not apply to the next request on the connection.
+			defer m.clear()
+
+			r.Body = &streamReader{ReadCloser: r.Body, monitor: m}
+			next(&streamWriter{ResponseWriter: w, monitor: m}, r)
+		}
+	})
+}
+
+// streamMonitor extends the deadlines of a connection while bytes are moving.
+type streamMonitor struct {
+	rc       *http.ResponseController
+	idle     time.Duration
+	extended time.Time
+}
+
+// progress is called after bytes are read or written. The deadlines are extended at most every quarter of the idle
+// duration, so they are not reset on every chunk.
+func (m *streamMonitor) progress() {
+	now := time.Now()
+	if now.Sub(m.extended) < m.idle/4 {
+		return
+	}
+
+	m.extend(now)
+}
+
+// extend sets the read and write deadlines to idle after now. The errors of a http.ResponseWriter that does not
+// support deadlines are ignored, the request is then not aborted when idle.
+func (m *streamMonitor) extend(now time.Time) {
+	m.extended = now
+	deadline := now.Add(m.idle)
+
+	m.rc.SetReadDeadline(deadline)
+	m.rc.SetWriteDeadline(deadline)
+}
+
+// clear removes the read and write deadlines.
+func (m *streamMonitor) clear() {
+	m.rc.SetReadDeadline(time.Time{})
+	m.rc.SetWriteDeadline(time.Time{})
+}
+
+// streamReader is a request body that reports its progress to a streamMonitor.
+type streamReader struct {
+	io.ReadCloser
+	monitor *streamMonitor
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.monitor.progress()
+	}
+
+	return n, err
+}
+
+// streamWriter is a http.ResponseWriter that reports its progress to a streamMonitor.
+type streamWriter struct {
+	http.ResponseWriter
+	monitor *streamMonitor
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if n > 0 {
+		w.monitor.progress()
+	}
+
+	return n, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, it is used by http.ResponseController.
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamResult is what a handler behind Stream read from the request body.
+type streamResult struct {
+	n   int64
+	err error
+}
+
+// newStreamServer starts a server with a read timeout of timeout that reads the request body
+// behind Stream(idle). The result of every read is sent on the returned channel.
+func newStreamServer(t *testing.T, timeout time.Duration, idle time.Duration) (*httptest.Server, <-chan streamResult) {
+	t.Helper()
+
+	results := make(chan streamResult, 1)
+	srv := httptest.NewUnstartedServer(Stream(idle).Func(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		results <- streamResult{n: n, err: err}
+	}))
+	srv.Config.ReadTimeout = timeout
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return srv, results
+}
+
+// upload posts the body written by write to srv in the background. The body is closed
+// once write returns.
+func upload(t *testing.T, srv *httptest.Server, write func(w io.Writer)) {
+	t.Helper()
+
+	pr, pw := io.Pipe()
+	go func() {
+		write(pw)
+		pw.Close()
+	}()
+
+	go func() {
+		resp, err := srv.Client().Post(srv.URL, "application/octet-stream", pr)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+}
+
+func TestStreamSlowClient(t *testing.T) {
+	srv, results := newStreamServer(t, 100*time.Millisecond, 300*time.Millisecond)
+
+	// The upload outlasts the read timeout and the idle duration, but bytes keep moving.
+	chunk := strings.Repeat("a", 1024)
+	upload(t, srv, func(w io.Writer) {
+		for i := 0; i < 10; i++ {
+			io.WriteString(w, chunk)
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	select {
+	case res := <-results:
+		if res.err != nil {
+			t.Fatalf("reading body error = %v, want the slow client not cut off", res.err)
+		}
+
+		if res.n != 10*1024 {
+			t.Errorf("read %d bytes, want %d", res.n, 10*1024)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload did not finish")
+	}
+}
+
+func TestStreamStalledClient(t *testing.T) {
+	srv, results := newStreamServer(t, 100*time.Millisecond, 300*time.Millisecond)
+
+	stalled := make(chan struct{})
+	t.Cleanup(func() { close(stalled) })
+
+	upload(t, srv, func(w io.Writer) {
+		io.WriteString(w, "a")
+		<-stalled
+	})
+
+	select {
+	case res := <-results:
+		if res.err == nil {
+			t.Fatal("reading body error = nil, want the stalled client aborted")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled upload was not aborted")
+	}
+}
+
+func TestStreamNoDeadlines(t *testing.T) {
+	// A http.ResponseWriter that does not support deadlines is still served.
+	h := Stream(0).Func(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("POST", "/", strings.NewReader("hello")))
+
+	if got := rec.Body.String(); got != "hello" {
+		t.Errorf("body = %q, want %q", got, "hello")
+	}
+}
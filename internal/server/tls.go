@@ -0,0 +1,30 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ClientCertTLS creates a tls.Config that verifies the client certificates presented to the
+// server against the PEM encoded CA bundle in caFile. Clients that do not present a
+// certificate are still accepted, so other authentication methods keep working.
+func ClientCertTLS(caFile string) (*tls.Config, error) {
+	bundle, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("client CA bundle has no PEM certificates")
+	}
+
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
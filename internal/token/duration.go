@@ -0,0 +1,87 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+var ErrTokenDuration = errors.New("invalid token duration")
+
+// The minimum and maximum duration a user token may be valid for.
+const (
+	MinDuration = time.Hour
+	MaxDuration = 365 * 24 * time.Hour
+)
+
+// DurationPresets are the named durations that may be used in place of a number of seconds
+// when parsing a token duration.
+var DurationPresets = map[string]time.Duration{
+	"30d": 30 * 24 * time.Hour,
+	"90d": 90 * 24 * time.Hour,
+	"1y":  365 * 24 * time.Hour,
+}
+
+// ParseDuration parses a token duration from s. The value of s must be one of DurationPresets
+// or a positive whole number of seconds. Signs, decimals, and exponents are not accepted.
+//
+// The parsed duration is validated with ValidateDuration. All errors returned by ParseDuration
+// are a app.WrappedSafeError with the field set to field.
+func ParseDuration(s string, field string) (time.Duration, error) {
+	if s == "" {
+		return 0, durationError(field, "Expiration is required.", "duration is empty")
+	}
+
+	if d, ok := DurationPresets[s]; ok {
+		return d, nil
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, durationError(field, "Expiration must be a whole number of seconds.", fmt.Sprintf("duration %q is not a whole number", s))
+		}
+	}
+
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || seconds > int64(MaxDuration/time.Second) {
+		return 0, durationError(field, durationRangeMessage(), fmt.Sprintf("duration %q is out of range", s))
+	}
+
+	d := time.Duration(seconds) * time.Second
+	if err := validateDuration(d, field); err != nil {
+		return 0, err
+	}
+
+	return d, nil
+}
+
+// ValidateDuration returns a app.WrappedSafeError with a 400 status code if d is not between
+// MinDuration and MaxDuration.
+func ValidateDuration(d time.Duration) error {
+	return validateDuration(d, "")
+}
+
+func validateDuration(d time.Duration, field string) error {
+	if d < MinDuration || d > MaxDuration {
+		return durationError(field, durationRangeMessage(), fmt.Sprintf("duration %s is out of range", d))
+	}
+
+	return nil
+}
+
+func durationRangeMessage() string {
+	return fmt.Sprintf("Expiration must be between %d hour and %d days.", MinDuration/time.Hour, MaxDuration/(24*time.Hour))
+}
+
+func durationError(field string, safeMessage string, reason string) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("%w: %s", ErrTokenDuration, reason),
+		SafeMessage: safeMessage,
+		StatusCode:  http.StatusBadRequest,
+		Field:       field,
+	})
+}
@@ -0,0 +1,79 @@
+package token
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "3600", want: time.Hour},
+		{value: "86400", want: 24 * time.Hour},
+		{value: "30d", want: 30 * 24 * time.Hour},
+		{value: "90d", want: 90 * 24 * time.Hour},
+		{value: "1y", want: MaxDuration},
+		{value: "31536000", want: MaxDuration},
+		{value: "", wantErr: true},
+		{value: "3599", wantErr: true},
+		{value: "31536001", wantErr: true},
+		{value: "99999999999999999999", wantErr: true},
+		{value: "+3600", wantErr: true},
+		{value: "-3600", wantErr: true},
+		{value: "3600.5", wantErr: true},
+		{value: "1e4", wantErr: true},
+		{value: " 3600", wantErr: true},
+		{value: "7d", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := ParseDuration(tc.value, "expiration")
+			if !tc.wantErr {
+				if err != nil || got != tc.want {
+					t.Errorf("ParseDuration(%q) = %s, %v, want %s", tc.value, got, err, tc.want)
+				}
+				return
+			}
+
+			var fieldErr app.FieldError
+			if !errors.As(err, &fieldErr) || fieldErr.Field() != "expiration" {
+				t.Fatalf("ParseDuration(%q) error = %v, want a error of the expiration field", tc.value, err)
+			}
+
+			var safeErr app.SafeError
+			if !errors.As(err, &safeErr) {
+				t.Fatalf("ParseDuration(%q) error = %v, want a app.SafeError", tc.value, err)
+			}
+
+			if _, status := safeErr.Safe(); status != http.StatusBadRequest {
+				t.Errorf("ParseDuration(%q) status = %d, want %d", tc.value, status, http.StatusBadRequest)
+			}
+
+			if !errors.Is(err, ErrTokenDuration) {
+				t.Errorf("ParseDuration(%q) error = %v, want it to wrap ErrTokenDuration", tc.value, err)
+			}
+		})
+	}
+}
+
+func TestValidateDuration(t *testing.T) {
+	for _, d := range []time.Duration{MinDuration, MaxDuration} {
+		if err := ValidateDuration(d); err != nil {
+			t.Errorf("ValidateDuration(%s) error = %v", d, err)
+		}
+	}
+
+	for _, d := range []time.Duration{0, MinDuration - time.Second, MaxDuration + time.Second} {
+		if err := ValidateDuration(d); !errors.Is(err, ErrTokenDuration) {
+			t.Errorf("ValidateDuration(%s) error = %v, want ErrTokenDuration", d, err)
+		}
+	}
+}
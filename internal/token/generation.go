@@ -0,0 +1,71 @@
+package token
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// generationTTL is how long the token generation of a user is cached. A generation is
+// cached again when it is incremented, so the TTL only bounds how long a failed cache
+// update leaves the old generation valid.
+const generationTTL = 10 * time.Minute
+
+// generationKey returns the cache key of the token generation of a user.
+func generationKey(userID string) string {
+	return fmt.Sprintf("user:%s:token_generation", userID)
+}
+
+// generation gets the token generation of a user. It is read from the cache, and from
+// the database if it is not cached or the cache cannot be read.
+func (s *Service) generation(ctx context.Context, userID string) (int64, error) {
+	if v, err := s.cache.Get(ctx, generationKey(userID)); err == nil {
+		if gen, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return gen, nil
+		}
+	}
+
+	gen, err := s.repo.SelectGeneration(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// A generation that fails to be cached is read from the database again next time.
+	s.cache.Set(ctx, generationKey(userID), gen, generationTTL)
+
+	return gen, nil
+}
+
+// InvalidateAll invalidates every token of a user instantly by incrementing the token
+// generation of the user. Validate rejects every token created before the increment,
+// including tokens that are not in the database, such as tokens signed with a leaked
+// secret. The tokens are also revoked, so they are no longer listed.
+func (s *Service) InvalidateAll(ctx context.Context, uid string) error {
+	gen, err := s.repo.IncrementGeneration(ctx, uid, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("incrementing token generation [user: %s]: %w", uid, err),
+				SafeMessage: "User not found",
+				StatusCode:  http.StatusNotFound,
+			})
+		}
+
+		return fmt.Errorf("incrementing token generation [user: %s]: %w", uid, err)
+	}
+
+	if err := s.cache.Set(ctx, generationKey(uid), gen, generationTTL); err != nil {
+		// The old generation is valid until the cached value expires, remove it instead.
+		if err := s.cache.Del(ctx, generationKey(uid)); err != nil {
+			return fmt.Errorf("caching token generation [user: %s]: %w", uid, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/internal/jwt"
+)
+
+// newTestCachedService creates a Service on the test database and the test Redis cache,
+// with a new user. The cache is configured by the TEST_REDIS_HOST, TEST_REDIS_PORT,
+// TEST_REDIS_USERNAME, and TEST_REDIS_PASSWORD environment variables. If TEST_REDIS_HOST
+// is not set, t is skipped.
+func newTestCachedService(t *testing.T) (*Service, *cache.Redis, string) {
+	t.Helper()
+
+	host := os.Getenv("TEST_REDIS_HOST")
+	if host == "" {
+		t.Skip("TEST_REDIS_HOST is not set")
+	}
+
+	repo, userID := newTestRepo(t)
+
+	redis := &cache.Redis{}
+	redis.Open(host,
+		os.Getenv("TEST_REDIS_PORT"),
+		os.Getenv("TEST_REDIS_USERNAME"),
+		os.Getenv("TEST_REDIS_PASSWORD"))
+	t.Cleanup(func() {
+		redis.Del(context.Background(), generationKey(userID))
+		redis.Close()
+	})
+
+	if err := redis.Ping(); err != nil {
+		t.Fatalf("pinging test cache: %v", err)
+	}
+
+	jwts := jwt.NewManager("clox-test", "clox-test")
+	jwts.SetSecret("secret")
+
+	return NewService(jwts, redis, repo), redis, userID
+}
+
+// assertRejected fails t if err is not a 401 app.WrappedSafeError wrapping a RejectedError
+// of the user whose reason contains reason.
+func assertRejected(t *testing.T, err error, userID string, reason string) {
+	t.Helper()
+
+	assertStatus(t, err, http.StatusUnauthorized)
+
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("error = %v, want a RejectedError", err)
+	}
+
+	if rejected.UserID != userID || !strings.Contains(rejected.Reason, reason) {
+		t.Errorf("RejectedError = %+v, want user %s rejected for %q", rejected, userID, reason)
+	}
+}
+
+func TestServiceInvalidateAll(t *testing.T) {
+	ctx := context.Background()
+	s, redis, userID := newTestCachedService(t)
+
+	before, err := s.New(ctx, NewParams{UserID: userID, Duration: time.Hour, Name: "before"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := s.Validate(ctx, ValidateParams{Token: before.Token}); err != nil {
+		t.Fatalf("Validate() before the bump error = %v", err)
+	}
+
+	if err := s.InvalidateAll(ctx, userID); err != nil {
+		t.Fatalf("InvalidateAll() error = %v", err)
+	}
+
+	// The new generation is cached, Validate does not read the old one.
+	cached, err := redis.Get(ctx, generationKey(userID))
+	if err != nil {
+		t.Fatalf("getting cached generation: %v", err)
+	}
+
+	if gen, _ := strconv.ParseInt(cached, 10, 64); gen != 1 {
+		t.Errorf("cached generation = %s, want 1", cached)
+	}
+
+	// The tokens of the old generation are revoked, so they are not listed either.
+	_, err = s.Validate(ctx, ValidateParams{Token: before.Token})
+	assertRejected(t, err, userID, "revoked")
+
+	// Tokens created after the bump carry the new generation.
+	after, err := s.New(ctx, NewParams{UserID: userID, Duration: time.Hour, Name: "after"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := s.Validate(ctx, ValidateParams{Token: after.Token}); err != nil {
+		t.Errorf("Validate() after the bump error = %v", err)
+	}
+}
+
+func TestServiceValidateStaleGeneration(t *testing.T) {
+	ctx := context.Background()
+	s, _, userID := newTestCachedService(t)
+
+	if err := s.InvalidateAll(ctx, userID); err != nil {
+		t.Fatalf("InvalidateAll() error = %v", err)
+	}
+
+	active, err := s.New(ctx, NewParams{UserID: userID, Duration: time.Hour, Name: "active"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// A token signed with a leaked secret names an active token, but cannot know the
+	// current generation of the user.
+	now := time.Now().UTC()
+	forged, err := s.jwts.New(jwt.NewTokenClaims{
+		Sub: userID,
+		Exp: now.Add(time.Hour),
+		Nbf: now,
+		Iat: now,
+		Jti: active.TokenID,
+		Gen: 0,
+	})
+	if err != nil {
+		t.Fatalf("signing forged token: %v", err)
+	}
+
+	_, err = s.Validate(ctx, ValidateParams{Token: forged})
+	assertRejected(t, err, userID, "invalidated")
+
+	// Nor can it name a token of another user.
+	other, err := s.jwts.New(jwt.NewTokenClaims{
+		Sub: "someone-else",
+		Exp: now.Add(time.Hour),
+		Nbf: now,
+		Iat: now,
+		Jti: active.TokenID,
+		Gen: 1,
+	})
+	if err != nil {
+		t.Fatalf("signing forged token: %v", err)
+	}
+
+	_, err = s.Validate(ctx, ValidateParams{Token: other})
+	assertRejected(t, err, userID, "another user")
+}
+
+func TestServiceInvalidateAllUserNotFound(t *testing.T) {
+	s, _, _ := newTestCachedService(t)
+
+	err := s.InvalidateAll(context.Background(), "not-a-user")
+	assertStatus(t, err, http.StatusNotFound)
+}
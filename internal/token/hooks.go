@@ -0,0 +1,70 @@
+package token
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/cicconee/clox/internal/hook"
+)
+
+// HookAfterTokenCreated is the hook point after a token is created.
+const HookAfterTokenCreated = "token.AfterTokenCreated"
+
+// Created is a token passed to the AfterTokenCreated callbacks.
+type Created struct {
+	UserID string
+	Kind   Kind
+	Listing
+}
+
+// Hooks are the callbacks run after the Service changes a token. They are run on a hook.Queue,
+// every callback is queued on its own. Callbacks are never passed the signed token.
+//
+// Callbacks should be registered before the Service is used. A nil Hooks is valid, it runs
+// nothing.
+//
+// Hooks should be created using the NewHooks function.
+type Hooks struct {
+	queue *hook.Queue
+
+	mu           sync.RWMutex
+	tokenCreated []func(ctx context.Context, c Created)
+}
+
+// NewHooks creates a new Hooks that runs its callbacks on queue.
+func NewHooks(queue *hook.Queue) *Hooks {
+	return &Hooks{queue: queue}
+}
+
+// AfterTokenCreated registers fn to be called after a token is created, including console
+// tokens.
+func (h *Hooks) AfterTokenCreated(fn func(ctx context.Context, c Created)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tokenCreated = append(h.tokenCreated, fn)
+}
+
+// Log registers callbacks that log every hook point as a key=value line to logger.
+func (h *Hooks) Log(logger *log.Logger) {
+	h.AfterTokenCreated(func(ctx context.Context, c Created) {
+		logger.Printf("[INFO] hook=%s user_id=%s token_id=%s name=%q kind=%s\n",
+			HookAfterTokenCreated, c.UserID, c.TokenID, c.TokenName, c.Kind)
+	})
+}
+
+// runTokenCreated queues the AfterTokenCreated callbacks with c.
+func (h *Hooks) runTokenCreated(c Created) {
+	if h == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, fn := range h.tokenCreated {
+		fn := fn
+		h.queue.Enqueue(HookAfterTokenCreated, func(ctx context.Context) { fn(ctx, c) })
+	}
+}
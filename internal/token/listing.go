@@ -1,6 +1,21 @@
 package token
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// Kind is the kind of a token.
+type Kind string
+
+const (
+	// KindUser is a token created by a user. User tokens are displayed in the token listings.
+	KindUser Kind = "user"
+
+	// KindConsole is a short-lived token minted for the API request console. Console tokens are
+	// excluded from the token listings.
+	KindConsole Kind = "console"
+)
 
 // NewListing is a token and its listing information. NewListing is returned when a new token
 // is created.
@@ -12,6 +27,16 @@ type NewListing struct {
 	Listing
 }
 
+// Principal is the user and token that authenticated a request. Principal is returned when a
+// token is validated.
+type Principal struct {
+	// The user ID (sub) of the token.
+	UserID string
+
+	// The listing information of the token.
+	Token Listing
+}
+
 // Listing is the relevant token information for the end user.
 type Listing struct {
 	// The token ID (jti).
@@ -28,6 +53,12 @@ type Listing struct {
 
 	// The last time this token was used in UTC time.
 	LastUsed time.Time
+
+	// The CIDRs this token may be used from. If empty, the token may be used from any address.
+	AllowedIPs []string
+
+	// The origins this token may be used from. If empty, the token may be used from any origin.
+	AllowedOrigins []string
 }
 
 // Returns this Listing's ExpiresAt field as a string formatted as "2006-01-02T15:04:05Z07:00".
@@ -44,3 +75,23 @@ func (l *Listing) IssuedAtString() string {
 func (l *Listing) LastUsedString() string {
 	return l.LastUsed.Format(time.RFC3339)
 }
+
+// Returns this Listing's AllowedIPs as a comma separated string. If there are no allowed IPs,
+// "Any" is returned.
+func (l *Listing) AllowedIPsString() string {
+	if len(l.AllowedIPs) == 0 {
+		return "Any"
+	}
+
+	return strings.Join(l.AllowedIPs, ", ")
+}
+
+// Returns this Listing's AllowedOrigins as a comma separated string. If there are no allowed
+// origins, "Any" is returned.
+func (l *Listing) AllowedOriginsString() string {
+	if len(l.AllowedOrigins) == 0 {
+		return "Any"
+	}
+
+	return strings.Join(l.AllowedOrigins, ", ")
+}
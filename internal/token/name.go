@@ -0,0 +1,54 @@
+package token
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// MaxNameLength is the maximum number of characters in a token name.
+const MaxNameLength = 64
+
+// SanitizeName removes all control characters from name and trims the surrounding white space.
+// Control characters include carriage returns and line feeds, so a sanitized name is safe to
+// write to headers, cookies, and logs.
+//
+// If the sanitized name is empty, not valid UTF-8, or longer than MaxNameLength characters, a
+// app.WrappedSafeError with a 400 status code is returned.
+func SanitizeName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", nameError("Token name must be valid text.", "token name is not valid utf-8")
+	}
+
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+
+		return r
+	}, name))
+
+	if name == "" {
+		return "", nameError("Token name cannot be empty.", "token name is empty")
+	}
+
+	if n := utf8.RuneCountInString(name); n > MaxNameLength {
+		return "", nameError(
+			fmt.Sprintf("Token name cannot be longer than %d characters.", MaxNameLength),
+			fmt.Sprintf("token name is %d characters", n))
+	}
+
+	return name, nil
+}
+
+func nameError(safeMessage string, reason string) error {
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("%w: %s", ErrTokenName, reason),
+		SafeMessage: safeMessage,
+		StatusCode:  http.StatusBadRequest,
+	})
+}
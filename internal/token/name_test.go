@@ -0,0 +1,57 @@
+package token
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "deploy", want: "deploy"},
+		{name: "  deploy key  ", want: "deploy key"},
+		{name: "deploy\r\nSet-Cookie: a=b", want: "deploySet-Cookie: a=b"},
+		{name: "\tdeploy\x00\x1b[31m", want: "deploy[31m"},
+		{name: "<script>alert(1)</script>", want: "<script>alert(1)</script>"},
+		{name: "clé de déploiement", want: "clé de déploiement"},
+		{name: strings.Repeat("é", MaxNameLength), want: strings.Repeat("é", MaxNameLength)},
+	}
+
+	for _, tc := range tests {
+		got, err := SanitizeName(tc.name)
+		if err != nil || got != tc.want {
+			t.Errorf("SanitizeName(%q) = %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestSanitizeNameInvalid(t *testing.T) {
+	for _, name := range []string{
+		"",
+		"   ",
+		"\r\n\x00",
+		"deploy\xff",
+		strings.Repeat("a", MaxNameLength+1),
+		strings.Repeat("a", 10<<10),
+	} {
+		_, err := SanitizeName(name)
+		if !errors.Is(err, ErrTokenName) {
+			t.Fatalf("SanitizeName(%q) error = %v, want it to wrap ErrTokenName", name, err)
+		}
+
+		var safeErr app.SafeError
+		if !errors.As(err, &safeErr) {
+			t.Fatalf("SanitizeName(%q) error = %v, want a app.SafeError", name, err)
+		}
+
+		if _, status := safeErr.Safe(); status != http.StatusBadRequest {
+			t.Errorf("SanitizeName(%q) status = %d, want %d", name, status, http.StatusBadRequest)
+		}
+	}
+}
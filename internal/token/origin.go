@@ -0,0 +1,70 @@
+package token
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides if a token with allowed origins may be used by a request without an
+// Origin header. Browsers send the header with every cross-origin request, so only clients
+// outside a browser, such as scripts, leave it out.
+type OriginPolicy string
+
+const (
+	// OriginLenient accepts a token with allowed origins from a request without an Origin
+	// header. It is the default.
+	OriginLenient OriginPolicy = "lenient"
+
+	// OriginStrict rejects a token with allowed origins from a request without an Origin
+	// header, so the token can only be used by a page of an allowed origin.
+	OriginStrict OriginPolicy = "strict"
+)
+
+// ParseOriginPolicy parses the name of a OriginPolicy, "lenient" or "strict". If value is empty,
+// OriginLenient is returned. The error names variable, the name of the variable value was read
+// from.
+func ParseOriginPolicy(value string, variable string) (OriginPolicy, error) {
+	switch p := OriginPolicy(value); p {
+	case "":
+		return OriginLenient, nil
+	case OriginLenient, OriginStrict:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q: must be %q or %q", variable, value, OriginLenient, OriginStrict)
+	}
+}
+
+// ParseOrigins parses every value in s as a http or https origin, such as "https://example.com"
+// or "http://localhost:8080". The origins are normalized to the form browsers send in the
+// Origin header: the scheme and host are lowercase and the default port is removed. Empty
+// values are ignored.
+func ParseOrigins(s []string) ([]string, error) {
+	origins := []string{}
+
+	for _, v := range s {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" || u.Opaque != "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid origin %q", v)
+		}
+
+		scheme := strings.ToLower(u.Scheme)
+		if scheme != "http" && scheme != "https" {
+			return nil, fmt.Errorf("invalid origin %q: scheme must be http or https", v)
+		}
+
+		host := strings.ToLower(u.Host)
+		if port := u.Port(); (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+			host = strings.TrimSuffix(host, ":"+port)
+		}
+
+		origins = append(origins, scheme+"://"+host)
+	}
+
+	return origins, nil
+}
@@ -3,11 +3,14 @@ package token
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/pagination"
+	"github.com/lib/pq"
 )
 
 // Repo is the token repository.
@@ -30,25 +33,49 @@ type Row struct {
 	LastUsed  sql.NullTime
 	UserID    string
 	DeletedAt sql.NullTime
+
+	// AllowedIPs are the CIDRs the token may be used from. An empty slice means the token can
+	// be used from any address.
+	AllowedIPs []string
+
+	// AllowedOrigins are the origins the token may be used from, see ParseOrigins. An empty slice
+	// means the token can be used from any origin.
+	AllowedOrigins []string
+
+	// Kind is the kind of token. If empty, it is persisted as KindUser.
+	Kind Kind
 }
 
-// listing returns this row as a Listing.
+// listing returns this row as a Listing. Listing.AllowedIPs and Listing.AllowedOrigins are never
+// nil.
 func (r *Row) listing() Listing {
+	allowedIPs := r.AllowedIPs
+	if allowedIPs == nil {
+		allowedIPs = []string{}
+	}
+
+	allowedOrigins := r.AllowedOrigins
+	if allowedOrigins == nil {
+		allowedOrigins = []string{}
+	}
+
 	return Listing{
-		TokenID:   r.ID,
-		TokenName: r.Name,
-		ExpiresAt: r.ExpiresAt,
-		IssuedAt:  r.IssuedAt,
-		LastUsed:  r.LastUsed.Time,
+		TokenID:        r.ID,
+		TokenName:      r.Name,
+		ExpiresAt:      r.ExpiresAt,
+		IssuedAt:       r.IssuedAt,
+		LastUsed:       r.LastUsed.Time,
+		AllowedIPs:     allowedIPs,
+		AllowedOrigins: allowedOrigins,
 	}
 }
 
 // Rows is a Row slice.
 type Rows []Row
 
-// listings returns this Rows as a Listing slice.
+// listings returns this Rows as a Listing slice. The slice is never nil.
 func (r Rows) listings() []Listing {
-	var listings []Listing
+	listings := []Listing{}
 
 	for _, v := range r {
 		listing := v.listing()
@@ -58,26 +85,94 @@ func (r Rows) listings() []Listing {
 	return listings
 }
 
+// ErrTokenLimit is returned by InsertLimited when the user already has the max number of
+// active tokens.
+var ErrTokenLimit = errors.New("token limit reached")
+
+// execer executes queries. It is the database connection of a Repo, or a transaction.
+type execer interface {
+	QueryRow(ctx context.Context, query string, args ...any) *sql.Row
+	Exec(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
 // Insert inserts a new row into the database.
 func (r *Repo) Insert(ctx context.Context, row Row) error {
-	query := `INSERT INTO user_tokens(token_id, token_name, expires_at, issued_at, last_used, user_id)
-		VALUES($1, $2, $3, $4, $5, $6)`
+	return insert(ctx, r.db, row)
+}
+
+// InsertLimited inserts a new row into the database if the user of the row has less than
+// max active tokens, see CountActive. The number of active tokens before the insert is
+// returned. If the user already has max, the row is not inserted and ErrTokenLimit is
+// returned.
+//
+// The count and the insert run in one transaction that holds an advisory lock on the user,
+// so concurrent inserts for a user can never exceed max.
+func (r *Repo) InsertLimited(ctx context.Context, row Row, max int) (int, error) {
+	tx, err := r.db.Tx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "user_tokens:"+row.UserID); err != nil {
+		return 0, fmt.Errorf("locking user tokens: %w", err)
+	}
+
+	n, err := countActive(ctx, tx, row.UserID)
+	if err != nil {
+		return 0, err
+	}
 
-	_, err := r.db.Exec(ctx, query,
+	if n >= max {
+		return n, ErrTokenLimit
+	}
+
+	if err := insert(ctx, tx, row); err != nil {
+		return n, err
+	}
+
+	return n, tx.Commit()
+}
+
+// insert inserts a new row with db.
+func insert(ctx context.Context, db execer, row Row) error {
+	query := `INSERT INTO user_tokens(token_id, token_name, expires_at, issued_at, last_used, user_id, allowed_ips, allowed_origins, kind)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	allowedIPs := row.AllowedIPs
+	if allowedIPs == nil {
+		allowedIPs = []string{}
+	}
+
+	allowedOrigins := row.AllowedOrigins
+	if allowedOrigins == nil {
+		allowedOrigins = []string{}
+	}
+
+	kind := row.Kind
+	if kind == "" {
+		kind = KindUser
+	}
+
+	_, err := db.Exec(ctx, query,
 		row.ID,
 		row.Name,
 		row.ExpiresAt,
 		row.IssuedAt,
 		row.LastUsed,
-		row.UserID)
+		row.UserID,
+		pq.Array(allowedIPs),
+		pq.Array(allowedOrigins),
+		kind)
 
 	return err
 }
 
-// SelectAll reads all the tokens from the database that have not been deleted for a specific user id.
+// SelectAll reads all the user tokens (KindUser) from the database that have not been deleted for a
+// specific user id.
 func (r *Repo) SelectAll(ctx context.Context, userID string) (Rows, error) {
-	query := `SELECT token_id, token_name, expires_at, issued_at, last_used, user_id FROM user_tokens
-		WHERE user_id = $1 AND deleted_at IS NULL`
+	query := `SELECT token_id, token_name, expires_at, issued_at, last_used, user_id, allowed_ips, allowed_origins FROM user_tokens
+		WHERE user_id = $1 AND deleted_at IS NULL AND kind = 'user'`
 
 	rows, err := r.db.Query(ctx, query, userID)
 	if err != nil {
@@ -85,6 +180,43 @@ func (r *Repo) SelectAll(ctx context.Context, userID string) (Rows, error) {
 	}
 	defer rows.Close()
 
+	return scanRows(rows)
+}
+
+// PageKey is the ordering key of a token when paginating. Tokens are ordered newest first.
+type PageKey struct {
+	IssuedAt time.Time `json:"issued_at"`
+	ID       string    `json:"id"`
+}
+
+// SelectPage reads at most limit user tokens (KindUser) from the database that have not been deleted
+// for a specific user id, ordered newest first. If after is not nil, only the tokens after it are read.
+func (r *Repo) SelectPage(ctx context.Context, userID string, after *PageKey, limit int) (Rows, error) {
+	columns := []string{"issued_at", "token_id"}
+	args := []any{userID}
+
+	where := "user_id = $1 AND deleted_at IS NULL AND kind = 'user'"
+	if after != nil {
+		where += " AND " + pagination.KeysetWhere(columns, pagination.Desc, 2)
+		args = append(args, after.IssuedAt, after.ID)
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`SELECT token_id, token_name, expires_at, issued_at, last_used, user_id, allowed_ips, allowed_origins FROM user_tokens
+		WHERE %s ORDER BY %s LIMIT $%d`, where, pagination.KeysetOrderBy(columns, pagination.Desc), len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRows(rows)
+}
+
+// scanRows scans every row in rows into a Rows. The columns must be token_id, token_name, expires_at,
+// issued_at, last_used, user_id, allowed_ips, and allowed_origins.
+func scanRows(rows *sql.Rows) (Rows, error) {
 	var tokenRows Rows
 	for rows.Next() {
 		var row Row
@@ -95,7 +227,9 @@ func (r *Repo) SelectAll(ctx context.Context, userID string) (Rows, error) {
 			&row.ExpiresAt,
 			&row.IssuedAt,
 			&row.LastUsed,
-			&row.UserID)
+			&row.UserID,
+			pq.Array(&row.AllowedIPs),
+			pq.Array(&row.AllowedOrigins))
 		if err != nil {
 			return nil, err
 		}
@@ -103,12 +237,12 @@ func (r *Repo) SelectAll(ctx context.Context, userID string) (Rows, error) {
 		tokenRows = append(tokenRows, row)
 	}
 
-	return tokenRows, nil
+	return tokenRows, rows.Err()
 }
 
 // Select reads a single token row from the database.
 func (r *Repo) Select(ctx context.Context, id string) (Row, error) {
-	query := `SELECT token_id, token_name, expires_at, issued_at, last_used, user_id, deleted_at FROM user_tokens
+	query := `SELECT token_id, token_name, expires_at, issued_at, last_used, user_id, deleted_at, allowed_ips, allowed_origins, kind FROM user_tokens
 		WHERE token_id = $1`
 
 	var row Row
@@ -120,11 +254,56 @@ func (r *Repo) Select(ctx context.Context, id string) (Row, error) {
 		&row.LastUsed,
 		&row.UserID,
 		&row.DeletedAt,
+		pq.Array(&row.AllowedIPs),
+		pq.Array(&row.AllowedOrigins),
+		&row.Kind,
 	)
 
 	return row, err
 }
 
+// CountActive counts the user tokens of the user userID that are not deleted or expired.
+// Console tokens are not counted.
+func (r *Repo) CountActive(ctx context.Context, userID string) (int, error) {
+	return countActive(ctx, r.db, userID)
+}
+
+// countActive counts the active user tokens of a user with db, see CountActive.
+func countActive(ctx context.Context, db execer, userID string) (int, error) {
+	query := `SELECT COUNT(*) FROM user_tokens
+			  WHERE user_id = $1 AND kind = $2 AND deleted_at IS NULL AND expires_at > NOW()`
+
+	var n int
+	err := db.QueryRow(ctx, query, userID, KindUser).Scan(&n)
+
+	return n, err
+}
+
+// SelectOriginAllowed reads if any token that is not deleted or expired at t allows origin.
+func (r *Repo) SelectOriginAllowed(ctx context.Context, origin string, t time.Time) (bool, error) {
+	query := `SELECT EXISTS (
+				  SELECT 1 FROM user_tokens
+				  WHERE allowed_origins @> ARRAY[$1]::TEXT[] AND deleted_at IS NULL AND expires_at > $2
+			  )`
+
+	var allowed bool
+	err := r.db.QueryRow(ctx, query, origin, t).Scan(&allowed)
+
+	return allowed, err
+}
+
+// DeleteExpired deletes every token of kind that expired before t.
+func (r *Repo) DeleteExpired(ctx context.Context, kind Kind, t time.Time) (int64, error) {
+	query := `DELETE FROM user_tokens WHERE kind = $1 AND expires_at < $2`
+
+	result, err := r.db.Exec(ctx, query, kind, t)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 // UpdateDeletedAt sets the deleted_at column with value t where token_id is id.
 func (r *Repo) UpdateDeletedAt(ctx context.Context, id string, t time.Time) error {
 	// query := `UPDATE user_tokens SET deleted_at = $1 WHERE token_id = $2`
@@ -135,6 +314,21 @@ func (r *Repo) UpdateDeletedAt(ctx context.Context, id string, t time.Time) erro
 	})
 }
 
+// UpdateLastUsed sets the last_used column with value t where token_id is id, unless
+// last_used is after since. It returns true if the row was updated.
+func (r *Repo) UpdateLastUsed(ctx context.Context, id string, t time.Time, since time.Time) (bool, error) {
+	query := `UPDATE user_tokens SET last_used = $1
+			  WHERE token_id = $2 AND (last_used IS NULL OR last_used <= $3)`
+
+	result, err := r.db.Exec(ctx, query, t, id, since)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
 // Update updates a user_tokens row using the updates map where token_id is id.
 //
 // The updates map key value corresponds to the column names, and the values will be the
@@ -160,3 +354,35 @@ func (r *Repo) Update(ctx context.Context, id string, updates map[string]interfa
 	_, err := r.db.Exec(ctx, query, args...)
 	return err
 }
+
+// SelectGeneration selects the token generation of a user.
+func (r *Repo) SelectGeneration(ctx context.Context, userID string) (int64, error) {
+	query := `SELECT token_generation FROM users WHERE id = $1`
+
+	var gen int64
+	if err := r.db.QueryRow(ctx, query, userID).Scan(&gen); err != nil {
+		return 0, err
+	}
+
+	return gen, nil
+}
+
+// IncrementGeneration increments the token generation of a user and returns the new
+// generation. Every token of the user that is not deleted has its deleted_at column set to
+// t in the same statement, so the listings do not show the tokens the increment invalidated.
+func (r *Repo) IncrementGeneration(ctx context.Context, userID string, t time.Time) (int64, error) {
+	query := `WITH revoked AS (
+				  UPDATE user_tokens SET deleted_at = $2 WHERE user_id = $1 AND deleted_at IS NULL
+			  )
+			  UPDATE users
+			  SET token_generation = token_generation + 1
+			  WHERE id = $1
+			  RETURNING token_generation`
+
+	var gen int64
+	if err := r.db.QueryRow(ctx, query, userID, t).Scan(&gen); err != nil {
+		return 0, err
+	}
+
+	return gen, nil
+}
@@ -0,0 +1,237 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/pkg/random"
+)
+
+// newTestRepo opens a Repo on the test database with a new user. The user and its tokens
+// are deleted when t finishes.
+func newTestRepo(t *testing.T) (*Repo, string) {
+	t.Helper()
+
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	userID := random.ID(16)
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	return NewRepo(p), userID
+}
+
+// testRow returns a token row of kind for a user that expires at exp.
+func testRow(userID string, kind Kind, exp time.Time) Row {
+	return Row{
+		ID:        random.ID(32),
+		Name:      "test",
+		ExpiresAt: exp,
+		IssuedAt:  time.Now().UTC(),
+		UserID:    userID,
+		Kind:      kind,
+	}
+}
+
+func TestInsertLimited(t *testing.T) {
+	const max = 3
+
+	ctx := context.Background()
+	active := time.Now().UTC().Add(time.Hour)
+	expired := time.Now().UTC().Add(-time.Hour)
+
+	tests := []struct {
+		name string
+
+		// setup inserts the tokens the user has before InsertLimited.
+		setup   func(t *testing.T, r *Repo, userID string)
+		wantN   int
+		wantErr error
+	}{
+		{
+			name:  "no tokens",
+			setup: func(t *testing.T, r *Repo, userID string) {},
+			wantN: 0,
+		},
+		{
+			name: "one below the limit",
+			setup: func(t *testing.T, r *Repo, userID string) {
+				insertRows(t, r, userID, KindUser, active, max-1)
+			},
+			wantN: max - 1,
+		},
+		{
+			name: "at the limit",
+			setup: func(t *testing.T, r *Repo, userID string) {
+				insertRows(t, r, userID, KindUser, active, max)
+			},
+			wantN:   max,
+			wantErr: ErrTokenLimit,
+		},
+		{
+			name: "expired tokens are not counted",
+			setup: func(t *testing.T, r *Repo, userID string) {
+				insertRows(t, r, userID, KindUser, active, max-1)
+				insertRows(t, r, userID, KindUser, expired, max)
+			},
+			wantN: max - 1,
+		},
+		{
+			name: "revoked tokens are not counted",
+			setup: func(t *testing.T, r *Repo, userID string) {
+				insertRows(t, r, userID, KindUser, active, max-1)
+				for _, id := range insertRows(t, r, userID, KindUser, active, max) {
+					if err := r.UpdateDeletedAt(ctx, id, time.Now().UTC()); err != nil {
+						t.Fatalf("revoking token: %v", err)
+					}
+				}
+			},
+			wantN: max - 1,
+		},
+		{
+			name: "console tokens are not counted",
+			setup: func(t *testing.T, r *Repo, userID string) {
+				insertRows(t, r, userID, KindUser, active, max-1)
+				insertRows(t, r, userID, KindConsole, active, max)
+			},
+			wantN: max - 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, userID := newTestRepo(t)
+			tc.setup(t, r, userID)
+
+			n, err := r.InsertLimited(ctx, testRow(userID, KindUser, active), max)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("InsertLimited() error = %v, want %v", err, tc.wantErr)
+			}
+
+			if n != tc.wantN {
+				t.Errorf("InsertLimited() = %d, want %d", n, tc.wantN)
+			}
+
+			wantCount := tc.wantN + 1
+			if tc.wantErr != nil {
+				wantCount = tc.wantN
+			}
+
+			if count := countTokens(t, r, userID); count != wantCount {
+				t.Errorf("active tokens = %d, want %d", count, wantCount)
+			}
+		})
+	}
+}
+
+func TestInsertLimitedConcurrent(t *testing.T) {
+	const max = 5
+
+	ctx := context.Background()
+	r, userID := newTestRepo(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*max)
+	for i := 0; i < 4*max; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := r.InsertLimited(ctx, testRow(userID, KindUser, time.Now().UTC().Add(time.Hour)), max)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	inserted := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			inserted++
+		case !errors.Is(err, ErrTokenLimit):
+			t.Errorf("InsertLimited() error = %v", err)
+		}
+	}
+
+	if inserted != max {
+		t.Errorf("inserted %d tokens, want %d", inserted, max)
+	}
+
+	if count := countTokens(t, r, userID); count != max {
+		t.Errorf("active tokens = %d, want %d", count, max)
+	}
+}
+
+// insertRows inserts n tokens of kind for a user that expire at exp, and returns their IDs.
+func insertRows(t *testing.T, r *Repo, userID string, kind Kind, exp time.Time, n int) []string {
+	t.Helper()
+
+	ids := []string{}
+	for i := 0; i < n; i++ {
+		row := testRow(userID, kind, exp)
+		if err := r.Insert(context.Background(), row); err != nil {
+			t.Fatalf("inserting token: %v", err)
+		}
+		ids = append(ids, row.ID)
+	}
+
+	return ids
+}
+
+// countTokens counts the active user tokens of a user.
+func countTokens(t *testing.T, r *Repo, userID string) int {
+	t.Helper()
+
+	n, err := r.CountActive(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("counting tokens: %v", err)
+	}
+
+	return n
+}
+
+func TestRepoCountActive(t *testing.T) {
+	r, userID := newTestRepo(t)
+	active := time.Now().UTC().Add(time.Hour)
+
+	insertRows(t, r, userID, KindUser, active, 2)
+	insertRows(t, r, userID, KindUser, time.Now().UTC().Add(-time.Hour), 1)
+	insertRows(t, r, userID, KindConsole, active, 1)
+
+	deleted := insertRows(t, r, userID, KindUser, active, 1)
+	if err := r.UpdateDeletedAt(context.Background(), deleted[0], time.Now().UTC()); err != nil {
+		t.Fatalf("UpdateDeletedAt() error = %v", err)
+	}
+
+	// Only the active user tokens are counted.
+	if n, err := r.CountActive(context.Background(), userID); err != nil || n != 2 {
+		t.Errorf("CountActive() = %d, %v, want 2", n, err)
+	}
+
+	if n, err := r.CountActive(context.Background(), random.ID(16)); err != nil || n != 0 {
+		t.Errorf("CountActive() of a user without tokens = %d, %v, want 0", n, err)
+	}
+}
+
+func TestRowListingsEmpty(t *testing.T) {
+	row := testRow("user", KindUser, time.Now().UTC())
+
+	listing := row.listing()
+	if listing.AllowedIPs == nil || listing.AllowedOrigins == nil {
+		t.Errorf("listing() = %+v, want empty allowed IPs and origins, not nil", listing)
+	}
+
+	if listings := Rows(nil).listings(); listings == nil {
+		t.Error("listings() of no rows = nil, want an empty slice")
+	}
+}
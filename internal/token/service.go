@@ -5,7 +5,10 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/cicconee/clox/internal/app"
@@ -16,6 +19,33 @@ import (
 
 var ErrTokenName = errors.New("invalid token name")
 
+// RejectedError is the error of a token of a known user that was rejected, such as a revoked
+// token. The error of a token that cannot be attributed to a user is never a RejectedError.
+type RejectedError struct {
+	UserID string
+
+	// Reason is why the token was rejected. It is safe to show to the owner of the token.
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("token rejected [user: %s]: %s", e.UserID, e.Reason)
+}
+
+// ConsoleTokenDuration is the duration a console token is valid for.
+const ConsoleTokenDuration = 15 * time.Minute
+
+// DefaultMaxTokensPerUser is the default number of active tokens a user may have, see
+// SetMaxTokensPerUser.
+const DefaultMaxTokensPerUser = 10
+
+// lastUsedTimeout is how long updating the last used time of a token may take.
+const lastUsedTimeout = 5 * time.Second
+
+// lastUsedInterval is how old the last used time of a token must be before it is updated
+// again, so a token used for many requests is not written on every one of them.
+const lastUsedInterval = time.Minute
+
 // Service controls token creation, revocation, and listings.
 type Service struct {
 	// jwts creates and validates JWT's.
@@ -26,11 +56,78 @@ type Service struct {
 
 	// repo executes database queries for tokens.
 	repo *Repo
+
+	// hooks are run after tokens are created. If nil, no hooks are run.
+	hooks *Hooks
+
+	// log logs the errors of updating the last used time of tokens.
+	log *log.Logger
+
+	// maxTokens is the number of active tokens a user may have. If it is not positive, the
+	// number of tokens is not limited.
+	maxTokens int
+
+	// now returns the current time, the last used time of a token is set with it.
+	now func() time.Time
+
+	// updateLastUsed updates the last used time of a token, see Repo.UpdateLastUsed.
+	updateLastUsed func(ctx context.Context, id string, t time.Time, since time.Time) (bool, error)
+
+	// touching holds the IDs of the tokens whose last used time is being updated.
+	touching sync.Map
 }
 
-// NewService creates a new Service.
+// NewService creates a new Service. Errors in the background are logged with log.Default(),
+// see SetLog.
 func NewService(jwts *jwt.Manager, cache *cache.Redis, repo *Repo) *Service {
-	return &Service{jwts: jwts, cache: cache, repo: repo}
+	return &Service{
+		jwts:           jwts,
+		cache:          cache,
+		repo:           repo,
+		log:            log.Default(),
+		maxTokens:      DefaultMaxTokensPerUser,
+		now:            time.Now,
+		updateLastUsed: repo.UpdateLastUsed,
+	}
+}
+
+// SetMaxTokensPerUser sets the number of active tokens a user may have, DefaultMaxTokensPerUser
+// by default. If n is not positive, the number of tokens is not limited. It should be called
+// before the Service is used.
+func (s *Service) SetMaxTokensPerUser(n int) {
+	s.maxTokens = n
+}
+
+// SetLog sets the logger errors in the background are logged with. It should be called
+// before the Service is used.
+func (s *Service) SetLog(logger *log.Logger) {
+	s.log = logger
+}
+
+// SetHooks sets the Hooks run after tokens are created. It should be called before the Service
+// is used.
+func (s *Service) SetHooks(hooks *Hooks) {
+	s.hooks = hooks
+}
+
+// NewParams is the parameters when creating a new token.
+type NewParams struct {
+	// The user ID (sub) of the user the token is created for.
+	UserID string
+
+	// The duration the token will be valid for.
+	Duration time.Duration
+
+	// The token name. This is used to identify the token to the user.
+	Name string
+
+	// The IP addresses or CIDRs the token may be used from. If empty, the token can be used from
+	// any address.
+	AllowedIPs []string
+
+	// The origins the token may be used from, such as "https://example.com". If empty, the token
+	// can be used from any origin.
+	AllowedOrigins []string
 }
 
 // New creates a new token and writes it to the database. The token and its relevant data is
@@ -40,18 +137,83 @@ func NewService(jwts *jwt.Manager, cache *cache.Redis, repo *Repo) *Service {
 // (jti) will be generated for the token. All time claims (exp, nbf, iat) and times related to the token
 // are UTC times.
 //
-// The token name is used to identify the token to the user. It is not part of the token.
-func (s *Service) New(ctx context.Context, uid string, dur time.Duration, name string) (NewListing, error) {
-	// TODO: Trim token space, maybe restrict special characters.
+// The token name is used to identify the token to the user. It is not part of the token. The
+// name is sanitized with SanitizeName before it is persisted.
+//
+// The duration must be between MinDuration and MaxDuration.
+//
+// If AllowedIPs is set, every value must be a valid IP address or CIDR. They are normalized to CIDR
+// notation before being persisted.
+//
+// If AllowedOrigins is set, every value must be a valid origin. They are normalized with
+// ParseOrigins before being persisted.
+//
+// A user may have at most the max tokens per user that are not revoked or expired, see
+// SetMaxTokensPerUser. If the user already has as many, a 422 app.WrappedSafeError is
+// returned. Console tokens are not counted. The count and the insert are atomic, concurrent
+// calls for a user cannot exceed the max.
+func (s *Service) New(ctx context.Context, p NewParams) (NewListing, error) {
+	if err := ValidateDuration(p.Duration); err != nil {
+		return NewListing{}, err
+	}
 
-	if name == "" {
+	return s.new(ctx, p, KindUser)
+}
+
+// NewConsole creates a token of kind KindConsole for a user. Console tokens are valid for
+// ConsoleTokenDuration and are not included in the token listings.
+//
+// Every call to NewConsole deletes the console tokens that have expired.
+func (s *Service) NewConsole(ctx context.Context, uid string) (NewListing, error) {
+	if _, err := s.repo.DeleteExpired(ctx, KindConsole, time.Now().UTC()); err != nil {
+		return NewListing{}, fmt.Errorf("deleting expired console tokens: %w", err)
+	}
+
+	return s.new(ctx, NewParams{
+		UserID:   uid,
+		Duration: ConsoleTokenDuration,
+		Name:     "Console",
+	}, KindConsole)
+}
+
+// new creates a new token of kind and writes it to the database.
+func (s *Service) new(ctx context.Context, p NewParams, kind Kind) (NewListing, error) {
+	uid, dur := p.UserID, p.Duration
+
+	name, err := SanitizeName(p.Name)
+	if err != nil {
+		return NewListing{}, err
+	}
+
+	allowedNets, err := app.ParseCIDRs(p.AllowedIPs)
+	if err != nil {
+		return NewListing{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("parsing allowed ips: %w", err),
+			SafeMessage: fmt.Sprintf("Allowed IPs must be IP addresses or CIDRs: %v", err),
+			StatusCode:  http.StatusBadRequest,
+		})
+	}
+
+	allowedIPs := []string{}
+	for _, n := range allowedNets {
+		allowedIPs = append(allowedIPs, n.String())
+	}
+
+	allowedOrigins, err := ParseOrigins(p.AllowedOrigins)
+	if err != nil {
 		return NewListing{}, app.Wrap(app.WrapParams{
-			Err:         fmt.Errorf("%w: token name is empty", ErrTokenName),
-			SafeMessage: "Token name cannot be empty.",
+			Err:         fmt.Errorf("parsing allowed origins: %w", err),
+			SafeMessage: fmt.Sprintf("Allowed origins must be http or https origins, such as https://example.com: %v", err),
 			StatusCode:  http.StatusBadRequest,
+			Field:       "allowedOrigins",
 		})
 	}
 
+	gen, err := s.generation(ctx, uid)
+	if err != nil {
+		return NewListing{}, fmt.Errorf("getting token generation: %w", err)
+	}
+
 	now := time.Now().UTC()
 	exp := now.Add(dur)
 	jti := random.ID(32)
@@ -62,29 +224,78 @@ func (s *Service) New(ctx context.Context, uid string, dur time.Duration, name s
 		Nbf: now,
 		Iat: now,
 		Jti: jti,
+		Gen: gen,
 	})
 	if err != nil {
 		return NewListing{}, err
 	}
 
 	row := Row{
-		ID:        jti,
-		Name:      name,
-		ExpiresAt: exp,
-		IssuedAt:  now,
-		LastUsed:  sql.NullTime{Valid: false},
-		UserID:    uid,
+		ID:             jti,
+		Name:           name,
+		ExpiresAt:      exp,
+		IssuedAt:       now,
+		LastUsed:       sql.NullTime{Valid: false},
+		UserID:         uid,
+		AllowedIPs:     allowedIPs,
+		AllowedOrigins: allowedOrigins,
+		Kind:           kind,
 	}
-	if err = s.repo.Insert(ctx, row); err != nil {
-		return NewListing{}, fmt.Errorf("inserting token: %w", err)
+	if err := s.insert(ctx, row); err != nil {
+		return NewListing{}, err
 	}
 
+	listing := row.listing()
+	s.hooks.runTokenCreated(Created{UserID: uid, Kind: kind, Listing: listing})
+
 	return NewListing{
 		Token:   token,
-		Listing: row.listing(),
+		Listing: listing,
 	}, nil
 }
 
+// insert writes row to the database. User tokens are only inserted if the user has less than
+// the max tokens per user, otherwise a 422 app.WrappedSafeError is returned.
+func (s *Service) insert(ctx context.Context, row Row) error {
+	if row.Kind != KindUser || s.maxTokens <= 0 {
+		if err := s.repo.Insert(ctx, row); err != nil {
+			return fmt.Errorf("inserting token: %w", err)
+		}
+
+		return nil
+	}
+
+	n, err := s.repo.InsertLimited(ctx, row, s.maxTokens)
+	if err != nil {
+		if errors.Is(err, ErrTokenLimit) {
+			return app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("%w [user: %s, tokens: %d, max: %d]", err, row.UserID, n, s.maxTokens),
+				SafeMessage: "Token limit reached. Please revoke an existing token.",
+				StatusCode:  http.StatusUnprocessableEntity,
+			})
+		}
+
+		return fmt.Errorf("inserting token: %w", err)
+	}
+
+	return nil
+}
+
+// Remaining returns the number of user tokens the user uid may still create. If the number of
+// tokens is not limited, see SetMaxTokensPerUser, it returns false.
+func (s *Service) Remaining(ctx context.Context, uid string) (int, bool, error) {
+	if s.maxTokens <= 0 {
+		return 0, false, nil
+	}
+
+	n, err := s.repo.CountActive(ctx, uid)
+	if err != nil {
+		return 0, true, fmt.Errorf("counting active tokens [user: %s]: %w", uid, err)
+	}
+
+	return max(s.maxTokens-n, 0), true, nil
+}
+
 // List gets all the token listings for a user.
 func (s *Service) List(ctx context.Context, uid string) ([]Listing, error) {
 	rows, err := s.repo.SelectAll(ctx, uid)
@@ -95,6 +306,19 @@ func (s *Service) List(ctx context.Context, uid string) ([]Listing, error) {
 	return rows.listings(), nil
 }
 
+// ListPage gets at most limit token listings for a user, newest first. If after is not nil, only the
+// listings after it are returned.
+//
+// To determine if there are more listings, request limit+1 listings and pass them to pagination.NewPage.
+func (s *Service) ListPage(ctx context.Context, uid string, after *PageKey, limit int) ([]Listing, error) {
+	rows, err := s.repo.SelectPage(ctx, uid, after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows.listings(), nil
+}
+
 // Revoke marks a token as deleted. Tokens that are revoked remain in the database. Only the user
 // that created the token may revoke it.
 //
@@ -127,12 +351,34 @@ func (s *Service) Revoke(ctx context.Context, uid string, jti string) error {
 	return s.repo.UpdateDeletedAt(ctx, jti, time.Now().UTC())
 }
 
-// Validate validates a JWT and then checks if the token has been revoked. If the JWT is valid
-// it will return the user id (sub).
-func (s *Service) Validate(ctx context.Context, token string) (string, error) {
-	claims, err := s.jwts.Validate(token)
+// ValidateParams is the parameters when validating a token.
+type ValidateParams struct {
+	// The signed JWT as a string.
+	Token string
+
+	// The IP address of the client presenting the token. If the token has allowed IPs, ClientIP
+	// must be within one of them.
+	ClientIP net.IP
+
+	// The Origin header of the request presenting the token. If the token has allowed origins,
+	// Origin must be one of them. An empty Origin is accepted unless OriginPolicy is OriginStrict.
+	Origin       string
+	OriginPolicy OriginPolicy
+}
+
+// Validate validates a JWT and then checks if the token has been revoked. The token must be
+// of the user that owns the token row, and of the current token generation of the user, see
+// InvalidateAll. If the token has allowed IPs, the client IP must be within one of them. If
+// the token has allowed origins, the origin must be one of them, otherwise a 403
+// app.WrappedSafeError is returned. If the JWT is valid it will return the user id (sub) and
+// the token listing as a Principal.
+//
+// The last used time of a valid token is updated in the background if it is older than a
+// minute, see touch.
+func (s *Service) Validate(ctx context.Context, p ValidateParams) (Principal, error) {
+	claims, err := s.jwts.Validate(p.Token)
 	if err != nil {
-		return "", app.Wrap(app.WrapParams{
+		return Principal{}, app.Wrap(app.WrapParams{
 			Err:         err,
 			SafeMessage: "Invalid token",
 			StatusCode:  http.StatusUnauthorized,
@@ -142,26 +388,131 @@ func (s *Service) Validate(ctx context.Context, token string) (string, error) {
 	row, err := s.repo.Select(ctx, claims.ID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", app.Wrap(app.WrapParams{
+			return Principal{}, app.Wrap(app.WrapParams{
 				Err:         fmt.Errorf("token not found [jti: %s]: %w", claims.ID, err),
 				SafeMessage: "Token not found",
 				StatusCode:  http.StatusNotFound,
 			})
 		}
 
-		return "", err
+		return Principal{}, err
 	}
 
 	// If token row has a DeletedAt time, it was revoked and is invalid.
 	if !row.DeletedAt.Time.IsZero() {
-		return "", app.Wrap(app.WrapParams{
-			Err:         errors.New("token is revoked"),
+		return Principal{}, app.Wrap(app.WrapParams{
+			Err:         &RejectedError{UserID: row.UserID, Reason: fmt.Sprintf("token '%s' is revoked", row.Name)},
 			SafeMessage: "Invalid token",
 			StatusCode:  http.StatusUnauthorized,
 		})
 	}
 
-	return claims.Subject, nil
+	// A token signed with a leaked secret may name any user and any token ID.
+	if claims.Subject != row.UserID {
+		return Principal{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("token subject does not own token [jti: %s, sub: %s]: %w", claims.ID, claims.Subject, &RejectedError{UserID: row.UserID, Reason: fmt.Sprintf("token '%s' was presented for another user", row.Name)}),
+			SafeMessage: "Invalid token",
+			StatusCode:  http.StatusUnauthorized,
+		})
+	}
+
+	gen, err := s.generation(ctx, row.UserID)
+	if err != nil {
+		return Principal{}, fmt.Errorf("getting token generation [user: %s]: %w", row.UserID, err)
+	}
+
+	if claims.Generation != gen {
+		return Principal{}, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("token generation %d is not %d [jti: %s]: %w", claims.Generation, gen, claims.ID, &RejectedError{UserID: row.UserID, Reason: fmt.Sprintf("token '%s' was invalidated with every token of the account", row.Name)}),
+			SafeMessage: "Invalid token",
+			StatusCode:  http.StatusUnauthorized,
+		})
+	}
+
+	if len(row.AllowedIPs) > 0 {
+		allowedNets, err := app.ParseCIDRs(row.AllowedIPs)
+		if err != nil {
+			return Principal{}, fmt.Errorf("parsing allowed ips [jti: %s]: %w", claims.ID, err)
+		}
+
+		if !app.ContainsIP(allowedNets, p.ClientIP) {
+			return Principal{}, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("client ip not allowed [jti: %s, ip: %s]: %w", claims.ID, p.ClientIP, &RejectedError{UserID: row.UserID, Reason: fmt.Sprintf("token '%s' used from a address it is not allowed from", row.Name)}),
+				SafeMessage: "Token cannot be used from this address",
+				StatusCode:  http.StatusUnauthorized,
+			})
+		}
+	}
+
+	if len(row.AllowedOrigins) > 0 {
+		if err := checkOrigin(row, p.Origin, p.OriginPolicy); err != nil {
+			return Principal{}, err
+		}
+	}
+
+	if now := s.now().UTC(); !row.LastUsed.Valid || now.Sub(row.LastUsed.Time) >= lastUsedInterval {
+		s.touch(ctx, row.ID, now)
+		row.LastUsed = sql.NullTime{Time: now, Valid: true}
+	}
+
+	return Principal{UserID: claims.Subject, Token: row.listing()}, nil
+}
+
+// touch sets the last used time of the token id to t. The update runs in a goroutine, so
+// it does not add to the latency of the request. It keeps the values of ctx but is not
+// canceled with the request, it is bounded by lastUsedTimeout instead. An error is logged.
+//
+// A token has at most one update running, and the update is skipped if the last used
+// time was set less than lastUsedInterval before t, so concurrent requests write a token
+// once per lastUsedInterval.
+func (s *Service) touch(ctx context.Context, id string, t time.Time) {
+	if _, running := s.touching.LoadOrStore(id, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer s.touching.Delete(id)
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lastUsedTimeout)
+		defer cancel()
+
+		if _, err := s.updateLastUsed(ctx, id, t, t.Add(-lastUsedInterval)); err != nil {
+			s.log.Printf("[ERROR] Updating token last used time [jti: %s]: %v\n", id, err)
+		}
+	}()
 }
 
-// TODO: Implement method to update a tokens last used time.
+// checkOrigin checks origin is one of the allowed origins of row. If origin is empty, it is
+// allowed unless policy is OriginStrict.
+func checkOrigin(row Row, origin string, policy OriginPolicy) error {
+	if origin == "" {
+		if policy != OriginStrict {
+			return nil
+		}
+
+		return app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("missing origin [jti: %s]: %w", row.ID, &RejectedError{UserID: row.UserID, Reason: fmt.Sprintf("token '%s' used without an Origin header", row.Name)}),
+			SafeMessage: "Token can only be used from an allowed origin",
+			StatusCode:  http.StatusForbidden,
+		})
+	}
+
+	for _, o := range row.AllowedOrigins {
+		if o == origin {
+			return nil
+		}
+	}
+
+	return app.Wrap(app.WrapParams{
+		Err:         fmt.Errorf("origin not allowed [jti: %s, origin: %s]: %w", row.ID, origin, &RejectedError{UserID: row.UserID, Reason: fmt.Sprintf("token '%s' used from an origin it is not allowed from", row.Name)}),
+		SafeMessage: "Token cannot be used from this origin",
+		StatusCode:  http.StatusForbidden,
+	})
+}
+
+// OriginAllowed returns true if a token that is not revoked or expired allows origin. The
+// CORS layer uses it to accept the preflight requests of a page that uses such a token, since
+// a preflight request does not carry the token.
+func (s *Service) OriginAllowed(ctx context.Context, origin string) (bool, error) {
+	return s.repo.SelectOriginAllowed(ctx, origin, time.Now().UTC())
+}
@@ -0,0 +1,320 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/internal/hook"
+	"github.com/cicconee/clox/internal/jwt"
+)
+
+// newTestService creates a Service on the test database that allows max tokens per user,
+// with a new user. The cache is unreachable, the token generation is read from the
+// database.
+func newTestService(t *testing.T, max int) (*Service, string) {
+	t.Helper()
+
+	repo, userID := newTestRepo(t)
+
+	jwts := jwt.NewManager("clox-test", "clox-test")
+	jwts.SetSecret("secret")
+
+	redis := &cache.Redis{}
+	redis.Open("127.0.0.1", "1", "", "")
+	t.Cleanup(func() { redis.Close() })
+
+	s := NewService(jwts, redis, repo)
+	s.SetMaxTokensPerUser(max)
+
+	return s, userID
+}
+
+func TestServiceNewLimit(t *testing.T) {
+	const max = 2
+
+	ctx := context.Background()
+	s, userID := newTestService(t, max)
+
+	params := NewParams{UserID: userID, Duration: time.Hour, Name: "test"}
+	for i := 0; i < max; i++ {
+		if _, err := s.New(ctx, params); err != nil {
+			t.Fatalf("New() token %d error = %v", i+1, err)
+		}
+	}
+
+	_, err := s.New(ctx, params)
+
+	var safeErr *app.WrappedSafeError
+	if !errors.As(err, &safeErr) {
+		t.Fatalf("New() over the limit error = %v, want a app.WrappedSafeError", err)
+	}
+
+	if _, status := safeErr.Safe(); status != http.StatusUnprocessableEntity {
+		t.Fatalf("New() over the limit status = %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	if !errors.Is(err, ErrTokenLimit) {
+		t.Errorf("New() over the limit error = %v, want it to wrap ErrTokenLimit", err)
+	}
+
+	if _, err := s.NewConsole(ctx, userID); err != nil {
+		t.Errorf("NewConsole() at the limit error = %v, console tokens are not limited", err)
+	}
+}
+
+func TestServiceRemaining(t *testing.T) {
+	const max = 3
+
+	ctx := context.Background()
+	s, userID := newTestService(t, max)
+
+	params := NewParams{UserID: userID, Duration: time.Hour, Name: "test"}
+	for i := 0; i <= max; i++ {
+		remaining, limited, err := s.Remaining(ctx, userID)
+		if err != nil || !limited || remaining != max-i {
+			t.Fatalf("Remaining() after %d tokens = %d, %v, %v, want %d, true", i, remaining, limited, err, max-i)
+		}
+
+		if i < max {
+			if _, err := s.New(ctx, params); err != nil {
+				t.Fatalf("New() token %d error = %v", i+1, err)
+			}
+		}
+	}
+
+	// Console tokens do not take a slot.
+	if _, err := s.NewConsole(ctx, userID); err != nil {
+		t.Fatalf("NewConsole() error = %v", err)
+	}
+
+	if remaining, _, err := s.Remaining(ctx, userID); err != nil || remaining != 0 {
+		t.Errorf("Remaining() after a console token = %d, %v, want 0", remaining, err)
+	}
+
+	// Lowering the limit below the active tokens leaves no slots.
+	s.SetMaxTokensPerUser(1)
+	if remaining, _, err := s.Remaining(ctx, userID); err != nil || remaining != 0 {
+		t.Errorf("Remaining() over the limit = %d, %v, want 0", remaining, err)
+	}
+
+	s.SetMaxTokensPerUser(0)
+	if _, limited, err := s.Remaining(ctx, userID); err != nil || limited {
+		t.Errorf("Remaining() without a limit = %v, %v, want not limited", limited, err)
+	}
+}
+
+func TestServiceAllowedIPs(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newTestService(t, 0)
+
+	// Values are normalized to CIDRs before they are persisted.
+	created, err := s.New(ctx, NewParams{
+		UserID:     userID,
+		Duration:   time.Hour,
+		Name:       "test",
+		AllowedIPs: []string{"203.0.113.7", " 198.51.100.0/24 "},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if got := strings.Join(created.AllowedIPs, ","); got != "203.0.113.7/32,198.51.100.0/24" {
+		t.Errorf("AllowedIPs = %s, want 203.0.113.7/32,198.51.100.0/24", got)
+	}
+
+	tests := []struct {
+		ip   string
+		want int
+	}{
+		{"203.0.113.7", 0},
+		{"198.51.100.42", 0},
+		{"203.0.113.8", http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		_, err := s.Validate(ctx, ValidateParams{Token: created.Token, ClientIP: net.ParseIP(tc.ip)})
+		if tc.want == 0 {
+			if err != nil {
+				t.Errorf("Validate() from %s error = %v", tc.ip, err)
+			}
+			continue
+		}
+
+		assertStatus(t, err, tc.want)
+	}
+
+	_, err = s.New(ctx, NewParams{UserID: userID, Duration: time.Hour, Name: "test", AllowedIPs: []string{"203.0.113"}})
+	assertStatus(t, err, http.StatusBadRequest)
+}
+
+func TestServiceHooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, userID := newTestService(t, 0)
+
+	q := hook.NewQueue(0, log.New(io.Discard, "", 0))
+	go q.Run(ctx)
+
+	created := make(chan Created, 1)
+	hooks := NewHooks(q)
+	hooks.AfterTokenCreated(func(ctx context.Context, c Created) { created <- c })
+	s.SetHooks(hooks)
+
+	listing, err := s.New(ctx, NewParams{UserID: userID, Duration: time.Hour, Name: "test"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	select {
+	case c := <-created:
+		if c.UserID != userID || c.Kind != KindUser || c.TokenID != listing.TokenID || c.TokenName != "test" {
+			t.Errorf("AfterTokenCreated = %+v, want token %s of %s", c, listing.TokenID, userID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AfterTokenCreated was not run")
+	}
+}
+
+// lastUsedWrite is a update of the last used time of a token by Service.touch.
+type lastUsedWrite struct {
+	at      time.Time
+	updated bool
+	err     error
+}
+
+func TestServiceValidateLastUsed(t *testing.T) {
+	type ctxKey struct{}
+
+	s, userID := newTestService(t, 0)
+	created, err := s.New(context.Background(), NewParams{UserID: userID, Duration: time.Hour, Name: "test"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	var mu sync.Mutex
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	s.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+
+	// The first write is held until the request is canceled.
+	release := make(chan struct{})
+	writes := make(chan lastUsedWrite, 16)
+	s.updateLastUsed = func(ctx context.Context, id string, at time.Time, since time.Time) (bool, error) {
+		<-release
+
+		if ctx.Err() != nil || ctx.Value(ctxKey{}) != "request" {
+			t.Errorf("write context error = %v with value %v, want the values of the canceled request", ctx.Err(), ctx.Value(ctxKey{}))
+		}
+
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > lastUsedTimeout {
+			t.Errorf("write deadline = %s, %t, want one within %s", deadline, ok, lastUsedTimeout)
+		}
+
+		updated, err := s.repo.UpdateLastUsed(ctx, id, at, since)
+		writes <- lastUsedWrite{at: at, updated: updated, err: err}
+		return updated, err
+	}
+
+	validate := func() {
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "request"))
+		defer cancel()
+
+		if _, err := s.Validate(ctx, ValidateParams{Token: created.Token}); err != nil {
+			t.Errorf("Validate() error = %v", err)
+		}
+	}
+
+	// wait waits for n updates of the last used time, then checks no other write follows.
+	wait := func(n int) {
+		t.Helper()
+
+		updated := 0
+		for updated < n {
+			select {
+			case w := <-writes:
+				if w.err != nil {
+					t.Fatalf("updating last used time error = %v", w.err)
+				}
+				if w.updated {
+					updated++
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("last used time updated %d times, want %d", updated, n)
+			}
+		}
+
+		select {
+		case w := <-writes:
+			if w.updated {
+				t.Errorf("last used time updated again at %s", w.at)
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	validate()
+	close(release)
+	wait(1)
+
+	// Within the interval the token is not written.
+	advance(lastUsedInterval / 2)
+	for i := 0; i < 3; i++ {
+		validate()
+	}
+	wait(0)
+
+	// Once the interval passed, concurrent requests write the token once.
+	advance(lastUsedInterval / 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			validate()
+		}()
+	}
+	wg.Wait()
+	wait(1)
+
+	row, err := s.repo.Select(context.Background(), created.TokenID)
+	if err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+
+	if !row.LastUsed.Valid || !row.LastUsed.Time.Equal(s.now()) {
+		t.Errorf("last used = %v, want %s", row.LastUsed, s.now())
+	}
+}
+
+// assertStatus fails t if err is not a app.WrappedSafeError with the status code want.
+func assertStatus(t *testing.T, err error, want int) {
+	t.Helper()
+
+	var safeErr *app.WrappedSafeError
+	if !errors.As(err, &safeErr) {
+		t.Fatalf("error = %v, want a app.WrappedSafeError", err)
+	}
+
+	if _, status := safeErr.Safe(); status != want {
+		t.Errorf("status = %d, want %d: %v", status, want, err)
+	}
+}
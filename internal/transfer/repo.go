@@ -0,0 +1,90 @@
+package transfer
+
+import (
+	"context"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// Repo is the transfer stats repository.
+type Repo struct {
+	// The database connection.
+	db app.DB
+}
+
+// NewRepo creates a new Repo.
+func NewRepo(db app.DB) *Repo {
+	return &Repo{db: db}
+}
+
+// Upsert inserts the total of a day, token, and direction. If it exists, the bytes and
+// requests are set to the larger of the stored and new values, so upserting the same total
+// again does not change it.
+func (r *Repo) Upsert(ctx context.Context, t Total) error {
+	query := `INSERT INTO transfer_stats(day, user_id, token_id, direction, bytes, requests)
+			  VALUES($1, $2, $3, $4, $5, $6)
+			  ON CONFLICT (day, user_id, token_id, direction) DO UPDATE
+			  SET bytes = GREATEST(transfer_stats.bytes, EXCLUDED.bytes),
+			      requests = GREATEST(transfer_stats.requests, EXCLUDED.requests),
+			      updated_at = now()`
+
+	_, err := r.db.Exec(ctx, query, t.Date, t.UserID, t.TokenID, t.Direction, t.Bytes, t.Requests)
+
+	return err
+}
+
+// SelectDaily selects the totals of a user on each day since the day since, by token and
+// direction. They are ordered by day, token, and direction.
+func (r *Repo) SelectDaily(ctx context.Context, userID string, since time.Time) ([]Total, error) {
+	query := `SELECT to_char(day, 'YYYY-MM-DD'), user_id, token_id, direction, bytes, requests
+			  FROM transfer_stats
+			  WHERE user_id = $1 AND day >= $2
+			  ORDER BY day, token_id, direction`
+
+	rows, err := r.db.Query(ctx, query, userID, since.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []Total{}
+	for rows.Next() {
+		var t Total
+		if err := rows.Scan(&t.Date, &t.UserID, &t.TokenID, &t.Direction, &t.Bytes, &t.Requests); err != nil {
+			return nil, err
+		}
+
+		totals = append(totals, t)
+	}
+
+	return totals, rows.Err()
+}
+
+// SelectUserTotals selects the totals of every user since the day since, by direction. They
+// are ordered by bytes, largest first.
+func (r *Repo) SelectUserTotals(ctx context.Context, since time.Time) ([]Total, error) {
+	query := `SELECT user_id, direction, SUM(bytes), SUM(requests)
+			  FROM transfer_stats
+			  WHERE day >= $1
+			  GROUP BY user_id, direction
+			  ORDER BY SUM(bytes) DESC, user_id, direction`
+
+	rows, err := r.db.Query(ctx, query, since.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []Total{}
+	for rows.Next() {
+		var t Total
+		if err := rows.Scan(&t.UserID, &t.Direction, &t.Bytes, &t.Requests); err != nil {
+			return nil, err
+		}
+
+		totals = append(totals, t)
+	}
+
+	return totals, rows.Err()
+}
@@ -0,0 +1,195 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/cicconee/clox/internal/cache"
+)
+
+// FlushInterval is how often Run flushes the counters.
+const FlushInterval = time.Minute
+
+// counterTTL is how long a counter is kept in Redis after its last request. It is longer than
+// a day, so the counters of the previous day are still there for the first flush of a day.
+const counterTTL = 72 * time.Hour
+
+// Stats counts the bytes transferred in Redis and flushes the totals to the database.
+//
+// A nil Stats is valid, it records nothing and has no totals.
+//
+// Stats should be created using the NewStats function.
+type Stats struct {
+	cache *cache.Redis
+	repo  *Repo
+	log   *log.Logger
+
+	// now returns the current time. Days are in UTC.
+	now func() time.Time
+}
+
+// NewStats creates a new Stats. If logger is nil, it will default to log.Default().
+func NewStats(c *cache.Redis, repo *Repo, logger *log.Logger) *Stats {
+	if c == nil {
+		panic("transfer.NewStats: cannot create Stats with nil cache.Redis")
+	}
+
+	if repo == nil {
+		panic("transfer.NewStats: cannot create Stats with nil Repo")
+	}
+
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &Stats{cache: c, repo: repo, log: logger, now: time.Now}
+}
+
+// Record counts the bytes of smp and one request on the current day. Errors are logged, a
+// request that is not counted is not retried.
+func (s *Stats) Record(ctx context.Context, smp Sample) {
+	if s == nil || smp.Bytes < 0 {
+		return
+	}
+
+	date := s.now().UTC().Format(time.DateOnly)
+	m := member(smp)
+
+	err := s.cache.HIncrBy(ctx, counterKey(date, m), map[string]int64{"bytes": smp.Bytes, "requests": 1}, counterTTL)
+	if err == nil {
+		err = s.cache.SAdd(ctx, setKey(date), counterTTL, m)
+	}
+
+	if err != nil {
+		s.log.Printf("[ERROR] Recording transfer [user: %s, token: %s, direction: %s, bytes: %d]: %v\n",
+			smp.UserID, smp.TokenID, smp.Direction, smp.Bytes, err)
+	}
+}
+
+// Run flushes the counters every FlushInterval until ctx is done. Errors are logged.
+func (s *Stats) Run(ctx context.Context) {
+	ticker := time.NewTicker(FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(ctx); err != nil {
+				s.log.Printf("[ERROR] Flushing transfer stats: %v\n", err)
+			}
+		}
+	}
+}
+
+// Flush upserts the totals counted on the current and previous day. The previous day is
+// included so the requests counted after its last flush are not lost. A total that fails to
+// be upserted is logged and the rest are still flushed, it is retried by the next flush.
+func (s *Stats) Flush(ctx context.Context) error {
+	now := s.now().UTC()
+
+	for _, day := range []time.Time{now.AddDate(0, 0, -1), now} {
+		date := day.Format(time.DateOnly)
+
+		members, err := s.cache.SMembers(ctx, setKey(date))
+		if err != nil {
+			return fmt.Errorf("getting counters [date: %s]: %w", date, err)
+		}
+
+		for _, m := range members {
+			t, ok, err := s.total(ctx, date, m)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				continue
+			}
+
+			if err := s.repo.Upsert(ctx, t); err != nil {
+				s.log.Printf("[ERROR] Flushing transfer stats [date: %s, user: %s, token: %s, direction: %s]: %v\n",
+					date, t.UserID, t.TokenID, t.Direction, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// total gets the Total of the counter of member m on date. If the counter is malformed or
+// expired, ok is false.
+func (s *Stats) total(ctx context.Context, date string, m string) (Total, bool, error) {
+	userID, tokenID, direction, ok := parseMember(m)
+	if !ok {
+		s.log.Printf("[WARN] Skipping malformed transfer counter [date: %s, member: %s]\n", date, m)
+		return Total{}, false, nil
+	}
+
+	fields, err := s.cache.HGetAll(ctx, counterKey(date, m))
+	if err != nil {
+		return Total{}, false, fmt.Errorf("getting counter [date: %s, member: %s]: %w", date, m, err)
+	}
+
+	if len(fields) == 0 {
+		return Total{}, false, nil
+	}
+
+	bytes, errBytes := strconv.ParseInt(fields["bytes"], 10, 64)
+	requests, errRequests := strconv.ParseInt(fields["requests"], 10, 64)
+	if errBytes != nil || errRequests != nil {
+		s.log.Printf("[WARN] Skipping malformed transfer counter [date: %s, member: %s]\n", date, m)
+		return Total{}, false, nil
+	}
+
+	return Total{
+		Date:      date,
+		UserID:    userID,
+		TokenID:   tokenID,
+		Direction: direction,
+		Bytes:     bytes,
+		Requests:  requests,
+	}, true, nil
+}
+
+// Daily gets the flushed totals of a user on each of the last days, including today, by token
+// and direction. Days without transfers are not included.
+func (s *Stats) Daily(ctx context.Context, userID string, days int) ([]Total, error) {
+	if s == nil {
+		return []Total{}, nil
+	}
+
+	totals, err := s.repo.SelectDaily(ctx, userID, s.since(days))
+	if err != nil {
+		return nil, fmt.Errorf("selecting transfer stats [user: %s]: %w", userID, err)
+	}
+
+	return totals, nil
+}
+
+// ByUser gets the flushed totals of every user over the last days, including today, by
+// direction. Users with the most bytes transferred are first.
+func (s *Stats) ByUser(ctx context.Context, days int) ([]Total, error) {
+	if s == nil {
+		return []Total{}, nil
+	}
+
+	totals, err := s.repo.SelectUserTotals(ctx, s.since(days))
+	if err != nil {
+		return nil, fmt.Errorf("selecting transfer stats by user: %w", err)
+	}
+
+	return totals, nil
+}
+
+// since returns the first of the last days, including today.
+func (s *Stats) since(days int) time.Time {
+	if days < 1 {
+		days = DefaultDays
+	}
+
+	return s.now().UTC().AddDate(0, 0, -(days - 1))
+}
@@ -0,0 +1,176 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+// openTestCache opens the test Redis. If TEST_REDIS_HOST is not set, the test is skipped.
+func openTestCache(t *testing.T) *cache.Redis {
+	t.Helper()
+
+	host := os.Getenv("TEST_REDIS_HOST")
+	if host == "" {
+		t.Skip("TEST_REDIS_HOST is not set")
+	}
+
+	redis := &cache.Redis{}
+	redis.Open(host,
+		os.Getenv("TEST_REDIS_PORT"),
+		os.Getenv("TEST_REDIS_USERNAME"),
+		os.Getenv("TEST_REDIS_PASSWORD"))
+	t.Cleanup(func() { redis.Close() })
+
+	if err := redis.Ping(); err != nil {
+		t.Fatalf("pinging test cache: %v", err)
+	}
+
+	return redis
+}
+
+func TestStatsNil(t *testing.T) {
+	var s *Stats
+	s.Record(context.Background(), Sample{UserID: "user", TokenID: "token", Direction: Upload, Bytes: 1})
+
+	totals, err := s.Daily(context.Background(), "user", 1)
+	if err != nil || len(totals) != 0 {
+		t.Errorf("Daily() on a nil Stats = %v, %v, want none", totals, err)
+	}
+}
+
+func TestStatsRecordUnreachable(t *testing.T) {
+	redis := &cache.Redis{}
+	redis.Open("127.0.0.1", "1", "", "")
+	t.Cleanup(func() { redis.Close() })
+
+	var logs bytes.Buffer
+	s := NewStats(redis, NewRepo(nil), log.New(&logs, "", 0))
+
+	// A request that cannot be counted is logged, it never fails the request.
+	s.Record(context.Background(), Sample{UserID: "user", TokenID: "token", Direction: Upload, Bytes: 1})
+
+	if !strings.Contains(logs.String(), "[ERROR] Recording transfer") {
+		t.Errorf("logs = %q, want the error logged", logs.String())
+	}
+}
+
+func TestStatsFlush(t *testing.T) {
+	ctx := context.Background()
+	redis := openTestCache(t)
+	p := dbtest.Open(t)
+
+	userID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	// The days are long before any real counters.
+	now := time.Date(2001, time.March, 1, 23, 0, 0, 0, time.UTC)
+	s := NewStats(redis, NewRepo(p), log.New(io.Discard, "", 0))
+	s.now = func() time.Time { return now }
+
+	t.Cleanup(func() {
+		for _, date := range []string{"2001-03-01", "2001-03-02"} {
+			members, _ := redis.SMembers(ctx, setKey(date))
+			for _, m := range members {
+				redis.Del(ctx, counterKey(date, m))
+			}
+			redis.Del(ctx, setKey(date))
+		}
+	})
+
+	record := func(tokenID string, direction string, n int64) {
+		s.Record(ctx, Sample{UserID: userID, TokenID: tokenID, Direction: direction, Bytes: n})
+	}
+
+	flush := func() {
+		t.Helper()
+
+		if err := s.Flush(ctx); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+	}
+
+	assertDaily := func(days int, want []Total) {
+		t.Helper()
+
+		got, err := s.Daily(ctx, userID, days)
+		if err != nil {
+			t.Fatalf("Daily() error = %v", err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Daily(%d) = %+v, want %+v", days, got, want)
+		}
+	}
+
+	record("token-a", Upload, 100)
+	record("token-a", Upload, 50)
+	record("token-b", Download, 10)
+
+	// Flushing again does not count the requests twice.
+	flush()
+	flush()
+
+	day1 := []Total{
+		{Date: "2001-03-01", UserID: userID, TokenID: "token-a", Direction: Upload, Bytes: 150, Requests: 2},
+		{Date: "2001-03-01", UserID: userID, TokenID: "token-b", Direction: Download, Bytes: 10, Requests: 1},
+	}
+	assertDaily(1, day1)
+
+	// The first flush of a day also flushes the previous day.
+	record("token-a", Upload, 25)
+	now = now.Add(2 * time.Hour)
+	record("token-a", Upload, 5)
+	flush()
+
+	day1[0].Bytes, day1[0].Requests = 175, 3
+	day2 := Total{Date: "2001-03-02", UserID: userID, TokenID: "token-a", Direction: Upload, Bytes: 5, Requests: 1}
+	assertDaily(2, append(append([]Total{}, day1...), day2))
+	assertDaily(1, []Total{day2})
+
+	// Redis loses its counters. The requests that follow are under-counted, never
+	// counted twice.
+	m := member(Sample{UserID: userID, TokenID: "token-a", Direction: Upload})
+	if err := redis.Del(ctx, counterKey("2001-03-02", m)); err != nil {
+		t.Fatalf("deleting counter: %v", err)
+	}
+
+	record("token-a", Upload, 1)
+	flush()
+	assertDaily(1, []Total{day2})
+
+	totals, err := s.ByUser(ctx, 2)
+	if err != nil {
+		t.Fatalf("ByUser() error = %v", err)
+	}
+
+	got := []Total{}
+	for _, total := range totals {
+		if total.UserID == userID {
+			got = append(got, total)
+		}
+	}
+
+	want := []Total{
+		{UserID: userID, Direction: Upload, Bytes: 180, Requests: 4},
+		{UserID: userID, Direction: Download, Bytes: 10, Requests: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ByUser() = %+v, want %+v", got, want)
+	}
+}
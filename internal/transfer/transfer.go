@@ -0,0 +1,114 @@
+// Package transfer counts the bytes uploaded and downloaded through the API by each token.
+//
+// Requests are counted in Redis, in a hash per user, token, direction, and day. Stats.Flush
+// copies the running totals of the current and previous day to the transfer_stats table.
+// A flush writes totals rather than increments, and keeps the larger of the stored and
+// flushed total, so a flush that is repeated or interrupted never counts a request twice.
+// If Redis loses its counters, the requests counted since the last flush are lost, and the
+// requests that follow are only recorded once their total passes the stored one.
+package transfer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+// The directions of a transfer.
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// The default and maximum number of days of stats.
+const (
+	DefaultDays = 30
+	MaxDays     = 365
+)
+
+// Sample is the bytes transferred by a single request.
+type Sample struct {
+	UserID    string
+	TokenID   string
+	Direction string
+	Bytes     int64
+}
+
+// Total is the bytes transferred and the number of requests. A Total of a single day and
+// token has Date and TokenID set, a Total over many days or tokens leaves them empty.
+type Total struct {
+	// Date is the day in UTC, formatted as "2006-01-02".
+	Date      string
+	UserID    string
+	TokenID   string
+	Direction string
+	Bytes     int64
+	Requests  int64
+}
+
+// ParseDays parses the "days" query parameter. If it is not set, DefaultDays is returned. If
+// it is not a whole number between 1 and MaxDays, a 400 app.WrappedSafeError is returned.
+func ParseDays(q url.Values) (int, error) {
+	v := q.Get("days")
+	if v == "" {
+		return DefaultDays, nil
+	}
+
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 1 || days > MaxDays {
+		return 0, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("invalid days: %q", v),
+			SafeMessage: fmt.Sprintf("Days must be a whole number between 1 and %d", MaxDays),
+			StatusCode:  http.StatusBadRequest,
+			Field:       "days",
+		})
+	}
+
+	return days, nil
+}
+
+// member returns the member of the counter of s in the set of a day. It is also the suffix
+// of the counter key.
+func member(s Sample) string {
+	return s.UserID + "|" + s.TokenID + "|" + s.Direction
+}
+
+// parseMember parses a member returned by member. The user ID may contain "|", the token ID
+// and direction never do.
+func parseMember(m string) (userID string, tokenID string, direction string, ok bool) {
+	rest, direction, ok := cutLast(m)
+	if !ok {
+		return "", "", "", false
+	}
+
+	userID, tokenID, ok = cutLast(rest)
+	if !ok || userID == "" || tokenID == "" || direction == "" {
+		return "", "", "", false
+	}
+
+	return userID, tokenID, direction, true
+}
+
+// cutLast slices s around the last "|".
+func cutLast(s string) (before string, after string, ok bool) {
+	i := strings.LastIndex(s, "|")
+	if i < 0 {
+		return "", "", false
+	}
+
+	return s[:i], s[i+1:], true
+}
+
+// setKey is the key of the set of counters of a day.
+func setKey(date string) string {
+	return "transfer:" + date
+}
+
+// counterKey is the key of the counter of a member on a day.
+func counterKey(date string, member string) string {
+	return "transfer:" + date + ":" + member
+}
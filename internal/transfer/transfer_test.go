@@ -0,0 +1,56 @@
+package transfer
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+)
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    int
+		wantErr bool
+	}{
+		{v: "", want: DefaultDays},
+		{v: "1", want: 1},
+		{v: "365", want: MaxDays},
+		{v: "0", wantErr: true},
+		{v: "366", wantErr: true},
+		{v: "week", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseDays(url.Values{"days": {tc.v}})
+		if !tc.wantErr {
+			if err != nil || got != tc.want {
+				t.Errorf("ParseDays(%q) = %d, %v, want %d", tc.v, got, err, tc.want)
+			}
+			continue
+		}
+
+		var fieldErr app.FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Field() != "days" {
+			t.Errorf("ParseDays(%q) error = %v, want a error of the days field", tc.v, err)
+		}
+	}
+}
+
+func TestParseMember(t *testing.T) {
+	// The user ID of a provider may contain "|".
+	s := Sample{UserID: "github|42", TokenID: "token", Direction: Upload}
+
+	userID, tokenID, direction, ok := parseMember(member(s))
+	if !ok || userID != s.UserID || tokenID != s.TokenID || direction != s.Direction {
+		t.Errorf("parseMember(member()) = %q, %q, %q, %t, want %q, %q, %q", userID, tokenID, direction, ok,
+			s.UserID, s.TokenID, s.Direction)
+	}
+
+	for _, m := range []string{"", "upload", "token|upload", "|token|upload", "user||upload", "user|token|"} {
+		if _, _, _, ok := parseMember(m); ok {
+			t.Errorf("parseMember(%q) = ok, want malformed", m)
+		}
+	}
+}
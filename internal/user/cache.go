@@ -0,0 +1,131 @@
+package user
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// cacheMetrics counts the user cache hits, misses, invalidations, and evictions. It is
+// published with expvar as "user_cache".
+var cacheMetrics = expvar.NewMap("user_cache")
+
+// Cache is a in-process least recently used cache of user rows, keyed by user ID. Only
+// users that exist are cached.
+//
+// The Service invalidates a user when it writes the user. Users written outside of the
+// Service, or by another process, are stale for at most the TTL. Keep the TTL short, a
+// user blocked in the database can keep using the API until their row expires.
+//
+// A nil Cache is valid and caches nothing. Cache should be created using the NewCache
+// function.
+type Cache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a cached user row.
+type cacheEntry struct {
+	id        string
+	row       Row
+	expiresAt time.Time
+}
+
+// NewCache creates a new Cache of at most size users, each cached for at most ttl. If size
+// or ttl is not positive, caching is disabled and nil is returned.
+func NewCache(size int, ttl time.Duration) *Cache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+
+	return &Cache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// get gets the cached row of the user id. If the user is not cached or expired, false is
+// returned.
+func (c *Cache) get(id string) (Row, bool) {
+	if c == nil {
+		return Row{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok {
+		cacheMetrics.Add("misses", 1)
+		return Row{}, false
+	}
+
+	entry := e.Value.(*cacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(e)
+		cacheMetrics.Add("misses", 1)
+		return Row{}, false
+	}
+
+	c.order.MoveToFront(e)
+	cacheMetrics.Add("hits", 1)
+
+	return entry.row, true
+}
+
+// set caches the row of a user. If the cache is full, the least recently used user is
+// evicted.
+func (c *Cache) set(row Row) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{id: row.ID, row: row, expiresAt: c.now().Add(c.ttl)}
+
+	if e, ok := c.entries[row.ID]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[row.ID] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		cacheMetrics.Add("evictions", 1)
+	}
+}
+
+// Invalidate removes the user id from the cache. It must be called after a user is written
+// outside of the Service, so the write is read on the next Get.
+func (c *Cache) Invalidate(id string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[id]; ok {
+		c.remove(e)
+	}
+	cacheMetrics.Add("invalidations", 1)
+}
+
+// remove removes e from the cache. The lock must be held.
+func (c *Cache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).id)
+}
@@ -0,0 +1,166 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/google/uuid"
+)
+
+// newTestCache creates a new Cache of size users cached for a minute. The returned func
+// advances the time of the Cache by d.
+func newTestCache(size int) (*Cache, func(d time.Duration)) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	c := NewCache(size, time.Minute)
+	c.now = func() time.Time { return now }
+
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestNewCacheDisabled(t *testing.T) {
+	if c := NewCache(0, time.Minute); c != nil {
+		t.Error("NewCache() with no size is not nil")
+	}
+
+	if c := NewCache(10, 0); c != nil {
+		t.Error("NewCache() with no TTL is not nil")
+	}
+
+	// A nil Cache caches nothing.
+	var c *Cache
+	c.set(Row{ID: "a"})
+	c.Invalidate("a")
+	if _, ok := c.get("a"); ok {
+		t.Error("get() on a nil Cache = true, want false")
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	c, advance := newTestCache(10)
+
+	if _, ok := c.get("a"); ok {
+		t.Fatal("get() before set = true, want false")
+	}
+
+	c.set(Row{ID: "a", Email: "ada@example.com"})
+
+	row, ok := c.get("a")
+	if !ok || row.Email != "ada@example.com" {
+		t.Fatalf("get() = %+v, %t, want the cached row", row, ok)
+	}
+
+	// An entry expires once the TTL has passed.
+	advance(time.Minute - time.Second)
+	if _, ok := c.get("a"); !ok {
+		t.Error("get() before the TTL = false, want true")
+	}
+
+	advance(time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Error("get() after the TTL = true, want false")
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c, _ := newTestCache(10)
+
+	c.set(Row{ID: "a", RegisterStatus: Complete})
+	c.Invalidate("a")
+
+	if _, ok := c.get("a"); ok {
+		t.Error("get() after Invalidate = true, want false")
+	}
+
+	// Invalidating a user that is not cached is a no-op.
+	c.Invalidate("b")
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, _ := newTestCache(2)
+
+	c.set(Row{ID: "a"})
+	c.set(Row{ID: "b"})
+
+	// Reading a makes b the least recently used.
+	c.get("a")
+	c.set(Row{ID: "c"})
+
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(id); ok != want {
+			t.Errorf("get(%s) = %t, want %t", id, ok, want)
+		}
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	c := NewCache(8, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				id := fmt.Sprintf("user-%d", (i+j)%16)
+				c.set(Row{ID: id})
+				c.get(id)
+				if j%10 == 0 {
+					c.Invalidate(id)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := c.order.Len(); got > 8 || got != len(c.entries) {
+		t.Errorf("cached %d users with %d entries, want at most 8 and the same", got, len(c.entries))
+	}
+}
+
+func TestServiceGetCached(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+	s := NewService(NewRepo(p))
+	c, _ := newTestCache(10)
+	s.SetCache(c)
+
+	id := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		id, id+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, id) })
+
+	assertStatus := func(want Status) {
+		t.Helper()
+
+		u, err := s.Get(ctx, id)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+
+		if u.RegistrationStatus != want {
+			t.Errorf("Get() status = %s, want %s", u.RegistrationStatus, want)
+		}
+	}
+
+	assertStatus(Complete)
+
+	// The user is blocked outside the Service, the cached user is read until it is
+	// invalidated.
+	if _, err := p.Exec(ctx, `UPDATE users SET register_status = 'blocked' WHERE id = $1`, id); err != nil {
+		t.Fatalf("blocking user: %v", err)
+	}
+
+	assertStatus(Complete)
+
+	c.Invalidate(id)
+	assertStatus(Blocked)
+}
@@ -16,12 +16,35 @@ var ErrUserNotFound = errors.New("user not found")
 
 type Service struct {
 	repo *Repo
+
+	// requireVerifiedEmail prevents users with an unverified email from registering.
+	requireVerifiedEmail bool
+
+	// cache caches the users read by Get. It is nil if users are not cached.
+	cache *Cache
 }
 
 func NewService(repo *Repo) *Service {
 	return &Service{repo: repo}
 }
 
+// RequireVerifiedEmail sets if users must have a provider verified email to register. When
+// required, Authenticate returns new users with an unverified email with the Unverified status,
+// and Register rejects them.
+//
+// Accounts are never linked by email, verified or not. Users are always identified by their
+// provider ID.
+func (s *Service) RequireVerifiedEmail(require bool) {
+	s.requireVerifiedEmail = require
+}
+
+// SetCache sets the Cache of the users read by Get. If c is nil, users are not cached.
+//
+// Authenticate always reads the database, a login never uses a cached user.
+func (s *Service) SetCache(c *Cache) {
+	s.cache = c
+}
+
 type Provider interface {
 	UserInfo(context.Context, *oauth2.Token) (provider.User, error)
 }
@@ -38,7 +61,14 @@ func (s *Service) Authenticate(ctx context.Context, provider Provider, token *oa
 	}
 	// User already exists.
 	if row != nil {
-		return row.user(), nil
+		user := row.user()
+		user.EmailVerified = info.EmailVerified
+		return user, nil
+	}
+
+	status := Incomplete
+	if s.requireVerifiedEmail && !info.EmailVerified {
+		status = Unverified
 	}
 
 	return &User{
@@ -48,13 +78,20 @@ func (s *Service) Authenticate(ctx context.Context, provider Provider, token *oa
 		PictureURL:         info.PictureURL,
 		Email:              info.Email,
 		Username:           "",
-		RegistrationStatus: Incomplete,
+		RegistrationStatus: status,
+		EmailVerified:      info.EmailVerified,
 	}, nil
 }
 
 // Get gets a user from the database by id. If a user is not found, a ErrUserNotFound is returned
 // within a app.WrappedSafeError.
+//
+// If the Service has a Cache, a cached user is returned instead of reading the database.
 func (s *Service) Get(ctx context.Context, id string) (*User, error) {
+	if row, ok := s.cache.get(id); ok {
+		return row.user(), nil
+	}
+
 	row, err := s.get(ctx, id)
 	if err != nil {
 		return nil, err
@@ -67,6 +104,8 @@ func (s *Service) Get(ctx context.Context, id string) (*User, error) {
 		})
 	}
 
+	s.cache.set(*row)
+
 	return row.user(), nil
 }
 
@@ -86,6 +125,9 @@ type Registration struct {
 	PictureURL string
 	Email      string
 	Username   string
+
+	// EmailVerified is true if the provider verified the user owns Email.
+	EmailVerified bool
 }
 
 // Register will register a user by writing a user to the database.
@@ -94,7 +136,18 @@ type Registration struct {
 // field returned in User. Usernames get normalized before being written to the database
 // so this value may change. If you need the up-to-date username, use the username that
 // is returned with User.
+//
+// If verified emails are required and the email is not verified, a app.WrappedSafeError is
+// returned.
 func (s *Service) Register(ctx context.Context, r Registration) (*User, error) {
+	if s.requireVerifiedEmail && !r.EmailVerified {
+		return nil, app.Wrap(app.WrapParams{
+			Err:         fmt.Errorf("email not verified [id: %s]", r.ID),
+			SafeMessage: "Your email must be verified to register.",
+			StatusCode:  http.StatusForbidden,
+		})
+	}
+
 	user := User{
 		ID:                 r.ID,
 		FirstName:          r.FirstName,
@@ -103,6 +156,7 @@ func (s *Service) Register(ctx context.Context, r Registration) (*User, error) {
 		Email:              r.Email,
 		Username:           r.Username,
 		RegistrationStatus: Complete,
+		EmailVerified:      r.EmailVerified,
 	}
 
 	user.NormalizeUsername()
@@ -115,6 +169,7 @@ func (s *Service) Register(ctx context.Context, r Registration) (*User, error) {
 	if err != nil {
 		return nil, fmt.Errorf("inserting user [id: %s]: %w", user.ID, err)
 	}
+	s.cache.Invalidate(user.ID)
 
 	return &user, nil
 }
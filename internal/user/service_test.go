@@ -0,0 +1,93 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/oauth2"
+	"github.com/cicconee/clox/internal/provider"
+	"github.com/google/uuid"
+)
+
+// staticProvider is a Provider that returns the same user info for every token.
+type staticProvider provider.User
+
+func (p staticProvider) UserInfo(ctx context.Context, token *oauth2.Token) (provider.User, error) {
+	return provider.User(p), nil
+}
+
+func TestServiceAuthenticateVerifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+	s := NewService(NewRepo(p))
+
+	info := provider.User{ID: provider.NewID("test", uuid.NewString()), Email: "ada@example.com"}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, info.ID.Encode()) })
+
+	tests := []struct {
+		name     string
+		require  bool
+		verified bool
+		want     Status
+	}{
+		{name: "not required", require: false, verified: false, want: Incomplete},
+		{name: "required and verified", require: true, verified: true, want: Incomplete},
+		{name: "required and unverified", require: true, verified: false, want: Unverified},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s.RequireVerifiedEmail(tc.require)
+
+			info.EmailVerified = tc.verified
+			user, err := s.Authenticate(ctx, staticProvider(info), &oauth2.Token{})
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+
+			if user.RegistrationStatus != tc.want || user.EmailVerified != tc.verified {
+				t.Errorf("Authenticate() = %s, verified %v, want %s, verified %v", user.RegistrationStatus, user.EmailVerified, tc.want, tc.verified)
+			}
+		})
+	}
+
+	// Existing users are not affected, the flag is taken from the provider. Usernames are
+	// unique, the user gets a random one.
+	s.RequireVerifiedEmail(false)
+	username := "ada" + uuid.NewString()[:8]
+	if _, err := s.Register(ctx, Registration{ID: info.ID.Encode(), Email: info.Email, Username: username}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	s.RequireVerifiedEmail(true)
+	info.EmailVerified = false
+	user, err := s.Authenticate(ctx, staticProvider(info), &oauth2.Token{})
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	if user.RegistrationStatus != Complete || user.EmailVerified {
+		t.Errorf("Authenticate() of a registered user = %s, verified %v, want %s, unverified", user.RegistrationStatus, user.EmailVerified, Complete)
+	}
+}
+
+func TestServiceRegisterUnverifiedEmail(t *testing.T) {
+	s := NewService(nil)
+	s.RequireVerifiedEmail(true)
+
+	// The registration is rejected before the database is used.
+	_, err := s.Register(context.Background(), Registration{ID: "test|1", Email: "ada@example.com", Username: "ada"})
+
+	var safeErr *app.WrappedSafeError
+	if !errors.As(err, &safeErr) {
+		t.Fatalf("Register() error = %v, want a app.WrappedSafeError", err)
+	}
+
+	if _, status := safeErr.Safe(); status != http.StatusForbidden {
+		t.Errorf("Register() status = %d, want %d", status, http.StatusForbidden)
+	}
+}
@@ -16,6 +16,10 @@ const (
 	Complete   Status = "complete"
 	Incomplete Status = "incomplete"
 	Blocked    Status = "blocked"
+
+	// Unverified is the status of a user that has not registered and whose email was not
+	// verified by the provider, when verified emails are required.
+	Unverified Status = "unverified"
 )
 
 type User struct {
@@ -26,6 +30,10 @@ type User struct {
 	Email              string
 	Username           string
 	RegistrationStatus Status
+
+	// EmailVerified is true if the provider verified the user owns Email. It is not persisted,
+	// it is set from the provider when authenticating.
+	EmailVerified bool
 }
 
 func (u *User) Row() Row {
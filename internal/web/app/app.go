@@ -1,13 +1,23 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/avatar"
+	"github.com/cicconee/clox/internal/bootstrap"
 	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/hook"
+	"github.com/cicconee/clox/internal/invite"
 	"github.com/cicconee/clox/internal/oauth2"
+	"github.com/cicconee/clox/internal/pagination"
 	"github.com/cicconee/clox/internal/provider/google"
+	"github.com/cicconee/clox/internal/router"
+	"github.com/cicconee/clox/internal/security"
 	"github.com/cicconee/clox/internal/server"
 	"github.com/cicconee/clox/internal/token"
 	"github.com/cicconee/clox/internal/user"
@@ -31,81 +41,273 @@ type App struct {
 	Users        *user.Service
 	Tokens       *token.Service
 	CloudDirs    *cloudstore.DirService
+	CloudFiles   *cloudstore.FileService
+	Cursors      *pagination.Codec
+	Avatars      *avatar.Service
+	Invites      *invite.Service
+
+	// Hooks runs the callbacks registered on CloudHooks and TokenHooks. If nil, no callbacks
+	// are run.
+	Hooks *hook.Queue
+
+	// CloudHooks and TokenHooks are the Hooks passed to the cloudstore services and Tokens.
+	// Callbacks may be registered on them until NewServer is called.
+	CloudHooks *cloudstore.Hooks
+	TokenHooks *token.Hooks
+
+	// LogHooks registers the callbacks that log every hook point.
+	LogHooks bool
+
+	// StatementBudget is the number of database statements a request may execute before a warning is
+	// logged. If zero, statements are not counted.
+	StatementBudget int
+
+	// Security records the failed logins of users. If nil, nothing is recorded.
+	Security *security.Recorder
+
+	// TrustedProxies are the proxies trusted to set the X-Forwarded-For header.
+	TrustedProxies []*net.IPNet
+
+	// RegistrationMode is the registration mode. It is one of web.RegistrationOpen, web.RegistrationInvite, or
+	// web.RegistrationClosed.
+	RegistrationMode string
+
+	// AdminUsers are the usernames allowed to use the admin pages.
+	AdminUsers []string
+
+	// ConsoleUsers are the usernames allowed to use the request console. A "*" allows every registered user.
+	ConsoleUsers []string
+
+	// ConsoleAPIURL is the base URL of the Clox API the request console sends requests to.
+	ConsoleAPIURL string
+
+	// StorageQuota is the storage in bytes a user is expected to stay under. The header warns a user near it. If
+	// zero, the header never warns.
+	StorageQuota int64
 
 	dashboard *handler.Dashboard
 	auth      *handler.Auth
 	google    *handler.OAuth2
 	tokens    *handler.Token
+	session   *handler.Session
+	console   *handler.Console
+	dirs      *handler.Directory
+	avatars   *handler.Avatar
+	invites   *handler.Invite
+	activity  *handler.Activity
 
 	sessionMiddleware  *middleware.Session
 	flashMiddleware    *middleware.Flash
 	registryMiddleware *middleware.Registry
+	adminMiddleware    *middleware.Admin
+	navMiddleware      *middleware.Nav
 }
 
-// init initializes and validates App. If any required fields in App are not defined an error is returned.
+// init initializes and validates App, and sets all of its routes. If any required fields in App are not defined, or
+// a route cannot be set, an error is returned.
 func (a *App) init() error {
 	if err := a.Template.Parse(); err != nil {
 		return fmt.Errorf("parsing templates: %w", err)
 	}
 
-	if err := a.CloudDirs.SetupRoot(); err != nil {
-		return fmt.Errorf("setting up root storage directory: %w", err)
+	if a.LogHooks {
+		a.registerLogHooks()
 	}
 
-	googleAuthenticator := auth.NewAuthenticator(a.GoogleOAuth2, google.New(a.GoogleOAuth2), a.Users, a.Sessions)
-	registry := auth.NewRegistry(a.Users, a.Sessions, a.CloudDirs, a.Logger)
+	googleAuthenticator := auth.NewAuthenticator(a.GoogleOAuth2, google.New(a.GoogleOAuth2), a.Users, a.Sessions, a.Security, a.TrustedProxies)
+	registry := auth.NewRegistry(a.Users, a.Sessions, a.CloudDirs, a.Avatars, a.Invites, a.RegistrationMode, a.Logger)
 
-	a.dashboard = handler.NewDashboard(a.Template, a.Logger)
+	a.dashboard = handler.NewDashboard(registry, a.Security, a.Cookies, a.Template, a.Logger)
 	a.auth = handler.NewAuth(registry, a.Cookies, a.Template, a.Logger)
 	a.google = handler.NewOAuth2(googleAuthenticator, a.Cookies, a.Logger)
-	a.tokens = handler.NewToken(a.Tokens, a.Cookies, a.Template, a.Logger)
+	a.tokens = handler.NewToken(a.Tokens, a.Cookies, a.Cursors, a.Template, a.Logger)
+	a.session = handler.NewSession(a.Logger)
+	a.console = handler.NewConsole(a.Tokens, a.ConsoleUsers, a.ConsoleAPIURL, a.Template, a.Logger)
+	a.dirs = handler.NewDirectory(a.CloudDirs, a.CloudFiles, a.Cursors, a.Cookies, a.Template, a.Logger)
+	a.avatars = handler.NewAvatar(a.Avatars, a.Cookies, a.Logger)
+	a.invites = handler.NewInvite(a.Invites, a.Cookies, a.Template, a.Logger)
+	a.activity = handler.NewActivity(a.Security, a.Template, a.Logger)
 
-	a.sessionMiddleware = middleware.NewSession(a.Sessions, a.Cookies, a.Logger)
+	a.sessionMiddleware = middleware.NewSession(a.Sessions, a.Cookies, a.Users, a.Logger)
 	a.flashMiddleware = middleware.NewFlash(a.Cookies)
-	a.registryMiddleware = middleware.NewRegistry(a.Cookies, a.Logger)
+	a.registryMiddleware = middleware.NewRegistry(a.Cookies, registry.Mode(), a.Logger)
+	a.adminMiddleware = middleware.NewAdmin(a.AdminUsers)
+	a.navMiddleware = middleware.NewNav(web.NewNavBuilder(a.adminMiddleware.IsAdmin, a.StorageQuota, a.storageUsed), a.Logger)
+
+	a.setRoutes()
+	a.setStaticAssets()
+	if err := a.Server.Err(); err != nil {
+		return fmt.Errorf("setting routes: %w", err)
+	}
 
 	return nil
 }
 
+// registerLogHooks registers the callbacks that log every hook point on CloudHooks and
+// TokenHooks.
+func (a *App) registerLogHooks() {
+	if a.CloudHooks != nil {
+		a.CloudHooks.Log(a.Logger)
+	}
+
+	if a.TokenHooks != nil {
+		a.TokenHooks.Log(a.Logger)
+	}
+}
+
 // setRoutes sets all the route handlers for App.
 func (a *App) setRoutes() {
+	a.Server.Use(server.Recover(a.Logger), server.RequestInfo(a.TrustedProxies), server.MaxQuery())
+
+	if a.StatementBudget > 0 {
+		a.Server.Use(server.StatementBudget(a.StatementBudget, a.Logger))
+	}
+
+	// Routes requested with fetch by the frontend javascript answer errors with JSON, even when the request does not
+	// ask for it.
+	json := server.Format(app.FormatJSON)
+
+	active := server.Named("session.Active", a.sessionMiddleware.Active)
+	inactive := server.Named("session.Inactive", a.sessionMiddleware.Inactive)
+	registered := server.Named("registry.IsRegistered", a.registryMiddleware.IsRegistered)
+	notRegistered := server.Named("registry.NotRegistered", a.registryMiddleware.NotRegistered)
+	flash := server.Named("flash.Extract", a.flashMiddleware.Extract)
+	admin := server.Named("admin.Require", a.adminMiddleware.Require)
+	nav := server.Named("nav.Build", a.navMiddleware.Build)
+
 	a.Server.SetRoute("GET", web.URLDashboard, a.dashboard.Template(),
-		a.sessionMiddleware.Active,
-		a.registryMiddleware.IsRegistered,
-		a.flashMiddleware.Extract)
+		active,
+		registered,
+		flash,
+		nav)
+
+	a.Server.SetRoute("GET", web.URLLanding, a.auth.TemplateLanding(),
+		inactive,
+		flash,
+		nav)
 
 	a.Server.SetRoute("GET", web.URLLogin, a.auth.TemplateLogin(),
-		a.sessionMiddleware.Inactive,
-		a.flashMiddleware.Extract)
+		inactive,
+		flash,
+		nav)
+
+	a.Server.SetRoute("GET", web.URLUnverified, a.auth.TemplateUnverified(),
+		inactive,
+		flash,
+		nav)
 
 	a.Server.SetRoute("GET", web.URLGoogleLogin, a.google.Redirect())
 
 	a.Server.SetRoute("GET", web.URLGoogleCallback, a.google.Callback())
 
 	a.Server.SetRoute("GET", web.URLRegister, a.auth.TemplateRegister(),
-		a.sessionMiddleware.Active,
-		a.registryMiddleware.NotRegistered,
-		a.flashMiddleware.Extract)
+		active,
+		notRegistered,
+		flash,
+		nav)
+
+	a.Server.SetRoute("GET", web.URLRegisterClosed, a.auth.TemplateRegisterClosed(),
+		active,
+		flash,
+		nav)
 
 	a.Server.SetRoute("GET", web.URLTokens, a.tokens.TemplateListing(),
-		a.sessionMiddleware.Active,
-		a.registryMiddleware.IsRegistered,
-		a.flashMiddleware.Extract)
+		active,
+		registered,
+		flash,
+		nav)
+
+	a.Server.SetRoute("GET", web.URLConsole, a.console.Template(),
+		active,
+		registered,
+		flash,
+		nav)
+
+	a.Server.SetRoute("GET", web.URLActivity, a.activity.Template(),
+		active,
+		registered,
+		flash,
+		nav)
+
+	a.Server.SetRoute("GET", web.URLAdminInvites, a.invites.TemplateListing(),
+		active,
+		registered,
+		admin,
+		flash,
+		nav)
+
+	a.Server.SetRoute("POST", web.URLAdminInvites, a.invites.Generate(),
+		active,
+		registered,
+		admin)
 
 	a.Server.SetRoute("POST", web.URLRegister, a.auth.Register(),
-		a.sessionMiddleware.Active,
-		a.registryMiddleware.NotRegistered)
+		json,
+		active,
+		notRegistered)
+
+	a.Server.SetRoute("GET", web.URLAvatar, a.avatars.Serve(),
+		active)
+
+	a.Server.SetRoute("POST", web.URLAvatarRefresh, a.avatars.Refresh(),
+		active,
+		registered)
+
+	a.Server.SetRoute("POST", web.URLStorageRetry, a.dashboard.RetryStorage(),
+		active,
+		registered)
 
 	a.Server.SetRoute("POST", web.URLLogout, a.auth.Logout(),
-		a.sessionMiddleware.Active)
+		active)
+
+	a.Server.SetRoute("GET", web.URLFiles, a.dirs.Template(),
+		active,
+		registered,
+		nav)
+
+	a.Server.SetRoute("GET", web.URLFilesDir, a.dirs.Template(),
+		active,
+		registered,
+		nav)
+
+	a.Server.SetRoute("GET", web.URLFileInfo, a.dirs.FileInfoTemplate(),
+		active,
+		registered,
+		nav)
 
 	a.Server.SetRoute("POST", web.URLTokens, a.tokens.Generate(),
-		a.sessionMiddleware.Active,
-		a.registryMiddleware.IsRegistered)
+		json,
+		active,
+		registered)
 
 	a.Server.SetRoute("DELETE", web.URLTokenResource, a.tokens.Delete(),
-		a.sessionMiddleware.Active,
-		a.registryMiddleware.IsRegistered)
+		json,
+		active,
+		registered)
+
+	a.Server.SetRoute("POST", web.URLTokensInvalidate, a.tokens.InvalidateAll(),
+		json,
+		active,
+		registered)
+
+	a.Server.SetRoute("GET", web.URLAPISession, a.session.JSON(),
+		json,
+		active)
+
+	a.Server.SetRoute("GET", web.URLAPITokens, a.tokens.ListJSON(),
+		json,
+		active,
+		registered)
+
+	a.Server.SetRoute("GET", web.URLAPIEntries, a.dirs.EntriesJSON(),
+		json,
+		active,
+		registered)
+
+	a.Server.SetRoute("GET", web.URLAPIUploadStats, a.dirs.UploadStatsJSON(),
+		json,
+		active,
+		registered)
 }
 
 // setStaticAssets sets all the static asset handlers for App.
@@ -115,14 +317,104 @@ func (a *App) setStaticAssets() {
 	a.Server.SetStatic("/web/static/*", fsHandlerFunc)
 }
 
-// Start will initialize, set all the routes, and start App.
-func (a *App) Start() error {
+// Open opens the shared services of config with bootstrap.Open and builds the server of the
+// server side app on them with NewServer. The returned func stops the background work and closes
+// the services.
+func Open(config *web.Config, logger *log.Logger) (*server.HTTP, func(), error) {
+	s, err := bootstrap.Open(config.Config, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srv, stop, err := NewServer(config, s)
+	if err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+
+	return srv, func() {
+		stop()
+		s.Close()
+	}, nil
+}
+
+// NewServer builds the App of the server side app from config on the shared services s,
+// initializes it, and starts its background work. The returned server.HTTP serves the app,
+// with Start or as a http.Handler, and the returned func stops the background work. It does
+// not close s.
+func NewServer(config *web.Config, s *bootstrap.Services) (*server.HTTP, func(), error) {
+	a := New(config, s)
+
+	ctx, stop := context.WithCancel(context.Background())
+	if err := a.run(ctx); err != nil {
+		stop()
+		return nil, nil, err
+	}
+
+	return a.Server, stop, nil
+}
+
+// New builds the App of the server side app from config on the shared services s. The App
+// is not initialized.
+func New(config *web.Config, s *bootstrap.Services) *App {
+	s.Users.RequireVerifiedEmail(config.RequireVerifiedEmail)
+
+	return &App{
+		Server:   server.New(config.Host, config.Port, router.NewChi()),
+		Logger:   s.Logger,
+		Template: template.New("clox", "web/templates", s.Logger),
+		GoogleOAuth2: oauth2.Google(&oauth2.Config{
+			ClientID:          config.GoogleOAuthClientID,
+			ClientSecret:      config.GoogleOAuthClientSecret,
+			RedirectURLScheme: config.OAuthCallbackScheme(),
+			RedirectURLHost:   config.Host,
+			RedirectURLPort:   config.Port,
+			RedirectURLPath:   "login/google/callback",
+		}),
+		Cookies:       cookie.NewManager(config.SecureCookie(), config.Host),
+		Sessions:      session.NewManager(s.Cache),
+		Users:         s.Users,
+		Tokens:        s.Tokens,
+		CloudDirs:     s.CloudDirs,
+		CloudFiles:    s.CloudFiles,
+		Cursors:       s.Cursors,
+		Avatars:       avatar.NewService(s.CloudPaths.System("avatars"), s.Logger),
+		Invites:       invite.NewService(invite.NewRepo(s.DB)),
+		Security:      s.Security,
+		Hooks:         s.Hooks,
+		CloudHooks:    s.CloudHooks,
+		TokenHooks:    s.TokenHooks,
+		ConsoleUsers:  config.ConsoleUsers,
+		ConsoleAPIURL: config.ConsoleAPIURL,
+
+		RegistrationMode: config.RegistrationMode,
+		AdminUsers:       config.AdminUsers,
+		TrustedProxies:   config.TrustedProxies,
+		LogHooks:         config.LogHooks,
+		StatementBudget:  config.StatementBudget,
+		StorageQuota:     config.StorageQuota,
+	}
+}
+
+// storageUsed gets the bytes of storage used by a user, it is the web.UsageFunc of the navigation bar.
+func (a *App) storageUsed(ctx context.Context, userID string) (int64, error) {
+	usage, err := a.CloudDirs.Usage(ctx, userID)
+	return usage.Used, err
+}
+
+// run initializes App and starts its background work until ctx is done.
+func (a *App) run(ctx context.Context) error {
 	if err := a.init(); err != nil {
 		return fmt.Errorf("initializing App: %w", err)
 	}
 
-	a.setRoutes()
-	a.setStaticAssets()
+	if a.Security != nil {
+		go a.Security.Run(ctx)
+	}
+
+	if a.Hooks != nil {
+		go a.Hooks.Run(ctx)
+	}
 
-	return a.Server.Start()
+	return nil
 }
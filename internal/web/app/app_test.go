@@ -0,0 +1,214 @@
+package app
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/bootstrap"
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/internal/db"
+	"github.com/cicconee/clox/internal/router"
+	"github.com/cicconee/clox/internal/server"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/template"
+)
+
+// newTestApp initializes a with every route set, without any services. The routes are
+// only introspected, never served.
+func newTestApp(t *testing.T, a *App) *App {
+	t.Helper()
+
+	a.Server = server.New("localhost", "0", router.NewChi())
+	a.Logger = log.New(io.Discard, "", 0)
+	a.Template = template.New("test", t.TempDir(), a.Logger)
+
+	if err := a.init(); err != nil {
+		t.Fatalf("init() error = %v", err)
+	}
+
+	return a
+}
+
+// chains returns the middleware chain of every route of a, keyed by method and pattern.
+func chains(a *App) map[string][]string {
+	m := map[string][]string{}
+	for _, r := range a.Server.Routes() {
+		m[r.Method+" "+r.Pattern] = r.Middlewares
+	}
+
+	return m
+}
+
+var globalChain = []string{
+	"server.Recover",
+	"server.RequestInfo",
+	"server.MaxQuery",
+}
+
+func TestRouteMiddlewareOrder(t *testing.T) {
+	routes := chains(newTestApp(t, &App{}))
+
+	page := []string{"session.Active", "registry.IsRegistered", "flash.Extract", "nav.Build"}
+	files := []string{"session.Active", "registry.IsRegistered", "nav.Build"}
+	action := []string{"session.Active", "registry.IsRegistered"}
+	json := []string{"server.Format(json)", "session.Active", "registry.IsRegistered"}
+	public := []string{"session.Inactive", "flash.Extract", "nav.Build"}
+
+	tests := []struct {
+		method  string
+		pattern string
+		want    []string
+	}{
+		{"GET", web.URLDashboard, page},
+		{"GET", web.URLTokens, page},
+		{"GET", web.URLConsole, page},
+		{"GET", web.URLActivity, page},
+		{"GET", web.URLLanding, public},
+		{"GET", web.URLLogin, public},
+		{"GET", web.URLUnverified, public},
+		{"GET", web.URLGoogleLogin, nil},
+		{"GET", web.URLGoogleCallback, nil},
+		{"GET", web.URLRegister, []string{"session.Active", "registry.NotRegistered", "flash.Extract", "nav.Build"}},
+		{"POST", web.URLRegister, []string{"server.Format(json)", "session.Active", "registry.NotRegistered"}},
+		{"GET", web.URLRegisterClosed, []string{"session.Active", "flash.Extract", "nav.Build"}},
+		{"GET", web.URLAdminInvites, []string{"session.Active", "registry.IsRegistered", "admin.Require", "flash.Extract", "nav.Build"}},
+		{"POST", web.URLAdminInvites, []string{"session.Active", "registry.IsRegistered", "admin.Require"}},
+		{"GET", web.URLAvatar, []string{"session.Active"}},
+		{"POST", web.URLAvatarRefresh, action},
+		{"POST", web.URLStorageRetry, action},
+		{"POST", web.URLLogout, []string{"session.Active"}},
+		{"GET", web.URLFiles, files},
+		{"GET", web.URLFilesDir, files},
+		{"GET", web.URLFileInfo, files},
+		{"POST", web.URLTokens, json},
+		{"DELETE", web.URLTokenResource, json},
+		{"POST", web.URLTokensInvalidate, json},
+		{"GET", web.URLAPISession, []string{"server.Format(json)", "session.Active"}},
+		{"GET", web.URLAPITokens, json},
+		{"GET", web.URLAPIEntries, json},
+		{"GET", web.URLAPIUploadStats, json},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.pattern, func(t *testing.T) {
+			got, ok := routes[tc.method+" "+tc.pattern]
+			if !ok {
+				t.Fatalf("route is not set")
+			}
+
+			want := append(append([]string{}, globalChain...), tc.want...)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("middlewares = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoutesSessionFirst(t *testing.T) {
+	for key, got := range chains(newTestApp(t, &App{})) {
+		route := got[len(globalChain):]
+		active := indexOf(route, "session.Active")
+
+		// The registry and admin middlewares read the user of the session, so they must run
+		// inside session.Active, in that order.
+		last := active
+		for _, name := range []string{"registry.IsRegistered", "registry.NotRegistered", "admin.Require"} {
+			i := indexOf(route, name)
+			if i < 0 {
+				continue
+			}
+
+			if active < 0 || i < last {
+				t.Errorf("%s: middlewares = %v, want session.Active, the registry, then admin.Require", key, got)
+			}
+			last = i
+		}
+	}
+}
+
+func TestGlobalStatementBudget(t *testing.T) {
+	routes := chains(newTestApp(t, &App{StatementBudget: 10}))
+
+	got := routes["POST "+web.URLLogout]
+	want := append(append([]string{}, globalChain...), "server.StatementBudget(10)", "session.Active")
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("middlewares = %v, want %v", got, want)
+	}
+}
+
+// indexOf returns the index of name in names, or -1 if it is not in names.
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func TestNewServer(t *testing.T) {
+	// The templates and static assets are read relative to the root of the repository.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getting working directory: %v", err)
+	}
+	if err := os.Chdir("../../.."); err != nil {
+		t.Fatalf("changing to repository root: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	config := &web.Config{
+		Config: &app.Config{
+			Host:            "localhost",
+			Port:            "0",
+			FileStorePath:   t.TempDir(),
+			DisplayLocation: time.UTC,
+		},
+		RegistrationMode: web.RegistrationOpen,
+	}
+
+	pg := &db.Postgres{}
+	if err := pg.Open("127.0.0.1", "1", "clox", "clox", "clox"); err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+
+	redis := &cache.Redis{}
+	redis.Open("127.0.0.1", "1", "", "")
+
+	s, err := bootstrap.New(config.Config, pg, redis, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("bootstrap.New() error = %v", err)
+	}
+	defer s.Close()
+
+	srv, stop, err := NewServer(config, s)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer stop()
+
+	tests := []struct {
+		pattern string
+		want    int
+	}{
+		{web.URLLogin, http.StatusOK},
+		{web.URLDashboard, http.StatusFound},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.pattern, nil))
+
+		if w.Code != tc.want {
+			t.Errorf("GET %s status = %d, want %d", tc.pattern, w.Code, tc.want)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package auth
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 
+	"github.com/cicconee/clox/internal/app"
 	"github.com/cicconee/clox/internal/oauth2"
+	"github.com/cicconee/clox/internal/security"
 	"github.com/cicconee/clox/internal/user"
 	"github.com/cicconee/clox/internal/web/session"
 	"github.com/cicconee/clox/pkg/random"
@@ -24,18 +27,27 @@ type GenerateValidater interface {
 }
 
 type Authenticator struct {
-	oauth2   GenerateValidater
-	provider user.Provider
-	users    *user.Service
-	sessions *session.Manager
+	oauth2         GenerateValidater
+	provider       user.Provider
+	users          *user.Service
+	sessions       *session.Manager
+	security       *security.Recorder
+	trustedProxies []*net.IPNet
 }
 
-func NewAuthenticator(oauth2 GenerateValidater, provider user.Provider, users *user.Service, sessions *session.Manager) *Authenticator {
+// NewAuthenticator creates a new Authenticator.
+//
+// The failed logins of a user the provider identified are recorded with recorder. If recorder
+// is nil, nothing is recorded. The trustedProxies are the proxies that are trusted to set the
+// X-Forwarded-For header when determining the client IP of a recorded login.
+func NewAuthenticator(oauth2 GenerateValidater, provider user.Provider, users *user.Service, sessions *session.Manager, recorder *security.Recorder, trustedProxies []*net.IPNet) *Authenticator {
 	return &Authenticator{
-		oauth2:   oauth2,
-		provider: provider,
-		users:    users,
-		sessions: sessions,
+		oauth2:         oauth2,
+		provider:       provider,
+		users:          users,
+		sessions:       sessions,
+		security:       recorder,
+		trustedProxies: trustedProxies,
 	}
 }
 
@@ -54,20 +66,21 @@ func (a *Authenticator) Authenticate(r *http.Request, state string) (*session.Us
 		return nil, fmt.Errorf("getting user token: %w", err)
 	}
 
-	user, err := a.users.Authenticate(r.Context(), a.provider, token)
+	u, err := a.users.Authenticate(r.Context(), a.provider, token)
 	if err != nil {
 		return nil, fmt.Errorf("authenticating user: %w", err)
 	}
 
 	userSession := session.User{
 		SessionID:          random.ID(32),
-		UserID:             user.ID,
-		FirstName:          user.FirstName,
-		LastName:           user.LastName,
-		PictureURL:         user.PictureURL,
-		Email:              user.Email,
-		Username:           user.Username,
-		RegistrationStatus: user.RegistrationStatus,
+		UserID:             u.ID,
+		FirstName:          u.FirstName,
+		LastName:           u.LastName,
+		PictureURL:         u.PictureURL,
+		Email:              u.Email,
+		Username:           u.Username,
+		RegistrationStatus: u.RegistrationStatus,
+		EmailVerified:      u.EmailVerified,
 	}
 	// If a user has an invalid registration status (blocked or unexpected value), do not
 	// set the session in session storage. If the session were to be set in storage, it
@@ -75,14 +88,24 @@ func (a *Authenticator) Authenticate(r *http.Request, state string) (*session.Us
 	// to a endpoint that requires an inactive session, the session middleware will verify
 	// that the session is inactive (session key not in storage), and then clear the session
 	// key from cookies.
-	if !user.ValidRegistration() {
+	if !u.ValidRegistration() {
+		if u.RegistrationStatus == user.Blocked {
+			a.record(r, u.ID, security.TypeLoginBlocked, "login while the account is blocked")
+		}
+
 		return &userSession, nil
 	}
 
 	err = a.sessions.Set(r.Context(), userSession)
 	if err != nil {
+		a.record(r, u.ID, security.TypeLoginFailed, "session could not be created")
 		return nil, fmt.Errorf("setting user session: %w", err)
 	}
 
 	return &userSession, nil
 }
+
+// record records a failed login of the user userID.
+func (a *Authenticator) record(r *http.Request, userID string, eventType string, detail string) {
+	a.security.Record(security.NewEvent(r, app.ClientIP(r, a.trustedProxies), userID, eventType, detail))
+}
@@ -2,11 +2,17 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/avatar"
 	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/invite"
 	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/internal/web"
 	"github.com/cicconee/clox/internal/web/session"
 )
 
@@ -15,19 +21,44 @@ type Registry struct {
 	users    *user.Service
 	sessions *session.Manager
 	dirs     *cloudstore.DirService
+	avatars  *avatar.Service
+	invites  *invite.Service
+	mode     string
 	log      *log.Logger
 }
 
-// NewRegistry creates a new Registry.
-func NewRegistry(users *user.Service, sessions *session.Manager, dirs *cloudstore.DirService, log *log.Logger) *Registry {
-	return &Registry{users: users, sessions: sessions, dirs: dirs, log: log}
+// NewRegistry creates a new Registry. The registration mode is one of web.RegistrationOpen,
+// web.RegistrationInvite, or web.RegistrationClosed. If empty, it defaults to web.RegistrationOpen.
+func NewRegistry(users *user.Service, sessions *session.Manager, dirs *cloudstore.DirService, avatars *avatar.Service, invites *invite.Service, mode string, log *log.Logger) *Registry {
+	if mode == "" {
+		mode = web.RegistrationOpen
+	}
+
+	return &Registry{users: users, sessions: sessions, dirs: dirs, avatars: avatars, invites: invites, mode: mode, log: log}
+}
+
+// Mode returns the registration mode.
+func (r *Registry) Mode() string {
+	return r.mode
 }
 
 // Register persists a user. Once registered, the session is updated to reflect the users new state.
 //
-// Upon success, a root storage directory is created for the user. If creating the directory fails
-// it will be logged.
-func (r *Registry) Register(ctx context.Context, session session.User) error {
+// If the registration mode is web.RegistrationClosed, a 403 app.WrappedSafeError is returned. If it
+// is web.RegistrationInvite, inviteCode is redeemed before the user is persisted, and released if
+// the user is not persisted. inviteCode is ignored in the other modes.
+//
+// Upon success, a root storage directory is provisioned for the user in the background, so that
+// registration does not wait on the file system. The users storage state is pending until the
+// directory is created. If provisioning fails, it is logged and the storage state is set to failed.
+//
+// The users provider picture is also fetched and stored as their avatar in the background.
+func (r *Registry) Register(ctx context.Context, session session.User, inviteCode string) error {
+	inviteID, err := r.redeem(ctx, session.UserID, inviteCode)
+	if err != nil {
+		return err
+	}
+
 	user, err := r.users.Register(ctx, user.Registration{
 		ID:         session.UserID,
 		FirstName:  session.FirstName,
@@ -35,8 +66,11 @@ func (r *Registry) Register(ctx context.Context, session session.User) error {
 		PictureURL: session.PictureURL,
 		Email:      session.Email,
 		Username:   session.Username,
+
+		EmailVerified: session.EmailVerified,
 	})
 	if err != nil {
+		r.release(inviteID)
 		return fmt.Errorf("registering user: %w", err)
 	}
 
@@ -47,16 +81,66 @@ func (r *Registry) Register(ctx context.Context, session session.User) error {
 		return fmt.Errorf("setting user session: %w", err)
 	}
 
-	dir, err := r.dirs.NewUser(ctx, user.ID)
+	// The request context is canceled once the response is written.
+	go r.Provision(context.Background(), user.ID)
+	r.avatars.FetchAsync(user.ID, user.PictureURL)
+
+	return nil
+}
+
+// redeem checks the registration mode allows the user to register. In invite mode the invite
+// with code is redeemed and its ID is returned, otherwise the ID is empty.
+func (r *Registry) redeem(ctx context.Context, userID string, code string) (string, error) {
+	switch r.mode {
+	case web.RegistrationClosed:
+		return "", app.Wrap(app.WrapParams{
+			Err:         errors.New("registration is closed"),
+			SafeMessage: "Registrations are closed.",
+			StatusCode:  http.StatusForbidden,
+		})
+	case web.RegistrationInvite:
+		id, err := r.invites.Redeem(ctx, code, userID)
+		if err != nil {
+			return "", fmt.Errorf("redeeming invite: %w", err)
+		}
+
+		return id, nil
+	default:
+		return "", nil
+	}
+}
+
+// release releases the invite with id so it may be redeemed again. If id is empty, nothing
+// happens. Errors are logged.
+func (r *Registry) release(id string) {
+	if id == "" {
+		return
+	}
+
+	// The request context may be canceled before the invite is released.
+	if err := r.invites.Release(context.Background(), id); err != nil {
+		r.log.Printf("[ERROR] Releasing invite: %v\n", err)
+	}
+}
+
+// Provision creates the root storage directory for a user. It is safe to call multiple times,
+// if the directory already exists it is left as is. The result is logged and returned.
+func (r *Registry) Provision(ctx context.Context, userID string) error {
+	dir, err := r.dirs.NewUser(ctx, userID)
 	if err != nil {
-		r.log.Printf("[ERROR] Creating root storage [user: %s]: %v\n", user.ID, err)
-	} else {
-		r.log.Printf("[INFO] Created root storage [user: %s, dir: %s]\n", user.ID, dir.ID)
+		r.log.Printf("[ERROR] Creating root storage [user: %s]: %v\n", userID, err)
+		return err
 	}
 
+	r.log.Printf("[INFO] Root storage ready [user: %s, dir: %s]\n", userID, dir.ID)
 	return nil
 }
 
+// StorageState gets the provisioning state of a users root storage.
+func (r *Registry) StorageState(ctx context.Context, userID string) (cloudstore.StorageState, error) {
+	return r.dirs.StorageState(ctx, userID)
+}
+
 // Logout logs out a user. The session is deleted from the cache.
 func (r *Registry) Logout(ctx context.Context, user session.User) error {
 	return r.sessions.Del(ctx, user)
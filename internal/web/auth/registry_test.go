@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/invite"
+	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+// The users are not reached in these tests, the registration is refused before the user is
+// registered.
+func TestRegistryRegisterRefused(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		code   string
+		status int
+		target error
+	}{
+		{name: "closed", mode: web.RegistrationClosed, code: "code", status: http.StatusForbidden},
+		{name: "invite without code", mode: web.RegistrationInvite, code: " ", status: http.StatusForbidden, target: invite.ErrInvalid},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRegistry(nil, nil, nil, nil, invite.NewService(nil), tc.mode, log.New(io.Discard, "", 0))
+
+			err := r.Register(context.Background(), session.User{UserID: "user", RegistrationStatus: user.Incomplete}, tc.code)
+
+			var safeErr app.SafeError
+			if !errors.As(err, &safeErr) {
+				t.Fatalf("Register() error = %v, want a app.SafeError", err)
+			}
+
+			if _, status := safeErr.Safe(); status != tc.status {
+				t.Errorf("status = %d, want %d", status, tc.status)
+			}
+
+			if tc.target != nil && !errors.Is(err, tc.target) {
+				t.Errorf("Register() error = %v, want it to wrap %v", err, tc.target)
+			}
+		})
+	}
+}
+
+func TestNewRegistryDefaultMode(t *testing.T) {
+	if got := NewRegistry(nil, nil, nil, nil, nil, "", nil).Mode(); got != web.RegistrationOpen {
+		t.Errorf("Mode() = %q, want %q", got, web.RegistrationOpen)
+	}
+}
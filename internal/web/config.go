@@ -5,6 +5,19 @@ import (
 	"github.com/cicconee/clox/internal/app"
 	"github.com/cicconee/clox/pkg/env"
 	"os"
+	"strings"
+)
+
+// The registration modes. The registration mode is set with the REGISTRATION_MODE environment variable.
+const (
+	// RegistrationOpen allows anyone to register. This is the default.
+	RegistrationOpen string = "open"
+
+	// RegistrationInvite requires a invite code generated by an admin to register.
+	RegistrationInvite string = "invite"
+
+	// RegistrationClosed prevents new users from registering. Registered users can still log in.
+	RegistrationClosed string = "closed"
 )
 
 // A Config is the web application configuration for the Clox server side app.
@@ -12,6 +25,28 @@ type Config struct {
 	*app.Config
 	GoogleOAuthClientID     string
 	GoogleOAuthClientSecret string
+
+	// ConsoleUsers are the usernames allowed to use the request console. A "*" allows every registered user. If
+	// empty, the request console is disabled. Set with the CONSOLE_USERS environment variable as a comma separated
+	// list.
+	ConsoleUsers []string
+
+	// ConsoleAPIURL is the base URL of the Clox API that the request console sends requests to. Set with the
+	// CONSOLE_API_URL environment variable.
+	ConsoleAPIURL string
+
+	// RequireVerifiedEmail prevents users with an email that is not verified by the provider from registering.
+	// Set with the REQUIRE_VERIFIED_EMAIL environment variable to "true".
+	RequireVerifiedEmail bool
+
+	// RegistrationMode is the registration mode. It is one of RegistrationOpen, RegistrationInvite, or
+	// RegistrationClosed.
+	RegistrationMode string
+
+	// StorageQuota is the storage in bytes a user is expected to stay under. It is not enforced, the header warns
+	// a user once they used NearQuotaRatio of it. Set with the STORAGE_QUOTA environment variable, such as "10GB".
+	// If zero, the header never warns.
+	StorageQuota int64
 }
 
 // LoadConfig will load the application configuration and set the remaining values based on the environment variables.
@@ -21,11 +56,37 @@ func LoadConfig(loader *env.Loader) (*Config, error) {
 		return nil, fmt.Errorf("loading app configuration: %w", err)
 	}
 
-	return &Config{
+	config := &Config{
 		Config:                  appConfig,
 		GoogleOAuthClientID:     os.Getenv("GOOGLE_OAUTH_CLIENT_ID"),
 		GoogleOAuthClientSecret: os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"),
-	}, nil
+		ConsoleAPIURL:           os.Getenv("CONSOLE_API_URL"),
+		RequireVerifiedEmail:    os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+	}
+
+	switch mode := os.Getenv("REGISTRATION_MODE"); mode {
+	case "":
+		config.RegistrationMode = RegistrationOpen
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+		config.RegistrationMode = mode
+	default:
+		return nil, fmt.Errorf("invalid REGISTRATION_MODE %q: must be %q, %q, or %q", mode, RegistrationOpen, RegistrationInvite, RegistrationClosed)
+	}
+
+	if u := os.Getenv("CONSOLE_USERS"); u != "" {
+		config.ConsoleUsers = strings.Split(u, ",")
+	}
+
+	config.StorageQuota, err = env.Bytes("STORAGE_QUOTA", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ConsoleAPIURL == "" {
+		config.ConsoleAPIURL = fmt.Sprintf("%s://%s:%s", config.OAuthCallbackScheme(), config.Host, os.Getenv("API_PORT"))
+	}
+
+	return config, nil
 }
 
 // OAuthCallbackScheme will return the scheme used by the OAuth2 provider callback functions.
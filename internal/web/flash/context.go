@@ -1,36 +1,27 @@
 package flash
 
-import "context"
+import (
+	"context"
 
-type contextKey string
-
-var (
-	messageContextKey contextKey = "flash_message"
-	errorContextKey   contextKey = "flash_error"
+	"github.com/cicconee/clox/internal/reqinfo"
 )
 
+// Deprecated: Use reqinfo.SetFlash.
 func SetErrorContext(ctx context.Context, err string) context.Context {
-	return context.WithValue(ctx, errorContextKey, err)
+	return reqinfo.SetFlash(ctx, reqinfo.From(ctx).FlashMessage, err)
 }
 
+// Deprecated: Use reqinfo.From.
 func GetErrorContext(ctx context.Context) string {
-	flashErr, ok := ctx.Value(errorContextKey).(string)
-	if !ok {
-		return ""
-	}
-
-	return flashErr
+	return reqinfo.From(ctx).FlashError
 }
 
+// Deprecated: Use reqinfo.SetFlash.
 func SetMessageContext(ctx context.Context, msg string) context.Context {
-	return context.WithValue(ctx, messageContextKey, msg)
+	return reqinfo.SetFlash(ctx, msg, reqinfo.From(ctx).FlashError)
 }
 
+// Deprecated: Use reqinfo.From.
 func GetMessageContext(ctx context.Context) string {
-	flash, ok := ctx.Value(messageContextKey).(string)
-	if !ok {
-		return ""
-	}
-
-	return flash
+	return reqinfo.From(ctx).FlashMessage
 }
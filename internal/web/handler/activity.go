@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/security"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/session"
+	"github.com/cicconee/clox/internal/web/template"
+)
+
+// Activity encapsulates the handlers for the security activity of a user.
+type Activity struct {
+	security *security.Recorder
+	tmpl     *template.Template
+	log      *log.Logger
+}
+
+// NewActivity creates a new Activity.
+func NewActivity(security *security.Recorder, tmpl *template.Template, log *log.Logger) *Activity {
+	return &Activity{security: security, tmpl: tmpl, log: log}
+}
+
+// Template executes the activity template. It lists the most recent failed authentications of
+// the user.
+//
+// Template expects a registered session.User in the request context.
+func (a *Activity) Template() http.HandlerFunc {
+	type row struct {
+		CreatedAt   app.Time
+		Description string
+		Detail      string
+		IP          string
+		UserAgent   string
+	}
+
+	type data struct {
+		Events []row
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		d := data{Events: []row{}}
+
+		var alert *template.Alert
+		if a.security != nil {
+			events, err := a.security.Recent(r.Context(), user.UserID, security.DefaultListLimit)
+			if err != nil {
+				a.log.Printf("[ERROR] [%s %s] Getting security events: %v\n", r.Method, r.URL.Path, err)
+				alert = &template.Alert{Level: template.AlertDanger, Message: "Activity could not be loaded.", Retryable: true}
+			}
+
+			for _, e := range events {
+				d.Events = append(d.Events, row{
+					CreatedAt:   app.NewTime(e.CreatedAt),
+					Description: e.Description(),
+					Detail:      e.Detail,
+					IP:          e.IP,
+					UserAgent:   e.UserAgent,
+				})
+			}
+		}
+
+		a.tmpl.Execute(w, r, "activity", template.ExecuteParams{
+			Title:  "Activity",
+			PageID: web.PageActivity,
+			Data:   d,
+			Alert:  alert,
+		})
+	}
+}
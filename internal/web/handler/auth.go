@@ -38,42 +38,96 @@ func (a *Auth) TemplateLogin() http.HandlerFunc {
 		}
 
 		a.tmpl.Execute(w, r, "login", template.ExecuteParams{
-			Title:    "Authenticate",
-			PageID:   web.PageLogin,
-			NavLinks: []web.NavLink{web.NavLinkLogin},
-			Data:     d,
+			Title:  "Authenticate",
+			PageID: web.PageLogin,
+			Data:   d,
 		})
 	}
 }
 
-// TemplateRegister executes the register template.
+// TemplateUnverified executes the unverified template. It explains that a verified email is required
+// to register.
+func (a *Auth) TemplateUnverified() http.HandlerFunc {
+	type data struct {
+		LinkLogin web.Link
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.tmpl.Execute(w, r, "unverified", template.ExecuteParams{
+			Title:  "Verify Your Email",
+			PageID: web.PageUnverified,
+			Data:   data{LinkLogin: web.Link{URL: web.URLLogin, Value: "Back to login"}},
+		})
+	}
+}
+
+// TemplateLanding executes the landing template. It is the public page shown to visitors that are
+// not logged in, and describes how to sign up in the registration mode.
+func (a *Auth) TemplateLanding() http.HandlerFunc {
+	type data struct {
+		Mode      string
+		LinkLogin web.Link
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := data{
+			Mode:      a.registry.Mode(),
+			LinkLogin: web.Link{URL: web.URLLogin, Value: "Log in or sign up"},
+		}
+		if d.Mode == web.RegistrationClosed {
+			d.LinkLogin.Value = "Log in"
+		}
+
+		a.tmpl.Execute(w, r, "landing", template.ExecuteParams{
+			Title:  "Clox",
+			PageID: web.PageLanding,
+			Data:   d,
+		})
+	}
+}
+
+// TemplateRegisterClosed executes the closed template. It is shown to users that have not
+// registered when registration is closed.
+func (a *Auth) TemplateRegisterClosed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.tmpl.Execute(w, r, "closed", template.ExecuteParams{
+			Title:  "Registrations Closed",
+			PageID: web.PageClosed,
+		})
+	}
+}
+
+// TemplateRegister executes the register template. In invite mode the template asks for a invite
+// code.
 func (a *Auth) TemplateRegister() http.HandlerFunc {
 	type data struct {
-		LinkRegister web.Link
+		LinkRegister   web.Link
+		InviteRequired bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		data := data{
-			LinkRegister: web.Link{URL: web.URLRegister, Value: "Register"},
+			LinkRegister:   web.Link{URL: web.URLRegister, Value: "Register"},
+			InviteRequired: a.registry.Mode() == web.RegistrationInvite,
 		}
 
 		a.tmpl.Execute(w, r, "register", template.ExecuteParams{
-			Title:         "Register",
-			PageID:        web.PageRegister,
-			Authenticated: true,
-			Data:          data,
+			Title:  "Register",
+			PageID: web.PageRegister,
+			Data:   data,
 		})
 	}
 }
 
-// Register handles post requests to the register endpoint.
+// Register handles post requests to the register endpoint. In invite mode the invite code is read
+// from the "invite" form value.
 func (a *Auth) Register() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := session.GetUserContext(r.Context())
 
 		user.Username = r.FormValue("username")
 
-		err := a.registry.Register(r.Context(), user)
+		err := a.registry.Register(r.Context(), user, r.FormValue("invite"))
 		if err != nil {
 			a.log.Printf("[ERROR] [%s %s] Registering user: %v\n", r.Method, r.URL.Path, err)
 			app.WriteJSONError(w, err)
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/cicconee/clox/internal/avatar"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/cookie"
+	"github.com/cicconee/clox/internal/web/session"
+	"github.com/go-chi/chi/v5"
+)
+
+// Avatar encapsulates the handlers for user avatars.
+type Avatar struct {
+	avatars *avatar.Service
+	cookies *cookie.Manager
+	log     *log.Logger
+}
+
+// NewAvatar creates a new Avatar.
+func NewAvatar(avatars *avatar.Service, cookies *cookie.Manager, log *log.Logger) *Avatar {
+	return &Avatar{avatars: avatars, cookies: cookies, log: log}
+}
+
+// Serve writes the stored avatar of the user in the request path. Clients must revalidate
+// the avatar before using a cached copy, so a refreshed avatar is displayed immediately.
+//
+// Serve expects a session.User in the request context.
+func (a *Avatar) Serve() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := url.PathUnescape(chi.URLParam(r, "userID"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		f, info, err := a.avatars.Open(userID)
+		if err != nil {
+			if errors.Is(err, avatar.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+
+			a.log.Printf("[ERROR] [%s %s] Opening avatar: %v\n", r.Method, r.URL.Path, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+		http.ServeContent(w, r, "", info.ModTime(), f)
+	}
+}
+
+// Refresh fetches the provider picture of the user again and stores it as their avatar.
+// The user is redirected to the dashboard.
+//
+// Refresh expects a registered session.User in the request context.
+func (a *Avatar) Refresh() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		if err := a.avatars.Fetch(r.Context(), user.UserID, user.PictureURL); err != nil {
+			a.log.Printf("[ERROR] [%s %s] Refreshing avatar: %v\n", r.Method, r.URL.Path, err)
+			a.cookies.Set(w, cookie.FlashError, "Your picture could not be refreshed. Please try again later.")
+		} else {
+			a.cookies.Set(w, cookie.FlashMessage, "Your picture was refreshed.")
+		}
+
+		http.Redirect(w, r, web.URLDashboard, http.StatusFound)
+	}
+}
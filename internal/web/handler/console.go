@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/cicconee/clox/internal/api"
+	"github.com/cicconee/clox/internal/token"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/session"
+	"github.com/cicconee/clox/internal/web/template"
+)
+
+// Console encapsulates the handlers for the API request console.
+type Console struct {
+	tokens *token.Service
+	users  map[string]bool
+	apiURL string
+	tmpl   *template.Template
+	log    *log.Logger
+}
+
+// NewConsole creates a console handler. Only the users with a username in users may use the console. If
+// users contains "*", every registered user may use the console. Requests are sent to the API at apiURL.
+func NewConsole(tokens *token.Service, users []string, apiURL string, tmpl *template.Template, log *log.Logger) *Console {
+	allowed := map[string]bool{}
+	for _, u := range users {
+		if u = strings.ToLower(strings.TrimSpace(u)); u != "" {
+			allowed[u] = true
+		}
+	}
+
+	return &Console{
+		tokens: tokens,
+		users:  allowed,
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		tmpl:   tmpl,
+		log:    log,
+	}
+}
+
+// Allowed returns if the user may use the console. Usernames are compared case-insensitively.
+func (c *Console) Allowed(user session.User) bool {
+	return c.users["*"] || c.users[strings.ToLower(user.Username)]
+}
+
+// consoleData is the data of the console template.
+type consoleData struct {
+	Endpoints []api.Endpoint
+	APIURL    string
+	Token     string
+	ExpiresAt string
+
+	// TokenFailed is true if the console token could not be created. Token and ExpiresAt
+	// are empty.
+	TokenFailed bool
+}
+
+// newConsoleData creates the consoleData of a console that sends requests to apiURL with
+// consoleToken. If err is not nil, the console token could not be created and the data
+// is in the failed state.
+func newConsoleData(apiURL string, consoleToken token.NewListing, err error) consoleData {
+	data := consoleData{Endpoints: api.Endpoints(), APIURL: apiURL}
+	if err != nil {
+		data.TokenFailed = true
+		return data
+	}
+
+	data.Token = consoleToken.Token
+	data.ExpiresAt = consoleToken.ExpiresAtString()
+
+	return data
+}
+
+// Template executes the console template which lists the API endpoints and sends requests to them. A console
+// token is minted every time the page is loaded. If the user may not use the console, a 404 is written.
+//
+// Template expects a registered session.User in the request context.
+func (c *Console) Template() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		if !c.Allowed(user) {
+			http.NotFound(w, r)
+			return
+		}
+
+		var alert *template.Alert
+		consoleToken, err := c.tokens.NewConsole(r.Context(), user.UserID)
+		if err != nil {
+			c.log.Printf("[ERROR] [%s %s] Creating console token: %v\n", r.Method, r.URL.Path, err)
+			alert = &template.Alert{
+				Level:     template.AlertWarning,
+				Message:   "A console token could not be created. Requests will not be authenticated.",
+				Retryable: true,
+			}
+		}
+
+		c.tmpl.Execute(w, r, "console", template.ExecuteParams{
+			Title:  "API Console",
+			PageID: web.PageConsole,
+			Data:   newConsoleData(c.apiURL, consoleToken, err),
+			Alert:  alert,
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/token"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+func TestConsoleAllowed(t *testing.T) {
+	c := NewConsole(nil, []string{" Alice ", "bob"}, "", nil, log.New(io.Discard, "", 0))
+
+	tests := []struct {
+		username string
+		want     bool
+	}{
+		{"alice", true},
+		{"ALICE", true},
+		{"Bob", true},
+		{"carol", false},
+	}
+
+	for _, tc := range tests {
+		if got := c.Allowed(session.User{Username: tc.username}); got != tc.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tc.username, got, tc.want)
+		}
+	}
+
+	everyone := NewConsole(nil, []string{"*"}, "", nil, log.New(io.Discard, "", 0))
+	if !everyone.Allowed(session.User{Username: "Carol"}) {
+		t.Error("Allowed() = false with \"*\", want true")
+	}
+}
+
+func TestNewConsoleData(t *testing.T) {
+	consoleToken := token.NewListing{Token: "eyJhbGciOiJIUzI1NiJ9.e30.c2ln"}
+	consoleToken.ExpiresAt = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	data := newConsoleData("https://api.example.com", consoleToken, nil)
+	if data.TokenFailed || data.Token != consoleToken.Token || data.ExpiresAt != "2024-06-01T12:00:00Z" {
+		t.Errorf("newConsoleData() = %+v, want the token and its expiry", data)
+	}
+
+	// A token that could not be created is not rendered as the zero token.
+	data = newConsoleData("https://api.example.com", token.NewListing{}, errors.New("connection refused"))
+	if !data.TokenFailed || data.Token != "" || data.ExpiresAt != "" {
+		t.Errorf("newConsoleData() = %+v, want the failed state", data)
+	}
+}
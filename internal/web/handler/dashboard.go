@@ -3,41 +3,116 @@ package handler
 import (
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/security"
 	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/auth"
+	"github.com/cicconee/clox/internal/web/cookie"
 	"github.com/cicconee/clox/internal/web/session"
 	"github.com/cicconee/clox/internal/web/template"
 )
 
 type Dashboard struct {
-	tmpl *template.Template
-	log  *log.Logger
+	registry *auth.Registry
+	security *security.Recorder
+	cookies  *cookie.Manager
+	tmpl     *template.Template
+	log      *log.Logger
 }
 
-func NewDashboard(tmpl *template.Template, log *log.Logger) *Dashboard {
-	return &Dashboard{tmpl: tmpl, log: log}
+func NewDashboard(registry *auth.Registry, security *security.Recorder, cookies *cookie.Manager, tmpl *template.Template, log *log.Logger) *Dashboard {
+	return &Dashboard{registry: registry, security: security, cookies: cookies, tmpl: tmpl, log: log}
 }
 
+// failureWindow is how far back the dashboard counts failed API authentications.
+const failureWindow = 7 * 24 * time.Hour
+
+// Template executes the dashboard template. If the users root storage is not ready, a notice is
+// displayed. If provisioning the storage failed, the user is given the option to retry it. The
+// failed API authentications of the last week are counted.
+//
+// Template expects a registered session.User in the request context.
 func (d *Dashboard) Template() http.HandlerFunc {
 	type data struct {
-		FirstName string
-		LastName  string
+		FirstName        string
+		LastName         string
+		AvatarURL        string
+		PictureURL       string
+		AvatarRefreshURL string
+		StorageFailed    bool
+		StorageRetryURL  string
+		UploadStatsURL   string
+		APIFailures      int
+		ActivityURL      string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := session.GetUserContext(r.Context())
 
 		data := data{
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
+			FirstName:        user.FirstName,
+			LastName:         user.LastName,
+			AvatarURL:        web.AvatarURL(user.UserID),
+			PictureURL:       user.PictureURL,
+			AvatarRefreshURL: web.URLAvatarRefresh,
+			StorageRetryURL:  web.URLStorageRetry,
+			UploadStatsURL:   web.URLAPIUploadStats,
+			ActivityURL:      web.URLActivity,
+		}
+
+		if d.security != nil {
+			failures, err := d.security.CountAPIFailures(r.Context(), user.UserID, failureWindow)
+			if err != nil {
+				d.log.Printf("[ERROR] [%s %s] Counting failed API authentications: %v\n", r.Method, r.URL.Path, err)
+			}
+
+			data.APIFailures = failures
+		}
+
+		var alert *template.Alert
+		state, err := d.registry.StorageState(r.Context(), user.UserID)
+		switch {
+		case err != nil:
+			d.log.Printf("[ERROR] [%s %s] Getting storage state: %v\n", r.Method, r.URL.Path, err)
+		case state == cloudstore.StoragePending:
+			alert = &template.Alert{
+				Level:     template.AlertWarning,
+				Message:   "Your storage is being set up. This usually only takes a moment.",
+				Retryable: true,
+			}
+		case state == cloudstore.StorageFailed:
+			data.StorageFailed = true
+			alert = &template.Alert{
+				Level:   template.AlertDanger,
+				Message: "Your storage could not be set up. It will be set up the next time you use it, or you can retry now.",
+			}
 		}
 
 		d.tmpl.Execute(w, r, "dashboard", template.ExecuteParams{
-			Title:         "Dashboard",
-			PageID:        web.PageDashboard,
-			NavLinks:      web.NavBarAuthenticated,
-			Authenticated: true,
-			Data:          data,
+			Title:  "Dashboard",
+			PageID: web.PageDashboard,
+			Data:   data,
+			Alert:  alert,
 		})
 	}
 }
+
+// RetryStorage provisions the users root storage and redirects to the dashboard. If the storage
+// already exists, it is left as is.
+//
+// RetryStorage expects a registered session.User in the request context.
+func (d *Dashboard) RetryStorage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		if err := d.registry.Provision(r.Context(), user.UserID); err != nil {
+			d.cookies.Set(w, cookie.FlashError, "Your storage could not be set up. Please try again later.")
+		} else {
+			d.cookies.Set(w, cookie.FlashMessage, "Your storage is ready.")
+		}
+
+		http.Redirect(w, r, web.URLDashboard, http.StatusFound)
+	}
+}
@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/pagination"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/cookie"
+	"github.com/cicconee/clox/internal/web/session"
+	"github.com/cicconee/clox/internal/web/template"
+	"github.com/go-chi/chi/v5"
+)
+
+// Directory encapsulates the handlers for user directories.
+type Directory struct {
+	dirs    *cloudstore.DirService
+	files   *cloudstore.FileService
+	cursors *pagination.Codec
+	cookies *cookie.Manager
+	tmpl    *template.Template
+	log     *log.Logger
+}
+
+// NewDirectory creates a directory handler.
+func NewDirectory(dirs *cloudstore.DirService, files *cloudstore.FileService, cursors *pagination.Codec, cookies *cookie.Manager, tmpl *template.Template, log *log.Logger) *Directory {
+	return &Directory{dirs: dirs, files: files, cursors: cursors, cookies: cookies, tmpl: tmpl, log: log}
+}
+
+// browseLimit is the number of entries on a page of the file browser.
+const browseLimit = 50
+
+// browseState are the URL query parameters that are the listing state of the file browser. They are kept when
+// navigating between pages and directories, the "after" and "before" cursors are not.
+var browseState = []string{"sort", "order", "include"}
+
+// browseURL returns path with the listing state of q. If cursor is not empty, it is set as the param URL query
+// parameter.
+func browseURL(path string, q url.Values, param string, cursor string) string {
+	state := url.Values{}
+	for _, k := range browseState {
+		if v := q.Get(k); v != "" {
+			state.Set(k, v)
+		}
+	}
+
+	if cursor != "" {
+		state.Set(param, cursor)
+	}
+
+	if len(state) == 0 {
+		return path
+	}
+
+	return path + "?" + state.Encode()
+}
+
+// Template executes the files template which displays a page of the sub directories and files of the directory in
+// the request path, or of the users root directory if there is none. It works without javascript, the page is
+// controlled by the "sort", "order", and "include" URL query parameters as parsed by cloudstore.ParseListOptions,
+// and the "after" or "before" cursor of the pager links.
+//
+// If a cursor has expired or is invalid, the user is redirected to the first page with the same listing state.
+//
+// Template expects a registered session.User in the request context.
+func (d *Directory) Template() http.HandlerFunc {
+	type row struct {
+		Type       string
+		Name       string
+		URL        string
+		Size       *int64
+		ModifiedAt app.Time
+
+		// ContentMissing badges a file whose content is unavailable.
+		ContentMissing bool
+	}
+
+	type sortLink struct {
+		web.Link
+		Active bool
+	}
+
+	type data struct {
+		Path      string
+		ParentURL string
+		Entries   []row
+		SortLinks []sortLink
+		Pager     web.Pager
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+		q := r.URL.Query()
+
+		page := data{Entries: []row{}}
+		execute := func(alert *template.Alert) {
+			d.tmpl.Execute(w, r, "files", template.ExecuteParams{
+				Title:  "Files",
+				PageID: web.PageFiles,
+				Data:   page,
+				Alert:  alert,
+			})
+		}
+
+		opts, err := cloudstore.ParseListOptions(q)
+		if err != nil {
+			execute(browseAlert(err))
+			return
+		}
+
+		dir, err := d.dirs.Info(r.Context(), user.UserID, chi.URLParam(r, "id"))
+		if err != nil {
+			d.log.Printf("[ERROR] [%s %s] Getting directory: %v\n", r.Method, r.URL.Path, err)
+			execute(browseAlert(err))
+			return
+		}
+
+		page.Path = dir.Path
+		if dir.ParentID != "" {
+			page.ParentURL = browseURL(web.DirURL(dir.ParentID), q, "", "")
+		}
+
+		for _, s := range []web.Link{{URL: string(cloudstore.SortName), Value: "Name"}, {URL: string(cloudstore.SortCreated), Value: "Created"}} {
+			sq := url.Values{"order": q["order"], "include": q["include"], "sort": {s.URL}}
+			page.SortLinks = append(page.SortLinks, sortLink{
+				Link:   web.Link{URL: browseURL(r.URL.Path, sq, "", ""), Value: s.Value},
+				Active: string(opts.Sort) == s.URL,
+			})
+		}
+
+		window, err := d.dirs.ListEntriesWindow(r.Context(), d.cursors, user.UserID, dir.ID, opts, q.Get("after"), q.Get("before"), browseLimit)
+		if errors.Is(err, pagination.ErrExpiredCursor) || errors.Is(err, pagination.ErrInvalidCursor) {
+			d.cookies.Set(w, cookie.FlashError, "That page is no longer available, you have been sent to the first page.")
+			http.Redirect(w, r, browseURL(r.URL.Path, q, "", ""), http.StatusFound)
+			return
+		}
+		if err != nil {
+			d.log.Printf("[ERROR] [%s %s] Listing directory entries: %v\n", r.Method, r.URL.Path, err)
+			execute(browseAlert(err))
+			return
+		}
+
+		// Files link back to this page, so the user returns to the same page with the same state.
+		ret := url.Values{"return": {r.URL.RequestURI()}}.Encode()
+		for _, e := range window.Items {
+			u := browseURL(web.DirURL(e.ID), q, "", "")
+			if e.Type == cloudstore.EntryFile {
+				u = web.FileInfoURL(e.ID) + "?" + ret
+			}
+
+			page.Entries = append(page.Entries, row{
+				Type:       e.Type.Name(),
+				Name:       e.Name,
+				URL:        u,
+				Size:       e.Size,
+				ModifiedAt: app.NewTime(e.ModifiedAt),
+
+				ContentMissing: e.ContentMissing,
+			})
+		}
+
+		if window.PrevCursor != "" {
+			page.Pager.PrevURL = browseURL(r.URL.Path, q, "before", window.PrevCursor)
+		}
+		if window.NextCursor != "" {
+			page.Pager.NextURL = browseURL(r.URL.Path, q, "after", window.NextCursor)
+		}
+
+		execute(nil)
+	}
+}
+
+// FileInfoTemplate executes the file template which displays the information of the file in the request path. The
+// page links back to the "return" URL query parameter, or to the directory of the file if it is not set.
+//
+// FileInfoTemplate expects a registered session.User in the request context.
+func (d *Directory) FileInfoTemplate() http.HandlerFunc {
+	type data struct {
+		Name             string
+		Path             string
+		Size             int64
+		UploadedAt       app.Time
+		ClientModifiedAt app.Time
+		BackURL          string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		var page data
+		var alert *template.Alert
+
+		file, err := d.files.Info(r.Context(), user.UserID, chi.URLParam(r, "id"))
+		if err != nil {
+			d.log.Printf("[ERROR] [%s %s] Getting file info: %v\n", r.Method, r.URL.Path, err)
+			alert = browseAlert(err)
+			page.BackURL = web.ReturnURL(r, web.URLFiles)
+		} else {
+			page = data{
+				Name:             file.Name,
+				Path:             file.Path,
+				Size:             file.Size,
+				UploadedAt:       app.NewTime(file.UploadedAt),
+				ClientModifiedAt: app.NewTime(file.ClientModifiedAt),
+				BackURL:          web.ReturnURL(r, web.DirURL(file.DirectoryID)),
+			}
+		}
+
+		d.tmpl.Execute(w, r, "file", template.ExecuteParams{
+			Title:  "File",
+			PageID: web.PageFileInfo,
+			Data:   page,
+			Alert:  alert,
+		})
+	}
+}
+
+// browseAlert returns the alert of an error listing files. The message is the safe message of err if it has one.
+func browseAlert(err error) *template.Alert {
+	msg := "Your files could not be loaded."
+
+	var safeErr app.SafeError
+	if errors.As(err, &safeErr) {
+		msg, _ = safeErr.Safe()
+	}
+
+	return &template.Alert{Level: template.AlertDanger, Message: msg}
+}
+
+// EntriesJSON writes a page of the sub directories and files of the directory in the request
+// path as a JSON pagination.Page. The listing is controlled by the "sort", "order", "include",
+// "cursor", and "limit" URL query parameters.
+//
+// EntriesJSON expects a registered session.User in the request context.
+func (d *Directory) EntriesJSON() http.HandlerFunc {
+	type entry struct {
+		Type       string   `json:"type"`
+		ID         string   `json:"id"`
+		Name       string   `json:"name"`
+		Size       *int64   `json:"size"`
+		ModifiedAt app.Time `json:"modified_at"`
+
+		// ClientModifiedAt is null for directories.
+		ClientModifiedAt app.Time `json:"client_modified_at"`
+
+		// ContentMissing is true if the content of a file is unavailable.
+		ContentMissing bool `json:"content_missing"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		opts, err := cloudstore.ParseListOptions(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		req, err := pagination.ParseRequest(r, 50, 500)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		page, err := d.dirs.ListEntriesPage(r.Context(), d.cursors, user.UserID, chi.URLParam(r, "id"), opts, req)
+		if err != nil {
+			d.log.Printf("[ERROR] [%s %s] Listing directory entries: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		items := []entry{}
+		for _, e := range page.Items {
+			items = append(items, entry{
+				Type:       e.Type.Name(),
+				ID:         e.ID,
+				Name:       e.Name,
+				Size:       e.Size,
+				ModifiedAt: app.NewTime(e.ModifiedAt),
+
+				ClientModifiedAt: app.NewTime(e.ClientModifiedAt),
+				ContentMissing:   e.ContentMissing,
+			})
+		}
+
+		resp, err := json.Marshal(&pagination.Page[entry]{
+			Items:      items,
+			NextCursor: page.NextCursor,
+			HasMore:    page.HasMore,
+		})
+		if err != nil {
+			d.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
+
+// UploadStatsJSON writes the number of files the user uploaded on each of the last days as
+// JSON. The number of days is set with the "days" URL query parameter. Days are in the display
+// time zone, and days without uploads have a count of 0.
+//
+// UploadStatsJSON expects a registered session.User in the request context.
+func (d *Directory) UploadStatsJSON() http.HandlerFunc {
+	type response struct {
+		TimeZone string                `json:"time_zone"`
+		Days     []cloudstore.DayCount `json:"days"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		days, err := cloudstore.ParseStatsDays(r.URL.Query())
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		series, err := d.dirs.UploadStats(r.Context(), user.UserID, days)
+		if err != nil {
+			d.log.Printf("[ERROR] [%s %s] Getting upload stats: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		resp, err := json.Marshal(&response{TimeZone: d.dirs.Location().String(), Days: series})
+		if err != nil {
+			d.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
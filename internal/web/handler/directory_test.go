@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cicconee/clox/internal/cloudstore"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/pagination"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/cookie"
+	"github.com/cicconee/clox/internal/web/session"
+	"github.com/cicconee/clox/internal/web/template"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	// entryName matches the names of the entries listed on a files page.
+	entryName = regexp.MustCompile(`<a href="[^"]*">(entry-\d{3})</a>`)
+
+	// pagerLink matches the URLs of the pager links on a files page, by rel.
+	pagerLink = regexp.MustCompile(`href="([^"]*)" rel="(prev|next)"`)
+)
+
+// filesPage is a rendered files page.
+type filesPage struct {
+	names []string
+	prev  string
+	next  string
+}
+
+// getFilesPage requests url from h as the user and parses the rendered page.
+func getFilesPage(t *testing.T, h http.Handler, userID string, url string) filesPage {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, url, nil)
+	r = r.WithContext(session.SetUserContext(r.Context(), session.User{UserID: userID}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s status = %d, want %d: %s", url, w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var page filesPage
+	for _, m := range entryName.FindAllStringSubmatch(w.Body.String(), -1) {
+		page.names = append(page.names, m[1])
+	}
+
+	for _, m := range pagerLink.FindAllStringSubmatch(w.Body.String(), -1) {
+		if m[2] == "prev" {
+			page.prev = html.UnescapeString(m[1])
+		} else {
+			page.next = html.UnescapeString(m[1])
+		}
+	}
+
+	return page
+}
+
+// entryNames returns the names of the seeded entries from i to j.
+func entryNames(i int, j int) []string {
+	names := []string{}
+	for ; i < j; i++ {
+		names = append(names, fmt.Sprintf("entry-%03d", i))
+	}
+
+	return names
+}
+
+func TestDirectoryTemplatePages(t *testing.T) {
+	ctx := context.Background()
+	p := dbtest.Open(t)
+
+	userID := uuid.NewString()
+	_, err := p.Exec(ctx, `INSERT INTO users (id, email, register_status) VALUES ($1, $2, 'complete')`,
+		userID, userID+"@example.com")
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	t.Cleanup(func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID) })
+
+	discard := log.New(io.Discard, "", 0)
+	dirs := cloudstore.NewDirService(cloudstore.DirServiceConfig{
+		Store:   cloudstore.NewStore(p),
+		PathMap: cloudstore.NewPathMapper(t.TempDir()),
+		Log:     discard,
+	})
+
+	if _, err := dirs.ValidateUser(ctx, userID); err != nil {
+		t.Fatalf("ValidateUser() error = %v", err)
+	}
+
+	seeded, err := dirs.New(ctx, userID, "seeded", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, name := range entryNames(0, 120) {
+		if _, err := dirs.New(ctx, userID, name, seeded.ID); err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+	}
+
+	tmpl := template.New("clox", "../../../web/templates", discard)
+	if err := tmpl.Parse(); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	cursors := pagination.NewCodec(0)
+	cursors.SetSecret("secret")
+
+	d := NewDirectory(dirs, nil, cursors, cookie.NewManager(false, "localhost"), tmpl, discard)
+	router := chi.NewRouter()
+	router.Get(web.URLFilesDir, d.Template())
+
+	// The pages are walked only through the rendered links, with the listing state kept.
+	first := web.DirURL(seeded.ID) + "?order=asc"
+	pages := []filesPage{getFilesPage(t, router, userID, first)}
+	for len(pages) < 3 {
+		next := pages[len(pages)-1].next
+		if next == "" {
+			t.Fatalf("page %d has no next link", len(pages))
+		}
+
+		if !strings.Contains(next, "order=asc") {
+			t.Errorf("next link %s does not keep the listing state", next)
+		}
+
+		pages = append(pages, getFilesPage(t, router, userID, next))
+	}
+
+	want := [][]string{entryNames(0, 50), entryNames(50, 100), entryNames(100, 120)}
+	for i, page := range pages {
+		if !slices.Equal(page.names, want[i]) {
+			t.Errorf("page %d = %v, want %v", i+1, page.names, want[i])
+		}
+	}
+
+	if pages[0].prev != "" {
+		t.Errorf("first page prev link = %s, want none", pages[0].prev)
+	}
+
+	if pages[2].next != "" {
+		t.Errorf("last page next link = %s, want none", pages[2].next)
+	}
+
+	// The previous link of the last page goes back to the second page.
+	if pages[2].prev == "" {
+		t.Fatal("last page has no prev link")
+	}
+
+	back := getFilesPage(t, router, userID, pages[2].prev)
+	if !slices.Equal(back.names, want[1]) {
+		t.Errorf("page before the last = %v, want %v", back.names, want[1])
+	}
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites the golden files with the current responses. Run it after an intended
+// change to a response body:
+//
+//	go test ./internal/web/handler -update
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// assertGolden marshals v as a response body and compares it with the golden file
+// testdata/name.golden.json. If the update flag is set, the golden file is written
+// instead.
+func assertGolden(t *testing.T, name string, v any) {
+	t.Helper()
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshalling response: %v", err)
+	}
+
+	var got bytes.Buffer
+	if err := json.Indent(&got, body, "", "  "); err != nil {
+		t.Fatalf("indenting response %s: %v", body, err)
+	}
+	got.WriteByte('\n')
+
+	path := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatalf("creating testdata: %v", err)
+		}
+
+		if err := os.WriteFile(path, got.Bytes(), 0644); err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file, run with -update to create it: %v", err)
+	}
+
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("response %s does not match %s, run with -update if the change is intended\n got: %s\nwant: %s",
+			name, path, got.Bytes(), want)
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/invite"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/cookie"
+	"github.com/cicconee/clox/internal/web/session"
+	"github.com/cicconee/clox/internal/web/template"
+)
+
+// Invite encapsulates the admin handlers for managing invites.
+type Invite struct {
+	invites *invite.Service
+	cookies *cookie.Manager
+	tmpl    *template.Template
+	log     *log.Logger
+}
+
+// NewInvite creates a new Invite.
+func NewInvite(invites *invite.Service, cookies *cookie.Manager, tmpl *template.Template, log *log.Logger) *Invite {
+	return &Invite{invites: invites, cookies: cookies, tmpl: tmpl, log: log}
+}
+
+// TemplateListing executes the invites template. It lists the most recent invites and has a form to
+// generate a invite.
+func (i *Invite) TemplateListing() http.HandlerFunc {
+	type row struct {
+		CreatedAt app.Time
+		ExpiresAt app.Time
+		Status    string
+		UsedBy    string
+	}
+
+	type data struct {
+		LinkGenerate web.Link
+		DefaultDays  int
+		MaxDays      int
+		Invites      []row
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := data{
+			LinkGenerate: web.Link{URL: web.URLAdminInvites, Value: "Generate invite"},
+			DefaultDays:  int(invite.DefaultTTL.Hours() / 24),
+			MaxDays:      int(invite.MaxTTL.Hours() / 24),
+			Invites:      []row{},
+		}
+
+		var alert *template.Alert
+		invites, err := i.invites.List(r.Context())
+		if err != nil {
+			i.log.Printf("[ERROR] [%s %s] Listing invites: %v\n", r.Method, r.URL.Path, err)
+			alert = &template.Alert{Level: template.AlertDanger, Message: "Invites could not be loaded.", Retryable: true}
+		}
+
+		now := time.Now()
+		for _, inv := range invites {
+			d.Invites = append(d.Invites, row{
+				CreatedAt: app.NewTime(inv.CreatedAt),
+				ExpiresAt: app.NewTime(inv.ExpiresAt),
+				Status:    inv.Status(now),
+				UsedBy:    inv.UsedBy,
+			})
+		}
+
+		i.tmpl.Execute(w, r, "invites", template.ExecuteParams{
+			Title:  "Invites",
+			PageID: web.PageInvites,
+			Data:   d,
+			Alert:  alert,
+		})
+	}
+}
+
+// Generate handles post requests to generate a invite. The invite is valid for the number of days
+// in the "days" form value, or invite.DefaultTTL if not set. The code is shown once as a flash
+// message.
+func (i *Invite) Generate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		var ttl time.Duration
+		if days := r.FormValue("days"); days != "" {
+			n, err := strconv.Atoi(days)
+			if err != nil || n <= 0 {
+				i.cookies.Set(w, cookie.FlashError, "Days must be a positive number.")
+				http.Redirect(w, r, web.URLAdminInvites, http.StatusFound)
+				return
+			}
+
+			ttl = time.Duration(n) * 24 * time.Hour
+		}
+
+		inv, code, err := i.invites.Generate(r.Context(), user.UserID, ttl)
+		if err != nil {
+			i.log.Printf("[ERROR] [%s %s] Generating invite: %v\n", r.Method, r.URL.Path, err)
+			msg := "Problem generating the invite."
+			var wrapErr *app.WrappedSafeError
+			if errors.As(err, &wrapErr) {
+				msg, _ = wrapErr.Safe()
+			}
+
+			i.cookies.Set(w, cookie.FlashError, msg)
+			http.Redirect(w, r, web.URLAdminInvites, http.StatusFound)
+			return
+		}
+
+		i.cookies.Set(w, cookie.FlashMessage, fmt.Sprintf("Invite code %s expires %s. It will not be shown again.", code, app.NewTime(inv.ExpiresAt)))
+		http.Redirect(w, r, web.URLAdminInvites, http.StatusFound)
+	}
+}
@@ -59,6 +59,9 @@ func (o *OAuth2) Callback() http.HandlerFunc {
 			// User authenticated but is not registered.
 			redirect = web.URLRegister
 			flashMessage = fmt.Sprintf("Welcome, %s! Choose your username.", session.FirstName)
+		case user.Unverified:
+			// User is not registered and their email is not verified.
+			redirect = web.URLUnverified
 		case user.Blocked:
 			// User is blocked.
 			redirect = web.URLLogin
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+// Session encapsulates the handlers that expose the current session to the frontend.
+type Session struct {
+	log *log.Logger
+}
+
+// NewSession creates a new Session handler.
+func NewSession(log *log.Logger) *Session {
+	return &Session{log: log}
+}
+
+// JSON writes the session.User fields that are safe for the client as a JSON response.
+//
+// JSON expects a session.User in the request context.
+func (s *Session) JSON() http.HandlerFunc {
+	type response struct {
+		UserID             string      `json:"user_id"`
+		FirstName          string      `json:"first_name"`
+		LastName           string      `json:"last_name"`
+		Username           string      `json:"username"`
+		PictureURL         string      `json:"picture_url"`
+		AvatarURL          string      `json:"avatar_url"`
+		RegistrationStatus user.Status `json:"registration_status"`
+		EmailVerified      bool        `json:"email_verified"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		resp, err := json.Marshal(&response{
+			UserID:             user.UserID,
+			FirstName:          user.FirstName,
+			LastName:           user.LastName,
+			Username:           user.Username,
+			PictureURL:         user.PictureURL,
+			AvatarURL:          web.AvatarURL(user.UserID),
+			RegistrationStatus: user.RegistrationStatus,
+			EmailVerified:      user.EmailVerified,
+		})
+		if err != nil {
+			s.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+func TestSessionJSONGolden(t *testing.T) {
+	// The session and email are not safe for the client, they are not written.
+	r := httptest.NewRequest(http.MethodGet, "/app/api/session", nil)
+	r = r.WithContext(session.WithUser(r.Context(), session.User{
+		SessionID:          "5c1e3a7f-9b2d-4f6e-8a0c-2e4f6b8d0a1c",
+		UserID:             "a3f1c5e7-0b2d-4e6f-8a9c-1d3e5f7a9b0c",
+		FirstName:          "Ada",
+		LastName:           "Lovelace",
+		PictureURL:         "https://example.com/ada.png",
+		Email:              "ada@example.com",
+		Username:           "ada",
+		RegistrationStatus: user.Complete,
+		EmailVerified:      true,
+	}))
+
+	w := httptest.NewRecorder()
+	NewSession(log.New(io.Discard, "", 0)).JSON()(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	for _, secret := range []string{"5c1e3a7f-9b2d-4f6e-8a0c-2e4f6b8d0a1c", "ada@example.com"} {
+		if bytes.Contains(w.Body.Bytes(), []byte(secret)) {
+			t.Errorf("body %s contains %q", w.Body.Bytes(), secret)
+		}
+	}
+
+	assertGolden(t, "session", json.RawMessage(w.Body.Bytes()))
+}
@@ -4,9 +4,10 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/pagination"
 	"github.com/cicconee/clox/internal/token"
 	"github.com/cicconee/clox/internal/web"
 	"github.com/cicconee/clox/internal/web/cookie"
@@ -19,13 +20,14 @@ import (
 type Token struct {
 	tokens  *token.Service
 	cookies *cookie.Manager
+	cursors *pagination.Codec
 	tmpl    *template.Template
 	log     *log.Logger
 }
 
 // NewToken creates a token handler.
-func NewToken(tokens *token.Service, cookies *cookie.Manager, tmpl *template.Template, log *log.Logger) *Token {
-	return &Token{tokens: tokens, cookies: cookies, tmpl: tmpl, log: log}
+func NewToken(tokens *token.Service, cookies *cookie.Manager, cursors *pagination.Codec, tmpl *template.Template, log *log.Logger) *Token {
+	return &Token{tokens: tokens, cookies: cookies, cursors: cursors, tmpl: tmpl, log: log}
 }
 
 // TemplateListing executes the tokens template which displays all the active tokens for a user.
@@ -35,70 +37,190 @@ func (t *Token) TemplateListing() http.HandlerFunc {
 	type data struct {
 		Listings         []token.Listing
 		TokenResourceURL string
+
+		// Remaining is the number of tokens the user may still generate, if Limited.
+		Remaining int
+		Limited   bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := session.GetUserContext(r.Context())
 
+		var alert *template.Alert
 		listings, err := t.tokens.List(r.Context(), user.UserID)
 		if err != nil {
 			t.log.Printf("[ERROR] [%s %s] Getting token list: %v\n", r.Method, r.URL.Path, err)
+			alert = &template.Alert{
+				Level:     template.AlertWarning,
+				Message:   "Your tokens could not be loaded. Tokens you generate will still work.",
+				Retryable: true,
+			}
+		}
+
+		// The slots are a hint, the limit is enforced when a token is generated.
+		remaining, limited, err := t.tokens.Remaining(r.Context(), user.UserID)
+		if err != nil {
+			t.log.Printf("[ERROR] [%s %s] Getting remaining tokens: %v\n", r.Method, r.URL.Path, err)
+			limited = false
 		}
 
 		t.tmpl.Execute(w, r, "tokens", template.ExecuteParams{
-			Title:         "API Tokens",
-			PageID:        web.PageTokens,
-			NavLinks:      web.NavBarAuthenticated,
-			Authenticated: true,
-			Data:          data{Listings: listings, TokenResourceURL: web.URLTokenResource},
+			Title:  "API Tokens",
+			PageID: web.PageTokens,
+			Data: data{
+				Listings:         listings,
+				TokenResourceURL: web.URLTokenResource,
+				Remaining:        remaining,
+				Limited:          limited,
+			},
+			Alert: alert,
 		})
 	}
 }
 
-// Generate handles creating a new token.
+// The JSON of a token listing.
+type listingResponse struct {
+	TokenID        string   `json:"token_id"`
+	TokenName      string   `json:"token_name"`
+	CreatedAt      app.Time `json:"created_at"`
+	LastUsed       app.Time `json:"last_used"`
+	ExpiresAt      app.Time `json:"expires_at"`
+	AllowedIPs     []string `json:"allowed_ips"`
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// newListingResponseFrom converts a token.Listing to a listingResponse.
+func newListingResponseFrom(l token.Listing) listingResponse {
+	return listingResponse{
+		TokenID:        l.TokenID,
+		TokenName:      l.TokenName,
+		CreatedAt:      app.NewTime(l.IssuedAt),
+		LastUsed:       app.NewTime(l.LastUsed),
+		ExpiresAt:      app.NewTime(l.ExpiresAt),
+		AllowedIPs:     l.AllowedIPs,
+		AllowedOrigins: l.AllowedOrigins,
+	}
+}
+
+// newTokenPageFrom converts a page of token.Listing to a page of listingResponse.
+func newTokenPageFrom(page pagination.Page[token.Listing]) pagination.Page[listingResponse] {
+	items := []listingResponse{}
+	for _, l := range page.Items {
+		items = append(items, newListingResponseFrom(l))
+	}
+
+	return pagination.Page[listingResponse]{
+		Items:      items,
+		NextCursor: page.NextCursor,
+		HasMore:    page.HasMore,
+	}
+}
+
+// The JSON of a new token, the signed token followed by its listing.
+type tokenResponse struct {
+	Token string `json:"token"`
+	listingResponse
+}
+
+// newTokenResponseFrom converts a token.NewListing to a tokenResponse.
+func newTokenResponseFrom(l token.NewListing) tokenResponse {
+	return tokenResponse{
+		Token:           l.Token,
+		listingResponse: newListingResponseFrom(l.Listing),
+	}
+}
+
+// ListJSON writes a page of the active tokens for a user as a JSON pagination.Page. The page is
+// controlled with the "cursor" and "limit" URL query parameters.
 //
-// Generate expects a registered session.User in the request context.
-func (t *Token) Generate() http.HandlerFunc {
-	type response struct {
-		Token     string `json:"token"`
-		TokenID   string `json:"token_id"`
-		TokenName string `json:"token_name"`
-		CreatedAt string `json:"created_at"`
-		LastUsed  string `json:"last_used"`
-		ExpiresAt string `json:"expires_at"`
+// ListJSON expects a registered session.User in the request context.
+func (t *Token) ListJSON() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		req, err := pagination.ParseRequest(r, 25, 100)
+		if err != nil {
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		var after token.PageKey
+		hasCursor, err := req.DecodeCursor(t.cursors, &after)
+		if err != nil {
+			t.log.Printf("[ERROR] [%s %s] Decoding cursor: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		var afterKey *token.PageKey
+		if hasCursor {
+			afterKey = &after
+		}
+
+		listings, err := t.tokens.ListPage(r.Context(), user.UserID, afterKey, req.Limit+1)
+		if err != nil {
+			t.log.Printf("[ERROR] [%s %s] Getting token list: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		page, err := pagination.NewPage(t.cursors, listings, req.Limit, func(l token.Listing) any {
+			return token.PageKey{IssuedAt: l.IssuedAt, ID: l.TokenID}
+		})
+		if err != nil {
+			t.log.Printf("[ERROR] [%s %s] Building page: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		resp, err := json.Marshal(newTokenPageFrom(page))
+		if err != nil {
+			t.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
 	}
+}
 
+// Generate handles creating a new token. The "expiration" form value must be a whole number of
+// seconds or one of token.DurationPresets.
+//
+// Generate expects a registered session.User in the request context.
+func (t *Token) Generate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := session.GetUserContext(r.Context())
 		tokenName := r.FormValue("tokenName")
-		durationStr := r.FormValue("expiration") + "s"
 
-		seconds, err := time.ParseDuration(durationStr)
+		duration, err := token.ParseDuration(r.FormValue("expiration"), "expiration")
 		if err != nil {
-			t.log.Printf("[ERROR] [%s %s] Parsing duration to seconds: %v\n", r.Method, r.URL.Path, err)
-			app.WriteJSONError(w, app.Wrap(app.WrapParams{
-				Err:         err,
-				SafeMessage: "Invalid expires duration",
-				StatusCode:  http.StatusBadRequest,
-			}))
+			t.log.Printf("[ERROR] [%s %s] Parsing expiration: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
 			return
 		}
 
-		newListing, err := t.tokens.New(r.Context(), user.UserID, seconds, tokenName)
+		// Allowed IPs and origins may be separated by commas, spaces, or new lines.
+		separator := func(c rune) bool {
+			return c == ',' || c == ' ' || c == '\n' || c == '\r' || c == '\t'
+		}
+
+		newListing, err := t.tokens.New(r.Context(), token.NewParams{
+			UserID:         user.UserID,
+			Duration:       duration,
+			Name:           tokenName,
+			AllowedIPs:     strings.FieldsFunc(r.FormValue("allowedIPs"), separator),
+			AllowedOrigins: strings.FieldsFunc(r.FormValue("allowedOrigins"), separator),
+		})
 		if err != nil {
 			t.log.Printf("[ERROR] [%s %s] Creating new token: %v\n", r.Method, r.URL.Path, err)
 			app.WriteJSONError(w, err)
 			return
 		}
 
-		jsonResponse, err := json.Marshal(&response{
-			Token:     newListing.Token,
-			TokenID:   newListing.Listing.TokenID,
-			TokenName: newListing.Listing.TokenName,
-			CreatedAt: newListing.IssuedAtString(),
-			LastUsed:  newListing.LastUsedString(),
-			ExpiresAt: newListing.ExpiresAtString(),
-		})
+		jsonResponse, err := json.Marshal(newTokenResponseFrom(newListing))
 		if err != nil {
 			t.log.Printf("[ERROR] [%s %s] Marshalling response: %v\n", r.Method, r.URL.Path, err)
 			app.WriteJSONError(w, err)
@@ -128,3 +250,22 @@ func (t *Token) Delete() http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// InvalidateAll invalidates every token of the user at once, including tokens that are not
+// listed. It should be used when a token may have leaked.
+//
+// InvalidateAll expects a registered session.User in the request context.
+func (t *Token) InvalidateAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := session.GetUserContext(r.Context())
+
+		if err := t.tokens.InvalidateAll(r.Context(), user.UserID); err != nil {
+			t.log.Printf("[ERROR] [%s %s] Invalidating tokens: %v\n", r.Method, r.URL.Path, err)
+			app.WriteJSONError(w, err)
+			return
+		}
+
+		t.log.Printf("[INFO] [%s %s] [User: %s] All tokens invalidated\n", r.Method, r.URL.Path, user.UserID)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+// Admin is a http middleware that validates a user is an admin.
+type Admin struct {
+	admins map[string]bool
+}
+
+// NewAdmin creates a new Admin middleware. Only the users with a username in usernames are admins.
+func NewAdmin(usernames []string) *Admin {
+	admins := map[string]bool{}
+	for _, u := range usernames {
+		if u = strings.ToLower(strings.TrimSpace(u)); u != "" {
+			admins[u] = true
+		}
+	}
+
+	return &Admin{admins: admins}
+}
+
+// IsAdmin reports whether the user with the username is an admin.
+func (a *Admin) IsAdmin(username string) bool {
+	return a.admins[strings.ToLower(username)]
+}
+
+// Require validates that the user is an admin. If not, a 404 is written so the admin pages are not
+// revealed. The 404 is a JSON error if JSON is negotiated with app.Negotiate.
+//
+// Require must have a session in the request context. Execute the *Session.Active middleware function
+// before calling Require to set the session.
+func (a *Admin) Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := session.UserFrom(r.Context())
+
+		if !a.IsAdmin(u.Username) {
+			if app.Negotiate(r, app.FormatHTML) == app.FormatJSON {
+				app.WriteJSONError(w, app.Wrap(app.WrapParams{
+					Err:         errors.New("user is not an admin"),
+					SafeMessage: "Not found",
+					StatusCode:  http.StatusNotFound,
+				}))
+				return
+			}
+
+			http.NotFound(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+func TestAdminRequire(t *testing.T) {
+	a := NewAdmin([]string{" Ada ", ""})
+
+	tests := []struct {
+		name     string
+		username string
+		accept   string
+		want     int
+		json     bool
+	}{
+		{name: "admin", username: "ada", want: http.StatusOK},
+		{name: "admin other case", username: "ADA", want: http.StatusOK},
+		{name: "not admin", username: "grace", want: http.StatusNotFound},
+		{name: "no username", username: "", want: http.StatusNotFound},
+		{name: "not admin json", username: "grace", accept: "application/json", want: http.StatusNotFound, json: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/invites", nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			r = r.WithContext(session.WithUser(r.Context(), session.User{Username: tc.username}))
+
+			w := httptest.NewRecorder()
+			a.Require(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })(w, r)
+
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d", w.Code, tc.want)
+			}
+
+			if isJSON := w.Header().Get("Content-Type") == "application/json"; isJSON != tc.json {
+				t.Errorf("Content-Type = %q, want JSON %v", w.Header().Get("Content-Type"), tc.json)
+			}
+		})
+	}
+}
@@ -3,8 +3,8 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/cicconee/clox/internal/reqinfo"
 	"github.com/cicconee/clox/internal/web/cookie"
-	"github.com/cicconee/clox/internal/web/flash"
 )
 
 type Flash struct {
@@ -42,10 +42,7 @@ func (f *Flash) Extract(next http.HandlerFunc) http.HandlerFunc {
 			f.cookies.Clear(w, cookie.FlashError)
 		}
 
-		r = r.WithContext(flash.SetMessageContext(r.Context(), msg))
-		r = r.WithContext(flash.SetErrorContext(r.Context(), err))
-
-		next(w, r)
+		next(w, r.WithContext(reqinfo.SetFlash(r.Context(), msg, err)))
 	}
 }
 
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+// Nav is a http middleware that builds the navigation bar of the page.
+type Nav struct {
+	builder *web.NavBuilder
+	logger  *log.Logger
+}
+
+// NewNav creates a new Nav middleware.
+func NewNav(builder *web.NavBuilder, logger *log.Logger) *Nav {
+	return &Nav{builder: builder, logger: logger}
+}
+
+// Build builds the web.Nav of the user of the session and injects it into the http request context, where
+// template.Execute reads it. If there is no session, the web.Nav of a visitor is built. If the storage used by the user
+// cannot be checked, it is logged and the page is served without the quota warning.
+//
+// Wrap all http handlers that execute a page template. The session must already be in the request context, execute
+// the *Session.Active middleware function before calling Build on pages that require a session.
+func (n *Nav) Build(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var u *session.User
+		if su := session.UserFrom(r.Context()); su.UserID != "" {
+			u = &su
+		}
+
+		nav, err := n.builder.Build(r.Context(), u)
+		if err != nil {
+			n.logger.Printf("[ERROR] Building navigation bar: %v\n", err)
+		}
+
+		next(w, r.WithContext(web.SetNavContext(r.Context(), nav)))
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+func TestNavBuild(t *testing.T) {
+	usage := func(ctx context.Context, userID string) (int64, error) { return 95, nil }
+	builder := web.NewNavBuilder(func(username string) bool { return username == "ada" }, 100, usage)
+	n := NewNav(builder, log.New(io.Discard, "", 0))
+
+	tests := []struct {
+		name string
+		user session.User
+	}{
+		{name: "anonymous"},
+		{name: "registered", user: session.User{UserID: "user", Username: "grace", RegistrationStatus: user.Complete}},
+		{name: "admin", user: session.User{UserID: "user", Username: "ada", RegistrationStatus: user.Complete}},
+		{name: "pending", user: session.User{UserID: "user", Username: "ada", RegistrationStatus: user.Incomplete}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var u *session.User
+			if tc.user.UserID != "" {
+				u = &tc.user
+			}
+
+			want, err := builder.Build(context.Background(), u)
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/files", nil)
+			r = r.WithContext(session.WithUser(r.Context(), tc.user))
+
+			var got web.Nav
+			n.Build(func(w http.ResponseWriter, r *http.Request) {
+				got = web.GetNavContext(r.Context())
+			})(httptest.NewRecorder(), r)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Nav in context = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"fmt"
+	"github.com/cicconee/clox/internal/app"
 	"github.com/cicconee/clox/internal/user"
 	"github.com/cicconee/clox/internal/web"
 	"github.com/cicconee/clox/internal/web/cookie"
@@ -13,26 +14,44 @@ import (
 // Registry is a http middleware that can validate if a user is registered or not.
 type Registry struct {
 	cookies *cookie.Manager
+	mode    string
 	logger  *log.Logger
 }
 
-func NewRegistry(cookies *cookie.Manager, logger *log.Logger) *Registry {
-	return &Registry{cookies: cookies, logger: logger}
+// NewRegistry creates a new Registry middleware. The registration mode is one of web.RegistrationOpen,
+// web.RegistrationInvite, or web.RegistrationClosed.
+func NewRegistry(cookies *cookie.Manager, mode string, logger *log.Logger) *Registry {
+	return &Registry{cookies: cookies, mode: mode, logger: logger}
 }
 
-// IsRegistered validates that a user already registered using the current session.
+// IsRegistered validates that a user already registered using the current session. If JSON is negotiated with
+// app.Negotiate, a user that is not registered gets a JSON error with a 403 status code instead of a redirect.
 //
 // IsRegistered must have a session in the request context. Execute the *Session.Active middleware function before
 // calling NotRegistered to set the session. Alternatively, use the session.SetSessionContext to set the session.
 func (r *Registry) IsRegistered(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, rq *http.Request) {
-		u := session.GetUserContext(rq.Context())
+		u := session.UserFrom(rq.Context())
 
 		if u.RegistrationStatus == user.Complete {
 			next(w, rq)
 			return
 		}
 
+		if app.Negotiate(rq, app.FormatHTML) == app.FormatJSON {
+			message := "Registration is not complete"
+			if u.RegistrationStatus == user.Blocked {
+				message = "You are blocked"
+			}
+
+			app.WriteJSONError(w, app.Wrap(app.WrapParams{
+				Err:         fmt.Errorf("user not registered [status: %s]", u.RegistrationStatus),
+				SafeMessage: message,
+				StatusCode:  http.StatusForbidden,
+			}))
+			return
+		}
+
 		var redirect, flashMessage, flashError string
 		switch u.RegistrationStatus {
 		case user.Incomplete:
@@ -53,15 +72,21 @@ func (r *Registry) IsRegistered(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// NotRegistered validates that a user has not yet registered using the current session.
+// NotRegistered validates that a user has not yet registered using the current session. If registration
+// is closed, a user that has not registered is redirected to the registration closed page.
 //
 // NotRegistered must have a session in the request context. Execute the *Session.Active middleware function before
 // calling NotRegistered to set the session. Alternatively, use the session.SetSessionContext to set the session.
 func (r *Registry) NotRegistered(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, rq *http.Request) {
-		u := session.GetUserContext(rq.Context())
+		u := session.UserFrom(rq.Context())
 
 		if u.RegistrationStatus == user.Incomplete {
+			if r.mode == web.RegistrationClosed {
+				http.Redirect(w, rq, web.URLRegisterClosed, http.StatusFound)
+				return
+			}
+
 			next(w, rq)
 			return
 		}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/cookie"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+func TestRegistryNotRegistered(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		status   user.Status
+		next     bool
+		location string
+	}{
+		{name: "open", mode: web.RegistrationOpen, status: user.Incomplete, next: true},
+		{name: "invite", mode: web.RegistrationInvite, status: user.Incomplete, next: true},
+		{name: "closed", mode: web.RegistrationClosed, status: user.Incomplete, location: web.URLRegisterClosed},
+		{name: "closed and registered", mode: web.RegistrationClosed, status: user.Complete, location: web.URLDashboard},
+		{name: "blocked", mode: web.RegistrationOpen, status: user.Blocked, location: web.URLLogin},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := NewRegistry(cookie.NewManager(false, "localhost"), tc.mode, log.New(io.Discard, "", 0))
+
+			r := httptest.NewRequest(http.MethodGet, web.URLRegister, nil)
+			r = r.WithContext(session.WithUser(r.Context(), session.User{UserID: "user", RegistrationStatus: tc.status}))
+
+			called := false
+			w := httptest.NewRecorder()
+			reg.NotRegistered(func(w http.ResponseWriter, r *http.Request) { called = true })(w, r)
+
+			if called != tc.next {
+				t.Fatalf("next called = %v, want %v", called, tc.next)
+			}
+
+			if got := w.Header().Get("Location"); got != tc.location {
+				t.Errorf("Location = %q, want %q", got, tc.location)
+			}
+		})
+	}
+}
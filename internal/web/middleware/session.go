@@ -1,10 +1,13 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
 
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/user"
 	"github.com/cicconee/clox/internal/web"
 	"github.com/cicconee/clox/internal/web/cookie"
 	"github.com/cicconee/clox/internal/web/session"
@@ -13,44 +16,115 @@ import (
 type Session struct {
 	sessions *session.Manager
 	cookies  *cookie.Manager
+	users    *user.Service
 	logger   *log.Logger
 }
 
-func NewSession(sessions *session.Manager, cookies *cookie.Manager, logger *log.Logger) *Session {
-	return &Session{sessions: sessions, cookies: cookies, logger: logger}
+// NewSession creates a new Session middleware. The users are used to verify the user of a session still exists.
+func NewSession(sessions *session.Manager, cookies *cookie.Manager, users *user.Service, logger *log.Logger) *Session {
+	return &Session{sessions: sessions, cookies: cookies, users: users, logger: logger}
 }
 
 // Active is a http middleware that validates a user session. The user session (session.User) is injected into the
 // http request context.
 //
+// When there is no active session, the response is negotiated with app.Negotiate. Browser navigation is redirected to
+// the login page, fetch requests and routes with a app.FormatJSON hint get a JSON error with a 401 status code.
+//
+// The session of a registered user is only active while the user exists. If the user was deleted, the session is
+// destroyed and the request is answered as if there was no session, with a flash explaining why.
+//
 // Active should wrap http handlers that require an active session.
 func (s *Session) Active(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		sessionCookie, err := s.cookies.Get(r, cookie.Session)
 		if err != nil {
-			http.Redirect(w, r, web.URLLogin, http.StatusFound)
+			// Visitors without a session are shown the landing page instead of the login page.
+			redirect := web.URLLogin
+			if r.URL.Path == web.URLDashboard {
+				redirect = web.URLLanding
+			}
+
+			s.unauthorized(w, r, err, redirect)
 			return
 		}
 
 		user, err := s.sessions.Get(r.Context(), sessionCookie.Value)
 		if err != nil {
+			json := app.Negotiate(r, app.FormatHTML) == app.FormatJSON
+
 			if errors.Is(err, session.ErrNoSession) {
-				s.cookies.Set(w, cookie.FlashError, "Please log in again.")
+				if !json {
+					s.cookies.Set(w, cookie.FlashError, "Please log in again.")
+				}
 			} else {
-				s.cookies.Set(w, cookie.FlashError, "Something went wrong. Please log in again.")
+				if !json {
+					s.cookies.Set(w, cookie.FlashError, "Something went wrong. Please log in again.")
+				}
 				s.logger.Printf("[ERROR] [%s %s] Getting user session: %v\n", r.Method, r.URL.Path, err)
 			}
 
 			s.cookies.Clear(w, cookie.Session)
-			http.Redirect(w, r, web.URLLogin, http.StatusFound)
+			s.unauthorized(w, r, err, web.URLLogin)
 			return
 		}
 
-		ctx := session.SetUserContext(r.Context(), user)
+		if err := s.verifyUser(r.Context(), user); err != nil {
+			s.logger.Printf("[WARN] [%s %s] Destroying stale session [userID: %s]: %v\n", r.Method, r.URL.Path, user.UserID, err)
+
+			if err := s.sessions.Del(r.Context(), user); err != nil {
+				s.logger.Printf("[ERROR] [%s %s] Deleting stale session: %v\n", r.Method, r.URL.Path, err)
+			}
+
+			if app.Negotiate(r, app.FormatHTML) != app.FormatJSON {
+				s.cookies.Set(w, cookie.FlashError, "Your account no longer exists. Please log in again.")
+			}
+
+			s.cookies.Clear(w, cookie.Session)
+			s.unauthorized(w, r, err, web.URLLogin)
+			return
+		}
+
+		ctx := session.WithUser(r.Context(), user)
 		next(w, r.WithContext(ctx))
 	}
 }
 
+// verifyUser returns a error if the user of a registered session no longer exists. Users that have not registered
+// are not in the database yet, they are not verified. The users are read with user.Service.Get, which caches them, so
+// a existing user is not read from the database on every request.
+//
+// Errors other than user.ErrUserNotFound are logged and nil is returned, a session is not destroyed because the
+// database could not be read.
+func (s *Session) verifyUser(ctx context.Context, u session.User) error {
+	if u.RegistrationStatus != user.Complete && u.RegistrationStatus != user.Blocked {
+		return nil
+	}
+
+	_, err := s.users.Get(ctx, u.UserID)
+	if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+		s.logger.Printf("[ERROR] Verifying session user [userID: %s]: %v\n", u.UserID, err)
+		return nil
+	}
+
+	return err
+}
+
+// unauthorized writes the response to a request without an active session. A JSON error with a 401 status code is
+// written if JSON is negotiated, otherwise the request is redirected.
+func (s *Session) unauthorized(w http.ResponseWriter, r *http.Request, err error, redirect string) {
+	if app.Negotiate(r, app.FormatHTML) == app.FormatJSON {
+		app.WriteJSONError(w, app.Wrap(app.WrapParams{
+			Err:         err,
+			SafeMessage: "Not logged in",
+			StatusCode:  http.StatusUnauthorized,
+		}))
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
 // Inactive is a http middleware that validates no active session.
 //
 // Inactive should wrap http handlers that require an inactive session.
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cicconee/clox/internal/app"
+	"github.com/cicconee/clox/internal/cache"
+	"github.com/cicconee/clox/internal/db/dbtest"
+	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/internal/web"
+	"github.com/cicconee/clox/internal/web/cookie"
+	"github.com/cicconee/clox/internal/web/session"
+	"github.com/google/uuid"
+)
+
+func TestSessionActiveNoSession(t *testing.T) {
+	s := NewSession(nil, cookie.NewManager(false, "localhost"), nil, log.New(io.Discard, "", 0))
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("next handler called without a session")
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		header   http.Header
+		hint     app.Format
+		want     int
+		location string
+	}{
+		{name: "browser navigation", path: "/tokens", header: http.Header{"Accept": {"text/html"}}, want: http.StatusFound, location: web.URLLogin},
+		{name: "dashboard", path: web.URLDashboard, want: http.StatusFound, location: web.URLLanding},
+		{name: "fetch", path: "/tokens", header: http.Header{"X-Requested-With": {"fetch"}}, want: http.StatusUnauthorized},
+		{name: "accept json", path: "/tokens", header: http.Header{"Accept": {"application/json"}}, want: http.StatusUnauthorized},
+		{name: "json route", path: "/app/api/session", hint: app.FormatJSON, want: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+			if tc.hint != "" {
+				r = r.WithContext(app.WithFormat(r.Context(), tc.hint))
+			}
+
+			w := httptest.NewRecorder()
+			s.Active(next)(w, r)
+
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d", w.Code, tc.want)
+			}
+
+			if got := w.Header().Get("Location"); got != tc.location {
+				t.Errorf("Location = %q, want %q", got, tc.location)
+			}
+
+			if tc.want == http.StatusUnauthorized && w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// newTestSessions creates a session.Manager on the test Redis cache. It is configured by the TEST_REDIS_HOST,
+// TEST_REDIS_PORT, TEST_REDIS_USERNAME, and TEST_REDIS_PASSWORD environment variables. If TEST_REDIS_HOST is not
+// set, t is skipped.
+func newTestSessions(t *testing.T) *session.Manager {
+	t.Helper()
+
+	host := os.Getenv("TEST_REDIS_HOST")
+	if host == "" {
+		t.Skip("TEST_REDIS_HOST is not set")
+	}
+
+	redis := &cache.Redis{}
+	redis.Open(host,
+		os.Getenv("TEST_REDIS_PORT"),
+		os.Getenv("TEST_REDIS_USERNAME"),
+		os.Getenv("TEST_REDIS_PASSWORD"))
+	t.Cleanup(func() { redis.Close() })
+
+	if err := redis.Ping(); err != nil {
+		t.Fatalf("pinging test cache: %v", err)
+	}
+
+	return session.NewManager(redis)
+}
+
+// danglingSession is a registered user with a session, on the test database and cache.
+type danglingSession struct {
+	s        *Session
+	sessions *session.Manager
+	user     session.User
+
+	// deleteUser deletes the user row, leaving the session.
+	deleteUser func()
+}
+
+// newDanglingSession inserts a registered user and sets a session for it. The users are cached for ttl, if ttl is
+// zero they are not cached.
+func newDanglingSession(t *testing.T, ttl time.Duration) danglingSession {
+	t.Helper()
+
+	ctx := context.Background()
+	p := dbtest.Open(t)
+	sessions := newTestSessions(t)
+
+	id := "test|" + uuid.NewString()
+	if err := user.NewRepo(p).Insert(ctx, user.Row{ID: id, Email: "ada@example.com", RegisterStatus: user.Complete}); err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	deleteUser := func() { p.Exec(ctx, `DELETE FROM users WHERE id = $1`, id) }
+	t.Cleanup(deleteUser)
+
+	u := session.User{SessionID: uuid.NewString(), UserID: id, RegistrationStatus: user.Complete}
+	if err := sessions.Set(ctx, u); err != nil {
+		t.Fatalf("setting session: %v", err)
+	}
+	t.Cleanup(func() { sessions.Del(ctx, u) })
+
+	users := user.NewService(user.NewRepo(p))
+	users.SetCache(user.NewCache(10, ttl))
+
+	return danglingSession{
+		s:          NewSession(sessions, cookie.NewManager(false, "localhost"), users, log.New(io.Discard, "", 0)),
+		sessions:   sessions,
+		user:       u,
+		deleteUser: deleteUser,
+	}
+}
+
+// serve serves a request with the session cookie, and returns the response and if the next handler was called.
+func (d danglingSession) serve(header http.Header) (*httptest.ResponseRecorder, bool) {
+	r := httptest.NewRequest(http.MethodGet, "/tokens", nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	r.AddCookie(&http.Cookie{Name: cookie.Session, Value: d.user.SessionID})
+
+	called := false
+	w := httptest.NewRecorder()
+	d.s.Active(func(w http.ResponseWriter, r *http.Request) { called = true })(w, r)
+
+	return w, called
+}
+
+// responseCookie returns the cookie name set by the response, or nil.
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func TestSessionActiveDeletedUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+		flash  bool
+	}{
+		{name: "browser navigation", header: http.Header{"Accept": {"text/html"}}, want: http.StatusFound, flash: true},
+		{name: "fetch", header: http.Header{"X-Requested-With": {"fetch"}}, want: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newDanglingSession(t, 0)
+			d.deleteUser()
+
+			w, called := d.serve(tc.header)
+			if called {
+				t.Fatal("next handler called with the session of a deleted user")
+			}
+
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d", w.Code, tc.want)
+			}
+
+			if tc.want == http.StatusFound && w.Header().Get("Location") != web.URLLogin {
+				t.Errorf("Location = %q, want %q", w.Header().Get("Location"), web.URLLogin)
+			}
+
+			if c := responseCookie(w, cookie.Session); c == nil || c.Value != "" {
+				t.Errorf("session cookie = %v, want it cleared", c)
+			}
+
+			if c := responseCookie(w, cookie.FlashError); (c != nil) != tc.flash {
+				t.Errorf("flash error = %v, want set %v", c, tc.flash)
+			}
+
+			if _, err := d.sessions.Get(context.Background(), d.user.SessionID); !errors.Is(err, session.ErrNoSession) {
+				t.Errorf("session Get() error = %v, want the session destroyed", err)
+			}
+		})
+	}
+}
+
+func TestSessionActiveDeletedUserCached(t *testing.T) {
+	ttl := 200 * time.Millisecond
+	d := newDanglingSession(t, ttl)
+
+	// The first request caches the user.
+	if _, called := d.serve(nil); !called {
+		t.Fatal("next handler not called with the session of a existing user")
+	}
+
+	// Until the cached user expires the deleted user is not noticed, the session is still served.
+	d.deleteUser()
+	if _, called := d.serve(nil); !called {
+		t.Fatal("next handler not called while the deleted user is cached")
+	}
+
+	time.Sleep(ttl + 50*time.Millisecond)
+
+	w, called := d.serve(http.Header{"Accept": {"text/html"}})
+	if called {
+		t.Fatal("next handler called once the cached user expired")
+	}
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != web.URLLogin {
+		t.Errorf("response = %d %s, want a redirect to %s", w.Code, w.Header().Get("Location"), web.URLLogin)
+	}
+
+	if _, err := d.sessions.Get(context.Background(), d.user.SessionID); !errors.Is(err, session.ErrNoSession) {
+		t.Errorf("session Get() error = %v, want the session destroyed", err)
+	}
+}
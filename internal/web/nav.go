@@ -0,0 +1,120 @@
+package web
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+// Nav is the navigation bar and header of a page. It is built by a NavBuilder for the user of the request.
+type Nav struct {
+	// Links are the links to be displayed in the navigation bar.
+	Links []NavLink
+
+	// Logout is the logout link. It is nil if there is no session to log out of.
+	Logout *Link
+
+	// Admin is true if the user is an admin. The header displays a admin badge.
+	Admin bool
+
+	// NearQuota is true if the user has used NearQuotaRatio of the storage quota. The header displays a warning
+	// badge.
+	NearQuota bool
+}
+
+// NearQuotaRatio is the share of the storage quota a user must use before the header warns them.
+const NearQuotaRatio = 0.9
+
+// UsageFunc gets the bytes of storage used by the user with userID.
+type UsageFunc func(ctx context.Context, userID string) (int64, error)
+
+// NavBuilder builds the Nav of the user of a request. The links of the Nav only depend on the registration status of
+// the user and whether the user is an admin, so every variant is built once by NewNavBuilder and shared. Only the
+// NearQuota badge is computed per request.
+//
+// The Links of a built Nav are shared by every request and must not be modified.
+type NavBuilder struct {
+	isAdmin func(username string) bool
+	quota   int64
+	usage   UsageFunc
+
+	guest      Nav
+	registered Nav
+	admin      Nav
+	pending    Nav
+}
+
+// NewNavBuilder creates a new NavBuilder. The isAdmin function reports whether the user with a username is an admin. If
+// nil, no user is an admin.
+//
+// The quota is the storage in bytes a user is expected to stay under, and usage gets the storage a user used. If the
+// quota is zero or usage is nil, the header never warns about the quota.
+func NewNavBuilder(isAdmin func(username string) bool, quota int64, usage UsageFunc) *NavBuilder {
+	if isAdmin == nil {
+		isAdmin = func(string) bool { return false }
+	}
+
+	logout := &Link{URL: URLLogout, Value: "Logout"}
+
+	return &NavBuilder{
+		isAdmin:    isAdmin,
+		quota:      quota,
+		usage:      usage,
+		guest:      Nav{Links: []NavLink{NavLinkLogin}},
+		registered: Nav{Links: NavBarAuthenticated, Logout: logout},
+		admin:      Nav{Links: append(append([]NavLink{}, NavBarAuthenticated...), NavLinkInvites), Logout: logout, Admin: true},
+		pending:    Nav{Logout: logout},
+	}
+}
+
+// Build returns the Nav of u. If u is nil, there is no session and the Nav links to the login page. A user that has not
+// completed registration only gets the logout link.
+//
+// The storage used by a registered user is checked against the quota. If it cannot be checked, the Nav is returned
+// without the NearQuota badge along with the error.
+func (b *NavBuilder) Build(ctx context.Context, u *session.User) (Nav, error) {
+	var nav Nav
+	switch {
+	case u == nil:
+		return b.guest, nil
+	case u.RegistrationStatus != user.Complete:
+		return b.pending, nil
+	case b.isAdmin(u.Username):
+		nav = b.admin
+	default:
+		nav = b.registered
+	}
+
+	if b.quota <= 0 || b.usage == nil {
+		return nav, nil
+	}
+
+	used, err := b.usage(ctx, u.UserID)
+	if err != nil {
+		return nav, fmt.Errorf("getting storage used [user: %s]: %w", u.UserID, err)
+	}
+	nav.NearQuota = float64(used) >= NearQuotaRatio*float64(b.quota)
+
+	return nav, nil
+}
+
+type navContextKey string
+
+var navKey navContextKey = "nav"
+
+// SetNavContext sets the Nav of the request in ctx.
+func SetNavContext(ctx context.Context, nav Nav) context.Context {
+	return context.WithValue(ctx, navKey, nav)
+}
+
+// GetNavContext gets the Nav of the request from ctx. If it was not set, a empty Nav is returned.
+func GetNavContext(ctx context.Context) Nav {
+	nav, ok := ctx.Value(navKey).(Nav)
+	if !ok {
+		return Nav{}
+	}
+
+	return nav
+}
@@ -0,0 +1,108 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/cicconee/clox/internal/user"
+	"github.com/cicconee/clox/internal/web/session"
+)
+
+func TestNavBuilderBuild(t *testing.T) {
+	isAdmin := func(username string) bool { return username == "ada" }
+
+	// usage reports the storage used by each user, of a quota of 100 bytes.
+	usage := func(ctx context.Context, userID string) (int64, error) {
+		switch userID {
+		case "full":
+			return 90, nil
+		case "failing":
+			return 0, errors.New("database down")
+		default:
+			return 89, nil
+		}
+	}
+
+	logout := &Link{URL: URLLogout, Value: "Logout"}
+	adminLinks := append(append([]NavLink{}, NavBarAuthenticated...), NavLinkInvites)
+
+	tests := []struct {
+		name    string
+		user    *session.User
+		want    Nav
+		wantErr bool
+	}{
+		{
+			name: "anonymous",
+			want: Nav{Links: []NavLink{NavLinkLogin}},
+		},
+		{
+			name: "registered",
+			user: &session.User{UserID: "user", Username: "grace", RegistrationStatus: user.Complete},
+			want: Nav{Links: NavBarAuthenticated, Logout: logout},
+		},
+		{
+			name: "admin",
+			user: &session.User{UserID: "user", Username: "ada", RegistrationStatus: user.Complete},
+			want: Nav{Links: adminLinks, Logout: logout, Admin: true},
+		},
+		{
+			name: "pending",
+			user: &session.User{UserID: "full", Username: "grace", RegistrationStatus: user.Incomplete},
+			want: Nav{Logout: logout},
+		},
+		{
+			// An admin that has not completed registration is not shown the admin links.
+			name: "pending admin",
+			user: &session.User{UserID: "user", Username: "ada", RegistrationStatus: user.Incomplete},
+			want: Nav{Logout: logout},
+		},
+		{
+			name: "registered near quota",
+			user: &session.User{UserID: "full", Username: "grace", RegistrationStatus: user.Complete},
+			want: Nav{Links: NavBarAuthenticated, Logout: logout, NearQuota: true},
+		},
+		{
+			name: "admin near quota",
+			user: &session.User{UserID: "full", Username: "ada", RegistrationStatus: user.Complete},
+			want: Nav{Links: adminLinks, Logout: logout, Admin: true, NearQuota: true},
+		},
+		{
+			name:    "usage fails",
+			user:    &session.User{UserID: "failing", Username: "grace", RegistrationStatus: user.Complete},
+			want:    Nav{Links: NavBarAuthenticated, Logout: logout},
+			wantErr: true,
+		},
+	}
+
+	b := NewNavBuilder(isAdmin, 100, usage)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := b.Build(context.Background(), tc.user)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Build() error = %v, want error %t", err, tc.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Build() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNavBuilderBuildNoQuota(t *testing.T) {
+	u := &session.User{UserID: "user", RegistrationStatus: user.Complete}
+	usage := func(ctx context.Context, userID string) (int64, error) {
+		t.Error("usage checked without a quota")
+		return 1 << 40, nil
+	}
+
+	for _, b := range []*NavBuilder{NewNavBuilder(nil, 0, usage), NewNavBuilder(nil, 100, nil)} {
+		nav, err := b.Build(context.Background(), u)
+		if err != nil || nav.NearQuota || nav.Admin {
+			t.Errorf("Build() = %+v, %v, want a registered Nav without badges", nav, err)
+		}
+	}
+}
@@ -1,20 +1,34 @@
 package session
 
-import "context"
+import (
+	"context"
 
-type contextKey string
+	"github.com/cicconee/clox/internal/reqinfo"
+)
 
-var userContextKey contextKey = "user_session"
+// UserKey is the reqinfo.Key of the User of the active session of the request.
+var UserKey = reqinfo.NewKey[User]("session.user")
 
+// WithUser sets the User of the active session and its user and session IDs in the
+// reqinfo.Info of the context.
+func WithUser(ctx context.Context, user User) context.Context {
+	ctx = reqinfo.SetSession(ctx, user.UserID, user.SessionID)
+	return reqinfo.Set(ctx, UserKey, user)
+}
+
+// UserFrom gets the User of the active session from the context. If there is no active
+// session, the zero value is returned.
+func UserFrom(ctx context.Context) User {
+	user, _ := reqinfo.Get(ctx, UserKey)
+	return user
+}
+
+// Deprecated: Use WithUser.
 func SetUserContext(ctx context.Context, user User) context.Context {
-	return context.WithValue(ctx, userContextKey, user)
+	return WithUser(ctx, user)
 }
 
+// Deprecated: Use UserFrom.
 func GetUserContext(ctx context.Context) User {
-	user, ok := ctx.Value(userContextKey).(User)
-	if !ok {
-		return User{}
-	}
-
-	return user
+	return UserFrom(ctx)
 }
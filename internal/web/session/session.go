@@ -31,6 +31,7 @@ type User struct {
 	Email              string      `json:"email"`
 	Username           string      `json:"username"`
 	RegistrationStatus user.Status `json:"registration_status"`
+	EmailVerified      bool        `json:"email_verified"`
 }
 
 // Set sets the user and the user-to-session mapping in the session storage. Sessions will be created
@@ -1,13 +1,14 @@
 package template
 
 import (
+	"fmt"
 	"html/template"
 	"strings"
 )
 
-// funcMap holds all the functions that will be available to the templates. Every template will have access to these
-// functions.
-var funcMap template.FuncMap = template.FuncMap{
+// builtinFuncs holds the functions that are available to every template. They are added to a Template by New, other
+// functions are added with Template.Funcs.
+var builtinFuncs template.FuncMap = template.FuncMap{
 	"formatName": func(name string) string {
 		if len(name) == 0 {
 			return name
@@ -18,4 +19,30 @@ var funcMap template.FuncMap = template.FuncMap{
 
 		return firstLetter + remaining
 	},
+
+	// formatBytes formats a size in bytes with a binary unit, such as "1.5 KiB". It accepts an int64 or a *int64.
+	"formatBytes": func(size any) string {
+		var n int64
+		switch v := size.(type) {
+		case int64:
+			n = v
+		case *int64:
+			if v == nil {
+				return ""
+			}
+			n = *v
+		}
+
+		if n < 1024 {
+			return fmt.Sprintf("%d B", n)
+		}
+
+		f, unit := float64(n), 0
+		for f >= 1024 && unit < 6 {
+			f /= 1024
+			unit++
+		}
+
+		return fmt.Sprintf("%.1f %ciB", f, "KMGTPE"[unit-1])
+	},
 }
@@ -2,6 +2,7 @@ package template
 
 import (
 	"bytes"
+	"errors"
 	"html/template"
 	"io/fs"
 	"log"
@@ -9,30 +10,76 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/cicconee/clox/internal/reqinfo"
 	"github.com/cicconee/clox/internal/web"
-	"github.com/cicconee/clox/internal/web/flash"
 )
 
+// ErrFuncsAfterParse signals template functions were added after the templates were parsed.
+var ErrFuncsAfterParse = errors.New("template functions added after parse")
+
 // Template holds the parsed templates and is responsible for executing them. Before executing a template the Parse
 // method must be called.
+//
+// Template is safe to use from multiple goroutines. Parse may be called again while templates are executing, an
+// Execute uses the set of templates that was parsed last when it started.
 type Template struct {
 	Name   string
 	Path   string
 	Logger *log.Logger
-	tmpl   *template.Template
+
+	// tmpl is the parsed set of templates. It is swapped by Parse.
+	tmpl atomic.Pointer[template.Template]
+
+	// mu guards funcs and parsed.
+	mu     sync.Mutex
+	funcs  template.FuncMap
+	parsed bool
 }
 
-// New creates a new Template that will wrap the parsed templates (tmpl).
+// New creates a new Template that will wrap the parsed templates. The built-in template functions are added to it.
 func New(name string, path string, logger *log.Logger) *Template {
-	return &Template{Name: name, Path: path, Logger: logger}
+	t := &Template{Name: name, Path: path, Logger: logger, funcs: template.FuncMap{}}
+	t.Funcs(builtinFuncs)
+
+	return t
+}
+
+// Funcs adds the functions in funcs to the functions available to every template. Funcs is additive, a function with
+// the name of one already added replaces it.
+//
+// The functions must be added before Parse is called, the templates are compiled against the functions they can
+// call. If Parse was already called, nothing is added and ErrFuncsAfterParse is returned.
+func (t *Template) Funcs(funcs map[string]any) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.parsed {
+		return ErrFuncsAfterParse
+	}
+
+	for name, fn := range funcs {
+		t.funcs[name] = fn
+	}
+
+	return nil
 }
 
 // Parse will parse every template (.gohtml) in this template Path. Every template that will be explicitly executed
 // should include {{define "template-name"}}. The handlers could then execute these templates by
 // specifying the "template-name".
+//
+// The parsed templates replace the current ones only if every template parses. Calling Parse again re-parses the
+// templates, for example to reload them after they changed. Functions may still be added with Funcs after a Parse
+// that failed.
 func (t *Template) Parse() error {
-	templates := template.New(t.Name).Funcs(funcMap)
+	// Funcs waits for the parse, a function is never added after the templates were compiled without it.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	templates := template.New(t.Name).Funcs(t.funcs)
 
 	err := filepath.Walk(t.Path, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
@@ -60,7 +107,8 @@ func (t *Template) Parse() error {
 		return err
 	}
 
-	t.tmpl = templates
+	t.tmpl.Store(templates)
+	t.parsed = true
 
 	return nil
 }
@@ -74,16 +122,30 @@ type ExecuteParams struct {
 	// PageID is the ID of the page being executed.
 	PageID string
 
-	// NavLinks is the slice of web.NavLink that should be displayed in the navigation bar.
-	NavLinks []web.NavLink
-
-	// Authenticated is used by the base layout to render template data that should only be visible if the page requires
-	// authentication or not. Each template that requires the user to be authenticated should set this value to true so
-	// that all the appropriate html is rendered.
-	Authenticated bool
-
 	// Data is the values that will be injected into the page template. Data is not accessible in the base layout.
 	Data any
+
+	// Alert is displayed above the page content by the base layout. Handlers should set Alert when a service call
+	// fails and the page is rendered with partial data, instead of silently rendering empty data.
+	Alert *Alert
+}
+
+// The Alert levels. The level is used as the bootstrap alert class suffix.
+const (
+	AlertWarning string = "warning"
+	AlertDanger  string = "danger"
+)
+
+// Alert is a message displayed above the page content by the base layout.
+type Alert struct {
+	// Level is the alert level. It should be AlertWarning or AlertDanger.
+	Level string
+
+	// Message is the user friendly message.
+	Message string
+
+	// Retryable renders a link to reload the page when true.
+	Retryable bool
 }
 
 // page is the data that is accessible in the page template. This data is not accessible in the base layout template.
@@ -103,20 +165,20 @@ type base struct {
 	// Content holds the page template as HTML. Content is written to the {{.Content}} directive within the base layout.
 	Content template.HTML
 
-	// NavBar is a slice of NavLink's to be displayed in a navigation bar.
-	NavBar []web.NavLink
+	// Nav is the navigation bar and header of the user. It is built by the middleware.Nav of the route.
+	Nav web.Nav
 
 	// PageID is the page that is being displayed within the base layout.
 	PageID string
 
-	// Authenticated renders certain data differently based on if the page requires authentication or not.
-	Authenticated bool
-
-	// LinkLogout is the logout url and value to be rendered when rendering a page that requires authentication.
-	LinkLogout web.Link
-
 	FlashMessage string
 	FlashError   string
+
+	// Alert is displayed above the page content if not nil.
+	Alert *Alert
+
+	// RetryURL is the URL to reload the current page. It is used when Alert is retryable.
+	RetryURL string
 }
 
 // Execute will execute the specified template defined with tmplName. ExecuteParams is used to inject data into the
@@ -125,30 +187,57 @@ type base struct {
 // All templates to be executed will be injected into the base layout template (base.gohtml). The base layout has a
 // {{.Content}} directive within the <main> html tag. This is where the templates specified by tmplName will be written.
 func (t *Template) Execute(w http.ResponseWriter, r *http.Request, tmplName string, p ExecuteParams) {
+	// The page and the base layout are executed with the same set, even if Parse swaps it in between.
+	tmpl := t.tmpl.Load()
+	if tmpl == nil {
+		t.Logger.Printf("[ERROR] [%s %s] Executing template [name: %s]: templates not parsed\n", r.Method, r.URL.Path, tmplName)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
 	// Build content to be injected into the base layout. This is the current page that is being rendered.
 	// Cache it in a buffer to then be injected into the base layout.
+	//
+	// If the page template fails, the page is rendered without content and with a alert. The partially
+	// executed page template is discarded.
+	status := http.StatusOK
 	var content bytes.Buffer
-	err := t.tmpl.ExecuteTemplate(&content, tmplName, page{
+	err := tmpl.ExecuteTemplate(&content, tmplName, page{
 		Data: p.Data,
 	})
 	if err != nil {
 		t.Logger.Printf("[ERROR] [%s %s] Executing template [name: %s]: %v\n", r.Method, r.URL.Path, tmplName, err)
+		content.Reset()
+		status = http.StatusInternalServerError
+		p.Alert = &Alert{
+			Level:     AlertDanger,
+			Message:   "Something went wrong displaying this page.",
+			Retryable: true,
+		}
 	}
 
 	// Write the base layout with the injected the page template.
-	// Page template is injected via Content field.
-	err = t.tmpl.ExecuteTemplate(w, "base", base{
-		Title:         p.Title,
-		Content:       template.HTML(content.String()),
-		NavBar:        p.NavLinks,
-		PageID:        p.PageID,
-		Authenticated: p.Authenticated,
-		LinkLogout:    web.Link{URL: web.URLLogout, Value: "Logout"},
-		FlashMessage:  flash.GetMessageContext(r.Context()),
-		FlashError:    flash.GetErrorContext(r.Context()),
+	// Page template is injected via Content field. The base layout is buffered so that a failure does
+	// not write a partial page.
+	var layout bytes.Buffer
+	info := reqinfo.From(r.Context())
+	err = tmpl.ExecuteTemplate(&layout, "base", base{
+		Title:        p.Title,
+		Content:      template.HTML(content.String()),
+		Nav:          web.GetNavContext(r.Context()),
+		PageID:       p.PageID,
+		FlashMessage: info.FlashMessage,
+		FlashError:   info.FlashError,
+		Alert:        p.Alert,
+		RetryURL:     r.URL.RequestURI(),
 	})
 	if err != nil {
 		t.Logger.Printf("[ERROR] [%s %s] Executing base template [name: %s]: %v\n", r.Method, r.URL.Path, "base", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	layout.WriteTo(w)
 }
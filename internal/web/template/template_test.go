@@ -0,0 +1,176 @@
+package template
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestTemplate writes files, by name, to a new directory and parses them as a
+// Template.
+func newTestTemplate(t *testing.T, files map[string]string) *Template {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("writing template %s: %v", name, err)
+		}
+	}
+
+	tmpl := New("test", dir, log.New(io.Discard, "", 0))
+	if err := tmpl.Parse(); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	return tmpl
+}
+
+// testBase is a base layout that writes the alert and the content.
+const testBase = `{{define "base"}}<title>{{.Title}}</title>` +
+	`{{if .Alert}}<div class="alert-{{.Alert.Level}}">{{.Alert.Message}}{{if .Alert.Retryable}}<a href="{{.RetryURL}}">Try again</a>{{end}}</div>{{end}}` +
+	`<main>{{.Content}}</main>{{end}}`
+
+func TestExecuteAlert(t *testing.T) {
+	tmpl := newTestTemplate(t, map[string]string{
+		"base.gohtml": testBase,
+		"page.gohtml": `{{define "page"}}<p>{{len .Data}} tokens</p>{{end}}`,
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/tokens?cursor=abc", nil)
+	tmpl.Execute(w, r, "page", ExecuteParams{
+		Title: "Tokens",
+		Data:  []string{},
+		Alert: &Alert{Level: AlertWarning, Message: "Tokens could not be loaded.", Retryable: true},
+	})
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	want := `<title>Tokens</title><div class="alert-warning">Tokens could not be loaded.<a href="/tokens?cursor=abc">Try again</a></div><main><p>0 tokens</p></main>`
+	if got := w.Body.String(); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestExecutePageFailure(t *testing.T) {
+	tmpl := newTestTemplate(t, map[string]string{
+		"base.gohtml": testBase,
+		"page.gohtml": `{{define "page"}}<p>partial</p>{{index .Data 5}}{{end}}`,
+	})
+
+	w := httptest.NewRecorder()
+	tmpl.Execute(w, httptest.NewRequest(http.MethodGet, "/tokens", nil), "page", ExecuteParams{Title: "Tokens", Data: []string{"a"}})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	body := w.Body.String()
+	if strings.Contains(body, "partial") {
+		t.Errorf("body = %s, want the partial page discarded", body)
+	}
+
+	if !strings.Contains(body, `<div class="alert-danger">`) || !strings.Contains(body, `<a href="/tokens">Try again</a>`) {
+		t.Errorf("body = %s, want a retryable danger alert", body)
+	}
+}
+
+func TestExecuteLayoutFailure(t *testing.T) {
+	tmpl := newTestTemplate(t, map[string]string{
+		"base.gohtml": `{{define "base"}}<title>{{.Title}}</title>{{index .Alert.Message 99}}{{end}}`,
+		"page.gohtml": `{{define "page"}}<p>page</p>{{end}}`,
+	})
+
+	w := httptest.NewRecorder()
+	tmpl.Execute(w, httptest.NewRequest(http.MethodGet, "/", nil), "page", ExecuteParams{
+		Title: "Home",
+		Alert: &Alert{Level: AlertWarning, Message: "short"},
+	})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	if body := w.Body.String(); strings.Contains(body, "<title>") {
+		t.Errorf("body = %s, want no partial layout written", body)
+	}
+}
+
+func TestFuncsAfterParse(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.gohtml")
+	if err := os.WriteFile(page, []byte(`{{define "page"}}{{shout "hi"}}{{end}}`), 0644); err != nil {
+		t.Fatalf("writing template: %v", err)
+	}
+
+	tmpl := New("test", dir, log.New(io.Discard, "", 0))
+
+	// The page calls a function that was not added, the parse fails and does not block adding it.
+	if err := tmpl.Parse(); err == nil {
+		t.Fatal("Parse() error = nil, want the undefined function error")
+	}
+
+	if err := tmpl.Funcs(map[string]any{"shout": strings.ToUpper}); err != nil {
+		t.Fatalf("Funcs() after a failed parse error = %v", err)
+	}
+
+	if err := tmpl.Parse(); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if err := tmpl.Funcs(map[string]any{"whisper": strings.ToLower}); !errors.Is(err, ErrFuncsAfterParse) {
+		t.Errorf("Funcs() after parse error = %v, want ErrFuncsAfterParse", err)
+	}
+}
+
+// TestParseConcurrentExecute re-parses the templates while they execute. Run it with -race.
+func TestParseConcurrentExecute(t *testing.T) {
+	tmpl := newTestTemplate(t, map[string]string{
+		"base.gohtml": testBase,
+		"page.gohtml": `{{define "page"}}<p>{{.Data}}</p>{{end}}`,
+	})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				w := httptest.NewRecorder()
+				tmpl.Execute(w, httptest.NewRequest(http.MethodGet, "/", nil), "page", ExecuteParams{Title: "Home", Data: "hello"})
+				if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<p>hello</p>") {
+					t.Errorf("Execute() = %d %s, want the page", w.Code, w.Body.String())
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := tmpl.Parse(); err != nil {
+			t.Errorf("Parse() error = %v", err)
+			break
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
@@ -1,30 +1,94 @@
 package web
 
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
 // The server side endpoints for Clox.
 //
 // All handler declarations and redirects should use these constants. If any new endpoints are implemented, append to
 // these constant values. This will keep the endpoint values centralized and allow for easy changes.
 const (
-	URLDashboard      string = "/"
-	URLLogin          string = "/login"
-	URLGoogleLogin    string = "/login/google"
-	URLGoogleCallback string = "/login/google/callback"
-	URLRegister       string = "/register"
-	URLLogout         string = "/logout"
-	URLTokens         string = "/tokens"
-	URLTokenResource  string = URLTokens + "/{id}"
+	URLDashboard        string = "/"
+	URLLogin            string = "/login"
+	URLGoogleLogin      string = "/login/google"
+	URLGoogleCallback   string = "/login/google/callback"
+	URLRegister         string = "/register"
+	URLLogout           string = "/logout"
+	URLTokens           string = "/tokens"
+	URLTokenResource    string = URLTokens + "/{id}"
+	URLTokensInvalidate string = URLTokens + "/invalidate"
+	URLConsole          string = "/console"
+	URLUnverified       string = "/unverified"
+	URLStorageRetry     string = "/storage/retry"
+	URLAvatar           string = "/avatar/{userID}"
+	URLAvatarRefresh    string = "/avatar/refresh"
+	URLLanding          string = "/welcome"
+	URLRegisterClosed   string = "/register/closed"
+	URLAdminInvites     string = "/admin/invites"
+	URLActivity         string = "/activity"
+	URLFiles            string = "/files"
+	URLFilesDir         string = URLFiles + "/{id}"
+	URLFileInfo         string = "/file/{id}"
+)
+
+// The server side JSON endpoints for Clox. These endpoints are requested by the frontend javascript and always respond
+// with JSON, including errors.
+const (
+	URLAPI            string = "/app/api"
+	URLAPISession     string = URLAPI + "/session"
+	URLAPITokens      string = URLAPI + "/tokens"
+	URLAPIEntries     string = URLAPI + "/dir/{id}/entries"
+	URLAPIUploadStats string = URLAPI + "/stats/uploads"
 )
 
 // The server side app page ID's for Clox. Page IDs refer to the actual page displayed.
 //
 // Every page that is rendered will have a corresponding page ID. These values will be accessible in the templates.
 const (
-	PageDashboard string = "dashboard"
-	PageLogin     string = "login"
-	PageRegister  string = "register"
-	PageTokens    string = "tokens"
+	PageDashboard  string = "dashboard"
+	PageLogin      string = "login"
+	PageRegister   string = "register"
+	PageTokens     string = "tokens"
+	PageConsole    string = "console"
+	PageUnverified string = "unverified"
+	PageLanding    string = "landing"
+	PageClosed     string = "closed"
+	PageInvites    string = "invites"
+	PageActivity   string = "activity"
+	PageFiles      string = "files"
+	PageFileInfo   string = "file"
 )
 
+// AvatarURL returns the URL of the stored avatar of a user.
+func AvatarURL(userID string) string {
+	return "/avatar/" + url.PathEscape(userID)
+}
+
+// DirURL returns the URL of the file browser page of a directory.
+func DirURL(dirID string) string {
+	return URLFiles + "/" + url.PathEscape(dirID)
+}
+
+// FileInfoURL returns the URL of the info page of a file.
+func FileInfoURL(fileID string) string {
+	return "/file/" + url.PathEscape(fileID)
+}
+
+// ReturnURL returns the "return" URL query or form value of r if it is a path on this server, otherwise fallback is
+// returned. Pages link to other pages, and actions redirect, with the URL of the page they came from as the "return"
+// value, so the user is sent back to the same page with the same query parameters.
+func ReturnURL(r *http.Request, fallback string) string {
+	ret := r.FormValue("return")
+	if !strings.HasPrefix(ret, "/") || strings.HasPrefix(ret, "//") || strings.HasPrefix(ret, "/\\") {
+		return fallback
+	}
+
+	return ret
+}
+
 // Link holds a URL and its display value. Link will be injected into templates to navigate the Clox server side app.
 type Link struct {
 	// The URL to navigate to. This is the href attribute in an html anchor tag.
@@ -34,6 +98,15 @@ type Link struct {
 	Value string
 }
 
+// Pager holds the links to the previous and next pages of a paginated page. It is rendered by the "pager" template.
+type Pager struct {
+	// PrevURL is the URL of the previous page. It is empty on the first page.
+	PrevURL string
+
+	// NextURL is the URL of the next page. It is empty on the last page.
+	NextURL string
+}
+
 // NavLink holds a link to be displayed in a navigation bar.
 type NavLink struct {
 	// The ID of the page the link corresponds to. This is useful when wanting to style an active link.
@@ -60,6 +133,27 @@ var NavLinkTokens = NavLink{
 	Value:  "Tokens",
 }
 
+// NavLinkFiles is a navigation link for the file browser.
+var NavLinkFiles = NavLink{
+	PageID: PageFiles,
+	URL:    URLFiles,
+	Value:  "Files",
+}
+
+// NavLinkActivity is a navigation link for the activity page.
+var NavLinkActivity = NavLink{
+	PageID: PageActivity,
+	URL:    URLActivity,
+	Value:  "Activity",
+}
+
+// NavLinkInvites is a navigation link for the invites page. It is only displayed to admins.
+var NavLinkInvites = NavLink{
+	PageID: PageInvites,
+	URL:    URLAdminInvites,
+	Value:  "Invites",
+}
+
 // NavLinkLogin is a navigation link for the login page.
 var NavLinkLogin = NavLink{
 	PageID: PageLogin,
@@ -69,4 +163,4 @@ var NavLinkLogin = NavLink{
 
 // NavBarAuthenticated is the navigation bar to be displayed once a user is authenticated. Use this navigation bar only
 // once a user is authenticated and registered.
-var NavBarAuthenticated = []NavLink{NavLinkDashboard, NavLinkTokens}
+var NavBarAuthenticated = []NavLink{NavLinkDashboard, NavLinkFiles, NavLinkTokens, NavLinkActivity}
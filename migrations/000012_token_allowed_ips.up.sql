@@ -0,0 +1 @@
+ALTER TABLE user_tokens ADD COLUMN allowed_ips TEXT[] NOT NULL DEFAULT '{}';
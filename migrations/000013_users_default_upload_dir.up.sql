@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN default_upload_dir UUID NULL REFERENCES directories(id) ON DELETE SET NULL;
@@ -0,0 +1 @@
+ALTER TABLE user_tokens ADD COLUMN kind VARCHAR(32) NOT NULL DEFAULT 'user';
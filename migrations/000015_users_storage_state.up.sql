@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN storage_state VARCHAR(16) NOT NULL DEFAULT 'pending';
+UPDATE users SET storage_state = 'ready' WHERE id IN (SELECT user_id FROM directories WHERE parent_id IS NULL);
@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN size BIGINT NULL;
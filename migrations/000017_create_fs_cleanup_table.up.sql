@@ -0,0 +1,8 @@
+CREATE TABLE fs_cleanup (
+    path TEXT PRIMARY KEY,
+    recursive BOOLEAN NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_error TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS backfill_checkpoints;
+
+ALTER TABLE files
+    DROP COLUMN IF EXISTS checksum,
+    DROP COLUMN IF EXISTS content_type,
+    DROP COLUMN IF EXISTS content_missing;
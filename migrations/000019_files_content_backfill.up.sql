@@ -0,0 +1,16 @@
+ALTER TABLE files
+    ADD COLUMN checksum VARCHAR(64) NULL,
+    ADD COLUMN content_type VARCHAR(255) NULL,
+    ADD COLUMN content_missing BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE backfill_checkpoints (
+    name VARCHAR(64) PRIMARY KEY,
+    last_id UUID NULL,
+    processed BIGINT NOT NULL DEFAULT 0,
+    updated BIGINT NOT NULL DEFAULT 0,
+    missing BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    started_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NULL
+);
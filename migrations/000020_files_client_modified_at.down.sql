@@ -0,0 +1 @@
+ALTER TABLE files DROP COLUMN client_modified_at;
@@ -0,0 +1,5 @@
+ALTER TABLE files ADD COLUMN client_modified_at TIMESTAMPTZ NULL;
+
+UPDATE files SET client_modified_at = uploaded_at;
+
+ALTER TABLE files ALTER COLUMN client_modified_at SET NOT NULL;
@@ -0,0 +1,9 @@
+CREATE TABLE invites (
+    id VARCHAR(36) PRIMARY KEY,
+    code_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_by VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_by VARCHAR(255) NULL,
+    used_at TIMESTAMPTZ NULL
+);
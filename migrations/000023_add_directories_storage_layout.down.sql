@@ -0,0 +1 @@
+ALTER TABLE directories DROP COLUMN storage_layout;
@@ -0,0 +1 @@
+ALTER TABLE directories ADD COLUMN storage_layout SMALLINT NOT NULL DEFAULT 1;
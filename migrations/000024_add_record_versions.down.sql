@@ -0,0 +1,3 @@
+ALTER TABLE directories DROP COLUMN record_version;
+
+ALTER TABLE files DROP COLUMN record_version;
@@ -0,0 +1,9 @@
+ALTER TABLE files ADD COLUMN record_version SMALLINT NOT NULL DEFAULT 1;
+
+UPDATE files SET record_version = 2
+WHERE checksum IS NOT NULL AND content_type IS NOT NULL AND NOT content_missing;
+
+ALTER TABLE directories ADD COLUMN record_version SMALLINT NOT NULL DEFAULT 1;
+
+-- Every directory created before 000023 was stored flat, the storage_layout default.
+UPDATE directories SET record_version = 2;
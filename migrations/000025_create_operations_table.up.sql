@@ -0,0 +1,15 @@
+CREATE TABLE operations (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(64) NOT NULL,
+    params_hash VARCHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    processed BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    error VARCHAR(255) NOT NULL DEFAULT '',
+    started_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX operations_user_id_started_at_idx ON operations(user_id, started_at DESC);
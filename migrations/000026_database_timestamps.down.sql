@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS directories_set_updated_at ON directories;
+DROP FUNCTION IF EXISTS directories_set_updated_at();
+
+ALTER TABLE files ALTER COLUMN client_modified_at DROP DEFAULT;
+ALTER TABLE files ALTER COLUMN uploaded_at DROP DEFAULT;
+ALTER TABLE directories ALTER COLUMN created_at DROP DEFAULT;
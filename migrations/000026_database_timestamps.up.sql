@@ -0,0 +1,15 @@
+ALTER TABLE directories ALTER COLUMN created_at SET DEFAULT now();
+ALTER TABLE files ALTER COLUMN uploaded_at SET DEFAULT now();
+ALTER TABLE files ALTER COLUMN client_modified_at SET DEFAULT now();
+
+CREATE FUNCTION directories_set_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER directories_set_updated_at
+    BEFORE UPDATE OF name, parent_id, storage_layout ON directories
+    FOR EACH ROW
+    EXECUTE FUNCTION directories_set_updated_at();
@@ -0,0 +1,5 @@
+UPDATE files SET record_version = 2 WHERE record_version > 2;
+
+DROP INDEX IF EXISTS files_search_vector_idx;
+ALTER TABLE files DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE files DROP COLUMN IF EXISTS search_text;
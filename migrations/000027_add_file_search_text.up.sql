@@ -0,0 +1,15 @@
+ALTER TABLE files ADD COLUMN search_text TEXT NULL;
+ALTER TABLE files ADD COLUMN search_vector TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector('english', coalesce(search_text, ''))) STORED;
+
+CREATE INDEX files_search_vector_idx ON files USING GIN (search_vector);
+
+-- Files that are never indexed have nothing for the backfill to record. The extensions and
+-- size limit match cloudstore.searchableExts and cloudstore.MaxSearchTextBytes.
+UPDATE files SET record_version = 3
+WHERE record_version = 2
+  AND (size > 1048576 OR coalesce(lower(substring(name from '\.[^.]*$')), '') NOT IN (
+      '.txt', '.md', '.markdown', '.csv', '.tsv', '.log', '.json', '.yaml', '.yml', '.toml', '.ini',
+      '.xml', '.html', '.htm', '.css', '.sql', '.sh', '.go', '.py', '.js', '.ts', '.java', '.c',
+      '.h', '.cpp', '.hpp', '.rs', '.rb', '.php'
+  ));
@@ -0,0 +1,12 @@
+CREATE TABLE transfer_stats (
+    day DATE NOT NULL,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_id VARCHAR(36) NOT NULL,
+    direction VARCHAR(16) NOT NULL,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    requests BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (day, user_id, token_id, direction)
+);
+
+CREATE INDEX transfer_stats_user_id_day_idx ON transfer_stats(user_id, day);
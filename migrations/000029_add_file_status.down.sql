@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS files_pending_idx;
+ALTER TABLE files DROP COLUMN IF EXISTS status;
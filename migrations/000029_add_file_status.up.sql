@@ -0,0 +1,6 @@
+-- A file is pending from the commit of its row until its staged content is renamed into
+-- place. Only uploads in strict write mode are ever pending.
+ALTER TABLE files ADD COLUMN status TEXT NOT NULL DEFAULT 'ready'
+    CHECK (status IN ('pending', 'ready'));
+
+CREATE INDEX files_pending_idx ON files (uploaded_at) WHERE status = 'pending';
@@ -0,0 +1,3 @@
+-- The generation of the tokens of a user. Tokens minted before the generation claim have no
+-- claim, which is read as 0, so they stay valid until the generation is first bumped.
+ALTER TABLE users ADD COLUMN token_generation BIGINT NOT NULL DEFAULT 0;
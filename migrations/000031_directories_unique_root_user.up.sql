@@ -0,0 +1,7 @@
+-- A user has a single directory without a parent, their root directory. The name of the root
+-- directory is no longer part of the index, so the root is found by parent_id alone.
+DROP INDEX unique_user_root_directory;
+
+CREATE UNIQUE INDEX unique_user_root_directory
+ON directories (user_id)
+WHERE parent_id IS NULL;
@@ -0,0 +1,13 @@
+CREATE TABLE client_certs (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    subject TEXT NOT NULL,
+    not_after TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    last_used TIMESTAMPTZ NULL,
+    CONSTRAINT unique_client_cert_fingerprint UNIQUE (fingerprint)
+);
+
+CREATE INDEX client_certs_user_id_idx ON client_certs (user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE directories ADD COLUMN created_via VARCHAR(16) NOT NULL DEFAULT 'explicit';
+UPDATE directories SET created_via = 'auto_root' WHERE parent_id IS NULL;
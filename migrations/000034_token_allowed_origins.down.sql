@@ -0,0 +1,2 @@
+DROP INDEX user_tokens_allowed_origins;
+ALTER TABLE user_tokens DROP COLUMN allowed_origins;
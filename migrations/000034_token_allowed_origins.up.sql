@@ -0,0 +1,4 @@
+ALTER TABLE user_tokens ADD COLUMN allowed_origins TEXT[] NOT NULL DEFAULT '{}';
+
+-- The CORS layer looks up if any token allows an origin on every cross-origin request.
+CREATE INDEX user_tokens_allowed_origins ON user_tokens USING GIN (allowed_origins);
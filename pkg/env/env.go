@@ -22,7 +22,7 @@ func NewFileLoader(filepath string) (*Loader, error) {
 }
 
 // Load will read the content in ReadCloser line by line and set the environment
-// variables. If a syntax error is found an error will be returned including the
+// variables. Blank lines and lines starting with "#" are skipped. If a syntax error is found an error will be returned including the
 // line number in filepath.
 func (l *Loader) Load() error {
 	scanner := bufio.NewScanner(l.ReadCloser)
@@ -32,6 +32,10 @@ func (l *Loader) Load() error {
 		line := scanner.Text()
 		lineNumber++
 
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
 		kv, ok := parseLine(line)
 		if !ok {
 			return fmt.Errorf("syntax error at line %d: %s", lineNumber, line)
@@ -54,21 +58,20 @@ type keyValue struct {
 
 // parseLine will parse a string to extract the environment variable. It expects
 // the line to be formatted as KEY=VALUE. White space will be trimmed on both
-// the key and value.
+// the key and value. The value may be empty, and may contain "=".
 //
 // If the line is parsed successfully a nonempty keyvalue and
 // true will be returned, otherwise an empty keyvalue and false is returned.
 func parseLine(line string) (keyValue, bool) {
-	parts := strings.Split(line, "=")
-	if len(parts) != 2 {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
 		return keyValue{}, false
 	}
 
-	key := strings.TrimSpace(parts[0])
-	value := strings.TrimSpace(parts[1])
-	if key == "" || value == "" {
+	key = strings.TrimSpace(key)
+	if key == "" {
 		return keyValue{}, false
 	}
 
-	return keyValue{key, value}, true
+	return keyValue{key, strings.TrimSpace(value)}, true
 }
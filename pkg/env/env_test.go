@@ -0,0 +1,63 @@
+package env
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want keyValue
+		ok   bool
+	}{
+		{line: "HOST=localhost", want: keyValue{"HOST", "localhost"}, ok: true},
+		{line: " PORT = 8080 ", want: keyValue{"PORT", "8080"}, ok: true},
+		{line: "TRUSTED_PROXIES=", want: keyValue{"TRUSTED_PROXIES", ""}, ok: true},
+		{line: "FAULTS=commit=1,fs.copy=2", want: keyValue{"FAULTS", "commit=1,fs.copy=2"}, ok: true},
+		{line: "HOST", ok: false},
+		{line: "=localhost", ok: false},
+	}
+
+	for _, tc := range tests {
+		got, ok := parseLine(tc.line)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseLine(%q) = %v, %v, want %v, %v", tc.line, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestLoadTemplate(t *testing.T) {
+	f, err := os.Open("../../.env.template")
+	if err != nil {
+		t.Fatalf("opening template: %v", err)
+	}
+
+	// t.Setenv restores every variable of the template once the test finishes.
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("reading template: %v", err)
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if key, _, ok := strings.Cut(line, "="); ok && !strings.HasPrefix(line, "#") {
+			t.Setenv(key, "unset")
+		}
+	}
+
+	l := &Loader{ReadCloser: io.NopCloser(strings.NewReader(string(b)))}
+	if err := l.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if got := os.Getenv("WARMUP_MODE"); got != "warn" {
+		t.Errorf("WARMUP_MODE = %q, want %q", got, "warn")
+	}
+
+	if got, ok := os.LookupEnv("TRUSTED_PROXIES"); !ok || got != "" {
+		t.Errorf("TRUSTED_PROXIES = %q, want it set and empty", got)
+	}
+}
@@ -0,0 +1,143 @@
+package env
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is the error of a environment variable that could not be parsed or is out of range.
+type Error struct {
+	// Key is the name of the environment variable.
+	Key string
+
+	// Value is the value of the environment variable.
+	Value string
+
+	// Reason is why Value is not valid.
+	Reason string
+
+	// Example is a valid value of the environment variable.
+	Example string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s, for example %q", e.Key, e.Value, e.Reason, e.Example)
+}
+
+// Check validates a parsed value. If the value is not valid, it returns the reason, such
+// as "must be at least 1".
+type Check[T any] func(T) string
+
+// Min checks a value is at least min.
+func Min[T int | int64 | time.Duration](min T) Check[T] {
+	return func(v T) string {
+		if v < min {
+			return fmt.Sprintf("must be at least %v", min)
+		}
+
+		return ""
+	}
+}
+
+// Max checks a value is at most max.
+func Max[T int | int64 | time.Duration](max T) Check[T] {
+	return func(v T) string {
+		if v > max {
+			return fmt.Sprintf("must be at most %v", max)
+		}
+
+		return ""
+	}
+}
+
+// Duration parses the environment variable key as a time.Duration, such as "30s" or "5m". If
+// the variable is not set or empty, def is returned. The parsed value must pass every check.
+func Duration(key string, def time.Duration, checks ...Check[time.Duration]) (time.Duration, error) {
+	return parse(key, def, "30s", "must be a duration", time.ParseDuration, checks)
+}
+
+// Int parses the environment variable key as a base 10 integer. If the variable is not set or
+// empty, def is returned. The parsed value must pass every check.
+func Int(key string, def int, checks ...Check[int]) (int, error) {
+	return parse(key, def, "10", "must be a integer", strconv.Atoi, checks)
+}
+
+// Bytes parses the environment variable key as a number of bytes. If the variable is not set or
+// empty, def is returned. The parsed value must pass every check.
+//
+// The value is a non-negative integer followed by an optional unit, with no space between them.
+// The units are B, the decimal KB, MB, GB, and TB, and the binary KiB, MiB, GiB, and TiB. A value
+// without a unit is in bytes.
+func Bytes(key string, def int64, checks ...Check[int64]) (int64, error) {
+	return parse(key, def, "100MB", "must be a size in bytes with an optional unit of B, KB, MB, GB, TB, KiB, MiB, GiB, or TiB", parseBytes, checks)
+}
+
+// parse parses the environment variable key with parseFunc. If parseFunc fails, the Error has
+// reason syntax. Otherwise the first failed check is the reason.
+func parse[T any](key string, def T, example string, syntax string, parseFunc func(string) (T, error), checks []Check[T]) (T, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	v, err := parseFunc(value)
+	if err != nil {
+		return def, &Error{Key: key, Value: value, Reason: syntax, Example: example}
+	}
+
+	for _, check := range checks {
+		if reason := check(v); reason != "" {
+			return def, &Error{Key: key, Value: value, Reason: reason, Example: example}
+		}
+	}
+
+	return v, nil
+}
+
+// byteUnits are the units accepted by Bytes. The longest units are first so the suffix that
+// matches is the whole unit.
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"B", 1},
+}
+
+// parseBytes parses a size with the grammar of Bytes.
+func parseBytes(s string) (int64, error) {
+	number, size := s, int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			number, size = strings.TrimSuffix(s, u.suffix), u.size
+			break
+		}
+	}
+
+	// ParseInt accepts a sign, a size does not have one.
+	if number == "" || number[0] < '0' || number[0] > '9' {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > math.MaxInt64/size {
+		return 0, fmt.Errorf("size %q overflows int64", s)
+	}
+
+	return n * size, nil
+}
@@ -0,0 +1,71 @@
+// The API base URL and console token rendered by the server.
+const consoleConfig = document.getElementById("consoleConfig");
+const apiURL = consoleConfig.getAttribute("data-api-url");
+const consoleToken = consoleConfig.getAttribute("data-token");
+
+// The request form elements.
+const consoleForm = document.getElementById("consoleForm");
+const consoleMethod = document.getElementById("consoleMethod");
+const consolePath = document.getElementById("consolePath");
+const consoleBody = document.getElementById("consoleBody");
+const consoleFiles = document.getElementById("consoleFiles");
+
+// The response elements.
+const consoleStatus = document.getElementById("consoleStatus");
+const consoleResponse = document.getElementById("consoleResponse");
+
+// Selecting an endpoint fills in the request method and path.
+document.querySelectorAll("#endpointList .list-group-item").forEach(item => {
+    item.addEventListener("click", function() {
+        consoleMethod.textContent = this.getAttribute("data-method");
+        consolePath.value = this.getAttribute("data-pattern");
+    })
+})
+
+// Submitting the form sends the request to the API with the console token.
+consoleForm.addEventListener("submit", function(e) {
+    e.preventDefault();
+
+    const init = {
+        method: consoleMethod.textContent,
+        headers: { "Authorization": "Bearer " + consoleToken },
+    };
+
+    if (consoleFiles.files.length > 0) {
+        const formData = new FormData();
+        for (const file of consoleFiles.files) {
+            formData.append("file_uploads", file);
+        }
+        init.body = formData;
+    } else if (consoleBody.value.trim() !== "") {
+        init.headers["Content-Type"] = "application/json";
+        init.body = consoleBody.value;
+    }
+
+    consoleStatus.textContent = "...";
+    consoleResponse.textContent = "";
+
+    fetch(apiURL + consolePath.value, init)
+    .then(async resp => {
+        consoleStatus.textContent = resp.status + " " + resp.statusText;
+        consoleResponse.textContent = formatBody(await resp.text());
+    })
+    .catch(err => {
+        consoleStatus.textContent = "Error";
+        consoleResponse.textContent = err.toString();
+    })
+})
+
+/**
+ * Formats a response body. JSON bodies are indented, all other bodies are returned as is.
+ *
+ * @param text The response body.
+ * @returns The formatted response body.
+ */
+function formatBody(text) {
+    try {
+        return JSON.stringify(JSON.parse(text), null, 2);
+    } catch {
+        return text;
+    }
+}
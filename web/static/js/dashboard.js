@@ -0,0 +1,48 @@
+// The element the uploads per day chart is drawn in.
+const uploadChart = document.getElementById("uploadChart");
+
+// The URL the uploads per day series is requested from.
+const uploadStatsURL = document.getElementById("uploadStatsURL").getAttribute("data-url");
+
+loadUploadChart();
+
+/**
+ * Requests the uploads per day for the last 30 days and draws them as a bar chart. If the
+ * request fails, a message is displayed in place of the chart.
+ */
+async function loadUploadChart() {
+    try {
+        const response = await fetch(`${uploadStatsURL}?days=30`);
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data["error"]);
+        }
+
+        drawUploadChart(data["days"], data["time_zone"]);
+    } catch (error) {
+        uploadChart.textContent = "Uploads could not be loaded.";
+    }
+}
+
+/**
+ * Draws the days as a bar chart. Every bar is scaled relative to the day with the most
+ * uploads.
+ *
+ * @param {{date: string, count: number}[]} days The uploads per day, oldest first.
+ * @param {string} timeZone The time zone the days are in.
+ */
+function drawUploadChart(days, timeZone) {
+    const max = Math.max(1, ...days.map(d => d.count));
+
+    uploadChart.replaceChildren();
+    days.forEach(d => {
+        const bar = document.createElement("div");
+        bar.className = "bg-primary flex-fill";
+        bar.style.height = `${(d.count / max) * 100}%`;
+        bar.style.minHeight = "1px";
+        bar.title = `${d.date}: ${d.count} upload${d.count === 1 ? "" : "s"}`;
+        uploadChart.appendChild(bar);
+    });
+
+    uploadChart.setAttribute("aria-label", `Uploads per day for the last ${days.length} days (${timeZone})`);
+}
@@ -46,6 +46,7 @@ const tokenForm = document.getElementById("tokenForm");
 // Set the token forms submit event listener. The token form is posted to the server.
 tokenForm.addEventListener("submit", function(e) {
     e.preventDefault();
+    clearFieldErrors();
 
     const formData = new FormData(this);
 
@@ -97,12 +98,16 @@ tokenForm.addEventListener("submit", function(e) {
         let createdAtCell = row.insertCell(-1);
         let lastUsedCell = row.insertCell(-1);
         let expiresCell = row.insertCell(-1);
+        let allowedIPsCell = row.insertCell(-1);
+        let allowedOriginsCell = row.insertCell(-1);
         let buttonCell = row.insertCell(-1);
 
         // Set the content of the remaining cells.
         createdAtCell.innerHTML = formatTime(data["created_at"]);
         lastUsedCell.innerHTML = formatTime(data["last_used"]);
         expiresCell.innerHTML = formatTime(data["expires_at"]);
+        allowedIPsCell.textContent = formatAllowList(data["allowed_ips"]);
+        allowedOriginsCell.textContent = formatAllowList(data["allowed_origins"]);
         buttonCell.innerHTML = `
             <div class="dropdown" data-bs-toggle="dropdown">
                 <button class="btn p-0"><i class="bi bi-three-dots h3"></i></button>
@@ -113,6 +118,12 @@ tokenForm.addEventListener("submit", function(e) {
     })
     .catch(errData => {
         console.log(errData);
+
+        // Errors caused by a specific field are displayed next to the field.
+        if (errData.field && writeFieldError(errData.field, errData.error)) {
+            return;
+        }
+
         writeAlert(tokenModalAlertPlaceholder, errData.error, "danger");
     })
 });
@@ -139,24 +150,57 @@ document.getElementById("headerCancelTokenButton").addEventListener("click", res
  */
 function reset() {
     clearAlert(tokenModalAlertPlaceholder);
+    clearFieldErrors();
     tokenForm.reset();
     setDefaultExpiration();
 }
 
+/**
+ * Displays an error message next to a field in the token form.
+ *
+ * @param {string} field The name of the field that caused the error.
+ * @param {string} message The error message to display.
+ * @returns True if the field has a place to display errors, otherwise false.
+ */
+function writeFieldError(field, message) {
+    const feedback = tokenForm.querySelector(`.invalid-feedback[data-field="${field}"]`);
+    if (!feedback) {
+        return false;
+    }
+
+    feedback.textContent = message;
+    feedback.classList.add("d-block");
+    return true;
+}
+
+/**
+ * Clears all the field errors in the token form.
+ */
+function clearFieldErrors() {
+    tokenForm.querySelectorAll(".invalid-feedback").forEach(feedback => {
+        feedback.textContent = "";
+        feedback.classList.remove("d-block");
+    });
+}
+
 // Format all the times and set to local time zone.
 document.querySelectorAll(".time").forEach(function(e) {
     e.textContent = formatTime(e.textContent);
 })
 
 /**
- * Converts a date and time string into a local date and time string. If timeString is a zero
- * date, "Never" is returned. This should only be the case for the "Last Used" column in the
- * token table.
+ * Converts a date and time string into a local date and time string. If timeString is null
+ * or a zero date, "Never" is returned. This should only be the case for the "Last Used"
+ * column in the token table.
  * 
- * @param {string} timeString A date and time string to be converted to a local date and time.
+ * @param {?string} timeString A date and time string to be converted to a local date and time.
  * @returns The date and time string converted to the local time zone.
  */
 function formatTime(timeString) {
+    if (!timeString) {
+        return "Never";
+    }
+
     const date = new Date(timeString);
 
     // If the time is not set, default to "Never". This will only be the case for
@@ -168,6 +212,21 @@ function formatTime(timeString) {
     }
 }
 
+/**
+ * Converts the allowed IPs or origins of a token into a display string. If there are none,
+ * "Any" is returned.
+ *
+ * @param {string[]} allowed The CIDRs or origins the token may be used from.
+ * @returns The allowed values as a comma separated string.
+ */
+function formatAllowList(allowed) {
+    if (!allowed || allowed.length === 0) {
+        return "Any";
+    }
+
+    return allowed.join(", ");
+}
+
 // The token ID that the action should be executed for. When a action is chosen from
 // the action dropdown menu (three dots), it will be executed on behalf of this token ID.
 let tokenID;
@@ -226,6 +285,40 @@ function deleteToken(id) {
     }
 }
 
+// Set the confirmInvalidateTokensButton's click event listener to invalidate every token and
+// close the bootstrap modal.
+document.getElementById("confirmInvalidateTokensButton").addEventListener("click", function() {
+    const invalidateTokensModal = document.getElementById("invalidateTokensModal");
+    bootstrap.Modal.getInstance(invalidateTokensModal).hide();
+    invalidateTokens();
+});
+
+/**
+ * Invalidate every token of the user and remove all rows from the token table.
+ */
+function invalidateTokens() {
+    fetch("/tokens/invalidate", {
+        method: "POST",
+        headers: {
+            "X-Requested-With": "FetchAPI"
+        },
+        credentials: "include"
+    })
+    .then(resp => {
+        if (!resp.ok) {
+            return resp.json().then(errData => {
+                throw errData;
+            })
+        }
+
+        const tokenTableBody = document.getElementById("tokenTable").getElementsByTagName("tbody")[0];
+        tokenTableBody.replaceChildren();
+    })
+    .catch(errData => {
+        writeFlashError(errData.error);
+    })
+}
+
 /**
  * Returns the token resource url for the provided token id. The token resource url format is expected
  * to be stored in a script tag with an id 'tokenResourceURL' and an attribute 'data-url' that holds the